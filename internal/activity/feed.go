@@ -0,0 +1,182 @@
+package activity
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"generatio-pb/internal/notify"
+	"generatio-pb/internal/pagination"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Event types in the feed
+const (
+	TypeGeneration    = "generation"     // A generation finished or failed
+	TypePipeline      = "pipeline"       // A pipeline run was started
+	TypeFolderCreated = "folder.created" // The user created a folder
+	TypeFolderShared  = "folder.shared"  // Another user shared a folder with the user
+	TypeAlert         = "alert"          // Budget, spending, key health or security notice
+	TypeAccount       = "account"        // An administrator changed the user's budget or credit
+)
+
+// Types lists every event type
+var Types = []string{TypeGeneration, TypePipeline, TypeFolderCreated, TypeFolderShared, TypeAlert, TypeAccount}
+
+// Event is one entry of a user's activity feed
+type Event struct {
+	ID      string                 `json:"id"`
+	Type    string                 `json:"type"`
+	Title   string                 `json:"title"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+	Created time.Time              `json:"created"`
+
+	record *core.Record
+}
+
+// source reads one event type from the collection that records it
+type source struct {
+	eventType  string
+	collection string
+	filter     string
+	event      func(record *core.Record) Event
+}
+
+// sources assembles the feed from records other features already keep
+var sources = []source{
+	{
+		eventType:  TypeGeneration,
+		collection: "generation_jobs",
+		filter:     "user_id = {:user_id}",
+		event: func(record *core.Record) Event {
+			title := "Generated images with " + record.GetString("model")
+			if !record.GetBool("success") {
+				title = "Generation with " + record.GetString("model") + " failed"
+			}
+			return Event{Title: title, Data: map[string]interface{}{
+				"model":       record.GetString("model"),
+				"success":     record.GetBool("success"),
+				"duration_ms": record.GetInt("duration_ms"),
+			}}
+		},
+	},
+	{
+		eventType:  TypePipeline,
+		collection: "pipeline_runs",
+		filter:     "user_id = {:user_id}",
+		event: func(record *core.Record) Event {
+			return Event{Title: "Started a pipeline", Data: map[string]interface{}{
+				"pipeline_id": record.Id,
+				"status":      record.GetString("status"),
+			}}
+		},
+	},
+	{
+		eventType:  TypeFolderCreated,
+		collection: "folders",
+		filter:     "user_id = {:user_id}",
+		event: func(record *core.Record) Event {
+			return Event{Title: "Created folder " + record.GetString("name"), Data: map[string]interface{}{
+				"folder_id": record.Id,
+				"org_id":    record.GetString("org_id"),
+				"deleted":   !record.GetDateTime("deleted_at").IsZero(),
+			}}
+		},
+	},
+	{
+		eventType:  TypeFolderShared,
+		collection: "folder_permissions",
+		filter:     "user_id = {:user_id}",
+		event: func(record *core.Record) Event {
+			return Event{Title: "A folder was shared with you", Data: map[string]interface{}{
+				"folder_id": record.GetString("folder_id"),
+				"role":      record.GetString("role"),
+			}}
+		},
+	},
+	{
+		eventType:  TypeAlert,
+		collection: notify.OutboxCollection,
+		// Alerts go out by email; other channels would repeat them
+		filter: "user_id = {:user_id} && channel = '" + notify.ChannelEmail + "' && (event = '" + notify.EventBudgetAlert +
+			"' || event = '" + notify.EventSpendingAnomaly + "' || event = '" + notify.EventKeyHealth + "' || event = '" + notify.EventSecurityNotice + "')",
+		event: func(record *core.Record) Event {
+			return Event{Title: record.GetString("subject"), Data: map[string]interface{}{
+				"event": record.GetString("event"),
+			}}
+		},
+	},
+	{
+		eventType:  TypeAccount,
+		collection: "audit_log",
+		filter:     "target_id = {:user_id}",
+		event: func(record *core.Record) Event {
+			return Event{Title: "An administrator changed your account", Data: map[string]interface{}{
+				"action": record.GetString("action"),
+				"reason": record.GetString("reason"),
+			}}
+		},
+	},
+}
+
+// Feed merges a user's recent activity from generations, pipelines, folders,
+// shares, alerts and the audit log, newest first
+type Feed struct {
+	app core.App
+}
+
+// NewFeed creates an activity feed
+func NewFeed(app core.App) *Feed {
+	return &Feed{app: app}
+}
+
+// List returns the first page of the user's events of the given types (all
+// types when empty), or the page after page.Cursor, and the cursor of the
+// next page, which is empty on the last. Page numbers are ignored because
+// events come from several collections.
+func (f *Feed) List(userID string, types []string, page pagination.Page) ([]Event, string, error) {
+	wanted := make(map[string]bool, len(types))
+	for _, eventType := range types {
+		wanted[eventType] = true
+	}
+
+	events := make([]Event, 0)
+	for _, src := range sources {
+		if len(wanted) > 0 && !wanted[src.eventType] {
+			continue
+		}
+		// Collections a deployment does not have contribute nothing
+		if _, err := f.app.FindCollectionByNameOrId(src.collection); err != nil {
+			continue
+		}
+
+		// Each source returns at most a page, so the merged page is complete
+		records, _, err := pagination.Find(f.app, src.collection, src.filter, map[string]any{"user_id": userID}, pagination.Page{Limit: page.Limit, Cursor: page.Cursor})
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to fetch %s activity: %w", src.eventType, err)
+		}
+		for _, record := range records {
+			event := src.event(record)
+			event.ID = record.Id
+			event.Type = src.eventType
+			event.Created = record.GetDateTime("created").Time()
+			event.record = record
+			events = append(events, event)
+		}
+	}
+
+	// Same ordering as pagination.Find: created, then id, both descending
+	sort.Slice(events, func(i, j int) bool {
+		if !events[i].Created.Equal(events[j].Created) {
+			return events[i].Created.After(events[j].Created)
+		}
+		return events[i].ID > events[j].ID
+	})
+
+	if len(events) <= page.Limit {
+		return events, "", nil
+	}
+	events = events[:page.Limit]
+	return events, pagination.CursorFor(events[len(events)-1].record).Encode(), nil
+}
@@ -0,0 +1,257 @@
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"generatio-pb/internal/money"
+	"generatio-pb/internal/notify"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Collection records the spending spikes that were flagged
+const Collection = "spending_anomalies"
+
+// Detection windows: the last day is compared with the 30 days before it
+const (
+	Window       = 24 * time.Hour
+	BaselineDays = 30
+)
+
+// Anomaly is a flagged spending spike. The USD fields repeat the
+// micro-dollar amounts for older readers.
+type Anomaly struct {
+	ID                 string       `json:"id"`
+	UserID             string       `json:"user_id"`
+	Spent              float64      `json:"spent"`         // USD spent in the 24 hours before Created
+	SpentMicros        money.Micros `json:"spent_micros"`  // the same in micro-dollars
+	DailyAverage       float64      `json:"daily_average"` // USD per day over the 30 days before that
+	DailyAverageMicros money.Micros `json:"daily_average_micros"`
+	Created            time.Time    `json:"created"`
+}
+
+// Report summarises a detection run
+type Report struct {
+	UsersScanned int `json:"users_scanned"`
+	Flagged      int `json:"flagged"`
+}
+
+// Detector flags users whose spending over the last day exceeds factor times
+// their 30-day daily average and emails them, at most once per day. Spending
+// below minSpend is never flagged, so new accounts without a baseline are
+// only flagged once they spend a meaningful amount.
+type Detector struct {
+	app      core.App
+	notifier *notify.Service
+	factor   float64
+	minSpend money.Micros
+	interval time.Duration
+
+	runMutex sync.Mutex
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// NewDetector creates a detector running every interval; a non-positive
+// interval disables scheduled runs. minSpend is in USD.
+func NewDetector(app core.App, notifier *notify.Service, factor, minSpend float64, interval time.Duration) *Detector {
+	if factor <= 1 {
+		factor = 5
+	}
+	return &Detector{
+		app:      app,
+		notifier: notifier,
+		factor:   factor,
+		minSpend: money.FromUSD(minSpend),
+		interval: interval,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start begins the scheduled detection loop
+func (d *Detector) Start() {
+	if d.interval <= 0 {
+		return
+	}
+	go d.run()
+	log.Printf("Spending anomaly detection started with interval: %v", d.interval)
+}
+
+// Stop stops the scheduled detection loop
+func (d *Detector) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.stopChan)
+	})
+}
+
+func (d *Detector) run() {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			report, err := d.Run(context.Background())
+			if err != nil {
+				log.Printf("Spending anomaly detection failed: %v", err)
+			} else if report.Flagged > 0 {
+				log.Printf("Spending anomaly detection flagged %d users", report.Flagged)
+			}
+		case <-d.stopChan:
+			return
+		}
+	}
+}
+
+// Run checks every user who spent anything in the last day
+func (d *Detector) Run(ctx context.Context) (*Report, error) {
+	d.runMutex.Lock()
+	defer d.runMutex.Unlock()
+
+	now := time.Now().UTC()
+	windowStart := now.Add(-Window)
+	baselineStart := windowStart.AddDate(0, 0, -BaselineDays)
+
+	recent, err := d.spendByUser(windowStart, now)
+	if err != nil {
+		return nil, err
+	}
+	baseline, err := d.spendByUser(baselineStart, windowStart)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{}
+	userIDs := make([]string, 0, len(recent))
+	for userID := range recent {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Strings(userIDs)
+
+	for _, userID := range userIDs {
+		report.UsersScanned++
+
+		spent := recent[userID]
+		average := baseline[userID] / BaselineDays
+		if spent < d.minSpend || spent <= money.Micros(math.Round(float64(average)*d.factor)) {
+			continue
+		}
+		if d.flaggedSince(userID, windowStart) {
+			continue
+		}
+
+		if err := d.flag(userID, spent, average); err != nil {
+			d.app.Logger().Error("Failed to flag spending anomaly", "error", err, "user_id", userID)
+			continue
+		}
+		report.Flagged++
+	}
+
+	return report, nil
+}
+
+// spendByUser sums each user's image costs created in [from, to). The
+// database does the summing, so a month of images is never loaded at once.
+// Trashed images are left out, as everywhere else images are read.
+func (d *Detector) spendByUser(from, to time.Time) (map[string]money.Micros, error) {
+	var rows []struct {
+		UserID string       `db:"user_id"`
+		Spent  money.Micros `db:"spent"`
+	}
+	err := d.app.DB().
+		Select("user_id", "SUM("+money.CostSQL("other_info")+") AS spent").
+		From("images").
+		Where(dbx.NewExp("[[created]] >= {:from} AND [[created]] < {:to} AND ([[deleted_at]] IS NULL OR [[deleted_at]] = '')", dbx.Params{
+			"from": from.Format(types.DefaultDateLayout),
+			"to":   to.Format(types.DefaultDateLayout),
+		})).
+		GroupBy("user_id").
+		All(&rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum image costs: %w", err)
+	}
+
+	spent := make(map[string]money.Micros, len(rows))
+	for _, row := range rows {
+		spent[row.UserID] = row.Spent
+	}
+	return spent, nil
+}
+
+// List returns a user's flagged anomalies, newest first
+func (d *Detector) List(userID string, limit int) ([]Anomaly, error) {
+	records, err := d.app.FindRecordsByFilter(Collection, "user_id = {:user_id}", "-created", limit, 0, map[string]any{"user_id": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch anomalies: %w", err)
+	}
+
+	anomalies := make([]Anomaly, 0, len(records))
+	for _, record := range records {
+		// The amounts are stored in USD; rounding them back to micro-dollars is exact
+		spent := money.FromUSD(record.GetFloat("spent"))
+		average := money.FromUSD(record.GetFloat("daily_average"))
+		anomalies = append(anomalies, Anomaly{
+			ID:                 record.Id,
+			UserID:             record.GetString("user_id"),
+			Spent:              spent.USD(),
+			SpentMicros:        spent,
+			DailyAverage:       average.USD(),
+			DailyAverageMicros: average,
+			Created:            record.GetDateTime("created").Time(),
+		})
+	}
+	return anomalies, nil
+}
+
+// flaggedSince reports whether the user was already flagged after since
+func (d *Detector) flaggedSince(userID string, since time.Time) bool {
+	_, err := d.app.FindFirstRecordByFilter(Collection, "user_id = {:user_id} && created >= {:since}", map[string]any{
+		"user_id": userID,
+		"since":   since.Format(types.DefaultDateLayout),
+	})
+	return err == nil
+}
+
+// flag records the anomaly and emails the user
+func (d *Detector) flag(userID string, spent, average money.Micros) error {
+	collection, err := d.app.FindCollectionByNameOrId(Collection)
+	if err != nil {
+		return fmt.Errorf("failed to find anomalies collection: %w", err)
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("user_id", userID)
+	record.Set("spent", spent.USD())
+	record.Set("daily_average", average.USD())
+	if err := d.app.Save(record); err != nil {
+		return fmt.Errorf("failed to save anomaly: %w", err)
+	}
+
+	user, err := d.app.FindRecordById("generatio_users", userID)
+	if err != nil || user.Email() == "" {
+		return nil
+	}
+
+	body := fmt.Sprintf("You spent $%.2f on image generation in the last 24 hours, more than %.0f times your daily average of $%.2f over the previous %d days.\n\n"+
+		"If this wasn't you, sign out of all sessions and replace your FAL token.", spent.USD(), d.factor, average.USD(), BaselineDays)
+	err = d.notifier.Enqueue(notify.Message{
+		UserID:  userID,
+		Channel: notify.ChannelEmail,
+		Target:  user.Email(),
+		Event:   notify.EventSpendingAnomaly,
+		Subject: "Unusual spending on your account",
+		Body:    body,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to queue anomaly notice: %w", err)
+	}
+	return nil
+}
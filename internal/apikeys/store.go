@@ -0,0 +1,199 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Collection stores hashed API keys; the plaintext key is only shown once on creation
+const Collection = "api_keys"
+
+// KeyPrefix marks a string as a Generatio API key
+const KeyPrefix = "gpk_"
+
+// Scopes an API key can be granted. Requests authenticated with a key can
+// only reach routes that require one of its scopes.
+const (
+	ScopeImagesRead    = "images:read"
+	ScopeGenerateWrite = "generate:write"
+	ScopeFinancialRead = "financial:read"
+	ScopeHooksWrite    = "hooks:write"
+)
+
+// AllScopes lists every scope in display order
+var AllScopes = []string{ScopeImagesRead, ScopeGenerateWrite, ScopeFinancialRead, ScopeHooksWrite}
+
+// MaxKeysPerUser caps how many keys a user can hold at once
+const MaxKeysPerUser = 20
+
+// lastUsedInterval throttles last_used_at writes for busy keys
+const lastUsedInterval = time.Minute
+
+// Key describes a stored API key without its secret
+type Key struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	UserID     string     `json:"-"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Created    time.Time  `json:"created"`
+}
+
+// HasScope reports whether the key was granted scope
+func (k *Key) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Store creates, authenticates and revokes API keys
+type Store struct {
+	app core.App
+}
+
+// NewStore creates a store backed by the api_keys collection
+func NewStore(app core.App) *Store {
+	return &Store{app: app}
+}
+
+// ValidScope reports whether scope is a known scope
+func ValidScope(scope string) bool {
+	for _, s := range AllScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Create issues a new key for a user and returns the plaintext key once
+func (s *Store) Create(userID, name string, scopes []string) (string, *Key, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, fmt.Errorf("name is required")
+	}
+	if len(name) > 100 {
+		return "", nil, fmt.Errorf("name cannot exceed 100 characters")
+	}
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !ValidScope(scope) {
+			return "", nil, fmt.Errorf("unknown scope %q (valid: %s)", scope, strings.Join(AllScopes, ", "))
+		}
+	}
+
+	existing, err := s.List(userID)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(existing) >= MaxKeysPerUser {
+		return "", nil, fmt.Errorf("a user can hold at most %d API keys", MaxKeysPerUser)
+	}
+
+	collection, err := s.app.FindCollectionByNameOrId(Collection)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to find api_keys collection: %w", err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	plaintext := KeyPrefix + hex.EncodeToString(secret)
+
+	record := core.NewRecord(collection)
+	record.Set("user_id", userID)
+	record.Set("name", name)
+	record.Set("key_hash", hashKey(plaintext))
+	record.Set("prefix", plaintext[:len(KeyPrefix)+8])
+	record.Set("scopes", scopes)
+
+	if err := s.app.Save(record); err != nil {
+		return "", nil, fmt.Errorf("failed to save key: %w", err)
+	}
+
+	return plaintext, keyFromRecord(record), nil
+}
+
+// List returns a user's keys, newest first
+func (s *Store) List(userID string) ([]*Key, error) {
+	records, err := s.app.FindRecordsByFilter(Collection, "user_id = {:user_id}", "-created", 0, 0, map[string]any{"user_id": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch keys: %w", err)
+	}
+
+	keys := make([]*Key, 0, len(records))
+	for _, record := range records {
+		keys = append(keys, keyFromRecord(record))
+	}
+	return keys, nil
+}
+
+// Revoke deletes one of a user's keys
+func (s *Store) Revoke(userID, id string) error {
+	record, err := s.app.FindRecordById(Collection, id)
+	if err != nil || record.GetString("user_id") != userID {
+		return fmt.Errorf("key not found")
+	}
+
+	if err := s.app.Delete(record); err != nil {
+		return fmt.Errorf("failed to delete key: %w", err)
+	}
+	return nil
+}
+
+// Authenticate resolves a plaintext key to its stored record
+func (s *Store) Authenticate(plaintext string) (*Key, error) {
+	if !strings.HasPrefix(plaintext, KeyPrefix) {
+		return nil, fmt.Errorf("invalid API key")
+	}
+
+	record, err := s.app.FindFirstRecordByData(Collection, "key_hash", hashKey(plaintext))
+	if err != nil {
+		return nil, fmt.Errorf("invalid API key")
+	}
+
+	if time.Since(record.GetDateTime("last_used_at").Time()) > lastUsedInterval {
+		record.Set("last_used_at", types.NowDateTime())
+		if err := s.app.Save(record); err != nil {
+			s.app.Logger().Warn("Failed to record API key use", "error", err, "key_id", record.Id)
+		}
+	}
+
+	return keyFromRecord(record), nil
+}
+
+func keyFromRecord(record *core.Record) *Key {
+	key := &Key{
+		ID:      record.Id,
+		Name:    record.GetString("name"),
+		Prefix:  record.GetString("prefix"),
+		Scopes:  record.GetStringSlice("scopes"),
+		UserID:  record.GetString("user_id"),
+		Created: record.GetDateTime("created").Time(),
+	}
+	if lastUsed := record.GetDateTime("last_used_at"); !lastUsed.IsZero() {
+		t := lastUsed.Time()
+		key.LastUsedAt = &t
+	}
+	return key
+}
+
+// hashKey stores keys as SHA-256 digests; they are high-entropy so no salt is needed
+func hashKey(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
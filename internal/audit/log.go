@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Collection stores audit entries
+const Collection = "audit_log"
+
+// Actions recorded in the audit log
+const (
+	ActionCreditGranted = "budget.credit_granted"
+	ActionBudgetChanged = "budget.changed"
+	ActionQuotaReset    = "budget.quota_reset"
+)
+
+// Entry is an administrative change to a user's account
+type Entry struct {
+	ID       string                 `json:"id"`
+	ActorID  string                 `json:"actor_id"`
+	Action   string                 `json:"action"`
+	TargetID string                 `json:"target_id"`
+	Reason   string                 `json:"reason,omitempty"`
+	Details  map[string]interface{} `json:"details,omitempty"`
+	Created  time.Time              `json:"created"`
+}
+
+// Log appends and lists audit entries. Entries are never updated or deleted.
+type Log struct {
+	app core.App
+}
+
+// NewLog creates an audit log
+func NewLog(app core.App) *Log {
+	return &Log{app: app}
+}
+
+// Record appends an entry
+func (l *Log) Record(entry Entry) error {
+	collection, err := l.app.FindCollectionByNameOrId(Collection)
+	if err != nil {
+		return fmt.Errorf("failed to find audit collection: %w", err)
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("actor_id", entry.ActorID)
+	record.Set("action", entry.Action)
+	record.Set("target_id", entry.TargetID)
+	record.Set("reason", entry.Reason)
+	record.Set("details", entry.Details)
+	if err := l.app.Save(record); err != nil {
+		return fmt.Errorf("failed to save audit entry: %w", err)
+	}
+	return nil
+}
+
+// List returns entries newest first, only those about targetID when it is set
+func (l *Log) List(targetID string, limit int) ([]Entry, error) {
+	filter := ""
+	params := map[string]any{}
+	if targetID != "" {
+		filter = "target_id = {:target_id}"
+		params["target_id"] = targetID
+	}
+
+	records, err := l.app.FindRecordsByFilter(Collection, filter, "-created", limit, 0, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch audit entries: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(records))
+	for _, record := range records {
+		entry := Entry{
+			ID:       record.Id,
+			ActorID:  record.GetString("actor_id"),
+			Action:   record.GetString("action"),
+			TargetID: record.GetString("target_id"),
+			Reason:   record.GetString("reason"),
+			Created:  record.GetDateTime("created").Time(),
+		}
+		record.UnmarshalJSONField("details", &entry.Details)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
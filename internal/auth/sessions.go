@@ -1,29 +1,184 @@
 package auth
 
 import (
+	"container/list"
+	"crypto/hmac"
 	"crypto/rand"
 	"fmt"
+	"hash/fnv"
+	"maps"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"generatio-pb/internal/crypto"
 	"generatio-pb/internal/models"
 
 	"github.com/google/uuid"
 )
 
+// sessionShardCount is how many independently locked maps sessions are spread
+// over, so concurrent requests for different sessions rarely wait on each other
+const sessionShardCount = 32
+
+// sessionShard is one slice of the store guarded by its own lock
+type sessionShard struct {
+	mutex    sync.RWMutex
+	sessions map[string]*models.Session
+
+	// recent orders the shard's sessions by last use, most recent first
+	recent   *list.List
+	elements map[string]*list.Element
+}
+
+// recentUse is an entry of a shard's recency list
+type recentUse struct {
+	sessionID string
+	usedAt    time.Time
+}
+
+func newSessionShard() *sessionShard {
+	return &sessionShard{
+		sessions: make(map[string]*models.Session),
+		recent:   list.New(),
+		elements: make(map[string]*list.Element),
+	}
+}
+
+// remove drops sessionID from the shard; the caller holds the write lock
+func (sh *sessionShard) remove(sessionID string) {
+	if session, exists := sh.sessions[sessionID]; exists {
+		// Clear sensitive data before deletion
+		session.Clear()
+		delete(sh.sessions, sessionID)
+	}
+	if element, exists := sh.elements[sessionID]; exists {
+		sh.recent.Remove(element)
+		delete(sh.elements, sessionID)
+	}
+}
+
 // SessionStore manages in-memory user sessions
 type SessionStore struct {
-	sessions map[string]*models.Session
-	mutex    sync.RWMutex
-	timeout  time.Duration
+	shards  [sessionShardCount]*sessionShard
+	timeout time.Duration
+
+	// count tracks the sessions in all shards; past maxSessions the least
+	// recently used session is evicted
+	count       atomic.Int64
+	maxSessions atomic.Int64
+	evictions   atomic.Uint64
+
+	// byUser indexes session IDs by user so per-user lookups skip the shards.
+	// userMutex is never held together with a shard lock.
+	byUser    map[string]map[string]struct{}
+	userMutex sync.RWMutex
 }
 
 // NewSessionStore creates a new session store with the specified timeout
 func NewSessionStore(timeout time.Duration) *SessionStore {
-	return &SessionStore{
-		sessions: make(map[string]*models.Session),
-		timeout:  timeout,
+	s := &SessionStore{timeout: timeout, byUser: make(map[string]map[string]struct{})}
+	for i := range s.shards {
+		s.shards[i] = newSessionShard()
 	}
+	return s
+}
+
+// SetMaxSessions caps how many sessions the store holds (0 removes the cap).
+// Creating a session beyond the cap evicts the least recently used one, so
+// floods of new sessions cannot exhaust memory.
+func (s *SessionStore) SetMaxSessions(max int) {
+	s.maxSessions.Store(int64(max))
+	s.evictOverflow()
+}
+
+// evictOverflow removes least recently used sessions until the store is
+// within its cap. The oldest session overall is the oldest of the shard tails.
+func (s *SessionStore) evictOverflow() {
+	for {
+		max := s.maxSessions.Load()
+		if max <= 0 || s.count.Load() <= max {
+			return
+		}
+
+		var oldest *sessionShard
+		var oldestUse time.Time
+		for _, shard := range s.shards {
+			shard.mutex.RLock()
+			if tail := shard.recent.Back(); tail != nil {
+				if usedAt := tail.Value.(*recentUse).usedAt; oldest == nil || usedAt.Before(oldestUse) {
+					oldest, oldestUse = shard, usedAt
+				}
+			}
+			shard.mutex.RUnlock()
+		}
+		if oldest == nil {
+			return
+		}
+
+		oldest.mutex.Lock()
+		tail := oldest.recent.Back()
+		if tail == nil {
+			oldest.mutex.Unlock()
+			continue
+		}
+		sessionID := tail.Value.(*recentUse).sessionID
+		userID := ""
+		if session, exists := oldest.sessions[sessionID]; exists {
+			userID = session.UserID
+		}
+		oldest.remove(sessionID)
+		oldest.mutex.Unlock()
+
+		s.count.Add(-1)
+		s.evictions.Add(1)
+		s.unindex(userID, sessionID)
+	}
+}
+
+// shard returns the shard holding sessionID
+func (s *SessionStore) shard(sessionID string) *sessionShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(sessionID))
+	return s.shards[hash.Sum32()%sessionShardCount]
+}
+
+// index records that sessionID belongs to userID
+func (s *SessionStore) index(userID, sessionID string) {
+	s.userMutex.Lock()
+	defer s.userMutex.Unlock()
+
+	ids, exists := s.byUser[userID]
+	if !exists {
+		ids = make(map[string]struct{})
+		s.byUser[userID] = ids
+	}
+	ids[sessionID] = struct{}{}
+}
+
+// unindex forgets that sessionID belongs to userID
+func (s *SessionStore) unindex(userID, sessionID string) {
+	s.userMutex.Lock()
+	defer s.userMutex.Unlock()
+
+	if ids, exists := s.byUser[userID]; exists {
+		delete(ids, sessionID)
+		if len(ids) == 0 {
+			delete(s.byUser, userID)
+		}
+	}
+}
+
+// userSessionIDs returns the IDs of a user's sessions
+func (s *SessionStore) userSessionIDs(userID string) []string {
+	s.userMutex.RLock()
+	defer s.userMutex.RUnlock()
+
+	ids := make([]string, 0, len(s.byUser[userID]))
+	for sessionID := range s.byUser[userID] {
+		ids = append(ids, sessionID)
+	}
+	return ids
 }
 
 // Create creates a new session for the user with their decrypted FAL token
@@ -47,30 +202,100 @@ func (s *SessionStore) Create(userID, falToken string) (string, error) {
 		ExpiresAt: time.Now().Add(s.timeout),
 	}
 
-	// Store session
-	s.mutex.Lock()
-	s.sessions[sessionID] = session
-	s.mutex.Unlock()
+	// Store session; indexing it first means a concurrent Delete always finds
+	// the index entry it has to remove
+	s.index(userID, sessionID)
+	shard := s.shard(sessionID)
+	shard.mutex.Lock()
+	shard.sessions[sessionID] = session
+	shard.elements[sessionID] = shard.recent.PushFront(&recentUse{sessionID: sessionID, usedAt: session.CreatedAt})
+	shard.mutex.Unlock()
+	s.count.Add(1)
+	s.evictOverflow()
 
 	return sessionID, nil
 }
 
-// Get retrieves a session by ID
+// SetTestingKey gives a session the user's testing key and the key slot its
+// requests use by default
+func (s *SessionStore) SetTestingKey(sessionID, testingToken, environment string) error {
+	shard := s.shard(sessionID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	session, exists := shard.sessions[sessionID]
+	if !exists {
+		return fmt.Errorf("session not found")
+	}
+	session.TestingFALToken = testingToken
+	session.Environment = environment
+	return nil
+}
+
+// CacheDerivedKey keeps a key derived from the session's password for the
+// rest of the session, so DerivedKey can hand it out again
+func (s *SessionStore) CacheDerivedKey(sessionID, salt, password string, key []byte) error {
+	shard := s.shard(sessionID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	session, exists := shard.sessions[sessionID]
+	if !exists {
+		return fmt.Errorf("session not found")
+	}
+	if session.DerivedKeys == nil {
+		session.DerivedKeys = make(map[string]models.DerivedKey)
+	}
+	session.DerivedKeys[salt] = models.DerivedKey{
+		Key:   append([]byte(nil), key...),
+		Check: crypto.PasswordCheck(key, password),
+	}
+	return nil
+}
+
+// DerivedKey returns the key for salt cached by an unexpired session of the
+// user, provided the session was created with the same password
+func (s *SessionStore) DerivedKey(userID, salt, password string) ([]byte, bool) {
+	for _, sessionID := range s.userSessionIDs(userID) {
+		shard := s.shard(sessionID)
+		shard.mutex.RLock()
+		session, exists := shard.sessions[sessionID]
+		if !exists || session.IsExpired() {
+			shard.mutex.RUnlock()
+			continue
+		}
+		derived, cached := session.DerivedKeys[salt]
+		key := append([]byte(nil), derived.Key...)
+		shard.mutex.RUnlock()
+
+		if cached && hmac.Equal(crypto.PasswordCheck(key, password), derived.Check) {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// Get retrieves a copy of an unexpired session by ID; changes to the copy
+// are not stored
 func (s *SessionStore) Get(sessionID string) (*models.Session, error) {
 	if sessionID == "" {
 		return nil, fmt.Errorf("session ID cannot be empty")
 	}
 
-	s.mutex.RLock()
-	session, exists := s.sessions[sessionID]
-	s.mutex.RUnlock()
+	shard := s.shard(sessionID)
+	shard.mutex.RLock()
+	stored, exists := shard.sessions[sessionID]
+	var session *models.Session
+	if exists && !stored.IsExpired() {
+		session = snapshot(stored)
+	}
+	shard.mutex.RUnlock()
 
 	if !exists {
 		return nil, fmt.Errorf("session not found")
 	}
 
-	// Check if session has expired
-	if session.IsExpired() {
+	if session == nil {
 		// Remove expired session
 		s.Delete(sessionID)
 		return nil, fmt.Errorf("session expired")
@@ -79,36 +304,87 @@ func (s *SessionStore) Get(sessionID string) (*models.Session, error) {
 	return session, nil
 }
 
+// snapshot copies a session while the caller holds its shard lock. Touch and
+// ExtendSession update stored sessions in place, so callers outside the lock
+// only ever see copies.
+func snapshot(session *models.Session) *models.Session {
+	copied := *session
+	copied.DerivedKeys = maps.Clone(session.DerivedKeys)
+	return &copied
+}
+
+// Touch records that a request used the session, which also makes it the
+// last candidate for eviction
+func (s *SessionStore) Touch(sessionID string) {
+	shard := s.shard(sessionID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	if session, exists := shard.sessions[sessionID]; exists {
+		session.LastUsedAt = time.Now()
+		if element, exists := shard.elements[sessionID]; exists {
+			element.Value.(*recentUse).usedAt = session.LastUsedAt
+			shard.recent.MoveToFront(element)
+		}
+	}
+}
+
+// Describe returns a copy of an unexpired session with its FAL token removed
+func (s *SessionStore) Describe(sessionID string) (models.Session, error) {
+	shard := s.shard(sessionID)
+	shard.mutex.RLock()
+	defer shard.mutex.RUnlock()
+
+	session, exists := shard.sessions[sessionID]
+	if !exists || session.IsExpired() {
+		return models.Session{}, fmt.Errorf("session not found")
+	}
+
+	described := *session
+	described.FALToken = ""
+	described.DerivedKeys = nil
+	return described, nil
+}
+
 // Delete removes a session by ID
 func (s *SessionStore) Delete(sessionID string) error {
 	if sessionID == "" {
 		return fmt.Errorf("session ID cannot be empty")
 	}
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	session, exists := s.sessions[sessionID]
-	if exists {
-		// Clear sensitive data before deletion
-		session.Clear()
-		delete(s.sessions, sessionID)
+	shard := s.shard(sessionID)
+	shard.mutex.Lock()
+	session, exists := shard.sessions[sessionID]
+	if !exists {
+		shard.mutex.Unlock()
+		return nil
 	}
 
+	userID := session.UserID
+	shard.remove(sessionID)
+	shard.mutex.Unlock()
+
+	s.count.Add(-1)
+	s.unindex(userID, sessionID)
 	return nil
 }
 
-// GetUserSession retrieves the active session for a user (if any)
+// GetUserSession retrieves a copy of the active session for a user (if any)
 func (s *SessionStore) GetUserSession(userID string) (*models.Session, error) {
 	if userID == "" {
 		return nil, fmt.Errorf("user ID cannot be empty")
 	}
 
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
+	for _, sessionID := range s.userSessionIDs(userID) {
+		shard := s.shard(sessionID)
+		shard.mutex.RLock()
+		var session *models.Session
+		if stored, exists := shard.sessions[sessionID]; exists && !stored.IsExpired() {
+			session = snapshot(stored)
+		}
+		shard.mutex.RUnlock()
 
-	for _, session := range s.sessions {
-		if session.UserID == userID && !session.IsExpired() {
+		if session != nil {
 			return session, nil
 		}
 	}
@@ -122,19 +398,8 @@ func (s *SessionStore) DeleteUserSessions(userID string) error {
 		return fmt.Errorf("user ID cannot be empty")
 	}
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
-	var toDelete []string
-	for sessionID, session := range s.sessions {
-		if session.UserID == userID {
-			session.Clear()
-			toDelete = append(toDelete, sessionID)
-		}
-	}
-
-	for _, sessionID := range toDelete {
-		delete(s.sessions, sessionID)
+	for _, sessionID := range s.userSessionIDs(userID) {
+		s.Delete(sessionID)
 	}
 
 	return nil
@@ -142,21 +407,24 @@ func (s *SessionStore) DeleteUserSessions(userID string) error {
 
 // Cleanup removes expired sessions from memory
 func (s *SessionStore) Cleanup() {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
-
 	now := time.Now()
-	var toDelete []string
 
-	for sessionID, session := range s.sessions {
-		if now.After(session.ExpiresAt) {
-			session.Clear()
-			toDelete = append(toDelete, sessionID)
+	for _, shard := range s.shards {
+		removed := make(map[string]string)
+
+		shard.mutex.Lock()
+		for sessionID, session := range shard.sessions {
+			if now.After(session.ExpiresAt) {
+				removed[sessionID] = session.UserID
+				shard.remove(sessionID)
+			}
 		}
-	}
+		shard.mutex.Unlock()
 
-	for _, sessionID := range toDelete {
-		delete(s.sessions, sessionID)
+		s.count.Add(-int64(len(removed)))
+		for sessionID, userID := range removed {
+			s.unindex(userID, sessionID)
+		}
 	}
 }
 
@@ -174,22 +442,23 @@ func (s *SessionStore) StartCleanup(interval time.Duration) {
 
 // Stats returns statistics about the session store
 func (s *SessionStore) Stats() SessionStats {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-
 	stats := SessionStats{
-		TotalSessions: len(s.sessions),
-		ActiveSessions: 0,
-		ExpiredSessions: 0,
+		Capacity:  int(s.maxSessions.Load()),
+		Evictions: s.evictions.Load(),
 	}
 
 	now := time.Now()
-	for _, session := range s.sessions {
-		if now.After(session.ExpiresAt) {
-			stats.ExpiredSessions++
-		} else {
-			stats.ActiveSessions++
+	for _, shard := range s.shards {
+		shard.mutex.RLock()
+		stats.TotalSessions += len(shard.sessions)
+		for _, session := range shard.sessions {
+			if now.After(session.ExpiresAt) {
+				stats.ExpiredSessions++
+			} else {
+				stats.ActiveSessions++
+			}
 		}
+		shard.mutex.RUnlock()
 	}
 
 	return stats
@@ -200,6 +469,11 @@ type SessionStats struct {
 	TotalSessions   int `json:"total_sessions"`
 	ActiveSessions  int `json:"active_sessions"`
 	ExpiredSessions int `json:"expired_sessions"`
+
+	// Capacity is the session cap (0 when uncapped) and Evictions how many
+	// sessions were dropped to stay under it since startup
+	Capacity  int    `json:"capacity"`
+	Evictions uint64 `json:"evictions"`
 }
 
 // ExtendSession extends the expiration time of a session
@@ -208,10 +482,11 @@ func (s *SessionStore) ExtendSession(sessionID string) error {
 		return fmt.Errorf("session ID cannot be empty")
 	}
 
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	shard := s.shard(sessionID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
 
-	session, exists := s.sessions[sessionID]
+	session, exists := shard.sessions[sessionID]
 	if !exists {
 		return fmt.Errorf("session not found")
 	}
@@ -225,25 +500,46 @@ func (s *SessionStore) ExtendSession(sessionID string) error {
 	return nil
 }
 
+// SetExpiry moves a session's expiration time, which may be in the past
+func (s *SessionStore) SetExpiry(sessionID string, expiresAt time.Time) error {
+	shard := s.shard(sessionID)
+	shard.mutex.Lock()
+	defer shard.mutex.Unlock()
+
+	session, exists := shard.sessions[sessionID]
+	if !exists {
+		return fmt.Errorf("session not found")
+	}
+
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
 // Clear removes all sessions from the store
 func (s *SessionStore) Clear() {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	for _, shard := range s.shards {
+		shard.mutex.Lock()
 
-	// Clear sensitive data from all sessions
-	for _, session := range s.sessions {
-		session.Clear()
+		// Clear sensitive data from all sessions
+		for _, session := range shard.sessions {
+			session.Clear()
+		}
+		s.count.Add(-int64(len(shard.sessions)))
+		shard.sessions = make(map[string]*models.Session)
+		shard.recent.Init()
+		shard.elements = make(map[string]*list.Element)
+
+		shard.mutex.Unlock()
 	}
 
-	// Clear the map
-	s.sessions = make(map[string]*models.Session)
+	s.userMutex.Lock()
+	s.byUser = make(map[string]map[string]struct{})
+	s.userMutex.Unlock()
 }
 
 // GetSessionCount returns the current number of sessions
 func (s *SessionStore) GetSessionCount() int {
-	s.mutex.RLock()
-	defer s.mutex.RUnlock()
-	return len(s.sessions)
+	return int(s.count.Load())
 }
 
 // ValidateSession checks if a session exists and is valid
@@ -273,4 +569,4 @@ func generateSecureID() (string, error) {
 		return "", err
 	}
 	return fmt.Sprintf("%x", bytes), nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// verification is a key derived from a user's password, kept briefly
+type verification struct {
+	userID    string
+	key       []byte
+	expiresAt time.Time
+}
+
+// VerificationCache briefly remembers keys derived while verifying a user's
+// password, so the login sequence of a token verify followed by a session
+// creation derives each key once. Entries are keyed by user, salt and a
+// keyed hash of the password; the password itself is never stored.
+type VerificationCache struct {
+	ttl    time.Duration
+	secret []byte
+
+	mutex   sync.Mutex
+	entries map[string]*verification
+}
+
+// NewVerificationCache creates a cache keeping keys for ttl; a non-positive
+// ttl disables it
+func NewVerificationCache(ttl time.Duration) *VerificationCache {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		// Without a secret password hashes could be precomputed, so cache nothing
+		ttl = 0
+	}
+	return &VerificationCache{
+		ttl:     ttl,
+		secret:  secret,
+		entries: make(map[string]*verification),
+	}
+}
+
+// Enabled reports whether keys are cached
+func (c *VerificationCache) Enabled() bool {
+	return c.ttl > 0
+}
+
+// entryKey identifies the key derived for salt from password
+func (c *VerificationCache) entryKey(userID, salt, password string) string {
+	mac := hmac.New(sha256.New, c.secret)
+	mac.Write([]byte(password))
+	return userID + "\x00" + salt + "\x00" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Put remembers a key derived for salt from the user's password
+func (c *VerificationCache) Put(userID, salt, password string, key []byte) {
+	if !c.Enabled() {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.sweep(time.Now())
+	c.entries[c.entryKey(userID, salt, password)] = &verification{
+		userID:    userID,
+		key:       append([]byte(nil), key...),
+		expiresAt: time.Now().Add(c.ttl),
+	}
+}
+
+// Get returns a copy of the key derived for salt from password, if it was
+// cached within the ttl
+func (c *VerificationCache) Get(userID, salt, password string) ([]byte, bool) {
+	if !c.Enabled() {
+		return nil, false
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	id := c.entryKey(userID, salt, password)
+	entry, exists := c.entries[id]
+	if !exists {
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		c.remove(id)
+		return nil, false
+	}
+	return append([]byte(nil), entry.key...), true
+}
+
+// Invalidate forgets every key cached for the user, for instance because
+// their stored FAL token changed
+func (c *VerificationCache) Invalidate(userID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for id, entry := range c.entries {
+		if entry.userID == userID {
+			c.remove(id)
+		}
+	}
+}
+
+// Len returns how many keys are cached, including expired ones not yet swept
+func (c *VerificationCache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.entries)
+}
+
+// sweep drops expired entries; the caller holds the lock
+func (c *VerificationCache) sweep(now time.Time) {
+	for id, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			c.remove(id)
+		}
+	}
+}
+
+// remove wipes and drops an entry; the caller holds the lock
+func (c *VerificationCache) remove(id string) {
+	if entry, exists := c.entries[id]; exists {
+		for i := range entry.key {
+			entry.key[i] = 0
+		}
+		delete(c.entries, id)
+	}
+}
@@ -0,0 +1,202 @@
+package availability
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"generatio-pb/internal/fal"
+)
+
+// Probe limits
+const (
+	ProbeTimeout  = 10 * time.Second
+	SlowThreshold = 3 * time.Second // probes slower than this report the model as slow
+)
+
+// Connectivity describes whether FAL AI can be reached at all. While it
+// cannot, the server runs degraded: reads work but generation is refused.
+type Connectivity struct {
+	Reachable bool       `json:"reachable"`
+	Error     string     `json:"error,omitempty"`
+	CheckedAt *time.Time `json:"checked_at,omitempty"` // nil until the first check
+	Since     *time.Time `json:"since,omitempty"`      // when FAL AI became unreachable
+}
+
+// Monitor periodically probes the endpoint of every built-in model and caches
+// the outcome, so model lists can show which models are currently erroring
+type Monitor struct {
+	client   fal.FALClient
+	interval time.Duration
+
+	mutex        sync.RWMutex
+	statuses     map[string]fal.Availability
+	connectivity Connectivity
+
+	runMutex sync.Mutex
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// NewMonitor creates a monitor probing every interval; a non-positive
+// interval disables the background probes
+func NewMonitor(client fal.FALClient, interval time.Duration) *Monitor {
+	return &Monitor{
+		client:       client,
+		interval:     interval,
+		statuses:     make(map[string]fal.Availability),
+		connectivity: Connectivity{Reachable: true},
+		stopChan:     make(chan struct{}),
+	}
+}
+
+// Start probes once and then every interval in the background
+func (m *Monitor) Start() {
+	if m.interval <= 0 {
+		log.Printf("Model availability probes disabled")
+		return
+	}
+	go m.run()
+	log.Printf("Model availability monitor started with interval: %v", m.interval)
+}
+
+// Stop stops the background probes
+func (m *Monitor) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopChan)
+	})
+}
+
+func (m *Monitor) run() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.ProbeAll(context.Background())
+
+		select {
+		case <-ticker.C:
+		case <-m.stopChan:
+			return
+		}
+	}
+}
+
+// ProbeAll probes every model concurrently and records the outcomes
+func (m *Monitor) ProbeAll(ctx context.Context) {
+	m.runMutex.Lock()
+	defer m.runMutex.Unlock()
+
+	var wg sync.WaitGroup
+	var unreachable error
+	answered := false
+	for modelID := range m.client.GetModels() {
+		wg.Add(1)
+		go func(modelID string) {
+			defer wg.Done()
+			status, err := m.probe(ctx, modelID)
+
+			m.mutex.Lock()
+			m.statuses[modelID] = status
+			if errors.Is(err, fal.ErrUnreachable) {
+				unreachable = err
+			} else {
+				answered = true
+			}
+			m.mutex.Unlock()
+		}(modelID)
+	}
+	wg.Wait()
+
+	// FAL AI is unreachable only when no endpoint answered at all; single
+	// models erroring are reported per model instead
+	if answered {
+		m.recordConnectivity(nil)
+	} else if unreachable != nil {
+		m.recordConnectivity(unreachable)
+	}
+}
+
+// CheckConnectivity probes one model endpoint to find out whether FAL AI can
+// be reached at all, records the outcome and returns it
+func (m *Monitor) CheckConnectivity(ctx context.Context) Connectivity {
+	var modelIDs []string
+	for modelID := range m.client.GetModels() {
+		modelIDs = append(modelIDs, modelID)
+	}
+	if len(modelIDs) == 0 {
+		return m.Connectivity()
+	}
+	sort.Strings(modelIDs)
+
+	_, err := m.probe(ctx, modelIDs[0])
+	if !errors.Is(err, fal.ErrUnreachable) {
+		err = nil
+	}
+	m.recordConnectivity(err)
+	return m.Connectivity()
+}
+
+// recordConnectivity stores the outcome of a reachability check; err is nil
+// when FAL AI answered
+func (m *Monitor) recordConnectivity(err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now().UTC()
+	previous := m.connectivity
+	m.connectivity = Connectivity{Reachable: err == nil, CheckedAt: &now}
+
+	if err != nil {
+		m.connectivity.Error = err.Error()
+		m.connectivity.Since = previous.Since
+		if previous.Reachable {
+			m.connectivity.Since = &now
+			log.Printf("FAL AI is unreachable, generation disabled: %v", err)
+		}
+	} else if !previous.Reachable {
+		log.Printf("FAL AI is reachable again, generation enabled")
+	}
+}
+
+// Connectivity returns the latest reachability outcome
+func (m *Monitor) Connectivity() Connectivity {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.connectivity
+}
+
+// probe checks one model and classifies the outcome
+func (m *Monitor) probe(ctx context.Context, modelID string) (fal.Availability, error) {
+	ctx, cancel := context.WithTimeout(ctx, ProbeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := m.client.ProbeModel(ctx, modelID)
+	latency := time.Since(start)
+
+	status := fal.Availability{
+		Status:    fal.AvailabilityUp,
+		LatencyMS: latency.Milliseconds(),
+		CheckedAt: time.Now().UTC(),
+	}
+	switch {
+	case err != nil:
+		status.Status = fal.AvailabilityDown
+		status.Error = err.Error()
+	case latency > SlowThreshold:
+		status.Status = fal.AvailabilitySlow
+	}
+	return status, err
+}
+
+// Status returns the latest probe outcome for a model, if it has been probed
+func (m *Monitor) Status(modelID string) (fal.Availability, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	status, exists := m.statuses[modelID]
+	return status, exists
+}
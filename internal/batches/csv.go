@@ -0,0 +1,117 @@
+package batches
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// MaxRows caps the prompts of one import
+	MaxRows = 500
+
+	// MaxFileBytes caps the size of an imported file
+	MaxFileBytes = 1 << 20
+)
+
+// Columns an import may have; prompt is required
+const (
+	ColumnPrompt     = "prompt"
+	ColumnModel      = "model"
+	ColumnFolderID   = "folder_id"
+	ColumnParameters = "parameters" // A JSON object
+)
+
+// Row is one prompt read from an imported file. Error is set when the row
+// itself is malformed.
+type Row struct {
+	Line       int
+	Prompt     string
+	Model      string
+	FolderID   string
+	Parameters map[string]interface{}
+	Error      string
+}
+
+// Parse reads an import's rows. The first line names the columns. Malformed
+// rows are returned with an Error; an unusable file fails as a whole.
+func Parse(r io.Reader) ([]Row, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("the file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV: %w", err)
+	}
+
+	// Spreadsheet exports may start with a byte order mark
+	columns := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		switch name {
+		case ColumnPrompt, ColumnModel, ColumnFolderID, ColumnParameters:
+		default:
+			return nil, fmt.Errorf("unknown column %q; use prompt, model, folder_id and parameters", name)
+		}
+		if _, exists := columns[name]; exists {
+			return nil, fmt.Errorf("column %q appears twice", name)
+		}
+		columns[name] = i
+	}
+	if _, exists := columns[ColumnPrompt]; !exists {
+		return nil, fmt.Errorf("a prompt column is required")
+	}
+
+	field := func(record []string, column string) string {
+		if i, exists := columns[column]; exists && i < len(record) {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	var rows []Row
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		if len(rows) == MaxRows {
+			return nil, fmt.Errorf("an import cannot have more than %d rows", MaxRows)
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := Row{
+			Line:     line,
+			Prompt:   field(record, ColumnPrompt),
+			Model:    field(record, ColumnModel),
+			FolderID: field(record, ColumnFolderID),
+		}
+		switch {
+		case len(record) != len(header):
+			row.Error = fmt.Sprintf("expected %d fields, found %d", len(header), len(record))
+		case row.Prompt == "":
+			row.Error = "prompt is required"
+		}
+		if raw := field(record, ColumnParameters); raw != "" && row.Error == "" {
+			if err := json.Unmarshal([]byte(raw), &row.Parameters); err != nil {
+				row.Error = "parameters must be a JSON object"
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("the file has no rows")
+	}
+	return rows, nil
+}
@@ -0,0 +1,120 @@
+package batches
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"generatio-pb/internal/pipelines"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Collection stores imported batches
+const Collection = "generation_batches"
+
+// ErrNotFound is returned for batches the user does not own
+var ErrNotFound = errors.New("batch not found")
+
+// Rejection is a CSV row that was not queued
+type Rejection struct {
+	Row    int    `json:"row"` // Line in the file, the header being line 1
+	Prompt string `json:"prompt"`
+	Model  string `json:"model,omitempty"`
+	Error  string `json:"error"`
+}
+
+// Batch is a CSV import whose accepted rows were queued as pipeline runs
+type Batch struct {
+	ID       string         `json:"id"`
+	FileName string         `json:"file_name"`
+	Rows     int            `json:"rows"`
+	RunIDs   []string       `json:"run_ids"`
+	Rejected []Rejection    `json:"rejected"`
+	Progress map[string]int `json:"progress,omitempty"` // Runs by status
+	Created  time.Time      `json:"created"`
+
+	// ErrorReport is where the rejected rows can be downloaded as CSV
+	ErrorReport string `json:"error_report,omitempty"`
+}
+
+// Store saves imported batches
+type Store struct {
+	app core.App
+}
+
+// NewStore creates a batch store
+func NewStore(app core.App) *Store {
+	return &Store{app: app}
+}
+
+// Create records an imported file, the runs queued from it and its rejected rows
+func (s *Store) Create(userID, orgID, fileName string, rows int, runIDs []string, rejected []Rejection) (*Batch, error) {
+	collection, err := s.app.FindCollectionByNameOrId(Collection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find batches collection: %w", err)
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("user_id", userID)
+	if orgID != "" {
+		record.Set("org_id", orgID)
+	}
+	record.Set("file_name", fileName)
+	record.Set("rows", rows)
+	record.Set("run_ids", runIDs)
+	record.Set("rejected", rejected)
+	if err := s.app.Save(record); err != nil {
+		return nil, fmt.Errorf("failed to save batch: %w", err)
+	}
+	return fromRecord(record), nil
+}
+
+// Find loads one of the user's batches with the progress of its runs
+func (s *Store) Find(id, userID string) (*Batch, error) {
+	record, err := s.app.FindRecordById(Collection, id)
+	if err != nil || record.GetString("user_id") != userID {
+		return nil, ErrNotFound
+	}
+	batch := fromRecord(record)
+
+	batch.Progress = map[string]int{}
+	if len(batch.RunIDs) > 0 {
+		runs, err := s.app.FindRecordsByIds(pipelines.RunsCollection, batch.RunIDs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch batch runs: %w", err)
+		}
+		for _, run := range runs {
+			batch.Progress[run.GetString("status")]++
+		}
+	}
+	return batch, nil
+}
+
+// WriteErrorReport writes a batch's rejected rows as CSV
+func WriteErrorReport(w io.Writer, batch *Batch) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"row", "prompt", "model", "error"})
+	for _, rejection := range batch.Rejected {
+		writer.Write([]string{strconv.Itoa(rejection.Row), rejection.Prompt, rejection.Model, rejection.Error})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func fromRecord(record *core.Record) *Batch {
+	batch := &Batch{
+		ID:       record.Id,
+		FileName: record.GetString("file_name"),
+		Rows:     record.GetInt("rows"),
+		RunIDs:   []string{},
+		Rejected: []Rejection{},
+		Created:  record.GetDateTime("created").Time(),
+	}
+	record.UnmarshalJSONField("run_ids", &batch.RunIDs)
+	record.UnmarshalJSONField("rejected", &batch.Rejected)
+	return batch
+}
@@ -0,0 +1,313 @@
+package budget
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/money"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Collection stores per-user spending limits set by superusers
+const Collection = "user_budgets"
+
+// Generation is refused with these errors once a limit is reached
+var (
+	ErrBudgetExceeded = errors.New("monthly budget exhausted")
+	ErrQuotaExceeded  = errors.New("daily image quota reached")
+)
+
+// Limits are a user's spending limits. Zero limits are unlimited. Amounts
+// are kept in micro-dollars; the USD fields repeat them for older readers.
+type Limits struct {
+	UserID           string       `json:"user_id"`
+	MonthlyBudget    money.Micros `json:"monthly_budget_micros"`
+	MonthlyBudgetUSD float64      `json:"monthly_budget_usd"`
+	DailyImages      int          `json:"daily_image_quota"`
+
+	// Credit extends the current month's budget and lapses when the month ends
+	Credit    money.Micros `json:"credit_micros"`
+	CreditUSD float64      `json:"credit_usd"`
+
+	// QuotaResetAt discards usage before it when checking limits
+	QuotaResetAt *time.Time `json:"quota_reset_at,omitempty"`
+}
+
+// Usage is what counts towards a user's limits
+type Usage struct {
+	MonthSpent    money.Micros  `json:"month_spent_micros"`
+	MonthSpentUSD float64       `json:"month_spent_usd"`
+	TodayImages   int           `json:"today_images"`
+	Remaining     *money.Micros `json:"remaining_micros,omitempty"` // set when a monthly budget applies
+	RemainingUSD  *float64      `json:"remaining_usd,omitempty"`
+}
+
+// Status combines a user's limits and usage
+type Status struct {
+	Limits
+	Usage Usage `json:"usage"`
+}
+
+// Service reads, enforces and changes user limits. Months and days are UTC.
+type Service struct {
+	app core.App
+
+	// mutex makes checking a user's limits and reserving against them one
+	// step; reserved holds what generations in flight are expected to cost
+	mutex    sync.Mutex
+	reserved map[string]reservation
+}
+
+// reservation is the estimated cost and image count held for a user's
+// generations in flight
+type reservation struct {
+	cost   money.Micros
+	images int
+}
+
+// NewService creates a budget service
+func NewService(app core.App) *Service {
+	return &Service{app: app, reserved: make(map[string]reservation)}
+}
+
+// Get returns a user's limits; users without a record are unlimited
+func (s *Service) Get(userID string) *Limits {
+	return limitsFromRecord(userID, s.find(userID), time.Now().UTC())
+}
+
+// Status returns a user's limits with their current usage
+func (s *Service) Status(userID string) (*Status, error) {
+	now := time.Now().UTC()
+	limits := limitsFromRecord(userID, s.find(userID), now)
+	usage, err := s.usage(limits, now)
+	if err != nil {
+		return nil, err
+	}
+	if limits.MonthlyBudget > 0 {
+		remaining := max(0, limits.MonthlyBudget+limits.Credit-usage.MonthSpent)
+		remainingUSD := remaining.USD()
+		usage.Remaining = &remaining
+		usage.RemainingUSD = &remainingUSD
+	}
+	return &Status{Limits: *limits, Usage: *usage}, nil
+}
+
+// Check returns ErrBudgetExceeded or ErrQuotaExceeded when the user may not
+// generate more images. Generations in flight count as already spent.
+func (s *Service) Check(userID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.check(userID, reservation{})
+}
+
+// Reserve checks that the user may generate images expected to cost cost,
+// counting generations already in flight, and holds cost and images against
+// their limits until release is called. Call release once the generation's
+// images are saved, or it failed; calling it again does nothing.
+func (s *Service) Reserve(userID string, cost money.Micros, images int) (release func(), err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	held := reservation{cost: cost, images: images}
+	if err := s.check(userID, held); err != nil {
+		return nil, err
+	}
+
+	total := s.reserved[userID]
+	total.cost += held.cost
+	total.images += held.images
+	s.reserved[userID] = total
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+
+			total := s.reserved[userID]
+			total.cost -= held.cost
+			total.images -= held.images
+			if total == (reservation{}) {
+				delete(s.reserved, userID)
+			} else {
+				s.reserved[userID] = total
+			}
+		})
+	}, nil
+}
+
+// check refuses a generation of next once the user's usage and reservations
+// reach a limit, or when next would take them past one. The caller holds
+// s.mutex.
+func (s *Service) check(userID string, next reservation) error {
+	now := time.Now().UTC()
+	record := s.find(userID)
+	if record == nil {
+		return nil
+	}
+
+	limits := limitsFromRecord(userID, record, now)
+	if limits.MonthlyBudget <= 0 && limits.DailyImages <= 0 {
+		return nil
+	}
+
+	usage, err := s.usage(limits, now)
+	if err != nil {
+		return err
+	}
+	held := s.reserved[userID]
+	if limits.MonthlyBudget > 0 {
+		budget, spent := limits.MonthlyBudget+limits.Credit, usage.MonthSpent+held.cost
+		if spent >= budget || spent+next.cost > budget {
+			return ErrBudgetExceeded
+		}
+	}
+	if limits.DailyImages > 0 {
+		images := usage.TodayImages + held.images
+		if images >= limits.DailyImages || images+next.images > limits.DailyImages {
+			return ErrQuotaExceeded
+		}
+	}
+	return nil
+}
+
+// GrantCredit adds amount to the user's credit for the current month
+func (s *Service) GrantCredit(userID string, amount money.Micros) (*Limits, error) {
+	if amount <= 0 {
+		return nil, fmt.Errorf("credit must be positive")
+	}
+
+	now := time.Now().UTC()
+	return s.update(userID, now, func(record *core.Record, limits *Limits) {
+		record.Set("credit", (limits.Credit + amount).USD())
+		record.Set("credit_month", now.Format("2006-01"))
+	})
+}
+
+// SetLimits changes the monthly budget and daily image quota; nil values are
+// left unchanged and zero removes a limit
+func (s *Service) SetLimits(userID string, monthlyBudget *money.Micros, dailyImages *int) (*Limits, error) {
+	if monthlyBudget != nil && *monthlyBudget < 0 {
+		return nil, fmt.Errorf("monthly budget cannot be negative")
+	}
+	if dailyImages != nil && *dailyImages < 0 {
+		return nil, fmt.Errorf("daily image quota cannot be negative")
+	}
+
+	return s.update(userID, time.Now().UTC(), func(record *core.Record, limits *Limits) {
+		if monthlyBudget != nil {
+			record.Set("monthly_budget", monthlyBudget.USD())
+		}
+		if dailyImages != nil {
+			record.Set("daily_images", *dailyImages)
+		}
+	})
+}
+
+// ResetQuota discards the user's usage so far, restoring their full budget
+// and daily quota
+func (s *Service) ResetQuota(userID string) (*Limits, error) {
+	now := time.Now().UTC()
+	return s.update(userID, now, func(record *core.Record, limits *Limits) {
+		record.Set("quota_reset_at", now)
+	})
+}
+
+// find returns the user's limits record, or nil when they have none
+func (s *Service) find(userID string) *core.Record {
+	record, err := s.app.FindFirstRecordByFilter(Collection, "user_id = {:user_id}", map[string]any{"user_id": userID})
+	if err != nil {
+		return nil
+	}
+	return record
+}
+
+// update applies change to the user's limits record, creating it if needed
+func (s *Service) update(userID string, now time.Time, change func(record *core.Record, limits *Limits)) (*Limits, error) {
+	record := s.find(userID)
+	if record == nil {
+		collection, err := s.app.FindCollectionByNameOrId(Collection)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find budgets collection: %w", err)
+		}
+		record = core.NewRecord(collection)
+		record.Set("user_id", userID)
+	}
+
+	change(record, limitsFromRecord(userID, record, now))
+	if err := s.app.Save(record); err != nil {
+		return nil, fmt.Errorf("failed to save limits: %w", err)
+	}
+	return limitsFromRecord(userID, record, now), nil
+}
+
+// usage sums the cost and count of the user's images since the start of the
+// month and day, or since the last quota reset if later. Trashed images are
+// left out, as are images generated with the testing key, which were not paid
+// from the budget.
+func (s *Service) usage(limits *Limits, now time.Time) (*Usage, error) {
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if limits.QuotaResetAt != nil {
+		if limits.QuotaResetAt.After(monthStart) {
+			monthStart = *limits.QuotaResetAt
+		}
+		if limits.QuotaResetAt.After(dayStart) {
+			dayStart = *limits.QuotaResetAt
+		}
+	}
+
+	records, err := s.app.FindRecordsByFilter("images", "user_id = {:user_id} && created >= {:since} && deleted_at = null", "", 0, 0, map[string]any{
+		"user_id": limits.UserID,
+		"since":   monthStart.Format(types.DefaultDateLayout),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch images: %w", err)
+	}
+
+	usage := &Usage{}
+	for _, record := range records {
+		var info struct {
+			money.ImageCost
+			Environment string `json:"environment"`
+		}
+		record.UnmarshalJSONField("other_info", &info)
+		if info.Environment == localmodels.EnvironmentTesting {
+			continue
+		}
+		usage.MonthSpent += info.Amount()
+
+		if !record.GetDateTime("created").Time().Before(dayStart) {
+			usage.TodayImages++
+		}
+	}
+	usage.MonthSpentUSD = usage.MonthSpent.USD()
+	return usage, nil
+}
+
+func limitsFromRecord(userID string, record *core.Record, now time.Time) *Limits {
+	limits := &Limits{UserID: userID}
+	if record == nil {
+		return limits
+	}
+
+	// The amounts are stored in USD; rounding them back to micro-dollars is exact
+	limits.MonthlyBudget = money.FromUSD(record.GetFloat("monthly_budget"))
+	limits.MonthlyBudgetUSD = limits.MonthlyBudget.USD()
+	limits.DailyImages = record.GetInt("daily_images")
+	if record.GetString("credit_month") == now.Format("2006-01") {
+		limits.Credit = money.FromUSD(record.GetFloat("credit"))
+		limits.CreditUSD = limits.Credit.USD()
+	}
+	if resetAt := record.GetDateTime("quota_reset_at"); !resetAt.IsZero() {
+		t := resetAt.Time()
+		limits.QuotaResetAt = &t
+	}
+	return limits
+}
@@ -0,0 +1,75 @@
+package captions
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Captioner describes what an image shows in a sentence or two
+type Captioner interface {
+	Caption(ctx context.Context, token, imageURL string) (string, error)
+}
+
+// FALCaptioner runs images through a captioning model hosted on FAL AI. The
+// endpoint takes {"image_url": ...} and answers {"results": "<caption>"}.
+type FALCaptioner struct {
+	endpoint   string
+	httpClient *http.Client
+}
+
+// NewFALCaptioner creates a captioner for a FAL AI endpoint (defaults to Florence-2)
+func NewFALCaptioner(endpoint string) *FALCaptioner {
+	if endpoint == "" {
+		endpoint = "https://fal.run/fal-ai/florence-2-large/more-detailed-caption"
+	}
+
+	return &FALCaptioner{
+		endpoint: endpoint,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// Caption submits the image URL to the model and returns its caption
+func (c *FALCaptioner) Caption(ctx context.Context, token, imageURL string) (string, error) {
+	body, err := json.Marshal(map[string]string{"image_url": imageURL})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Key "+token)
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("caption request failed: HTTP %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var parsed struct {
+		Results string `json:"results"`
+	}
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		return "", fmt.Errorf("failed to parse response: %w", err)
+	}
+	return parsed.Results, nil
+}
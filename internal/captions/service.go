@@ -0,0 +1,170 @@
+package captions
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"generatio-pb/internal/moderation"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// MaxLength caps stored captions, in runes
+const MaxLength = 500
+
+// captionTimeout bounds a single caption request
+const captionTimeout = 30 * time.Second
+
+// ErrDisabled is returned when no captioner is configured
+var ErrDisabled = errors.New("captioning is not enabled")
+
+// job is a queued image together with the key paying for its caption
+type job struct {
+	imageID string
+	token   string
+}
+
+// Service stores captions of generated images in images.caption, where
+// prompt searches also look. New images are captioned by a background worker
+// so generation responses do not wait on the model.
+type Service struct {
+	app core.App
+
+	mu        sync.RWMutex
+	captioner Captioner
+
+	queue    chan job
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	started  bool
+	stopOnce sync.Once
+}
+
+// NewService creates a captioning service; a nil captioner leaves captioning off
+func NewService(app core.App, captioner Captioner) *Service {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Service{
+		app:       app,
+		captioner: captioner,
+		queue:     make(chan job, 256),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+}
+
+// SetCaptioner replaces the captioning model (nil disables captioning)
+func (s *Service) SetCaptioner(captioner Captioner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.captioner = captioner
+}
+
+// Enabled reports whether a captioner is configured
+func (s *Service) Enabled() bool {
+	return s.current() != nil
+}
+
+func (s *Service) current() Captioner {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.captioner
+}
+
+// Start begins the background worker that captions newly generated images
+func (s *Service) Start() {
+	s.started = true
+	go s.run()
+	log.Printf("Image caption worker started")
+}
+
+// Stop cancels any in-flight caption request and waits for the worker to exit
+func (s *Service) Stop() {
+	s.stopOnce.Do(func() {
+		s.cancel()
+		if s.started {
+			<-s.done
+		}
+	})
+}
+
+// Enqueue schedules an image to be captioned with token. It does nothing when
+// captioning is disabled, and drops the image when the queue is full; it can
+// still be captioned on request.
+func (s *Service) Enqueue(token, imageID string) {
+	if !s.Enabled() {
+		return
+	}
+	select {
+	case s.queue <- job{imageID: imageID, token: token}:
+	default:
+	}
+}
+
+func (s *Service) run() {
+	defer close(s.done)
+
+	for {
+		select {
+		case next := <-s.queue:
+			if s.ctx.Err() != nil {
+				return
+			}
+
+			record, err := s.app.FindRecordById("images", next.imageID)
+			if err != nil || record.GetString("caption") != "" ||
+				record.GetString("moderation_status") == moderation.StatusQuarantined {
+				continue
+			}
+
+			if _, err := s.Caption(s.ctx, next.token, record); err != nil && s.ctx.Err() == nil {
+				s.app.Logger().Warn("Failed to caption image", "error", err, "image_id", next.imageID)
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// Caption describes the image with the configured model, stores the caption
+// and returns it
+func (s *Service) Caption(ctx context.Context, token string, record *core.Record) (string, error) {
+	captioner := s.current()
+	if captioner == nil {
+		return "", ErrDisabled
+	}
+
+	captionCtx, cancel := context.WithTimeout(ctx, captionTimeout)
+	defer cancel()
+
+	caption, err := captioner.Caption(captionCtx, token, record.GetString("url"))
+	if err != nil {
+		return "", err
+	}
+	caption = clean(caption)
+	if caption == "" {
+		return "", fmt.Errorf("captioning model returned no caption")
+	}
+
+	record.Set("caption", caption)
+	if err := s.app.Save(record); err != nil {
+		return "", fmt.Errorf("failed to save caption: %w", err)
+	}
+	return caption, nil
+}
+
+// clean collapses whitespace and truncates a caption to MaxLength runes
+func clean(caption string) string {
+	caption = strings.Join(strings.Fields(caption), " ")
+	if runes := []rune(caption); len(runes) > MaxLength {
+		caption = strings.TrimSpace(string(runes[:MaxLength]))
+	}
+	return caption
+}
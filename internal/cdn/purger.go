@@ -0,0 +1,138 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"generatio-pb/internal/notify"
+)
+
+// Providers a purger can be configured for
+const (
+	ProviderCloudflare = "cloudflare"
+	ProviderFastly     = "fastly"
+)
+
+// Purger evicts cached responses carrying any of the given tags from a CDN
+type Purger interface {
+	Purge(ctx context.Context, tags []string) error
+}
+
+// NewPurger creates the purger for a provider. zone is the Cloudflare zone ID
+// or the Fastly service ID. An empty provider disables purging (nil, nil).
+func NewPurger(provider, zone, token string) (Purger, error) {
+	switch provider {
+	case "":
+		return nil, nil
+	case ProviderCloudflare, ProviderFastly:
+		if zone == "" || token == "" {
+			return nil, fmt.Errorf("the %s purger needs a zone and an API token", provider)
+		}
+		if provider == ProviderCloudflare {
+			return &Cloudflare{ZoneID: zone, Token: token}, nil
+		}
+		return &Fastly{ServiceID: zone, Token: token}, nil
+	default:
+		return nil, fmt.Errorf("unknown CDN provider %q (valid: %s, %s)", provider, ProviderCloudflare, ProviderFastly)
+	}
+}
+
+// ImageTag is the cache tag of every cached response serving an image
+func ImageTag(imageID string) string {
+	return "image-" + imageID
+}
+
+// SetTags labels a response so it can be purged by tag. Cloudflare reads
+// Cache-Tag and Fastly reads Surrogate-Key; both strip the header before the
+// response reaches clients.
+func SetTags(header http.Header, tags ...string) {
+	header.Set("Cache-Tag", strings.Join(tags, ","))
+	header.Set("Surrogate-Key", strings.Join(tags, " "))
+}
+
+// Cloudflare purges by cache tag through the Cloudflare API
+type Cloudflare struct {
+	ZoneID   string
+	Token    string
+	Endpoint string // defaults to the public API
+	Client   *http.Client
+}
+
+// Purge evicts the tagged responses from the zone
+func (c *Cloudflare) Purge(ctx context.Context, tags []string) error {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.cloudflare.com/client/v4"
+	}
+
+	body, err := json.Marshal(map[string][]string{"tags": tags})
+	if err != nil {
+		return fmt.Errorf("failed to marshal purge request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/zones/"+c.ZoneID+"/purge_cache", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create purge request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+
+	return send(c.Client, req, ProviderCloudflare)
+}
+
+// Fastly purges by surrogate key through the Fastly API
+type Fastly struct {
+	ServiceID string
+	Token     string
+	Endpoint  string // defaults to the public API
+	Client    *http.Client
+}
+
+// Purge evicts the tagged responses from the service
+func (f *Fastly) Purge(ctx context.Context, tags []string) error {
+	endpoint := f.Endpoint
+	if endpoint == "" {
+		endpoint = "https://api.fastly.com"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/service/"+f.ServiceID+"/purge", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create purge request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Fastly-Key", f.Token)
+	req.Header.Set("Surrogate-Key", strings.Join(tags, " "))
+
+	return send(f.Client, req, ProviderFastly)
+}
+
+// send performs a purge request. Client errors other than rate limiting are
+// permanent: retrying with the same credentials cannot succeed.
+func send(client *http.Client, req *http.Request, provider string) error {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s purge failed: %w", provider, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("%s purge failed: HTTP %d: %s", provider, resp.StatusCode, strings.TrimSpace(string(detail)))
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+		return notify.Permanent(err)
+	}
+	return err
+}
@@ -0,0 +1,98 @@
+package cdn
+
+import (
+	"context"
+	"fmt"
+
+	"generatio-pb/internal/moderation"
+	"generatio-pb/internal/notify"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Channel is the outbox channel purge requests are queued on, so failed
+// purges are retried and dead-lettered like notifications
+const Channel = "cdn_purge"
+
+// EventPurge names queued purge requests
+const EventPurge = "cdn.purge"
+
+// Service purges an image's cached responses when the image is deleted,
+// moved to the trash, quarantined or its file replaced, so CDN copies of
+// share links and feed images stop serving it
+type Service struct {
+	app      core.App
+	notifier *notify.Service
+	provider string
+}
+
+// NewService registers purger on the notifier and binds the image hooks
+// that queue purges. provider names the purger in the outbox.
+func NewService(app core.App, notifier *notify.Service, provider string, purger Purger) *Service {
+	s := &Service{app: app, notifier: notifier, provider: provider}
+
+	notifier.SetSender(Channel, &sender{purger: purger})
+
+	app.OnRecordAfterUpdateSuccess("images").BindFunc(func(e *core.RecordEvent) error {
+		if changed(e.Record.Original(), e.Record) {
+			s.PurgeImage(e.Record.Id)
+		}
+		return e.Next()
+	})
+	app.OnRecordAfterDeleteSuccess("images").BindFunc(func(e *core.RecordEvent) error {
+		s.PurgeImage(e.Record.Id)
+		return e.Next()
+	})
+
+	return s
+}
+
+// PurgeImage queues a purge of every cached response serving the image
+func (s *Service) PurgeImage(imageID string) {
+	err := s.notifier.Enqueue(notify.Message{
+		Channel: Channel,
+		Target:  s.provider,
+		Event:   EventPurge,
+		Subject: "Purge image " + imageID,
+		Data:    map[string]interface{}{"tags": []string{ImageTag(imageID)}},
+	})
+	if err != nil {
+		s.app.Logger().Error("Failed to queue CDN purge", "image_id", imageID, "error", err)
+	}
+}
+
+// changed reports whether an update stops an image being served as it was.
+// The first content hash is set when the file is persisted, not replaced.
+func changed(before, after *core.Record) bool {
+	switch {
+	case before.GetDateTime("deleted_at").IsZero() && !after.GetDateTime("deleted_at").IsZero():
+		return true
+	case before.GetString("moderation_status") != moderation.StatusQuarantined && after.GetString("moderation_status") == moderation.StatusQuarantined:
+		return true
+	case before.GetString("url") != after.GetString("url"):
+		return true
+	case before.GetString("content_hash") != "" && before.GetString("content_hash") != after.GetString("content_hash"):
+		return true
+	}
+	return false
+}
+
+// sender delivers queued purges
+type sender struct {
+	purger Purger
+}
+
+// Send purges the message's tags
+func (s *sender) Send(ctx context.Context, msg notify.Message) error {
+	raw, _ := msg.Data["tags"].([]interface{})
+	tags := make([]string, 0, len(raw))
+	for _, tag := range raw {
+		if value, ok := tag.(string); ok && value != "" {
+			tags = append(tags, value)
+		}
+	}
+	if len(tags) == 0 {
+		return notify.Permanent(fmt.Errorf("purge request has no tags"))
+	}
+	return s.purger.Purge(ctx, tags)
+}
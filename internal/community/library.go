@@ -0,0 +1,338 @@
+package community
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"generatio-pb/internal/moderation"
+	"generatio-pb/internal/repository"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Collections backing the community prompt library
+const (
+	PromptsCollection = "community_prompts"
+	LikesCollection   = "community_prompt_likes"
+	ReportsCollection = "community_prompt_reports"
+)
+
+// Library limits
+const (
+	MaxExamples = 4
+	MaxTitleLen = 120
+	// HideAfterReports hides a prompt from browsing until a moderator reviews it
+	HideAfterReports = 3
+	PageSize         = 24
+)
+
+// Browse orderings
+const (
+	SortRecent  = "recent"
+	SortPopular = "popular"
+)
+
+// Prompt is a published prompt as seen by one user
+type Prompt struct {
+	ID          string                 `json:"id"`
+	AuthorID    string                 `json:"author_id"`
+	Title       string                 `json:"title"`
+	Prompt      string                 `json:"prompt"`
+	Model       string                 `json:"model"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	ExampleIDs  []string               `json:"example_ids"`
+	ExampleURLs []string               `json:"example_urls"` // filled in by the API layer
+	Likes       int                    `json:"likes"`
+	Uses        int                    `json:"uses"`
+	LikedByMe   bool                   `json:"liked_by_me"`
+	Created     time.Time              `json:"created"`
+}
+
+// PublishInput describes a prompt being published
+type PublishInput struct {
+	Title      string
+	Prompt     string
+	Model      string
+	Parameters map[string]interface{}
+	ExampleIDs []string
+}
+
+// Library stores published prompts with their likes and reports
+type Library struct {
+	app    core.App
+	images *repository.Images
+}
+
+// NewLibrary creates a community prompt library
+func NewLibrary(app core.App) *Library {
+	return &Library{app: app, images: repository.NewImages(app)}
+}
+
+// Publish shares a prompt. Example images must be the author's own visible images.
+// Callers apply the content policy to the prompt.
+func (l *Library) Publish(authorID string, input PublishInput) (*Prompt, error) {
+	input.Title = strings.TrimSpace(input.Title)
+	if input.Title == "" || len(input.Title) > MaxTitleLen {
+		return nil, fmt.Errorf("title is required and must be at most %d characters", MaxTitleLen)
+	}
+	if strings.TrimSpace(input.Prompt) == "" || input.Model == "" {
+		return nil, fmt.Errorf("prompt and model are required")
+	}
+	if len(input.ExampleIDs) > MaxExamples {
+		return nil, fmt.Errorf("at most %d example images can be attached", MaxExamples)
+	}
+
+	for _, imageID := range input.ExampleIDs {
+		image, err := l.images.Owned(authorID, imageID)
+		if err != nil {
+			return nil, fmt.Errorf("example image %s not found", imageID)
+		}
+		if image.GetString("moderation_status") == moderation.StatusQuarantined {
+			return nil, fmt.Errorf("example image %s is withheld by moderation", imageID)
+		}
+	}
+
+	collection, err := l.app.FindCollectionByNameOrId(PromptsCollection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find prompts collection: %w", err)
+	}
+
+	examples := input.ExampleIDs
+	if examples == nil {
+		examples = []string{}
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("user_id", authorID)
+	record.Set("title", input.Title)
+	record.Set("prompt", input.Prompt)
+	record.Set("model", input.Model)
+	record.Set("parameters", input.Parameters)
+	record.Set("example_ids", examples)
+	record.Set("likes", 0)
+	record.Set("uses", 0)
+	record.Set("reports", 0)
+	record.Set("hidden", false)
+
+	if err := l.app.Save(record); err != nil {
+		return nil, fmt.Errorf("failed to publish prompt: %w", err)
+	}
+	return l.fromRecord(record, false), nil
+}
+
+// Browse lists visible prompts matching query, one page at a time (page starts at 1)
+func (l *Library) Browse(viewerID, query, sort string, page int) ([]*Prompt, error) {
+	filter := "hidden = false"
+	params := map[string]any{}
+	if query = strings.TrimSpace(query); query != "" {
+		filter += " && (title ~ {:query} || prompt ~ {:query})"
+		params["query"] = query
+	}
+
+	order := "-created"
+	if sort == SortPopular {
+		order = "-likes,-uses,-created"
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	records, err := l.app.FindRecordsByFilter(PromptsCollection, filter, order, PageSize, (page-1)*PageSize, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch prompts: %w", err)
+	}
+
+	liked := l.likedBy(viewerID, records)
+	prompts := make([]*Prompt, 0, len(records))
+	for _, record := range records {
+		prompts = append(prompts, l.fromRecord(record, liked[record.Id]))
+	}
+	return prompts, nil
+}
+
+// Find loads a prompt. Hidden prompts are only found by their author.
+func (l *Library) Find(id, viewerID string) (*Prompt, error) {
+	record, err := l.visible(id, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	return l.fromRecord(record, len(l.likedBy(viewerID, []*core.Record{record})) > 0), nil
+}
+
+// Unpublish removes an author's prompt with its likes and reports
+func (l *Library) Unpublish(id, authorID string) error {
+	record, err := l.app.FindRecordById(PromptsCollection, id)
+	if err != nil || record.GetString("user_id") != authorID {
+		return fmt.Errorf("prompt not found")
+	}
+
+	return l.app.RunInTransaction(func(txApp core.App) error {
+		for _, collection := range []string{LikesCollection, ReportsCollection} {
+			related, err := txApp.FindAllRecords(collection, dbx.HashExp{"prompt_id": id})
+			if err != nil {
+				return err
+			}
+			for _, r := range related {
+				if err := txApp.Delete(r); err != nil {
+					return err
+				}
+			}
+		}
+		return txApp.Delete(record)
+	})
+}
+
+// SetLiked likes or unlikes a prompt for a user and returns the new like count
+func (l *Library) SetLiked(id, userID string, liked bool) (int, error) {
+	record, err := l.visible(id, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = l.app.RunInTransaction(func(txApp core.App) error {
+		existing, _ := txApp.FindFirstRecordByFilter(LikesCollection, "prompt_id = {:prompt_id} && user_id = {:user_id}", dbx.Params{
+			"prompt_id": id,
+			"user_id":   userID,
+		})
+
+		switch {
+		case liked && existing == nil:
+			collection, err := txApp.FindCollectionByNameOrId(LikesCollection)
+			if err != nil {
+				return err
+			}
+			like := core.NewRecord(collection)
+			like.Set("prompt_id", id)
+			like.Set("user_id", userID)
+			if err := txApp.Save(like); err != nil {
+				return err
+			}
+		case !liked && existing != nil:
+			if err := txApp.Delete(existing); err != nil {
+				return err
+			}
+		}
+
+		count, err := txApp.CountRecords(LikesCollection, dbx.HashExp{"prompt_id": id})
+		if err != nil {
+			return err
+		}
+		record.Set("likes", count)
+		return txApp.Save(record)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to update like: %w", err)
+	}
+	return record.GetInt("likes"), nil
+}
+
+// Report flags a prompt once per user. Prompts reaching HideAfterReports
+// reports are hidden from browsing.
+func (l *Library) Report(id, userID, reason string) error {
+	record, err := l.visible(id, userID)
+	if err != nil {
+		return err
+	}
+	if record.GetString("user_id") == userID {
+		return fmt.Errorf("you cannot report your own prompt")
+	}
+
+	return l.app.RunInTransaction(func(txApp core.App) error {
+		existing, _ := txApp.FindFirstRecordByFilter(ReportsCollection, "prompt_id = {:prompt_id} && user_id = {:user_id}", dbx.Params{
+			"prompt_id": id,
+			"user_id":   userID,
+		})
+		if existing != nil {
+			return nil
+		}
+
+		collection, err := txApp.FindCollectionByNameOrId(ReportsCollection)
+		if err != nil {
+			return err
+		}
+		report := core.NewRecord(collection)
+		report.Set("prompt_id", id)
+		report.Set("user_id", userID)
+		report.Set("reason", strings.TrimSpace(reason))
+		if err := txApp.Save(report); err != nil {
+			return err
+		}
+
+		count, err := txApp.CountRecords(ReportsCollection, dbx.HashExp{"prompt_id": id})
+		if err != nil {
+			return err
+		}
+		record.Set("reports", count)
+		if count >= HideAfterReports {
+			record.Set("hidden", true)
+		}
+		return txApp.Save(record)
+	})
+}
+
+// RecordUse counts a generation started from a prompt
+func (l *Library) RecordUse(id string) {
+	record, err := l.app.FindRecordById(PromptsCollection, id)
+	if err != nil {
+		return
+	}
+	record.Set("uses", record.GetInt("uses")+1)
+	l.app.Save(record)
+}
+
+// HasExample reports whether imageID is one of a prompt's example images
+func (p *Prompt) HasExample(imageID string) bool {
+	return slices.Contains(p.ExampleIDs, imageID)
+}
+
+// visible loads a prompt the viewer may see
+func (l *Library) visible(id, viewerID string) (*core.Record, error) {
+	record, err := l.app.FindRecordById(PromptsCollection, id)
+	if err != nil || (record.GetBool("hidden") && record.GetString("user_id") != viewerID) {
+		return nil, fmt.Errorf("prompt not found")
+	}
+	return record, nil
+}
+
+// likedBy returns which of the prompts the viewer has liked
+func (l *Library) likedBy(viewerID string, records []*core.Record) map[string]bool {
+	liked := make(map[string]bool)
+	if viewerID == "" || len(records) == 0 {
+		return liked
+	}
+
+	ids := make([]any, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.Id)
+	}
+
+	likes, err := l.app.FindAllRecords(LikesCollection, dbx.HashExp{"user_id": viewerID}, dbx.In("prompt_id", ids...))
+	if err != nil {
+		return liked
+	}
+	for _, like := range likes {
+		liked[like.GetString("prompt_id")] = true
+	}
+	return liked
+}
+
+func (l *Library) fromRecord(record *core.Record, liked bool) *Prompt {
+	prompt := &Prompt{
+		ID:         record.Id,
+		AuthorID:   record.GetString("user_id"),
+		Title:      record.GetString("title"),
+		Prompt:     record.GetString("prompt"),
+		Model:      record.GetString("model"),
+		ExampleIDs: []string{},
+		Likes:      record.GetInt("likes"),
+		Uses:       record.GetInt("uses"),
+		LikedByMe:  liked,
+		Created:    record.GetDateTime("created").Time(),
+	}
+	record.UnmarshalJSONField("parameters", &prompt.Parameters)
+	record.UnmarshalJSONField("example_ids", &prompt.ExampleIDs)
+	return prompt
+}
@@ -0,0 +1,432 @@
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds deployment settings for the Generatio extension.
+// Values come from GENERATIO_* environment variables with safe defaults.
+type Config struct {
+	// ModerationProvider selects the post-generation classifier ("" disables moderation, "fal")
+	ModerationProvider string
+
+	// ModerationThreshold is the NSFW probability at or above which an image is quarantined
+	ModerationThreshold float64
+
+	// ContentFilterStrictness selects which prompt filter severities are blocked ("off", "lenient", "standard", "strict")
+	ContentFilterStrictness string
+
+	// RetentionDays is the deployment default age after which images expire (0 keeps images forever)
+	RetentionDays int
+
+	// RetentionAction is what happens to expired images ("archive" or "delete")
+	RetentionAction string
+
+	// TrashDays is how long deleted images stay restorable before they are purged (0 keeps them until the trash is emptied)
+	TrashDays int
+
+	// ImageCacheDir is where proxied image files are cached ("" uses <data dir>/image_cache)
+	ImageCacheDir string
+
+	// AllowPrivateImageURLs lets imports and the image cache fetch from loopback,
+	// private and link-local addresses; leave it off unless every image origin is trusted
+	AllowPrivateImageURLs bool
+
+	// ThumbnailSizes are the bounding boxes (in pixels) of the resized variants generated per image
+	ThumbnailSizes []int
+
+	// ThumbnailFormats are the variant encodings ("jpeg", "png"); formats without an encoder are skipped
+	ThumbnailFormats []string
+
+	// ShareSecret signs share links; when empty links stop working after a restart
+	ShareSecret string
+
+	// DefaultPriority is the highest request priority ("interactive" or "batch") for users without a listed tier
+	DefaultPriority string
+
+	// TierPriorities caps the request priority per generatio_users.tier, e.g. {"free": "batch", "pro": "interactive"}
+	TierPriorities map[string]string
+
+	// ModelProbeInterval is how often model endpoints are probed for availability (0 disables probes)
+	ModelProbeInterval time.Duration
+
+	// ReconcileInterval is how often recorded spending is reconciled with image costs (0 disables scheduled runs)
+	ReconcileInterval time.Duration
+
+	// ReconcileAutoFix lets scheduled reconciliation reset drifted totals instead of only reporting them
+	ReconcileAutoFix bool
+
+	// ExchangeRatesURL is a JSON endpoint returning USD-based rates for display currencies ("" uses ExchangeRates)
+	ExchangeRatesURL string
+
+	// ExchangeRates are fixed units per USD used when no URL is set, e.g. {"EUR": 0.92}
+	ExchangeRates map[string]float64
+
+	// AnomalyFactor is how many times the 30-day daily average a day's spending must exceed to be flagged
+	AnomalyFactor float64
+
+	// AnomalyMinSpend is the USD a day's spending must reach before it can be flagged
+	AnomalyMinSpend float64
+
+	// AnomalyInterval is how often spending is checked for anomalies (0 disables the check)
+	AnomalyInterval time.Duration
+
+	// KeyHealthInterval is how often opted-in users' FAL AI keys are validated (0 disables the check)
+	KeyHealthInterval time.Duration
+
+	// GenerationRateLimit is how many generation requests a user may make per window (0 disables rate limiting)
+	GenerationRateLimit int
+
+	// GenerationRateWindow is the length of a rate limit window
+	GenerationRateWindow time.Duration
+
+	// MaxBodyBytes caps JSON request bodies (0 disables the cap)
+	MaxBodyBytes int
+
+	// MaxUploadBytes caps multipart image uploads (0 disables the cap)
+	MaxUploadBytes int
+
+	// StrictJSON rejects JSON request bodies containing fields the endpoint does not accept
+	StrictJSON bool
+
+	// RenewSessionOnGeneration extends the caller's session by the session timeout after each successful generation
+	RenewSessionOnGeneration bool
+
+	// MaxRememberDeviceDays caps how long users may keep a device remembered with a refresh token (0 disables remembering)
+	MaxRememberDeviceDays int
+
+	// SessionCookie also delivers and accepts session IDs in a Secure, HttpOnly cookie, as an alternative to X-Session-ID
+	SessionCookie bool
+
+	// SessionCookieSameSite is the session cookie's SameSite mode ("strict", "lax" or "none")
+	SessionCookieSameSite string
+
+	// MaxSessions caps the in-memory session count; past it the least recently used session is evicted (0 removes the cap)
+	MaxSessions int
+
+	// StartupConnectivityCheck probes FAL AI when the server starts and runs degraded (no generation) if it is unreachable
+	StartupConnectivityCheck bool
+
+	// FALProxyURL routes FAL AI requests through this proxy; empty honours HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	FALProxyURL string
+
+	// FALBaseURL is the FAL AI queue endpoint, overridden to send traffic through an internal gateway
+	FALBaseURL string
+
+	// FALCAFile is a PEM CA bundle trusted for FAL AI requests in addition to the system roots
+	FALCAFile string
+
+	// FALClientCertFile and FALClientKeyFile are a PEM client certificate and key for mutual TLS with the FAL AI gateway
+	FALClientCertFile string
+	FALClientKeyFile  string
+
+	// FakeFAL answers FAL AI calls with placeholder results instead of calling FAL AI, for local development without a paid key
+	FakeFAL bool
+
+	// DuplicateWindow is how long after an identical generation a repeat counts as a duplicate (0 disables detection)
+	DuplicateWindow time.Duration
+
+	// DuplicateAction is "coalesce" to answer duplicates with the earlier request's images, or "warn" to generate them with a warning
+	DuplicateAction string
+
+	// CoalesceSeeded submits a user's identical seeded generations in flight at the same time once and shares the result
+	CoalesceSeeded bool
+
+	// ResultCacheTTL is how long a seeded generation answers identical repeats with its stored images (0 disables the cache)
+	ResultCacheTTL time.Duration
+
+	// MaxGenerationWait caps the max_wait a generation request may ask for before it is answered with a job to poll (0 ignores max_wait)
+	MaxGenerationWait time.Duration
+
+	// GenerationJobTTL is how long the outcome of a generation answered with a job can be polled for
+	GenerationJobTTL time.Duration
+
+	// RecentErrors is how many FAL AI errors are kept per user for GET /api/custom/debug/errors (0 disables it)
+	RecentErrors int
+
+	// CDNProvider is the CDN in front of the server whose cached images are purged when they change ("" disables purging, "cloudflare", "fastly")
+	CDNProvider string
+
+	// CDNZone is the Cloudflare zone ID or the Fastly service ID
+	CDNZone string
+
+	// CDNToken is the API token purge requests are made with
+	CDNToken string
+
+	// EmbeddingProvider computes image embeddings for similarity search ("" disables search, "fal")
+	EmbeddingProvider string
+
+	// EmbeddingEndpoint is the FAL AI CLIP endpoint; it takes {"image_url"} or {"text"} and answers {"embedding": [...]}
+	EmbeddingEndpoint string
+
+	// EmbeddingInterval is how often persisted images of users with an active session are embedded (0 disables background indexing)
+	EmbeddingInterval time.Duration
+
+	// CaptionProvider captions generated images so prompt searches also match what they show ("" disables, "fal")
+	CaptionProvider string
+
+	// CaptionEndpoint overrides the FAL AI captioning endpoint; it takes {"image_url"} and answers {"results": "<caption>"}
+	CaptionEndpoint string
+
+	// KDFWorkers is how many password key derivations run at once; more logins queue (0 uses one per CPU)
+	KDFWorkers int
+
+	// VerificationCacheTTL is how long a key derived while checking a password is reused, e.g. by the create-session following a token verify (0 disables)
+	VerificationCacheTTL time.Duration
+
+	// LogRequests logs custom API requests and responses at debug level, with tokens, passwords and credential headers redacted
+	LogRequests bool
+}
+
+// Default returns the configuration used when no environment overrides are set
+func Default() *Config {
+	return &Config{
+		ModerationProvider:  "",
+		ModerationThreshold: 0.5,
+
+		ContentFilterStrictness: "standard",
+
+		RetentionDays:   0,
+		RetentionAction: "archive",
+
+		TrashDays: 30,
+
+		ThumbnailSizes:   []int{128, 512, 1024},
+		ThumbnailFormats: []string{"jpeg"},
+
+		DefaultPriority: "interactive",
+		TierPriorities:  map[string]string{},
+
+		ModelProbeInterval: 5 * time.Minute,
+
+		ReconcileInterval: 24 * time.Hour,
+		ReconcileAutoFix:  false,
+
+		ExchangeRates: map[string]float64{},
+
+		AnomalyFactor:   5,
+		AnomalyMinSpend: 5,
+		AnomalyInterval: time.Hour,
+
+		KeyHealthInterval: 6 * time.Hour,
+
+		GenerationRateLimit:  30,
+		GenerationRateWindow: time.Minute,
+
+		MaxBodyBytes:   1 << 20,
+		MaxUploadBytes: 32 << 20,
+		StrictJSON:     true,
+
+		RenewSessionOnGeneration: false,
+		MaxRememberDeviceDays:    30,
+
+		SessionCookie:         false,
+		SessionCookieSameSite: "strict",
+
+		MaxSessions: 10000,
+
+		StartupConnectivityCheck: true,
+
+		FALBaseURL: "https://queue.fal.run",
+
+		DuplicateWindow: 10 * time.Second,
+		DuplicateAction: "coalesce",
+		CoalesceSeeded:  true,
+		ResultCacheTTL:  7 * 24 * time.Hour,
+
+		MaxGenerationWait: 5 * time.Minute,
+		GenerationJobTTL:  time.Hour,
+
+		RecentErrors: 20,
+
+		EmbeddingInterval: 10 * time.Minute,
+
+		VerificationCacheTTL: time.Minute,
+	}
+}
+
+// Load returns the default configuration overridden by environment variables
+func Load() *Config {
+	cfg := Default()
+
+	cfg.ModerationProvider = envString("GENERATIO_MODERATION_PROVIDER", cfg.ModerationProvider)
+	cfg.ModerationThreshold = envFloat("GENERATIO_MODERATION_THRESHOLD", cfg.ModerationThreshold)
+	cfg.ContentFilterStrictness = envString("GENERATIO_CONTENT_FILTER_STRICTNESS", cfg.ContentFilterStrictness)
+	cfg.RetentionDays = envInt("GENERATIO_RETENTION_DAYS", cfg.RetentionDays)
+	cfg.RetentionAction = envString("GENERATIO_RETENTION_ACTION", cfg.RetentionAction)
+	cfg.TrashDays = envInt("GENERATIO_TRASH_DAYS", cfg.TrashDays)
+	cfg.ImageCacheDir = envString("GENERATIO_IMAGE_CACHE_DIR", cfg.ImageCacheDir)
+	cfg.AllowPrivateImageURLs = envBool("GENERATIO_ALLOW_PRIVATE_IMAGE_URLS", cfg.AllowPrivateImageURLs)
+	cfg.ThumbnailSizes = envIntList("GENERATIO_THUMBNAIL_SIZES", cfg.ThumbnailSizes)
+	cfg.ThumbnailFormats = envList("GENERATIO_THUMBNAIL_FORMATS", cfg.ThumbnailFormats)
+	cfg.ShareSecret = envString("GENERATIO_SHARE_SECRET", cfg.ShareSecret)
+	cfg.DefaultPriority = envString("GENERATIO_DEFAULT_PRIORITY", cfg.DefaultPriority)
+	cfg.TierPriorities = envMap("GENERATIO_TIER_PRIORITIES", cfg.TierPriorities)
+	cfg.ModelProbeInterval = envDuration("GENERATIO_MODEL_PROBE_INTERVAL", cfg.ModelProbeInterval)
+	cfg.ReconcileInterval = envDuration("GENERATIO_RECONCILE_INTERVAL", cfg.ReconcileInterval)
+	cfg.ReconcileAutoFix = envBool("GENERATIO_RECONCILE_AUTOFIX", cfg.ReconcileAutoFix)
+	cfg.ExchangeRatesURL = envString("GENERATIO_EXCHANGE_RATES_URL", cfg.ExchangeRatesURL)
+	cfg.ExchangeRates = envFloatMap("GENERATIO_EXCHANGE_RATES", cfg.ExchangeRates)
+	cfg.AnomalyFactor = envFloat("GENERATIO_ANOMALY_FACTOR", cfg.AnomalyFactor)
+	cfg.AnomalyMinSpend = envFloat("GENERATIO_ANOMALY_MIN_SPEND", cfg.AnomalyMinSpend)
+	cfg.AnomalyInterval = envDuration("GENERATIO_ANOMALY_INTERVAL", cfg.AnomalyInterval)
+	cfg.KeyHealthInterval = envDuration("GENERATIO_KEY_HEALTH_INTERVAL", cfg.KeyHealthInterval)
+	cfg.GenerationRateLimit = envInt("GENERATIO_RATE_LIMIT", cfg.GenerationRateLimit)
+	cfg.GenerationRateWindow = envDuration("GENERATIO_RATE_LIMIT_WINDOW", cfg.GenerationRateWindow)
+	cfg.MaxBodyBytes = envInt("GENERATIO_MAX_BODY_BYTES", cfg.MaxBodyBytes)
+	cfg.MaxUploadBytes = envInt("GENERATIO_MAX_UPLOAD_BYTES", cfg.MaxUploadBytes)
+	cfg.StrictJSON = envBool("GENERATIO_STRICT_JSON", cfg.StrictJSON)
+	cfg.RenewSessionOnGeneration = envBool("GENERATIO_SESSION_RENEWAL", cfg.RenewSessionOnGeneration)
+	cfg.MaxRememberDeviceDays = envInt("GENERATIO_REMEMBER_DEVICE_MAX_DAYS", cfg.MaxRememberDeviceDays)
+	cfg.SessionCookie = envBool("GENERATIO_SESSION_COOKIE", cfg.SessionCookie)
+	cfg.SessionCookieSameSite = envString("GENERATIO_SESSION_COOKIE_SAMESITE", cfg.SessionCookieSameSite)
+	cfg.MaxSessions = envInt("GENERATIO_MAX_SESSIONS", cfg.MaxSessions)
+	cfg.StartupConnectivityCheck = envBool("GENERATIO_STARTUP_CONNECTIVITY_CHECK", cfg.StartupConnectivityCheck)
+	cfg.FALProxyURL = envString("GENERATIO_FAL_PROXY", cfg.FALProxyURL)
+	cfg.FALBaseURL = envString("GENERATIO_FAL_BASE_URL", cfg.FALBaseURL)
+	cfg.FALCAFile = envString("GENERATIO_FAL_CA_FILE", cfg.FALCAFile)
+	cfg.FALClientCertFile = envString("GENERATIO_FAL_CLIENT_CERT", cfg.FALClientCertFile)
+	cfg.FALClientKeyFile = envString("GENERATIO_FAL_CLIENT_KEY", cfg.FALClientKeyFile)
+	cfg.FakeFAL = envBool("GENERATIO_FAKE_FAL", cfg.FakeFAL)
+	cfg.DuplicateWindow = envDuration("GENERATIO_DUPLICATE_WINDOW", cfg.DuplicateWindow)
+	cfg.DuplicateAction = envString("GENERATIO_DUPLICATE_ACTION", cfg.DuplicateAction)
+	cfg.CoalesceSeeded = envBool("GENERATIO_COALESCE_SEEDED", cfg.CoalesceSeeded)
+	cfg.ResultCacheTTL = envDuration("GENERATIO_RESULT_CACHE_TTL", cfg.ResultCacheTTL)
+	cfg.MaxGenerationWait = envDuration("GENERATIO_MAX_GENERATION_WAIT", cfg.MaxGenerationWait)
+	cfg.GenerationJobTTL = envDuration("GENERATIO_GENERATION_JOB_TTL", cfg.GenerationJobTTL)
+	cfg.RecentErrors = envInt("GENERATIO_RECENT_ERRORS", cfg.RecentErrors)
+	cfg.CDNProvider = envString("GENERATIO_CDN_PROVIDER", cfg.CDNProvider)
+	cfg.CDNZone = envString("GENERATIO_CDN_ZONE", cfg.CDNZone)
+	cfg.CDNToken = envString("GENERATIO_CDN_TOKEN", cfg.CDNToken)
+	cfg.EmbeddingProvider = envString("GENERATIO_EMBEDDING_PROVIDER", cfg.EmbeddingProvider)
+	cfg.EmbeddingEndpoint = envString("GENERATIO_EMBEDDING_ENDPOINT", cfg.EmbeddingEndpoint)
+	cfg.EmbeddingInterval = envDuration("GENERATIO_EMBEDDING_INTERVAL", cfg.EmbeddingInterval)
+	cfg.CaptionProvider = envString("GENERATIO_CAPTION_PROVIDER", cfg.CaptionProvider)
+	cfg.CaptionEndpoint = envString("GENERATIO_CAPTION_ENDPOINT", cfg.CaptionEndpoint)
+	cfg.KDFWorkers = envInt("GENERATIO_KDF_WORKERS", cfg.KDFWorkers)
+	cfg.VerificationCacheTTL = envDuration("GENERATIO_VERIFICATION_CACHE_TTL", cfg.VerificationCacheTTL)
+	cfg.LogRequests = envBool("GENERATIO_LOG_REQUESTS", cfg.LogRequests)
+
+	return cfg
+}
+
+// envString reads a string variable, falling back when unset
+func envString(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return strings.TrimSpace(value)
+	}
+	return fallback
+}
+
+// envFloat reads a float variable, falling back when unset or invalid
+func envFloat(key string, fallback float64) float64 {
+	if value, ok := os.LookupEnv(key); ok {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+			return f
+		}
+	}
+	return fallback
+}
+
+// envInt reads an integer variable, falling back when unset or invalid
+func envInt(key string, fallback int) int {
+	if value, ok := os.LookupEnv(key); ok {
+		if i, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
+// envFloatMap reads a comma-separated list of key=number pairs, falling back
+// when unset. Pairs with invalid numbers are ignored.
+func envFloatMap(key string, fallback map[string]float64) map[string]float64 {
+	pairs := envMap(key, nil)
+	if pairs == nil {
+		return fallback
+	}
+
+	values := make(map[string]float64, len(pairs))
+	for name, value := range pairs {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			values[name] = f
+		}
+	}
+	return values
+}
+
+// envBool reads a boolean variable, falling back when unset or invalid
+func envBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		if b, err := strconv.ParseBool(strings.TrimSpace(value)); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
+// envDuration reads a duration such as "5m", falling back when unset or invalid
+func envDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		if d, err := time.ParseDuration(strings.TrimSpace(value)); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+// envList reads a comma-separated variable, falling back when unset
+func envList(key string, fallback []string) []string {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// envIntList reads a comma-separated list of integers, falling back when unset or invalid
+func envIntList(key string, fallback []int) []int {
+	items := envList(key, nil)
+	if items == nil {
+		return fallback
+	}
+
+	values := make([]int, 0, len(items))
+	for _, item := range items {
+		i, err := strconv.Atoi(item)
+		if err != nil {
+			return fallback
+		}
+		values = append(values, i)
+	}
+	return values
+}
+
+// envMap reads a comma-separated list of key=value pairs, falling back when unset.
+// Pairs without a key or value are ignored.
+func envMap(key string, fallback map[string]string) map[string]string {
+	items := envList(key, nil)
+	if items == nil {
+		return fallback
+	}
+
+	values := make(map[string]string, len(items))
+	for _, item := range items {
+		name, value, ok := strings.Cut(item, "=")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if ok && name != "" && value != "" {
+			values[name] = value
+		}
+	}
+	return values
+}
@@ -0,0 +1,189 @@
+package contentfilter
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// TermsCollection stores admin-edited block and allow list entries
+const TermsCollection = "content_filter_terms"
+
+// Filter serves the deployment's content policy. Admin-edited terms from the
+// database are layered over the built-in defaults, and the compiled policy is
+// cached until the terms collection changes.
+type Filter struct {
+	app        core.App
+	strictness string
+
+	mutex  sync.RWMutex
+	policy *Policy
+}
+
+// NewFilter creates a filter at the given strictness and invalidates its
+// cache whenever a term record is created, updated or deleted
+func NewFilter(app core.App, strictness string) *Filter {
+	if !ValidStrictness(strictness) {
+		strictness = StrictnessStandard
+	}
+
+	f := &Filter{
+		app:        app,
+		strictness: strictness,
+	}
+
+	invalidate := func(e *core.RecordEvent) error {
+		f.Invalidate()
+		return e.Next()
+	}
+	app.OnRecordAfterCreateSuccess(TermsCollection).BindFunc(invalidate)
+	app.OnRecordAfterUpdateSuccess(TermsCollection).BindFunc(invalidate)
+	app.OnRecordAfterDeleteSuccess(TermsCollection).BindFunc(invalidate)
+
+	return f
+}
+
+// Strictness returns the deployment's strictness level
+func (f *Filter) Strictness() string {
+	return f.strictness
+}
+
+// Evaluate checks a prompt against the current policy
+func (f *Filter) Evaluate(prompt string) *Decision {
+	return f.Policy().Evaluate(prompt)
+}
+
+// Policy returns the compiled policy, loading it on first use
+func (f *Filter) Policy() *Policy {
+	f.mutex.RLock()
+	policy := f.policy
+	f.mutex.RUnlock()
+	if policy != nil {
+		return policy
+	}
+
+	terms := DefaultTerms()
+	custom, err := f.Terms()
+	if err != nil {
+		f.app.Logger().Warn("Failed to load content filter terms, using defaults", "error", err)
+	}
+	terms = append(terms, custom...)
+
+	// An allow entry that exactly matches a block term removes it, so admins
+	// can switch off built-in terms without a code change
+	terms = dropAllowedTerms(terms)
+
+	policy = NewPolicy(f.strictness, terms)
+
+	f.mutex.Lock()
+	f.policy = policy
+	f.mutex.Unlock()
+
+	return policy
+}
+
+// Invalidate drops the cached policy so the next evaluation reloads terms
+func (f *Filter) Invalidate() {
+	f.mutex.Lock()
+	f.policy = nil
+	f.mutex.Unlock()
+}
+
+// Terms returns the admin-edited terms stored in the database
+func (f *Filter) Terms() ([]Term, error) {
+	if _, err := f.app.FindCollectionByNameOrId(TermsCollection); err != nil {
+		return nil, nil
+	}
+
+	records, err := f.app.FindRecordsByFilter(TermsCollection, "", "kind,term", 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch terms: %w", err)
+	}
+
+	terms := make([]Term, 0, len(records))
+	for _, record := range records {
+		terms = append(terms, Term{
+			ID:       record.Id,
+			Term:     record.GetString("term"),
+			Kind:     record.GetString("kind"),
+			Severity: record.GetString("severity"),
+		})
+	}
+	return terms, nil
+}
+
+// AddTerm validates and stores a new term
+func (f *Filter) AddTerm(term Term) (*Term, error) {
+	term.Term = strings.ToLower(strings.TrimSpace(term.Term))
+	if term.Term == "" {
+		return nil, fmt.Errorf("term cannot be empty")
+	}
+	if len(term.Term) > 100 {
+		return nil, fmt.Errorf("term cannot exceed 100 characters")
+	}
+
+	switch term.Kind {
+	case KindBlock:
+		if term.Severity == "" {
+			term.Severity = SeverityMedium
+		}
+		if !ValidSeverity(term.Severity) {
+			return nil, fmt.Errorf("severity must be one of: low, medium, high")
+		}
+	case KindAllow:
+		term.Severity = ""
+	default:
+		return nil, fmt.Errorf("kind must be one of: block, allow")
+	}
+
+	collection, err := f.app.FindCollectionByNameOrId(TermsCollection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find terms collection: %w", err)
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("term", term.Term)
+	record.Set("kind", term.Kind)
+	record.Set("severity", term.Severity)
+
+	if err := f.app.Save(record); err != nil {
+		return nil, fmt.Errorf("failed to save term: %w", err)
+	}
+
+	term.ID = record.Id
+	return &term, nil
+}
+
+// DeleteTerm removes a stored term
+func (f *Filter) DeleteTerm(id string) error {
+	record, err := f.app.FindRecordById(TermsCollection, id)
+	if err != nil {
+		return fmt.Errorf("term not found")
+	}
+
+	if err := f.app.Delete(record); err != nil {
+		return fmt.Errorf("failed to delete term: %w", err)
+	}
+	return nil
+}
+
+// dropAllowedTerms removes block terms that also appear verbatim on the allow list
+func dropAllowedTerms(terms []Term) []Term {
+	allowed := make(map[string]bool)
+	for _, term := range terms {
+		if term.Kind == KindAllow {
+			allowed[strings.ToLower(strings.TrimSpace(term.Term))] = true
+		}
+	}
+
+	result := terms[:0:0]
+	for _, term := range terms {
+		if term.Kind != KindAllow && allowed[strings.ToLower(strings.TrimSpace(term.Term))] {
+			continue
+		}
+		result = append(result, term)
+	}
+	return result
+}
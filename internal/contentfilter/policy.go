@@ -0,0 +1,243 @@
+package contentfilter
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Strictness levels control which term severities block a prompt
+const (
+	StrictnessOff      = "off"
+	StrictnessLenient  = "lenient"  // Blocks high severity terms only
+	StrictnessStandard = "standard" // Blocks medium and high severity terms
+	StrictnessStrict   = "strict"   // Blocks every listed term
+)
+
+// Term severities
+const (
+	SeverityLow    = "low"
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+// Term kinds
+const (
+	KindBlock = "block"
+	KindAllow = "allow"
+)
+
+// Term is a single block or allow list entry
+type Term struct {
+	ID       string `json:"id,omitempty"`
+	Term     string `json:"term"`
+	Kind     string `json:"kind"`
+	Severity string `json:"severity,omitempty"` // Block terms only
+}
+
+// Match describes a blocked term found in a prompt
+type Match struct {
+	Term     string `json:"term"`
+	Severity string `json:"severity"`
+	Position int    `json:"position"`
+	Blocking bool   `json:"blocking"` // False when the strictness level tolerates this severity
+}
+
+// Decision is the outcome of evaluating a prompt against a policy
+type Decision struct {
+	Allowed    bool     `json:"allowed"`
+	Strictness string   `json:"strictness"`
+	Matches    []Match  `json:"matches"`
+	AllowedBy  []string `json:"allowed_by,omitempty"` // Allow phrases that neutralised a block term
+}
+
+// Reason summarises why a prompt was rejected
+func (d *Decision) Reason() string {
+	var terms []string
+	for _, m := range d.Matches {
+		if m.Blocking {
+			terms = append(terms, m.Term)
+		}
+	}
+	if len(terms) == 0 {
+		return ""
+	}
+	return "prompt contains blocked terms: " + strings.Join(terms, ", ")
+}
+
+// Policy evaluates prompts using whole-word matching so benign words
+// containing a blocked term (e.g. "skill" for "kill") are not rejected
+type Policy struct {
+	strictness string
+	block      []compiledTerm
+	allow      []compiledTerm
+}
+
+type compiledTerm struct {
+	text     string
+	severity string
+	pattern  *regexp.Regexp
+}
+
+// NewPolicy compiles the given terms for the strictness level
+func NewPolicy(strictness string, terms []Term) *Policy {
+	if !ValidStrictness(strictness) {
+		strictness = StrictnessStandard
+	}
+
+	p := &Policy{strictness: strictness}
+	for _, term := range terms {
+		text := strings.ToLower(strings.TrimSpace(term.Term))
+		if text == "" {
+			continue
+		}
+
+		compiled := compiledTerm{
+			text:     text,
+			severity: term.Severity,
+			pattern:  regexp.MustCompile(`\b` + regexp.QuoteMeta(text) + `\b`),
+		}
+		if term.Kind == KindAllow {
+			p.allow = append(p.allow, compiled)
+		} else {
+			p.block = append(p.block, compiled)
+		}
+	}
+	return p
+}
+
+// Strictness returns the policy's strictness level
+func (p *Policy) Strictness() string {
+	return p.strictness
+}
+
+// Evaluate checks a prompt and explains the result
+func (p *Policy) Evaluate(prompt string) *Decision {
+	decision := &Decision{
+		Allowed:    true,
+		Strictness: p.strictness,
+		Matches:    []Match{},
+	}
+	if p.strictness == StrictnessOff {
+		return decision
+	}
+
+	lower := strings.ToLower(prompt)
+
+	// Spans covered by allow phrases neutralise block terms inside them
+	var allowed [][]int
+	for _, term := range p.allow {
+		allowed = append(allowed, term.pattern.FindAllStringIndex(lower, -1)...)
+	}
+
+	usedAllow := make(map[string]bool)
+	for _, term := range p.block {
+		for _, span := range term.pattern.FindAllStringIndex(lower, -1) {
+			if phrase, covered := coveredBy(span, allowed, lower); covered {
+				usedAllow[phrase] = true
+				continue
+			}
+
+			blocking := blocks(p.strictness, term.severity)
+			decision.Matches = append(decision.Matches, Match{
+				Term:     term.text,
+				Severity: term.severity,
+				Position: span[0],
+				Blocking: blocking,
+			})
+			if blocking {
+				decision.Allowed = false
+			}
+		}
+	}
+
+	for phrase := range usedAllow {
+		decision.AllowedBy = append(decision.AllowedBy, phrase)
+	}
+	sort.Strings(decision.AllowedBy)
+	sort.Slice(decision.Matches, func(i, j int) bool {
+		return decision.Matches[i].Position < decision.Matches[j].Position
+	})
+
+	return decision
+}
+
+// coveredBy reports whether span lies inside an allow phrase occurrence
+func coveredBy(span []int, allowed [][]int, lower string) (string, bool) {
+	for _, a := range allowed {
+		if span[0] >= a[0] && span[1] <= a[1] {
+			return lower[a[0]:a[1]], true
+		}
+	}
+	return "", false
+}
+
+// blocks reports whether a severity blocks at the given strictness
+func blocks(strictness, severity string) bool {
+	switch strictness {
+	case StrictnessStrict:
+		return true
+	case StrictnessStandard:
+		return severity == SeverityMedium || severity == SeverityHigh || severity == ""
+	case StrictnessLenient:
+		return severity == SeverityHigh
+	default:
+		return false
+	}
+}
+
+// ValidStrictness reports whether s is a known strictness level
+func ValidStrictness(s string) bool {
+	switch s {
+	case StrictnessOff, StrictnessLenient, StrictnessStandard, StrictnessStrict:
+		return true
+	}
+	return false
+}
+
+// ValidSeverity reports whether s is a known severity
+func ValidSeverity(s string) bool {
+	switch s {
+	case SeverityLow, SeverityMedium, SeverityHigh:
+		return true
+	}
+	return false
+}
+
+// DefaultTerms is the built-in policy used until an admin edits the lists.
+// Broad words that are usually benign ("death", "drug") are low severity so
+// only strict deployments block them.
+func DefaultTerms() []Term {
+	block := map[string][]string{
+		SeverityHigh: {
+			"suicide", "self-harm", "torture", "bomb", "explosive",
+			"porn", "nude", "naked", "nsfw", "nazi", "terrorist",
+		},
+		SeverityMedium: {
+			"murder", "kill", "abuse", "assault", "sex", "erotic",
+			"racist", "extremist", "social security", "credit card", "private key",
+		},
+		SeverityLow: {
+			"violence", "death", "weapon", "adult", "hate", "drug",
+			"illegal", "piracy", "fraud", "scam", "hack", "ssn", "password",
+		},
+	}
+
+	var terms []Term
+	for _, severity := range []string{SeverityHigh, SeverityMedium, SeverityLow} {
+		for _, term := range block[severity] {
+			terms = append(terms, Term{Term: term, Kind: KindBlock, Severity: severity})
+		}
+	}
+
+	for _, phrase := range []string{"drug store", "killer whale", "death valley", "adult supervision"} {
+		terms = append(terms, Term{Term: phrase, Kind: KindAllow})
+	}
+
+	return terms
+}
+
+// Default returns the built-in policy at standard strictness
+func Default() *Policy {
+	return NewPolicy(StrictnessStandard, DefaultTerms())
+}
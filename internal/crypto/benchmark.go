@@ -0,0 +1,247 @@
+package crypto
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// Benchmark limits, so a request cannot tie up the server for long
+const (
+	DefaultBenchmarkSamples = 3
+	MaxBenchmarkSamples     = 10
+	MaxBenchmarkProfiles    = 12
+	DefaultTargetMS         = 250
+	MaxTargetMS             = 10000
+
+	// MaxBenchmarkIterations caps the PBKDF2 iterations one run may spend
+	// over all profiles and samples, encrypting and decrypting, which keeps
+	// a run to seconds rather than minutes
+	MaxBenchmarkIterations = 20000000
+)
+
+// benchmarkToken stands in for a FAL AI key; only its length matters
+const benchmarkToken = "00000000-0000-0000-0000-000000000000:00000000000000000000000000000000"
+
+// DefaultProfiles are the settings benchmarked when none are given: PBKDF2 up
+// to the 600,000 iterations OWASP recommends for SHA-256. Stored keys record
+// only their salt, so PBKDF2 is the one KDF the service can be tuned to.
+func DefaultProfiles() []KDFParams {
+	return []KDFParams{
+		{KDF: KDFPBKDF2, Iterations: 100000},
+		{KDF: KDFPBKDF2, Iterations: 210000},
+		{KDF: KDFPBKDF2, Iterations: 310000},
+		{KDF: KDFPBKDF2, Iterations: 600000},
+	}
+}
+
+// Validate checks that the settings name a known KDF at a sane cost
+func (p KDFParams) Validate() error {
+	if p.KDF != KDFPBKDF2 {
+		return fmt.Errorf("unknown kdf %q (use %s)", p.KDF, KDFPBKDF2)
+	}
+	if p.Iterations < 1000 || p.Iterations > 10000000 {
+		return fmt.Errorf("pbkdf2-sha256 iterations must be between 1000 and 10000000")
+	}
+	return nil
+}
+
+// MeetsGuidance reports whether the settings reach OWASP's password storage
+// recommendation of 600,000 PBKDF2-SHA256 iterations
+func (p KDFParams) MeetsGuidance() bool {
+	return p.KDF == KDFPBKDF2 && p.Iterations >= 600000
+}
+
+// BenchmarkOptions selects what a benchmark measures
+type BenchmarkOptions struct {
+	Profiles []KDFParams `json:"profiles,omitempty"`  // defaults to DefaultProfiles
+	Samples  int         `json:"samples,omitempty"`   // runs per profile; the median is reported
+	TargetMS int         `json:"target_ms,omitempty"` // acceptable decrypt (login) latency
+}
+
+// Normalize fills in defaults and validates the options
+func (o *BenchmarkOptions) Normalize() error {
+	if len(o.Profiles) == 0 {
+		o.Profiles = DefaultProfiles()
+	}
+	if len(o.Profiles) > MaxBenchmarkProfiles {
+		return fmt.Errorf("at most %d profiles can be benchmarked at once", MaxBenchmarkProfiles)
+	}
+	for _, profile := range o.Profiles {
+		if err := profile.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if o.Samples == 0 {
+		o.Samples = DefaultBenchmarkSamples
+	}
+	if o.Samples < 1 || o.Samples > MaxBenchmarkSamples {
+		return fmt.Errorf("samples must be between 1 and %d", MaxBenchmarkSamples)
+	}
+
+	if o.TargetMS == 0 {
+		o.TargetMS = DefaultTargetMS
+	}
+	if o.TargetMS < 1 || o.TargetMS > MaxTargetMS {
+		return fmt.Errorf("target_ms must be between 1 and %d", MaxTargetMS)
+	}
+
+	// Each sample derives a key twice, once to encrypt and once to decrypt
+	var iterations int
+	for _, profile := range o.Profiles {
+		iterations += 2 * profile.Iterations * o.Samples
+	}
+	if iterations > MaxBenchmarkIterations {
+		return fmt.Errorf("profiles and samples add up to %d iterations; at most %d can be benchmarked at once", iterations, MaxBenchmarkIterations)
+	}
+	return nil
+}
+
+// BenchmarkResult is the median latency of one profile
+type BenchmarkResult struct {
+	KDFParams
+	EncryptMS     float64 `json:"encrypt_ms"`
+	DecryptMS     float64 `json:"decrypt_ms"` // paid on every login and session creation
+	MeetsGuidance bool    `json:"meets_guidance"`
+	Current       bool    `json:"current,omitempty"`
+}
+
+// Recommendation is the strongest setting of a KDF within the latency target
+type Recommendation struct {
+	KDFParams
+	DecryptMS float64 `json:"decrypt_ms"`
+	Reason    string  `json:"reason"`
+}
+
+// BenchmarkReport describes how key derivation performs on this machine
+type BenchmarkReport struct {
+	Current         KDFParams         `json:"current"`
+	CPUs            int               `json:"cpus"`
+	Arch            string            `json:"arch"`
+	Samples         int               `json:"samples"`
+	TargetMS        int               `json:"target_ms"`
+	Results         []BenchmarkResult `json:"results"`
+	Recommendations []Recommendation  `json:"recommendations"`
+	Note            string            `json:"note"`
+}
+
+// Benchmark times a full Encrypt and Decrypt of a FAL AI key with every
+// profile. Profiles run one after another, so the numbers reflect a single
+// login on an otherwise idle server. Derivations take the same workers as
+// logins, so a benchmark never adds to the configured CPU limit.
+func (e *EncryptionService) Benchmark(opts BenchmarkOptions) (*BenchmarkReport, error) {
+	if err := opts.Normalize(); err != nil {
+		return nil, err
+	}
+
+	report := &BenchmarkReport{
+		Current:  e.params,
+		CPUs:     runtime.NumCPU(),
+		Arch:     runtime.GOARCH,
+		Samples:  opts.Samples,
+		TargetMS: opts.TargetMS,
+		Results:  make([]BenchmarkResult, 0, len(opts.Profiles)),
+		Note:     "Stored FAL AI keys are encrypted with the current settings; keys encrypted before a change can no longer be decrypted, so users must set them up again",
+	}
+
+	for _, profile := range opts.Profiles {
+		result, err := e.benchmarkProfile(profile, opts.Samples)
+		if err != nil {
+			return nil, err
+		}
+		result.Current = profile == e.params
+		report.Results = append(report.Results, *result)
+	}
+	report.Recommendations = Recommend(report.Results, time.Duration(opts.TargetMS)*time.Millisecond)
+
+	return report, nil
+}
+
+func (e *EncryptionService) benchmarkProfile(profile KDFParams, samples int) (*BenchmarkResult, error) {
+	service := &EncryptionService{params: profile, workers: e.workers}
+
+	encrypts := make([]float64, 0, samples)
+	decrypts := make([]float64, 0, samples)
+	for i := 0; i < samples; i++ {
+		start := time.Now()
+		encrypted, err := service.Encrypt(benchmarkToken, "benchmark password")
+		if err != nil {
+			return nil, fmt.Errorf("benchmark encryption failed: %w", err)
+		}
+		encrypts = append(encrypts, milliseconds(time.Since(start)))
+
+		start = time.Now()
+		if _, err := service.Decrypt(encrypted.Encrypted, encrypted.Salt, "benchmark password"); err != nil {
+			return nil, fmt.Errorf("benchmark decryption failed: %w", err)
+		}
+		decrypts = append(decrypts, milliseconds(time.Since(start)))
+	}
+
+	return &BenchmarkResult{
+		KDFParams:     profile,
+		EncryptMS:     median(encrypts),
+		DecryptMS:     median(decrypts),
+		MeetsGuidance: profile.MeetsGuidance(),
+	}, nil
+}
+
+// Recommend picks, per KDF, the costliest benchmarked profile whose decrypt
+// latency is within target. A KDF with no profile within target is
+// recommended at its fastest profile, flagged as over the target.
+func Recommend(results []BenchmarkResult, target time.Duration) []Recommendation {
+	targetMS := milliseconds(target)
+
+	best := map[string]BenchmarkResult{}
+	var kdfs []string
+	for _, result := range results {
+		current, seen := best[result.KDF]
+		if !seen {
+			kdfs = append(kdfs, result.KDF)
+			best[result.KDF] = result
+			continue
+		}
+		within, currentWithin := result.DecryptMS <= targetMS, current.DecryptMS <= targetMS
+		switch {
+		case within && (!currentWithin || result.DecryptMS > current.DecryptMS):
+			best[result.KDF] = result
+		case !within && !currentWithin && result.DecryptMS < current.DecryptMS:
+			best[result.KDF] = result
+		}
+	}
+
+	recommendations := make([]Recommendation, 0, len(kdfs))
+	for _, kdf := range kdfs {
+		result := best[kdf]
+		var reason string
+		switch {
+		case result.DecryptMS > targetMS:
+			reason = fmt.Sprintf("No benchmarked setting decrypts within %.0f ms; this is the fastest", targetMS)
+		case result.MeetsGuidance:
+			reason = fmt.Sprintf("Strongest setting within %.0f ms; meets OWASP guidance", targetMS)
+		default:
+			reason = fmt.Sprintf("Strongest setting within %.0f ms, but below OWASP guidance", targetMS)
+		}
+		recommendations = append(recommendations, Recommendation{
+			KDFParams: result.KDFParams,
+			DecryptMS: result.DecryptMS,
+			Reason:    reason,
+		})
+	}
+	return recommendations
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
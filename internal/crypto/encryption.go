@@ -3,11 +3,15 @@ package crypto
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
 
 	"golang.org/x/crypto/pbkdf2"
 )
@@ -15,14 +19,28 @@ import (
 const (
 	// PBKDF2 parameters
 	DefaultIterations = 100000
-	SaltSize         = 32
-	KeySize          = 32 // AES-256
-	NonceSize        = 12 // GCM standard nonce size
+	SaltSize          = 32
+	KeySize           = 32 // AES-256
+	NonceSize         = 12 // GCM standard nonce size
 )
 
-// EncryptionService provides AES-256-GCM encryption with PBKDF2 key derivation
+// KDFPBKDF2 is the key derivation function stored keys are encrypted with
+const KDFPBKDF2 = "pbkdf2-sha256"
+
+// KDFParams selects a password-based key derivation function and its cost
+type KDFParams struct {
+	KDF        string `json:"kdf"`
+	Iterations int    `json:"iterations"`
+}
+
+// EncryptionService provides AES-256-GCM encryption with password-based key derivation
 type EncryptionService struct {
-	iterations int
+	params KDFParams
+
+	// workers bounds concurrent key derivations, so a burst of logins queues
+	// instead of starving every other request of CPU
+	workers     chan struct{}
+	derivations atomic.Uint64
 }
 
 // NewEncryptionService creates a new encryption service with specified PBKDF2 iterations
@@ -31,8 +49,28 @@ func NewEncryptionService(iterations int) *EncryptionService {
 		iterations = DefaultIterations
 	}
 	return &EncryptionService{
-		iterations: iterations,
+		params:  KDFParams{KDF: KDFPBKDF2, Iterations: iterations},
+		workers: make(chan struct{}, runtime.NumCPU()),
+	}
+}
+
+// SetWorkers sets how many key derivations may run at once (0 uses one per
+// CPU). It must be called before the service is used.
+func (e *EncryptionService) SetWorkers(workers int) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
 	}
+	e.workers = make(chan struct{}, workers)
+}
+
+// Derivations returns how many keys the service has derived
+func (e *EncryptionService) Derivations() uint64 {
+	return e.derivations.Load()
+}
+
+// Params returns the key derivation settings the service encrypts with
+func (e *EncryptionService) Params() KDFParams {
+	return e.params
 }
 
 // EncryptResult contains the encrypted data and salt
@@ -102,25 +140,118 @@ func (e *EncryptionService) Decrypt(encrypted, salt, password string) (string, e
 		return "", errors.New("password cannot be empty")
 	}
 
-	// Decode from base64
-	ciphertext, err := base64.StdEncoding.DecodeString(encrypted)
+	// Reject malformed data before paying for key derivation
+	ciphertext, err := decodeCiphertext(encrypted)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode encrypted data: %w", err)
+		return "", err
+	}
+
+	key, err := e.DeriveKey(salt, password)
+	if err != nil {
+		return "", err
+	}
+	defer ClearMemory(key)
+
+	return open(ciphertext, key)
+}
+
+// DeriveKey derives the key Encrypt used for salt from password. Holding on
+// to it lets DecryptWithKey decrypt again without another derivation.
+func (e *EncryptionService) DeriveKey(salt, password string) ([]byte, error) {
+	if salt == "" {
+		return nil, errors.New("salt cannot be empty")
+	}
+	if password == "" {
+		return nil, errors.New("password cannot be empty")
 	}
 
 	saltBytes, err := base64.StdEncoding.DecodeString(salt)
 	if err != nil {
-		return "", fmt.Errorf("failed to decode salt: %w", err)
+		return nil, fmt.Errorf("failed to decode salt: %w", err)
+	}
+	return e.deriveKey([]byte(password), saltBytes), nil
+}
+
+// DecryptWithKey decrypts ciphertext with a key returned by DeriveKey
+func (e *EncryptionService) DecryptWithKey(encrypted string, key []byte) (string, error) {
+	if encrypted == "" {
+		return "", errors.New("encrypted data cannot be empty")
+	}
+	if len(key) != KeySize {
+		return "", errors.New("invalid key size")
+	}
+
+	ciphertext, err := decodeCiphertext(encrypted)
+	if err != nil {
+		return "", err
+	}
+	return open(ciphertext, key)
+}
+
+// Unsealed is the outcome of decrypting one EncryptResult
+type Unsealed struct {
+	Plaintext string
+	Key       []byte // the derived key, for reuse with DecryptWithKey
+	Err       error
+}
+
+// DecryptAll decrypts values encrypted with the same password, deriving their
+// keys in parallel. Values sharing a salt derive their key once.
+func (e *EncryptionService) DecryptAll(password string, values ...EncryptResult) []Unsealed {
+	results := make([]Unsealed, len(values))
+
+	bySalt := make(map[string][]int)
+	for i, value := range values {
+		bySalt[value.Salt] = append(bySalt[value.Salt], i)
+	}
+
+	var wg sync.WaitGroup
+	for salt, indexes := range bySalt {
+		wg.Add(1)
+		go func(salt string, indexes []int) {
+			defer wg.Done()
+
+			key, err := e.DeriveKey(salt, password)
+			for _, i := range indexes {
+				if err != nil {
+					results[i].Err = err
+					continue
+				}
+				results[i].Key = key
+				results[i].Plaintext, results[i].Err = e.DecryptWithKey(values[i].Encrypted, key)
+			}
+		}(salt, indexes)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// PasswordCheck fingerprints password under a derived key. Kept next to the
+// key, it tells whether a later request supplied the same password without
+// deriving the key again.
+func PasswordCheck(key []byte, password string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(password))
+	return mac.Sum(nil)
+}
+
+// decodeCiphertext decodes stored ciphertext and checks it can hold a nonce and tag
+func decodeCiphertext(encrypted string) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encrypted data: %w", err)
 	}
 
 	// Validate minimum length (nonce + at least some data + tag)
 	if len(ciphertext) < NonceSize+16 { // 16 is GCM tag size
-		return "", errors.New("ciphertext too short")
+		return nil, errors.New("ciphertext too short")
 	}
+	return ciphertext, nil
+}
 
-	// Derive key from password and salt
-	key := e.deriveKey([]byte(password), saltBytes)
-
+// open authenticates and decrypts nonce-prefixed ciphertext with key
+func open(ciphertext, key []byte) (string, error) {
 	// Create AES cipher
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -146,9 +277,14 @@ func (e *EncryptionService) Decrypt(encrypted, salt, password string) (string, e
 	return string(plaintext), nil
 }
 
-// deriveKey derives a key from password and salt using PBKDF2-SHA256
+// deriveKey derives a key from password and salt using PBKDF2-SHA256, waiting
+// for a free worker first
 func (e *EncryptionService) deriveKey(password, salt []byte) []byte {
-	return pbkdf2.Key(password, salt, e.iterations, KeySize, sha256.New)
+	e.workers <- struct{}{}
+	defer func() { <-e.workers }()
+	e.derivations.Add(1)
+
+	return pbkdf2.Key(password, salt, e.params.Iterations, KeySize, sha256.New)
 }
 
 // generateSalt generates a cryptographically secure random salt
@@ -178,4 +314,4 @@ func ClearString(s *string) {
 	if s != nil {
 		*s = ""
 	}
-}
\ No newline at end of file
+}
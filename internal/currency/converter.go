@@ -0,0 +1,155 @@
+package currency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// USD is the canonical currency costs are stored in
+const USD = "USD"
+
+// CacheTTL is how long fetched exchange rates are reused
+const CacheTTL = 24 * time.Hour
+
+var codePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Provider supplies exchange rates as units of each currency per USD
+type Provider interface {
+	Rates(ctx context.Context) (map[string]float64, error)
+}
+
+// StaticProvider serves fixed rates, e.g. from configuration
+type StaticProvider map[string]float64
+
+// Rates returns the configured rates
+func (p StaticProvider) Rates(ctx context.Context) (map[string]float64, error) {
+	return p, nil
+}
+
+// HTTPProvider fetches rates from a JSON endpoint answering
+// {"rates": {"EUR": 0.92, ...}} for a USD base, as open.er-api.com does
+type HTTPProvider struct {
+	URL    string
+	Client *http.Client
+}
+
+// Rates fetches the current rates
+func (p *HTTPProvider) Rates(ctx context.Context) (map[string]float64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch exchange rates: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("exchange rate provider returned HTTP %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Rates map[string]float64 `json:"rates"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("failed to decode exchange rates: %w", err)
+	}
+	if len(body.Rates) == 0 {
+		return nil, fmt.Errorf("exchange rate provider returned no rates")
+	}
+	return body.Rates, nil
+}
+
+// Converter converts USD amounts using a provider's rates, fetched at most
+// once per CacheTTL. Stale rates are kept when a refresh fails.
+type Converter struct {
+	provider Provider
+
+	mutex     sync.Mutex
+	rates     map[string]float64
+	fetchedAt time.Time
+}
+
+// NewConverter creates a converter; a nil provider only supports USD
+func NewConverter(provider Provider) *Converter {
+	return &Converter{provider: provider}
+}
+
+// Normalize upper-cases a currency code and checks its form
+func Normalize(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if !codePattern.MatchString(code) {
+		return "", fmt.Errorf("currency must be a three-letter ISO 4217 code")
+	}
+	return code, nil
+}
+
+// Rate returns the units of code per USD
+func (c *Converter) Rate(ctx context.Context, code string) (float64, error) {
+	if code == USD {
+		return 1, nil
+	}
+	if c.provider == nil {
+		return 0, fmt.Errorf("no exchange rate provider is configured")
+	}
+
+	rates, err := c.currentRates(ctx)
+	if err != nil {
+		return 0, err
+	}
+	rate, exists := rates[code]
+	if !exists || rate <= 0 {
+		return 0, fmt.Errorf("no exchange rate for %s", code)
+	}
+	return rate, nil
+}
+
+// Updated returns when the cached rates were fetched
+func (c *Converter) Updated() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.fetchedAt
+}
+
+func (c *Converter) currentRates(ctx context.Context) (map[string]float64, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.rates != nil && time.Since(c.fetchedAt) < CacheTTL {
+		return c.rates, nil
+	}
+
+	rates, err := c.provider.Rates(ctx)
+	if err != nil {
+		if c.rates != nil {
+			return c.rates, nil
+		}
+		return nil, err
+	}
+
+	normalized := make(map[string]float64, len(rates))
+	for code, rate := range rates {
+		normalized[strings.ToUpper(code)] = rate
+	}
+	c.rates = normalized
+	c.fetchedAt = time.Now().UTC()
+	return c.rates, nil
+}
+
+// Convert converts a USD amount at rate, rounded to four decimal places
+func Convert(usd, rate float64) float64 {
+	return math.Round(usd*rate*10000) / 10000
+}
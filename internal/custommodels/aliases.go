@@ -0,0 +1,173 @@
+package custommodels
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"generatio-pb/internal/fal"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// AliasesCollection stores the model aliases users defined
+const AliasesCollection = "model_aliases"
+
+// MaxAliasesPerUser caps how many aliases a user can define
+const MaxAliasesPerUser = 50
+
+// ErrAliasNotFound is returned for aliases the user has not defined
+var ErrAliasNotFound = errors.New("alias not found")
+
+// Alias names never contain a slash, so they cannot shadow a model ID
+var aliasPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,39}$`)
+
+// AliasInput describes an alias to define
+type AliasInput struct {
+	Model      string                 `json:"model"`
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+// Alias names a model together with default parameters. Parameters sent with
+// a request override the alias defaults.
+type Alias struct {
+	Name       string                 `json:"name"`
+	Model      string                 `json:"model"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Updated    time.Time              `json:"updated"`
+}
+
+// Apply returns the alias's model and its defaults merged with params
+func (a *Alias) Apply(params map[string]interface{}) (string, map[string]interface{}) {
+	merged := make(map[string]interface{}, len(a.Parameters)+len(params))
+	for key, value := range a.Parameters {
+		merged[key] = value
+	}
+	for key, value := range params {
+		merged[key] = value
+	}
+	return a.Model, merged
+}
+
+// IsAliasName reports whether name has the form of an alias rather than a model ID
+func IsAliasName(name string) bool {
+	return aliasPattern.MatchString(name)
+}
+
+// SaveAlias defines or replaces userID's alias called name. target is the
+// definition of in.Model, which the defaults are checked against.
+func (r *Registry) SaveAlias(userID, name string, in AliasInput, target fal.ModelInfo) (*Alias, error) {
+	name = strings.TrimSpace(name)
+	if !IsAliasName(name) {
+		return nil, fmt.Errorf("alias names must be 1-40 lowercase letters, digits, dashes or underscores")
+	}
+	if in.Parameters == nil {
+		in.Parameters = map[string]interface{}{}
+	}
+	if _, exists := in.Parameters["prompt"]; exists {
+		return nil, fmt.Errorf("aliases cannot set the prompt")
+	}
+
+	// Validation normalizes values, so check a copy and store what was sent
+	check := make(map[string]interface{}, len(in.Parameters))
+	for key, value := range in.Parameters {
+		check[key] = value
+	}
+
+	// Required parameters may still come with each request, so only the
+	// values the alias sets are checked
+	schema := fal.ModelInfo{Parameters: make(map[string]fal.Parameter, len(target.Parameters))}
+	for key, param := range target.Parameters {
+		param.Required = false
+		schema.Parameters[key] = param
+	}
+	if err := schema.ValidateParameters(check); err != nil {
+		return nil, err
+	}
+
+	record, err := r.findAlias(userID, name)
+	if err != nil {
+		count, err := r.app.CountRecords(AliasesCollection, dbx.HashExp{"user_id": userID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to count aliases: %w", err)
+		}
+		if count >= MaxAliasesPerUser {
+			return nil, fmt.Errorf("a user can define at most %d aliases", MaxAliasesPerUser)
+		}
+
+		collection, err := r.app.FindCollectionByNameOrId(AliasesCollection)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find aliases collection: %w", err)
+		}
+		record = core.NewRecord(collection)
+		record.Set("user_id", userID)
+		record.Set("name", name)
+	}
+
+	record.Set("model", in.Model)
+	record.Set("parameters", in.Parameters)
+	if err := r.app.Save(record); err != nil {
+		return nil, fmt.Errorf("failed to save alias: %w", err)
+	}
+	return aliasFromRecord(record), nil
+}
+
+// Aliases returns userID's aliases sorted by name
+func (r *Registry) Aliases(userID string) ([]*Alias, error) {
+	records, err := r.app.FindRecordsByFilter(AliasesCollection, "user_id = {:user_id}", "name", 0, 0, map[string]any{"user_id": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch aliases: %w", err)
+	}
+
+	aliases := make([]*Alias, 0, len(records))
+	for _, record := range records {
+		aliases = append(aliases, aliasFromRecord(record))
+	}
+	return aliases, nil
+}
+
+// ResolveAlias returns userID's alias called name
+func (r *Registry) ResolveAlias(userID, name string) (*Alias, bool) {
+	if !IsAliasName(name) {
+		return nil, false
+	}
+	record, err := r.findAlias(userID, name)
+	if err != nil {
+		return nil, false
+	}
+	return aliasFromRecord(record), true
+}
+
+// DeleteAlias removes userID's alias called name
+func (r *Registry) DeleteAlias(userID, name string) error {
+	record, err := r.findAlias(userID, name)
+	if err != nil {
+		return err
+	}
+	if err := r.app.Delete(record); err != nil {
+		return fmt.Errorf("failed to delete alias: %w", err)
+	}
+	return nil
+}
+
+func (r *Registry) findAlias(userID, name string) (*core.Record, error) {
+	record, err := r.app.FindFirstRecordByFilter(AliasesCollection, "user_id = {:user_id} && name = {:name}", map[string]any{"user_id": userID, "name": name})
+	if err != nil {
+		return nil, ErrAliasNotFound
+	}
+	return record, nil
+}
+
+func aliasFromRecord(record *core.Record) *Alias {
+	alias := &Alias{
+		Name:       record.GetString("name"),
+		Model:      record.GetString("model"),
+		Parameters: map[string]interface{}{},
+		Updated:    record.GetDateTime("updated").Time(),
+	}
+	record.UnmarshalJSONField("parameters", &alias.Parameters)
+	return alias
+}
@@ -0,0 +1,321 @@
+package custommodels
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"generatio-pb/internal/fal"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Collection stores the FAL endpoints users registered as personal models
+const Collection = "custom_models"
+
+// IDPrefix namespaces custom model IDs so they never shadow built-in models
+const IDPrefix = "custom/"
+
+// Registry limits
+const (
+	MaxModelsPerUser = 25
+	MaxParameters    = 30
+	MaxCostPerImage  = 5.0
+)
+
+// ErrNotFound is returned for models that do not exist or belong to another user
+var ErrNotFound = errors.New("custom model not found")
+
+var (
+	namePattern      = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+	endpointPattern  = regexp.MustCompile(`^[A-Za-z0-9_.-]+(/[A-Za-z0-9_.-]+)+$`)
+	parameterPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+)
+
+// parameterTypes are the schema types the parameter validator understands
+var parameterTypes = []string{"integer", "float", "string", "boolean", "object"}
+
+// Input describes a model to register or replace
+type Input struct {
+	Name         string                   `json:"name"` // selected as "custom/<name>"
+	DisplayName  string                   `json:"display_name"`
+	Description  string                   `json:"description"`
+	Endpoint     string                   `json:"endpoint"` // FAL endpoint ID, e.g. "acme/sdxl-lora"
+	CostPerImage float64                  `json:"cost_per_image"`
+	Parameters   map[string]fal.Parameter `json:"parameters"`
+}
+
+// Model is a registered endpoint
+type Model struct {
+	ID           string                   `json:"id"`
+	Model        string                   `json:"model"` // the ID to generate with
+	Name         string                   `json:"name"`
+	DisplayName  string                   `json:"display_name"`
+	Description  string                   `json:"description"`
+	Endpoint     string                   `json:"endpoint"`
+	CostPerImage float64                  `json:"cost_per_image"`
+	Parameters   map[string]fal.Parameter `json:"parameters"`
+	Created      time.Time                `json:"created"`
+	Updated      time.Time                `json:"updated"`
+}
+
+// Info returns the model in the form the FAL client and the model list use
+func (m *Model) Info() fal.ModelInfo {
+	return fal.ModelInfo{
+		Name:         m.Model,
+		DisplayName:  m.DisplayName,
+		Description:  m.Description,
+		CostPerImage: m.CostPerImage,
+		Parameters:   m.Parameters,
+		Endpoint:     m.Endpoint,
+		Custom:       true,
+	}
+}
+
+// Registry stores each user's custom models
+type Registry struct {
+	app core.App
+}
+
+// NewRegistry creates a registry backed by the custom_models collection
+func NewRegistry(app core.App) *Registry {
+	return &Registry{app: app}
+}
+
+// Validate checks a model definition and fills in its defaults
+func Validate(in *Input) error {
+	in.Name = strings.TrimSpace(in.Name)
+	in.DisplayName = strings.TrimSpace(in.DisplayName)
+	in.Endpoint = strings.Trim(strings.TrimSpace(in.Endpoint), "/")
+
+	if !namePattern.MatchString(in.Name) {
+		return fmt.Errorf("name must be 1-63 lowercase letters, digits or dashes")
+	}
+	if in.DisplayName == "" {
+		in.DisplayName = in.Name
+	}
+	if len(in.DisplayName) > 100 {
+		return fmt.Errorf("display name cannot exceed 100 characters")
+	}
+	if len(in.Description) > 500 {
+		return fmt.Errorf("description cannot exceed 500 characters")
+	}
+	if !validEndpoint(in.Endpoint) {
+		return fmt.Errorf("endpoint must be a FAL endpoint ID such as \"owner/app\"")
+	}
+	if in.CostPerImage < 0 || in.CostPerImage > MaxCostPerImage {
+		return fmt.Errorf("cost per image must be between 0 and %.2f", MaxCostPerImage)
+	}
+	if len(in.Parameters) > MaxParameters {
+		return fmt.Errorf("a model can declare at most %d parameters", MaxParameters)
+	}
+	if in.Parameters == nil {
+		in.Parameters = map[string]fal.Parameter{}
+	}
+
+	names := make([]string, 0, len(in.Parameters))
+	for name := range in.Parameters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := validateParameter(name, in.Parameters[name]); err != nil {
+			return fmt.Errorf("parameter %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// validEndpoint checks that endpoint is a FAL endpoint ID. It is built into
+// the FAL URL, so "." and ".." segments are refused.
+func validEndpoint(endpoint string) bool {
+	if !endpointPattern.MatchString(endpoint) {
+		return false
+	}
+	for _, segment := range strings.Split(endpoint, "/") {
+		if segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// validateParameter checks one parameter of a schema
+func validateParameter(name string, param fal.Parameter) error {
+	if !parameterPattern.MatchString(name) {
+		return fmt.Errorf("names must be lowercase letters, digits or underscores")
+	}
+	if name == "prompt" {
+		return fmt.Errorf("the prompt is sent separately")
+	}
+
+	known := false
+	for _, t := range parameterTypes {
+		known = known || param.Type == t
+	}
+	if !known {
+		return fmt.Errorf("type must be one of: %s", strings.Join(parameterTypes, ", "))
+	}
+
+	numeric := param.Type == "integer" || param.Type == "float"
+	if (param.Min != nil || param.Max != nil) && !numeric {
+		return fmt.Errorf("min and max only apply to integer and float parameters")
+	}
+	if param.Min != nil && param.Max != nil && *param.Min > *param.Max {
+		return fmt.Errorf("min cannot exceed max")
+	}
+	if len(param.Options) > 0 && param.Type != "string" {
+		return fmt.Errorf("options only apply to string parameters")
+	}
+
+	// The default must pass the schema it belongs to
+	if param.Default != nil {
+		schema := fal.ModelInfo{Parameters: map[string]fal.Parameter{name: param}}
+		if err := schema.ValidateParameters(map[string]interface{}{name: param.Default}); err != nil {
+			return fmt.Errorf("invalid default: %w", err)
+		}
+	}
+	return nil
+}
+
+// Register adds a model for userID
+func (r *Registry) Register(userID string, in Input) (*Model, error) {
+	if err := Validate(&in); err != nil {
+		return nil, err
+	}
+
+	count, err := r.app.CountRecords(Collection, dbx.HashExp{"user_id": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to count custom models: %w", err)
+	}
+	if count >= MaxModelsPerUser {
+		return nil, fmt.Errorf("a user can register at most %d custom models", MaxModelsPerUser)
+	}
+	if _, err := r.findByName(userID, in.Name); err == nil {
+		return nil, fmt.Errorf("a custom model named %q already exists", in.Name)
+	}
+
+	collection, err := r.app.FindCollectionByNameOrId(Collection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find custom models collection: %w", err)
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("user_id", userID)
+	return r.save(record, in)
+}
+
+// Update replaces the definition of one of userID's models
+func (r *Registry) Update(userID, id string, in Input) (*Model, error) {
+	record, err := r.find(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := Validate(&in); err != nil {
+		return nil, err
+	}
+	if existing, err := r.findByName(userID, in.Name); err == nil && existing.Id != record.Id {
+		return nil, fmt.Errorf("a custom model named %q already exists", in.Name)
+	}
+	return r.save(record, in)
+}
+
+// List returns userID's models sorted by name
+func (r *Registry) List(userID string) ([]*Model, error) {
+	records, err := r.app.FindRecordsByFilter(Collection, "user_id = {:user_id}", "name", 0, 0, map[string]any{"user_id": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch custom models: %w", err)
+	}
+
+	models := make([]*Model, 0, len(records))
+	for _, record := range records {
+		models = append(models, fromRecord(record))
+	}
+	return models, nil
+}
+
+// Find returns one of userID's models by record ID
+func (r *Registry) Find(userID, id string) (*Model, error) {
+	record, err := r.find(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	return fromRecord(record), nil
+}
+
+// Resolve returns the definition of the custom model userID selected as modelID.
+// It reports false for built-in model IDs and unknown custom models.
+func (r *Registry) Resolve(userID, modelID string) (fal.ModelInfo, bool) {
+	name, isCustom := strings.CutPrefix(modelID, IDPrefix)
+	if !isCustom {
+		return fal.ModelInfo{}, false
+	}
+	record, err := r.findByName(userID, name)
+	if err != nil {
+		return fal.ModelInfo{}, false
+	}
+	return fromRecord(record).Info(), true
+}
+
+// Delete removes one of userID's models
+func (r *Registry) Delete(userID, id string) error {
+	record, err := r.find(userID, id)
+	if err != nil {
+		return err
+	}
+	if err := r.app.Delete(record); err != nil {
+		return fmt.Errorf("failed to delete custom model: %w", err)
+	}
+	return nil
+}
+
+func (r *Registry) find(userID, id string) (*core.Record, error) {
+	record, err := r.app.FindRecordById(Collection, id)
+	if err != nil || record.GetString("user_id") != userID {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+func (r *Registry) findByName(userID, name string) (*core.Record, error) {
+	record, err := r.app.FindFirstRecordByFilter(Collection, "user_id = {:user_id} && name = {:name}", map[string]any{"user_id": userID, "name": name})
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+func (r *Registry) save(record *core.Record, in Input) (*Model, error) {
+	record.Set("name", in.Name)
+	record.Set("display_name", in.DisplayName)
+	record.Set("description", in.Description)
+	record.Set("endpoint", in.Endpoint)
+	record.Set("cost_per_image", in.CostPerImage)
+	record.Set("parameters", in.Parameters)
+
+	if err := r.app.Save(record); err != nil {
+		return nil, fmt.Errorf("failed to save custom model: %w", err)
+	}
+	return fromRecord(record), nil
+}
+
+func fromRecord(record *core.Record) *Model {
+	model := &Model{
+		ID:           record.Id,
+		Model:        IDPrefix + record.GetString("name"),
+		Name:         record.GetString("name"),
+		DisplayName:  record.GetString("display_name"),
+		Description:  record.GetString("description"),
+		Endpoint:     record.GetString("endpoint"),
+		CostPerImage: record.GetFloat("cost_per_image"),
+		Parameters:   map[string]fal.Parameter{},
+		Created:      record.GetDateTime("created").Time(),
+		Updated:      record.GetDateTime("updated").Time(),
+	}
+	record.UnmarshalJSONField("parameters", &model.Parameters)
+	return model
+}
@@ -0,0 +1,92 @@
+package dedupe
+
+import (
+	"context"
+	"sync"
+)
+
+// call is a generation in flight that identical requests may share
+type call struct {
+	done   chan struct{}
+	result interface{}
+	err    error
+}
+
+// Coalescer shares generations between identical requests that are in flight
+// at the same time, such as a double-submitted form. The first caller
+// submits; the others wait for its result instead of submitting again.
+// Unlike Detector it forgets a request as soon as it finishes.
+type Coalescer struct {
+	enabled bool
+
+	mutex sync.Mutex
+	calls map[string]*call
+}
+
+// NewCoalescer creates a coalescer; a disabled one runs every request itself
+func NewCoalescer(enabled bool) *Coalescer {
+	return &Coalescer{
+		enabled: enabled,
+		calls:   make(map[string]*call),
+	}
+}
+
+// SeededKey returns the fingerprint identical seeded requests of one user
+// share, or "" for requests without a seed. Only a fixed seed makes another
+// caller's images the ones this request would have produced. The user is part
+// of the key, since the shared images are paid for with the first caller's key.
+func SeededKey(userID, model, prompt string, parameters map[string]interface{}) string {
+	if _, ok := parameters["seed"]; !ok {
+		return ""
+	}
+	return Request{UserID: userID, Model: model, Prompt: prompt, Parameters: parameters}.Fingerprint()
+}
+
+// Do runs generate, unless an identical request with key is already in flight,
+// in which case it waits for that request's result and reports shared. When
+// the shared request fails, for instance because its caller's key is out of
+// credit, generate runs after all. An empty key is never coalesced.
+func (c *Coalescer) Do(ctx context.Context, key string, generate func() (interface{}, error)) (result interface{}, shared bool, err error) {
+	if !c.enabled || key == "" {
+		result, err = generate()
+		return result, false, err
+	}
+
+	c.mutex.Lock()
+	if existing, ok := c.calls[key]; ok {
+		c.mutex.Unlock()
+
+		select {
+		case <-existing.done:
+			if existing.err == nil {
+				return existing.result, true, nil
+			}
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+
+		result, err = generate()
+		return result, false, err
+	}
+
+	current := &call{done: make(chan struct{})}
+	c.calls[key] = current
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		delete(c.calls, key)
+		c.mutex.Unlock()
+		close(current.done)
+	}()
+
+	current.result, current.err = generate()
+	return current.result, false, current.err
+}
+
+// InFlight returns how many distinct requests are being generated
+func (c *Coalescer) InFlight() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.calls)
+}
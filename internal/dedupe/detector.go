@@ -0,0 +1,148 @@
+package dedupe
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// Request identifies a generation for duplicate detection. Two submissions
+// are duplicates when every field matches.
+type Request struct {
+	UserID       string                 `json:"user_id"`
+	OrgID        string                 `json:"org_id,omitempty"`
+	CollectionID string                 `json:"collection_id,omitempty"`
+	Model        string                 `json:"model"`
+	Prompt       string                 `json:"prompt"`
+	Parameters   map[string]interface{} `json:"parameters"`
+}
+
+// Fingerprint returns a key that is equal for identical requests. Parameters
+// are compared by their JSON encoding, so map order does not matter and a
+// missing parameter map equals an empty one.
+func (r Request) Fingerprint() string {
+	if r.Parameters == nil {
+		r.Parameters = map[string]interface{}{}
+	}
+	encoded, _ := json.Marshal(r)
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// Submission is a generation tracked for duplicates. It is in flight until
+// Complete or Fail is called.
+type Submission struct {
+	detector *Detector
+	key      string
+	started  time.Time
+	done     chan struct{}
+
+	// Set under the detector's mutex before done is closed
+	finished time.Time
+	result   interface{}
+	ok       bool
+}
+
+// Started returns when the submission was made
+func (s *Submission) Started() time.Time {
+	return s.started
+}
+
+// Complete records the successful result and releases callers waiting on it.
+// Calling it on a nil submission does nothing.
+func (s *Submission) Complete(result interface{}) {
+	if s == nil {
+		return
+	}
+	s.detector.mutex.Lock()
+	s.finished = time.Now()
+	s.result = result
+	s.ok = true
+	s.detector.mutex.Unlock()
+	close(s.done)
+}
+
+// Fail forgets a submission that did not produce a result, so retrying it is
+// not treated as a duplicate. Calling it on a nil submission does nothing.
+func (s *Submission) Fail() {
+	if s == nil {
+		return
+	}
+	s.detector.mutex.Lock()
+	if s.detector.submissions[s.key] == s {
+		delete(s.detector.submissions, s.key)
+	}
+	s.detector.mutex.Unlock()
+	close(s.done)
+}
+
+// Wait blocks until the submission finishes and returns its result. ok is
+// false when it failed or ctx ended first.
+func (s *Submission) Wait(ctx context.Context) (result interface{}, ok bool) {
+	select {
+	case <-s.done:
+		return s.result, s.ok
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// Detector remembers each user's recent generations so that an identical
+// submission moments later, usually an impatient double-click, can be
+// recognised. A submission counts as recent while it is in flight and for the
+// window after it completed successfully.
+type Detector struct {
+	window time.Duration
+
+	mutex       sync.Mutex
+	submissions map[string]*Submission
+}
+
+// NewDetector creates a detector; a non-positive window disables it
+func NewDetector(window time.Duration) *Detector {
+	return &Detector{
+		window:      window,
+		submissions: make(map[string]*Submission),
+	}
+}
+
+// Enabled reports whether duplicates are detected at all
+func (d *Detector) Enabled() bool {
+	return d.window > 0
+}
+
+// Begin tracks a submission of the request with key. When an identical
+// submission is recent it is returned as previous and nothing new is tracked;
+// otherwise current must be finished with Complete or Fail. Both are nil when
+// the detector is disabled.
+func (d *Detector) Begin(key string) (current, previous *Submission) {
+	if !d.Enabled() {
+		return nil, nil
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	for k, s := range d.submissions {
+		if !s.finished.IsZero() && now.Sub(s.finished) > d.window {
+			delete(d.submissions, k)
+		}
+	}
+
+	if existing, ok := d.submissions[key]; ok {
+		return nil, existing
+	}
+
+	current = &Submission{
+		detector: d,
+		key:      key,
+		started:  now,
+		done:     make(chan struct{}),
+	}
+	d.submissions[key] = current
+	return current, nil
+}
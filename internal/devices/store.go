@@ -0,0 +1,282 @@
+package devices
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"generatio-pb/internal/crypto"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Collection stores remembered devices. The FAL token is kept encrypted with
+// the device's refresh token, which is never stored, so a leaked row cannot
+// be used to open a session.
+const Collection = "trusted_devices"
+
+// TokenPrefix marks a string as a Generatio refresh token
+const TokenPrefix = "grt_"
+
+// MaxDevicesPerUser caps how many devices a user can remember at once
+const MaxDevicesPerUser = 10
+
+// ErrInvalidToken is returned for unknown, expired, revoked or foreign refresh tokens
+var ErrInvalidToken = fmt.Errorf("invalid refresh token")
+
+// Device describes a remembered device without its secrets
+type Device struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	UserID     string     `json:"-"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Created    time.Time  `json:"created"`
+}
+
+// Store remembers devices, exchanges their refresh tokens for FAL tokens and
+// revokes them
+type Store struct {
+	app core.App
+	enc *crypto.EncryptionService
+}
+
+// NewStore creates a store backed by the trusted_devices collection
+func NewStore(app core.App, enc *crypto.EncryptionService) *Store {
+	return &Store{app: app, enc: enc}
+}
+
+// Remember trusts a device for lifetime and returns its refresh token once.
+// deviceID is a random identifier the client keeps on the device; tokens
+// only work when presented together with it.
+func (s *Store) Remember(userID, deviceID, name, falToken string, lifetime time.Duration) (string, *Device, error) {
+	if strings.TrimSpace(deviceID) == "" {
+		return "", nil, fmt.Errorf("device_id is required")
+	}
+	name = strings.TrimSpace(name)
+	if len(name) > 100 {
+		return "", nil, fmt.Errorf("device name cannot exceed 100 characters")
+	}
+	if name == "" {
+		name = "Unnamed device"
+	}
+
+	collection, err := s.app.FindCollectionByNameOrId(Collection)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to find trusted_devices collection: %w", err)
+	}
+
+	// Remembering the same device again replaces its previous token
+	existing, err := s.app.FindRecordsByFilter(Collection, "user_id = {:user_id}", "created", 0, 0, map[string]any{"user_id": userID})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch devices: %w", err)
+	}
+	deviceHash := hashSecret(deviceID)
+	remaining := len(existing)
+	for _, record := range existing {
+		if record.GetString("device_hash") == deviceHash {
+			if err := s.app.Delete(record); err != nil {
+				return "", nil, fmt.Errorf("failed to replace device: %w", err)
+			}
+			remaining--
+		}
+	}
+	if remaining >= MaxDevicesPerUser {
+		return "", nil, fmt.Errorf("a user can remember at most %d devices", MaxDevicesPerUser)
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("user_id", userID)
+	record.Set("name", name)
+	record.Set("device_hash", deviceHash)
+	record.Set("expires_at", time.Now().Add(lifetime))
+
+	token, err := s.seal(record, falToken)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := s.app.Save(record); err != nil {
+		return "", nil, fmt.Errorf("failed to save device: %w", err)
+	}
+
+	return token, deviceFromRecord(record), nil
+}
+
+// Redeem exchanges a refresh token for the FAL token it protects. The token
+// is rotated: the returned replacement must be used next time, and the
+// presented one stops working. Presenting the replaced token again means it
+// was copied, so the device is revoked. The device's expiry is not extended,
+// and devices remembered longer than maxAge ago are refused, so lowering a
+// user's limit also shortens devices remembered under the old one.
+func (s *Store) Redeem(userID, deviceID, token string, maxAge time.Duration) (string, string, *Device, error) {
+	if !strings.HasPrefix(token, TokenPrefix) || deviceID == "" {
+		return "", "", nil, ErrInvalidToken
+	}
+
+	tokenHash := hashSecret(token)
+	record, err := s.app.FindFirstRecordByData(Collection, "token_hash", tokenHash)
+	if err != nil {
+		s.revokeReused(tokenHash)
+		return "", "", nil, ErrInvalidToken
+	}
+	if record.GetString("user_id") != userID || record.GetString("device_hash") != hashSecret(deviceID) {
+		return "", "", nil, ErrInvalidToken
+	}
+	if time.Now().After(record.GetDateTime("expires_at").Time()) || time.Since(record.GetDateTime("created").Time()) > maxAge {
+		if err := s.app.Delete(record); err != nil {
+			s.app.Logger().Warn("Failed to delete expired device", "error", err, "device_id", record.Id)
+		}
+		return "", "", nil, ErrInvalidToken
+	}
+
+	falToken, err := s.enc.Decrypt(record.GetString("encrypted_token"), record.GetString("salt"), token)
+	if err != nil {
+		return "", "", nil, ErrInvalidToken
+	}
+
+	next, err := s.seal(record, falToken)
+	if err != nil {
+		return "", "", nil, err
+	}
+	record.Set("previous_token_hash", tokenHash)
+	record.Set("last_used_at", types.NowDateTime())
+
+	// Only one of several requests redeeming the same token may rotate it;
+	// the others presented a token that has just been replaced
+	err = s.app.RunInTransaction(func(txApp core.App) error {
+		result, err := txApp.DB().Update(Collection, dbx.Params{
+			"token_hash":          record.GetString("token_hash"),
+			"previous_token_hash": tokenHash,
+			"encrypted_token":     record.GetString("encrypted_token"),
+			"salt":                record.GetString("salt"),
+			"last_used_at":        record.GetDateTime("last_used_at"),
+		}, dbx.HashExp{"id": record.Id, "token_hash": tokenHash}).Execute()
+		if err != nil {
+			return err
+		}
+		rotated, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rotated == 0 {
+			return ErrInvalidToken
+		}
+		return nil
+	})
+	if errors.Is(err, ErrInvalidToken) {
+		s.revokeReused(tokenHash)
+		return "", "", nil, ErrInvalidToken
+	}
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	return falToken, next, deviceFromRecord(record), nil
+}
+
+// revokeReused forgets the device whose previous refresh token hashes to
+// tokenHash. Rotated tokens are only ever presented again by a second copy of
+// the token, so the device can no longer be trusted.
+func (s *Store) revokeReused(tokenHash string) {
+	record, err := s.app.FindFirstRecordByData(Collection, "previous_token_hash", tokenHash)
+	if err != nil {
+		return
+	}
+
+	s.app.Logger().Warn("Rotated refresh token reused, revoking device", "device_id", record.Id, "user_id", record.GetString("user_id"))
+	if err := s.app.Delete(record); err != nil {
+		s.app.Logger().Warn("Failed to revoke device", "error", err, "device_id", record.Id)
+	}
+}
+
+// List returns a user's unexpired devices, newest first
+func (s *Store) List(userID string) ([]*Device, error) {
+	records, err := s.app.FindRecordsByFilter(Collection, "user_id = {:user_id} && expires_at > {:now}", "-created", 0, 0, map[string]any{
+		"user_id": userID,
+		"now":     types.NowDateTime(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch devices: %w", err)
+	}
+
+	devices := make([]*Device, 0, len(records))
+	for _, record := range records {
+		devices = append(devices, deviceFromRecord(record))
+	}
+	return devices, nil
+}
+
+// Revoke forgets one of a user's devices
+func (s *Store) Revoke(userID, id string) error {
+	record, err := s.app.FindRecordById(Collection, id)
+	if err != nil || record.GetString("user_id") != userID {
+		return fmt.Errorf("device not found")
+	}
+
+	if err := s.app.Delete(record); err != nil {
+		return fmt.Errorf("failed to delete device: %w", err)
+	}
+	return nil
+}
+
+// RevokeAll forgets every device of a user and returns how many there were
+func (s *Store) RevokeAll(userID string) (int, error) {
+	records, err := s.app.FindRecordsByFilter(Collection, "user_id = {:user_id}", "", 0, 0, map[string]any{"user_id": userID})
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch devices: %w", err)
+	}
+
+	for _, record := range records {
+		if err := s.app.Delete(record); err != nil {
+			return 0, fmt.Errorf("failed to delete device: %w", err)
+		}
+	}
+	return len(records), nil
+}
+
+// seal issues a new refresh token for record and encrypts falToken with it
+func (s *Store) seal(record *core.Record, falToken string) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	token := TokenPrefix + hex.EncodeToString(secret)
+
+	encrypted, err := s.enc.Encrypt(falToken, token)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt token: %w", err)
+	}
+
+	record.Set("token_hash", hashSecret(token))
+	record.Set("encrypted_token", encrypted.Encrypted)
+	record.Set("salt", encrypted.Salt)
+	return token, nil
+}
+
+func deviceFromRecord(record *core.Record) *Device {
+	device := &Device{
+		ID:        record.Id,
+		Name:      record.GetString("name"),
+		UserID:    record.GetString("user_id"),
+		ExpiresAt: record.GetDateTime("expires_at").Time(),
+		Created:   record.GetDateTime("created").Time(),
+	}
+	if lastUsed := record.GetDateTime("last_used_at"); !lastUsed.IsZero() {
+		t := lastUsed.Time()
+		device.LastUsedAt = &t
+	}
+	return device
+}
+
+// hashSecret stores refresh tokens and device ids as SHA-256 digests
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,93 @@
+package embeddings
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Embedder maps images and text into a shared vector space (e.g. CLIP), so a
+// text query can be compared with images
+type Embedder interface {
+	// Model names the vector space; vectors of different models are never compared
+	Model() string
+	EmbedImage(ctx context.Context, token, imageURL string) ([]float64, error)
+	EmbedText(ctx context.Context, token, text string) ([]float64, error)
+}
+
+// FALEmbedder calls a CLIP model hosted on FAL AI with the user's key. The
+// endpoint takes {"image_url": ...} or {"text": ...} and answers
+// {"embedding": [...]}.
+type FALEmbedder struct {
+	endpoint   string
+	httpClient *http.Client
+}
+
+// NewFALEmbedder creates an embedder for a FAL AI endpoint
+func NewFALEmbedder(endpoint string) *FALEmbedder {
+	return &FALEmbedder{
+		endpoint: endpoint,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// Model returns the endpoint, which identifies the hosted model
+func (f *FALEmbedder) Model() string {
+	return f.endpoint
+}
+
+// EmbedImage returns the embedding of the image at imageURL
+func (f *FALEmbedder) EmbedImage(ctx context.Context, token, imageURL string) ([]float64, error) {
+	return f.embed(ctx, token, map[string]string{"image_url": imageURL})
+}
+
+// EmbedText returns the embedding of a text query
+func (f *FALEmbedder) EmbedText(ctx context.Context, token, text string) ([]float64, error) {
+	return f.embed(ctx, token, map[string]string{"text": text})
+}
+
+func (f *FALEmbedder) embed(ctx context.Context, token string, input map[string]string) ([]float64, error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, f.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Key "+token)
+
+	resp, err := f.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding request failed: HTTP %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var parsed struct {
+		Embedding []float64 `json:"embedding"`
+	}
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	if len(parsed.Embedding) == 0 {
+		return nil, fmt.Errorf("embedding response had no vector")
+	}
+	return parsed.Embedding, nil
+}
@@ -0,0 +1,176 @@
+package embeddings
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"generatio-pb/internal/auth"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	// BatchSize is how many images of one user a run embeds
+	BatchSize = 20
+
+	// embedTimeout bounds a single embedding request
+	embedTimeout = 30 * time.Second
+)
+
+// Report summarises an indexing run
+type Report struct {
+	Users    int `json:"users"`
+	Embedded int `json:"embedded"`
+	Failed   int `json:"failed"`
+}
+
+// Indexer embeds persisted images in the background. Embedding calls are
+// paid with the image owner's FAL AI key, and stored keys are encrypted with
+// the user's password, so only users with an active session are indexed.
+type Indexer struct {
+	app      core.App
+	sessions *auth.SessionStore
+	store    *Store
+	embedder Embedder
+	interval time.Duration
+
+	runMutex sync.Mutex
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// NewIndexer creates an indexer running every interval; a non-positive
+// interval disables scheduled runs
+func NewIndexer(app core.App, sessions *auth.SessionStore, store *Store, embedder Embedder, interval time.Duration) *Indexer {
+	return &Indexer{
+		app:      app,
+		sessions: sessions,
+		store:    store,
+		embedder: embedder,
+		interval: interval,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start begins the scheduled indexing loop
+func (i *Indexer) Start() {
+	if i.interval <= 0 {
+		return
+	}
+	go i.run()
+	log.Printf("Image embedding indexer started with interval: %v", i.interval)
+}
+
+// Stop stops the scheduled indexing loop
+func (i *Indexer) Stop() {
+	i.stopOnce.Do(func() {
+		close(i.stopChan)
+	})
+}
+
+func (i *Indexer) run() {
+	ticker := time.NewTicker(i.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			report, err := i.Run(context.Background())
+			if err != nil {
+				log.Printf("Image embedding run failed: %v", err)
+			} else if report.Embedded > 0 || report.Failed > 0 {
+				log.Printf("Embedded %d images of %d users (%d failed)", report.Embedded, report.Users, report.Failed)
+			}
+		case <-i.stopChan:
+			return
+		}
+	}
+}
+
+// Run embeds up to BatchSize missing images of every user with an active session
+func (i *Indexer) Run(ctx context.Context) (*Report, error) {
+	i.runMutex.Lock()
+	defer i.runMutex.Unlock()
+
+	users, err := i.app.FindAllRecords("generatio_users")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch users: %w", err)
+	}
+
+	report := &Report{}
+	for _, user := range users {
+		if ctx.Err() != nil {
+			break
+		}
+		session, err := i.sessions.GetUserSession(user.Id)
+		if err != nil {
+			continue
+		}
+
+		embedded, failed, err := i.Index(ctx, user.Id, session.FALToken, BatchSize)
+		if err != nil {
+			i.app.Logger().Error("Failed to index images", "user_id", user.Id, "error", err)
+			continue
+		}
+		if embedded > 0 || failed > 0 {
+			report.Users++
+		}
+		report.Embedded += embedded
+		report.Failed += failed
+	}
+	return report, nil
+}
+
+// Index embeds up to limit of the user's images that have no vector yet
+func (i *Indexer) Index(ctx context.Context, userID, token string, limit int) (embedded, failed int, err error) {
+	images, err := i.store.Missing(userID, i.embedder.Model(), limit)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, image := range images {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := i.Embed(ctx, token, image); err != nil {
+			i.app.Logger().Warn("Failed to embed image", "image_id", image.Id, "error", err)
+			failed++
+			continue
+		}
+		embedded++
+	}
+	return embedded, failed, nil
+}
+
+// Embed computes and stores the vector of one image
+func (i *Indexer) Embed(ctx context.Context, token string, image *core.Record) error {
+	embedCtx, cancel := context.WithTimeout(ctx, embedTimeout)
+	defer cancel()
+
+	vector, err := i.embedder.EmbedImage(embedCtx, token, image.GetString("url"))
+	if err != nil {
+		return err
+	}
+	return i.store.Save(image, i.embedder.Model(), vector)
+}
+
+// Query embeds a text query
+func (i *Indexer) Query(ctx context.Context, token, text string) ([]float64, error) {
+	embedCtx, cancel := context.WithTimeout(ctx, embedTimeout)
+	defer cancel()
+
+	return i.embedder.EmbedText(embedCtx, token, text)
+}
+
+// Model names the vector space of the configured embedder
+func (i *Indexer) Model() string {
+	return i.embedder.Model()
+}
+
+// Store returns the vector store
+func (i *Indexer) Store() *Store {
+	return i.store
+}
@@ -0,0 +1,227 @@
+package embeddings
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"generatio-pb/internal/moderation"
+	"generatio-pb/internal/repository"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Collection stores one vector per image and model
+const Collection = "image_embeddings"
+
+const (
+	// MaxCandidates caps the vectors a search compares, newest first
+	MaxCandidates = 5000
+
+	// MaxResults caps the images a search returns
+	MaxResults = 50
+
+	// DefaultResults is how many images a search returns without ?limit=
+	DefaultResults = 20
+
+	// scanWindow is how many of a user's newest images are checked for missing vectors
+	scanWindow = 500
+)
+
+// Match is an image ranked by cosine similarity to a query, 1 being identical
+type Match struct {
+	Image *core.Record
+	Score float64
+}
+
+// Store keeps image vectors and ranks a user's images against a query vector
+type Store struct {
+	app    core.App
+	images *repository.Images
+}
+
+// NewStore creates a store backed by the image_embeddings collection.
+// Vectors are deleted with their image.
+func NewStore(app core.App) *Store {
+	s := &Store{app: app, images: repository.NewImages(app)}
+
+	app.OnRecordAfterDeleteSuccess("images").BindFunc(func(e *core.RecordEvent) error {
+		if records, err := app.FindAllRecords(Collection, dbx.HashExp{"image_id": e.Record.Id}); err == nil {
+			for _, record := range records {
+				if err := app.Delete(record); err != nil {
+					app.Logger().Warn("Failed to delete image embedding", "image_id", e.Record.Id, "error", err)
+				}
+			}
+		}
+		return e.Next()
+	})
+
+	return s
+}
+
+// Save stores the image's vector for model, replacing an earlier one
+func (s *Store) Save(image *core.Record, model string, vector []float64) error {
+	normalized, err := normalize(vector)
+	if err != nil {
+		return err
+	}
+
+	record, err := s.app.FindFirstRecordByFilter(Collection, "image_id = {:image_id} && model = {:model}",
+		dbx.Params{"image_id": image.Id, "model": model})
+	if err != nil {
+		collection, err := s.app.FindCollectionByNameOrId(Collection)
+		if err != nil {
+			return fmt.Errorf("failed to find embeddings collection: %w", err)
+		}
+		record = core.NewRecord(collection)
+		record.Set("image_id", image.Id)
+		record.Set("user_id", image.GetString("user_id"))
+		record.Set("model", model)
+	}
+
+	record.Set("vector", normalized)
+	if err := s.app.Save(record); err != nil {
+		return fmt.Errorf("failed to save embedding: %w", err)
+	}
+	return nil
+}
+
+// Vector returns the image's stored vector for model
+func (s *Store) Vector(imageID, model string) ([]float64, bool) {
+	record, err := s.app.FindFirstRecordByFilter(Collection, "image_id = {:image_id} && model = {:model}",
+		dbx.Params{"image_id": imageID, "model": model})
+	if err != nil {
+		return nil, false
+	}
+
+	var vector []float64
+	if err := record.UnmarshalJSONField("vector", &vector); err != nil || len(vector) == 0 {
+		return nil, false
+	}
+	return vector, true
+}
+
+// Missing returns the user's newest persisted images without a vector for model
+func (s *Store) Missing(userID, model string, limit int) ([]*core.Record, error) {
+	images, err := s.images.Find(
+		"user_id = {:user_id} && content_hash != '' && url != '' && moderation_status != {:quarantined}",
+		"-created,-id", scanWindow, 0,
+		dbx.Params{"user_id": userID, "quarantined": moderation.StatusQuarantined})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch images: %w", err)
+	}
+	if len(images) == 0 {
+		return images, nil
+	}
+
+	ids := make([]interface{}, 0, len(images))
+	for _, image := range images {
+		ids = append(ids, image.Id)
+	}
+	existing, err := s.app.FindAllRecords(Collection, dbx.HashExp{"model": model}, dbx.In("image_id", ids...))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch embeddings: %w", err)
+	}
+	embedded := make(map[string]bool, len(existing))
+	for _, record := range existing {
+		embedded[record.GetString("image_id")] = true
+	}
+
+	missing := make([]*core.Record, 0, limit)
+	for _, image := range images {
+		if len(missing) == limit {
+			break
+		}
+		if !embedded[image.Id] {
+			missing = append(missing, image)
+		}
+	}
+	return missing, nil
+}
+
+// Search ranks the user's images by similarity to query, best first. Trashed
+// and quarantined images and excludeID are left out.
+func (s *Store) Search(userID, model string, query []float64, excludeID string, limit int) ([]Match, error) {
+	query, err := normalize(query)
+	if err != nil {
+		return nil, err
+	}
+
+	records, err := s.app.FindRecordsByFilter(Collection, "user_id = {:user_id} && model = {:model} && image_id != {:exclude}",
+		"-created", MaxCandidates, 0, dbx.Params{"user_id": userID, "model": model, "exclude": excludeID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch embeddings: %w", err)
+	}
+
+	type scored struct {
+		imageID string
+		score   float64
+	}
+	ranked := make([]scored, 0, len(records))
+	for _, record := range records {
+		var vector []float64
+		if err := record.UnmarshalJSONField("vector", &vector); err != nil || len(vector) != len(query) {
+			continue
+		}
+		ranked = append(ranked, scored{imageID: record.GetString("image_id"), score: dot(query, vector)})
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].score > ranked[j].score })
+
+	// Images are loaded a page at a time, as trashed ones are skipped
+	matches := make([]Match, 0, limit)
+	for start := 0; start < len(ranked) && len(matches) < limit; start += limit {
+		page := ranked[start:min(start+limit, len(ranked))]
+		ids := make([]string, 0, len(page))
+		for _, candidate := range page {
+			ids = append(ids, candidate.imageID)
+		}
+		images, err := s.app.FindRecordsByIds("images", ids)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch images: %w", err)
+		}
+		byID := make(map[string]*core.Record, len(images))
+		for _, image := range images {
+			byID[image.Id] = image
+		}
+
+		for _, candidate := range page {
+			image, ok := byID[candidate.imageID]
+			if !ok || image.GetString("user_id") != userID || !image.GetDateTime("deleted_at").IsZero() ||
+				image.GetString("moderation_status") == moderation.StatusQuarantined {
+				continue
+			}
+			matches = append(matches, Match{Image: image, Score: math.Round(candidate.score*10000) / 10000})
+			if len(matches) == limit {
+				break
+			}
+		}
+	}
+	return matches, nil
+}
+
+// normalize scales a vector to unit length, so cosine similarity is a dot product
+func normalize(vector []float64) ([]float64, error) {
+	var sum float64
+	for _, value := range vector {
+		sum += value * value
+	}
+	if sum == 0 || math.IsNaN(sum) || math.IsInf(sum, 0) {
+		return nil, fmt.Errorf("embedding vector is empty or invalid")
+	}
+
+	norm := math.Sqrt(sum)
+	normalized := make([]float64, len(vector))
+	for i, value := range vector {
+		normalized[i] = value / norm
+	}
+	return normalized, nil
+}
+
+func dot(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
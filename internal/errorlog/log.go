@@ -0,0 +1,93 @@
+package errorlog
+
+import (
+	"sync"
+	"time"
+)
+
+// Entry is one failed FAL AI call
+type Entry struct {
+	Code      string    `json:"code"`
+	Message   string    `json:"message"`
+	RequestID string    `json:"request_id,omitempty"` // FAL queue request, when the failure happened after submission
+	Model     string    `json:"model,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// ring holds a user's most recent entries, overwriting the oldest
+type ring struct {
+	entries []Entry
+	next    int
+}
+
+// Log keeps the last few FAL AI errors of each user in memory so users can
+// see why their generations failed without access to the server logs. It is
+// lost on restart.
+type Log struct {
+	size int
+
+	mutex sync.Mutex
+	users map[string]*ring
+}
+
+// NewLog keeps size errors per user; a non-positive size disables the log
+func NewLog(size int) *Log {
+	return &Log{
+		size:  size,
+		users: make(map[string]*ring),
+	}
+}
+
+// Record adds an error for userID, dropping the user's oldest once full
+func (l *Log) Record(userID string, entry Entry) {
+	if l.size <= 0 {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	r, ok := l.users[userID]
+	if !ok {
+		r = &ring{entries: make([]Entry, 0, l.size)}
+		l.users[userID] = r
+	}
+	if len(r.entries) < l.size {
+		r.entries = append(r.entries, entry)
+		return
+	}
+	r.entries[r.next] = entry
+	r.next = (r.next + 1) % l.size
+}
+
+// Recent returns the user's recorded errors, newest first
+func (l *Log) Recent(userID string) []Entry {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	r, ok := l.users[userID]
+	if !ok {
+		return []Entry{}
+	}
+
+	recent := make([]Entry, 0, len(r.entries))
+	for i := 1; i <= len(r.entries); i++ {
+		recent = append(recent, r.entries[(r.next-i+len(r.entries))%len(r.entries)])
+	}
+	return recent
+}
+
+// Clear forgets the user's errors
+func (l *Log) Clear(userID string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.users, userID)
+}
+
+// Size returns how many errors are kept per user
+func (l *Log) Size() int {
+	return l.size
+}
@@ -3,12 +3,18 @@ package fal
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
+
+	"generatio-pb/internal/redact"
 )
 
 // min returns the minimum of two integers
@@ -41,6 +47,9 @@ func getBaseModelID(fullModelID string) string {
 	if fullModelID == "hidream/hidream-i1-dev" || fullModelID == "hidream/hidream-i1-fast" {
 		return "fal-ai/hidream"
 	}
+	if fullModelID == OutpaintModel || fullModelID == "fal-ai/"+OutpaintModel {
+		return "fal-ai/flux-pro"
+	}
 	
 	// Handle already converted FAL model IDs
 	if fullModelID == "fal-ai/flux/schnell" {
@@ -54,11 +63,31 @@ func getBaseModelID(fullModelID string) string {
 	return fullModelID
 }
 
+// queuePaths returns the FAL path a request for the model is submitted to and
+// the base path of its status and result endpoints
+func queuePaths(modelID string, model ModelInfo) (submit, base string) {
+	if model.Endpoint != "" {
+		// Apps are addressed as owner/app; further segments pick a route within the app
+		segments := strings.SplitN(model.Endpoint, "/", 3)
+		if len(segments) < 2 {
+			return model.Endpoint, model.Endpoint
+		}
+		return model.Endpoint, segments[0] + "/" + segments[1]
+	}
+
+	falModelID := convertToFALModelID(modelID)
+	return falModelID, getBaseModelID(falModelID)
+}
+
 // Client represents a FAL AI client
 type Client struct {
-	baseURL    string
-	httpClient *http.Client
-	timeout    time.Duration
+	baseURL      string
+	httpClient   *http.Client
+	proxy        *url.URL    // explicit proxy; nil uses the proxy environment variables
+	tlsConfig    *tls.Config // custom CA bundle or client certificate; nil uses the defaults
+	timeout      time.Duration
+	pollInterval time.Duration
+	logger       *slog.Logger // sanitized, so tokens and Authorization values are masked
 }
 
 // NewClient creates a new FAL AI client
@@ -68,13 +97,17 @@ func NewClient(baseURL string) *Client {
 		baseURL = "https://queue.fal.run"
 	}
 
-	return &Client{
+	client := &Client{
 		baseURL: baseURL,
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
-		timeout: 5 * time.Minute, // Default timeout for generation
+		timeout:      5 * time.Minute, // Default timeout for generation
+		pollInterval: 2 * time.Second, // Default queue polling interval
+		logger:       redact.Logger(nil),
 	}
+	client.applyTransport()
+	return client
 }
 
 // SetTimeout sets the timeout for generation requests
@@ -82,10 +115,23 @@ func (c *Client) SetTimeout(timeout time.Duration) {
 	c.timeout = timeout
 }
 
+// SetLogger sets the logger requests are logged to; it is wrapped so
+// secrets are masked (nil uses slog.Default())
+func (c *Client) SetLogger(logger *slog.Logger) {
+	c.logger = redact.Logger(logger)
+}
+
+// SetPollInterval sets how often the queue status is polled while waiting for completion
+func (c *Client) SetPollInterval(interval time.Duration) {
+	if interval > 0 {
+		c.pollInterval = interval
+	}
+}
+
 // SubmitGeneration submits a generation request to the FAL AI queue
 func (c *Client) SubmitGeneration(ctx context.Context, token string, req GenerationRequest) (*QueueResponse, error) {
 	// Validate the model
-	model, exists := GetModel(req.Model)
+	model, exists := req.modelInfo()
 	if !exists {
 		return nil, &FALError{
 			Code:    "invalid_model",
@@ -99,7 +145,7 @@ func (c *Client) SubmitGeneration(ctx context.Context, token string, req Generat
 	}
 
 	// Prepare the request - updated URL structure for FAL API
-	falModelID := convertToFALModelID(req.Model)
+	falModelID, _ := queuePaths(req.Model, model)
 	url := fmt.Sprintf("%s/%s", c.baseURL, falModelID)
 	
 	// Create request body - FAL expects different structure
@@ -120,7 +166,7 @@ func (c *Client) SubmitGeneration(ctx context.Context, token string, req Generat
 	}
 
 	// Log essential request info for debugging
-	fmt.Printf("FAL API Request: %s %s (model: %s)\n", "POST", url, req.Model)
+	c.logger.Debug("FAL API request", "method", http.MethodPost, "url", url, "model", req.Model)
 
 	// Create HTTP request
 	httpReq, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
@@ -132,10 +178,15 @@ func (c *Client) SubmitGeneration(ctx context.Context, token string, req Generat
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("Authorization", "Key "+token)
 
+	// Batch work is queued behind the account's interactive requests
+	if req.Priority == PriorityBatch {
+		httpReq.Header.Set("X-Fal-Queue-Priority", "low")
+	}
+
 	// Send request
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("%w: failed to send request: %w", ErrUnreachable, err)
 	}
 	defer resp.Body.Close()
 
@@ -147,7 +198,7 @@ func (c *Client) SubmitGeneration(ctx context.Context, token string, req Generat
 
 	// Log response status
 	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("FAL API Error: %d %s - %s\n", resp.StatusCode, resp.Status, string(respBody))
+		c.logger.Warn("FAL API error", "status", resp.StatusCode, "body", string(respBody))
 	}
 
 	// Handle error responses
@@ -157,8 +208,10 @@ func (c *Client) SubmitGeneration(ctx context.Context, token string, req Generat
 			return nil, &FALError{
 				Code:    "http_error",
 				Message: fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(respBody)),
+				Status:  resp.StatusCode,
 			}
 		}
+		falErr.Status = resp.StatusCode
 		return nil, &falErr
 	}
 
@@ -187,7 +240,7 @@ func (c *Client) CheckStatus(ctx context.Context, token, requestID string) (*Sta
 	url := fmt.Sprintf("%s/%s/requests/%s/status", c.baseURL, baseModelID, requestID)
 
 	// Log status check request
-	fmt.Printf("FAL Status Check: %s (model: %s, request: %s)\n", url, modelID, requestID)
+	c.logger.Debug("FAL status check", "url", url, "model", modelID, "request_id", requestID)
 
 	// Create HTTP request
 	httpReq, err := http.NewRequestWithContext(ctx, "GET", url, nil)
@@ -201,7 +254,7 @@ func (c *Client) CheckStatus(ctx context.Context, token, requestID string) (*Sta
 	// Send request
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("%w: failed to send request: %w", ErrUnreachable, err)
 	}
 	defer resp.Body.Close()
 
@@ -213,7 +266,7 @@ func (c *Client) CheckStatus(ctx context.Context, token, requestID string) (*Sta
 
 	// Log response status for errors
 	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("FAL Status Check Error: %d %s - %s\n", resp.StatusCode, resp.Status, string(respBody))
+		c.logger.Warn("FAL status check error", "status", resp.StatusCode, "body", string(respBody))
 	}
 
 	// Handle error responses
@@ -223,8 +276,10 @@ func (c *Client) CheckStatus(ctx context.Context, token, requestID string) (*Sta
 			return nil, &FALError{
 				Code:    "http_error",
 				Message: fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(respBody)),
+				Status:  resp.StatusCode,
 			}
 		}
+		falErr.Status = resp.StatusCode
 		return nil, &falErr
 	}
 
@@ -235,15 +290,8 @@ func (c *Client) CheckStatus(ctx context.Context, token, requestID string) (*Sta
 	}
 
 	// Debug: Log the parsed response to understand the structure
-	fmt.Printf("FAL Status Response Debug (Legacy):\n")
-	fmt.Printf("  Status: %s\n", statusResp.Status)
-	fmt.Printf("  RequestID: %s\n", statusResp.RequestID)
-	fmt.Printf("  Result is nil: %t\n", statusResp.Result == nil)
-	if statusResp.Result != nil {
-		fmt.Printf("  Result.Status: %s\n", statusResp.Result.Status)
-		fmt.Printf("  Result.Images count: %d\n", len(statusResp.Result.Images))
-	}
-	fmt.Printf("  Raw response: %s\n", string(respBody))
+	c.logger.Debug("FAL status response", "status", statusResp.Status, "request_id", statusResp.RequestID,
+		"has_result", statusResp.Result != nil, "body", string(respBody))
 
 	return &statusResp, nil
 }
@@ -251,14 +299,17 @@ func (c *Client) CheckStatus(ctx context.Context, token, requestID string) (*Sta
 // CheckStatusWithModel checks the status of a generation request with model ID
 func (c *Client) CheckStatusWithModel(ctx context.Context, token, modelID, requestID string) (*StatusResponse, error) {
 	// First convert to FAL format, then get base model ID for status checks
-	falModelID := convertToFALModelID(modelID)
-	baseModelID := getBaseModelID(falModelID)
-	
+	_, baseModelID := queuePaths(modelID, ModelInfo{})
+	return c.checkQueueStatus(ctx, token, baseModelID, requestID)
+}
+
+// checkQueueStatus checks the status of a request queued under baseModelID
+func (c *Client) checkQueueStatus(ctx context.Context, token, baseModelID, requestID string) (*StatusResponse, error) {
 	// Official FAL queue status endpoint format
 	url := fmt.Sprintf("%s/%s/requests/%s/status", c.baseURL, baseModelID, requestID)
 
 	// Log status check request with model
-	fmt.Printf("FAL Status Check: %s (model: %s → %s, request: %s)\n", url, modelID, baseModelID, requestID)
+	c.logger.Debug("FAL status check", "url", url, "model", baseModelID, "request_id", requestID)
 
 	// Create HTTP request
 	httpReq, err := http.NewRequestWithContext(ctx, "GET", url, nil)
@@ -272,8 +323,8 @@ func (c *Client) CheckStatusWithModel(ctx context.Context, token, modelID, reque
 	// Send request
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
-		fmt.Printf("❌ FAL Status Check Request failed: %v\n", err)
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		c.logger.Warn("FAL status check request failed", "error", err)
+		return nil, fmt.Errorf("%w: failed to send request: %w", ErrUnreachable, err)
 	}
 	defer resp.Body.Close()
 
@@ -285,7 +336,7 @@ func (c *Client) CheckStatusWithModel(ctx context.Context, token, modelID, reque
 
 	// Log response status for errors
 	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("FAL Status Check Error: %d %s - %s\n", resp.StatusCode, resp.Status, string(respBody))
+		c.logger.Warn("FAL status check error", "status", resp.StatusCode, "body", string(respBody))
 	}
 
 	// Handle error responses
@@ -295,8 +346,10 @@ func (c *Client) CheckStatusWithModel(ctx context.Context, token, modelID, reque
 			return nil, &FALError{
 				Code:    "http_error",
 				Message: fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(respBody)),
+				Status:  resp.StatusCode,
 			}
 		}
+		falErr.Status = resp.StatusCode
 		return nil, &falErr
 	}
 
@@ -307,15 +360,8 @@ func (c *Client) CheckStatusWithModel(ctx context.Context, token, modelID, reque
 	}
 
 	// Debug: Log the parsed response to understand the structure
-	fmt.Printf("FAL Status Response Debug:\n")
-	fmt.Printf("  Status: %s\n", statusResp.Status)
-	fmt.Printf("  RequestID: %s\n", statusResp.RequestID)
-	fmt.Printf("  Result is nil: %t\n", statusResp.Result == nil)
-	if statusResp.Result != nil {
-		fmt.Printf("  Result.Status: %s\n", statusResp.Result.Status)
-		fmt.Printf("  Result.Images count: %d\n", len(statusResp.Result.Images))
-	}
-	fmt.Printf("  Raw response: %s\n", string(respBody))
+	c.logger.Debug("FAL status response", "status", statusResp.Status, "request_id", statusResp.RequestID,
+		"has_result", statusResp.Result != nil, "body", string(respBody))
 
 	return &statusResp, nil
 }
@@ -323,14 +369,17 @@ func (c *Client) CheckStatusWithModel(ctx context.Context, token, modelID, reque
 // GetResult retrieves the result of a completed generation request
 func (c *Client) GetResult(ctx context.Context, token, modelID, requestID string) (*GenerationResponse, error) {
 	// First convert to FAL format, then get base model ID for result retrieval
-	falModelID := convertToFALModelID(modelID)
-	baseModelID := getBaseModelID(falModelID)
-	
+	_, baseModelID := queuePaths(modelID, ModelInfo{})
+	return c.getQueueResult(ctx, token, baseModelID, requestID)
+}
+
+// getQueueResult retrieves the result of a request queued under baseModelID
+func (c *Client) getQueueResult(ctx context.Context, token, baseModelID, requestID string) (*GenerationResponse, error) {
 	// FAL API result endpoint format (without /status)
 	url := fmt.Sprintf("%s/%s/requests/%s", c.baseURL, baseModelID, requestID)
 
 	// Log result retrieval request
-	fmt.Printf("FAL Get Result: %s (model: %s → %s, request: %s)\n", url, modelID, baseModelID, requestID)
+	c.logger.Debug("FAL get result", "url", url, "model", baseModelID, "request_id", requestID)
 
 	// Create HTTP request
 	httpReq, err := http.NewRequestWithContext(ctx, "GET", url, nil)
@@ -344,7 +393,7 @@ func (c *Client) GetResult(ctx context.Context, token, modelID, requestID string
 	// Send request
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
+		return nil, fmt.Errorf("%w: failed to send request: %w", ErrUnreachable, err)
 	}
 	defer resp.Body.Close()
 
@@ -356,7 +405,7 @@ func (c *Client) GetResult(ctx context.Context, token, modelID, requestID string
 
 	// Log response status for errors
 	if resp.StatusCode != http.StatusOK {
-		fmt.Printf("FAL Get Result Error: %d %s - %s\n", resp.StatusCode, resp.Status, string(respBody))
+		c.logger.Warn("FAL get result error", "status", resp.StatusCode, "body", string(respBody))
 	}
 
 	// Handle error responses
@@ -366,8 +415,10 @@ func (c *Client) GetResult(ctx context.Context, token, modelID, requestID string
 			return nil, &FALError{
 				Code:    "http_error",
 				Message: fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(respBody)),
+				Status:  resp.StatusCode,
 			}
 		}
+		falErr.Status = resp.StatusCode
 		return nil, &falErr
 	}
 
@@ -377,12 +428,28 @@ func (c *Client) GetResult(ctx context.Context, token, modelID, requestID string
 		return nil, fmt.Errorf("failed to parse result response: %w", err)
 	}
 
+	// Image-to-image models such as the upscaler return a single "image"
+	if len(result.Images) == 0 {
+		var single struct {
+			Image *struct {
+				URL    string `json:"url"`
+				Width  int    `json:"width,omitempty"`
+				Height int    `json:"height,omitempty"`
+			} `json:"image"`
+		}
+		if err := json.Unmarshal(respBody, &single); err == nil && single.Image != nil {
+			result.Images = append(result.Images, struct {
+				URL          string `json:"url"`
+				ThumbnailURL string `json:"thumbnail_url,omitempty"`
+				Width        int    `json:"width,omitempty"`
+				Height       int    `json:"height,omitempty"`
+			}{URL: single.Image.URL, Width: single.Image.Width, Height: single.Image.Height})
+		}
+	}
+
 	// Debug: Log the parsed result
-	fmt.Printf("FAL Result Response Debug:\n")
-	fmt.Printf("  RequestID: %s\n", result.RequestID)
-	fmt.Printf("  Status: %s\n", result.Status)
-	fmt.Printf("  Images count: %d\n", len(result.Images))
-	fmt.Printf("  Raw response: %s\n", string(respBody))
+	c.logger.Debug("FAL result response", "request_id", result.RequestID, "status", result.Status,
+		"images", len(result.Images), "body", string(respBody))
 
 	return &result, nil
 }
@@ -395,23 +462,40 @@ func (c *Client) PollForCompletion(ctx context.Context, token, requestID string)
 
 // PollForCompletionWithModel polls for completion of a generation request with model ID
 func (c *Client) PollForCompletionWithModel(ctx context.Context, token, modelID, requestID string) (*GenerationResponse, error) {
+	_, baseModelID := queuePaths(modelID, ModelInfo{})
+	return c.pollQueue(ctx, token, baseModelID, requestID)
+}
+
+// pollQueue polls a request queued under baseModelID until it finishes
+func (c *Client) pollQueue(ctx context.Context, token, baseModelID, requestID string) (*GenerationResponse, error) {
 	// Create a context with timeout
 	ctx, cancel := context.WithTimeout(ctx, c.timeout)
 	defer cancel()
 
-	ticker := time.NewTicker(2 * time.Second) // Poll every 2 seconds
+	ticker := time.NewTicker(c.pollInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
+			// A caller that gave up has not timed out
+			if errors.Is(ctx.Err(), context.Canceled) {
+				return nil, ctx.Err()
+			}
 			return nil, &FALError{
 				Code:    "timeout",
 				Message: "generation request timed out",
 			}
 		case <-ticker.C:
-			status, err := c.CheckStatusWithModel(ctx, token, modelID, requestID)
+			status, err := c.checkQueueStatus(ctx, token, baseModelID, requestID)
 			if err != nil {
+				// The deadline may expire while a status check is in flight
+				if ctx.Err() == context.DeadlineExceeded {
+					return nil, &FALError{
+						Code:    "timeout",
+						Message: "generation request timed out",
+					}
+				}
 				return nil, err
 			}
 
@@ -421,7 +505,7 @@ func (c *Client) PollForCompletionWithModel(ctx context.Context, token, modelID,
 			switch normalizedStatus {
 			case StatusCompleted:
 				// When status is completed, fetch the actual result from the result endpoint
-				result, err := c.GetResult(ctx, token, modelID, requestID)
+				result, err := c.getQueueResult(ctx, token, baseModelID, requestID)
 				if err != nil {
 					return nil, fmt.Errorf("failed to get completed result: %w", err)
 				}
@@ -439,7 +523,7 @@ func (c *Client) PollForCompletionWithModel(ctx context.Context, token, modelID,
 					Code:    "generation_cancelled",
 					Message: "generation was cancelled",
 				}
-			case StatusQueued, StatusProcessing:
+			case StatusQueued, StatusProcessing, StatusInQueue, StatusInProgress:
 				// Continue polling
 				continue
 			default:
@@ -460,26 +544,20 @@ func (c *Client) GenerateImage(ctx context.Context, token string, req Generation
 		return nil, err
 	}
 
-	// Poll for completion - pass the original model ID, let CheckStatusWithModel handle conversion
-	result, err := c.PollForCompletionWithModel(ctx, token, req.Model, queueResp.RequestID)
+	// Poll the queue the request was submitted to
+	model, _ := req.modelInfo()
+	_, baseModelID := queuePaths(req.Model, model)
+	result, err := c.pollQueue(ctx, token, baseModelID, queueResp.RequestID)
 	if err != nil {
+		var falErr *FALError
+		if errors.As(err, &falErr) && falErr.RequestID == "" {
+			falErr.RequestID = queueResp.RequestID
+		}
 		return nil, err
 	}
 
-	// Calculate cost based on model and number of images
-	model, _ := GetModel(req.Model)
-	numImages := 1
-	if req.Parameters != nil {
-		if num, ok := req.Parameters["num_images"]; ok {
-			if numInt, ok := num.(int); ok {
-				numImages = numInt
-			} else if numFloat, ok := num.(float64); ok {
-				numImages = int(numFloat)
-			}
-		}
-	}
-	
-	result.Cost = model.CostPerImage * float64(numImages)
+	// Only the images actually delivered are charged
+	result.Settle(&model, req.Parameters)
 	result.RequestID = queueResp.RequestID
 
 	return result, nil
@@ -507,7 +585,7 @@ func (c *Client) CancelGeneration(ctx context.Context, token, requestID string)
 	// Send request
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return fmt.Errorf("%w: failed to send request: %w", ErrUnreachable, err)
 	}
 	defer resp.Body.Close()
 
@@ -519,8 +597,10 @@ func (c *Client) CancelGeneration(ctx context.Context, token, requestID string)
 			return &FALError{
 				Code:    "http_error",
 				Message: fmt.Sprintf("HTTP %d: %s", resp.StatusCode, string(respBody)),
+				Status:  resp.StatusCode,
 			}
 		}
+		falErr.Status = resp.StatusCode
 		return &falErr
 	}
 
@@ -536,7 +616,7 @@ func (c *Client) ValidateToken(ctx context.Context, token string) error {
 	url := fmt.Sprintf("%s/%s", c.baseURL, falModelID)
 	
 	// Log token validation request
-	fmt.Printf("FAL Token Validation: %s\n", url)
+	c.logger.Debug("FAL token validation", "url", url)
 	
 	testReq := map[string]interface{}{
 		"prompt": "test",
@@ -564,7 +644,7 @@ func (c *Client) ValidateToken(ctx context.Context, token string) error {
 	// Send request
 	resp, err := c.httpClient.Do(httpReq)
 	if err != nil {
-		return fmt.Errorf("failed to send request: %w", err)
+		return fmt.Errorf("%w: failed to send request: %w", ErrUnreachable, err)
 	}
 	defer resp.Body.Close()
 
@@ -573,6 +653,22 @@ func (c *Client) ValidateToken(ctx context.Context, token string) error {
 		return &FALError{
 			Code:    "invalid_token",
 			Message: "invalid or expired FAL AI token",
+			Status:  resp.StatusCode,
+		}
+	}
+
+	if resp.StatusCode == http.StatusForbidden {
+		return &FALError{
+			Code:    "invalid_token",
+			Message: "FAL AI token is not allowed to use this endpoint",
+			Status:  resp.StatusCode,
+		}
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &FALError{
+			Code:    "rate_limited",
+			Message: "FAL AI token is rate limited",
+			Status:  resp.StatusCode,
 		}
 	}
 
@@ -580,6 +676,38 @@ func (c *Client) ValidateToken(ctx context.Context, token string) error {
 	return nil
 }
 
+// ProbeRequestID is the request polled by availability probes. It never
+// exists, so a healthy endpoint answers without running the model.
+const ProbeRequestID = "availability-probe"
+
+// ErrUnreachable marks probe failures where no response arrived at all, such
+// as DNS, proxy or firewall problems, as opposed to an endpoint erroring
+var ErrUnreachable = errors.New("FAL AI is unreachable")
+
+// ProbeModel checks that a model's queue endpoint is answering by polling the
+// status of a request that does not exist. No token is sent; rejections are
+// expected and only transport and server errors count as failures.
+func (c *Client) ProbeModel(ctx context.Context, modelID string) error {
+	_, base := queuePaths(modelID, ModelInfo{})
+	url := fmt.Sprintf("%s/%s/requests/%s/status", c.baseURL, base, ProbeRequestID)
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("%w: failed to reach endpoint: %v", ErrUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("endpoint returned HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
 // GetModels returns information about all supported models
 func (c *Client) GetModels() map[string]ModelInfo {
 	return GetAllModels()
@@ -0,0 +1,56 @@
+package fal
+
+// Statuses of the images requested from a generation
+const (
+	ImageDelivered = "delivered"
+	ImageFailed    = "failed"  // returned without a URL
+	ImageMissing   = "missing" // requested but not returned
+)
+
+// RequestedImages returns the number of images params ask for, 1 when
+// num_images is unset
+func RequestedImages(params map[string]interface{}) int {
+	switch num := params["num_images"].(type) {
+	case int:
+		return num
+	case float64:
+		return int(num)
+	}
+	return 1
+}
+
+// Settle records the status of each image requested with params, drops the
+// images returned without a URL and charges model's price for the delivered
+// images only. A result with more images than requested is taken as complete.
+func (r *GenerationResponse) Settle(model *ModelInfo, params map[string]interface{}) {
+	requested := RequestedImages(params)
+	statuses := make([]string, 0, requested)
+	delivered := r.Images[:0]
+	for _, img := range r.Images {
+		if img.URL == "" {
+			statuses = append(statuses, ImageFailed)
+			continue
+		}
+		statuses = append(statuses, ImageDelivered)
+		delivered = append(delivered, img)
+	}
+	for len(statuses) < requested {
+		statuses = append(statuses, ImageMissing)
+	}
+
+	r.Images = delivered
+	r.ImageStatuses = statuses
+	if model != nil {
+		r.Cost = model.ImagesCost(len(delivered)).USD()
+	}
+}
+
+// Partial reports whether some of the requested images were not delivered
+func (r *GenerationResponse) Partial() bool {
+	for _, status := range r.ImageStatuses {
+		if status != ImageDelivered {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package fal
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// Stable codes for failed FAL AI calls. API responses report these instead of
+// the raw errors FAL returns, whose wording changes without notice.
+const (
+	ErrorCodeInvalidRequest   = "fal_invalid_request"   // the model or parameters were rejected
+	ErrorCodeAuth             = "fal_auth"              // the FAL AI token was rejected
+	ErrorCodeRateLimited      = "fal_rate_limited"      // FAL AI is throttling the token
+	ErrorCodeUnavailable      = "fal_unavailable"       // FAL AI answered with a server error or could not be reached
+	ErrorCodeTimeout          = "fal_timeout"           // the generation did not finish in time
+	ErrorCodeGenerationFailed = "fal_generation_failed" // the model failed while generating
+	ErrorCodeCancelled        = "fal_cancelled"         // the generation was cancelled
+	ErrorCodeUnknown          = "fal_error"             // anything else
+)
+
+// Classification describes a failed FAL AI call in stable terms
+type Classification struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Retryable bool   `json:"retryable"` // whether sending the same request again may succeed
+	Status    int    `json:"-"`         // HTTP status to answer API clients with
+}
+
+var classifications = map[string]Classification{
+	ErrorCodeInvalidRequest: {
+		Code:    ErrorCodeInvalidRequest,
+		Message: "FAL AI rejected the model or parameters of this request",
+		Status:  http.StatusUnprocessableEntity,
+	},
+	ErrorCodeAuth: {
+		Code:    ErrorCodeAuth,
+		Message: "FAL AI rejected your FAL AI token; set up a valid token and try again",
+		Status:  http.StatusBadGateway,
+	},
+	ErrorCodeRateLimited: {
+		Code:      ErrorCodeRateLimited,
+		Message:   "FAL AI is rate limiting your token; wait a moment and try again",
+		Retryable: true,
+		Status:    http.StatusTooManyRequests,
+	},
+	ErrorCodeUnavailable: {
+		Code:      ErrorCodeUnavailable,
+		Message:   "FAL AI is unavailable; try again later",
+		Retryable: true,
+		Status:    http.StatusBadGateway,
+	},
+	ErrorCodeTimeout: {
+		Code:      ErrorCodeTimeout,
+		Message:   "The generation did not finish in time",
+		Retryable: true,
+		Status:    http.StatusGatewayTimeout,
+	},
+	ErrorCodeGenerationFailed: {
+		Code:      ErrorCodeGenerationFailed,
+		Message:   "The model failed while generating the image",
+		Retryable: true,
+		Status:    http.StatusBadGateway,
+	},
+	ErrorCodeCancelled: {
+		Code:    ErrorCodeCancelled,
+		Message: "The generation was cancelled",
+		Status:  http.StatusConflict,
+	},
+	ErrorCodeUnknown: {
+		Code:    ErrorCodeUnknown,
+		Message: "Image generation failed",
+		Status:  http.StatusBadGateway,
+	},
+}
+
+// falErrorCodes maps the codes FAL AI and this package put in FALError.Code
+// onto stable codes, for errors that did not come with an HTTP status
+var falErrorCodes = map[string]string{
+	"invalid_model":           ErrorCodeInvalidRequest,
+	"invalid_parameter_type":  ErrorCodeInvalidRequest,
+	"invalid_parameter_value": ErrorCodeInvalidRequest,
+	"missing_parameter":       ErrorCodeInvalidRequest,
+	"parameter_out_of_range":  ErrorCodeInvalidRequest,
+	"invalid_token":           ErrorCodeAuth,
+	"rate_limited":            ErrorCodeRateLimited,
+	"timeout":                 ErrorCodeTimeout,
+	"generation_failed":       ErrorCodeGenerationFailed,
+	"generation_cancelled":    ErrorCodeCancelled,
+}
+
+// Classify maps an error returned by a FAL client onto a stable code
+func Classify(err error) Classification {
+	return classifications[classifyCode(err)]
+}
+
+func classifyCode(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrorCodeTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrorCodeCancelled
+	case errors.Is(err, ErrUnreachable):
+		return ErrorCodeUnavailable
+	}
+
+	var falErr *FALError
+	if !errors.As(err, &falErr) {
+		return ErrorCodeUnknown
+	}
+
+	switch status := falErr.Status; {
+	case status == http.StatusBadRequest || status == http.StatusUnprocessableEntity || status == http.StatusNotFound:
+		return ErrorCodeInvalidRequest
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrorCodeAuth
+	case status == http.StatusTooManyRequests:
+		return ErrorCodeRateLimited
+	case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+		return ErrorCodeTimeout
+	case status >= 500:
+		return ErrorCodeUnavailable
+	}
+
+	if code, ok := falErrorCodes[falErr.Code]; ok {
+		return code
+	}
+	return ErrorCodeUnknown
+}
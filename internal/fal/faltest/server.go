@@ -0,0 +1,325 @@
+package faltest
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+
+	"generatio-pb/internal/fal"
+)
+
+// Scenario scripts how the fake FAL queue responds
+type Scenario struct {
+	// QueuedPolls is the number of status checks answered with IN_QUEUE
+	// before a request reports IN_PROGRESS and then COMPLETED
+	QueuedPolls int
+
+	// NeverComplete keeps every request queued forever
+	NeverComplete bool
+
+	// Fail makes requests report FAILED instead of COMPLETED
+	Fail bool
+
+	// RateLimitSubmits answers the first N submissions with 429 Too Many Requests
+	RateLimitSubmits int
+
+	// SubmitStatus overrides the HTTP status returned on submission (e.g. 401, 422, 500)
+	SubmitStatus int
+
+	// SubmitBody is returned together with SubmitStatus
+	SubmitBody string
+
+	// Images is the number of images in a completed result (defaults to 1)
+	Images int
+
+	// ProbeStatus overrides the HTTP status answered to availability probes (e.g. 503)
+	ProbeStatus int
+}
+
+// Counts records how many calls each endpoint received
+type Counts struct {
+	Submits      int
+	StatusChecks int
+	Results      int
+	Cancels      int
+	LowPriority  int // submissions sent with X-Fal-Queue-Priority: low
+	Probes       int // availability probes, which poll a request that does not exist
+}
+
+// queuedRequest tracks a submitted request inside the fake queue
+type queuedRequest struct {
+	model     string
+	polls     int
+	cancelled bool
+}
+
+// Server is a fake FAL queue API for exercising fal.Client over real HTTP
+type Server struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	scenario Scenario
+	requests map[string]*queuedRequest
+	counts   Counts
+	nextID   int
+}
+
+// NewServer starts a fake FAL queue server running the given scenario
+func NewServer(scenario Scenario) *Server {
+	s := &Server{
+		scenario: scenario,
+		requests: make(map[string]*queuedRequest),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// NewTLSServer starts the fake FAL queue over HTTPS with a self-signed
+// certificate, standing in for a TLS-terminating gateway. With clientCAs set
+// it only accepts clients presenting a certificate signed by one of them.
+func NewTLSServer(scenario Scenario, clientCAs *x509.CertPool) *Server {
+	s := &Server{
+		scenario: scenario,
+		requests: make(map[string]*queuedRequest),
+	}
+	s.Server = httptest.NewUnstartedServer(http.HandlerFunc(s.handle))
+	if clientCAs != nil {
+		s.Server.TLS = &tls.Config{
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			ClientCAs:  clientCAs,
+		}
+	}
+	s.Server.StartTLS()
+	return s
+}
+
+// SetScenario replaces the active scenario
+func (s *Server) SetScenario(scenario Scenario) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.scenario = scenario
+}
+
+// Counts returns a snapshot of the endpoint call counters
+func (s *Server) Counts() Counts {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.counts
+}
+
+// Cancelled reports whether the given request was cancelled
+func (s *Server) Cancelled(requestID string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	req, exists := s.requests[requestID]
+	return exists && req.cancelled
+}
+
+// Model returns the path the given request was submitted to
+func (s *Server) Model(requestID string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if req, exists := s.requests[requestID]; exists {
+		return req.model
+	}
+	return ""
+}
+
+// lookup finds a request polled under base, which must be the app the
+// request was submitted to, as it is on FAL
+func (s *Server) lookup(base, requestID string) (*queuedRequest, bool) {
+	req, exists := s.requests[requestID]
+	if !exists || (req.model != base && !strings.HasPrefix(req.model, base+"/")) {
+		return nil, false
+	}
+	return req, true
+}
+
+// handle routes queue API calls
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") == "Key invalid_token" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{
+			"code":    "invalid_token",
+			"message": "Invalid API key",
+		})
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	idx := strings.Index(path, "/requests/")
+	if idx < 0 {
+		if r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		s.submit(w, r, path)
+		return
+	}
+
+	base, rest := path[:idx], path[idx+len("/requests/"):]
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(rest, "/status"):
+		s.status(w, base, strings.TrimSuffix(rest, "/status"))
+	case r.Method == http.MethodPut && strings.HasSuffix(rest, "/cancel"):
+		s.cancel(w, strings.TrimSuffix(rest, "/cancel"))
+	case r.Method == http.MethodGet && !strings.Contains(rest, "/"):
+		s.result(w, base, rest)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// submit handles POST /{model}
+func (s *Server) submit(w http.ResponseWriter, r *http.Request, model string) {
+	io.Copy(io.Discard, r.Body)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.counts.Submits++
+	if r.Header.Get("X-Fal-Queue-Priority") == "low" {
+		s.counts.LowPriority++
+	}
+
+	if s.counts.Submits <= s.scenario.RateLimitSubmits {
+		w.Header().Set("Retry-After", "1")
+		writeJSON(w, http.StatusTooManyRequests, map[string]string{
+			"code":    "rate_limited",
+			"message": "Too many requests",
+		})
+		return
+	}
+
+	if s.scenario.SubmitStatus != 0 && s.scenario.SubmitStatus != http.StatusOK {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(s.scenario.SubmitStatus)
+		w.Write([]byte(s.scenario.SubmitBody))
+		return
+	}
+
+	s.nextID++
+	requestID := "fake_request_" + strconv.Itoa(s.nextID)
+	s.requests[requestID] = &queuedRequest{model: model}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"request_id": requestID,
+		"status":     "IN_QUEUE",
+	})
+}
+
+// status handles GET /{model}/requests/{id}/status
+func (s *Server) status(w http.ResponseWriter, base, requestID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Availability probes poll a request ID that is never issued
+	if requestID == fal.ProbeRequestID {
+		s.counts.Probes++
+		if s.scenario.ProbeStatus != 0 {
+			writeJSON(w, s.scenario.ProbeStatus, map[string]string{"message": "service unavailable"})
+			return
+		}
+	} else {
+		s.counts.StatusChecks++
+	}
+
+	req, exists := s.lookup(base, requestID)
+	if !exists {
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"code":    "not_found",
+			"message": "Request not found",
+		})
+		return
+	}
+
+	resp := map[string]interface{}{"request_id": requestID}
+	req.polls++
+
+	switch {
+	case req.cancelled:
+		resp["status"] = "CANCELLED"
+	case s.scenario.NeverComplete || req.polls <= s.scenario.QueuedPolls:
+		resp["status"] = "IN_QUEUE"
+	case req.polls == s.scenario.QueuedPolls+1 && s.scenario.QueuedPolls > 0:
+		resp["status"] = "IN_PROGRESS"
+	case s.scenario.Fail:
+		resp["status"] = "FAILED"
+		resp["error"] = map[string]string{
+			"code":    "generation_failed",
+			"message": "model worker crashed",
+		}
+	default:
+		resp["status"] = "COMPLETED"
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// result handles GET /{model}/requests/{id}
+func (s *Server) result(w http.ResponseWriter, base, requestID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.counts.Results++
+
+	if _, exists := s.lookup(base, requestID); !exists {
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"code":    "not_found",
+			"message": "Request not found",
+		})
+		return
+	}
+
+	count := s.scenario.Images
+	if count <= 0 {
+		count = 1
+	}
+
+	images := make([]map[string]interface{}, 0, count)
+	for i := 0; i < count; i++ {
+		images = append(images, map[string]interface{}{
+			"url":    fmt.Sprintf("%s/files/%s_%d.jpg", s.URL, requestID, i),
+			"width":  1024,
+			"height": 1024,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"images": images,
+		"seed":   42,
+	})
+}
+
+// cancel handles PUT /{model}/requests/{id}/cancel
+func (s *Server) cancel(w http.ResponseWriter, requestID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.counts.Cancels++
+
+	req, exists := s.requests[requestID]
+	if !exists {
+		writeJSON(w, http.StatusNotFound, map[string]string{
+			"code":    "not_found",
+			"message": "Request not found",
+		})
+		return
+	}
+
+	req.cancelled = true
+	writeJSON(w, http.StatusOK, map[string]string{"status": "CANCELLATION_REQUESTED"})
+}
+
+// writeJSON writes a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
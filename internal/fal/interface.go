@@ -15,6 +15,7 @@ type FALClient interface {
 	CheckStatus(ctx context.Context, token, requestID string) (*StatusResponse, error)
 	PollForCompletion(ctx context.Context, token, requestID string) (*GenerationResponse, error)
 	CancelGeneration(ctx context.Context, token, requestID string) error
+	ProbeModel(ctx context.Context, modelID string) error
 }
 
 // Ensure both implementations satisfy the interface
@@ -13,6 +13,7 @@ type MockClient struct {
 	submitGenerationFunc func(ctx context.Context, token string, req GenerationRequest) (*QueueResponse, error)
 	checkStatusFunc      func(ctx context.Context, token, requestID string) (*StatusResponse, error)
 	pollForCompletionFunc func(ctx context.Context, token, requestID string) (*GenerationResponse, error)
+	probeModelFunc       func(ctx context.Context, modelID string) error
 }
 
 // NewMockClient creates a new mock FAL client
@@ -47,6 +48,7 @@ func NewMockClient() *MockClient {
 					},
 				},
 				Cost: 0.003,
+				Seed: mockSeed(req.Parameters),
 			}, nil
 		},
 		getModelsFunc: func() map[string]ModelInfo {
@@ -149,6 +151,14 @@ func (c *MockClient) PollForCompletion(ctx context.Context, token, requestID str
 	return c.pollForCompletionFunc(ctx, token, requestID)
 }
 
+// ProbeModel reports every model as reachable unless a probe function is set (mock implementation)
+func (c *MockClient) ProbeModel(ctx context.Context, modelID string) error {
+	if c.probeModelFunc == nil {
+		return nil
+	}
+	return c.probeModelFunc(ctx, modelID)
+}
+
 // CancelGeneration cancels a generation request (mock implementation)
 func (c *MockClient) CancelGeneration(ctx context.Context, token, requestID string) error {
 	if token == "invalid_token" {
@@ -172,4 +182,22 @@ func (c *MockClient) SetGenerateImageFunc(fn func(ctx context.Context, token str
 // SetGetModelsFunc sets a custom get models function for testing
 func (c *MockClient) SetGetModelsFunc(fn func() map[string]ModelInfo) {
 	c.getModelsFunc = fn
-}
\ No newline at end of file
+}
+
+// SetProbeModelFunc sets a custom probe function for testing
+func (c *MockClient) SetProbeModelFunc(fn func(ctx context.Context, modelID string) error) {
+	c.probeModelFunc = fn
+}
+
+// mockSeed echoes the requested seed like FAL does, or picks a fixed one
+func mockSeed(params map[string]interface{}) int64 {
+	switch seed := params["seed"].(type) {
+	case int:
+		return int64(seed)
+	case int64:
+		return seed
+	case float64:
+		return int64(seed)
+	}
+	return 42
+}
@@ -2,7 +2,10 @@ package fal
 
 import (
 	"fmt"
+	"sort"
 	"time"
+
+	"generatio-pb/internal/money"
 )
 
 // ModelInfo represents information about a FAL AI model
@@ -12,6 +15,93 @@ type ModelInfo struct {
 	Description string             `json:"description"`
 	CostPerImage float64           `json:"cost_per_image"`
 	Parameters  map[string]Parameter `json:"parameters"`
+	Endpoint    string             `json:"endpoint,omitempty"` // FAL endpoint of a user-registered model, e.g. "acme/sdxl-lora"
+	Custom      bool               `json:"custom,omitempty"`
+	Deprecation *Deprecation       `json:"deprecation,omitempty"`
+
+	// Availability is the latest probe outcome, set when listing models
+	Availability *Availability `json:"availability,omitempty"`
+}
+
+// Availability statuses reported for a model
+const (
+	AvailabilityUp   = "available"
+	AvailabilitySlow = "slow"
+	AvailabilityDown = "unavailable"
+)
+
+// Availability is the outcome of the latest probe of a model's endpoint
+type Availability struct {
+	Status    string    `json:"status"`
+	LatencyMS int64     `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Deprecation marks a model that is being phased out
+type Deprecation struct {
+	Sunset       string            `json:"sunset"`                  // YYYY-MM-DD, the last day the model can be requested without a replacement
+	Replacement  string            `json:"replacement,omitempty"`   // model that requests are migrated to
+	ParameterMap map[string]string `json:"parameter_map,omitempty"` // parameter name → name on the replacement; "" drops it
+}
+
+// Retired reports whether the sunset date has passed at now
+func (d *Deprecation) Retired(now time.Time) bool {
+	sunset, err := time.Parse("2006-01-02", d.Sunset)
+	if err != nil {
+		return false
+	}
+	return now.UTC().After(sunset.AddDate(0, 0, 1))
+}
+
+// maxMigrations bounds how many deprecated models a request is migrated through
+const maxMigrations = 5
+
+// MigrateRequest moves a request for a deprecated model in models to its
+// replacement, renaming parameters as the deprecation maps them. Chains of
+// deprecations are followed. It returns the model and parameters to use and a
+// warning for each deprecation encountered. Unknown models are returned unchanged.
+func MigrateRequest(models map[string]ModelInfo, modelID string, params map[string]interface{}, now time.Time) (string, map[string]interface{}, []string, error) {
+	var warnings []string
+	seen := map[string]bool{}
+
+	for {
+		model, exists := models[modelID]
+		if !exists || model.Deprecation == nil {
+			return modelID, params, warnings, nil
+		}
+		deprecation := model.Deprecation
+
+		if deprecation.Replacement == "" {
+			if deprecation.Retired(now) {
+				return "", nil, warnings, fmt.Errorf("model %q was retired on %s", modelID, deprecation.Sunset)
+			}
+			warnings = append(warnings, fmt.Sprintf("model %q is deprecated and will be retired on %s", modelID, deprecation.Sunset))
+			return modelID, params, warnings, nil
+		}
+
+		seen[modelID] = true
+		if seen[deprecation.Replacement] || len(seen) > maxMigrations {
+			return "", nil, warnings, fmt.Errorf("model %q has no usable replacement", modelID)
+		}
+		if _, exists := models[deprecation.Replacement]; !exists {
+			return "", nil, warnings, fmt.Errorf("model %q has no usable replacement", modelID)
+		}
+
+		migrated := make(map[string]interface{}, len(params))
+		for key, value := range params {
+			name, mapped := deprecation.ParameterMap[key]
+			if !mapped {
+				name = key
+			}
+			if name != "" {
+				migrated[name] = value
+			}
+		}
+
+		warnings = append(warnings, fmt.Sprintf("model %q is deprecated (sunset %s); using %q instead", modelID, deprecation.Sunset, deprecation.Replacement))
+		modelID, params = deprecation.Replacement, migrated
+	}
 }
 
 // Parameter represents a model parameter definition
@@ -25,11 +115,46 @@ type Parameter struct {
 	Required    bool        `json:"required"`
 }
 
+// Request priorities. Interactive requests use FAL's normal queue priority;
+// batch requests yield to them.
+const (
+	PriorityInteractive = "interactive"
+	PriorityBatch       = "batch"
+)
+
+// ValidPriority reports whether p is a known request priority
+func ValidPriority(p string) bool {
+	return p == PriorityInteractive || p == PriorityBatch
+}
+
 // GenerationRequest represents a request to generate images
 type GenerationRequest struct {
 	Model      string                 `json:"model"`
 	Prompt     string                 `json:"prompt"`
 	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Priority   string                 `json:"priority,omitempty"` // interactive (default) or batch
+
+	// CustomModel describes a user-registered endpoint; when set it is used
+	// instead of looking Model up in the built-in catalogue
+	CustomModel *ModelInfo `json:"-"`
+}
+
+// modelInfo returns the definition of the requested model
+func (r GenerationRequest) modelInfo() (ModelInfo, bool) {
+	if r.CustomModel != nil {
+		return *r.CustomModel, true
+	}
+	return GetModel(r.Model)
+}
+
+// EstimatedCost returns what the request costs at the model's per-image
+// price, or zero for an unknown model
+func (r GenerationRequest) EstimatedCost() money.Micros {
+	model, exists := r.modelInfo()
+	if !exists {
+		return 0
+	}
+	return model.ImagesCost(RequestedImages(r.Parameters))
 }
 
 // GenerationResponse represents the response from FAL AI
@@ -43,8 +168,17 @@ type GenerationResponse struct {
 		Height      int    `json:"height,omitempty"`
 	} `json:"images"`
 	Cost      float64                `json:"cost,omitempty"`
+	Seed      int64                  `json:"seed,omitempty"` // seed FAL used, needed to reproduce the composition
 	Metadata  map[string]interface{} `json:"metadata,omitempty"`
 	Error     *FALError              `json:"error,omitempty"`
+
+	// Coalesced is set on results shared from an identical request another
+	// caller had in flight; nothing was charged to this caller's key
+	Coalesced bool `json:"-"`
+
+	// ImageStatuses holds the status of each requested image once the result
+	// is settled; Images then only holds the delivered ones
+	ImageStatuses []string `json:"-"`
 }
 
 // QueueResponse represents the initial queue response
@@ -69,6 +203,12 @@ type FALError struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
 	Details interface{} `json:"details,omitempty"`
+
+	// RequestID is the queue request that failed, set once FAL accepted the submission
+	RequestID string `json:"request_id,omitempty"`
+
+	// Status is the HTTP status FAL answered with, or 0 when the error did not come from a response
+	Status int `json:"-"`
 }
 
 // Error implements the error interface
@@ -83,6 +223,10 @@ const (
 	StatusCompleted  = "completed"
 	StatusFailed     = "failed"
 	StatusCancelled  = "cancelled"
+
+	// Queue states as reported by the FAL queue API (after lowercasing)
+	StatusInQueue    = "in_queue"
+	StatusInProgress = "in_progress"
 )
 
 // Supported models with their configurations
@@ -284,9 +428,103 @@ var SupportedModels = map[string]ModelInfo{
 	},
 }
 
-// GetModel returns model information by name
+// Editing models used by the outpainting endpoint and pipeline steps
+const (
+	// OutpaintModel extends a padded canvas into its masked area
+	OutpaintModel = "flux-pro/v1/fill"
+
+	// UpscaleModel enlarges an existing image
+	UpscaleModel = "esrgan"
+
+	// BackgroundRemovalModel cuts the subject out of an existing image
+	BackgroundRemovalModel = "birefnet"
+)
+
+// EditingModels take a source image and are only used by editing endpoints
+// such as outpainting and pipelines, so they are not listed with the
+// text-to-image models
+var EditingModels = map[string]ModelInfo{
+	OutpaintModel: {
+		Name:         OutpaintModel,
+		DisplayName:  "FLUX.1 Pro Fill",
+		Description:  "Fills the masked area of an image, used to extend images beyond their borders",
+		CostPerImage: 0.05,
+		Parameters: map[string]Parameter{
+			"image_url": {
+				Type:        "string",
+				Description: "The image to fill, as a URL or data URI",
+				Required:    true,
+			},
+			"mask_url": {
+				Type:        "string",
+				Description: "The mask, white where the image should be filled",
+				Required:    true,
+			},
+			"num_images": {
+				Type:        "integer",
+				Default:     1,
+				Min:         floatPtr(1),
+				Max:         floatPtr(4),
+				Description: "Number of images to generate",
+				Required:    false,
+			},
+			"seed": {
+				Type:        "integer",
+				Default:     nil,
+				Description: "The same seed and the same prompt given to the same version of the model will output the same image every time",
+				Required:    false,
+			},
+			"output_format": {
+				Type:        "string",
+				Default:     "jpeg",
+				Options:     []string{"jpeg", "png"},
+				Description: "The format of the generated image",
+				Required:    false,
+			},
+		},
+	},
+	UpscaleModel: {
+		Name:         UpscaleModel,
+		DisplayName:  "ESRGAN Upscaler",
+		Description:  "Upscales an image while restoring fine detail",
+		CostPerImage: 0.002,
+		Parameters: map[string]Parameter{
+			"image_url": {
+				Type:        "string",
+				Description: "The image to upscale",
+				Required:    true,
+			},
+			"scale": {
+				Type:        "float",
+				Default:     2.0,
+				Min:         floatPtr(1),
+				Max:         floatPtr(8),
+				Description: "Upscaling factor",
+				Required:    false,
+			},
+		},
+	},
+	BackgroundRemovalModel: {
+		Name:         BackgroundRemovalModel,
+		DisplayName:  "BiRefNet Background Removal",
+		Description:  "Removes the background of an image, leaving the subject on transparency",
+		CostPerImage: 0.002,
+		Parameters: map[string]Parameter{
+			"image_url": {
+				Type:        "string",
+				Description: "The image to cut out",
+				Required:    true,
+			},
+		},
+	},
+}
+
+// GetModel returns model information by name, including editing models
 func GetModel(name string) (ModelInfo, bool) {
-	model, exists := SupportedModels[name]
+	if model, exists := SupportedModels[name]; exists {
+		return model, true
+	}
+	model, exists := EditingModels[name]
 	return model, exists
 }
 
@@ -295,6 +533,17 @@ func GetAllModels() map[string]ModelInfo {
 	return SupportedModels
 }
 
+// EstimateCost returns what a request with params costs, from the per-image
+// price and num_images (1 when unset)
+func (m *ModelInfo) EstimateCost(params map[string]interface{}) float64 {
+	return m.ImagesCost(RequestedImages(params)).USD()
+}
+
+// ImagesCost returns the price of n images, in whole micro-dollars
+func (m *ModelInfo) ImagesCost(n int) money.Micros {
+	return money.FromUSD(m.CostPerImage).Times(n)
+}
+
 // ValidateParameters validates generation parameters against model requirements
 func (m *ModelInfo) ValidateParameters(params map[string]interface{}) error {
 	for key, value := range params {
@@ -473,6 +722,21 @@ func (m *ModelInfo) ValidateParameters(params map[string]interface{}) error {
 		}
 	}
 
+	// Required parameters must be present
+	names := make([]string, 0, len(m.Parameters))
+	for key := range m.Parameters {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	for _, key := range names {
+		if _, ok := params[key]; m.Parameters[key].Required && !ok {
+			return &FALError{
+				Code:    "missing_parameter",
+				Message: key + " is required",
+			}
+		}
+	}
+
 	return nil
 }
 
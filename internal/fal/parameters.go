@@ -0,0 +1,63 @@
+package fal
+
+// Parameter sources, from lowest to highest precedence
+const (
+	SourceModelDefault = "model_default"
+	SourcePreference   = "preference"
+	SourceRequest      = "request"
+)
+
+// MergedParameters is a request's parameters layered over the user's saved
+// preferences and the model's defaults
+type MergedParameters struct {
+	// Submitted holds the preference and request values sent to FAL AI. Model
+	// defaults are left out: FAL AI applies them itself, and leaving them out
+	// keeps fingerprints of earlier requests stable.
+	Submitted map[string]interface{}
+	// Effective adds the model defaults FAL AI applies to parameters left unset
+	Effective map[string]interface{}
+	// Sources names the layer each effective value came from
+	Sources map[string]string
+}
+
+// MergeParameters layers request over preferences over the defaults of model,
+// which may be nil for an unknown model. Each parameter takes the value of the
+// highest layer setting it, so the result does not depend on map order. A
+// null request value clears the parameter, letting the model default apply;
+// null preferences and defaults are ignored. Values are not copied deeply.
+func MergeParameters(model *ModelInfo, preferences, request map[string]interface{}) MergedParameters {
+	merged := MergedParameters{
+		Submitted: make(map[string]interface{}, len(preferences)+len(request)),
+		Effective: make(map[string]interface{}),
+		Sources:   make(map[string]string),
+	}
+
+	if model != nil {
+		for key, param := range model.Parameters {
+			if param.Default != nil {
+				merged.Effective[key] = param.Default
+				merged.Sources[key] = SourceModelDefault
+			}
+		}
+	}
+
+	for key, value := range preferences {
+		if requested, set := request[key]; value == nil || (set && requested == nil) {
+			continue
+		}
+		merged.Submitted[key] = value
+		merged.Effective[key] = value
+		merged.Sources[key] = SourcePreference
+	}
+
+	for key, value := range request {
+		if value == nil {
+			continue
+		}
+		merged.Submitted[key] = value
+		merged.Effective[key] = value
+		merged.Sources[key] = SourceRequest
+	}
+
+	return merged
+}
@@ -0,0 +1,108 @@
+package fal
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// applyTransport rebuilds the HTTP transport used for FAL AI requests from the
+// proxy and TLS settings. Without an explicit proxy, HTTP_PROXY, HTTPS_PROXY
+// and NO_PROXY are honoured.
+func (c *Client) applyTransport() {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if c.proxy != nil {
+		transport.Proxy = http.ProxyURL(c.proxy)
+	}
+	if c.tlsConfig != nil {
+		transport.TLSClientConfig = c.tlsConfig.Clone()
+	}
+	c.httpClient.Transport = transport
+}
+
+// SetProxy routes FAL AI requests through an explicit proxy, for networks
+// where direct egress to queue.fal.run is blocked. http, https and socks5
+// proxies are supported and credentials may be given in the URL. An empty
+// proxyURL goes back to the proxy environment variables.
+func (c *Client) SetProxy(proxyURL string) error {
+	if proxyURL == "" {
+		c.proxy = nil
+		c.applyTransport()
+		return nil
+	}
+
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch parsed.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("unsupported proxy scheme %q (use http, https or socks5)", parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("proxy URL %q has no host", proxyURL)
+	}
+
+	c.proxy = parsed
+	c.applyTransport()
+	return nil
+}
+
+// Proxy describes the explicit proxy with any password masked, or returns ""
+// when the proxy environment variables are used
+func (c *Client) Proxy() string {
+	if c.proxy == nil {
+		return ""
+	}
+	return c.proxy.Redacted()
+}
+
+// SetTLS configures TLS for deployments that send FAL AI traffic through an
+// internal TLS-terminating gateway. caFile is a PEM bundle trusted in addition
+// to the system roots; certFile and keyFile are a PEM client certificate and
+// key presented for mutual TLS and must be given together. Empty arguments go
+// back to the system roots without a client certificate.
+func (c *Client) SetTLS(caFile, certFile, keyFile string) error {
+	if (certFile == "") != (keyFile == "") {
+		return fmt.Errorf("client certificate and key must be configured together")
+	}
+	if caFile == "" && certFile == "" {
+		c.tlsConfig = nil
+		c.applyTransport()
+		return nil
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		bundle, err := os.ReadFile(caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(bundle) {
+			return fmt.Errorf("no PEM certificates found in CA bundle %s", caFile)
+		}
+		config.RootCAs = roots
+	}
+
+	if certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	c.tlsConfig = config
+	c.applyTransport()
+	return nil
+}
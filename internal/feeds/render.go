@@ -0,0 +1,175 @@
+package feeds
+
+import (
+	"encoding/xml"
+	"html"
+	"time"
+)
+
+// Feed formats
+const (
+	FormatRSS  = "rss"
+	FormatAtom = "atom"
+)
+
+// Content types of the formats
+const (
+	ContentTypeRSS  = "application/rss+xml; charset=utf-8"
+	ContentTypeAtom = "application/atom+xml; charset=utf-8"
+)
+
+// Channel describes the feed as a whole
+type Channel struct {
+	Title   string
+	Link    string // the feed's own URL
+	Updated time.Time
+}
+
+// Item is one published image
+type Item struct {
+	ID        string
+	Title     string
+	Link      string // the image file
+	Prompt    string
+	Model     string
+	Published time.Time
+}
+
+// Render encodes the channel and items as an RSS 2.0 or Atom document
+func Render(format string, channel Channel, items []Item) ([]byte, error) {
+	var doc interface{}
+	if format == FormatAtom {
+		doc = atomDocument(channel, items)
+	} else {
+		doc = rssDocument(channel, items)
+	}
+
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// description is the HTML summary readers show for an item
+func (i Item) description() string {
+	return `<p><img src="` + html.EscapeString(i.Link) + `" alt="` + html.EscapeString(i.Title) + `"></p>` +
+		"<p>" + html.EscapeString(i.Prompt) + "</p><p>Model: " + html.EscapeString(i.Model) + "</p>"
+}
+
+type rss struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Media   string     `xml:"xmlns:media,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Self          atomLink  `xml:"atom:link"`
+	Description   string    `xml:"description"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	GUID        rssGUID      `xml:"guid"`
+	PubDate     string       `xml:"pubDate"`
+	Description string       `xml:"description"`
+	Media       mediaContent `xml:"media:content"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type mediaContent struct {
+	URL    string `xml:"url,attr"`
+	Medium string `xml:"medium,attr"`
+}
+
+func rssDocument(channel Channel, items []Item) rss {
+	doc := rss{
+		Version: "2.0",
+		Media:   "http://search.yahoo.com/mrss/",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:         channel.Title,
+			Link:          channel.Link,
+			Self:          atomLink{Href: channel.Link, Rel: "self", Type: "application/rss+xml"},
+			Description:   "New images published in " + channel.Title,
+			LastBuildDate: channel.Updated.UTC().Format(time.RFC1123Z),
+			Items:         make([]rssItem, 0, len(items)),
+		},
+	}
+	for _, item := range items {
+		doc.Channel.Items = append(doc.Channel.Items, rssItem{
+			Title:       item.Title,
+			Link:        item.Link,
+			GUID:        rssGUID{Value: item.ID},
+			PubDate:     item.Published.UTC().Format(time.RFC1123Z),
+			Description: item.description(),
+			Media:       mediaContent{URL: item.Link, Medium: "image"},
+		})
+	}
+	return doc
+}
+
+type atom struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Link    atomLink    `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID        string      `xml:"id"`
+	Title     string      `xml:"title"`
+	Updated   string      `xml:"updated"`
+	Published string      `xml:"published"`
+	Links     []atomLink  `xml:"link"`
+	Summary   atomContent `xml:"summary"`
+}
+
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+func atomDocument(channel Channel, items []Item) atom {
+	doc := atom{
+		ID:      channel.Link,
+		Title:   channel.Title,
+		Updated: channel.Updated.UTC().Format(time.RFC3339),
+		Link:    atomLink{Href: channel.Link, Rel: "self", Type: "application/atom+xml"},
+		Entries: make([]atomEntry, 0, len(items)),
+	}
+	for _, item := range items {
+		published := item.Published.UTC().Format(time.RFC3339)
+		doc.Entries = append(doc.Entries, atomEntry{
+			ID:        "urn:generatio:image:" + item.ID,
+			Title:     item.Title,
+			Updated:   published,
+			Published: published,
+			Links: []atomLink{
+				{Href: item.Link, Rel: "alternate"},
+				{Href: item.Link, Rel: "enclosure"},
+			},
+			Summary: atomContent{Type: "html", Value: item.description()},
+		})
+	}
+	return doc
+}
@@ -0,0 +1,245 @@
+package feeds
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"generatio-pb/internal/moderation"
+	"generatio-pb/internal/repository"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Collection stores the feeds users publish
+const Collection = "gallery_feeds"
+
+const (
+	// MaxItems is how many of the newest images a feed lists
+	MaxItems = 50
+
+	// DefaultWidgetImages is how many images a gallery widget lists without ?limit=
+	DefaultWidgetImages = 12
+
+	// MaxFeedsPerUser caps the feeds one user can publish
+	MaxFeedsPerUser = 20
+
+	// MaxTitleLen caps feed titles
+	MaxTitleLen = 120
+
+	// DefaultGalleryTitle names gallery feeds without a title
+	DefaultGalleryTitle = "Generatio gallery"
+)
+
+// ErrNotFound is returned for feeds that do not exist, belong to another
+// user or no longer have anything to publish
+var ErrNotFound = errors.New("feed not found")
+
+// Feed publishes a user's gallery, or one folder, to anyone holding its token.
+// A gallery feed lists the images in the user's folders not marked private.
+type Feed struct {
+	ID       string    `json:"id"`
+	FolderID string    `json:"folder_id,omitempty"` // empty for gallery feeds
+	Title    string    `json:"title"`
+	URL      string    `json:"url"` // filled in by the API layer
+	Created  time.Time `json:"created"`
+
+	UserID string `json:"-"`
+	Token  string `json:"-"`
+}
+
+// Store creates, revokes and reads feeds
+type Store struct {
+	app     core.App
+	folders *repository.Folders
+	images  *repository.Images
+}
+
+// NewStore creates a store backed by the gallery_feeds collection
+func NewStore(app core.App) *Store {
+	return &Store{app: app, folders: repository.NewFolders(app), images: repository.NewImages(app)}
+}
+
+// Create publishes userID's gallery, or the folder when folderID is set.
+// Callers check that the user may publish the folder. Publishing the same
+// target again returns the existing feed.
+func (s *Store) Create(userID, folderID, title string) (*Feed, error) {
+	title = strings.TrimSpace(title)
+	if len(title) > MaxTitleLen {
+		return nil, fmt.Errorf("title must be at most %d characters", MaxTitleLen)
+	}
+
+	existing, err := s.userFeeds(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range existing {
+		if record.GetString("folder_id") == folderID {
+			return fromRecord(record), nil
+		}
+	}
+	if len(existing) >= MaxFeedsPerUser {
+		return nil, fmt.Errorf("you cannot publish more than %d feeds", MaxFeedsPerUser)
+	}
+
+	if title == "" {
+		title = DefaultGalleryTitle
+		if folderID != "" {
+			if folder, err := s.folders.Get(folderID); err == nil {
+				title = folder.GetString("name")
+			}
+		}
+	}
+
+	collection, err := s.app.FindCollectionByNameOrId(Collection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find feeds collection: %w", err)
+	}
+
+	token := make([]byte, 24)
+	if _, err := rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate feed token: %w", err)
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("user_id", userID)
+	record.Set("folder_id", folderID)
+	record.Set("title", title)
+	record.Set("token", hex.EncodeToString(token))
+	if err := s.app.Save(record); err != nil {
+		return nil, fmt.Errorf("failed to save feed: %w", err)
+	}
+	return fromRecord(record), nil
+}
+
+// List returns a user's feeds, oldest first
+func (s *Store) List(userID string) ([]*Feed, error) {
+	records, err := s.userFeeds(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	feeds := make([]*Feed, 0, len(records))
+	for _, record := range records {
+		feeds = append(feeds, fromRecord(record))
+	}
+	return feeds, nil
+}
+
+// Delete revokes one of the user's feeds; its URL stops working
+func (s *Store) Delete(id, userID string) error {
+	record, err := s.app.FindRecordById(Collection, id)
+	if err != nil || record.GetString("user_id") != userID {
+		return ErrNotFound
+	}
+	return s.app.Delete(record)
+}
+
+// ByToken loads the feed a URL token names. Folder feeds are not found once
+// their folder is deleted or marked private.
+func (s *Store) ByToken(token string) (*Feed, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	record, err := s.app.FindFirstRecordByData(Collection, "token", token)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+
+	feed := fromRecord(record)
+	if feed.FolderID != "" {
+		if _, err := s.publicFolder(feed.FolderID); err != nil {
+			return nil, err
+		}
+	}
+	return feed, nil
+}
+
+// Images returns the newest images the feed publishes. Deleted and
+// quarantined images are left out.
+func (s *Store) Images(feed *Feed) ([]*core.Record, error) {
+	folderIDs := []string{feed.FolderID}
+	if feed.FolderID == "" {
+		folders, err := s.folders.Find("user_id = {:user_id} && private = false", "", 0, 0,
+			map[string]any{"user_id": feed.UserID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch folders: %w", err)
+		}
+		folderIDs = folderIDs[:0]
+		for _, folder := range folders {
+			folderIDs = append(folderIDs, folder.Id)
+		}
+		if len(folderIDs) == 0 {
+			return []*core.Record{}, nil
+		}
+	}
+
+	params := map[string]any{"quarantined": moderation.StatusQuarantined}
+	clauses := make([]string, 0, len(folderIDs))
+	for i, folderID := range folderIDs {
+		key := fmt.Sprintf("folder%d", i)
+		clauses = append(clauses, "folder_id = {:"+key+"}")
+		params[key] = folderID
+	}
+	filter := "(" + strings.Join(clauses, " || ") + ") && moderation_status != {:quarantined}"
+	if feed.FolderID == "" {
+		// A gallery only shows the user's own images, not those others added to their folders
+		filter += " && user_id = {:user_id}"
+		params["user_id"] = feed.UserID
+	}
+
+	records, err := s.images.Find(filter, "-created,-id", MaxItems, 0, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch images: %w", err)
+	}
+	return records, nil
+}
+
+// Publishes reports whether the feed lists image
+func (s *Store) Publishes(feed *Feed, image *core.Record) bool {
+	if !image.GetDateTime("deleted_at").IsZero() || image.GetString("moderation_status") == moderation.StatusQuarantined {
+		return false
+	}
+	if feed.FolderID != "" {
+		return image.GetString("folder_id") == feed.FolderID
+	}
+	if image.GetString("user_id") != feed.UserID {
+		return false
+	}
+	folder, err := s.publicFolder(image.GetString("folder_id"))
+	return err == nil && folder.GetString("user_id") == feed.UserID
+}
+
+// publicFolder loads a live folder not marked private
+func (s *Store) publicFolder(folderID string) (*core.Record, error) {
+	if folderID == "" {
+		return nil, ErrNotFound
+	}
+	folder, err := s.folders.Get(folderID)
+	if err != nil || folder.GetBool("private") {
+		return nil, ErrNotFound
+	}
+	return folder, nil
+}
+
+func (s *Store) userFeeds(userID string) ([]*core.Record, error) {
+	records, err := s.app.FindRecordsByFilter(Collection, "user_id = {:user_id}", "created", 0, 0, map[string]any{"user_id": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch feeds: %w", err)
+	}
+	return records, nil
+}
+
+func fromRecord(record *core.Record) *Feed {
+	return &Feed{
+		ID:       record.Id,
+		FolderID: record.GetString("folder_id"),
+		Title:    record.GetString("title"),
+		Created:  record.GetDateTime("created").Time(),
+		UserID:   record.GetString("user_id"),
+		Token:    record.GetString("token"),
+	}
+}
@@ -0,0 +1,201 @@
+package folderacl
+
+import (
+	"fmt"
+
+	"generatio-pb/internal/orgs"
+	"generatio-pb/internal/repository"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Collection stores explicit per-folder grants
+const Collection = "folder_permissions"
+
+// Folder roles. The folder's creator is always its owner; editors and viewers
+// are granted explicitly or inherited from the folder's organization.
+const (
+	RoleOwner  = "owner"  // Shares, deletes and edits the folder
+	RoleEditor = "editor" // Adds images and subfolders
+	RoleViewer = "viewer" // Browses the folder's images
+)
+
+// Grant is an explicit permission on a folder
+type Grant struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email,omitempty"`
+	Role   string `json:"role"`
+}
+
+// ValidGrantRole reports whether role can be granted explicitly
+func ValidGrantRole(role string) bool {
+	return role == RoleEditor || role == RoleViewer
+}
+
+// CanView reports whether role may see the folder and its images
+func CanView(role string) bool {
+	return role != ""
+}
+
+// CanEdit reports whether role may add images and subfolders
+func CanEdit(role string) bool {
+	return role == RoleOwner || role == RoleEditor
+}
+
+// CanDelete reports whether role may delete the folder or change its grants
+func CanDelete(role string) bool {
+	return role == RoleOwner
+}
+
+// Folders looks up live folders
+type Folders interface {
+	Get(id string) (*core.Record, error)
+}
+
+// Service resolves and manages folder permissions
+type Service struct {
+	app     core.App
+	orgs    *orgs.Service
+	folders Folders
+}
+
+// NewService creates a folder permission service; org roles map onto folder
+// roles (owner/admin -> owner, member -> editor, viewer -> viewer)
+func NewService(app core.App, orgService *orgs.Service) *Service {
+	return &Service{app: app, orgs: orgService, folders: repository.NewFolders(app)}
+}
+
+// SetFolders replaces where folders are looked up, e.g. with an in-memory
+// repository in tests
+func (s *Service) SetFolders(folders Folders) {
+	s.folders = folders
+}
+
+// Role returns the user's effective role on a folder, or "" without access.
+// The most privileged of ownership, org membership and explicit grant wins.
+func (s *Service) Role(folder *core.Record, userID string) string {
+	if folder.GetString("user_id") == userID {
+		return RoleOwner
+	}
+
+	role := ""
+	if orgID := folder.GetString("org_id"); orgID != "" {
+		if orgRole, err := s.orgs.Role(orgID, userID); err == nil {
+			switch {
+			case orgs.CanManage(orgRole):
+				return RoleOwner
+			case orgs.CanWrite(orgRole):
+				role = RoleEditor
+			default:
+				role = RoleViewer
+			}
+		}
+	}
+
+	if grant, err := s.findGrant(folder.Id, userID); err == nil {
+		if granted := grant.GetString("role"); granted == RoleEditor || role == "" {
+			role = granted
+		}
+	}
+	return role
+}
+
+// Find loads a live folder and the user's role on it
+func (s *Service) Find(folderID, userID string) (*core.Record, string, error) {
+	folder, err := s.folders.Get(folderID)
+	if err != nil {
+		return nil, "", fmt.Errorf("folder not found")
+	}
+
+	role := s.Role(folder, userID)
+	if !CanView(role) {
+		return nil, "", fmt.Errorf("folder not found")
+	}
+	return folder, role, nil
+}
+
+// SharedWith returns the ids of folders explicitly shared with a user
+func (s *Service) SharedWith(userID string) ([]string, error) {
+	if _, err := s.app.FindCollectionByNameOrId(Collection); err != nil {
+		return nil, nil
+	}
+
+	records, err := s.app.FindRecordsByFilter(Collection, "user_id = {:user_id}", "", 0, 0, map[string]any{"user_id": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch grants: %w", err)
+	}
+
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.GetString("folder_id"))
+	}
+	return ids, nil
+}
+
+// Grants lists the explicit grants on a folder
+func (s *Service) Grants(folderID string) ([]Grant, error) {
+	records, err := s.app.FindRecordsByFilter(Collection, "folder_id = {:folder_id}", "", 0, 0, map[string]any{"folder_id": folderID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch grants: %w", err)
+	}
+
+	grants := make([]Grant, 0, len(records))
+	for _, record := range records {
+		grant := Grant{
+			UserID: record.GetString("user_id"),
+			Role:   record.GetString("role"),
+		}
+		if user, err := s.app.FindRecordById("generatio_users", grant.UserID); err == nil {
+			grant.Email = user.Email()
+		}
+		grants = append(grants, grant)
+	}
+	return grants, nil
+}
+
+// Grant gives a user a role on a folder, replacing any existing grant
+func (s *Service) Grant(folder *core.Record, userID, role string) error {
+	if !ValidGrantRole(role) {
+		return fmt.Errorf("role must be one of: editor, viewer")
+	}
+	if folder.GetString("user_id") == userID {
+		return fmt.Errorf("the folder owner already has full access")
+	}
+
+	record, err := s.findGrant(folder.Id, userID)
+	if err != nil {
+		collection, err := s.app.FindCollectionByNameOrId(Collection)
+		if err != nil {
+			return fmt.Errorf("failed to find folder_permissions collection: %w", err)
+		}
+		record = core.NewRecord(collection)
+		record.Set("folder_id", folder.Id)
+		record.Set("user_id", userID)
+	}
+
+	record.Set("role", role)
+	if err := s.app.Save(record); err != nil {
+		return fmt.Errorf("failed to save grant: %w", err)
+	}
+	return nil
+}
+
+// Revoke removes a user's explicit grant on a folder
+func (s *Service) Revoke(folderID, userID string) error {
+	record, err := s.findGrant(folderID, userID)
+	if err != nil {
+		return fmt.Errorf("grant not found")
+	}
+
+	if err := s.app.Delete(record); err != nil {
+		return fmt.Errorf("failed to revoke grant: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) findGrant(folderID, userID string) (*core.Record, error) {
+	return s.app.FindFirstRecordByFilter(Collection, "folder_id = {:folder_id} && user_id = {:user_id}", map[string]any{
+		"folder_id": folderID,
+		"user_id":   userID,
+	})
+}
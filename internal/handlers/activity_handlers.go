@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"generatio-pb/internal/activity"
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/pagination"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// maxListedActivity caps one page of the activity feed
+const maxListedActivity = 50
+
+// GetActivity handles GET /api/custom/activity
+// Returns the user's recent generations, pipeline runs, folder changes,
+// shares, alerts and account changes, newest first.
+// Query parameters: limit, the cursor from a previous page's next_cursor, and
+// types, a comma-separated list of event types
+func (h *Handler) GetActivity(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	query := e.Request.URL.Query()
+	page, err := pagination.Parse(query, maxListedActivity)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+	if page.Number > 1 {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "The activity feed pages by cursor; use next_cursor")
+	}
+
+	var types []string
+	if raw := query.Get("types"); raw != "" {
+		for _, eventType := range strings.Split(raw, ",") {
+			eventType = strings.TrimSpace(eventType)
+			if !slices.Contains(activity.Types, eventType) {
+				return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation,
+					"Unknown event type "+eventType+"; use "+strings.Join(activity.Types, ", "))
+			}
+			types = append(types, eventType)
+		}
+	}
+
+	events, next, err := h.activity.List(user.Id, types, page)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch activity")
+	}
+
+	return h.listJSON(e, events, map[string]interface{}{
+		"next_cursor": next,
+	})
+}
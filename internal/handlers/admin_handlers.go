@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"generatio-pb/internal/availability"
+	"generatio-pb/internal/contentfilter"
+	"generatio-pb/internal/crypto"
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// GetMaintenance handles GET /api/custom/admin/maintenance
+func (h *Handler) GetMaintenance(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	return h.respond(e, http.StatusOK, h.maintenance.Status())
+}
+
+// CheckConnectivity handles POST /api/custom/admin/connectivity/check
+// It probes FAL AI right away, leaving degraded mode as soon as it answers
+func (h *Handler) CheckConnectivity(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	ctx, cancel := context.WithTimeout(e.Request.Context(), availability.ProbeTimeout)
+	defer cancel()
+
+	return h.respond(e, http.StatusOK, h.availability.CheckConnectivity(ctx))
+}
+
+// SetMaintenance handles POST /api/custom/admin/maintenance
+func (h *Handler) SetMaintenance(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	var req localmodels.SetMaintenanceRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	h.maintenance.Set(req.Enabled, req.Message)
+
+	status := h.maintenance.Status()
+	h.logger.Info("Maintenance mode updated",
+		"enabled", status.Enabled,
+		"superuser_id", e.Auth.Id,
+	)
+
+	return h.respond(e, http.StatusOK, status)
+}
+
+// GetDeadNotifications handles GET /api/custom/admin/notifications/dead
+func (h *Handler) GetDeadNotifications(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	records, err := h.notifier.DeadLetters(100)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch notifications")
+	}
+
+	notifications := make([]localmodels.DeadNotification, 0, len(records))
+	for _, record := range records {
+		notifications = append(notifications, localmodels.DeadNotification{
+			ID:        record.Id,
+			UserID:    record.GetString("user_id"),
+			Channel:   record.GetString("channel"),
+			Event:     record.GetString("event"),
+			Subject:   record.GetString("subject"),
+			Attempts:  record.GetInt("attempts"),
+			LastError: record.GetString("last_error"),
+		})
+	}
+
+	return h.listJSON(e, notifications, nil)
+}
+
+// RetryNotification handles POST /api/custom/admin/notifications/{id}/retry
+func (h *Handler) RetryNotification(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	if err := h.notifier.Retry(e.Request.PathValue("id")); err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Notification requeued",
+	})
+}
+
+// GetFilterTerms handles GET /api/custom/admin/content-filter/terms
+func (h *Handler) GetFilterTerms(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	terms, err := h.filter.Terms()
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch content filter terms")
+	}
+	if terms == nil {
+		terms = []contentfilter.Term{}
+	}
+
+	return h.listJSON(e, terms, map[string]interface{}{
+		"strictness": h.filter.Strictness(),
+		"defaults":   contentfilter.DefaultTerms(),
+	})
+}
+
+// AddFilterTerm handles POST /api/custom/admin/content-filter/terms
+func (h *Handler) AddFilterTerm(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	var req localmodels.AddFilterTermRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	term, err := h.filter.AddTerm(contentfilter.Term{
+		Term:     req.Term,
+		Kind:     req.Kind,
+		Severity: req.Severity,
+	})
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	h.logger.Info("Content filter term added",
+		"term", term.Term,
+		"kind", term.Kind,
+		"superuser_id", e.Auth.Id,
+	)
+
+	return h.respond(e, http.StatusOK, term)
+}
+
+// DeleteFilterTerm handles DELETE /api/custom/admin/content-filter/terms/{id}
+func (h *Handler) DeleteFilterTerm(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	if err := h.filter.DeleteTerm(e.Request.PathValue("id")); err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, err.Error())
+	}
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Term deleted",
+	})
+}
+
+// RunRetention handles POST /api/custom/admin/retention/run
+func (h *Handler) RunRetention(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	report, err := h.retention.Run()
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Retention run failed")
+	}
+
+	h.logger.Info("Retention run triggered",
+		"archived", report.Archived,
+		"deleted", report.Deleted,
+		"superuser_id", e.Auth.Id,
+	)
+
+	return h.respond(e, http.StatusOK, report)
+}
+
+// RunReconciliation handles POST /api/custom/admin/reconciliation/run
+// It compares recorded spending with image costs and, with {"fix": true},
+// resets drifted totals
+func (h *Handler) RunReconciliation(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	var req localmodels.RunReconciliationRequest
+	if err := h.decodeJSON(e, &req); err != nil && !errors.Is(err, io.EOF) {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	report, err := h.reconciler.Run(e.Request.Context(), req.Fix)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Reconciliation failed")
+	}
+
+	h.logger.Info("Cost reconciliation triggered",
+		"drifted", report.Drifted,
+		"fixed", report.Fixed,
+		"superuser_id", e.Auth.Id,
+	)
+
+	return h.respond(e, http.StatusOK, report)
+}
+
+// RunAnomalyCheck handles POST /api/custom/admin/anomalies/run
+// It checks spending for anomalies now instead of waiting for the schedule
+func (h *Handler) RunAnomalyCheck(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	report, err := h.anomalies.Run(e.Request.Context())
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Anomaly check failed")
+	}
+
+	h.logger.Info("Spending anomaly check triggered", "flagged", report.Flagged, "superuser_id", e.Auth.Id)
+
+	return h.respond(e, http.StatusOK, report)
+}
+
+// GetReconciliation handles GET /api/custom/admin/reconciliation
+// It returns the report of the latest scheduled or manual run
+func (h *Handler) GetReconciliation(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	report := h.reconciler.LastReport()
+	if report == nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "No reconciliation has run yet")
+	}
+
+	return h.respond(e, http.StatusOK, report)
+}
+
+// GetSessionStats handles GET /api/custom/admin/sessions
+// It reports how full the session store is and how many sessions were evicted
+func (h *Handler) GetSessionStats(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	return h.respond(e, http.StatusOK, h.sessionStore.Stats())
+}
+
+// RunCryptoBenchmark handles POST /api/custom/admin/crypto/benchmark
+// It times encrypting and decrypting a FAL AI key under several key
+// derivation settings and recommends the strongest within a login latency
+// target. An empty body benchmarks crypto.DefaultProfiles.
+func (h *Handler) RunCryptoBenchmark(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	var opts crypto.BenchmarkOptions
+	if err := h.decodeJSON(e, &opts); err != nil && !errors.Is(err, io.EOF) {
+		return h.invalidBodyResponse(e, err)
+	}
+	if err := opts.Normalize(); err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	// Benchmarks saturate a core, so overlapping runs would skew each other
+	if !h.cryptoBenchmark.TryLock() {
+		return h.errorResponse(e, http.StatusConflict, localmodels.ErrCodeValidation, "A crypto benchmark is already running")
+	}
+	defer h.cryptoBenchmark.Unlock()
+
+	report, err := h.encService.Benchmark(opts)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Crypto benchmark failed")
+	}
+
+	h.logger.Info("Crypto benchmark run", "profiles", len(report.Results), "superuser_id", e.Auth.Id)
+
+	return h.respond(e, http.StatusOK, report)
+}
+
+// GetStorageReport handles GET /api/custom/admin/storage/report
+// It shows how much space content-hash deduplication saves
+func (h *Handler) GetStorageReport(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	report, err := h.imageCache.Report()
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to build storage report")
+	}
+
+	return h.respond(e, http.StatusOK, report)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"net/http"
+
+	"generatio-pb/internal/apikeys"
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// apiKeyHeader carries a scoped API key in place of a user auth token
+const apiKeyHeader = "X-API-Key"
+
+// requireScope lets requests authenticated with an API key through only when
+// the key holds scope. Routes without this middleware ignore API keys, so a
+// key can never reach key management, preferences or admin endpoints.
+// Requests authenticated with a user token are unaffected.
+func (h *Handler) requireScope(scope string) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		plaintext := e.Request.Header.Get(apiKeyHeader)
+		if plaintext == "" {
+			return e.Next()
+		}
+
+		key, err := h.apiKeys.Authenticate(plaintext)
+		if err != nil {
+			return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Invalid API key")
+		}
+
+		if !key.HasScope(scope) {
+			return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "API key is missing the "+scope+" scope")
+		}
+
+		user, err := h.userRepo.Get(key.UserID)
+		if err != nil {
+			return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Invalid API key")
+		}
+
+		e.Auth = user
+		return e.Next()
+	}
+}
+
+// ListAPIKeys handles GET /api/custom/api-keys
+func (h *Handler) ListAPIKeys(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	keys, err := h.apiKeys.List(user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch API keys")
+	}
+
+	return h.listJSON(e, keys, map[string]interface{}{
+		"scopes": apikeys.AllScopes,
+	})
+}
+
+// CreateAPIKey handles POST /api/custom/api-keys
+// The plaintext key is only returned in this response
+func (h *Handler) CreateAPIKey(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.CreateAPIKeyRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	plaintext, key, err := h.apiKeys.Create(user.Id, req.Name, req.Scopes)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	h.logger.Info("API key created", "key_id", key.ID, "user_id", user.Id, "scopes", key.Scopes)
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"key":     plaintext,
+		"api_key": key,
+	})
+}
+
+// RevokeAPIKey handles DELETE /api/custom/api-keys/{id}
+func (h *Handler) RevokeAPIKey(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	if err := h.apiKeys.Revoke(user.Id, e.Request.PathValue("id")); err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, err.Error())
+	}
+
+	h.logger.Info("API key revoked", "key_id", e.Request.PathValue("id"), "user_id", user.Id)
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
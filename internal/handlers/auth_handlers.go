@@ -2,12 +2,13 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
-	"log"
+	"fmt"
 	"net/http"
 	"strings"
 	"time"
 
+	"generatio-pb/internal/crypto"
+	"generatio-pb/internal/fal"
 	localmodels "generatio-pb/internal/models"
 
 	"github.com/pocketbase/pocketbase/core"
@@ -15,42 +16,42 @@ import (
 
 // TokenSetup handles POST /api/custom/tokens/setup
 func (h *Handler) TokenSetup(e *core.RequestEvent) error {
-	log.Printf("TokenSetup: Received request from %s", e.Request.RemoteAddr)
-	log.Printf("TokenSetup: Request headers: %+v", e.Request.Header)
-	
 	var req localmodels.SetupTokenRequest
-	if err := json.NewDecoder(e.Request.Body).Decode(&req); err != nil {
-		log.Printf("TokenSetup: Failed to decode request body: %v", err)
-		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Invalid request body")
-	}
-
-	log.Printf("TokenSetup: Request decoded successfully, FAL token length: %d", len(req.FALToken))
-
-	if req.FALToken == "" || req.Password == "" {
-		log.Printf("TokenSetup: Missing required fields - FAL token empty: %t, Password empty: %t", req.FALToken == "", req.Password == "")
-		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "FAL token and password are required")
+	if err := h.decodeJSON(e, &req); err != nil {
+		h.logger.Debug("TokenSetup: failed to decode request body", "error", err)
+		return h.invalidBodyResponse(e, err)
 	}
 
 	// Get authenticated user
-	log.Printf("TokenSetup: Attempting to get authenticated user")
-	log.Printf("TokenSetup: Auth record present: %t", e.Auth != nil)
-	if e.Auth != nil {
-		log.Printf("TokenSetup: Auth record collection: %s, ID: %s", e.Auth.Collection().Name, e.Auth.Id)
-	}
-	
 	user, err := h.getAuthenticatedUser(e)
 	if err != nil {
-		log.Printf("TokenSetup: Authentication failed: %v", err)
+		h.logger.Debug("TokenSetup: authentication failed", "error", err, "has_auth_record", e.Auth != nil)
 		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
 	}
 
-	log.Printf("TokenSetup: User authenticated successfully - ID: %s, Collection: %s", user.Id, user.Collection().Name)
+	h.logger.Debug("TokenSetup: user authenticated", "user_id", user.Id, "collection", user.Collection().Name)
+
+	// The testing slot is opened with the same password as the production key
+	field, err := tokenField(req.Environment)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+	if field == testingTokenField {
+		encrypted, salt, ok := splitStoredToken(user.GetString("fal_token"))
+		if !ok {
+			return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Set up your production FAL token first")
+		}
+		if h.unsealTokens(user.Id, req.Password, crypto.EncryptResult{Encrypted: encrypted, Salt: salt})[0].Err != nil {
+			return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Invalid password")
+		}
+	}
 
 	// Validate FAL token by testing it
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(e.Request.Context(), 30*time.Second)
 	defer cancel()
 	
-	if err := h.falClient.ValidateToken(ctx, req.FALToken); err != nil {
+	// A rate-limited token is still a valid one
+	if err := h.falClient.ValidateToken(ctx, req.FALToken); err != nil && fal.Classify(err).Code != fal.ErrorCodeRateLimited {
 		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Invalid FAL AI token")
 	}
 
@@ -62,14 +63,34 @@ func (h *Handler) TokenSetup(e *core.RequestEvent) error {
 
 	// Store encrypted data and salt together, separated by period
 	combinedToken := encResult.Encrypted + "." + encResult.Salt
-	user.Set("fal_token", combinedToken)
+	user.Set(field, combinedToken)
 	
 	// Save to database
 	if err := h.app.Save(user); err != nil {
 		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to save user data")
 	}
+	h.verifications.Invalidate(user.Id)
+
+	if field == testingTokenField {
+		h.sendSecurityNotice(user, "Your testing FAL AI token was changed",
+			"A testing FAL AI token was saved to your Generatio account. If this wasn't you, change your password and replace the token immediately.")
+
+		return h.respond(e, http.StatusOK, map[string]interface{}{
+			"success":     true,
+			"message":     "Testing FAL token setup successfully",
+			"environment": localmodels.EnvironmentTesting,
+		})
+	}
+
+	// Remembered devices hold the old token, so they have to log in again
+	if _, err := h.devices.RevokeAll(user.Id); err != nil {
+		h.logger.Warn("Failed to forget remembered devices", "error", err, "user_id", user.Id)
+	}
 
-	return e.JSON(http.StatusOK, map[string]interface{}{
+	h.sendSecurityNotice(user, "Your FAL AI token was changed",
+		"A new FAL AI token was saved to your Generatio account. If this wasn't you, change your password and replace the token immediately.")
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
 		"success": true,
 		"message": "FAL token setup successfully",
 	})
@@ -78,12 +99,8 @@ func (h *Handler) TokenSetup(e *core.RequestEvent) error {
 // TokenVerify handles POST /api/custom/tokens/verify
 func (h *Handler) TokenVerify(e *core.RequestEvent) error {
 	var req localmodels.VerifyTokenRequest
-	if err := json.NewDecoder(e.Request.Body).Decode(&req); err != nil {
-		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Invalid request body")
-	}
-
-	if req.Password == "" {
-		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Password is required")
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
 	}
 
 	// Get authenticated user
@@ -109,22 +126,18 @@ func (h *Handler) TokenVerify(e *core.RequestEvent) error {
 
 	if falToken != "" && salt != "" {
 		// Test if password can decrypt the token
-		_, err := h.encService.Decrypt(falToken, salt, req.Password)
-		resp.CanDecrypt = err == nil
+		unsealed := h.unsealTokens(user.Id, req.Password, crypto.EncryptResult{Encrypted: falToken, Salt: salt})
+		resp.CanDecrypt = unsealed[0].Err == nil
 	}
 
-	return e.JSON(http.StatusOK, resp)
+	return h.respond(e, http.StatusOK, resp)
 }
 
 // CreateSession handles POST /api/custom/auth/create-session
 func (h *Handler) CreateSession(e *core.RequestEvent) error {
 	var req localmodels.CreateSessionRequest
-	if err := json.NewDecoder(e.Request.Body).Decode(&req); err != nil {
-		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Invalid request body")
-	}
-
-	if req.Password == "" {
-		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Password is required")
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
 	}
 
 	// Get authenticated user
@@ -147,11 +160,44 @@ func (h *Handler) CreateSession(e *core.RequestEvent) error {
 		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "FAL token not configured. Please setup token first")
 	}
 
+	if _, err := tokenField(req.Environment); err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	if req.RememberDevice {
+		if h.rememberDeviceDays(user) == 0 {
+			return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Remembering devices is turned off for this account")
+		}
+		if strings.TrimSpace(req.DeviceID) == "" {
+			return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "device_id is required to remember a device")
+		}
+	}
+
+	// The testing key is unlocked alongside the production key, deriving
+	// both keys at once
+	stored := []crypto.EncryptResult{{Encrypted: falToken, Salt: salt}}
+	if encrypted, salt, ok := splitStoredToken(user.GetString(testingTokenField)); ok {
+		stored = append(stored, crypto.EncryptResult{Encrypted: encrypted, Salt: salt})
+	}
+	unsealed := h.unsealTokens(user.Id, req.Password, stored...)
+
 	// Decrypt the FAL token
-	decryptedToken, err := h.encService.Decrypt(falToken, salt, req.Password)
-	if err != nil {
+	if unsealed[0].Err != nil {
 		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Invalid password")
 	}
+	decryptedToken := unsealed[0].Plaintext
+
+	var testingToken string
+	if len(unsealed) > 1 {
+		if unsealed[1].Err != nil {
+			h.logger.Warn("Testing FAL token could not be decrypted with the session password", "user_id", user.Id)
+		} else {
+			testingToken = unsealed[1].Plaintext
+		}
+	}
+	if req.Environment == localmodels.EnvironmentTesting && testingToken == "" {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "No testing FAL token is set up")
+	}
 
 	// Remove any existing sessions for this user
 	h.sessionStore.DeleteUserSessions(user.Id)
@@ -168,18 +214,103 @@ func (h *Handler) CreateSession(e *core.RequestEvent) error {
 	}
 
 	resp := localmodels.CreateSessionResponse{
-		SessionID: sessionID,
-		ExpiresAt: session.ExpiresAt,
+		SessionID:    sessionID,
+		ExpiresAt:    session.ExpiresAt,
+		Environment:  localmodels.EnvironmentProduction,
+		Environments: []string{localmodels.EnvironmentProduction},
+	}
+
+	if testingToken != "" {
+		resp.Environments = append(resp.Environments, localmodels.EnvironmentTesting)
+	}
+	if req.Environment == localmodels.EnvironmentTesting {
+		resp.Environment = localmodels.EnvironmentTesting
+	}
+	if err := h.sessionStore.SetTestingKey(sessionID, testingToken, resp.Environment); err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to create session")
 	}
 
-	return e.JSON(http.StatusOK, resp)
+	// Keep the derived keys, so repeating the password during the session
+	// skips key derivation
+	for i, result := range unsealed {
+		if result.Err == nil {
+			if err := h.sessionStore.CacheDerivedKey(sessionID, stored[i].Salt, req.Password, result.Key); err != nil {
+				h.logger.Warn("Failed to cache derived key", "error", err, "user_id", user.Id)
+			}
+		}
+	}
+	for _, result := range unsealed {
+		crypto.ClearMemory(result.Key)
+	}
+	h.setSessionCookie(e, sessionID)
+
+	if req.RememberDevice {
+		if err := h.rememberDevice(user, req, decryptedToken, &resp); err != nil {
+			return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+		}
+	}
+
+	return h.respond(e, http.StatusOK, resp)
+}
+
+// GetSessionInfo handles GET /api/custom/auth/session
+// It describes the caller's session so clients can renew it
+// before it expires. Looking a session up does not count as using it.
+func (h *Handler) GetSessionInfo(e *core.RequestEvent) error {
+	sessionID := h.requestSessionID(e)
+	if sessionID == "" {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Session ID required in X-Session-ID header or session cookie")
+	}
+
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	session, err := h.sessionStore.Describe(sessionID)
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Session not found")
+	}
+
+	if session.UserID != user.Id {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Access denied")
+	}
+
+	resp := localmodels.SessionInfoResponse{
+		CreatedAt:  session.CreatedAt,
+		ExpiresAt:  session.ExpiresAt,
+		TTLSeconds: int(time.Until(session.ExpiresAt).Seconds()),
+	}
+	if !session.LastUsedAt.IsZero() {
+		resp.LastUsedAt = &session.LastUsedAt
+	}
+
+	return h.respond(e, http.StatusOK, resp)
+}
+
+// renewSession extends the caller's session after a successful
+// generation when cfg.RenewSessionOnGeneration is set, so users generating
+// steadily are not logged out mid-work. Failed requests do not renew it.
+func (h *Handler) renewSession(e *core.RequestEvent) error {
+	if err := e.Next(); err != nil || !h.cfg.RenewSessionOnGeneration {
+		return err
+	}
+	if e.Status() != http.StatusOK || e.Auth == nil {
+		return nil
+	}
+
+	sessionID := h.requestSessionID(e)
+	if session, err := h.sessionStore.Describe(sessionID); err == nil && session.UserID == e.Auth.Id {
+		h.sessionStore.ExtendSession(sessionID)
+	}
+	return nil
 }
 
 // DeleteSession handles DELETE /api/custom/auth/session
 func (h *Handler) DeleteSession(e *core.RequestEvent) error {
-	sessionID := e.Request.Header.Get("X-Session-ID")
+	sessionID := h.requestSessionID(e)
 	if sessionID == "" {
-		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Session ID required in X-Session-ID header")
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Session ID required in X-Session-ID header or session cookie")
 	}
 
 	// Get authenticated user
@@ -200,8 +331,9 @@ func (h *Handler) DeleteSession(e *core.RequestEvent) error {
 
 	// Delete session
 	h.sessionStore.Delete(sessionID)
+	h.clearSessionCookie(e)
 
-	return e.JSON(http.StatusOK, map[string]interface{}{
+	return h.respond(e, http.StatusOK, map[string]interface{}{
 		"success": true,
 		"message": "Session deleted successfully",
 	})
@@ -215,8 +347,6 @@ func (h *Handler) TokenStatus(e *core.RequestEvent) error {
 		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
 	}
 
-	log.Printf("TokenStatus: Checking token status for user %s", user.Id)
-
 	// Check if user has stored encrypted token
 	combinedToken := user.GetString("fal_token")
 	hasToken := combinedToken != ""
@@ -236,10 +366,90 @@ func (h *Handler) TokenStatus(e *core.RequestEvent) error {
 		HasToken:         hasToken,
 		HasActiveSession: hasActiveSession,
 		RequiresLogin:    requiresLogin,
+		HasTestingToken:  user.GetString(testingTokenField) != "",
+	}
+
+	h.logger.Debug("TokenStatus checked", "user_id", user.Id, "has_token", hasToken,
+		"has_active_session", hasActiveSession, "requires_login", requiresLogin)
+
+	return h.respond(e, http.StatusOK, response)
+}
+// testingTokenField stores the encrypted key of the testing slot
+const testingTokenField = "fal_token_testing"
+
+// tokenField returns the generatio_users field storing an environment's key
+func tokenField(environment string) (string, error) {
+	switch environment {
+	case "", localmodels.EnvironmentProduction:
+		return "fal_token", nil
+	case localmodels.EnvironmentTesting:
+		return testingTokenField, nil
+	}
+	return "", fmt.Errorf("environment must be %q or %q", localmodels.EnvironmentProduction, localmodels.EnvironmentTesting)
+}
+
+// splitStoredToken splits a stored "encrypted.salt" token
+func splitStoredToken(combined string) (encrypted, salt string, ok bool) {
+	parts := strings.Split(combined, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// unsealTokens decrypts stored keys encrypted with password. Keys whose
+// salt one of the user's sessions, or a recent verification, already derived
+// from the same password are decrypted with the cached key; the rest are
+// derived in parallel and remembered briefly for the next step of a login.
+func (h *Handler) unsealTokens(userID, password string, stored ...crypto.EncryptResult) []crypto.Unsealed {
+	results := make([]crypto.Unsealed, len(stored))
+
+	var missing []crypto.EncryptResult
+	var missingIndexes []int
+	for i, value := range stored {
+		key, ok := h.sessionStore.DerivedKey(userID, value.Salt, password)
+		if !ok {
+			key, ok = h.verifications.Get(userID, value.Salt, password)
+		}
+		if ok {
+			results[i].Key = key
+			results[i].Plaintext, results[i].Err = h.encService.DecryptWithKey(value.Encrypted, key)
+			continue
+		}
+		missing = append(missing, value)
+		missingIndexes = append(missingIndexes, i)
 	}
 
-	log.Printf("TokenStatus: User %s - HasToken: %t, HasActiveSession: %t, RequiresLogin: %t",
-		user.Id, hasToken, hasActiveSession, requiresLogin)
+	if len(missing) > 0 {
+		for j, result := range h.encService.DecryptAll(password, missing...) {
+			results[missingIndexes[j]] = result
+			if result.Err == nil {
+				h.verifications.Put(userID, missing[j].Salt, password, result.Key)
+			}
+		}
+	}
+	return results
+}
 
-	return e.JSON(http.StatusOK, response)
-}
\ No newline at end of file
+// DeleteTestingToken handles DELETE /api/custom/tokens/testing
+// It removes the testing key; sessions keep it until they end
+func (h *Handler) DeleteTestingToken(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	if user.GetString(testingTokenField) == "" {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "No testing FAL token is set up")
+	}
+
+	user.Set(testingTokenField, "")
+	if err := h.app.Save(user); err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to save user data")
+	}
+	h.verifications.Invalidate(user.Id)
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"generatio-pb/internal/batches"
+	"generatio-pb/internal/fal"
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/pipelines"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// withErrorReport links a batch's error report when rows were rejected
+func withErrorReport(batch *batches.Batch) *batches.Batch {
+	if len(batch.Rejected) > 0 {
+		batch.ErrorReport = "/api/custom/batches/" + batch.ID + "/errors"
+	}
+	return batch
+}
+
+// batchRowSteps builds and checks the pipeline of one imported row. Rows
+// without a model or folder use the import's defaults.
+func (h *Handler) batchRowSteps(e *core.RequestEvent, user *core.Record, orgID string, models map[string]fal.ModelInfo, row batches.Row, defaultModel, defaultFolder string) ([]pipelines.Step, string, error) {
+	model := row.Model
+	if model == "" {
+		model = defaultModel
+	}
+	if row.Error != "" {
+		return nil, model, errors.New(row.Error)
+	}
+	if model == "" {
+		return nil, model, fmt.Errorf("model is required")
+	}
+
+	steps := []pipelines.Step{{Type: pipelines.StepGenerate, Model: model, Prompt: row.Prompt, Parameters: row.Parameters}}
+	decision, err := h.checkGenerateStep(e, user, models, &steps[0])
+	if err != nil {
+		return nil, model, err
+	}
+	if decision != nil {
+		return nil, model, errors.New(decision.Reason())
+	}
+
+	folderID := row.FolderID
+	if folderID == "" {
+		folderID = defaultFolder
+	}
+	if folderID != "" {
+		if err := h.checkTargetFolder(user, orgID, folderID); err != nil {
+			return nil, model, err
+		}
+		steps = append(steps, pipelines.Step{Type: pipelines.StepSaveToFolder, FolderID: folderID})
+	}
+	return steps, steps[0].Model, nil
+}
+
+// ImportBatch handles POST /api/custom/batches/import
+// It takes a multipart form whose file field is a CSV with a prompt column
+// and optional model, folder_id and parameters (JSON object) columns. The
+// form's model, folder_id and priority fields are defaults for every row.
+// Each valid row is queued as a pipeline run; rejected rows are listed in
+// the batch and in its downloadable error report.
+func (h *Handler) ImportBatch(e *core.RequestEvent) error {
+	// A session is required now so the runs can borrow its FAL token later
+	user, _, err := h.getAuthenticatedUserAndSession(e)
+	if err != nil {
+		return h.sessionErrorResponse(e, err)
+	}
+
+	orgID, accessErr := h.writableOrg(e, user)
+	if accessErr != nil {
+		return h.accessErrorResponse(e, accessErr)
+	}
+
+	e.Request.Body = http.MaxBytesReader(e.Response, e.Request.Body, batches.MaxFileBytes+64<<10)
+	if err := e.Request.ParseMultipartForm(batches.MaxFileBytes); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return h.errorResponse(e, http.StatusRequestEntityTooLarge, localmodels.ErrCodeValidation, fmt.Sprintf("CSV files must not exceed %d bytes", batches.MaxFileBytes))
+		}
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Invalid multipart form: "+err.Error())
+	}
+
+	priority, err := h.requestPriority(user, e.Request.FormValue("priority"), fal.PriorityBatch)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	file, header, err := e.Request.FormFile("file")
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "A CSV file is required")
+	}
+	defer file.Close()
+
+	rows, err := batches.Parse(file)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	models := h.modelsFor(user)
+	runIDs := []string{}
+	rejected := []batches.Rejection{}
+	for _, row := range rows {
+		steps, model, err := h.batchRowSteps(e, user, orgID, models, row, e.Request.FormValue("model"), e.Request.FormValue("folder_id"))
+		if err == nil {
+			var run *pipelines.Run
+			if run, err = h.pipelines.Create(user.Id, orgID, steps, pipelines.RunOptions{Priority: priority}); err == nil {
+				runIDs = append(runIDs, run.ID)
+				continue
+			}
+			h.logger.Error("Failed to queue batch row", "error", err, "user_id", user.Id, "row", row.Line)
+			err = fmt.Errorf("failed to queue")
+		}
+		rejected = append(rejected, batches.Rejection{Row: row.Line, Prompt: row.Prompt, Model: model, Error: err.Error()})
+	}
+
+	batch, err := h.batches.Create(user.Id, orgID, header.Filename, len(rows), runIDs, rejected)
+	if err != nil {
+		h.logger.Error("Failed to save batch", "error", err, "user_id", user.Id, "queued", len(runIDs))
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to save batch")
+	}
+
+	h.logger.Info("Batch imported", "user_id", user.Id, "batch_id", batch.ID, "queued", len(runIDs), "rejected", len(rejected))
+
+	return h.respond(e, http.StatusAccepted, withErrorReport(batch))
+}
+
+// GetBatch handles GET /api/custom/batches/{id}
+// The batch carries its runs' progress by status
+func (h *Handler) GetBatch(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	batch, err := h.batches.Find(e.Request.PathValue("id"), user.Id)
+	if errors.Is(err, batches.ErrNotFound) {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Batch not found")
+	}
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch batch")
+	}
+
+	return h.respond(e, http.StatusOK, withErrorReport(batch))
+}
+
+// GetBatchErrors handles GET /api/custom/batches/{id}/errors
+// It downloads the rejected rows as CSV
+func (h *Handler) GetBatchErrors(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	batch, err := h.batches.Find(e.Request.PathValue("id"), user.Id)
+	if errors.Is(err, batches.ErrNotFound) {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Batch not found")
+	}
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch batch")
+	}
+
+	e.Response.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	e.Response.Header().Set("Content-Disposition", `attachment; filename="batch-`+batch.ID+`-errors.csv"`)
+	e.Response.WriteHeader(http.StatusOK)
+	return batches.WriteErrorReport(e.Response, batch)
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/validation"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// bodyError is a request body rejected by decodeJSON. fields is set when
+// the body decoded but broke the target's validate tags.
+type bodyError struct {
+	status  int
+	message string
+	fields  validation.Errors
+}
+
+func (e *bodyError) Error() string {
+	return e.message
+}
+
+// decodeJSON decodes the request body into dst and checks dst's validate
+// tags. Bodies are limited to cfg.MaxBodyBytes and, when cfg.StrictJSON is
+// set, may only contain fields dst declares. An empty body returns io.EOF, so
+// handlers with optional bodies can accept it; every other failure is a
+// *bodyError describing it.
+func (h *Handler) decodeJSON(e *core.RequestEvent, dst interface{}) error {
+	if h.cfg.MaxBodyBytes > 0 {
+		e.Request.Body = http.MaxBytesReader(e.Response, e.Request.Body, int64(h.cfg.MaxBodyBytes))
+	}
+
+	decoder := json.NewDecoder(e.Request.Body)
+	if h.cfg.StrictJSON {
+		decoder.DisallowUnknownFields()
+	}
+
+	if err := decoder.Decode(dst); err != nil {
+		if errors.Is(err, io.EOF) {
+			return io.EOF
+		}
+		return describeBodyError(err)
+	}
+
+	// Anything after the first value is a malformed body, not a second request
+	if err := decoder.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return describeBodyError(err)
+		}
+		return &bodyError{status: http.StatusBadRequest, message: "Request body must contain a single JSON object"}
+	}
+
+	if err := validation.Struct(dst); err != nil {
+		fields := err.(validation.Errors)
+		return &bodyError{status: http.StatusBadRequest, message: "Invalid fields: " + fields.Error(), fields: fields}
+	}
+	return nil
+}
+
+// describeBodyError turns a decoding error into a message naming the problem
+func describeBodyError(err error) *bodyError {
+	var maxErr *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &maxErr):
+		return &bodyError{status: http.StatusRequestEntityTooLarge, message: fmt.Sprintf("Request body must not exceed %d bytes", maxErr.Limit)}
+	case errors.As(err, &syntaxErr):
+		return &bodyError{status: http.StatusBadRequest, message: fmt.Sprintf("Malformed JSON at byte %d: %s", syntaxErr.Offset, strings.TrimPrefix(syntaxErr.Error(), "json: "))}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &bodyError{status: http.StatusBadRequest, message: "Malformed JSON: the body ends unexpectedly"}
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return &bodyError{status: http.StatusBadRequest, message: fmt.Sprintf("Request body must be a JSON %s", jsonTypeName(typeErr.Type))}
+		}
+		return &bodyError{status: http.StatusBadRequest, message: fmt.Sprintf("Field %q must be a %s, not %s", typeErr.Field, jsonTypeName(typeErr.Type), typeErr.Value)}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return &bodyError{status: http.StatusBadRequest, message: "Unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")}
+	}
+	return &bodyError{status: http.StatusBadRequest, message: "Invalid request body: " + strings.TrimPrefix(err.Error(), "json: ")}
+}
+
+// jsonTypeName names the JSON type a Go type decodes from
+func jsonTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	}
+	return "object"
+}
+
+// invalidBodyResponse reports why decodeJSON rejected a request body
+func (h *Handler) invalidBodyResponse(e *core.RequestEvent, err error) error {
+	var bodyErr *bodyError
+	switch {
+	case errors.As(err, &bodyErr) && bodyErr.fields != nil:
+		return h.apiErrorResponse(e, bodyErr.status, localmodels.APIError{
+			Code:    localmodels.ErrCodeValidation,
+			Message: bodyErr.message,
+			Details: bodyErr.fields,
+		})
+	case errors.As(err, &bodyErr):
+		return h.errorResponse(e, bodyErr.status, localmodels.ErrCodeValidation, bodyErr.message)
+	case errors.Is(err, io.EOF):
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Request body is required")
+	}
+	return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Invalid request body")
+}
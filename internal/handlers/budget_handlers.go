@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"generatio-pb/internal/audit"
+	"generatio-pb/internal/budget"
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/money"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// GetUserBudget handles GET /api/custom/admin/users/{id}/budget
+func (h *Handler) GetUserBudget(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	userID, err := h.budgetTarget(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "User not found")
+	}
+
+	status, err := h.budgets.Status(userID)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch budget")
+	}
+
+	return h.respond(e, http.StatusOK, status)
+}
+
+// GrantCredit handles POST /api/custom/admin/users/{id}/credits
+// It extends the user's budget for the current month
+func (h *Handler) GrantCredit(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	userID, err := h.budgetTarget(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "User not found")
+	}
+
+	var req localmodels.GrantCreditRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	amount := money.FromUSD(req.AmountUSD)
+	before := h.budgets.Get(userID)
+	limits, err := h.budgets.GrantCredit(userID, amount)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	h.auditBudgetChange(e, audit.ActionCreditGranted, userID, req.Reason, before, limits, map[string]interface{}{
+		"amount_usd":    amount.USD(),
+		"amount_micros": amount,
+	})
+
+	return h.respond(e, http.StatusOK, limits)
+}
+
+// SetUserBudget handles PUT /api/custom/admin/users/{id}/budget
+func (h *Handler) SetUserBudget(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	userID, err := h.budgetTarget(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "User not found")
+	}
+
+	var req localmodels.SetBudgetRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+	if req.MonthlyBudgetUSD == nil && req.DailyImageQuota == nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "monthly_budget_usd or daily_image_quota is required")
+	}
+
+	var monthlyBudget *money.Micros
+	if req.MonthlyBudgetUSD != nil {
+		amount := money.FromUSD(*req.MonthlyBudgetUSD)
+		monthlyBudget = &amount
+	}
+
+	before := h.budgets.Get(userID)
+	limits, err := h.budgets.SetLimits(userID, monthlyBudget, req.DailyImageQuota)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	h.auditBudgetChange(e, audit.ActionBudgetChanged, userID, req.Reason, before, limits, nil)
+
+	return h.respond(e, http.StatusOK, limits)
+}
+
+// ResetUserQuota handles POST /api/custom/admin/users/{id}/quota/reset
+// It discards the user's usage so far, restoring their full budget and daily quota
+func (h *Handler) ResetUserQuota(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	userID, err := h.budgetTarget(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "User not found")
+	}
+
+	var req localmodels.ResetQuotaRequest
+	if err := h.decodeJSON(e, &req); err != nil && !errors.Is(err, io.EOF) {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	before := h.budgets.Get(userID)
+	limits, err := h.budgets.ResetQuota(userID)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to reset quota")
+	}
+
+	h.auditBudgetChange(e, audit.ActionQuotaReset, userID, req.Reason, before, limits, nil)
+
+	return h.respond(e, http.StatusOK, limits)
+}
+
+// GetAuditLog handles GET /api/custom/admin/audit?target_id=
+func (h *Handler) GetAuditLog(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	entries, err := h.auditLog.List(strings.TrimSpace(e.Request.URL.Query().Get("target_id")), 100)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch audit log")
+	}
+
+	return h.listJSON(e, entries, nil)
+}
+
+// budgetTarget returns the ID of the user named in the path
+func (h *Handler) budgetTarget(e *core.RequestEvent) (string, error) {
+	user, err := h.userRepo.Get(e.Request.PathValue("id"))
+	if err != nil {
+		return "", err
+	}
+	return user.Id, nil
+}
+
+// auditBudgetChange writes a limits change to the audit log. Failures are
+// logged rather than undoing a change that was already saved.
+func (h *Handler) auditBudgetChange(e *core.RequestEvent, action, userID, reason string, before, after *budget.Limits, details map[string]interface{}) {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	details["before"] = before
+	details["after"] = after
+
+	err := h.auditLog.Record(audit.Entry{
+		ActorID:  e.Auth.Id,
+		Action:   action,
+		TargetID: userID,
+		Reason:   strings.TrimSpace(reason),
+		Details:  details,
+	})
+	if err != nil {
+		h.logger.Error("Failed to write audit entry", "error", err, "action", action, "user_id", userID)
+	}
+
+	h.logger.Info("User limits changed", "action", action, "user_id", userID, "superuser_id", e.Auth.Id)
+}
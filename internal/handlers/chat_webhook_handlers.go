@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/notify"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// chatWebhookErrorResponse maps chat webhook errors to responses
+func (h *Handler) chatWebhookErrorResponse(e *core.RequestEvent, err error) error {
+	if errors.Is(err, notify.ErrWebhookNotFound) {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Webhook not found")
+	}
+	return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+}
+
+// ListChatWebhooks handles GET /api/custom/notifications/webhooks
+func (h *Handler) ListChatWebhooks(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	webhooks, err := h.notifier.Webhooks(user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch webhooks")
+	}
+
+	return h.listJSON(e, webhooks, map[string]interface{}{
+		"events": notify.ChatEvents,
+	})
+}
+
+// RegisterChatWebhook handles POST /api/custom/notifications/webhooks
+// The webhook receives finished pipeline runs with thumbnails and budget
+// alerts, formatted for Discord or Slack
+func (h *Handler) RegisterChatWebhook(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.RegisterChatWebhookRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	webhook, err := h.notifier.RegisterWebhook(user.Id, req.Channel, req.URL, req.Events)
+	if err != nil {
+		return h.chatWebhookErrorResponse(e, err)
+	}
+
+	h.logger.Info("Chat webhook registered", "user_id", user.Id, "webhook_id", webhook.ID, "channel", webhook.Channel)
+
+	return h.respond(e, http.StatusOK, webhook)
+}
+
+// DeleteChatWebhook handles DELETE /api/custom/notifications/webhooks/{id}
+func (h *Handler) DeleteChatWebhook(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	if err := h.notifier.DeleteWebhook(e.Request.PathValue("id"), user.Id); err != nil {
+		return h.chatWebhookErrorResponse(e, err)
+	}
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// TestChatWebhook handles POST /api/custom/notifications/webhooks/{id}/test
+// It queues a test message; delivery happens in the background
+func (h *Handler) TestChatWebhook(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	if err := h.notifier.TestWebhook(e.Request.PathValue("id"), user.Id); err != nil {
+		return h.chatWebhookErrorResponse(e, err)
+	}
+
+	return h.respond(e, http.StatusAccepted, map[string]interface{}{
+		"queued": true,
+	})
+}
@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"generatio-pb/internal/folderacl"
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// maxDuplicatedImages caps the images a single collection copy may hold
+const maxDuplicatedImages = 2000
+
+// folderCopy is a folder of the source tree and the images directly in it
+type folderCopy struct {
+	folder *core.Record
+	images []*core.Record
+}
+
+// DuplicateCollection handles POST /api/custom/collections/{id}/duplicate
+// It deep-copies a folder, its subfolders and their images into the active
+// library, e.g. to branch a project. Copied images reference the same URL
+// and stored file as the originals; with copy_files the files are stored
+// first so the copy keeps working once FAL URLs expire. Copies carry no
+// cost, so spending is not counted twice.
+func (h *Handler) DuplicateCollection(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.DuplicateCollectionRequest
+	if err := h.decodeJSON(e, &req); err != nil && !errors.Is(err, io.EOF) {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	source, _, err := h.folders.Find(e.Request.PathValue("id"), user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Folder not found")
+	}
+
+	orgID, accessErr := h.writableOrg(e, user)
+	if accessErr != nil {
+		return h.accessErrorResponse(e, accessErr)
+	}
+
+	// Like new folders, a copy placed in a subfolder needs edit access to it
+	if req.ParentID != "" {
+		_, role, err := h.folders.Find(req.ParentID, user.Id)
+		if err != nil {
+			return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Parent folder not found")
+		}
+		if !folderacl.CanEdit(role) {
+			return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "You do not have edit access to the parent folder")
+		}
+	}
+
+	tree, err := h.collectFolderTree(source)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch folder contents")
+	}
+
+	resp := localmodels.DuplicateCollectionResponse{
+		Name:     req.Name,
+		ParentID: req.ParentID,
+		OrgID:    orgID,
+	}
+	if resp.Name == "" {
+		resp.Name = source.GetString("name") + " (copy)"
+	}
+	for _, node := range tree {
+		resp.Images += len(node.images)
+	}
+	if resp.Images > maxDuplicatedImages {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Folder is too large to duplicate")
+	}
+	if err := h.checkFolderPlacement(req.ParentID, "", treeHeight(tree)); err != nil {
+		return h.folderPlacementResponse(e, err)
+	}
+
+	// Files are downloaded outside the transaction; an image whose file
+	// cannot be stored is still copied by URL
+	if req.CopyFiles {
+		for _, node := range tree {
+			for _, image := range node.images {
+				if err := h.imageCache.Persist(e.Request.Context(), image); err != nil {
+					h.logger.Warn("Failed to store image file for collection copy", "error", err, "image_id", image.Id)
+					resp.FilesFailed++
+				}
+			}
+		}
+	}
+
+	err = h.app.RunInTransaction(func(txApp core.App) error {
+		folders, err := txApp.FindCollectionByNameOrId("folders")
+		if err != nil {
+			return err
+		}
+		images, err := txApp.FindCollectionByNameOrId("images")
+		if err != nil {
+			return err
+		}
+
+		// The tree is ordered parents first, so each parent is copied before its children
+		copies := make(map[string]string, len(tree))
+		for i, node := range tree {
+			folder := core.NewRecord(folders)
+			folder.Set("user_id", user.Id)
+			folder.Set("org_id", orgID)
+			folder.Set("private", node.folder.GetBool("private"))
+			if i == 0 {
+				folder.Set("name", resp.Name)
+				folder.Set("parent_id", req.ParentID)
+			} else {
+				folder.Set("name", node.folder.GetString("name"))
+				folder.Set("parent_id", copies[node.folder.GetString("parent_id")])
+			}
+			if err := txApp.Save(folder); err != nil {
+				return err
+			}
+			copies[node.folder.Id] = folder.Id
+
+			for _, image := range node.images {
+				if err := txApp.Save(copyImage(images, image, user.Id, orgID, folder.Id)); err != nil {
+					return err
+				}
+			}
+		}
+
+		resp.ID = copies[source.Id]
+		resp.Folders = len(copies)
+		return nil
+	})
+	if err != nil {
+		h.logger.Error("Failed to duplicate folder", "error", err, "folder_id", source.Id)
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to duplicate folder")
+	}
+
+	h.logger.Info("Folder duplicated", "folder_id", source.Id, "copy_id", resp.ID, "images", resp.Images, "user_id", user.Id)
+
+	return h.respond(e, http.StatusOK, resp)
+}
+
+// collectFolderTree returns the live folders under root, parents first, with
+// the live images directly in each
+func (h *Handler) collectFolderTree(root *core.Record) ([]folderCopy, error) {
+	var tree []folderCopy
+	queue := []*core.Record{root}
+	seen := map[string]bool{root.Id: true}
+	for len(queue) > 0 {
+		folder := queue[0]
+		queue = queue[1:]
+
+		images, err := h.imageRepo.InFolder(folder.Id, "created", 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		tree = append(tree, folderCopy{folder: folder, images: images})
+
+		children, err := h.folderRepo.Children(folder.Id)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			// Guards against parent_id cycles in existing data
+			if !seen[child.Id] {
+				seen[child.Id] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+	return tree, nil
+}
+
+// treeHeight returns the number of levels in a tree from collectFolderTree
+func treeHeight(tree []folderCopy) int {
+	height := 0
+	levels := make(map[string]int, len(tree))
+	for i, node := range tree {
+		level := 1
+		if i > 0 {
+			level = levels[node.folder.GetString("parent_id")] + 1
+		}
+		levels[node.folder.Id] = level
+		height = max(height, level)
+	}
+	return height
+}
+
+// copyImage returns a new image record in folderID with the same content as
+// source. The copy records where it came from and drops the cost, which was
+// paid once for the original.
+func copyImage(collection *core.Collection, source *core.Record, userID, orgID, folderID string) *core.Record {
+	record := core.NewRecord(collection)
+	for key, value := range source.FieldsData() {
+		switch key {
+		case core.FieldNameId, "created", "updated":
+			continue
+		}
+		record.Set(key, value)
+	}
+
+	info := map[string]any{}
+	source.UnmarshalJSONField("other_info", &info)
+	delete(info, "cost_usd")
+	info["source"] = "duplicate"
+	info["copied_from"] = source.Id
+
+	record.Set("other_info", info)
+	record.Set("user_id", userID)
+	record.Set("org_id", orgID)
+	record.Set("folder_id", folderID)
+	return record
+}
@@ -1,24 +1,32 @@
 package handlers
 
 import (
-	"encoding/json"
 	"net/http"
-	"time"
+	"strings"
 
+	"generatio-pb/internal/folderacl"
+	"generatio-pb/internal/invites"
 	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/pagination"
 
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// maxListedFolderImages caps the images returned per page of a folder listing
+const maxListedFolderImages = 200
+
+// Folder image orderings
+const (
+	folderSortRecent = "recent" // Newest first (default)
+	folderSortManual = "manual" // By position, as arranged with PUT /api/custom/collections/{id}/order
 )
 
 // CreateCollection handles POST /api/custom/collections/create
 func (h *Handler) CreateCollection(e *core.RequestEvent) error {
 	var req localmodels.CreateCollectionRequest
-	if err := json.NewDecoder(e.Request.Body).Decode(&req); err != nil {
-		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Invalid request body")
-	}
-
-	if req.Name == "" {
-		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Collection name is required")
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
 	}
 
 	// Get authenticated user
@@ -27,6 +35,25 @@ func (h *Handler) CreateCollection(e *core.RequestEvent) error {
 		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
 	}
 
+	orgID, accessErr := h.writableOrg(e, user)
+	if accessErr != nil {
+		return h.accessErrorResponse(e, accessErr)
+	}
+
+	// Subfolders need edit access to the parent
+	if req.ParentID != "" {
+		_, role, err := h.folders.Find(req.ParentID, user.Id)
+		if err != nil {
+			return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Parent folder not found")
+		}
+		if !folderacl.CanEdit(role) {
+			return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "You do not have edit access to the parent folder")
+		}
+		if err := h.checkFolderPlacement(req.ParentID, "", 1); err != nil {
+			return h.folderPlacementResponse(e, err)
+		}
+	}
+
 	// Create folder record (collections are called folders in the schema)
 	collection, err := h.app.FindCollectionByNameOrId("folders")
 	if err != nil {
@@ -37,6 +64,9 @@ func (h *Handler) CreateCollection(e *core.RequestEvent) error {
 	record.Set("user_id", user.Id)
 	record.Set("name", req.Name)
 	record.Set("private", false) // Default to public
+	if orgID != "" {
+		record.Set("org_id", orgID)
+	}
 	
 	if req.ParentID != "" {
 		record.Set("parent_id", req.ParentID)
@@ -50,10 +80,12 @@ func (h *Handler) CreateCollection(e *core.RequestEvent) error {
 		ID:       record.Id,
 		Name:     req.Name,
 		ParentID: req.ParentID,
-		Created:  time.Now(), // Fallback until we fix timestamp access
+		OrgID:    orgID,
+		Created:  record.GetDateTime("created").Time(),
+		Updated:  record.GetDateTime("updated").Time(),
 	}
 
-	return e.JSON(http.StatusOK, resp)
+	return h.respond(e, http.StatusOK, resp)
 }
 
 // GetCollections handles GET /api/custom/collections
@@ -64,22 +96,32 @@ func (h *Handler) GetCollections(e *core.RequestEvent) error {
 		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
 	}
 
-	// Get all folders for user (collections are called folders in the schema)
-	records, err := h.app.FindRecordsByFilter(
-		"folders",
-		"user_id = {:user_id} && deleted_at = null",
-		"-created",
-		100,
-		0,
-		map[string]any{
-			"user_id": user.Id,
-		},
-	)
+	orgID, _, err := h.activeOrg(e, user)
+	if err != nil {
+		return h.accessErrorResponse(e, errOrgNotFound)
+	}
 
+	// Get all folders in the personal or organization library (collections are called folders in the schema)
+	records, err := h.folderRepo.InLibrary(user.Id, orgID, 100)
 	if err != nil {
 		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch folders")
 	}
 
+	// The personal library also lists folders other users have shared
+	if orgID == "" {
+		sharedIDs, err := h.folders.SharedWith(user.Id)
+		if err != nil {
+			return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch folders")
+		}
+		if len(sharedIDs) > 0 {
+			shared, err := h.folderRepo.ByIDs(sharedIDs)
+			if err != nil {
+				return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch folders")
+			}
+			records = append(records, shared...)
+		}
+	}
+
 	var collections []localmodels.Collection
 	for _, record := range records {
 		collection := localmodels.Collection{
@@ -87,13 +129,341 @@ func (h *Handler) GetCollections(e *core.RequestEvent) error {
 			UserID:   record.GetString("user_id"),
 			Name:     record.GetString("name"),
 			ParentID: record.GetString("parent_id"),
-			Created:  time.Now(), // Fallback until we fix timestamp access
-			Updated:  time.Now(), // Fallback until we fix timestamp access
+			OrgID:    record.GetString("org_id"),
+			Role:     h.folders.Role(record, user.Id),
+			Created:  record.GetDateTime("created").Time(),
+			Updated:  record.GetDateTime("updated").Time(),
 		}
 		collections = append(collections, collection)
 	}
 
-	return e.JSON(http.StatusOK, map[string]interface{}{
-		"collections": collections,
+	// Smart collections are personal saved searches, listed next to the folders
+	meta := map[string]interface{}{}
+	if orgID == "" {
+		smart, err := h.smartFolders.ForUser(user.Id)
+		if err != nil {
+			return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch smart collections")
+		}
+		meta["smart_collections"] = smart
+	}
+
+	return h.listJSON(e, collections, meta)
+}
+
+// DeleteCollection handles DELETE /api/custom/collections/{id}
+// Only the folder owner can delete; editors and viewers cannot
+func (h *Handler) DeleteCollection(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	folder, role, err := h.folders.Find(e.Request.PathValue("id"), user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Folder not found")
+	}
+	if !folderacl.CanDelete(role) {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Only the folder owner can delete it")
+	}
+
+	folder.Set("deleted_at", types.NowDateTime())
+	if err := h.folderRepo.Save(folder); err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to delete folder")
+	}
+
+	h.logger.Info("Folder deleted", "folder_id", folder.Id, "user_id", user.Id)
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// MoveCollection handles PUT /api/custom/collections/{id}/move
+// Only the folder owner can move it, and only to a parent they can edit in
+// the same library. Moves that would nest a folder inside itself or exceed
+// maxFolderDepth are rejected.
+func (h *Handler) MoveCollection(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.MoveCollectionRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	folder, role, err := h.folders.Find(e.Request.PathValue("id"), user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Folder not found")
+	}
+	if !folderacl.CanDelete(role) {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Only the folder owner can move it")
+	}
+
+	if req.ParentID != "" {
+		parent, parentRole, err := h.folders.Find(req.ParentID, user.Id)
+		if err != nil {
+			return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Parent folder not found")
+		}
+		if !folderacl.CanEdit(parentRole) {
+			return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "You do not have edit access to the parent folder")
+		}
+		if parent.GetString("org_id") != folder.GetString("org_id") {
+			return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Folders can only be moved within their library")
+		}
+	}
+
+	height, err := h.folderHeight(folder)
+	if err != nil {
+		return h.folderPlacementResponse(e, err)
+	}
+	if err := h.checkFolderPlacement(req.ParentID, folder.Id, height); err != nil {
+		return h.folderPlacementResponse(e, err)
+	}
+
+	folder.Set("parent_id", req.ParentID)
+	if err := h.folderRepo.Save(folder); err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to move folder")
+	}
+
+	h.logger.Info("Folder moved", "folder_id", folder.Id, "parent_id", req.ParentID, "user_id", user.Id)
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success":   true,
+		"id":        folder.Id,
+		"parent_id": req.ParentID,
+	})
+}
+
+// GetCollectionImages handles GET /api/custom/collections/{id}/images
+// Query parameters: page and limit, or the cursor from a previous page's
+// next_cursor, and sort (recent or manual). Manual order pages by number
+// only; images added since the folder was last arranged come first.
+func (h *Handler) GetCollectionImages(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	folder, role, err := h.folders.Find(e.Request.PathValue("id"), user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Folder not found")
+	}
+
+	page, err := pagination.Parse(e.Request.URL.Query(), maxListedFolderImages)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	sort := e.Request.URL.Query().Get("sort")
+	if sort == "" {
+		sort = folderSortRecent
+	}
+
+	var records []*core.Record
+	var next string
+	switch sort {
+	case folderSortRecent:
+		records, next, err = h.imageRepo.FolderPage(folder.Id, page)
+	case folderSortManual:
+		if page.Cursor != nil {
+			return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "cursor cannot be combined with sort=manual; use page")
+		}
+		records, err = h.imageRepo.InFolder(folder.Id, "position,-created,-id", page.Limit, (page.Number-1)*page.Limit)
+	default:
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "sort must be recent or manual")
+	}
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch images")
+	}
+
+	images := make([]localmodels.GeneratedImageInfo, 0, len(records))
+	for _, record := range records {
+		status := record.GetString("moderation_status")
+		images = append(images, h.withVariants(withTimestamps(moderatedImageInfo(record.Id, record.GetString("url"), "", status), record)))
+	}
+
+	return h.listJSON(e, images, map[string]interface{}{
+		"role":        role,
+		"sort":        sort,
+		"next_cursor": next,
+	})
+}
+
+// ReorderCollectionImages handles PUT /api/custom/collections/{id}/order
+// It arranges the folder's images in the order of image_ids for sort=manual
+// listings. Images left out keep their relative order after the listed ones.
+func (h *Handler) ReorderCollectionImages(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.ReorderImagesRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	folder, role, err := h.folders.Find(e.Request.PathValue("id"), user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Folder not found")
+	}
+	if !folderacl.CanEdit(role) {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "You do not have edit access to this folder")
+	}
+
+	records, err := h.imageRepo.InFolder(folder.Id, "position,-created,-id", 0, 0)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch images")
+	}
+
+	byID := make(map[string]*core.Record, len(records))
+	for _, record := range records {
+		byID[record.Id] = record
+	}
+
+	ordered := make([]*core.Record, 0, len(records))
+	listed := make(map[string]bool, len(req.ImageIDs))
+	for _, id := range req.ImageIDs {
+		record, exists := byID[id]
+		if !exists {
+			return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Image "+id+" is not in this folder")
+		}
+		if listed[id] {
+			return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Image "+id+" is listed more than once")
+		}
+		listed[id] = true
+		ordered = append(ordered, record)
+	}
+	for _, record := range records {
+		if !listed[record.Id] {
+			ordered = append(ordered, record)
+		}
+	}
+
+	err = h.app.RunInTransaction(func(txApp core.App) error {
+		for i, record := range ordered {
+			if record.GetInt("position") == i+1 {
+				continue
+			}
+			record.Set("position", i+1)
+			if err := txApp.Save(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		h.logger.Error("Failed to reorder folder images", "error", err, "folder_id", folder.Id)
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to reorder images")
+	}
+
+	imageIDs := make([]string, 0, len(ordered))
+	for _, record := range ordered {
+		imageIDs = append(imageIDs, record.Id)
+	}
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"folder_id": folder.Id,
+		"image_ids": imageIDs,
 	})
-}
\ No newline at end of file
+}
+
+// GetCollectionPermissions handles GET /api/custom/collections/{id}/permissions
+func (h *Handler) GetCollectionPermissions(e *core.RequestEvent) error {
+	folder, accessErr := h.ownedFolder(e)
+	if accessErr != nil {
+		return h.accessErrorResponse(e, accessErr)
+	}
+
+	grants, err := h.folders.Grants(folder.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch permissions")
+	}
+
+	return h.listJSON(e, grants, map[string]interface{}{
+		"owner_id": folder.GetString("user_id"),
+	})
+}
+
+// ShareCollection handles POST /api/custom/collections/{id}/permissions
+// Sharing with a user who already has a grant changes their role. An email
+// without an account gets a pending invitation instead, and the response is
+// the same either way, so sharing cannot be used to probe for accounts.
+func (h *Handler) ShareCollection(e *core.RequestEvent) error {
+	folder, accessErr := h.ownedFolder(e)
+	if accessErr != nil {
+		return h.accessErrorResponse(e, accessErr)
+	}
+
+	var req localmodels.ShareFolderRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if email == "" || !strings.Contains(email, "@") {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "a valid email is required")
+	}
+	if !folderacl.ValidGrantRole(req.Role) {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "role must be one of: editor, viewer")
+	}
+
+	if grantee, err := h.userRepo.ByEmail(email); err == nil {
+		if err := h.folders.Grant(folder, grantee.Id, req.Role); err != nil {
+			return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+		}
+		h.logger.Info("Folder shared", "folder_id", folder.Id, "grantee_id", grantee.Id, "role", req.Role)
+	} else {
+		owner, err := h.getAuthenticatedUser(e)
+		if err != nil {
+			return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+		}
+		token, invitation, err := h.invites.Create(owner.Id, invites.KindFolder, folder.Id, email, req.Role)
+		if err != nil {
+			return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to share folder")
+		}
+		h.sendInvitationEmail(owner, invitation, token)
+		h.logger.Info("Folder shared by invitation", "folder_id", folder.Id, "invitation_id", invitation.ID, "role", req.Role)
+	}
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"email":   email,
+		"role":    req.Role,
+	})
+}
+
+// UnshareCollection handles DELETE /api/custom/collections/{id}/permissions/{user_id}
+func (h *Handler) UnshareCollection(e *core.RequestEvent) error {
+	folder, accessErr := h.ownedFolder(e)
+	if accessErr != nil {
+		return h.accessErrorResponse(e, accessErr)
+	}
+
+	if err := h.folders.Revoke(folder.Id, e.Request.PathValue("user_id")); err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, err.Error())
+	}
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// ownedFolder loads the folder in the path and checks the user owns it
+func (h *Handler) ownedFolder(e *core.RequestEvent) (*core.Record, *accessError) {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return nil, errAuthRequired
+	}
+
+	folder, role, err := h.folders.Find(e.Request.PathValue("id"), user.Id)
+	if err != nil {
+		return nil, errFolderNotFound
+	}
+	if !folderacl.CanDelete(role) {
+		return nil, errFolderOwner
+	}
+	return folder, nil
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"generatio-pb/internal/community"
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/moderation"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// withExampleURLs fills in the paths serving a prompt's example images
+func withExampleURLs(prompt *community.Prompt) *community.Prompt {
+	prompt.ExampleURLs = make([]string, 0, len(prompt.ExampleIDs))
+	for _, imageID := range prompt.ExampleIDs {
+		prompt.ExampleURLs = append(prompt.ExampleURLs, "/api/custom/community/prompts/"+prompt.ID+"/examples/"+imageID)
+	}
+	return prompt
+}
+
+// BrowsePrompts handles GET /api/custom/community/prompts
+// Query parameters: q (search), sort (recent or popular) and page
+func (h *Handler) BrowsePrompts(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	query := e.Request.URL.Query()
+	page, _ := strconv.Atoi(query.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	prompts, err := h.community.Browse(user.Id, query.Get("q"), query.Get("sort"), page)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch prompts")
+	}
+	for _, prompt := range prompts {
+		withExampleURLs(prompt)
+	}
+
+	return h.listJSON(e, prompts, map[string]interface{}{
+		"page":     page,
+		"per_page": community.PageSize,
+	})
+}
+
+// PublishPrompt handles POST /api/custom/community/prompts
+func (h *Handler) PublishPrompt(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.PublishPromptRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	if _, exists := h.falClient.GetModels()[req.Model]; !exists {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Unsupported model")
+	}
+
+	// Published prompts are held to the same policy as generated ones
+	if decision := h.filter.Evaluate(req.Prompt); !decision.Allowed {
+		return h.apiErrorResponse(e, http.StatusBadRequest, localmodels.APIError{
+			Code:    localmodels.ErrCodeContentPolicy,
+			Message: decision.Reason(),
+			Details: decision,
+		})
+	}
+
+	prompt, err := h.community.Publish(user.Id, community.PublishInput{
+		Title:      req.Title,
+		Prompt:     req.Prompt,
+		Model:      req.Model,
+		Parameters: req.Parameters,
+		ExampleIDs: req.ExampleIDs,
+	})
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	h.logger.Info("Prompt published", "prompt_id", prompt.ID, "user_id", user.Id)
+
+	return h.respond(e, http.StatusOK, withExampleURLs(prompt))
+}
+
+// GetPrompt handles GET /api/custom/community/prompts/{id}
+func (h *Handler) GetPrompt(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	prompt, err := h.community.Find(e.Request.PathValue("id"), user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Prompt not found")
+	}
+
+	return h.respond(e, http.StatusOK, withExampleURLs(prompt))
+}
+
+// UnpublishPrompt handles DELETE /api/custom/community/prompts/{id}
+func (h *Handler) UnpublishPrompt(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	id := e.Request.PathValue("id")
+	if err := h.community.Unpublish(id, user.Id); err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Prompt not found")
+	}
+
+	h.logger.Info("Prompt unpublished", "prompt_id", id, "user_id", user.Id)
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// LikePrompt handles POST and DELETE /api/custom/community/prompts/{id}/like
+func (h *Handler) LikePrompt(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	liked := e.Request.Method == http.MethodPost
+	likes, err := h.community.SetLiked(e.Request.PathValue("id"), user.Id, liked)
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Prompt not found")
+	}
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"liked": liked,
+		"likes": likes,
+	})
+}
+
+// ReportPrompt handles POST /api/custom/community/prompts/{id}/report
+func (h *Handler) ReportPrompt(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.ReportPromptRequest
+	if e.Request.ContentLength != 0 {
+		if err := h.decodeJSON(e, &req); err != nil {
+			return h.invalidBodyResponse(e, err)
+		}
+	}
+
+	id := e.Request.PathValue("id")
+	if _, err := h.community.Find(id, user.Id); err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Prompt not found")
+	}
+	if err := h.community.Report(id, user.Id, req.Reason); err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	h.logger.Info("Prompt reported", "prompt_id", id, "user_id", user.Id)
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// GenerateFromPrompt handles POST /api/custom/community/prompts/{id}/generate
+// It generates with a published prompt's model and parameters in one click
+func (h *Handler) GenerateFromPrompt(e *core.RequestEvent) error {
+	var req localmodels.UsePromptRequest
+	if e.Request.ContentLength != 0 {
+		if err := h.decodeJSON(e, &req); err != nil {
+			return h.invalidBodyResponse(e, err)
+		}
+	}
+
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Valid session required")
+	}
+
+	prompt, err := h.community.Find(e.Request.PathValue("id"), user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Prompt not found")
+	}
+
+	caller, handled, err := h.prepareGeneration(e, prompt.Prompt, req.CollectionID)
+	if handled {
+		return err
+	}
+
+	imageReq := localmodels.GenerateImageRequest{
+		Model:        prompt.Model,
+		Prompt:       prompt.Prompt,
+		Parameters:   prompt.Parameters,
+		CollectionID: req.CollectionID,
+	}
+	result, imageInfos, err := h.runGeneration(e.Request.Context(), caller, imageReq, nil)
+	if err != nil {
+		h.logger.Error("Community prompt generation failed", "error", err, "prompt_id", prompt.ID)
+		return h.generationErrorResponse(e, err)
+	}
+
+	h.community.RecordUse(prompt.ID)
+
+	h.logger.Info("Generated from community prompt", "prompt_id", prompt.ID, "user_id", user.Id, "cost", result.Cost)
+
+	return h.respond(e, http.StatusOK, generationResponse(prompt.Model, result, imageInfos, nil))
+}
+
+// ServePromptExample handles GET /api/custom/community/prompts/{id}/examples/{image_id}
+// Publishing a prompt makes its example images visible to every user
+func (h *Handler) ServePromptExample(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	prompt, err := h.community.Find(e.Request.PathValue("id"), user.Id)
+	imageID := e.Request.PathValue("image_id")
+	if err != nil || !prompt.HasExample(imageID) {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Image not found")
+	}
+
+	record, err := h.imageRepo.Get(imageID)
+	if err != nil || record.GetString("moderation_status") == moderation.StatusQuarantined {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Image not found")
+	}
+
+	return h.streamImage(e, record, "private, max-age=3600")
+}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"generatio-pb/internal/fal"
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/money"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// Comparison limits
+const (
+	minCompareVariants = 2
+	maxCompareVariants = 4
+)
+
+// Image group kinds stored in other_info.group.kind
+const (
+	groupKindComparison = "comparison"
+	groupKindSweep      = "sweep"
+	groupKindPipeline   = "pipeline"
+)
+
+// generationCaller is the authenticated caller of a multi-image generation endpoint
+type generationCaller struct {
+	user        *core.Record
+	falToken    string
+	environment string // key slot falToken belongs to
+	orgID       string
+	priority    string
+}
+
+// prepareGeneration authenticates the caller, applies the content policy and
+// resolves the target library and folder. When handled is true the response
+// has already been written and err is its result.
+func (h *Handler) prepareGeneration(e *core.RequestEvent, prompt, collectionID string) (caller *generationCaller, handled bool, err error) {
+	user, session, err := h.getAuthenticatedUserAndSession(e)
+	if err != nil {
+		return nil, true, h.sessionErrorResponse(e, err)
+	}
+
+	if decision := h.filter.Evaluate(prompt); !decision.Allowed {
+		return nil, true, h.apiErrorResponse(e, http.StatusBadRequest, localmodels.APIError{
+			Code:    localmodels.ErrCodeContentPolicy,
+			Message: decision.Reason(),
+			Details: decision,
+		})
+	}
+
+	orgID, accessErr := h.writableOrg(e, user)
+	if accessErr != nil {
+		return nil, true, h.accessErrorResponse(e, accessErr)
+	}
+
+	if err := h.checkTargetFolder(user, orgID, collectionID); err != nil {
+		return nil, true, h.targetFolderResponse(e, collectionID, err)
+	}
+
+	// Requests made from these endpoints are interactive unless the user's tier is capped
+	priority, _ := h.requestPriority(user, "", fal.PriorityInteractive)
+
+	return &generationCaller{user: user, falToken: session.FALToken, environment: session.Environment, orgID: orgID, priority: priority}, false, nil
+}
+
+// runGeneration generates a single request for a prepared caller, saves the
+// images and charges the caller. Generation stops when ctx is cancelled.
+func (h *Handler) runGeneration(ctx context.Context, caller *generationCaller, req localmodels.GenerateImageRequest, links *imageLinks) (*fal.GenerationResponse, []localmodels.GeneratedImageInfo, error) {
+	ctx, cancel := context.WithTimeout(ctx, generationTimeout)
+	defer cancel()
+
+	startTime := time.Now()
+	result, release, err := h.generate(ctx, caller.user.Id, caller.falToken, fal.GenerationRequest{
+		Model:       req.Model,
+		Prompt:      req.Prompt,
+		Parameters:  req.Parameters,
+		Priority:    caller.priority,
+		CustomModel: h.customModel(caller.user, req.Model),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer release()
+	generationTime := time.Since(startTime)
+
+	imageInfos, err := h.saveGeneration(ctx, caller.user, caller.falToken, caller.environment, caller.orgID, req, result, generationTime, links)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return result, imageInfos, nil
+}
+
+// GenerateComparison handles POST /api/custom/generate/compare
+// It runs one prompt against several models or parameter sets concurrently
+// and links the outputs as a comparison group
+func (h *Handler) GenerateComparison(e *core.RequestEvent) error {
+	var req localmodels.CompareRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	if len(req.Variants) < minCompareVariants || len(req.Variants) > maxCompareVariants {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, fmt.Sprintf("Between %d and %d variants are required", minCompareVariants, maxCompareVariants))
+	}
+
+	user, _ := h.getAuthenticatedUser(e)
+	for i := range req.Variants {
+		variant := &req.Variants[i]
+		variant.Model, variant.Parameters = h.resolveAlias(user, variant.Model, variant.Parameters)
+		var err error
+		if variant.Model, variant.Parameters, _, err = h.migrateModel(e, variant.Model, variant.Parameters); err != nil {
+			return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, fmt.Sprintf("Variant %d: %v", i+1, err))
+		}
+		if _, exists := h.findModel(user, variant.Model); !exists {
+			return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, fmt.Sprintf("Variant %d: unsupported model %q", i+1, variant.Model))
+		}
+	}
+
+	caller, handled, err := h.prepareGeneration(e, req.Prompt, req.CollectionID)
+	if handled {
+		return err
+	}
+
+	comparisonID := security.RandomString(15)
+
+	ctx, cancel := context.WithTimeout(e.Request.Context(), generationTimeout)
+	defer cancel()
+
+	// Run every variant concurrently; each result keeps its request order
+	results := make([]localmodels.CompareResult, len(req.Variants))
+	var wg sync.WaitGroup
+	for i, variant := range req.Variants {
+		wg.Add(1)
+		go func(i int, variant localmodels.CompareVariant) {
+			defer wg.Done()
+
+			results[i] = localmodels.CompareResult{
+				Variant:    i + 1,
+				Model:      variant.Model,
+				Parameters: variant.Parameters,
+				Images:     []localmodels.GeneratedImageInfo{},
+			}
+
+			startTime := time.Now()
+			result, release, err := h.generate(ctx, caller.user.Id, caller.falToken, fal.GenerationRequest{
+				Model:       variant.Model,
+				Prompt:      req.Prompt,
+				Parameters:  variant.Parameters,
+				Priority:    caller.priority,
+				CustomModel: h.customModel(caller.user, variant.Model),
+			})
+			generationTime := time.Since(startTime)
+			results[i].GenerationTimeMs = generationTime.Milliseconds()
+			if err != nil {
+				h.logger.Warn("Comparison variant failed", "comparison_id", comparisonID, "variant", i+1, "model", variant.Model, "error", err)
+				classified := describeGenerationError(err)
+				results[i].Error = classified.Message
+				results[i].ErrorCode = classified.Code
+				results[i].Retryable = classified.Retryable
+				return
+			}
+			defer release()
+
+			imageReq := localmodels.GenerateImageRequest{
+				Model:        variant.Model,
+				Prompt:       req.Prompt,
+				Parameters:   variant.Parameters,
+				CollectionID: req.CollectionID,
+			}
+			group := map[string]interface{}{
+				"id":      comparisonID,
+				"kind":    groupKindComparison,
+				"variant": i + 1,
+			}
+
+			images, err := h.saveGeneration(ctx, caller.user, caller.falToken, caller.environment, caller.orgID, imageReq, result, generationTime, &imageLinks{group: group})
+			results[i].Cost = result.Cost
+			results[i].CostMicros = money.FromUSD(result.Cost)
+			if err != nil {
+				classified := describeGenerationError(err)
+				results[i].Error = classified.Message
+				results[i].ErrorCode = classified.Code
+				return
+			}
+			results[i].Images = images
+		}(i, variant)
+	}
+	wg.Wait()
+
+	resp := localmodels.CompareResponse{
+		ComparisonID: comparisonID,
+		Prompt:       req.Prompt,
+		Results:      results,
+	}
+
+	succeeded := 0
+	for _, result := range results {
+		if result.Error == "" {
+			succeeded++
+		}
+		resp.TotalMicros += result.CostMicros
+	}
+	resp.TotalCost = resp.TotalMicros.USD()
+
+	if succeeded == 0 {
+		return h.errorResponse(e, http.StatusBadGateway, localmodels.ErrCodeExternal, "All comparison variants failed")
+	}
+
+	h.logger.Info("Comparison generated", "comparison_id", comparisonID, "user_id", caller.user.Id, "variants", len(results), "succeeded", succeeded, "cost", resp.TotalCost)
+
+	return h.respond(e, http.StatusOK, resp)
+}
+
+// GetComparison handles GET /api/custom/generate/compare/{id}
+// It returns a comparison's images grouped by variant
+func (h *Handler) GetComparison(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	comparisonID := e.Request.PathValue("id")
+	records, err := h.imageRepo.InGroup(comparisonID)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch comparison")
+	}
+
+	resp := localmodels.CompareResponse{
+		ComparisonID: comparisonID,
+		Results:      []localmodels.CompareResult{},
+	}
+	byVariant := make(map[int]int)
+	costs := make(map[int]money.Micros)
+	for _, record := range records {
+		if !h.canViewImage(user, record) {
+			continue
+		}
+
+		var info struct {
+			money.ImageCost
+			Parameters map[string]interface{} `json:"parameters"`
+			Group      struct {
+				Kind    string `json:"kind"`
+				Variant int    `json:"variant"`
+			} `json:"group"`
+		}
+		record.UnmarshalJSONField("other_info", &info)
+		if info.Group.Kind != groupKindComparison {
+			continue
+		}
+
+		index, exists := byVariant[info.Group.Variant]
+		if !exists {
+			index = len(resp.Results)
+			byVariant[info.Group.Variant] = index
+			resp.Results = append(resp.Results, localmodels.CompareResult{
+				Variant:    info.Group.Variant,
+				Model:      record.GetString("model"),
+				Parameters: info.Parameters,
+				Images:     []localmodels.GeneratedImageInfo{},
+			})
+		}
+
+		resp.Prompt = record.GetString("prompt")
+		resp.Results[index].Images = append(resp.Results[index].Images, h.withVariants(withTimestamps(moderatedImageInfo(record.Id, record.GetString("url"), "", record.GetString("moderation_status")), record)))
+		costs[index] += info.Amount()
+		resp.TotalMicros += info.Amount()
+	}
+
+	if len(resp.Results) == 0 {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Comparison not found")
+	}
+	for index, cost := range costs {
+		resp.Results[index].Cost = cost.USD()
+		resp.Results[index].CostMicros = cost
+	}
+	resp.TotalCost = resp.TotalMicros.USD()
+
+	return h.respond(e, http.StatusOK, resp)
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"generatio-pb/internal/custommodels"
+	"generatio-pb/internal/fal"
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// findModel returns a built-in model or one of user's custom models.
+// user may be nil, in which case only built-in models are found.
+func (h *Handler) findModel(user *core.Record, modelID string) (fal.ModelInfo, bool) {
+	if user != nil {
+		if model, exists := h.customModels.Resolve(user.Id, modelID); exists {
+			return model, true
+		}
+	}
+	model, exists := h.falClient.GetModels()[modelID]
+	return model, exists
+}
+
+// requestModel is findModel for the user making the request, if any
+func (h *Handler) requestModel(e *core.RequestEvent, modelID string) (fal.ModelInfo, bool) {
+	user, _ := h.getAuthenticatedUser(e)
+	return h.findModel(user, modelID)
+}
+
+// customModel returns the definition to send with a generation request for
+// modelID, or nil when modelID is not one of user's custom models
+func (h *Handler) customModel(user *core.Record, modelID string) *fal.ModelInfo {
+	model, exists := h.customModels.Resolve(user.Id, modelID)
+	if !exists {
+		return nil
+	}
+	return &model
+}
+
+// resolveAlias replaces one of user's aliases with the model it names and
+// merges the alias defaults under params. Model IDs are returned unchanged.
+func (h *Handler) resolveAlias(user *core.Record, modelID string, params map[string]interface{}) (string, map[string]interface{}) {
+	if user == nil {
+		return modelID, params
+	}
+	alias, exists := h.customModels.ResolveAlias(user.Id, modelID)
+	if !exists {
+		return modelID, params
+	}
+	return alias.Apply(params)
+}
+
+// migrateModel moves a request for a deprecated built-in model to its
+// replacement and adds a Warning header for each deprecation encountered.
+// It fails once a model without a replacement is past its sunset date.
+func (h *Handler) migrateModel(e *core.RequestEvent, modelID string, params map[string]interface{}) (string, map[string]interface{}, []string, error) {
+	migrated, params, warnings, err := fal.MigrateRequest(h.falClient.GetModels(), modelID, params, time.Now())
+	for _, warning := range warnings {
+		e.Response.Header().Add("Warning", fmt.Sprintf("299 - %q", warning))
+	}
+	if len(warnings) > 0 {
+		h.logger.Warn("Deprecated model requested", "model", modelID, "migrated_to", migrated, "error", err)
+	}
+	return migrated, params, warnings, err
+}
+
+// modelsFor returns the built-in models together with user's custom models
+func (h *Handler) modelsFor(user *core.Record) map[string]fal.ModelInfo {
+	models := make(map[string]fal.ModelInfo)
+	for id, model := range h.falClient.GetModels() {
+		models[id] = model
+	}
+
+	custom, err := h.customModels.List(user.Id)
+	if err != nil {
+		h.logger.Error("Failed to fetch custom models", "error", err, "user_id", user.Id)
+		return models
+	}
+	for _, model := range custom {
+		models[model.Model] = model.Info()
+	}
+	return models
+}
+
+// customModelErrorResponse maps registry errors to responses
+func (h *Handler) customModelErrorResponse(e *core.RequestEvent, err error) error {
+	if errors.Is(err, custommodels.ErrNotFound) {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Custom model not found")
+	}
+	return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+}
+
+// ListCustomModels handles GET /api/custom/models
+func (h *Handler) ListCustomModels(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	models, err := h.customModels.List(user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch custom models")
+	}
+
+	return h.listJSON(e, models, nil)
+}
+
+// RegisterCustomModel handles POST /api/custom/models
+// The model becomes selectable as "custom/<name>" wherever models are accepted
+func (h *Handler) RegisterCustomModel(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req custommodels.Input
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	model, err := h.customModels.Register(user.Id, req)
+	if err != nil {
+		return h.customModelErrorResponse(e, err)
+	}
+
+	h.logger.Info("Custom model registered", "user_id", user.Id, "model", model.Model, "endpoint", model.Endpoint)
+
+	return h.respond(e, http.StatusOK, model)
+}
+
+// GetCustomModel handles GET /api/custom/models/{id}
+func (h *Handler) GetCustomModel(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	model, err := h.customModels.Find(user.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return h.customModelErrorResponse(e, err)
+	}
+
+	return h.respond(e, http.StatusOK, model)
+}
+
+// UpdateCustomModel handles PUT /api/custom/models/{id}
+func (h *Handler) UpdateCustomModel(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req custommodels.Input
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	model, err := h.customModels.Update(user.Id, e.Request.PathValue("id"), req)
+	if err != nil {
+		return h.customModelErrorResponse(e, err)
+	}
+
+	return h.respond(e, http.StatusOK, model)
+}
+
+// DeleteCustomModel handles DELETE /api/custom/models/{id}
+// Images generated with the model keep their recorded model ID
+func (h *Handler) DeleteCustomModel(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	if err := h.customModels.Delete(user.Id, e.Request.PathValue("id")); err != nil {
+		return h.customModelErrorResponse(e, err)
+	}
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// ListModelAliases handles GET /api/custom/models/aliases
+func (h *Handler) ListModelAliases(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	aliases, err := h.customModels.Aliases(user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch aliases")
+	}
+
+	return h.listJSON(e, aliases, nil)
+}
+
+// SaveModelAlias handles PUT /api/custom/models/aliases/{name}
+// Generation requests can then name the alias instead of a model
+func (h *Handler) SaveModelAlias(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req custommodels.AliasInput
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	target, exists := h.findModel(user, req.Model)
+	if !exists {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, fmt.Sprintf("Unsupported model %q", req.Model))
+	}
+
+	alias, err := h.customModels.SaveAlias(user.Id, e.Request.PathValue("name"), req, target)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	return h.respond(e, http.StatusOK, alias)
+}
+
+// DeleteModelAlias handles DELETE /api/custom/models/aliases/{name}
+func (h *Handler) DeleteModelAlias(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	if err := h.customModels.DeleteAlias(user.Id, e.Request.PathValue("name")); err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Alias not found")
+	}
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// GetRecentErrors handles GET /api/custom/debug/errors
+// It lists the caller's most recent FAL AI errors, newest first, so failed
+// generations can be diagnosed without server log access.
+func (h *Handler) GetRecentErrors(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	return h.listJSON(e, h.recentErrors.Recent(user.Id), map[string]interface{}{
+		"limit": h.recentErrors.Size(),
+	})
+}
+
+// ClearRecentErrors handles DELETE /api/custom/debug/errors
+func (h *Handler) ClearRecentErrors(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	h.recentErrors.Clear(user.Id)
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Lineage relations stored in other_info.lineage.relation
+const (
+	relationEdit       = "edit"
+	relationRegenerate = "regenerate"
+	relationVariation  = "variation"
+	relationOutpaint   = "outpaint"
+	relationUpscale    = "upscale"
+	relationCutout     = "remove_background"
+)
+
+// generationSettings are the settings an image was generated with
+type generationSettings struct {
+	Seed       int64                  `json:"seed"`
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+// recordedSettings reads an image's generation settings. The seed FAL
+// reported wins over a seed that was only requested.
+func recordedSettings(record *core.Record) generationSettings {
+	var settings generationSettings
+	record.UnmarshalJSONField("other_info", &settings)
+
+	params := make(map[string]interface{}, len(settings.Parameters)+1)
+	for key, value := range settings.Parameters {
+		params[key] = value
+	}
+	if settings.Seed == 0 {
+		if seed, ok := params["seed"].(float64); ok {
+			settings.Seed = int64(seed)
+		}
+	}
+	if settings.Seed != 0 {
+		params["seed"] = int(settings.Seed) // integer parameters validate as int
+	}
+	settings.Parameters = params
+	return settings
+}
+
+// derivationSource decodes the request and loads the image it derives from.
+// An empty body is allowed.
+func (h *Handler) derivationSource(e *core.RequestEvent) (*core.Record, localmodels.DeriveImageRequest, *accessError) {
+	var req localmodels.DeriveImageRequest
+	if err := h.decodeJSON(e, &req); err != nil && !errors.Is(err, io.EOF) {
+		return nil, req, &accessError{err.(*bodyError).status, localmodels.ErrCodeValidation, err.Error()}
+	}
+
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return nil, req, &accessError{http.StatusUnauthorized, localmodels.ErrCodeAuth, "Valid session required"}
+	}
+
+	source, err := h.imageRepo.Get(e.Request.PathValue("id"))
+	if err != nil || !h.canViewImage(user, source) {
+		return nil, req, &accessError{http.StatusNotFound, localmodels.ErrCodeNotFound, "Image not found"}
+	}
+
+	if _, exists := h.findModel(user, source.GetString("model")); !exists {
+		return nil, req, &accessError{http.StatusBadRequest, localmodels.ErrCodeValidation, "Only images generated with a supported model can be derived from"}
+	}
+
+	return source, req, nil
+}
+
+// generateDerived generates from a source image's model with the given
+// prompt and parameters and records the source as the parent
+func (h *Handler) generateDerived(e *core.RequestEvent, source *core.Record, relation, prompt string, params map[string]interface{}, collectionID string) error {
+	caller, handled, err := h.prepareGeneration(e, prompt, collectionID)
+	if handled {
+		return err
+	}
+
+	// Images from a deprecated model are derived with its replacement
+	model, params, warnings, err := h.migrateModel(e, source.GetString("model"), params)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	req := localmodels.GenerateImageRequest{
+		Model:        model,
+		Prompt:       prompt,
+		Parameters:   params,
+		CollectionID: collectionID,
+	}
+	result, imageInfos, err := h.runGeneration(e.Request.Context(), caller, req, &imageLinks{
+		parentID: source.Id,
+		relation: relation,
+	})
+	if err != nil {
+		h.logger.Error("Derived generation failed", "error", err, "parent_id", source.Id, "relation", relation)
+		return h.generationErrorResponse(e, err)
+	}
+
+	h.logger.Info("Derived image generated", "user_id", caller.user.Id, "parent_id", source.Id, "relation", relation, "model", req.Model, "cost", result.Cost)
+
+	return h.respond(e, http.StatusOK, localmodels.DeriveImageResponse{
+		GenerateImageResponse: generationResponse(req.Model, result, imageInfos, warnings),
+		ParentID:              source.Id,
+		Relation:              relation,
+		Seed:                  result.Seed,
+	})
+}
+
+// EditImagePrompt handles POST /api/custom/images/{id}/edit
+// It regenerates an image with the same model, seed and parameters but a
+// modified prompt
+func (h *Handler) EditImagePrompt(e *core.RequestEvent) error {
+	source, req, accessErr := h.derivationSource(e)
+	if accessErr != nil {
+		return h.accessErrorResponse(e, accessErr)
+	}
+
+	if req.Prompt == "" {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Prompt is required")
+	}
+
+	settings := recordedSettings(source)
+	if settings.Seed == 0 {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "This image has no recorded seed, so its composition cannot be reproduced")
+	}
+
+	return h.generateDerived(e, source, relationEdit, req.Prompt, settings.Parameters, req.CollectionID)
+}
+
+// RegenerateImage handles POST /api/custom/images/{id}/regenerate
+// It reruns an image's prompt and parameters with a fresh seed
+func (h *Handler) RegenerateImage(e *core.RequestEvent) error {
+	source, req, accessErr := h.derivationSource(e)
+	if accessErr != nil {
+		return h.accessErrorResponse(e, accessErr)
+	}
+
+	params := recordedSettings(source).Parameters
+	delete(params, "seed")
+
+	return h.generateDerived(e, source, relationRegenerate, source.GetString("prompt"), params, req.CollectionID)
+}
+
+// CreateVariation handles POST /api/custom/images/{id}/variation
+// It reruns an image's prompt and settings, seed included, with the
+// requested parameters overridden
+func (h *Handler) CreateVariation(e *core.RequestEvent) error {
+	source, req, accessErr := h.derivationSource(e)
+	if accessErr != nil {
+		return h.accessErrorResponse(e, accessErr)
+	}
+
+	if len(req.Parameters) == 0 {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "At least one parameter to vary is required")
+	}
+
+	params := recordedSettings(source).Parameters
+	for key, value := range req.Parameters {
+		params[key] = value
+	}
+
+	model, _ := h.requestModel(e, source.GetString("model"))
+	if err := model.ValidateParameters(params); err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	return h.generateDerived(e, source, relationVariation, source.GetString("prompt"), params, req.CollectionID)
+}
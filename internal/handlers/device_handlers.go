@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"generatio-pb/internal/devices"
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// rememberDeviceDays returns how long the user lets devices stay remembered.
+// Users opt in through generatio_users.remember_device_days and the deployment
+// caps it, so 0 means remembering is off.
+func (h *Handler) rememberDeviceDays(user *core.Record) int {
+	days := user.GetInt("remember_device_days")
+	if days > h.cfg.MaxRememberDeviceDays {
+		days = h.cfg.MaxRememberDeviceDays
+	}
+	if days < 0 {
+		return 0
+	}
+	return days
+}
+
+// rememberDeviceResponse describes the user's remember-device setting and the
+// trade-off it makes
+func (h *Handler) rememberDeviceResponse(user *core.Record) map[string]interface{} {
+	return map[string]interface{}{
+		"remember_days": h.rememberDeviceDays(user),
+		"max_days":      h.cfg.MaxRememberDeviceDays,
+		"warning":       "Anyone holding a remembered device's refresh token and device ID can spend your FAL AI credit without your password until the device expires or is revoked.",
+	}
+}
+
+// RefreshSession handles POST /api/custom/auth/refresh
+// It recreates a session from a remembered device without the password and
+// returns a rotated refresh token that replaces the one sent.
+func (h *Handler) RefreshSession(e *core.RequestEvent) error {
+	var req localmodels.RefreshSessionRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	days := h.rememberDeviceDays(user)
+	if days == 0 {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Remembering devices is turned off for this account")
+	}
+
+	falToken, refreshToken, device, err := h.devices.Redeem(user.Id, req.DeviceID, req.RefreshToken, time.Duration(days)*24*time.Hour)
+	if errors.Is(err, devices.ErrInvalidToken) {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Invalid or expired refresh token")
+	}
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to refresh session")
+	}
+
+	h.sessionStore.DeleteUserSessions(user.Id)
+
+	sessionID, err := h.sessionStore.Create(user.Id, falToken)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to create session")
+	}
+
+	session, err := h.sessionStore.Get(sessionID)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to retrieve session")
+	}
+	h.setSessionCookie(e, sessionID)
+
+	h.logger.Info("Session refreshed from remembered device", "device_id", device.ID, "user_id", user.Id)
+
+	refreshExpiresAt := h.deviceExpiry(device, days)
+	return h.respond(e, http.StatusOK, localmodels.CreateSessionResponse{
+		SessionID:        sessionID,
+		ExpiresAt:        session.ExpiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: &refreshExpiresAt,
+	})
+}
+
+// rememberDevice issues a refresh token for the device named in a
+// create-session request
+func (h *Handler) rememberDevice(user *core.Record, req localmodels.CreateSessionRequest, falToken string, resp *localmodels.CreateSessionResponse) error {
+	days := h.rememberDeviceDays(user)
+	refreshToken, device, err := h.devices.Remember(user.Id, req.DeviceID, req.DeviceName, falToken, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+
+	h.sendSecurityNotice(user, "A device was remembered",
+		fmt.Sprintf("%q can now open Generatio sessions without your password until %s. Revoke it under your remembered devices if this wasn't you.",
+			device.Name, device.ExpiresAt.Format("2 January 2006")))
+
+	resp.RefreshToken = refreshToken
+	resp.RefreshExpiresAt = &device.ExpiresAt
+	return nil
+}
+
+// deviceExpiry returns when a device stops working under the user's current limit
+func (h *Handler) deviceExpiry(device *devices.Device, days int) time.Time {
+	limit := device.Created.Add(time.Duration(days) * 24 * time.Hour)
+	if limit.Before(device.ExpiresAt) {
+		return limit
+	}
+	return device.ExpiresAt
+}
+
+// ListDevices handles GET /api/custom/auth/devices
+func (h *Handler) ListDevices(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	list, err := h.devices.List(user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch devices")
+	}
+
+	days := h.rememberDeviceDays(user)
+	active := make([]*devices.Device, 0, len(list))
+	for _, device := range list {
+		device.ExpiresAt = h.deviceExpiry(device, days)
+		if device.ExpiresAt.After(time.Now()) {
+			active = append(active, device)
+		}
+	}
+
+	return h.listJSON(e, active, h.rememberDeviceResponse(user))
+}
+
+// SetRememberDevice handles PUT /api/custom/auth/devices/settings
+// Setting remember_days to 0 turns remembering off and forgets every device
+func (h *Handler) SetRememberDevice(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.RememberDeviceSettingsRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	if req.Days == nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "remember_days is required")
+	}
+	if *req.Days < 0 || *req.Days > h.cfg.MaxRememberDeviceDays {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation,
+			fmt.Sprintf("remember_days must be between 0 and %d", h.cfg.MaxRememberDeviceDays))
+	}
+
+	user.Set("remember_device_days", *req.Days)
+	if err := h.app.Save(user); err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to save remember-device setting")
+	}
+
+	if *req.Days == 0 {
+		revoked, err := h.devices.RevokeAll(user.Id)
+		if err != nil {
+			return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to forget devices")
+		}
+		h.logger.Info("Remembering devices turned off", "user_id", user.Id, "revoked", revoked)
+	}
+
+	return h.respond(e, http.StatusOK, h.rememberDeviceResponse(user))
+}
+
+// RevokeDevice handles DELETE /api/custom/auth/devices/{id}
+func (h *Handler) RevokeDevice(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	if err := h.devices.Revoke(user.Id, e.Request.PathValue("id")); err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, err.Error())
+	}
+
+	h.logger.Info("Remembered device revoked", "device_id", e.Request.PathValue("id"), "user_id", user.Id)
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// jsonWithETag writes data as JSON tagged with a hash of its content, or an
+// empty 304 when the request's If-None-Match already names that tag. Clients
+// polling the response then only download it again after it changes.
+func (h *Handler) jsonWithETag(e *core.RequestEvent, status int, data interface{}) error {
+	return h.jsonWithCacheTag(e, status, data, "private, no-cache")
+}
+
+// jsonWithCacheTag is jsonWithETag with the given Cache-Control, for
+// responses shared caches may keep
+func (h *Handler) jsonWithCacheTag(e *core.RequestEvent, status int, data interface{}, cacheControl string) error {
+	// The envelope has ?fields= applied, so the tag covers what is written
+	resp, err := h.envelope(e, data, nil)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Invalid fields parameter")
+	}
+	body, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	hash.Write(body)
+	tag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+
+	header := e.Response.Header()
+	header.Set("ETag", tag)
+	header.Set("Cache-Control", cacheControl)
+
+	if etagMatches(e.Request.Header.Get("If-None-Match"), tag) {
+		return e.NoContent(http.StatusNotModified)
+	}
+	return e.JSON(status, resp)
+}
+
+// etagMatches reports whether an If-None-Match header names tag. Weak tags
+// match their strong counterpart, as If-None-Match uses weak comparison.
+func etagMatches(ifNoneMatch, tag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
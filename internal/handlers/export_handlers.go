@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/pagination"
+	"generatio-pb/internal/smartfolders"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// exportBatchSize is how many images are read per query while exporting
+const exportBatchSize = 500
+
+// exportFilter reads the images an export covers from the query: a saved
+// smart collection, or model, tag, prompt, favorite and days criteria
+func (h *Handler) exportFilter(e *core.RequestEvent, userID string) (smartfolders.Filter, *accessError) {
+	query := e.Request.URL.Query()
+
+	if id := query.Get("smart_collection"); id != "" {
+		folder, err := h.smartFolders.Find(id, userID)
+		if err != nil {
+			return smartfolders.Filter{}, &accessError{http.StatusNotFound, localmodels.ErrCodeNotFound, "Smart collection not found"}
+		}
+		return folder.Filter, nil
+	}
+
+	filter := smartfolders.Filter{
+		Model:  query.Get("model"),
+		Tag:    query.Get("tag"),
+		Prompt: query.Get("prompt"),
+	}
+	if raw := query.Get("favorite"); raw != "" {
+		favorite, err := strconv.ParseBool(raw)
+		if err != nil {
+			return filter, &accessError{http.StatusBadRequest, localmodels.ErrCodeValidation, "favorite must be true or false"}
+		}
+		filter.Favorite = &favorite
+	}
+	if raw := query.Get("days"); raw != "" {
+		days, err := strconv.Atoi(raw)
+		if err != nil || days < 1 || days > smartfolders.MaxDays {
+			return filter, &accessError{http.StatusBadRequest, localmodels.ErrCodeValidation, "days must be between 1 and " + strconv.Itoa(smartfolders.MaxDays)}
+		}
+		filter.Days = days
+	}
+	return filter, nil
+}
+
+// datasetRow converts a generated image to an export line. Imported,
+// uploaded and duplicated images are not generations and are skipped.
+func datasetRow(record *core.Record) (localmodels.DatasetRow, bool) {
+	var info struct {
+		Source string `json:"source"`
+	}
+	record.UnmarshalJSONField("other_info", &info)
+	if info.Source != "" || record.GetString("prompt") == "" {
+		return localmodels.DatasetRow{}, false
+	}
+
+	settings := recordedSettings(record)
+	delete(settings.Parameters, "seed") // exported on its own
+
+	return localmodels.DatasetRow{
+		ID:         record.Id,
+		Prompt:     record.GetString("prompt"),
+		Model:      record.GetString("model"),
+		Seed:       settings.Seed,
+		Parameters: settings.Parameters,
+		ImageSize:  record.Get("image_size"),
+		ImageURL:   record.GetString("url"),
+		Created:    record.GetDateTime("created").Time(),
+	}, true
+}
+
+// ExportPrompts handles GET /api/custom/images/export
+// Streams the user's generations in their personal library as JSONL, one
+// prompt, model, seed and parameter set per line, newest first.
+// Query parameters: smart_collection, or model, tag, prompt, favorite and days
+// with the same meaning as a smart collection's filter
+func (h *Handler) ExportPrompts(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	filter, accessErr := h.exportFilter(e, user.Id)
+	if accessErr != nil {
+		return h.errorResponse(e, accessErr.status, accessErr.code, accessErr.message)
+	}
+	// Fail before streaming when the images cannot be read at all
+	page := pagination.Page{Number: 1, Limit: exportBatchSize}
+	records, next, err := h.imageRepo.Search(user.Id, filter, page)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch images")
+	}
+
+	header := e.Response.Header()
+	header.Set("Content-Type", "application/x-ndjson")
+	header.Set("Content-Disposition", `attachment; filename="generations-`+time.Now().UTC().Format("20060102")+`.jsonl"`)
+	e.Response.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(e.Response)
+	exported := 0
+	for {
+		for _, record := range records {
+			row, ok := datasetRow(record)
+			if !ok {
+				continue
+			}
+			if err := encoder.Encode(row); err != nil {
+				h.logger.Warn("Prompt export interrupted", "user_id", user.Id, "error", err)
+				return nil
+			}
+			exported++
+		}
+		if next == "" {
+			break
+		}
+
+		cursor, err := pagination.DecodeCursor(next)
+		if err != nil {
+			break
+		}
+		page.Cursor = cursor
+		if records, next, err = h.imageRepo.Search(user.Id, filter, page); err != nil {
+			// The status is already sent, so the file just ends early
+			h.logger.Error("Prompt export failed", "user_id", user.Id, "error", err)
+			return nil
+		}
+	}
+
+	h.logger.Info("Prompts exported", "user_id", user.Id, "rows", exported)
+	return nil
+}
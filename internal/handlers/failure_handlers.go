@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/modelstats"
+	"generatio-pb/internal/pagination"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// ListFailedGenerations handles GET /api/custom/generate/failures
+// It lists the caller's failed generations newest first, with the stable
+// error code and what was requested (?page=&limit= or ?cursor=)
+func (h *Handler) ListFailedGenerations(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	page, err := pagination.Parse(e.Request.URL.Query(), modelstats.MaxListedFailures)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	failures, next, err := h.modelStats.Failures(user.Id, page)
+	if err != nil {
+		h.logger.Error("Failed to fetch failed generations", "error", err, "user_id", user.Id)
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch failed generations")
+	}
+
+	return h.listJSON(e, failures, map[string]interface{}{
+		"next_cursor": next,
+	})
+}
+
+// RetryFailedGeneration handles POST /api/custom/generate/failures/{id}/retry
+// It submits a failed generation's model, prompt and parameters again. The
+// optional body picks the folder the images are saved into.
+func (h *Handler) RetryFailedGeneration(e *core.RequestEvent) error {
+	var req localmodels.RetryGenerationRequest
+	if err := h.decodeJSON(e, &req); err != nil && !errors.Is(err, io.EOF) {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	failure, err := h.modelStats.Failure(user.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Failed generation not found")
+	}
+
+	caller, handled, err := h.prepareGeneration(e, failure.Prompt, req.CollectionID)
+	if handled {
+		return err
+	}
+
+	// Failures of a since deprecated model are retried with its replacement
+	model, params, warnings, err := h.migrateModel(e, failure.Model, failure.Parameters)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	retry := localmodels.GenerateImageRequest{
+		Model:        model,
+		Prompt:       failure.Prompt,
+		Parameters:   params,
+		CollectionID: req.CollectionID,
+	}
+	result, imageInfos, err := h.runGeneration(e.Request.Context(), caller, retry, nil)
+	if err != nil {
+		h.logger.Info("Retried generation failed again", "error", err, "user_id", user.Id, "failure_id", failure.ID)
+		h.publishGenerationFailed(user.Id, retry, err)
+		return h.generationErrorResponse(e, err)
+	}
+
+	if err := h.modelStats.MarkRetried(failure.ID); err != nil {
+		h.logger.Warn("Failed to mark generation retried", "error", err, "failure_id", failure.ID)
+	}
+
+	return h.respond(e, http.StatusOK, generationResponse(retry.Model, result, imageInfos, warnings))
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"generatio-pb/internal/cdn"
+	"generatio-pb/internal/feeds"
+	"generatio-pb/internal/folderacl"
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// feedPath is where a feed is served; it works without authentication
+func (h *Handler) feedPath(token string) string {
+	return strings.TrimSuffix(h.app.Settings().Meta.AppURL, "/") + "/api/custom/shared/feeds/" + token
+}
+
+// withFeedURL fills in the feed's public URL
+func (h *Handler) withFeedURL(feed *feeds.Feed) *feeds.Feed {
+	feed.URL = h.feedPath(feed.Token)
+	return feed
+}
+
+// ListFeeds handles GET /api/custom/feeds
+func (h *Handler) ListFeeds(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	published, err := h.feeds.List(user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch feeds")
+	}
+	for _, feed := range published {
+		h.withFeedURL(feed)
+	}
+
+	return h.listJSON(e, published, nil)
+}
+
+// CreateFeed handles POST /api/custom/feeds
+// It publishes the user's gallery (their folders not marked private), or a
+// folder they own, as an RSS/Atom feed anyone with the returned URL can read
+func (h *Handler) CreateFeed(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.CreateFeedRequest
+	if e.Request.ContentLength != 0 {
+		if err := h.decodeJSON(e, &req); err != nil {
+			return h.invalidBodyResponse(e, err)
+		}
+	}
+
+	if req.FolderID != "" {
+		folder, role, err := h.folders.Find(req.FolderID, user.Id)
+		if err != nil {
+			return h.accessErrorResponse(e, errFolderNotFound)
+		}
+		if !folderacl.CanDelete(role) {
+			return h.accessErrorResponse(e, errFolderOwner)
+		}
+		if folder.GetBool("private") {
+			return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Private folders cannot be published")
+		}
+	}
+
+	feed, err := h.feeds.Create(user.Id, req.FolderID, req.Title)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	h.logger.Info("Feed published", "user_id", user.Id, "feed_id", feed.ID, "folder_id", feed.FolderID)
+
+	return h.respond(e, http.StatusOK, h.withFeedURL(feed))
+}
+
+// DeleteFeed handles DELETE /api/custom/feeds/{id}
+func (h *Handler) DeleteFeed(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	if err := h.feeds.Delete(e.Request.PathValue("id"), user.Id); err != nil {
+		if errors.Is(err, feeds.ErrNotFound) {
+			return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Feed not found")
+		}
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to delete feed")
+	}
+
+	h.logger.Info("Feed revoked", "user_id", user.Id, "feed_id", e.Request.PathValue("id"))
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// ServeFeed handles GET /api/custom/shared/feeds/{token}
+// It is unauthenticated; the token grants access. ?format=atom returns Atom
+// instead of RSS 2.0.
+func (h *Handler) ServeFeed(e *core.RequestEvent) error {
+	format := e.Request.URL.Query().Get("format")
+	if format == "" {
+		format = feeds.FormatRSS
+	}
+	if format != feeds.FormatRSS && format != feeds.FormatAtom {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "format must be \"rss\" or \"atom\"")
+	}
+
+	feed, err := h.feeds.ByToken(e.Request.PathValue("token"))
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Feed not found")
+	}
+
+	images, err := h.feeds.Images(feed)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch images")
+	}
+
+	channel := feeds.Channel{Title: feed.Title, Link: h.feedPath(feed.Token), Updated: feed.Created}
+	items := make([]feeds.Item, 0, len(images))
+	for _, image := range images {
+		created := image.GetDateTime("created").Time()
+		if created.After(channel.Updated) {
+			channel.Updated = created
+		}
+		title := image.GetString("title")
+		if title == "" {
+			title = image.GetString("prompt")
+		}
+		items = append(items, feeds.Item{
+			ID:        image.Id,
+			Title:     title,
+			Link:      h.feedPath(feed.Token) + "/images/" + image.Id,
+			Prompt:    image.GetString("prompt"),
+			Model:     image.GetString("model"),
+			Published: created,
+		})
+	}
+
+	body, err := feeds.Render(format, channel, items)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to render feed")
+	}
+
+	contentType := feeds.ContentTypeRSS
+	if format == feeds.FormatAtom {
+		contentType = feeds.ContentTypeAtom
+	}
+	e.Response.Header().Set("Cache-Control", "public, max-age=300")
+	return e.Blob(http.StatusOK, contentType, body)
+}
+
+// ServeFeedImage handles GET /api/custom/shared/feeds/{token}/images/{image_id}
+// It serves the files of the images a feed lists
+func (h *Handler) ServeFeedImage(e *core.RequestEvent) error {
+	feed, err := h.feeds.ByToken(e.Request.PathValue("token"))
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Image not found")
+	}
+
+	record, err := h.imageRepo.Get(e.Request.PathValue("image_id"))
+	if err != nil || !h.feeds.Publishes(feed, record) {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Image not found")
+	}
+
+	cdn.SetTags(e.Response.Header(), cdn.ImageTag(record.Id))
+	return h.streamImage(e, record, "public, max-age=3600")
+}
+
+// ServeFeedWidget handles GET /api/custom/shared/feeds/{token}/widget?limit=
+// It lists a feed's newest images as compact JSON for galleries embedded in
+// other sites. Any origin may read it, and shared caches may keep it for a
+// few minutes; image links go through the feed, never the main API.
+func (h *Handler) ServeFeedWidget(e *core.RequestEvent) error {
+	e.Response.Header().Set("Access-Control-Allow-Origin", "*")
+
+	feed, err := h.feeds.ByToken(e.Request.PathValue("token"))
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Feed not found")
+	}
+
+	limit, _ := strconv.Atoi(e.Request.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = feeds.DefaultWidgetImages
+	}
+
+	images, err := h.feeds.Images(feed)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch images")
+	}
+	if len(images) > limit {
+		images = images[:limit]
+	}
+
+	// Thumbnails are the smallest configured variant, or the image itself without variants
+	thumbnail := ""
+	if size := h.imageCache.SmallestSize(); size > 0 {
+		thumbnail = "?size=" + strconv.Itoa(size) + "&format=" + h.imageCache.DefaultFormat()
+	}
+
+	widget := localmodels.GalleryWidget{
+		Title:  feed.Title,
+		Feed:   h.feedPath(feed.Token),
+		Images: make([]localmodels.GalleryWidgetImage, 0, len(images)),
+	}
+	for _, image := range images {
+		title := image.GetString("title")
+		if title == "" {
+			title = image.GetString("prompt")
+		}
+		link := h.feedPath(feed.Token) + "/images/" + image.Id
+		widget.Images = append(widget.Images, localmodels.GalleryWidgetImage{
+			Title:     title,
+			Thumbnail: link + thumbnail,
+			Link:      link,
+		})
+	}
+
+	return h.jsonWithCacheTag(e, http.StatusOK, widget, "public, max-age=300, stale-while-revalidate=3600")
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/repository"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// maxFolderDepth is how deeply folders may be nested; root folders are at depth 1
+const maxFolderDepth = 10
+
+// Folder placement errors, reported to the client as validation errors
+var (
+	errFolderCycle   = errors.New("a folder cannot be placed inside itself or one of its subfolders")
+	errFolderTooDeep = fmt.Errorf("folders can be nested at most %d levels deep", maxFolderDepth)
+)
+
+// folderDepth returns the depth of a folder by following its parent_id chain:
+// 1 for a root folder. Trashed ancestors still count, since restoring them
+// brings the chain back. The walk fails with errFolderCycle when it reaches
+// movingID or revisits a folder.
+func (h *Handler) folderDepth(folderID, movingID string) (int, error) {
+	depth := 0
+	seen := make(map[string]bool)
+	for id := folderID; id != ""; depth++ {
+		if id == movingID || seen[id] {
+			return 0, errFolderCycle
+		}
+		if depth > maxFolderDepth {
+			return depth, nil
+		}
+		seen[id] = true
+
+		folder, err := h.folderRepo.GetIn(repository.All, id)
+		if err != nil {
+			// A dangling parent_id ends the chain
+			return depth + 1, nil
+		}
+		id = folder.GetString("parent_id")
+	}
+	return depth, nil
+}
+
+// folderHeight returns the number of levels in the live tree rooted at a
+// folder: 1 for a folder without subfolders
+func (h *Handler) folderHeight(root *core.Record) (int, error) {
+	height := 0
+	level := []string{root.Id}
+	seen := map[string]bool{root.Id: true}
+	for len(level) > 0 && height <= maxFolderDepth {
+		height++
+
+		var next []string
+		for _, id := range level {
+			children, err := h.folderRepo.Children(id)
+			if err != nil {
+				return 0, fmt.Errorf("failed to fetch subfolders: %w", err)
+			}
+			for _, child := range children {
+				if !seen[child.Id] {
+					seen[child.Id] = true
+					next = append(next, child.Id)
+				}
+			}
+		}
+		level = next
+	}
+	return height, nil
+}
+
+// checkFolderPlacement reports whether a tree of the given height can be
+// placed under parentID ("" for the root level). movingID is the folder being
+// moved, or "" when the tree is new.
+func (h *Handler) checkFolderPlacement(parentID, movingID string, height int) error {
+	depth, err := h.folderDepth(parentID, movingID)
+	if err != nil {
+		return err
+	}
+	if depth+height > maxFolderDepth {
+		return errFolderTooDeep
+	}
+	return nil
+}
+
+// folderPlacementResponse writes the response for a failed checkFolderPlacement
+func (h *Handler) folderPlacementResponse(e *core.RequestEvent, err error) error {
+	if errors.Is(err, errFolderCycle) || errors.Is(err, errFolderTooDeep) {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+	h.logger.Error("Failed to check folder placement", "error", err)
+	return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to check folder placement")
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"generatio-pb/internal/folderacl"
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// targetFolderError explains why new images cannot be saved into a folder
+type targetFolderError struct {
+	reason  string // Reported in the error details for clients to branch on
+	message string
+}
+
+func (e *targetFolderError) Error() string {
+	return e.message
+}
+
+var (
+	errTargetFolderNotFound = &targetFolderError{"not_found", "folder not found"}
+	errTargetFolderReadOnly = &targetFolderError{"read_only", "you do not have edit access to this folder"}
+	errTargetFolderLibrary  = &targetFolderError{"other_library", "folder belongs to a different library"}
+)
+
+// checkTargetFolder verifies that images saved into folderID can be kept
+// there: the folder exists and is not trashed, the user owns it or can edit
+// it, and it belongs to the library the images go to (orgID, or the personal
+// library when empty). An empty folderID means the library root.
+func (h *Handler) checkTargetFolder(user *core.Record, orgID, folderID string) error {
+	if folderID == "" {
+		return nil
+	}
+
+	folder, role, err := h.folders.Find(folderID, user.Id)
+	if err != nil {
+		return errTargetFolderNotFound
+	}
+	if !folderacl.CanEdit(role) {
+		return errTargetFolderReadOnly
+	}
+	if folder.GetString("org_id") != orgID {
+		return errTargetFolderLibrary
+	}
+	return nil
+}
+
+// targetFolderResponse sends the response for a failed checkTargetFolder
+func (h *Handler) targetFolderResponse(e *core.RequestEvent, folderID string, err error) error {
+	var folderErr *targetFolderError
+	if !errors.As(err, &folderErr) {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to check folder")
+	}
+	return h.apiErrorResponse(e, http.StatusBadRequest, localmodels.APIError{
+		Code:    localmodels.ErrCodeInvalidFolder,
+		Message: err.Error(),
+		Details: map[string]string{
+			"folder_id": folderID,
+			"reason":    folderErr.reason,
+		},
+	})
+}
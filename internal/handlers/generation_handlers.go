@@ -2,155 +2,850 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"generatio-pb/internal/budget"
+	"generatio-pb/internal/custommodels"
+	"generatio-pb/internal/dedupe"
+	"generatio-pb/internal/errorlog"
 	"generatio-pb/internal/fal"
+	"generatio-pb/internal/hooks"
+	"generatio-pb/internal/keystats"
 	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/modelstats"
+	"generatio-pb/internal/moderation"
+	"generatio-pb/internal/money"
+	"generatio-pb/internal/resultcache"
 
 	"github.com/pocketbase/pocketbase/core"
 )
 
+// generationTimeout bounds a FAL AI generation made for a request. The
+// request's own context bounds it further: a client that disconnects stops
+// the polling for a result nobody will read.
+const generationTimeout = 10 * time.Minute
+
 // GenerateImage handles POST /api/custom/generate/image
 func (h *Handler) GenerateImage(e *core.RequestEvent) error {
-	h.app.Logger().Info("🎨 GenerateImage endpoint called",
+	h.logger.Info("🎨 GenerateImage endpoint called",
 		"method", e.Request.Method,
-		"url", e.Request.URL.String(),
-		"user_agent", e.Request.Header.Get("User-Agent"),
+		"path", e.Request.URL.Path,
+		"user_agent", e.Request.UserAgent(),
 	)
 
 	var req localmodels.GenerateImageRequest
-	if err := json.NewDecoder(e.Request.Body).Decode(&req); err != nil {
-		h.app.Logger().Error("Failed to decode request body", "error", err)
-		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Invalid request body")
-	}
-
-	if req.Model == "" || req.Prompt == "" {
-		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Model and prompt are required")
+	if err := h.decodeJSON(e, &req); err != nil {
+		h.logger.Error("Failed to decode request body", "error", err)
+		return h.invalidBodyResponse(e, err)
 	}
 
-	h.app.Logger().Info("✓ Request decoded successfully", "model", req.Model, "prompt_length", len(req.Prompt))
+	h.logger.Info("✓ Request decoded successfully", "model", req.Model, "prompt_length", len(req.Prompt))
 
 	// Get authenticated user and session
 	user, session, err := h.getAuthenticatedUserAndSession(e)
 	if err != nil {
-		h.app.Logger().Error("Authentication failed", "error", err)
-		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Valid session required")
+		h.logger.Error("Authentication failed", "error", err)
+		return h.sessionErrorResponse(e, err)
+	}
+
+	h.logger.Info("✓ Authentication successful", "user_id", user.Id, "session_exists", session != nil)
+
+	priority, err := h.requestPriority(user, req.Priority, fal.PriorityInteractive)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+	if req.MaxWait < 0 {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "max_wait must not be negative")
+	}
+
+	// Generate into an organization library when the org switcher is set
+	orgID, accessErr := h.writableOrg(e, user)
+	if accessErr != nil {
+		return h.accessErrorResponse(e, accessErr)
+	}
+
+	// Saving into a folder requires edit access to it
+	if err := h.checkTargetFolder(user, orgID, req.CollectionID); err != nil {
+		return h.targetFolderResponse(e, req.CollectionID, err)
+	}
+
+	// Reject prompts blocked by the deployment's content policy
+	if decision := h.filter.Evaluate(req.Prompt); !decision.Allowed {
+		h.logger.Info("Prompt rejected by content filter", "user_id", user.Id, "strictness", decision.Strictness)
+		return h.apiErrorResponse(e, http.StatusBadRequest, localmodels.APIError{
+			Code:    localmodels.ErrCodeContentPolicy,
+			Message: decision.Reason(),
+			Details: decision,
+		})
+	}
+
+	// Aliases stand for a model with default parameters
+	req.Model, req.Parameters = h.resolveAlias(user, req.Model, req.Parameters)
+
+	// Deprecated models are replaced by their successors
+	var warnings []string
+	req.Model, req.Parameters, warnings, err = h.migrateModel(e, req.Model, req.Parameters)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	// Custom models are looked up in the user's registry
+	customModel := h.customModel(user, req.Model)
+	if customModel == nil && strings.HasPrefix(req.Model, custommodels.IDPrefix) {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, fmt.Sprintf("Unsupported model %q", req.Model))
+	}
+
+	// Saved preferences fill in parameters the request leaves out; model
+	// defaults cover the rest on FAL AI's side
+	merged := h.mergeParameters(user, req.Model, req.Parameters)
+	req.Parameters = merged.Submitted
+
+	if req.DryRun {
+		return h.dryRunResponse(e, user, req, priority, warnings, merged)
 	}
 
-	h.app.Logger().Info("✓ Authentication successful", "user_id", user.Id, "session_exists", session != nil)
+	// A seeded request reproduces its earlier result, which is returned as stored
+	cacheKey := ""
+	if customModel == nil {
+		cacheKey = resultcache.Key(user.Id, orgID, req.CollectionID, req.Model, req.Prompt, req.Parameters)
+	}
+	if cacheKey != "" && !req.NoCache {
+		if resp, ok := h.cachedResponse(cacheKey, req.Model, warnings); ok {
+			h.logger.Info("Seeded generation answered from the result cache", "user_id", user.Id, "model", req.Model)
+			return h.respond(e, http.StatusOK, resp)
+		}
+	}
+
+	// An identical request moments ago is usually an impatient double-click
+	var submission *dedupe.Submission
+	if !req.AllowDuplicate {
+		var previous *dedupe.Submission
+		submission, previous = h.duplicates.Begin(dedupe.Request{
+			UserID:       user.Id,
+			OrgID:        orgID,
+			CollectionID: req.CollectionID,
+			Model:        req.Model,
+			Prompt:       req.Prompt,
+			Parameters:   req.Parameters,
+		}.Fingerprint())
+		if previous != nil {
+			if resp, ok := h.duplicateResponse(e.Request.Context(), previous); ok {
+				h.logger.Info("Duplicate generation answered with the earlier result", "user_id", user.Id, "model", req.Model)
+				return h.respond(e, http.StatusOK, resp)
+			}
+			warnings = append(warnings, fmt.Sprintf("An identical request was submitted %s ago; this one is generated and charged again",
+				time.Since(previous.Started()).Round(time.Second)))
+		}
+	}
 
 	// Create FAL generation request
 	falReq := fal.GenerationRequest{
-		Model:      req.Model,
-		Prompt:     req.Prompt,
-		Parameters: req.Parameters,
+		Model:       req.Model,
+		Prompt:      req.Prompt,
+		Parameters:  req.Parameters,
+		Priority:    priority,
+		CustomModel: customModel,
+	}
+
+	h.logger.Info("🚀 Starting FAL API call", "model", req.Model, "priority", priority)
+
+	// Generating and saving only depend on ctx, so a request with max_wait can
+	// leave them running once it stops waiting
+	run := func(ctx context.Context) (*localmodels.GenerateImageResponse, error) {
+		startTime := time.Now()
+		result, release, err := h.generate(ctx, user.Id, session.FALToken, falReq)
+		if err != nil {
+			h.logger.Error("❌ FAL API call failed", "error", err, "duration", time.Since(startTime))
+			submission.Fail()
+			h.publishGenerationFailed(user.Id, req, err)
+			return nil, err
+		}
+		defer release()
+		generationTime := time.Since(startTime)
+		if result.Coalesced {
+			warnings = append(warnings, "An identical seeded request was being generated; its images are shared and nothing was charged")
+		}
+
+		// Save generated images to database and create response
+		var links *imageLinks
+		if cacheKey != "" {
+			links = &imageLinks{cacheKey: cacheKey}
+		}
+		imageInfos, err := h.saveGeneration(ctx, user, session.FALToken, session.Environment, orgID, req, result, generationTime, links)
+		if err != nil {
+			submission.Fail()
+			h.publishGenerationFailed(user.Id, req, err)
+			return nil, err
+		}
+
+		// Email the user if they opted in to completion notifications
+		if shouldSendCompletionEmail(user, generationTime) {
+			h.sendCompletionEmail(user, req.Model, req.Prompt, imageInfos, generationTime)
+		}
+
+		h.logger.Info("Image generated successfully", 
+			"user_id", user.Id,
+			"model", req.Model,
+			"cost", result.Cost,
+			"generation_time", generationTime.String(),
+		)
+
+		resp := generationResponse(req.Model, result, imageInfos, warnings)
+		submission.Complete(&resp)
+		return &resp, nil
 	}
 
-	h.app.Logger().Info("🚀 Starting FAL API call", "model", req.Model, "has_token", len(session.FALToken) > 0)
+	if wait := h.generationWait(req.MaxWait); wait > 0 {
+		return h.awaitGeneration(e, user.Id, wait, run)
+	}
 
 	// Generate image
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	ctx, cancel := context.WithTimeout(e.Request.Context(), generationTimeout)
 	defer cancel()
 
-	startTime := time.Now()
-	result, err := h.falClient.GenerateImage(ctx, session.FALToken, falReq)
+	resp, err := run(ctx)
 	if err != nil {
-		h.app.Logger().Error("❌ FAL API call failed", "error", err, "duration", time.Since(startTime))
-		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeExternal, "Image generation failed: "+err.Error())
+		return h.generationErrorResponse(e, err)
 	}
-	generationTime := time.Since(startTime)
+	return h.respond(e, http.StatusOK, resp)
+}
+
+// generationWait returns how long a request with max_wait seconds waits for
+// its generation, capped by MaxGenerationWait. Zero waits until it finishes.
+func (h *Handler) generationWait(maxWait int) time.Duration {
+	if maxWait <= 0 || h.cfg.MaxGenerationWait <= 0 {
+		return 0
+	}
+	if maxWait >= int(h.cfg.MaxGenerationWait/time.Second) {
+		return h.cfg.MaxGenerationWait
+	}
+	return time.Duration(maxWait) * time.Second
+}
+
+// awaitGeneration runs a generation as a job detached from its request and
+// waits up to wait for it. A generation still running then, or when the
+// client disconnects, is answered with 202 and the job, which is polled at
+// GET /api/custom/generate/jobs/{id} while it runs on to generationTimeout.
+func (h *Handler) awaitGeneration(e *core.RequestEvent, userID string, wait time.Duration, run func(ctx context.Context) (*localmodels.GenerateImageResponse, error)) error {
+	job := h.generationJobs.Start(userID)
+
+	detached := context.WithoutCancel(e.Request.Context())
+	go func() {
+		ctx, cancel := context.WithTimeout(detached, generationTimeout)
+		defer cancel()
+
+		resp, err := run(ctx)
+		if err != nil {
+			status, apiErr := generationError(err)
+			job.Fail(status, apiErr)
+			return
+		}
+		job.Complete(resp)
+	}()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-job.Done():
+		outcome, _ := h.generationJobs.Get(userID, job.ID())
+		if outcome.Error != nil {
+			return h.apiErrorResponse(e, outcome.HTTPStatus, *outcome.Error)
+		}
+		return h.respond(e, http.StatusOK, outcome.Result)
+	case <-timer.C:
+	case <-e.Request.Context().Done():
+	}
+
+	h.logger.Info("Generation outlasted its max_wait; answering with a job", "user_id", userID, "job_id", job.ID(), "max_wait", wait.String())
+	pending, _ := h.generationJobs.Get(userID, job.ID())
+	e.Response.Header().Set("Location", "/api/custom/generate/jobs/"+job.ID())
+	return h.respond(e, http.StatusAccepted, pending)
+}
+
+// GetGenerationJob handles GET /api/custom/generate/jobs/{id}
+// The job of a generation that outlasted its max_wait carries the generation's
+// response once completed, or its error once failed
+func (h *Handler) GetGenerationJob(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	job, ok := h.generationJobs.Get(user.Id, e.Request.PathValue("id"))
+	if !ok {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Generation job not found")
+	}
+	if job.Error != nil {
+		localized := h.localizedError(e, *job.Error)
+		job.Error = &localized
+	}
+
+	return h.respond(e, http.StatusOK, job)
+}
+
+// generationResponse builds the response to a settled generation result,
+// reporting the requested images that were not delivered
+func generationResponse(model string, result *fal.GenerationResponse, images []localmodels.GeneratedImageInfo, warnings []string) localmodels.GenerateImageResponse {
+	resp := localmodels.GenerateImageResponse{
+		Images:     images,
+		Cost:       result.Cost,
+		CostMicros: money.FromUSD(result.Cost),
+		Model:      model,
+		Warnings:   warnings,
+	}
+	if result.Partial() {
+		resp.Partial = true
+		resp.ImageStatuses = result.ImageStatuses
+		resp.Warnings = append(resp.Warnings, fmt.Sprintf("Only %d of %d requested images were delivered; only those were charged",
+			len(result.Images), len(result.ImageStatuses)))
+	}
+	return resp
+}
+
+// dryRunResponse validates and prices a generation request that went through
+// the same checks as a real one, and returns what would be submitted to FAL AI
+// without calling it
+func (h *Handler) dryRunResponse(e *core.RequestEvent, user *core.Record, req localmodels.GenerateImageRequest, priority string, warnings []string, merged fal.MergedParameters) error {
+	model, exists := h.findModel(user, req.Model)
+	if !exists {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, fmt.Sprintf("Unsupported model %q", req.Model))
+	}
+
+	params := req.Parameters
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+	if err := model.ValidateParameters(params); err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	// A request over the user's limits would be refused before reaching FAL
+	if err := h.budgets.Check(user.Id); err != nil {
+		return h.generationErrorResponse(e, err)
+	}
+
+	return h.respond(e, http.StatusOK, localmodels.DryRunResponse{
+		DryRun:        true,
+		Model:         req.Model,
+		Prompt:        req.Prompt,
+		Parameters:    params,
+		Priority:      priority,
+		CollectionID:  req.CollectionID,
+		EstimatedCost: model.EstimateCost(params),
+		Warnings:      warnings,
+
+		EffectiveParameters: merged.Effective,
+		ParameterSources:    merged.Sources,
+	})
+}
+
+// duplicateResponse answers a duplicate generation with the images of the
+// identical earlier submission, waiting for it if it is still in flight. It
+// reports false in "warn" mode, or when the earlier submission failed, and the
+// duplicate is then generated.
+func (h *Handler) duplicateResponse(ctx context.Context, previous *dedupe.Submission) (*localmodels.GenerateImageResponse, bool) {
+	if h.cfg.DuplicateAction != "coalesce" {
+		return nil, false
+	}
+
+	result, ok := previous.Wait(ctx)
+	if !ok {
+		return nil, false
+	}
+	first, ok := result.(*localmodels.GenerateImageResponse)
+	if !ok {
+		return nil, false
+	}
+
+	resp := *first
+	resp.Cost = 0
+	resp.CostMicros = 0
+	resp.Duplicate = true
+	resp.Warnings = append(append([]string(nil), first.Warnings...),
+		"An identical request was submitted moments ago; its images are returned and nothing was charged again. Set allow_duplicate to generate anyway.")
+	return &resp, true
+}
+
+// cachedResponse answers a seeded generation with the stored images of an
+// identical earlier one. It reports false on a cache miss.
+func (h *Handler) cachedResponse(key, model string, warnings []string) (*localmodels.GenerateImageResponse, bool) {
+	records, err := h.resultCache.Lookup(key)
+	if err != nil {
+		h.logger.Warn("Result cache lookup failed", "error", err)
+		return nil, false
+	}
+	if len(records) == 0 {
+		return nil, false
+	}
+
+	images := make([]localmodels.GeneratedImageInfo, 0, len(records))
+	for _, record := range records {
+		images = append(images, h.withVariants(withTimestamps(moderatedImageInfo(record.Id, record.GetString("url"), "", record.GetString("moderation_status")), record)))
+	}
+	return &localmodels.GenerateImageResponse{
+		Images:   images,
+		Cost:     0,
+		Model:    model,
+		Warnings: warnings,
+		Cached:   true,
+	}, true
+}
+
+// generationErrorResponse reports a failed generation, telling users who
+// reached a limit set by a superuser which one. FAL AI failures are reported
+// with a stable code and whether retrying may help.
+func (h *Handler) generationErrorResponse(e *core.RequestEvent, err error) error {
+	status, apiErr := generationError(err)
+	return h.apiErrorResponse(e, status, apiErr)
+}
+
+// generationError describes a failed generation as the status and error it
+// is answered with
+func generationError(err error) (int, localmodels.APIError) {
+	switch {
+	case errors.Is(err, budget.ErrBudgetExceeded):
+		return http.StatusPaymentRequired, localmodels.APIError{Code: localmodels.ErrCodeQuota, Message: "Your monthly budget is exhausted"}
+	case errors.Is(err, budget.ErrQuotaExceeded):
+		return http.StatusTooManyRequests, localmodels.APIError{Code: localmodels.ErrCodeQuota, Message: "Your daily image quota is reached"}
+	}
+
+	// The images were charged for, so the user is given what can be rescued
+	var unsaved *unsavedGenerationError
+	if errors.As(err, &unsaved) {
+		return http.StatusInternalServerError, localmodels.APIError{
+			Code:    localmodels.ErrCodeInternal,
+			Message: unsavedGenerationMessage,
+			Details: map[string]interface{}{
+				"request_id": unsaved.requestID,
+				"image_urls": unsaved.urls,
+				"cost":       unsaved.cost.USD(),
+			},
+		}
+	}
+
+	// FAL errors are reported by stable code rather than FAL's own wording
+	classified := fal.Classify(err)
+	details := map[string]interface{}{}
+	var falErr *fal.FALError
+	if errors.As(err, &falErr) {
+		if falErr.RequestID != "" {
+			details["request_id"] = falErr.RequestID
+		}
+		// Rejected requests can only be fixed knowing what was wrong with them
+		if classified.Code == fal.ErrorCodeInvalidRequest && falErr.Message != "" {
+			details["reason"] = falErr.Message
+		}
+	}
+	apiErr := localmodels.APIError{
+		Code:      classified.Code,
+		Message:   classified.Message,
+		Retryable: &classified.Retryable,
+	}
+	if len(details) > 0 {
+		apiErr.Details = details
+	}
+	return classified.Status, apiErr
+}
 
-	// Save generated images to database and create response
-	var imageInfos []localmodels.GeneratedImageInfo
+// describeGenerationError describes a failed comparison variant or sweep
+// cell: limits set by superusers by name, FAL AI failures by stable code
+func describeGenerationError(err error) fal.Classification {
+	if errors.Is(err, budget.ErrBudgetExceeded) || errors.Is(err, budget.ErrQuotaExceeded) {
+		return fal.Classification{Code: localmodels.ErrCodeQuota, Message: err.Error()}
+	}
+	var unsaved *unsavedGenerationError
+	if errors.As(err, &unsaved) {
+		return fal.Classification{Code: localmodels.ErrCodeInternal, Message: unsavedGenerationMessage}
+	}
+	return fal.Classify(err)
+}
+
+// generate sends a request to FAL and records its duration and outcome for
+// the per-model statistics. Custom models are private and are not recorded,
+// and neither are requests cancelled by the caller. An identical seeded
+// request of the same user already in flight is shared instead of submitted
+// again; its result comes back marked Coalesced and free of charge.
+//
+// The request's estimated cost is reserved against the user's limits before
+// FAL AI is called, so concurrent requests cannot all pass the same check.
+// On success the caller must call release once the images are saved.
+func (h *Handler) generate(ctx context.Context, userID, token string, req fal.GenerationRequest) (*fal.GenerationResponse, func(), error) {
+	// Limits set by superusers apply to every kind of generation
+	release, err := h.budgets.Reserve(userID, req.EstimatedCost(), fal.RequestedImages(req.Parameters))
+	if err != nil {
+		h.notifyBudgetTripped(userID, err)
+		return nil, nil, err
+	}
+
+	// Custom model IDs are per user, so only built-in models are shared
+	key := ""
+	if req.CustomModel == nil {
+		key = dedupe.SeededKey(userID, req.Model, req.Prompt, req.Parameters)
+	}
+	result, shared, err := h.coalescer.Do(ctx, key, func() (interface{}, error) {
+		return h.submitGeneration(ctx, userID, token, req)
+	})
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	if !shared {
+		return result.(*fal.GenerationResponse), release, nil
+	}
+
+	h.logger.Info("Generation shared with an identical request in flight", "user_id", userID, "model", req.Model)
+	coalesced := *result.(*fal.GenerationResponse)
+	coalesced.Cost = 0
+	coalesced.Coalesced = true
+	return &coalesced, release, nil
+}
+
+// submitGeneration submits a request to FAL AI and records its failure.
+// Successful jobs are recorded with their images by saveGeneration.
+func (h *Handler) submitGeneration(ctx context.Context, userID, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+	startTime := time.Now()
+	result, err := h.falClient.GenerateImage(ctx, token, req)
+	if err == nil || errors.Is(err, context.Canceled) {
+		return result, err
+	}
+
+	if req.CustomModel == nil {
+		// Failures are kept with what was asked, so the user can retry them
+		classified := fal.Classify(err)
+		job := modelstats.Job{
+			Model:      req.Model,
+			Duration:   time.Since(startTime),
+			Prompt:     req.Prompt,
+			Parameters: req.Parameters,
+			ErrorCode:  classified.Code,
+			Message:    classified.Message,
+			Retryable:  classified.Retryable,
+		}
+		var falErr *fal.FALError
+		if errors.As(err, &falErr) {
+			job.RequestID = falErr.RequestID
+		}
+		if recordErr := h.modelStats.Record(userID, job); recordErr != nil {
+			h.logger.Warn("Failed to record generation job", "error", recordErr, "model", req.Model)
+		}
+	}
+	h.recordFALError(userID, req.Model, err)
+	return result, err
+}
+
+// publishGenerationFailed publishes a failed generation as job.failed.
+// Refusals by a budget or quota are published as budget.exceeded instead.
+func (h *Handler) publishGenerationFailed(userID string, req localmodels.GenerateImageRequest, err error) {
+	if errors.Is(err, budget.ErrBudgetExceeded) || errors.Is(err, budget.ErrQuotaExceeded) || errors.Is(err, context.Canceled) {
+		return
+	}
+
+	classified := fal.Classify(err)
+	h.publishHook(userID, hooks.EventJobFailed, "Your generation failed", map[string]interface{}{
+		"kind":    "generation",
+		"model":   req.Model,
+		"prompt":  req.Prompt,
+		"code":    classified.Code,
+		"message": classified.Message,
+	})
+}
+
+// recordFALError keeps a failed FAL AI call in the user's recent errors
+func (h *Handler) recordFALError(userID, model string, err error) {
+	entry := errorlog.Entry{Code: "request_failed", Message: err.Error(), Model: model}
+	var falErr *fal.FALError
+	if errors.As(err, &falErr) {
+		entry.Code = falErr.Code
+		entry.RequestID = falErr.RequestID
+	}
+	h.recentErrors.Record(userID, entry)
+}
+
+// requestPriority resolves a request's priority, using fallback when none was
+// requested, and caps it at the highest priority allowed for the user's tier
+func (h *Handler) requestPriority(user *core.Record, requested, fallback string) (string, error) {
+	if requested == "" {
+		requested = fallback
+	}
+	if !fal.ValidPriority(requested) {
+		return "", fmt.Errorf("priority must be %q or %q", fal.PriorityInteractive, fal.PriorityBatch)
+	}
+
+	limit, listed := h.cfg.TierPriorities[user.GetString("tier")]
+	if !listed {
+		limit = h.cfg.DefaultPriority
+	}
+	if limit == fal.PriorityBatch {
+		return fal.PriorityBatch, nil
+	}
+	return requested, nil
+}
+
+// imageLinks ties generated images to a comparison or sweep group, to the
+// image they were derived from or to identical seeded requests
+type imageLinks struct {
+	group    map[string]interface{} // "id" is stored in group_id, the map in other_info.group
+	parentID string                 // stored in parent_id
+	relation string                 // how the images derive from the parent
+	cacheKey string                 // stored in cache_key
+}
+
+// unsavedGenerationMessage tells the user their charged images were not kept
+const unsavedGenerationMessage = "Your images were generated and charged for but could not be saved; download them from image_urls"
+
+// unsavedGenerationError reports images FAL AI generated and charged for that
+// could not be saved. Their URLs are returned so the user can still keep them.
+type unsavedGenerationError struct {
+	requestID string
+	urls      []string
+	cost      money.Micros
+	err       error
+}
+
+func (e *unsavedGenerationError) Error() string {
+	return fmt.Sprintf("generated images could not be saved: %v", e.err)
+}
+
+func (e *unsavedGenerationError) Unwrap() error {
+	return e.err
+}
+
+// saveGeneration moderates a FAL result and persists it in one transaction:
+// the image records, the user's financial totals and the job's outcome, so a
+// failure part way cannot leave half a batch saved or the totals out of sync.
+// It returns the response entries. links may be nil for a standalone generation.
+// FAL AI has charged for the images by now, so they are saved even when the
+// caller's context is cancelled meanwhile, and when saving fails the spend is
+// still recorded before an *unsavedGenerationError is returned.
+func (h *Handler) saveGeneration(ctx context.Context, user *core.Record, falToken, environment, orgID string, req localmodels.GenerateImageRequest, result *fal.GenerationResponse, generationTime time.Duration, links *imageLinks) ([]localmodels.GeneratedImageInfo, error) {
+	ctx = context.WithoutCancel(ctx)
+
+	// Run the optional moderation stage before anything is shown or persisted,
+	// outside the transaction since it calls FAL AI
+	moderationStatuses := make([]string, len(result.Images))
 	for i, img := range result.Images {
-		// Create generated image record
-		collection, err := h.app.FindCollectionByNameOrId("images")
-		if err == nil && collection != nil {
-			imageRecord := core.NewRecord(collection)
-			imageRecord.Set("title", req.Prompt) // Use prompt as title
-			imageRecord.Set("url", img.URL)
-			imageRecord.Set("user_id", user.Id)
-			imageRecord.Set("prompt", req.Prompt)
-			imageRecord.Set("request_id", result.RequestID)
-			imageRecord.Set("model", req.Model)
-			imageRecord.Set("batch_number", float64(i+1)) // Batch number for this image
-			
-			// Set image size from parameters or default
-			imageSize := map[string]interface{}{
-				"width":  1024, // Default
-				"height": 1024, // Default
+		moderationStatuses[i] = h.moderateImage(ctx, falToken, img.URL)
+	}
+
+	// The folder was checked when the request arrived; one trashed or
+	// unshared while the images were generating is not filed into
+	if err := h.checkTargetFolder(user, orgID, req.CollectionID); err != nil {
+		h.logger.Warn("Target folder is no longer available; saving to the library root", "error", err, "folder_id", req.CollectionID, "user_id", user.Id)
+		req.CollectionID = ""
+	}
+
+	var records []*core.Record
+	err := h.app.RunInTransaction(func(txApp core.App) error {
+		collection, err := txApp.FindCollectionByNameOrId("images")
+		if err != nil {
+			return fmt.Errorf("failed to find images collection: %w", err)
+		}
+		records = h.newImageRecords(collection, user, falToken, environment, orgID, req, result, moderationStatuses, generationTime, links)
+		for _, record := range records {
+			if err := txApp.Save(record); err != nil {
+				return fmt.Errorf("failed to save image record: %w", err)
 			}
-			if req.Parameters != nil {
-				if size, exists := req.Parameters["image_size"]; exists {
-					if sizeObj, ok := size.(map[string]interface{}); ok {
-						imageSize = sizeObj
-					}
-				}
+		}
+		if err := h.updateUserFinancialData(txApp, user, environment, money.FromUSD(result.Cost), len(result.Images)); err != nil {
+			return err
+		}
+		// Shared results were recorded by the request that generated them, and
+		// private custom models are left out of the model statistics
+		if !result.Coalesced && h.customModel(user, req.Model) == nil {
+			job := modelstats.Job{Model: req.Model, Duration: generationTime, Success: true, Cost: result.Cost}
+			if err := h.modelStats.RecordIn(txApp, user.Id, job); err != nil {
+				return err
 			}
-			imageRecord.Set("image_size", imageSize)
-			
-			// Store generation info in other_info
-			otherInfo := map[string]interface{}{
-				"cost_usd":           result.Cost / float64(len(result.Images)),
-				"generation_time_ms": generationTime.Milliseconds(),
-				"parameters":         req.Parameters,
+		}
+		return nil
+	})
+	if err != nil {
+		h.logger.Error("Failed to save generation", "error", err, "user_id", user.Id, "request_id", result.RequestID)
+
+		// FAL AI charged for the images all the same
+		cost := money.FromUSD(result.Cost)
+		if spendErr := h.updateUserFinancialData(h.app, user, environment, cost, len(result.Images)); spendErr != nil {
+			h.logger.Error("Failed to record the spend of an unsaved generation", "error", spendErr, "user_id", user.Id, "request_id", result.RequestID, "cost", cost.USD())
+		}
+
+		unsaved := &unsavedGenerationError{requestID: result.RequestID, cost: cost, err: err}
+		for i, img := range result.Images {
+			// Quarantined images are withheld here as well
+			if moderationStatuses[i] != moderation.StatusQuarantined {
+				unsaved.urls = append(unsaved.urls, img.URL)
 			}
-			imageRecord.Set("other_info", otherInfo)
-			
-			// Set folder if provided (renamed from collection)
-			if req.CollectionID != "" {
-				imageRecord.Set("folder_id", req.CollectionID)
+		}
+		return nil, unsaved
+	}
+
+	imageInfos := make([]localmodels.GeneratedImageInfo, 0, len(result.Images))
+	for i, img := range result.Images {
+		// Download and content-hash the file in the background
+		h.imageCache.Enqueue(records[i].Id)
+		// Caption it for prompt searches when captioning is enabled
+		h.captions.Enqueue(falToken, records[i].Id)
+
+		imageInfos = append(imageInfos, h.withVariants(withTimestamps(moderatedImageInfo(records[i].Id, img.URL, img.ThumbnailURL, moderationStatuses[i]), records[i])))
+	}
+
+	return imageInfos, nil
+}
+
+// newImageRecords builds the unsaved image records of a FAL result
+func (h *Handler) newImageRecords(collection *core.Collection, user *core.Record, falToken, environment, orgID string, req localmodels.GenerateImageRequest, result *fal.GenerationResponse, moderationStatuses []string, generationTime time.Duration, links *imageLinks) []*core.Record {
+	// Each image carries its share of the cost, the shares summing exactly to it
+	costs := money.FromUSD(result.Cost).Split(len(result.Images))
+
+	records := make([]*core.Record, 0, len(result.Images))
+	for i, img := range result.Images {
+		imageRecord := core.NewRecord(collection)
+		imageRecord.Set("title", req.Prompt) // Use prompt as title
+		imageRecord.Set("url", img.URL)
+		imageRecord.Set("user_id", user.Id)
+		if orgID != "" {
+			imageRecord.Set("org_id", orgID)
+		}
+		imageRecord.Set("prompt", req.Prompt)
+		imageRecord.Set("request_id", result.RequestID)
+		imageRecord.Set("model", req.Model)
+		imageRecord.Set("batch_number", float64(i+1)) // Batch number for this image
+
+		// Set image size from parameters or default
+		imageSize := map[string]interface{}{
+			"width":  1024, // Default
+			"height": 1024, // Default
+		}
+		if req.Parameters != nil {
+			if size, exists := req.Parameters["image_size"]; exists {
+				if sizeObj, ok := size.(map[string]interface{}); ok {
+					imageSize = sizeObj
+				}
 			}
+		}
+		imageRecord.Set("image_size", imageSize)
 
-			if err := h.app.Save(imageRecord); err != nil {
-				// Log error but don't fail the request
-				h.app.Logger().Error("Failed to save image record", "error", err)
+		// Store generation info in other_info
+		otherInfo := map[string]interface{}{
+			"cost_usd":           costs[i].USD(),
+			"cost_micros":        costs[i],
+			"generation_time_ms": generationTime.Milliseconds(),
+			"parameters":         req.Parameters,
+			"key_id":             keystats.KeyID(falToken),
+			"key_hint":           keystats.KeyHint(falToken),
+		}
+		if result.Partial() {
+			otherInfo["image_statuses"] = result.ImageStatuses
+		}
+		if result.Seed != 0 {
+			otherInfo["seed"] = result.Seed
+		}
+		if environment == localmodels.EnvironmentTesting {
+			otherInfo["environment"] = environment
+		}
+		if links != nil && links.group != nil {
+			otherInfo["group"] = links.group
+			imageRecord.Set("group_id", links.group["id"])
+		}
+		if links != nil && links.parentID != "" {
+			otherInfo["lineage"] = map[string]interface{}{
+				"parent_id": links.parentID,
+				"relation":  links.relation,
 			}
+			imageRecord.Set("parent_id", links.parentID)
+		}
+		imageRecord.Set("other_info", otherInfo)
 
-			imageInfos = append(imageInfos, localmodels.GeneratedImageInfo{
-				ID:           imageRecord.Id,
-				URL:          img.URL,
-				ThumbnailURL: img.ThumbnailURL,
-			})
-		} else {
-			// Fallback if collection doesn't exist
-			imageInfos = append(imageInfos, localmodels.GeneratedImageInfo{
-				ID:           result.RequestID + "_" + string(rune(i)),
-				URL:          img.URL,
-				ThumbnailURL: img.ThumbnailURL,
-			})
-		}
-	}
-
-	// Update user financial data
-	h.updateUserFinancialData(user, result.Cost, len(result.Images))
-
-	h.app.Logger().Info("Image generated successfully", 
-		"user_id", user.Id,
-		"model", req.Model,
-		"cost", result.Cost,
-		"generation_time", generationTime.String(),
-	)
+		// Set folder if provided (renamed from collection)
+		if req.CollectionID != "" {
+			imageRecord.Set("folder_id", req.CollectionID)
+		}
 
-	resp := localmodels.GenerateImageResponse{
-		Images: imageInfos,
-		Cost:   result.Cost,
-		Model:  req.Model,
-	}
+		if moderationStatuses[i] != "" {
+			imageRecord.Set("moderation_status", moderationStatuses[i])
+		}
+		if links != nil && links.cacheKey != "" {
+			imageRecord.Set(resultcache.Field, links.cacheKey)
+		}
 
-	return e.JSON(http.StatusOK, resp)
+		records = append(records, imageRecord)
+	}
+	return records
 }
 
 // GetModels handles GET /api/custom/generate/models
+// The list includes the user's custom models and the latest availability
+// probe of each built-in model. It carries an ETag, so pollers sending
+// If-None-Match get a 304 until it changes.
 func (h *Handler) GetModels(e *core.RequestEvent) error {
 	// Verify authentication
-	_, err := h.getAuthenticatedUser(e)
+	user, err := h.getAuthenticatedUser(e)
 	if err != nil {
 		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
 	}
 
-	models := h.falClient.GetModels()
-	return e.JSON(http.StatusOK, models)
-}
\ No newline at end of file
+	models := h.modelsFor(user)
+	for id, model := range models {
+		if status, probed := h.availability.Status(id); probed {
+			model.Availability = &status
+			models[id] = model
+		}
+	}
+	return h.jsonWithETag(e, http.StatusOK, models)
+}
+
+// GetModelStats handles GET /api/custom/stats/models
+// It reports average and p95 generation time and the failure rate of each
+// model over the last ?days= days (default 7, at most 90), across all users
+func (h *Handler) GetModelStats(e *core.RequestEvent) error {
+	if _, err := h.getAuthenticatedUser(e); err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	days := modelstats.DefaultWindowDays
+	if raw := e.Request.URL.Query().Get("days"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > modelstats.MaxWindowDays {
+			return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, fmt.Sprintf("days must be between 1 and %d", modelstats.MaxWindowDays))
+		}
+		days = parsed
+	}
+
+	stats, err := h.modelStats.Stats(time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		h.logger.Error("Failed to compute model statistics", "error", err)
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to compute model statistics")
+	}
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"days":   days,
+		"models": stats,
+	})
+}
+
+// CheckPrompt handles POST /api/custom/content-filter/check
+// It evaluates a prompt without generating anything and explains any rejection
+func (h *Handler) CheckPrompt(e *core.RequestEvent) error {
+	if _, err := h.getAuthenticatedUser(e); err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.CheckPromptRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	decision := h.filter.Evaluate(req.Prompt)
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"allowed":    decision.Allowed,
+		"reason":     decision.Reason(),
+		"strictness": decision.Strictness,
+		"matches":    decision.Matches,
+		"allowed_by": decision.AllowedBy,
+	})
+}
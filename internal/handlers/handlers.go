@@ -1,32 +1,317 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"generatio-pb/internal/activity"
+	"generatio-pb/internal/anomaly"
+	"generatio-pb/internal/apikeys"
+	"generatio-pb/internal/audit"
 	"generatio-pb/internal/auth"
+	"generatio-pb/internal/availability"
+	"generatio-pb/internal/batches"
+	"generatio-pb/internal/budget"
+	"generatio-pb/internal/captions"
+	"generatio-pb/internal/cdn"
+	"generatio-pb/internal/community"
+	"generatio-pb/internal/config"
+	"generatio-pb/internal/contentfilter"
 	"generatio-pb/internal/crypto"
+	"generatio-pb/internal/currency"
+	"generatio-pb/internal/custommodels"
+	"generatio-pb/internal/dedupe"
+	"generatio-pb/internal/devices"
+	"generatio-pb/internal/embeddings"
+	"generatio-pb/internal/errorlog"
 	"generatio-pb/internal/fal"
+	"generatio-pb/internal/feeds"
+	"generatio-pb/internal/folderacl"
+	"generatio-pb/internal/hooks"
+	"generatio-pb/internal/imagecache"
+	"generatio-pb/internal/invites"
+	"generatio-pb/internal/jobs"
+	"generatio-pb/internal/keyhealth"
 	localmodels "generatio-pb/internal/models"
-	"time"
+	"generatio-pb/internal/modelstats"
+	"generatio-pb/internal/moderation"
+	"generatio-pb/internal/money"
+	"generatio-pb/internal/notify"
+	"generatio-pb/internal/orgs"
+	"generatio-pb/internal/pipelines"
+	"generatio-pb/internal/ratelimit"
+	"generatio-pb/internal/reconcile"
+	"generatio-pb/internal/recordjson"
+	"generatio-pb/internal/redact"
+	"generatio-pb/internal/repository"
+	"generatio-pb/internal/resultcache"
+	"generatio-pb/internal/retention"
+	"generatio-pb/internal/share"
+	"generatio-pb/internal/smartfolders"
+	"generatio-pb/internal/trash"
 
-	"github.com/pocketbase/pocketbase"
 	"github.com/pocketbase/pocketbase/core"
 )
 
 // Handler provides all API endpoints for Generatio
 type Handler struct {
-	app          *pocketbase.PocketBase
+	app          core.App
+	logger       *slog.Logger // the app logger, sanitized so secrets are masked
 	sessionStore *auth.SessionStore
 	encService   *crypto.EncryptionService
 	falClient    fal.FALClient
+	cfg          *config.Config
+	maintenance  *MaintenanceMode
+	notifier     *notify.Service
+	moderator    moderation.Classifier
+	filter       *contentfilter.Filter
+	retention    *retention.Service
+	trash        *trash.Service
+	imageCache   *imagecache.Cache
+	shareSigner  *share.Signer
+	apiKeys      *apikeys.Store
+	devices      *devices.Store
+	orgs         *orgs.Service
+	folders      *folderacl.Service
+	folderRepo   foldersRepo
+	imageRepo    imagesRepo
+	prefRepo     preferencesRepo
+	userRepo     usersRepo
+	smartFolders *smartfolders.Store
+	activity     *activity.Feed
+	invites      *invites.Service
+	community    *community.Library
+	pipelines    *pipelines.Service
+	batches      *batches.Store
+	hooks        *hooks.Service
+	feeds        *feeds.Store
+	embeddings   *embeddings.Indexer // nil when similarity search is disabled
+	captions     *captions.Service
+
+	pipelineTemplates *pipelines.TemplateStore
+	customModels      *custommodels.Registry
+	availability      *availability.Monitor
+	modelStats        *modelstats.Recorder
+	reconciler        *reconcile.Service
+	currencies        *currency.Converter
+	anomalies         *anomaly.Detector
+	keyHealth         *keyhealth.Monitor
+	budgets           *budget.Service
+	auditLog          *audit.Log
+	rateLimiter       *ratelimit.Limiter
+	duplicates        *dedupe.Detector
+	coalescer         *dedupe.Coalescer
+	resultCache       *resultcache.Store
+	generationJobs    *jobs.Store
+	recentErrors      *errorlog.Log
+	verifications     *auth.VerificationCache
+	requestLog        *slog.Logger // nil logs requests to logger
+
+	// cryptoBenchmark lets one crypto benchmark run at a time
+	cryptoBenchmark sync.Mutex
 }
 
 // NewHandler creates a new handler instance
-func NewHandler(app *pocketbase.PocketBase, sessionStore *auth.SessionStore, encService *crypto.EncryptionService, falClient fal.FALClient) *Handler {
-	return &Handler{
+func NewHandler(app core.App, sessionStore *auth.SessionStore, encService *crypto.EncryptionService, falClient fal.FALClient, cfg *config.Config) *Handler {
+	h := &Handler{
 		app:          app,
+		logger:       redact.Logger(app.Logger()),
 		sessionStore: sessionStore,
 		encService:   encService,
 		falClient:    falClient,
+		cfg:          cfg,
+		maintenance:  NewMaintenanceMode(),
+		notifier:     notify.NewService(app),
+		filter:       contentfilter.NewFilter(app, cfg.ContentFilterStrictness),
+		retention:    retention.NewService(app, cfg.RetentionDays, cfg.RetentionAction, time.Hour),
+		trash:        trash.NewService(app, cfg.TrashDays, time.Hour),
+		imageCache:   imagecache.NewCache(app, cfg.ImageCacheDir),
+		apiKeys:      apikeys.NewStore(app),
+		devices:      devices.NewStore(app, encService),
+		orgs:         orgs.NewService(app),
+		folderRepo:   repository.NewFolders(app),
+		imageRepo:    repository.NewImages(app),
+		prefRepo:     repository.NewPreferences(app),
+		userRepo:     repository.NewUsers(app),
+		smartFolders: smartfolders.NewStore(app),
+		activity:     activity.NewFeed(app),
+		community:    community.NewLibrary(app),
+		pipelines:    pipelines.NewService(app),
+		batches:      batches.NewStore(app),
+		feeds:        feeds.NewStore(app),
+		captions:     captions.NewService(app, nil),
+		customModels: custommodels.NewRegistry(app),
+		availability: availability.NewMonitor(falClient, cfg.ModelProbeInterval),
+		modelStats:   modelstats.NewRecorder(app),
+		reconciler:   reconcile.NewService(app, cfg.ReconcileInterval, cfg.ReconcileAutoFix),
+	}
+
+	h.folders = folderacl.NewService(app, h.orgs)
+	h.budgets = budget.NewService(app)
+	h.rateLimiter = ratelimit.NewLimiter(cfg.GenerationRateLimit, cfg.GenerationRateWindow)
+	h.duplicates = dedupe.NewDetector(cfg.DuplicateWindow)
+	h.coalescer = dedupe.NewCoalescer(cfg.CoalesceSeeded)
+	h.resultCache = resultcache.NewStore(app, cfg.ResultCacheTTL)
+	h.generationJobs = jobs.NewStore(cfg.GenerationJobTTL)
+	h.recentErrors = errorlog.NewLog(cfg.RecentErrors)
+	h.verifications = auth.NewVerificationCache(cfg.VerificationCacheTTL)
+	h.auditLog = audit.NewLog(app)
+	h.anomalies = anomaly.NewDetector(app, h.notifier, cfg.AnomalyFactor, cfg.AnomalyMinSpend, cfg.AnomalyInterval)
+	h.keyHealth = keyhealth.NewMonitor(app, sessionStore, falClient, h.notifier, cfg.KeyHealthInterval)
+	h.invites = invites.NewService(app, h.orgs, h.folders)
+	h.hooks = hooks.NewService(app, h.notifier)
+	h.pipelineTemplates = pipelines.NewTemplateStore(app, h.orgs)
+	h.imageCache.SetVariants(cfg.ThumbnailSizes, cfg.ThumbnailFormats)
+	h.imageCache.SetAllowPrivateHosts(cfg.AllowPrivateImageURLs)
+	h.pipelines.SetExecutor(&pipelineExecutor{h: h})
+	h.pipelines.SetFinishHook(h.notifyRunFinished)
+
+	signer, persistent := share.NewSigner(cfg.ShareSecret)
+	if !persistent {
+		h.logger.Warn("GENERATIO_SHARE_SECRET is not set; share links will stop working after a restart")
+	}
+	h.shareSigner = signer
+
+	// Display currencies convert from USD with live rates when a URL is set, otherwise fixed ones
+	var rates currency.Provider
+	switch {
+	case cfg.ExchangeRatesURL != "":
+		rates = &currency.HTTPProvider{URL: cfg.ExchangeRatesURL}
+	case len(cfg.ExchangeRates) > 0:
+		rates = currency.StaticProvider(cfg.ExchangeRates)
+	}
+	h.currencies = currency.NewConverter(rates)
+
+	if cfg.ModerationProvider == "fal" {
+		h.moderator = moderation.NewFALClassifier("", cfg.ModerationThreshold)
+	}
+
+	if cfg.EmbeddingProvider == "fal" {
+		if cfg.EmbeddingEndpoint == "" {
+			h.logger.Warn("GENERATIO_EMBEDDING_ENDPOINT is not set; similarity search is disabled")
+		} else {
+			h.SetEmbedder(embeddings.NewFALEmbedder(cfg.EmbeddingEndpoint))
+		}
+	}
+
+	if cfg.CaptionProvider == "fal" {
+		h.captions.SetCaptioner(captions.NewFALCaptioner(cfg.CaptionEndpoint))
+	}
+
+	// Cached share links and feed images are purged from the CDN when their image changes
+	purger, err := cdn.NewPurger(cfg.CDNProvider, cfg.CDNZone, cfg.CDNToken)
+	if err != nil {
+		h.logger.Warn("CDN purging is disabled", "error", err)
+	} else if purger != nil {
+		cdn.NewService(app, h.notifier, cfg.CDNProvider, purger)
+	}
+
+	return h
+}
+
+// Maintenance returns the runtime maintenance mode toggle
+func (h *Handler) Maintenance() *MaintenanceMode {
+	return h.maintenance
+}
+
+// Notifier returns the outbound notification service
+func (h *Handler) Notifier() *notify.Service {
+	return h.notifier
+}
+
+// ContentFilter returns the prompt content policy
+func (h *Handler) ContentFilter() *contentfilter.Filter {
+	return h.filter
+}
+
+// Reconciler returns the cost reconciliation service
+func (h *Handler) Reconciler() *reconcile.Service {
+	return h.reconciler
+}
+
+// Anomalies returns the spending anomaly detector
+func (h *Handler) Anomalies() *anomaly.Detector {
+	return h.anomalies
+}
+
+// KeyHealth returns the FAL AI key health monitor
+func (h *Handler) KeyHealth() *keyhealth.Monitor {
+	return h.keyHealth
+}
+
+// RateLimiter returns the generation rate limiter
+func (h *Handler) RateLimiter() *ratelimit.Limiter {
+	return h.rateLimiter
+}
+
+// Duplicates returns the duplicate generation detector
+func (h *Handler) Duplicates() *dedupe.Detector {
+	return h.duplicates
+}
+
+// Coalescer returns the tracker of seeded generations in flight
+func (h *Handler) Coalescer() *dedupe.Coalescer {
+	return h.coalescer
+}
+
+// Verifications returns the cache of keys derived while checking passwords
+func (h *Handler) Verifications() *auth.VerificationCache {
+	return h.verifications
+}
+
+// RecentErrors returns the per-user log of recent FAL AI errors
+func (h *Handler) RecentErrors() *errorlog.Log {
+	return h.recentErrors
+}
+
+// Availability returns the model availability monitor
+func (h *Handler) Availability() *availability.Monitor {
+	return h.availability
+}
+
+// Retention returns the image retention service
+func (h *Handler) Retention() *retention.Service {
+	return h.retention
+}
+
+// Trash returns the service that purges deleted images
+func (h *Handler) Trash() *trash.Service {
+	return h.trash
+}
+
+// Pipelines returns the multi-step pipeline runner
+func (h *Handler) Pipelines() *pipelines.Service {
+	return h.pipelines
+}
+
+// SetModerator replaces the post-generation image classifier (nil disables moderation)
+func (h *Handler) SetModerator(classifier moderation.Classifier) {
+	h.moderator = classifier
+}
+
+// SetEmbedder enables similarity search with an image and text embedder
+func (h *Handler) SetEmbedder(embedder embeddings.Embedder) {
+	h.embeddings = embeddings.NewIndexer(h.app, h.sessionStore, embeddings.NewStore(h.app), embedder, h.cfg.EmbeddingInterval)
+}
+
+// SetCaptioner replaces the image captioning model (nil disables captioning)
+func (h *Handler) SetCaptioner(captioner captions.Captioner) {
+	h.captions.SetCaptioner(captioner)
+}
+
+// SetRequestLogger replaces the logger LogRequests writes to, wrapped so
+// secrets are masked (nil uses the app logger)
+func (h *Handler) SetRequestLogger(logger *slog.Logger) {
+	if logger == nil {
+		h.requestLog = nil
+		return
 	}
+	h.requestLog = redact.Logger(logger)
 }
 
 // Helper methods
@@ -40,16 +325,21 @@ func (h *Handler) getAuthenticatedUser(e *core.RequestEvent) (*core.Record, erro
 	return authRecord, nil
 }
 
-// getAuthenticatedUserAndSession extracts user and validates session
+// environmentHeader picks the FAL key slot of a single request
+const environmentHeader = "X-FAL-Environment"
+
+// getAuthenticatedUserAndSession extracts user and validates session. The
+// returned session carries the FAL key of the slot chosen by the
+// X-FAL-Environment header, or of the session's default slot.
 func (h *Handler) getAuthenticatedUserAndSession(e *core.RequestEvent) (*core.Record, *localmodels.Session, error) {
 	user, err := h.getAuthenticatedUser(e)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	sessionID := e.Request.Header.Get("X-Session-ID")
+	sessionID := h.requestSessionID(e)
 	if sessionID == "" {
-		return nil, nil, &localmodels.APIError{Code: localmodels.ErrCodeAuth, Message: "Session ID required in X-Session-ID header"}
+		return nil, nil, &localmodels.APIError{Code: localmodels.ErrCodeAuth, Message: "Session ID required in X-Session-ID header or session cookie"}
 	}
 
 	session, err := h.sessionStore.Get(sessionID)
@@ -61,133 +351,454 @@ func (h *Handler) getAuthenticatedUserAndSession(e *core.RequestEvent) (*core.Re
 		return nil, nil, &localmodels.APIError{Code: localmodels.ErrCodeAuthorization, Message: "Session does not belong to authenticated user"}
 	}
 
-	return user, session, nil
+	selected, err := session.ForEnvironment(e.Request.Header.Get(environmentHeader))
+	if err != nil {
+		return nil, nil, &localmodels.APIError{Code: localmodels.ErrCodeValidation, Message: err.Error()}
+	}
+
+	h.sessionStore.Touch(sessionID)
+	return user, selected, nil
+}
+
+// sessionErrorResponse reports why getAuthenticatedUserAndSession failed
+func (h *Handler) sessionErrorResponse(e *core.RequestEvent, err error) error {
+	var apiErr *localmodels.APIError
+	if errors.As(err, &apiErr) && apiErr.Code == localmodels.ErrCodeValidation {
+		return h.errorResponse(e, http.StatusBadRequest, apiErr.Code, apiErr.Message)
+	}
+	return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Valid session required")
+}
+
+// requireSuperuser ensures the request is authenticated as a PocketBase superuser
+func (h *Handler) requireSuperuser(e *core.RequestEvent) error {
+	if !e.HasSuperuserAuth() {
+		return &localmodels.APIError{Code: localmodels.ErrCodeAuthorization, Message: "Superuser access required"}
+	}
+	return nil
 }
 
 // errorResponse sends a standardized error response
 func (h *Handler) errorResponse(e *core.RequestEvent, status int, code, message string) error {
-	apiErr := localmodels.APIError{
+	return h.apiErrorResponse(e, status, localmodels.APIError{
 		Code:    code,
 		Message: message,
-	}
-	return e.JSON(status, apiErr)
+	})
+}
+
+// accessError describes why an organization or folder access check failed and how to respond
+type accessError struct {
+	status  int
+	code    string
+	message string
+}
+
+var (
+	errAuthRequired   = &accessError{http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required"}
+	errFolderNotFound = &accessError{http.StatusNotFound, localmodels.ErrCodeNotFound, "Folder not found"}
+	errFolderOwner    = &accessError{http.StatusForbidden, localmodels.ErrCodeAuthorization, "Only the folder owner can do this"}
+)
+
+// accessErrorResponse sends the response for a failed access check
+func (h *Handler) accessErrorResponse(e *core.RequestEvent, err *accessError) error {
+	return h.errorResponse(e, err.status, err.code, err.message)
 }
 
-// updateUserFinancialData updates user's financial tracking data
-func (h *Handler) updateUserFinancialData(user *core.Record, cost float64, imageCount int) {
-	financialDataRaw := user.Get("financial_data")
+// userFinancialData reads a user's financial tracking data. Totals recorded
+// before micro-dollar accounting only have total_spent in USD.
+func userFinancialData(user *core.Record) localmodels.FinancialData {
 	var financialData localmodels.FinancialData
-	
-	if financialDataRaw != nil {
-		if data, ok := financialDataRaw.(map[string]interface{}); ok {
-			if totalSpent, ok := data["total_spent"].(float64); ok {
-				financialData.TotalSpent = totalSpent
-			}
-			if totalImages, ok := data["total_images"].(float64); ok {
-				financialData.TotalImages = int(totalImages)
-			}
-		}
+	recordjson.Decode(user, "financial_data", &financialData)
+	if financialData.TotalSpentMicros == 0 {
+		financialData.TotalSpentMicros = money.FromUSD(financialData.TotalSpent)
+	}
+	financialData.TotalSpent = financialData.TotalSpentMicros.USD()
+	return financialData
+}
+
+// updateUserFinancialData adds a generation to user's financial tracking
+// data through app, usually a transaction. The totals are read afresh so
+// concurrent generations are not lost. Generations paid with the testing key
+// are left out.
+func (h *Handler) updateUserFinancialData(app core.App, user *core.Record, environment string, cost money.Micros, imageCount int) error {
+	if environment == localmodels.EnvironmentTesting {
+		return nil
 	}
 
-	// Update with new spending
-	financialData.TotalSpent += cost
+	stored, err := app.FindRecordById(user.Collection(), user.Id)
+	if err != nil {
+		return fmt.Errorf("failed to load financial data: %w", err)
+	}
+	financialData := userFinancialData(stored)
+
+	// Update with new spending; the USD total is derived so it cannot drift
+	financialData.TotalSpentMicros += cost
 	financialData.TotalImages += imageCount
 
-	// Save back to user record
-	user.Set("financial_data", map[string]interface{}{
-		"total_spent":  financialData.TotalSpent,
-		"total_images": financialData.TotalImages,
-	})
+	// Other keys, such as the salt, are kept
+	financialData.TotalSpent = financialData.TotalSpentMicros.USD()
+	if err := recordjson.Merge(stored, "financial_data", financialData); err != nil {
+		return err
+	}
 
-	// Save user record (ignore errors for financial data updates)
-	h.app.Save(user)
+	if err := app.Save(stored); err != nil {
+		return fmt.Errorf("failed to save financial data: %w", err)
+	}
+	user.Set("financial_data", stored.Get("financial_data"))
+	return nil
 }
 
 // calculateRecentSpending calculates spending in the last N days
-func (h *Handler) calculateRecentSpending(userID string, days int) (float64, error) {
+func (h *Handler) calculateRecentSpending(userID string, days int) (money.Micros, error) {
 	// Calculate date threshold
 	threshold := time.Now().AddDate(0, 0, -days)
-	
-	records, err := h.app.FindRecordsByFilter(
-		"images",
-		"user_id = {:user_id} && created >= {:threshold} && deleted_at = null",
-		"",
-		-1,
-		0,
-		map[string]any{
-			"user_id":   userID,
-			"threshold": threshold.Format("2006-01-02 15:04:05"),
-		},
-	)
+
+	records, err := h.imageRepo.CreatedSince(userID, threshold)
 
 	if err != nil {
 		return 0, err
 	}
 
-	var total float64
+	var total money.Micros
 	for _, record := range records {
 		// Cost is stored in other_info JSON field
-		if otherInfo := record.Get("other_info"); otherInfo != nil {
-			if data, ok := otherInfo.(map[string]interface{}); ok {
-				if cost, exists := data["cost_usd"]; exists {
-					if costFloat, ok := cost.(float64); ok {
-						total += costFloat
-					}
-				}
-			}
+		var info struct {
+			money.ImageCost
+			Environment string `json:"environment"`
+		}
+		record.UnmarshalJSONField("other_info", &info)
+		// Testing generations are left out of financial stats
+		if info.Environment == localmodels.EnvironmentTesting {
+			continue
 		}
+		total += info.Amount()
 	}
 
 	return total, nil
 }
 
-// RegisterRoutes registers all the API routes
-func RegisterRoutes(se *core.ServeEvent, app *pocketbase.PocketBase, sessionStore *auth.SessionStore, encService *crypto.EncryptionService, falClient fal.FALClient) {
-	handler := NewHandler(app, sessionStore, encService, falClient)
+// RegisterRoutes registers all the API routes and returns the handler serving them
+func RegisterRoutes(se *core.ServeEvent, app core.App, sessionStore *auth.SessionStore, encService *crypto.EncryptionService, falClient fal.FALClient, cfg *config.Config) *Handler {
+	// Fake FAL mode answers every FAL AI call locally so the API can be used without a paid key
+	if cfg.FakeFAL {
+		falClient = fal.NewMockClient()
+	}
+
+	handler := NewHandler(app, sessionStore, encService, falClient, cfg)
+	if cfg.FakeFAL {
+		handler.logger.Warn("Fake FAL mode is on: generations return placeholder images and no FAL AI credit is spent")
+	}
+
+	handler.logger.Info("🔧 Registering custom API routes...")
 
-	app.Logger().Info("🔧 Registering custom API routes...")
+	// Outbound notifications, retention and trash purges, image file persistence, pipelines, model probes,
+	// cost reconciliation, spending anomaly and key health checks, and image embedding run in the background
+	// until the app terminates
+	handler.notifier.Start()
+	handler.retention.Start()
+	handler.trash.Start()
+	handler.imageCache.Start()
+	handler.captions.Start()
+	handler.pipelines.Start()
+	handler.availability.Start()
+	handler.reconciler.Start()
+	handler.anomalies.Start()
+	handler.keyHealth.Start()
+	if handler.embeddings != nil {
+		handler.embeddings.Start()
+	}
+	// Without FAL AI the server starts degraded: reads work, generation is refused
+	if cfg.StartupConnectivityCheck {
+		ctx, cancel := context.WithTimeout(context.Background(), availability.ProbeTimeout)
+		if connectivity := handler.availability.CheckConnectivity(ctx); !connectivity.Reachable {
+			handler.logger.Warn("FAL AI is unreachable; starting in degraded mode with generation disabled", "error", connectivity.Error)
+		}
+		cancel()
+	}
+
+	app.OnTerminate().BindFunc(func(te *core.TerminateEvent) error {
+		handler.notifier.Stop()
+		handler.retention.Stop()
+		handler.trash.Stop()
+		handler.imageCache.Stop()
+		handler.captions.Stop()
+		handler.pipelines.Stop()
+		handler.availability.Stop()
+		handler.reconciler.Stop()
+		handler.anomalies.Stop()
+		handler.keyHealth.Stop()
+		if handler.embeddings != nil {
+			handler.embeddings.Stop()
+		}
+		return te.Next()
+	})
+
+	// With LogRequests, custom requests and responses are logged at debug level with secrets redacted
+	se.Router.BindFunc(handler.logRequests)
+
+	// Browsers using the session cookie must echo the CSRF token on state-changing requests
+	se.Router.BindFunc(handler.requireCSRF)
 
 	// Token management
 	se.Router.POST("/api/custom/tokens/setup", handler.TokenSetup)
 	se.Router.POST("/api/custom/tokens/verify", handler.TokenVerify)
-	app.Logger().Info("  ✓ Token management routes registered")
+	// An optional testing key sits next to the production key; sessions and
+	// single requests (X-FAL-Environment) pick which one pays
+	se.Router.DELETE("/api/custom/tokens/testing", handler.DeleteTestingToken)
+	handler.logger.Info("  ✓ Token management routes registered")
 
 	// Session management
 	se.Router.POST("/api/custom/auth/create-session", handler.CreateSession)
+	se.Router.GET("/api/custom/auth/session", handler.GetSessionInfo)
 	se.Router.DELETE("/api/custom/auth/session", handler.DeleteSession)
+
+	// Remembered devices: refresh tokens recreate sessions without the password
+	se.Router.POST("/api/custom/auth/refresh", handler.RefreshSession)
+	se.Router.GET("/api/custom/auth/devices", handler.ListDevices)
+	se.Router.PUT("/api/custom/auth/devices/settings", handler.SetRememberDevice)
+	se.Router.DELETE("/api/custom/auth/devices/{id}", handler.RevokeDevice)
 	se.Router.GET("/api/custom/auth/token-status", handler.TokenStatus)
-	app.Logger().Info("  ✓ Session management routes registered")
+	// Opt-in background validation of the FAL AI key held by the user's session
+	se.Router.GET("/api/custom/auth/key-health", handler.GetKeyHealth)
+	se.Router.PUT("/api/custom/auth/key-health/settings", handler.SetKeyHealthChecks)
+	handler.logger.Info("  ✓ Session management routes registered")
+
+	// Image generation; with RenewSessionOnGeneration, successful generations renew the session.
+	// dry_run requests stop before FAL AI and return the request that would be submitted.
+	// A repeat of the same request within DuplicateWindow is coalesced or warned about per DuplicateAction.
+	// One that outlasts its max_wait is answered with 202 and a job polled at generate/jobs/{id}.
+	se.Router.POST("/api/custom/generate/image", handler.GenerateImage).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite), handler.requireGenerationAvailable, handler.requireRateLimit, handler.renewSession)
+	se.Router.GET("/api/custom/generate/jobs/{id}", handler.GetGenerationJob).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite))
+	se.Router.GET("/api/custom/generate/models", handler.GetModels).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite))
+	se.Router.POST("/api/custom/content-filter/check", handler.CheckPrompt).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite))
+	se.Router.POST("/api/custom/generate/compare", handler.GenerateComparison).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite), handler.requireGenerationAvailable, handler.requireRateLimit, handler.renewSession)
+	se.Router.GET("/api/custom/generate/compare/{id}", handler.GetComparison).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	se.Router.POST("/api/custom/generate/sweep", handler.GenerateSweep).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite), handler.requireGenerationAvailable, handler.requireRateLimit, handler.renewSession)
+	handler.logger.Info("  ✓ Image generation routes registered")
+	handler.logger.Info("    - POST /api/custom/generate/image")
+	handler.logger.Info("    - GET /api/custom/generate/jobs/{id}")
+	handler.logger.Info("    - GET /api/custom/generate/models")
+	handler.logger.Info("    - POST /api/custom/content-filter/check")
+	handler.logger.Info("    - POST /api/custom/generate/compare")
+	handler.logger.Info("    - POST /api/custom/generate/sweep")
+
+	// Failed generations are kept with their error code; a retry submits the same request again
+	se.Router.GET("/api/custom/generate/failures", handler.ListFailedGenerations).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	se.Router.POST("/api/custom/generate/failures/{id}/retry", handler.RetryFailedGeneration).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite), handler.requireGenerationAvailable, handler.requireRateLimit, handler.renewSession)
+	handler.logger.Info("  ✓ Failed generation routes registered")
 
-	// Image generation
-	se.Router.POST("/api/custom/generate/image", handler.GenerateImage)
-	se.Router.GET("/api/custom/generate/models", handler.GetModels)
-	app.Logger().Info("  ✓ Image generation routes registered")
-	app.Logger().Info("    - POST /api/custom/generate/image")
-	app.Logger().Info("    - GET /api/custom/generate/models")
+	// Per-model generation statistics from recorded jobs
+	se.Router.GET("/api/custom/stats/models", handler.GetModelStats).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite))
+	handler.logger.Info("  ✓ Model statistics routes registered")
+
+	// Rate limit, budget and quota standing; generation routes report rate limits in X-RateLimit-* headers
+	se.Router.GET("/api/custom/limits", handler.GetLimits).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite))
+	// The caller's recent FAL AI errors, for diagnosing failed generations
+	se.Router.GET("/api/custom/debug/errors", handler.GetRecentErrors).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite))
+	se.Router.DELETE("/api/custom/debug/errors", handler.ClearRecentErrors).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite))
+	handler.logger.Info("  ✓ Limits route registered")
+
+	// Personal model registry; registered endpoints are selectable as custom/<name>
+	// and aliases name a model together with default parameters
+	se.Router.GET("/api/custom/models", handler.ListCustomModels).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite))
+	se.Router.POST("/api/custom/models", handler.RegisterCustomModel)
+	se.Router.GET("/api/custom/models/{id}", handler.GetCustomModel).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite))
+	se.Router.PUT("/api/custom/models/{id}", handler.UpdateCustomModel)
+	se.Router.DELETE("/api/custom/models/{id}", handler.DeleteCustomModel)
+	se.Router.GET("/api/custom/models/aliases", handler.ListModelAliases).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite))
+	se.Router.PUT("/api/custom/models/aliases/{name}", handler.SaveModelAlias)
+	se.Router.DELETE("/api/custom/models/aliases/{name}", handler.DeleteModelAlias)
+	handler.logger.Info("  ✓ Custom model routes registered")
+
+	// Pipelines (generate, upscale, remove background, save to folder) run as background jobs
+	se.Router.POST("/api/custom/pipelines", handler.CreatePipeline).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite), handler.requireGenerationAvailable, handler.requireRateLimit, handler.renewSession)
+	se.Router.GET("/api/custom/pipelines", handler.ListPipelines).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	se.Router.GET("/api/custom/pipelines/{id}", handler.GetPipeline).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	se.Router.POST("/api/custom/pipelines/{id}/cancel", handler.CancelPipeline).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite))
+	// CSV imports queue one run per valid row and keep a report of the rejected rows
+	se.Router.POST("/api/custom/batches/import", handler.ImportBatch).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite), handler.requireGenerationAvailable, handler.requireRateLimit, handler.renewSession)
+	se.Router.GET("/api/custom/batches/{id}", handler.GetBatch).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	se.Router.GET("/api/custom/batches/{id}/errors", handler.GetBatchErrors).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	se.Router.GET("/api/custom/pipelines/templates", handler.ListPipelineTemplates)
+	se.Router.POST("/api/custom/pipelines/templates", handler.CreatePipelineTemplate)
+	se.Router.GET("/api/custom/pipelines/templates/{id}", handler.GetPipelineTemplate)
+	se.Router.PUT("/api/custom/pipelines/templates/{id}", handler.UpdatePipelineTemplate)
+	se.Router.DELETE("/api/custom/pipelines/templates/{id}", handler.DeletePipelineTemplate)
+	se.Router.GET("/api/custom/pipelines/templates/{id}/versions", handler.GetPipelineTemplateVersions)
+	se.Router.POST("/api/custom/pipelines/templates/{id}/run", handler.RunPipelineTemplate).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite), handler.requireGenerationAvailable, handler.requireRateLimit, handler.renewSession)
+	handler.logger.Info("  ✓ Pipeline routes registered")
+
+	// Image management
+	se.Router.GET("/api/custom/images/quarantine", handler.GetQuarantinedImages)
+	// Deleted images stay in the trash, restorable, until GENERATIO_TRASH_DAYS pass
+	se.Router.DELETE("/api/custom/images/{id}", handler.DeleteImage)
+	se.Router.GET("/api/custom/images/trash", handler.GetTrash).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	se.Router.DELETE("/api/custom/images/trash", handler.EmptyTrash)
+	se.Router.POST("/api/custom/images/{id}/restore", handler.RestoreImage)
+	se.Router.POST("/api/custom/images/{id}/override", handler.OverrideModeration)
+	// New images are captioned in the background when a captioning model is configured
+	se.Router.POST("/api/custom/images/{id}/caption", handler.CaptionImage)
+	se.Router.GET("/api/custom/images/{id}/file", handler.ServeImageFile).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	se.Router.POST("/api/custom/images/import", handler.ImportImages)
+	// Prompts, seeds and parameters as a JSONL dataset for fine-tuning or analysis
+	se.Router.GET("/api/custom/images/export", handler.ExportPrompts).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	// Similarity search over CLIP embeddings of the user's persisted images
+	se.Router.GET("/api/custom/images/search", handler.SearchImages).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	se.Router.GET("/api/custom/images/{id}/similar", handler.GetSimilarImages).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	se.Router.POST("/api/custom/images/{id}/share", handler.CreateShareLink)
+	se.Router.POST("/api/custom/images/{id}/edit", handler.EditImagePrompt).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite), handler.requireGenerationAvailable, handler.requireRateLimit, handler.renewSession)
+	se.Router.POST("/api/custom/images/{id}/regenerate", handler.RegenerateImage).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite), handler.requireGenerationAvailable, handler.requireRateLimit, handler.renewSession)
+	se.Router.POST("/api/custom/images/{id}/variation", handler.CreateVariation).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite), handler.requireGenerationAvailable, handler.requireRateLimit, handler.renewSession)
+	se.Router.POST("/api/custom/images/{id}/outpaint", handler.OutpaintImage).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite), handler.requireGenerationAvailable, handler.requireRateLimit, handler.renewSession)
+	se.Router.GET("/api/custom/images/{id}/lineage", handler.GetImageLineage).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	se.Router.GET("/api/custom/shared/{id}", handler.ServeSharedImage)
+	// The same signed link as an HTML page with OpenGraph tags, so it unfurls in chat and social apps
+	se.Router.GET("/api/custom/shared/pages/{id}", handler.ServeSharePage)
+	// RSS/Atom feeds of a user's gallery or a folder, readable by anyone with the feed URL
+	se.Router.GET("/api/custom/feeds", handler.ListFeeds)
+	se.Router.POST("/api/custom/feeds", handler.CreateFeed)
+	se.Router.DELETE("/api/custom/feeds/{id}", handler.DeleteFeed)
+	se.Router.GET("/api/custom/shared/feeds/{token}", handler.ServeFeed)
+	se.Router.GET("/api/custom/shared/feeds/{token}/images/{image_id}", handler.ServeFeedImage)
+	// Compact JSON of a feed's images for galleries embedded in other sites (CORS-enabled)
+	se.Router.GET("/api/custom/shared/feeds/{token}/widget", handler.ServeFeedWidget)
+	se.Router.GET("/api/custom/retention", handler.GetRetentionPolicy)
+	se.Router.POST("/api/custom/retention", handler.SetRetentionPolicy)
+	se.Router.GET("/api/custom/retention/preview", handler.PreviewRetention)
+	handler.logger.Info("  ✓ Image management routes registered")
+
+	// API keys (user tokens only; keys cannot manage keys)
+	se.Router.GET("/api/custom/api-keys", handler.ListAPIKeys)
+	se.Router.POST("/api/custom/api-keys", handler.CreateAPIKey)
+	se.Router.DELETE("/api/custom/api-keys/{id}", handler.RevokeAPIKey)
+	handler.logger.Info("  ✓ API key routes registered")
+
+	// Organizations (send X-Org-ID to switch listing and creation to an org library)
+	se.Router.GET("/api/custom/orgs", handler.ListOrgs)
+	se.Router.POST("/api/custom/orgs", handler.CreateOrg)
+	se.Router.GET("/api/custom/orgs/{id}/members", handler.GetOrgMembers)
+	se.Router.POST("/api/custom/orgs/{id}/members", handler.AddOrgMember)
+	se.Router.DELETE("/api/custom/orgs/{id}/members/{user_id}", handler.RemoveOrgMember)
+	se.Router.GET("/api/custom/orgs/{id}/spending", handler.GetOrgSpending)
+	handler.logger.Info("  ✓ Organization routes registered")
+
+	// Invitations to folders and organizations
+	se.Router.POST("/api/custom/invitations", handler.CreateInvitation)
+	se.Router.GET("/api/custom/invitations", handler.ListInvitations)
+	se.Router.GET("/api/custom/invitations/pending", handler.GetPendingInvitations)
+	se.Router.POST("/api/custom/invitations/accept", handler.AcceptInvitation)
+	se.Router.DELETE("/api/custom/invitations/{id}", handler.RevokeInvitation)
+	handler.logger.Info("  ✓ Invitation routes registered")
+
+	// Community prompt library
+	se.Router.GET("/api/custom/community/prompts", handler.BrowsePrompts)
+	se.Router.POST("/api/custom/community/prompts", handler.PublishPrompt)
+	se.Router.GET("/api/custom/community/prompts/{id}", handler.GetPrompt)
+	se.Router.DELETE("/api/custom/community/prompts/{id}", handler.UnpublishPrompt)
+	se.Router.POST("/api/custom/community/prompts/{id}/like", handler.LikePrompt)
+	se.Router.DELETE("/api/custom/community/prompts/{id}/like", handler.LikePrompt)
+	se.Router.POST("/api/custom/community/prompts/{id}/report", handler.ReportPrompt)
+	se.Router.POST("/api/custom/community/prompts/{id}/generate", handler.GenerateFromPrompt).BindFunc(handler.requireScope(apikeys.ScopeGenerateWrite), handler.requireGenerationAvailable, handler.requireRateLimit, handler.renewSession)
+	se.Router.GET("/api/custom/community/prompts/{id}/examples/{image_id}", handler.ServePromptExample)
+	se.Router.GET("/api/custom/prompts/suggest", handler.SuggestPrompts)
+	handler.logger.Info("  ✓ Community prompt routes registered")
 
 	// Financial tracking
-	se.Router.GET("/api/custom/financial/stats", handler.GetFinancialStats)
-	app.Logger().Info("  ✓ Financial tracking routes registered")
+	se.Router.GET("/api/custom/financial/stats", handler.GetFinancialStats).BindFunc(handler.requireScope(apikeys.ScopeFinancialRead))
+	se.Router.GET("/api/custom/financial/currency", handler.GetDisplayCurrency).BindFunc(handler.requireScope(apikeys.ScopeFinancialRead))
+	se.Router.PUT("/api/custom/financial/currency", handler.SetDisplayCurrency)
+	se.Router.GET("/api/custom/financial/anomalies", handler.ListSpendingAnomalies).BindFunc(handler.requireScope(apikeys.ScopeFinancialRead))
+	// Generations and spend per FAL AI key, e.g. to split personal and client billing
+	se.Router.GET("/api/custom/financial/keys", handler.GetKeyUsage).BindFunc(handler.requireScope(apikeys.ScopeFinancialRead))
+	se.Router.PUT("/api/custom/financial/keys/{key_id}", handler.SetKeyLabel)
+	handler.logger.Info("  ✓ Financial tracking routes registered")
 
-	// User preferences
+	// User preferences; GET returns every model's preferences at once. Reads
+	// carry an ETag and answer If-None-Match with 304
+	se.Router.GET("/api/custom/preferences", handler.QueryPreferences)
 	se.Router.POST("/api/custom/preferences/get", handler.GetPreferences)
 	se.Router.POST("/api/custom/preferences/save", handler.SavePreferences)
-	app.Logger().Info("  ✓ User preferences routes registered")
+	handler.logger.Info("  ✓ User preferences routes registered")
 
 	// Collections management
 	se.Router.POST("/api/custom/collections/create", handler.CreateCollection)
-	se.Router.GET("/api/custom/collections", handler.GetCollections)
-	app.Logger().Info("  ✓ Collections management routes registered")
+	se.Router.GET("/api/custom/collections", handler.GetCollections).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	se.Router.DELETE("/api/custom/collections/{id}", handler.DeleteCollection)
+	// Moving a folder re-parents it; cycles and nesting beyond maxFolderDepth are rejected
+	se.Router.PUT("/api/custom/collections/{id}/move", handler.MoveCollection)
+	se.Router.GET("/api/custom/collections/{id}/images", handler.GetCollectionImages).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	se.Router.POST("/api/custom/collections/{id}/duplicate", handler.DuplicateCollection)
+	se.Router.PUT("/api/custom/collections/{id}/order", handler.ReorderCollectionImages)
+	// Smart collections are saved searches whose images are selected on read
+	se.Router.GET("/api/custom/smart-collections", handler.ListSmartCollections).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	se.Router.POST("/api/custom/smart-collections", handler.CreateSmartCollection)
+	se.Router.GET("/api/custom/smart-collections/{id}", handler.GetSmartCollection).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	se.Router.PUT("/api/custom/smart-collections/{id}", handler.UpdateSmartCollection)
+	se.Router.DELETE("/api/custom/smart-collections/{id}", handler.DeleteSmartCollection)
+	se.Router.GET("/api/custom/smart-collections/{id}/images", handler.GetSmartCollectionImages).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	se.Router.GET("/api/custom/collections/{id}/permissions", handler.GetCollectionPermissions)
+	se.Router.POST("/api/custom/collections/{id}/permissions", handler.ShareCollection)
+	se.Router.DELETE("/api/custom/collections/{id}/permissions/{user_id}", handler.UnshareCollection)
+	handler.logger.Info("  ✓ Collections management routes registered")
+
+	// Activity feed for the dashboard home screen
+	se.Router.GET("/api/custom/activity", handler.GetActivity).BindFunc(handler.requireScope(apikeys.ScopeImagesRead))
+	handler.logger.Info("  ✓ Activity feed routes registered")
+
+	// Discord and Slack webhooks for finished pipelines and budget alerts
+	se.Router.GET("/api/custom/notifications/webhooks", handler.ListChatWebhooks)
+	se.Router.POST("/api/custom/notifications/webhooks", handler.RegisterChatWebhook)
+	se.Router.DELETE("/api/custom/notifications/webhooks/{id}", handler.DeleteChatWebhook)
+	se.Router.POST("/api/custom/notifications/webhooks/{id}/test", handler.TestChatWebhook)
+	handler.logger.Info("  ✓ Chat webhook routes registered")
+
+	// REST hook subscriptions for no-code automations, with signed deliveries
+	se.Router.GET("/api/custom/hooks", handler.ListHooks).BindFunc(handler.requireScope(apikeys.ScopeHooksWrite))
+	se.Router.POST("/api/custom/hooks", handler.CreateHook).BindFunc(handler.requireScope(apikeys.ScopeHooksWrite))
+	se.Router.GET("/api/custom/hooks/{id}", handler.GetHook).BindFunc(handler.requireScope(apikeys.ScopeHooksWrite))
+	se.Router.PATCH("/api/custom/hooks/{id}", handler.UpdateHook).BindFunc(handler.requireScope(apikeys.ScopeHooksWrite))
+	se.Router.DELETE("/api/custom/hooks/{id}", handler.DeleteHook).BindFunc(handler.requireScope(apikeys.ScopeHooksWrite))
+	handler.logger.Info("  ✓ REST hook routes registered")
+
+	// Administration
+	se.Router.GET("/api/custom/admin/maintenance", handler.GetMaintenance)
+	se.Router.POST("/api/custom/admin/connectivity/check", handler.CheckConnectivity)
+	se.Router.POST("/api/custom/admin/maintenance", handler.SetMaintenance)
+	se.Router.GET("/api/custom/admin/notifications/dead", handler.GetDeadNotifications)
+	se.Router.POST("/api/custom/admin/notifications/{id}/retry", handler.RetryNotification)
+	se.Router.GET("/api/custom/admin/content-filter/terms", handler.GetFilterTerms)
+	se.Router.POST("/api/custom/admin/content-filter/terms", handler.AddFilterTerm)
+	se.Router.DELETE("/api/custom/admin/content-filter/terms/{id}", handler.DeleteFilterTerm)
+	se.Router.POST("/api/custom/admin/retention/run", handler.RunRetention)
+	se.Router.POST("/api/custom/admin/trash/purge", handler.RunTrashPurge)
+	se.Router.GET("/api/custom/admin/storage/report", handler.GetStorageReport)
+	se.Router.GET("/api/custom/admin/sessions", handler.GetSessionStats)
+	// Times key derivation settings on this hardware to tune login latency against brute-force cost
+	se.Router.POST("/api/custom/admin/crypto/benchmark", handler.RunCryptoBenchmark)
+	se.Router.GET("/api/custom/admin/reconciliation", handler.GetReconciliation)
+	se.Router.POST("/api/custom/admin/reconciliation/run", handler.RunReconciliation)
+	se.Router.POST("/api/custom/admin/anomalies/run", handler.RunAnomalyCheck)
+	se.Router.POST("/api/custom/admin/key-health/run", handler.RunKeyHealthCheck)
+	se.Router.GET("/api/custom/admin/users/{id}/budget", handler.GetUserBudget)
+	se.Router.PUT("/api/custom/admin/users/{id}/budget", handler.SetUserBudget)
+	se.Router.POST("/api/custom/admin/users/{id}/credits", handler.GrantCredit)
+	se.Router.POST("/api/custom/admin/users/{id}/quota/reset", handler.ResetUserQuota)
+	se.Router.GET("/api/custom/admin/audit", handler.GetAuditLog)
+	handler.logger.Info("  ✓ Administration routes registered")
+
+	// Public health check; "degraded" while FAL AI is unreachable, "fake_fal" in development mode
+	se.Router.GET("/api/custom/health", handler.GetHealth)
 
 	// Add a simple test endpoint to verify custom routing works
 	se.Router.GET("/api/custom/test", func(e *core.RequestEvent) error {
-		app.Logger().Info("🧪 Test endpoint called successfully")
-		return e.JSON(200, map[string]string{
-			"status": "ok",
+		handler.logger.Info("🧪 Test endpoint called successfully")
+		return handler.respond(e, http.StatusOK, map[string]string{
+			"status":  "ok",
 			"message": "Custom routes are working correctly",
 		})
 	})
-	app.Logger().Info("  ✓ Test endpoint registered: GET /api/custom/test")
+	handler.logger.Info("  ✓ Test endpoint registered: GET /api/custom/test")
+
+	handler.logger.Info("✅ All custom routes registered successfully")
 
-	app.Logger().Info("✅ All custom routes registered successfully")
-}
\ No newline at end of file
+	return handler
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"generatio-pb/internal/hooks"
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// hookErrorResponse maps REST hook errors to responses
+func (h *Handler) hookErrorResponse(e *core.RequestEvent, err error) error {
+	if errors.Is(err, hooks.ErrNotFound) {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Subscription not found")
+	}
+	return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+}
+
+// publishHook publishes an event to the user's REST hook subscriptions
+func (h *Handler) publishHook(userID, event, summary string, data map[string]interface{}) {
+	if err := h.hooks.Publish(userID, event, summary, data); err != nil {
+		h.logger.Error("Failed to publish hook event", "error", err, "user_id", userID, "event", event)
+	}
+}
+
+// ListHooks handles GET /api/custom/hooks
+func (h *Handler) ListHooks(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	subscriptions, err := h.hooks.List(user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch subscriptions")
+	}
+
+	return h.listJSON(e, subscriptions, map[string]interface{}{
+		"events": hooks.Events,
+	})
+}
+
+// CreateHook handles POST /api/custom/hooks
+// Deliveries are signed with the returned secret, which is only shown once
+func (h *Handler) CreateHook(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.CreateHookRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	subscription, err := h.hooks.Subscribe(user.Id, req.Event, req.TargetURL)
+	if err != nil {
+		return h.hookErrorResponse(e, err)
+	}
+
+	h.logger.Info("Hook subscription created", "user_id", user.Id, "hook_id", subscription.ID, "event", subscription.Event)
+
+	return h.respond(e, http.StatusOK, subscription)
+}
+
+// GetHook handles GET /api/custom/hooks/{id}
+func (h *Handler) GetHook(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	subscription, err := h.hooks.Get(e.Request.PathValue("id"), user.Id)
+	if err != nil {
+		return h.hookErrorResponse(e, err)
+	}
+
+	return h.respond(e, http.StatusOK, subscription)
+}
+
+// UpdateHook handles PATCH /api/custom/hooks/{id}
+// Setting enabled re-enables a subscription disabled after failed deliveries
+func (h *Handler) UpdateHook(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.UpdateHookRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	subscription, err := h.hooks.Update(e.Request.PathValue("id"), user.Id, hooks.Update{
+		TargetURL: req.TargetURL,
+		Enabled:   req.Enabled,
+	})
+	if err != nil {
+		return h.hookErrorResponse(e, err)
+	}
+
+	return h.respond(e, http.StatusOK, subscription)
+}
+
+// DeleteHook handles DELETE /api/custom/hooks/{id}
+func (h *Handler) DeleteHook(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	if err := h.hooks.Delete(e.Request.PathValue("id"), user.Id); err != nil {
+		return h.hookErrorResponse(e, err)
+	}
+
+	h.logger.Info("Hook subscription deleted", "user_id", user.Id, "hook_id", e.Request.PathValue("id"))
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
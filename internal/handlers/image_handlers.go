@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"generatio-pb/internal/imagecache"
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/moderation"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// moderateImage classifies a generated image and returns its moderation status.
+// It returns an empty status when moderation is disabled. Classifier errors
+// quarantine the image so nothing unchecked is shown; the owner can override.
+func (h *Handler) moderateImage(ctx context.Context, token, imageURL string) string {
+	if h.moderator == nil {
+		return ""
+	}
+
+	result, err := h.moderator.Classify(ctx, token, imageURL)
+	if err != nil {
+		h.logger.Error("Image moderation failed", "error", err)
+		return moderation.StatusQuarantined
+	}
+
+	if result.Flagged {
+		h.logger.Info("Image quarantined by moderation", "score", result.Score, "reason", result.Reason)
+		return moderation.StatusQuarantined
+	}
+	return moderation.StatusApproved
+}
+
+// moderatedImageInfo builds the response entry for an image, withholding URLs of quarantined images
+func moderatedImageInfo(id, url, thumbnailURL, moderationStatus string) localmodels.GeneratedImageInfo {
+	info := localmodels.GeneratedImageInfo{
+		ID:               id,
+		URL:              url,
+		ThumbnailURL:     thumbnailURL,
+		ModerationStatus: moderationStatus,
+	}
+	if moderationStatus == moderation.StatusQuarantined {
+		info.URL = ""
+		info.ThumbnailURL = ""
+	}
+	return info
+}
+
+// withTimestamps sets an image's created and updated times from its record
+func withTimestamps(info localmodels.GeneratedImageInfo, record *core.Record) localmodels.GeneratedImageInfo {
+	created, updated := record.GetDateTime("created").Time(), record.GetDateTime("updated").Time()
+	info.Created, info.Updated = &created, &updated
+	return info
+}
+
+// withVariants attaches the resized variant paths unless the image is withheld
+func (h *Handler) withVariants(info localmodels.GeneratedImageInfo) localmodels.GeneratedImageInfo {
+	if info.URL != "" {
+		info.Variants = h.imageCache.Variants(info.ID)
+	}
+	return info
+}
+
+// GetQuarantinedImages handles GET /api/custom/images/quarantine
+func (h *Handler) GetQuarantinedImages(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	orgID, _, err := h.activeOrg(e, user)
+	if err != nil {
+		return h.accessErrorResponse(e, errOrgNotFound)
+	}
+
+	records, err := h.imageRepo.Moderated(user.Id, orgID, moderation.StatusQuarantined, 100)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch images")
+	}
+
+	images := make([]localmodels.QuarantinedImage, 0, len(records))
+	for _, record := range records {
+		images = append(images, localmodels.QuarantinedImage{
+			ID:      record.Id,
+			Prompt:  record.GetString("prompt"),
+			Model:   record.GetString("model"),
+			Created: record.GetDateTime("created").Time(),
+		})
+	}
+
+	return h.listJSON(e, images, nil)
+}
+
+// OverrideModeration handles POST /api/custom/images/{id}/override
+func (h *Handler) OverrideModeration(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	record, err := h.imageRepo.Owned(user.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Image not found")
+	}
+
+	if record.GetString("moderation_status") != moderation.StatusQuarantined {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Image is not quarantined")
+	}
+
+	record.Set("moderation_status", moderation.StatusOverridden)
+	if err := h.imageRepo.Save(record); err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to update image")
+	}
+
+	h.logger.Info("Moderation overridden by owner", "image_id", record.Id, "user_id", user.Id)
+
+	return h.respond(e, http.StatusOK, h.withVariants(withTimestamps(moderatedImageInfo(record.Id, record.GetString("url"), "", moderation.StatusOverridden), record)))
+}
+
+// CaptionImage handles POST /api/custom/images/{id}/caption
+// It (re)captions an image with the configured model, e.g. one generated
+// before captioning was enabled. The call is paid with the session's key.
+func (h *Handler) CaptionImage(e *core.RequestEvent) error {
+	user, session, err := h.getAuthenticatedUserAndSession(e)
+	if err != nil {
+		return h.sessionErrorResponse(e, err)
+	}
+
+	if !h.captions.Enabled() {
+		return h.errorResponse(e, http.StatusServiceUnavailable, localmodels.ErrCodeUnavailable, "Captioning is not enabled")
+	}
+
+	record, err := h.imageRepo.Owned(user.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Image not found")
+	}
+	if record.GetString("moderation_status") == moderation.StatusQuarantined {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Image is withheld by moderation")
+	}
+
+	caption, err := h.captions.Caption(e.Request.Context(), session.FALToken, record)
+	if err != nil {
+		h.logger.Error("Failed to caption image", "image_id", record.Id, "error", err)
+		return h.errorResponse(e, http.StatusBadGateway, localmodels.ErrCodeExternal, "Failed to caption image")
+	}
+
+	return h.respond(e, http.StatusOK, localmodels.ImageCaptionResponse{ID: record.Id, Caption: caption})
+}
+
+// GetRetentionPolicy handles GET /api/custom/retention
+func (h *Handler) GetRetentionPolicy(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	return h.respond(e, http.StatusOK, h.retention.PolicyFor(user))
+}
+
+// SetRetentionPolicy handles POST /api/custom/retention
+func (h *Handler) SetRetentionPolicy(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.SetRetentionRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	if req.RetentionDays == nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "retention_days is required")
+	}
+	if *req.RetentionDays > 3650 {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "retention_days cannot exceed 3650")
+	}
+
+	if err := h.retention.SetUserDays(user, *req.RetentionDays); err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to save retention policy")
+	}
+
+	return h.respond(e, http.StatusOK, h.retention.PolicyFor(user))
+}
+
+// PreviewRetention handles GET /api/custom/retention/preview
+// It lists the images the next retention run would purge
+func (h *Handler) PreviewRetention(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	records, err := h.retention.Preview(user)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch images")
+	}
+
+	images := make([]localmodels.ExpiringImage, 0, len(records))
+	for _, record := range records {
+		images = append(images, localmodels.ExpiringImage{
+			ID:      record.Id,
+			Title:   record.GetString("title"),
+			Model:   record.GetString("model"),
+			Created: record.GetDateTime("created").Time(),
+		})
+	}
+
+	return h.listJSON(e, images, map[string]interface{}{
+		"policy": h.retention.PolicyFor(user),
+	})
+}
+
+// ServeImageFile handles GET /api/custom/images/{id}/file
+// It streams the image through the server from the on-disk cache so clients
+// are unaffected by FAL URL expiry and CORS. Range requests are supported.
+// Resized variants are served with ?size=<px>&format=<jpeg|png>.
+func (h *Handler) ServeImageFile(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	record, err := h.imageRepo.Get(e.Request.PathValue("id"))
+	if err != nil || !h.canViewImage(user, record) {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Image not found")
+	}
+
+	if record.GetString("moderation_status") == moderation.StatusQuarantined {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Image is withheld by moderation")
+	}
+
+	return h.streamImage(e, record, "private, max-age=86400")
+}
+
+// imageContentSecurityPolicy keeps a served file from running scripts or
+// loading anything, should it ever be rendered as a document
+const imageContentSecurityPolicy = "default-src 'none'; style-src 'unsafe-inline'; sandbox"
+
+// streamImage serves an image (or the variant selected by ?size=&format=) from the cache
+func (h *Handler) streamImage(e *core.RequestEvent, record *core.Record, cacheControl string) error {
+	var entry *imagecache.Entry
+	var err error
+	if sizeParam := e.Request.URL.Query().Get("size"); sizeParam != "" {
+		size, _ := strconv.Atoi(sizeParam)
+		format := e.Request.URL.Query().Get("format")
+		if format == "" {
+			format = h.imageCache.DefaultFormat()
+		}
+		if !h.imageCache.HasVariant(size, format) {
+			return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Unsupported image variant")
+		}
+		entry, err = h.imageCache.OpenVariant(e.Request.Context(), record, size, format)
+	} else {
+		entry, err = h.imageCache.Open(e.Request.Context(), record)
+	}
+	if err != nil {
+		h.logger.Error("Failed to load image file", "error", err, "image_id", record.Id)
+		return h.errorResponse(e, http.StatusBadGateway, localmodels.ErrCodeExternal, "Failed to load image file")
+	}
+	defer entry.File.Close()
+
+	if entry.ContentType != "" {
+		e.Response.Header().Set("Content-Type", entry.ContentType)
+	}
+	e.Response.Header().Set("Cache-Control", cacheControl)
+	e.Response.Header().Set("X-Content-Type-Options", "nosniff")
+	e.Response.Header().Set("Content-Security-Policy", imageContentSecurityPolicy)
+
+	http.ServeContent(e.Response, e.Request, record.Id, entry.ModTime, entry.File)
+	return nil
+}
@@ -0,0 +1,218 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"generatio-pb/internal/imagecache"
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/netguard"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// maxImportItems caps the number of files or URLs in a single import
+const maxImportItems = 50
+
+// importedModel is the model recorded when an import does not name one
+const importedModel = "imported"
+
+// ImportImages handles POST /api/custom/images/import
+// It accepts either a JSON body with image URLs or a multipart form with
+// uploaded files ("files") and shared prompt, model and folder_id fields.
+func (h *Handler) ImportImages(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	orgID, accessErr := h.writableOrg(e, user)
+	if accessErr != nil {
+		return h.accessErrorResponse(e, accessErr)
+	}
+
+	collection, err := h.app.FindCollectionByNameOrId("images")
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to find images collection")
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(e.Request.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		return h.importUploads(e, user, orgID, collection)
+	}
+
+	var req localmodels.ImportImagesRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	if len(req.Images) == 0 {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "At least one image is required")
+	}
+	if len(req.Images) > maxImportItems {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, fmt.Sprintf("Cannot import more than %d images at once", maxImportItems))
+	}
+	if err := h.checkTargetFolder(user, orgID, req.FolderID); err != nil {
+		return h.targetFolderResponse(e, req.FolderID, err)
+	}
+
+	resp := localmodels.ImportImagesResponse{
+		Imported: []localmodels.ImportedImage{},
+		Failed:   []localmodels.ImportFailure{},
+	}
+
+	for _, item := range req.Images {
+		if err := h.validateImportURL(item.URL); err != nil {
+			resp.Failed = append(resp.Failed, localmodels.ImportFailure{Source: item.URL, Error: err.Error()})
+			continue
+		}
+
+		record := newImportRecord(collection, user.Id, orgID, req.FolderID, item.Title, item.Prompt, item.Model)
+		record.Set("url", item.URL)
+		record.Set("other_info", map[string]interface{}{"source": "import", "source_url": item.URL})
+		if record.GetString("title") == "" {
+			record.Set("title", item.URL)
+		}
+
+		if err := h.app.Save(record); err != nil {
+			h.logger.Error("Failed to save imported image", "error", err, "user_id", user.Id)
+			resp.Failed = append(resp.Failed, localmodels.ImportFailure{Source: item.URL, Error: "failed to save image"})
+			continue
+		}
+
+		// Copy the file locally so the import survives the source going away
+		h.imageCache.Enqueue(record.Id)
+
+		resp.Imported = append(resp.Imported, localmodels.ImportedImage{
+			ID:       record.Id,
+			Title:    record.GetString("title"),
+			URL:      item.URL,
+			Source:   item.URL,
+			Variants: h.imageCache.Variants(record.Id),
+		})
+	}
+
+	h.logger.Info("Images imported", "user_id", user.Id, "imported", len(resp.Imported), "failed", len(resp.Failed))
+
+	return h.respond(e, http.StatusOK, resp)
+}
+
+// importUploads imports files from a multipart form into the image cache
+func (h *Handler) importUploads(e *core.RequestEvent, user *core.Record, orgID string, collection *core.Collection) error {
+	if h.cfg.MaxUploadBytes > 0 {
+		e.Request.Body = http.MaxBytesReader(e.Response, e.Request.Body, int64(h.cfg.MaxUploadBytes))
+	}
+	if err := e.Request.ParseMultipartForm(32 << 20); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return h.errorResponse(e, http.StatusRequestEntityTooLarge, localmodels.ErrCodeValidation, fmt.Sprintf("Uploads must not exceed %d bytes", maxErr.Limit))
+		}
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Invalid multipart form: "+err.Error())
+	}
+
+	files := e.Request.MultipartForm.File["files"]
+	if len(files) == 0 {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "At least one file is required")
+	}
+	if len(files) > maxImportItems {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, fmt.Sprintf("Cannot import more than %d images at once", maxImportItems))
+	}
+
+	folderID := e.Request.FormValue("folder_id")
+	if err := h.checkTargetFolder(user, orgID, folderID); err != nil {
+		return h.targetFolderResponse(e, folderID, err)
+	}
+
+	resp := localmodels.ImportImagesResponse{
+		Imported: []localmodels.ImportedImage{},
+		Failed:   []localmodels.ImportFailure{},
+	}
+
+	for _, header := range files {
+		if header.Size > imagecache.MaxImageBytes {
+			resp.Failed = append(resp.Failed, localmodels.ImportFailure{Source: header.Filename, Error: "file is too large"})
+			continue
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			resp.Failed = append(resp.Failed, localmodels.ImportFailure{Source: header.Filename, Error: "failed to read file"})
+			continue
+		}
+
+		record := newImportRecord(collection, user.Id, orgID, folderID, e.Request.FormValue("title"), e.Request.FormValue("prompt"), e.Request.FormValue("model"))
+		record.Set("other_info", map[string]interface{}{"source": "upload", "original_name": header.Filename})
+		if record.GetString("title") == "" {
+			record.Set("title", header.Filename)
+		}
+
+		err = h.imageCache.Store(record, file)
+		file.Close()
+		if err != nil {
+			resp.Failed = append(resp.Failed, localmodels.ImportFailure{Source: header.Filename, Error: err.Error()})
+			continue
+		}
+
+		if err := h.app.Save(record); err != nil {
+			h.logger.Error("Failed to save imported image", "error", err, "user_id", user.Id)
+			resp.Failed = append(resp.Failed, localmodels.ImportFailure{Source: header.Filename, Error: "failed to save image"})
+			continue
+		}
+
+		// Generate the resized variants in the background
+		h.imageCache.Enqueue(record.Id)
+
+		resp.Imported = append(resp.Imported, localmodels.ImportedImage{
+			ID:       record.Id,
+			Title:    record.GetString("title"),
+			URL:      "/api/custom/images/" + record.Id + "/file",
+			Source:   header.Filename,
+			Variants: h.imageCache.Variants(record.Id),
+		})
+	}
+
+	h.logger.Info("Images uploaded", "user_id", user.Id, "imported", len(resp.Imported), "failed", len(resp.Failed))
+
+	return h.respond(e, http.StatusOK, resp)
+}
+
+// newImportRecord creates an image record with the shared import metadata
+func newImportRecord(collection *core.Collection, userID, orgID, folderID, title, prompt, model string) *core.Record {
+	if model == "" {
+		model = importedModel
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("user_id", userID)
+	record.Set("title", strings.TrimSpace(title))
+	record.Set("prompt", prompt)
+	record.Set("model", model)
+	if orgID != "" {
+		record.Set("org_id", orgID)
+	}
+	if folderID != "" {
+		record.Set("folder_id", folderID)
+	}
+	return record
+}
+
+// validateImportURL accepts absolute http and https URLs only. Hosts naming
+// the server's own network are rejected up front; names resolving to it are
+// refused by the image cache when it connects.
+func (h *Handler) validateImportURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" {
+		return fmt.Errorf("invalid URL")
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("only http and https URLs can be imported")
+	}
+	if !h.cfg.AllowPrivateImageURLs && netguard.CheckHost(parsed.Hostname()) != nil {
+		return fmt.Errorf("URLs on private networks cannot be imported")
+	}
+	return nil
+}
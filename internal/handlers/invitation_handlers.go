@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"generatio-pb/internal/folderacl"
+	"generatio-pb/internal/invites"
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/notify"
+	"generatio-pb/internal/orgs"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// invitationAcceptPath is the frontend page that posts the emailed token to the accept endpoint
+const invitationAcceptPath = "/invitations/accept"
+
+// canInviteTo checks the user may share the folder (owner) or organization (owner/admin)
+func (h *Handler) canInviteTo(user *core.Record, kind, targetID string) *accessError {
+	switch kind {
+	case invites.KindFolder:
+		_, role, err := h.folders.Find(targetID, user.Id)
+		if err != nil {
+			return errFolderNotFound
+		}
+		if !folderacl.CanDelete(role) {
+			return errFolderOwner
+		}
+	case invites.KindOrg:
+		role, err := h.orgs.Role(targetID, user.Id)
+		if err != nil {
+			return errOrgNotFound
+		}
+		if !orgs.CanManage(role) {
+			return errOrgManage
+		}
+	default:
+		return &accessError{http.StatusBadRequest, localmodels.ErrCodeValidation, "kind must be one of: folder, org"}
+	}
+	return nil
+}
+
+// CreateInvitation handles POST /api/custom/invitations
+// The accept token is only sent by email
+func (h *Handler) CreateInvitation(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.CreateInvitationRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	if accessErr := h.canInviteTo(user, req.Kind, req.TargetID); accessErr != nil {
+		return h.accessErrorResponse(e, accessErr)
+	}
+
+	token, invitation, err := h.invites.Create(user.Id, req.Kind, req.TargetID, req.Email, req.Role)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	h.sendInvitationEmail(user, invitation, token)
+
+	h.logger.Info("Invitation created", "invitation_id", invitation.ID, "kind", invitation.Kind, "target_id", invitation.TargetID, "user_id", user.Id)
+
+	return h.respond(e, http.StatusOK, invitation)
+}
+
+// ListInvitations handles GET /api/custom/invitations?kind=&target_id=
+func (h *Handler) ListInvitations(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	kind := e.Request.URL.Query().Get("kind")
+	targetID := e.Request.URL.Query().Get("target_id")
+	if accessErr := h.canInviteTo(user, kind, targetID); accessErr != nil {
+		return h.accessErrorResponse(e, accessErr)
+	}
+
+	list, err := h.invites.ForTarget(kind, targetID)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch invitations")
+	}
+
+	return h.listJSON(e, list, nil)
+}
+
+// GetPendingInvitations handles GET /api/custom/invitations/pending
+// It lists open invitations addressed to the user's email
+func (h *Handler) GetPendingInvitations(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	list, err := h.invites.PendingFor(user.Email())
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch invitations")
+	}
+
+	return h.listJSON(e, list, nil)
+}
+
+// AcceptInvitation handles POST /api/custom/invitations/accept
+func (h *Handler) AcceptInvitation(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.AcceptInvitationRequest
+	if err := h.decodeJSON(e, &req); err != nil && !errors.Is(err, io.EOF) {
+		return h.invalidBodyResponse(e, err)
+	}
+	if req.Token == "" {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "token is required")
+	}
+
+	invitation, err := h.invites.Accept(req.Token, user)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	h.logger.Info("Invitation accepted", "invitation_id", invitation.ID, "user_id", user.Id)
+
+	return h.respond(e, http.StatusOK, invitation)
+}
+
+// RevokeInvitation handles DELETE /api/custom/invitations/{id}
+func (h *Handler) RevokeInvitation(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	invitation, err := h.invites.Find(e.Request.PathValue("id"))
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Invitation not found")
+	}
+	if accessErr := h.canInviteTo(user, invitation.Kind, invitation.TargetID); accessErr != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Invitation not found")
+	}
+
+	if err := h.invites.Revoke(invitation.ID); err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// sendInvitationEmail queues the invitation email with its accept link
+func (h *Handler) sendInvitationEmail(inviter *core.Record, invitation *invites.Invitation, token string) {
+	link := strings.TrimSuffix(h.app.Settings().Meta.AppURL, "/") + invitationAcceptPath + "?token=" + url.QueryEscape(token)
+
+	what := "the folder"
+	if invitation.Kind == invites.KindOrg {
+		what = "the organization"
+	}
+	if invitation.TargetName != "" {
+		what += " " + invitation.TargetName
+	}
+
+	err := h.notifier.Enqueue(notify.Message{
+		UserID:  inviter.Id,
+		Channel: notify.ChannelEmail,
+		Target:  invitation.Email,
+		Event:   notify.EventInvitation,
+		Subject: fmt.Sprintf("%s invited you to %s on Generatio", inviter.Email(), what),
+		Body:    fmt.Sprintf("You have been invited as %s to %s. Accept before %s: %s", invitation.Role, what, invitation.ExpiresAt.Format("2006-01-02"), link),
+		HTML: fmt.Sprintf(`<p>%s invited you as <strong>%s</strong> to %s.</p><p><a href="%s">Accept invitation</a></p><p>This link expires on %s.</p>`,
+			html.EscapeString(inviter.Email()), html.EscapeString(invitation.Role), html.EscapeString(what), html.EscapeString(link), invitation.ExpiresAt.Format("2006-01-02")),
+	})
+	if err != nil {
+		h.logger.Error("Failed to queue invitation email", "error", err, "invitation_id", invitation.ID)
+	}
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+
+	"generatio-pb/internal/keyhealth"
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// keyHealthResponse describes the user's key health setting and last verdict
+func (h *Handler) keyHealthResponse(user *core.Record) map[string]interface{} {
+	resp := map[string]interface{}{
+		"enabled":        user.GetBool("key_health_checks"),
+		"interval":       h.cfg.KeyHealthInterval.String(),
+		"requires_login": true,
+		"note":           "Your FAL AI key is stored encrypted with your password, so it can only be checked while you have an active session.",
+	}
+	if health := keyhealth.Get(user); health.Status != "" {
+		resp["health"] = health
+	}
+	return resp
+}
+
+// GetKeyHealth handles GET /api/custom/auth/key-health
+// It reports whether background key checks are on and what the last check found
+func (h *Handler) GetKeyHealth(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	return h.respond(e, http.StatusOK, h.keyHealthResponse(user))
+}
+
+// SetKeyHealthChecks handles PUT /api/custom/auth/key-health/settings
+// Turning checks on validates the session's key straight away; turning them
+// off forgets the last verdict.
+func (h *Handler) SetKeyHealthChecks(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.KeyHealthSettingsRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+	if req.Enabled == nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "enabled is required")
+	}
+
+	user.Set("key_health_checks", *req.Enabled)
+	if !*req.Enabled {
+		user.Set("key_health", nil)
+	}
+	if err := h.app.Save(user); err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to save key health setting")
+	}
+
+	if *req.Enabled {
+		if _, _, err := h.keyHealth.Check(e.Request.Context(), user); err != nil {
+			h.logger.Warn("Failed to check FAL key health", "error", err, "user_id", user.Id)
+		}
+	}
+
+	return h.respond(e, http.StatusOK, h.keyHealthResponse(user))
+}
+
+// RunKeyHealthCheck handles POST /api/custom/admin/key-health/run
+// It checks opted-in users' keys now instead of waiting for the schedule
+func (h *Handler) RunKeyHealthCheck(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	report, err := h.keyHealth.Run(e.Request.Context())
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Key health check failed")
+	}
+
+	h.logger.Info("FAL key health check triggered", "flagged", report.Flagged, "superuser_id", e.Auth.Id)
+
+	return h.respond(e, http.StatusOK, report)
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"generatio-pb/internal/keystats"
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Per-key usage windows in days
+const (
+	defaultKeyUsageDays = 30
+	maxKeyUsageDays     = 365
+)
+
+// GetKeyUsage handles GET /api/custom/financial/keys
+// It compares generations and spend per FAL AI key over the last ?days= days
+// (default 30). Keys are identified by a fingerprint and their last four
+// characters; the key of the caller's session is marked current.
+func (h *Handler) GetKeyUsage(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	days := defaultKeyUsageDays
+	if raw := e.Request.URL.Query().Get("days"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 1 || parsed > maxKeyUsageDays {
+			return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, fmt.Sprintf("days must be between 1 and %d", maxKeyUsageDays))
+		}
+		days = parsed
+	}
+
+	var currentKeyID string
+	if session, err := h.sessionStore.Get(h.requestSessionID(e)); err == nil && session.UserID == user.Id {
+		currentKeyID = keystats.KeyID(session.FALToken)
+	}
+
+	usage, err := keystats.Usage(h.app, user, time.Now().AddDate(0, 0, -days), currentKeyID)
+	if err != nil {
+		h.logger.Error("Failed to compute key usage", "error", err, "user_id", user.Id)
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to compute key usage")
+	}
+
+	return h.listJSON(e, usage, map[string]interface{}{
+		"days": days,
+	})
+}
+
+// SetKeyLabel handles PUT /api/custom/financial/keys/{key_id}
+// It names a key, such as "personal" or a client's name; an empty label
+// removes the name
+func (h *Handler) SetKeyLabel(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.KeyLabelRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	keyID := e.Request.PathValue("key_id")
+	if err := keystats.SetLabel(user, keyID, req.Label); err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+	if err := h.app.Save(user); err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to save key label")
+	}
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"key_id": keyID,
+		"label":  keystats.Labels(user)[keyID],
+	})
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/ratelimit"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// rateLimitKey identifies the caller for rate limiting: the user when
+// authenticated, otherwise the client IP
+func rateLimitKey(e *core.RequestEvent) string {
+	if e.Auth != nil {
+		return ratelimit.UserKey(e.Auth.Id)
+	}
+	return ratelimit.IPKey(e.RealIP())
+}
+
+// requireRateLimit counts generation requests against the caller's rate limit,
+// reporting their standing in X-RateLimit-* headers and rejecting requests
+// over the limit with 429. Bind it after requireScope so API key callers are
+// counted as their user.
+func (h *Handler) requireRateLimit(e *core.RequestEvent) error {
+	if !h.rateLimiter.Enabled() {
+		return e.Next()
+	}
+
+	status, allowed := h.rateLimiter.Allow(rateLimitKey(e))
+	header := e.Response.Header()
+	header.Set("X-RateLimit-Limit", strconv.Itoa(status.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(status.Remaining))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(status.Reset.Unix(), 10))
+
+	if !allowed {
+		retryAfter := int(math.Ceil(time.Until(status.Reset).Seconds()))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		header.Set("Retry-After", strconv.Itoa(retryAfter))
+		return h.errorResponse(e, http.StatusTooManyRequests, localmodels.ErrCodeRateLimit, fmt.Sprintf("Rate limit exceeded, retry in %d seconds", retryAfter))
+	}
+	return e.Next()
+}
+
+// GetLimits handles GET /api/custom/limits
+// It summarizes the caller's rate limit, budget and quota standing
+func (h *Handler) GetLimits(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	status, err := h.budgets.Status(user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch limits")
+	}
+
+	now := time.Now().UTC()
+	resp := localmodels.LimitsResponse{
+		Budget: localmodels.BudgetStanding{
+			MonthlyBudgetUSD: status.MonthlyBudgetUSD,
+			CreditUSD:        status.CreditUSD,
+			SpentUSD:         status.Usage.MonthSpentUSD,
+			RemainingUSD:     status.Usage.RemainingUSD,
+			SpentMicros:      status.Usage.MonthSpent,
+			RemainingMicros:  status.Usage.Remaining,
+			Reset:            time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		Quota: localmodels.QuotaStanding{
+			DailyImageQuota: status.DailyImages,
+			UsedToday:       status.Usage.TodayImages,
+			Reset:           time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	if status.DailyImages > 0 {
+		remaining := status.DailyImages - status.Usage.TodayImages
+		if remaining < 0 {
+			remaining = 0
+		}
+		resp.Quota.Remaining = &remaining
+	}
+
+	if h.rateLimiter.Enabled() {
+		standing := h.rateLimiter.Peek(rateLimitKey(e))
+		resp.RateLimit = &localmodels.RateLimitStanding{
+			Limit:         standing.Limit,
+			Remaining:     standing.Remaining,
+			Reset:         standing.Reset.UTC(),
+			WindowSeconds: int(h.rateLimiter.Window().Seconds()),
+		}
+	}
+
+	return h.respond(e, http.StatusOK, resp)
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/repository"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Lineage tree limits
+const (
+	maxLineageDepth = 50
+	maxLineageNodes = 200
+)
+
+// lineageBuilder collects a lineage tree visible to one user
+type lineageBuilder struct {
+	h       *Handler
+	user    *core.Record
+	current string
+	visited map[string]bool
+	// truncated is set when the tree hit maxLineageNodes
+	truncated bool
+}
+
+// node builds the subtree rooted at record
+func (b *lineageBuilder) node(record *core.Record) localmodels.LineageNode {
+	b.visited[record.Id] = true
+
+	var info struct {
+		Lineage struct {
+			Relation string `json:"relation"`
+		} `json:"lineage"`
+	}
+	record.UnmarshalJSONField("other_info", &info)
+
+	node := localmodels.LineageNode{
+		ID:       record.Id,
+		ParentID: record.GetString("parent_id"),
+		Relation: info.Lineage.Relation,
+		Prompt:   record.GetString("prompt"),
+		Model:    record.GetString("model"),
+		Seed:     recordedSettings(record).Seed,
+		Current:  record.Id == b.current,
+		Deleted:  !record.GetDateTime("deleted_at").IsZero(),
+		Created:  record.GetDateTime("created").Time(),
+		Children: []localmodels.LineageNode{},
+	}
+	if !node.Deleted {
+		image := b.h.withVariants(withTimestamps(moderatedImageInfo(record.Id, record.GetString("url"), "", record.GetString("moderation_status")), record))
+		node.Image = &image
+	}
+
+	children, err := b.h.imageRepo.DerivedFrom(record.Id)
+	if err != nil {
+		return node
+	}
+
+	for _, child := range children {
+		if b.visited[child.Id] || !b.h.canViewImage(b.user, child) {
+			continue
+		}
+		if len(b.visited) >= maxLineageNodes {
+			b.truncated = true
+			break
+		}
+		node.Children = append(node.Children, b.node(child))
+	}
+	return node
+}
+
+// GetImageLineage handles GET /api/custom/images/{id}/lineage
+// It returns the tree of edits, regenerations and variations the image
+// belongs to, rooted at its oldest visible ancestor
+func (h *Handler) GetImageLineage(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	image, err := h.imageRepo.Get(e.Request.PathValue("id"))
+	if err != nil || !h.canViewImage(user, image) {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Image not found")
+	}
+
+	// Walk up to the oldest ancestor the user may see. Deleted ancestors are
+	// kept so the tree stays connected.
+	root := image
+	seen := map[string]bool{image.Id: true}
+	for depth := 0; depth < maxLineageDepth; depth++ {
+		parentID := root.GetString("parent_id")
+		if parentID == "" || seen[parentID] {
+			break
+		}
+		parent, err := h.imageRepo.GetIn(repository.All, parentID)
+		if err != nil || !h.canViewImage(user, parent) {
+			break
+		}
+		seen[parentID] = true
+		root = parent
+	}
+
+	builder := &lineageBuilder{
+		h:       h,
+		user:    user,
+		current: image.Id,
+		visited: make(map[string]bool),
+	}
+	tree := builder.node(root)
+
+	return h.respond(e, http.StatusOK, localmodels.LineageResponse{
+		ImageID:   image.Id,
+		Root:      tree,
+		Nodes:     len(builder.visited),
+		Truncated: builder.truncated,
+	})
+}
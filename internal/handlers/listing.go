@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+	"reflect"
+
+	"generatio-pb/internal/i18n"
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/picker"
+)
+
+// fieldsParam names the query parameter selecting response fields
+const fieldsParam = "fields"
+
+// respond writes data in the response envelope
+func (h *Handler) respond(e *core.RequestEvent, status int, data interface{}) error {
+	return h.respondMeta(e, status, data, nil)
+}
+
+// respondMeta writes data and meta in the response envelope
+func (h *Handler) respondMeta(e *core.RequestEvent, status int, data interface{}, meta map[string]interface{}) error {
+	resp, err := h.envelope(e, data, meta)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Invalid fields parameter")
+	}
+	return e.JSON(status, resp)
+}
+
+// listJSON writes a listing response: the items as data next to meta.
+// A ?fields= query parameter picks fields of each item, so ?fields=id,url
+// trims every image to its id and url while meta such as next_cursor is kept.
+// A nil slice is written as an empty array, never as null.
+func (h *Handler) listJSON(e *core.RequestEvent, items interface{}, meta map[string]interface{}) error {
+	if v := reflect.ValueOf(items); v.Kind() == reflect.Slice && v.IsNil() {
+		items = reflect.MakeSlice(v.Type(), 0, 0).Interface()
+	}
+	return h.respondMeta(e, http.StatusOK, items, meta)
+}
+
+// apiErrorResponse writes a failed request's error in the response envelope.
+// The message is translated into the locale Accept-Language prefers when the
+// catalog has one for the error code; the code itself never changes.
+func (h *Handler) apiErrorResponse(e *core.RequestEvent, status int, apiErr localmodels.APIError) error {
+	takeFields(e)
+	apiErr = h.localizedError(e, apiErr)
+	return e.JSON(status, localmodels.Response{Error: &apiErr})
+}
+
+// localizedError translates an error's message for the request like
+// apiErrorResponse, for errors reported inside a response's data. A
+// translated message is general to the code, so the specific one is kept in
+// detail.
+func (h *Handler) localizedError(e *core.RequestEvent, apiErr localmodels.APIError) localmodels.APIError {
+	locale := i18n.Negotiate(e.Request.Header.Get("Accept-Language"))
+	if message, ok := i18n.Message(locale, apiErr.Code); ok && message != apiErr.Message {
+		if apiErr.Detail == "" {
+			apiErr.Detail = apiErr.Message
+		}
+		apiErr.Message = message
+	}
+	e.Response.Header().Set("Content-Language", locale)
+	e.Response.Header().Add("Vary", "Accept-Language")
+	return apiErr
+}
+
+// envelope wraps data and meta for a response. A ?fields= query parameter
+// picks fields of data rather than of the envelope.
+func (h *Handler) envelope(e *core.RequestEvent, data interface{}, meta map[string]interface{}) (localmodels.Response, error) {
+	resp := localmodels.Response{Data: data, Meta: meta}
+	if rawFields := takeFields(e); rawFields != "" {
+		picked, err := picker.Pick(data, rawFields)
+		if err != nil {
+			return resp, err
+		}
+		resp.Data = picked
+	}
+	return resp, nil
+}
+
+// takeFields removes the ?fields= query parameter, which e.JSON would
+// otherwise apply to the envelope, and returns its value
+func takeFields(e *core.RequestEvent) string {
+	query := e.Request.URL.Query()
+	rawFields := query.Get(fieldsParam)
+	if rawFields != "" {
+		query.Del(fieldsParam)
+		e.Request.URL.RawQuery = query.Encode()
+	}
+	return rawFields
+}
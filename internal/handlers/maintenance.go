@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// defaultMaintenanceMessage is shown when maintenance is enabled without a custom message
+const defaultMaintenanceMessage = "Image generation is temporarily unavailable for maintenance. Please try again shortly."
+
+// MaintenanceMode holds the runtime maintenance flag toggled by superusers
+type MaintenanceMode struct {
+	mutex   sync.RWMutex
+	enabled bool
+	message string
+	since   time.Time
+}
+
+// NewMaintenanceMode creates a maintenance toggle that starts disabled
+func NewMaintenanceMode() *MaintenanceMode {
+	return &MaintenanceMode{}
+}
+
+// Set enables or disables maintenance mode with an optional user-facing message
+func (m *MaintenanceMode) Set(enabled bool, message string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if enabled && !m.enabled {
+		m.since = time.Now()
+	}
+	if !enabled {
+		m.since = time.Time{}
+		message = ""
+	}
+
+	m.enabled = enabled
+	m.message = message
+}
+
+// Status returns the current maintenance state
+func (m *MaintenanceMode) Status() localmodels.MaintenanceStatus {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	status := localmodels.MaintenanceStatus{
+		Enabled: m.enabled,
+		Message: m.message,
+	}
+	if m.enabled {
+		if status.Message == "" {
+			status.Message = defaultMaintenanceMessage
+		}
+		since := m.since
+		status.Since = &since
+	}
+	return status
+}
+
+// degradedMessage is shown while FAL AI cannot be reached
+const degradedMessage = "Image generation is unavailable because the server cannot reach FAL AI. Please try again later."
+
+// requireGenerationAvailable rejects generation requests with 503 while
+// maintenance mode is on or FAL AI is unreachable (degraded mode)
+func (h *Handler) requireGenerationAvailable(e *core.RequestEvent) error {
+	status := h.maintenance.Status()
+	if status.Enabled {
+		e.Response.Header().Set("Retry-After", "300")
+		return h.errorResponse(e, http.StatusServiceUnavailable, localmodels.ErrCodeUnavailable, status.Message)
+	}
+	if !h.availability.Connectivity().Reachable {
+		e.Response.Header().Set("Retry-After", "60")
+		return h.errorResponse(e, http.StatusServiceUnavailable, localmodels.ErrCodeUnavailable, degradedMessage)
+	}
+	return e.Next()
+}
+
+// GetHealth handles GET /api/custom/health
+// It needs no authentication and reports "degraded" while FAL AI is
+// unreachable, when reads keep working but generation is refused. fake_fal
+// tells frontends that generations are placeholders from fake FAL mode.
+func (h *Handler) GetHealth(e *core.RequestEvent) error {
+	connectivity := h.availability.Connectivity()
+	maintenance := h.maintenance.Status()
+
+	status := "ok"
+	if !connectivity.Reachable {
+		status = "degraded"
+	}
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"status":               status,
+		"generation_available": connectivity.Reachable && !maintenance.Enabled,
+		"maintenance":          maintenance.Enabled,
+		"fal":                  connectivity,
+		"fake_fal":             h.cfg.FakeFAL,
+	})
+}
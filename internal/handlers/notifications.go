@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"generatio-pb/internal/budget"
+	"generatio-pb/internal/hooks"
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/notify"
+	"generatio-pb/internal/pipelines"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Completion email preferences stored in generatio_users.completion_email
+const (
+	CompletionEmailOff         = "off"
+	CompletionEmailLongRunning = "long_running"
+	CompletionEmailAlways      = "always"
+)
+
+// longRunningThreshold is the generation time after which a job counts as long-running
+const longRunningThreshold = time.Minute
+
+// shouldSendCompletionEmail decides whether the user asked to be emailed for this generation
+func shouldSendCompletionEmail(user *core.Record, generationTime time.Duration) bool {
+	if user.Email() == "" {
+		return false
+	}
+
+	switch user.GetString("completion_email") {
+	case CompletionEmailAlways:
+		return true
+	case CompletionEmailLongRunning:
+		return generationTime >= longRunningThreshold
+	default:
+		return false
+	}
+}
+
+// sendCompletionEmail queues an email with thumbnails and a link to the finished generation
+func (h *Handler) sendCompletionEmail(user *core.Record, model, prompt string, images []localmodels.GeneratedImageInfo, generationTime time.Duration) {
+	err := h.notifier.Enqueue(notify.Message{
+		UserID:  user.Id,
+		Channel: notify.ChannelEmail,
+		Target:  user.Email(),
+		Event:   notify.EventJobCompleted,
+		Subject: fmt.Sprintf("Your %d image(s) from %s are ready", len(images), model),
+		Body:    fmt.Sprintf("Your generation with %s finished in %s.", model, generationTime.Round(time.Second)),
+		HTML:    completionEmailHTML(h.app.Settings().Meta.AppURL, model, prompt, images, generationTime),
+	})
+	if err != nil {
+		h.logger.Error("Failed to queue completion email", "error", err, "user_id", user.Id)
+	}
+}
+
+// sendSecurityNotice queues an email informing the user about a security-relevant account change
+func (h *Handler) sendSecurityNotice(user *core.Record, subject, body string) {
+	if user.Email() == "" {
+		return
+	}
+
+	err := h.notifier.Enqueue(notify.Message{
+		UserID:  user.Id,
+		Channel: notify.ChannelEmail,
+		Target:  user.Email(),
+		Event:   notify.EventSecurityNotice,
+		Subject: subject,
+		Body:    body,
+	})
+	if err != nil {
+		h.logger.Error("Failed to queue security notice", "error", err, "user_id", user.Id)
+	}
+}
+
+// notifyRunFinished tells the user's chat webhooks that a pipeline run
+// finished, with thumbnails of its final images, and publishes failed runs
+// as job.failed. Cancelled runs were stopped by the user and are not reported.
+func (h *Handler) notifyRunFinished(run *pipelines.Run) {
+	var subject, body string
+	switch run.Status {
+	case pipelines.StatusSucceeded:
+		subject = "Your pipeline finished"
+	case pipelines.StatusFailed:
+		subject = "Your pipeline failed"
+		body = run.Error + "\n"
+		h.publishHook(run.UserID, hooks.EventJobFailed, subject, map[string]interface{}{
+			"kind":   "pipeline",
+			"run_id": run.ID,
+			"error":  run.Error,
+			"cost":   run.TotalCost,
+		})
+	default:
+		return
+	}
+
+	var imageIDs []string
+	for _, step := range run.Steps {
+		if step.Status == pipelines.StatusSucceeded && len(step.ImageIDs) > 0 {
+			imageIDs = step.ImageIDs
+		}
+	}
+	body += fmt.Sprintf("%d image(s), $%.4f", len(imageIDs), run.TotalCost)
+
+	var thumbnails []string
+	if len(imageIDs) > 0 {
+		records, err := h.imageRepo.ByIDs(imageIDs)
+		if err == nil {
+			for _, record := range records {
+				if imageURL := record.GetString("url"); imageURL != "" {
+					thumbnails = append(thumbnails, imageURL)
+				}
+			}
+		}
+	}
+
+	_, err := h.notifier.EnqueueChat(notify.Message{
+		UserID:  run.UserID,
+		Event:   notify.EventJobCompleted,
+		Subject: subject,
+		Body:    body,
+		Images:  thumbnails,
+	})
+	if err != nil {
+		h.logger.Error("Failed to queue pipeline notification", "error", err, "user_id", run.UserID, "run_id", run.ID)
+	}
+}
+
+// notifyBudgetTripped tells the user's chat webhooks and budget.exceeded
+// subscriptions that a generation was refused by their budget or quota, once
+// per month or day respectively
+func (h *Handler) notifyBudgetTripped(userID string, limitErr error) {
+	now := time.Now().UTC()
+	var subject, limit string
+	var since time.Time
+	switch {
+	case errors.Is(limitErr, budget.ErrBudgetExceeded):
+		subject, limit = "Your monthly budget is exhausted", "monthly_budget"
+		since = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case errors.Is(limitErr, budget.ErrQuotaExceeded):
+		subject, limit = "Your daily image quota is reached", "daily_images"
+		since = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	default:
+		return
+	}
+	// A quota reset starts a new period
+	if resetAt := h.budgets.Get(userID).QuotaResetAt; resetAt != nil && resetAt.After(since) {
+		since = *resetAt
+	}
+
+	if !h.notifier.Sent(userID, hooks.EventBudgetExceeded, subject, since) {
+		h.publishHook(userID, hooks.EventBudgetExceeded, subject, map[string]interface{}{
+			"limit":   limit,
+			"message": subject,
+		})
+	}
+
+	if h.notifier.Sent(userID, notify.EventBudgetAlert, subject, since) {
+		return
+	}
+
+	_, err := h.notifier.EnqueueChat(notify.Message{
+		UserID:  userID,
+		Event:   notify.EventBudgetAlert,
+		Subject: subject,
+		Body:    "Generations are refused until the limit resets or an administrator raises it.",
+	})
+	if err != nil {
+		h.logger.Error("Failed to queue budget alert", "error", err, "user_id", userID)
+	}
+}
+
+// completionEmailHTML renders the completion email body
+func completionEmailHTML(appURL, model, prompt string, images []localmodels.GeneratedImageInfo, generationTime time.Duration) string {
+	var body strings.Builder
+
+	body.WriteString("<p>Your generation has finished.</p>")
+	body.WriteString(fmt.Sprintf("<p><strong>Model:</strong> %s<br><strong>Prompt:</strong> %s<br><strong>Time:</strong> %s</p>",
+		html.EscapeString(model), html.EscapeString(prompt), generationTime.Round(time.Second)))
+
+	body.WriteString("<p>")
+	for _, img := range images {
+		thumb := img.ThumbnailURL
+		if thumb == "" {
+			thumb = img.URL
+		}
+		body.WriteString(fmt.Sprintf(`<a href="%s"><img src="%s" width="128" alt="generated image"></a> `,
+			html.EscapeString(img.URL), html.EscapeString(thumb)))
+	}
+	body.WriteString("</p>")
+
+	if appURL != "" {
+		body.WriteString(fmt.Sprintf(`<p><a href="%s">Open Generatio</a></p>`, html.EscapeString(appURL)))
+	}
+
+	return body.String()
+}
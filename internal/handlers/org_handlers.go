@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"net/http"
+
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/orgs"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// orgHeader is the org switcher: it selects an organization's shared library
+// instead of the user's personal one. The org_id query parameter works too.
+const orgHeader = "X-Org-ID"
+
+// activeOrg resolves the org switcher and returns the selected organization
+// and the user's role in it. Both are empty for the personal library.
+func (h *Handler) activeOrg(e *core.RequestEvent, user *core.Record) (string, string, error) {
+	orgID := e.Request.Header.Get(orgHeader)
+	if orgID == "" {
+		orgID = e.Request.URL.Query().Get("org_id")
+	}
+	if orgID == "" {
+		return "", "", nil
+	}
+
+	role, err := h.orgs.Role(orgID, user.Id)
+	if err != nil {
+		return "", "", err
+	}
+	return orgID, role, nil
+}
+
+var (
+	errOrgNotFound = &accessError{http.StatusNotFound, localmodels.ErrCodeNotFound, "Organization not found"}
+	errOrgReadOnly = &accessError{http.StatusForbidden, localmodels.ErrCodeAuthorization, "Viewers cannot add to the organization library"}
+	errOrgManage   = &accessError{http.StatusForbidden, localmodels.ErrCodeAuthorization, "Only organization owners and admins can do this"}
+)
+
+// writableOrg resolves the org switcher for requests that add to a library
+func (h *Handler) writableOrg(e *core.RequestEvent, user *core.Record) (string, *accessError) {
+	orgID, role, err := h.activeOrg(e, user)
+	if err != nil {
+		return "", errOrgNotFound
+	}
+	if orgID != "" && !orgs.CanWrite(role) {
+		return "", errOrgReadOnly
+	}
+	return orgID, nil
+}
+
+// canViewImage reports whether user owns the image, belongs to its
+// organization or has any role on its folder
+func (h *Handler) canViewImage(user, record *core.Record) bool {
+	if record.GetString("user_id") == user.Id {
+		return true
+	}
+	if orgID := record.GetString("org_id"); orgID != "" {
+		if _, err := h.orgs.Role(orgID, user.Id); err == nil {
+			return true
+		}
+	}
+	if folderID := record.GetString("folder_id"); folderID != "" {
+		if _, _, err := h.folders.Find(folderID, user.Id); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// managedOrg loads the organization in the path and checks the user may manage it
+func (h *Handler) managedOrg(e *core.RequestEvent) (*core.Record, string, *accessError) {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return nil, "", errAuthRequired
+	}
+
+	orgID := e.Request.PathValue("id")
+	role, err := h.orgs.Role(orgID, user.Id)
+	if err != nil {
+		return nil, "", errOrgNotFound
+	}
+	if !orgs.CanManage(role) {
+		return nil, "", errOrgManage
+	}
+	return user, orgID, nil
+}
+
+// ListOrgs handles GET /api/custom/orgs
+func (h *Handler) ListOrgs(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	list, err := h.orgs.ForUser(user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch organizations")
+	}
+
+	return h.listJSON(e, list, nil)
+}
+
+// CreateOrg handles POST /api/custom/orgs
+func (h *Handler) CreateOrg(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.CreateOrgRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	org, err := h.orgs.Create(user.Id, req.Name)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	h.logger.Info("Organization created", "org_id", org.ID, "user_id", user.Id)
+
+	return h.respond(e, http.StatusOK, org)
+}
+
+// GetOrgMembers handles GET /api/custom/orgs/{id}/members
+func (h *Handler) GetOrgMembers(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	orgID := e.Request.PathValue("id")
+	if _, err := h.orgs.Role(orgID, user.Id); err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Organization not found")
+	}
+
+	members, err := h.orgs.Members(orgID)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch members")
+	}
+
+	return h.listJSON(e, members, nil)
+}
+
+// AddOrgMember handles POST /api/custom/orgs/{id}/members
+// Adding an existing member changes their role
+func (h *Handler) AddOrgMember(e *core.RequestEvent) error {
+	user, orgID, accessErr := h.managedOrg(e)
+	if accessErr != nil {
+		return h.accessErrorResponse(e, accessErr)
+	}
+
+	var req localmodels.AddOrgMemberRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+	if req.Role == "" {
+		req.Role = orgs.RoleMember
+	}
+
+	member, err := h.orgs.AddMember(orgID, req.Email, req.Role)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	h.logger.Info("Organization member added", "org_id", orgID, "member_id", member.UserID, "role", member.Role, "user_id", user.Id)
+
+	return h.respond(e, http.StatusOK, member)
+}
+
+// RemoveOrgMember handles DELETE /api/custom/orgs/{id}/members/{user_id}
+func (h *Handler) RemoveOrgMember(e *core.RequestEvent) error {
+	user, orgID, accessErr := h.managedOrg(e)
+	if accessErr != nil {
+		return h.accessErrorResponse(e, accessErr)
+	}
+
+	memberID := e.Request.PathValue("user_id")
+	if err := h.orgs.RemoveMember(orgID, memberID); err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	h.logger.Info("Organization member removed", "org_id", orgID, "member_id", memberID, "user_id", user.Id)
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// GetOrgSpending handles GET /api/custom/orgs/{id}/spending
+func (h *Handler) GetOrgSpending(e *core.RequestEvent) error {
+	_, orgID, accessErr := h.managedOrg(e)
+	if accessErr != nil {
+		return h.accessErrorResponse(e, accessErr)
+	}
+
+	spending, err := h.orgs.Spending(orgID)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to calculate spending")
+	}
+
+	return h.respond(e, http.StatusOK, spending)
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"generatio-pb/internal/fal"
+	"generatio-pb/internal/imagecache"
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/moderation"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// dataURI embeds a PNG in a request so FAL does not need to fetch it
+func dataURI(png []byte) string {
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+}
+
+// OutpaintImage handles POST /api/custom/images/{id}/outpaint
+// It pads an image in the requested directions and has a fill model extend
+// it into the new area. Results are linked to the original as children.
+func (h *Handler) OutpaintImage(e *core.RequestEvent) error {
+	var req localmodels.OutpaintRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	padding := imagecache.Padding{Left: req.Left, Right: req.Right, Top: req.Top, Bottom: req.Bottom}
+	if err := padding.Validate(); err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Valid session required")
+	}
+
+	source, err := h.imageRepo.Get(e.Request.PathValue("id"))
+	if err != nil || !h.canViewImage(user, source) {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Image not found")
+	}
+	if source.GetString("moderation_status") == moderation.StatusQuarantined {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Image is withheld by moderation")
+	}
+
+	prompt := req.Prompt
+	if prompt == "" {
+		prompt = source.GetString("prompt")
+	}
+
+	caller, handled, err := h.prepareGeneration(e, prompt, req.CollectionID)
+	if handled {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(e.Request.Context(), generationTimeout)
+	defer cancel()
+
+	entry, err := h.imageCache.Open(ctx, source)
+	if err != nil {
+		h.logger.Error("Failed to load image for outpainting", "error", err, "image_id", source.Id)
+		return h.errorResponse(e, http.StatusBadGateway, localmodels.ErrCodeExternal, "Failed to load image file")
+	}
+	canvas, err := imagecache.Pad(entry.File, padding)
+	entry.File.Close()
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	startTime := time.Now()
+	result, release, err := h.generate(ctx, caller.user.Id, caller.falToken, fal.GenerationRequest{
+		Model:  fal.OutpaintModel,
+		Prompt: prompt,
+		Parameters: map[string]interface{}{
+			"image_url":     dataURI(canvas.Image),
+			"mask_url":      dataURI(canvas.Mask),
+			"output_format": "png",
+		},
+		Priority: caller.priority,
+	})
+	if err != nil {
+		h.logger.Error("Outpainting failed", "error", err, "parent_id", source.Id)
+		return h.generationErrorResponse(e, err)
+	}
+	defer release()
+	generationTime := time.Since(startTime)
+
+	// Record the extension instead of the canvas data URIs
+	imageReq := localmodels.GenerateImageRequest{
+		Model:  fal.OutpaintModel,
+		Prompt: prompt,
+		Parameters: map[string]interface{}{
+			"image_size": map[string]interface{}{"width": canvas.Width, "height": canvas.Height},
+			"outpaint":   padding,
+		},
+		CollectionID: req.CollectionID,
+	}
+	imageInfos, err := h.saveGeneration(ctx, caller.user, caller.falToken, caller.environment, caller.orgID, imageReq, result, generationTime, &imageLinks{
+		parentID: source.Id,
+		relation: relationOutpaint,
+	})
+	if err != nil {
+		return h.generationErrorResponse(e, err)
+	}
+
+	h.logger.Info("Image outpainted", "user_id", caller.user.Id, "parent_id", source.Id, "width", canvas.Width, "height", canvas.Height, "cost", result.Cost)
+
+	return h.respond(e, http.StatusOK, localmodels.DeriveImageResponse{
+		GenerateImageResponse: generationResponse(fal.OutpaintModel, result, imageInfos, nil),
+		ParentID:              source.Id,
+		Relation:              relationOutpaint,
+		Seed:                  result.Seed,
+	})
+}
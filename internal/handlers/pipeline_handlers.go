@@ -0,0 +1,329 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"generatio-pb/internal/contentfilter"
+	"generatio-pb/internal/fal"
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/moderation"
+	"generatio-pb/internal/money"
+	"generatio-pb/internal/pagination"
+	"generatio-pb/internal/pipelines"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// maxListedPipelines caps the runs returned by the pipeline list
+const maxListedPipelines = 50
+
+// pipelineExecutor performs pipeline steps on behalf of the run's owner
+type pipelineExecutor struct {
+	h *Handler
+}
+
+// ExecuteStep implements pipelines.Executor
+func (x *pipelineExecutor) ExecuteStep(ctx context.Context, run *pipelines.Run, step pipelines.Step, inputs []string) ([]string, money.Micros, error) {
+	h := x.h
+
+	user, err := h.userRepo.Get(run.UserID)
+	if err != nil {
+		return nil, 0, pipelines.Permanent(fmt.Errorf("user not found"))
+	}
+
+	if step.Type == pipelines.StepSaveToFolder {
+		return h.savePipelineImages(user, run.OrgID, step.FolderID, inputs)
+	}
+
+	// Runs execute after the request has returned, so they borrow the FAL
+	// token of the user's current session rather than storing it, using the
+	// session's default key slot
+	active, err := h.sessionStore.GetUserSession(run.UserID)
+	if err != nil {
+		return nil, 0, pipelines.Permanent(fmt.Errorf("no active session; log in again to run pipelines"))
+	}
+	session, err := active.ForEnvironment("")
+	if err != nil {
+		return nil, 0, pipelines.Permanent(err)
+	}
+
+	priority := run.Priority
+	if priority == "" {
+		priority = fal.PriorityBatch
+	}
+	caller := &generationCaller{user: user, falToken: session.FALToken, environment: session.Environment, orgID: run.OrgID, priority: priority}
+	group := map[string]interface{}{"id": run.ID, "kind": groupKindPipeline}
+
+	if step.Type == pipelines.StepGenerate {
+		result, imageInfos, err := h.runGeneration(ctx, caller, localmodels.GenerateImageRequest{
+			Model:      step.Model,
+			Prompt:     step.Prompt,
+			Parameters: step.Parameters,
+		}, &imageLinks{group: group})
+		if err != nil {
+			spent, err := generationFailure(err)
+			return nil, spent, err
+		}
+		return generatedIDs(imageInfos), money.FromUSD(result.Cost), nil
+	}
+
+	relation := relationUpscale
+	if step.Type == pipelines.StepRemoveBackground {
+		relation = relationCutout
+	}
+
+	var outputs []string
+	var cost money.Micros
+	for _, imageID := range inputs {
+		source, err := h.imageRepo.Get(imageID)
+		if err != nil || !h.canViewImage(user, source) {
+			return nil, cost, pipelines.Permanent(fmt.Errorf("image %s not found", imageID))
+		}
+		if source.GetString("moderation_status") == moderation.StatusQuarantined {
+			return nil, cost, pipelines.Permanent(fmt.Errorf("image %s is withheld by moderation", imageID))
+		}
+		if err := h.validateImportURL(source.GetString("url")); err != nil {
+			return nil, cost, pipelines.Permanent(fmt.Errorf("image %s has no public URL", imageID))
+		}
+
+		parameters := map[string]interface{}{}
+		for key, value := range step.Parameters {
+			parameters[key] = value
+		}
+		parameters["image_url"] = source.GetString("url")
+
+		result, imageInfos, err := h.runGeneration(ctx, caller, localmodels.GenerateImageRequest{
+			Model:      step.Model,
+			Prompt:     source.GetString("prompt"),
+			Parameters: parameters,
+		}, &imageLinks{group: group, parentID: source.Id, relation: relation})
+		if err != nil {
+			spent, err := generationFailure(err)
+			return nil, cost + spent, err
+		}
+		outputs = append(outputs, generatedIDs(imageInfos)...)
+		cost += money.FromUSD(result.Cost)
+	}
+	return outputs, cost, nil
+}
+
+// generationFailure returns what a failed generation cost a step and the
+// error to fail it with. Images that were charged for but not saved are not
+// generated again.
+func generationFailure(err error) (money.Micros, error) {
+	var unsaved *unsavedGenerationError
+	if errors.As(err, &unsaved) {
+		return unsaved.cost, pipelines.Permanent(err)
+	}
+	return 0, err
+}
+
+// savePipelineImages files the images of a run into a folder the user can edit
+func (h *Handler) savePipelineImages(user *core.Record, orgID, folderID string, imageIDs []string) ([]string, money.Micros, error) {
+	if err := h.checkTargetFolder(user, orgID, folderID); err != nil {
+		return nil, 0, pipelines.Permanent(err)
+	}
+
+	for _, imageID := range imageIDs {
+		image, err := h.imageRepo.Owned(user.Id, imageID)
+		if err != nil {
+			return nil, 0, pipelines.Permanent(fmt.Errorf("image %s not found", imageID))
+		}
+		image.Set("folder_id", folderID)
+		if err := h.imageRepo.Save(image); err != nil {
+			return nil, 0, fmt.Errorf("failed to move image %s: %w", imageID, err)
+		}
+	}
+	return imageIDs, 0, nil
+}
+
+// generatedIDs returns the image IDs of a generation response
+func generatedIDs(imageInfos []localmodels.GeneratedImageInfo) []string {
+	ids := make([]string, 0, len(imageInfos))
+	for _, info := range imageInfos {
+		ids = append(ids, info.ID)
+	}
+	return ids
+}
+
+// CreatePipeline handles POST /api/custom/pipelines
+// It validates the steps and queues them as one background run
+func (h *Handler) CreatePipeline(e *core.RequestEvent) error {
+	var req localmodels.CreatePipelineRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	// A session is required now so the run can borrow its FAL token later
+	user, _, err := h.getAuthenticatedUserAndSession(e)
+	if err != nil {
+		return h.sessionErrorResponse(e, err)
+	}
+
+	return h.queuePipeline(e, user, pipelineSteps(req.Steps), pipelines.RunOptions{Priority: req.Priority})
+}
+
+// pipelineSteps converts request steps to pipeline steps
+func pipelineSteps(requested []localmodels.PipelineStep) []pipelines.Step {
+	steps := make([]pipelines.Step, len(requested))
+	for i, step := range requested {
+		steps[i] = pipelines.Step{
+			Type:        step.Type,
+			Model:       step.Model,
+			Prompt:      step.Prompt,
+			Parameters:  step.Parameters,
+			FolderID:    step.FolderID,
+			MaxAttempts: step.MaxAttempts,
+		}
+	}
+	return steps
+}
+
+// checkGenerateStep resolves a generate step's model in place and checks the
+// step against the model catalogue. A prompt the content policy blocks
+// returns the policy's decision.
+func (h *Handler) checkGenerateStep(e *core.RequestEvent, user *core.Record, models map[string]fal.ModelInfo, step *pipelines.Step) (*contentfilter.Decision, error) {
+	// Runs keep the resolved model, so later alias changes do not affect them
+	step.Model, step.Parameters = h.resolveAlias(user, step.Model, step.Parameters)
+	var err error
+	if step.Model, step.Parameters, _, err = h.migrateModel(e, step.Model, step.Parameters); err != nil {
+		return nil, err
+	}
+	model, exists := models[step.Model]
+	if !exists {
+		return nil, fmt.Errorf("unsupported model %q", step.Model)
+	}
+	if err := model.ValidateParameters(step.Parameters); err != nil {
+		return nil, err
+	}
+	if decision := h.filter.Evaluate(step.Prompt); !decision.Allowed {
+		return decision, nil
+	}
+	return nil, nil
+}
+
+// queuePipeline checks steps against the model catalogue, the content
+// policy and the user's folders, then queues the run and writes the response.
+// Runs default to batch priority; opts.Priority is capped by the user's tier.
+func (h *Handler) queuePipeline(e *core.RequestEvent, user *core.Record, steps []pipelines.Step, opts pipelines.RunOptions) error {
+	orgID, accessErr := h.writableOrg(e, user)
+	if accessErr != nil {
+		return h.accessErrorResponse(e, accessErr)
+	}
+
+	priority, err := h.requestPriority(user, opts.Priority, fal.PriorityBatch)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+	opts.Priority = priority
+
+	if err := pipelines.Validate(steps); err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	models := h.modelsFor(user)
+	for i := range steps {
+		step := &steps[i]
+		switch step.Type {
+		case pipelines.StepGenerate:
+			decision, err := h.checkGenerateStep(e, user, models, step)
+			if err != nil {
+				return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, fmt.Sprintf("Step %d: %v", i+1, err))
+			}
+			if decision != nil {
+				return h.apiErrorResponse(e, http.StatusBadRequest, localmodels.APIError{
+					Code:    localmodels.ErrCodeContentPolicy,
+					Message: decision.Reason(),
+					Details: decision,
+				})
+			}
+		case pipelines.StepUpscale, pipelines.StepRemoveBackground:
+			defaultModel := fal.UpscaleModel
+			if step.Type == pipelines.StepRemoveBackground {
+				defaultModel = fal.BackgroundRemovalModel
+			}
+			if step.Model == "" {
+				step.Model = defaultModel
+			}
+			if step.Model != defaultModel {
+				return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, fmt.Sprintf("Step %d: %s uses the %s model", i+1, step.Type, defaultModel))
+			}
+		case pipelines.StepSaveToFolder:
+			if err := h.checkTargetFolder(user, orgID, step.FolderID); err != nil {
+				return h.targetFolderResponse(e, step.FolderID, fmt.Errorf("Step %d: %w", i+1, err))
+			}
+		}
+	}
+
+	run, err := h.pipelines.Create(user.Id, orgID, steps, opts)
+	if err != nil {
+		h.logger.Error("Failed to queue pipeline", "error", err, "user_id", user.Id)
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to queue pipeline")
+	}
+
+	h.logger.Info("Pipeline queued", "user_id", user.Id, "run_id", run.ID, "steps", len(steps), "priority", run.Priority)
+
+	return h.respond(e, http.StatusAccepted, run)
+}
+
+// ListPipelines handles GET /api/custom/pipelines
+// Query parameters: page and limit, or the cursor from a previous page's
+// next_cursor
+func (h *Handler) ListPipelines(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	page, err := pagination.Parse(e.Request.URL.Query(), maxListedPipelines)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	runs, next, err := h.pipelines.ForUser(user.Id, page)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch pipelines")
+	}
+
+	return h.listJSON(e, runs, map[string]interface{}{
+		"next_cursor": next,
+	})
+}
+
+// GetPipeline handles GET /api/custom/pipelines/{id}
+// The run carries per-step status, attempts, images and cost
+func (h *Handler) GetPipeline(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	run, err := h.pipelines.Find(e.Request.PathValue("id"), user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Pipeline not found")
+	}
+
+	return h.respond(e, http.StatusOK, run)
+}
+
+// CancelPipeline handles POST /api/custom/pipelines/{id}/cancel
+func (h *Handler) CancelPipeline(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	if _, err := h.pipelines.Find(e.Request.PathValue("id"), user.Id); err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Pipeline not found")
+	}
+
+	run, err := h.pipelines.Cancel(e.Request.PathValue("id"), user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	return h.respond(e, http.StatusOK, run)
+}
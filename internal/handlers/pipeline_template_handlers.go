@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/pipelines"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// templateInput converts a save request to a template input
+func templateInput(req localmodels.SavePipelineTemplateRequest) pipelines.TemplateInput {
+	return pipelines.TemplateInput{
+		Name:        req.Name,
+		Description: req.Description,
+		OrgID:       req.OrgID,
+		Steps:       pipelineSteps(req.Steps),
+		Variables:   req.Variables,
+	}
+}
+
+// templateErrorResponse maps template store errors to responses
+func (h *Handler) templateErrorResponse(e *core.RequestEvent, err error) error {
+	if errors.Is(err, pipelines.ErrTemplateNotFound) {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Template not found")
+	}
+	return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+}
+
+// ListPipelineTemplates handles GET /api/custom/pipelines/templates
+// It returns the user's templates and those shared with their organizations
+func (h *Handler) ListPipelineTemplates(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	templates, err := h.pipelineTemplates.ForUser(user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch templates")
+	}
+
+	return h.listJSON(e, templates, nil)
+}
+
+// CreatePipelineTemplate handles POST /api/custom/pipelines/templates
+func (h *Handler) CreatePipelineTemplate(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.SavePipelineTemplateRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	template, err := h.pipelineTemplates.Create(user.Id, templateInput(req))
+	if err != nil {
+		return h.templateErrorResponse(e, err)
+	}
+
+	h.logger.Info("Pipeline template saved", "user_id", user.Id, "template_id", template.ID, "org_id", template.OrgID)
+
+	return h.respond(e, http.StatusOK, template)
+}
+
+// GetPipelineTemplate handles GET /api/custom/pipelines/templates/{id}
+func (h *Handler) GetPipelineTemplate(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	template, err := h.pipelineTemplates.Find(e.Request.PathValue("id"), user.Id)
+	if err != nil {
+		return h.templateErrorResponse(e, err)
+	}
+
+	return h.respond(e, http.StatusOK, template)
+}
+
+// UpdatePipelineTemplate handles PUT /api/custom/pipelines/templates/{id}
+// Every save creates a new version; earlier versions stay runnable
+func (h *Handler) UpdatePipelineTemplate(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.SavePipelineTemplateRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	template, err := h.pipelineTemplates.Update(e.Request.PathValue("id"), user.Id, templateInput(req))
+	if err != nil {
+		return h.templateErrorResponse(e, err)
+	}
+
+	return h.respond(e, http.StatusOK, template)
+}
+
+// DeletePipelineTemplate handles DELETE /api/custom/pipelines/templates/{id}
+func (h *Handler) DeletePipelineTemplate(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	if err := h.pipelineTemplates.Delete(e.Request.PathValue("id"), user.Id); err != nil {
+		return h.templateErrorResponse(e, err)
+	}
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// GetPipelineTemplateVersions handles GET /api/custom/pipelines/templates/{id}/versions
+func (h *Handler) GetPipelineTemplateVersions(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	versions, err := h.pipelineTemplates.Versions(e.Request.PathValue("id"), user.Id)
+	if err != nil {
+		return h.templateErrorResponse(e, err)
+	}
+
+	return h.listJSON(e, versions, nil)
+}
+
+// RunPipelineTemplate handles POST /api/custom/pipelines/templates/{id}/run
+// It fills the template's variables from the request and queues a run
+func (h *Handler) RunPipelineTemplate(e *core.RequestEvent) error {
+	var req localmodels.RunPipelineTemplateRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	user, _, err := h.getAuthenticatedUserAndSession(e)
+	if err != nil {
+		return h.sessionErrorResponse(e, err)
+	}
+
+	templateID := e.Request.PathValue("id")
+	version, err := h.pipelineTemplates.Version(templateID, user.Id, req.Version)
+	if err != nil {
+		return h.templateErrorResponse(e, err)
+	}
+
+	steps, err := version.Resolve(req.Variables)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	return h.queuePipeline(e, user, steps, pipelines.RunOptions{
+		Priority: req.Priority,
+		Template: &pipelines.TemplateRef{ID: templateID, Version: version.Version},
+	})
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"time"
+
+	"generatio-pb/internal/pagination"
+	"generatio-pb/internal/repository"
+	"generatio-pb/internal/smartfolders"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// imagesRepo is the image storage the handlers use; repository.Images and
+// repository.MemoryImages implement it
+type imagesRepo interface {
+	Get(id string) (*core.Record, error)
+	GetIn(scope repository.Scope, id string) (*core.Record, error)
+	Owned(userID, id string) (*core.Record, error)
+	OwnedIn(scope repository.Scope, userID, id string) (*core.Record, error)
+	ByIDs(ids []string) ([]*core.Record, error)
+	InFolder(folderID, sort string, limit, offset int) ([]*core.Record, error)
+	FolderPage(folderID string, page pagination.Page) ([]*core.Record, string, error)
+	TrashPage(userID string, page pagination.Page) ([]*core.Record, string, error)
+	Search(userID string, filter smartfolders.Filter, page pagination.Page) ([]*core.Record, string, error)
+	CreatedSince(userID string, since time.Time) ([]*core.Record, error)
+	InGroup(groupID string) ([]*core.Record, error)
+	Moderated(userID, orgID, status string, limit int) ([]*core.Record, error)
+	PromptHistory(userID, query string, limit int) ([]*core.Record, error)
+	DerivedFrom(parentID string) ([]*core.Record, error)
+	Save(record *core.Record) error
+}
+
+// foldersRepo is the folder storage the handlers use; repository.Folders and
+// repository.MemoryFolders implement it
+type foldersRepo interface {
+	Get(id string) (*core.Record, error)
+	GetIn(scope repository.Scope, id string) (*core.Record, error)
+	ByIDs(ids []string) ([]*core.Record, error)
+	InLibrary(userID, orgID string, limit int) ([]*core.Record, error)
+	Children(parentID string) ([]*core.Record, error)
+	Save(record *core.Record) error
+}
+
+// preferencesRepo is the model preference storage the handlers use;
+// repository.Preferences and repository.MemoryPreferences implement it
+type preferencesRepo interface {
+	ForUser(user *core.Record) ([]*core.Record, error)
+	ForModel(user *core.Record, modelName string) (*core.Record, error)
+	Upsert(user *core.Record, modelName string, prefs map[string]any) (*core.Record, bool, error)
+}
+
+// usersRepo is the user storage the handlers use; repository.Users and
+// repository.MemoryUsers implement it
+type usersRepo interface {
+	Get(id string) (*core.Record, error)
+	ByEmail(email string) (*core.Record, error)
+	Save(user *core.Record) error
+}
+
+// Repositories replaces the storage behind the handlers. Nil fields keep the
+// current repository.
+type Repositories struct {
+	Images      imagesRepo
+	Folders     foldersRepo
+	Preferences preferencesRepo
+	Users       usersRepo
+}
+
+// SetRepositories replaces the handlers' repositories, e.g. with the
+// in-memory ones from the repository package in tests
+func (h *Handler) SetRepositories(repos Repositories) {
+	if repos.Images != nil {
+		h.imageRepo = repos.Images
+	}
+	if repos.Folders != nil {
+		h.folderRepo = repos.Folders
+		h.folders.SetFolders(repos.Folders)
+	}
+	if repos.Preferences != nil {
+		h.prefRepo = repos.Preferences
+	}
+	if repos.Users != nil {
+		h.userRepo = repos.Users
+	}
+}
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"generatio-pb/internal/redact"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// maxLoggedBody is how much of a request or response body is kept for the
+// log; longer bodies are summarised by size
+const maxLoggedBody = 64 << 10
+
+// logRequests logs each custom API request and its response at debug level
+// when LogRequests is on. Credential headers and secret JSON fields such as
+// fal_token and password are redacted, and only JSON bodies are logged.
+func (h *Handler) logRequests(e *core.RequestEvent) error {
+	if !h.cfg.LogRequests || !strings.HasPrefix(e.Request.URL.Path, "/api/custom/") {
+		return e.Next()
+	}
+
+	logger := h.requestLog
+	if logger == nil {
+		logger = h.logger
+	}
+	if !logger.Enabled(e.Request.Context(), slog.LevelDebug) {
+		return e.Next()
+	}
+
+	var requestBody []byte
+	if e.Request.Body != nil && isJSON(e.Request.Header.Get("Content-Type")) {
+		// A failed read fails again when the handler reads the rest. Drained
+		// bodies are not read again: PocketBase rewinds them at EOF.
+		prefix, err := io.ReadAll(io.LimitReader(e.Request.Body, maxLoggedBody+1))
+		requestBody = prefix
+		rest := io.Reader(bytes.NewReader(prefix))
+		if err != nil || len(prefix) > maxLoggedBody {
+			rest = io.MultiReader(rest, e.Request.Body)
+		}
+		e.Request.Body = struct {
+			io.Reader
+			io.Closer
+		}{rest, e.Request.Body}
+	}
+
+	recorder := &recordingWriter{ResponseWriter: e.Response, status: http.StatusOK}
+	e.Response = recorder
+	start := time.Now()
+	err := e.Next()
+
+	logger.Debug("Custom API request",
+		"method", e.Request.Method,
+		"path", e.Request.URL.Path,
+		"query", redact.Query(e.Request.URL.Query()),
+		"status", recorder.status,
+		"duration", time.Since(start),
+		"request_headers", redact.Headers(e.Request.Header),
+		"request_body", redact.JSON(requestBody),
+		"response_body", redact.JSON(recorder.body.Bytes()),
+	)
+	return err
+}
+
+// isJSON reports whether a Content-Type names JSON; an empty one is read as
+// JSON, as decodeJSON does
+func isJSON(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
+
+// recordingWriter keeps the status and the start of a JSON response body
+// for the request log while passing everything through
+type recordingWriter struct {
+	http.ResponseWriter
+	status      int
+	body        bytes.Buffer
+	wroteHeader bool
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	if isJSON(w.Header().Get("Content-Type")) && w.body.Len() <= maxLoggedBody {
+		w.body.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// sessionHeader carries the session ID for API clients
+const sessionHeader = "X-Session-ID"
+
+// sessionCookieName carries the session ID for browsers when cfg.SessionCookie is set
+const sessionCookieName = "generatio_session"
+
+// csrfCookieName holds the double-submit CSRF token next to the session cookie.
+// Unlike the session cookie scripts can read it, and must echo it in
+// csrfHeader on state-changing requests.
+const (
+	csrfCookieName = "generatio_csrf"
+	csrfHeader     = "X-CSRF-Token"
+)
+
+// requestSessionID returns the session ID of a request. The X-Session-ID
+// header wins; with cookie transport enabled the session cookie is used when
+// the header is absent.
+func (h *Handler) requestSessionID(e *core.RequestEvent) string {
+	if sessionID := e.Request.Header.Get(sessionHeader); sessionID != "" {
+		return sessionID
+	}
+	if !h.cfg.SessionCookie {
+		return ""
+	}
+	if cookie, err := e.Request.Cookie(sessionCookieName); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// setSessionCookie hands a new session to the browser in an HttpOnly cookie
+// when cookie transport is enabled, so scripts never see the ID. The cookie
+// has no expiry of its own: the session store decides when the session ends,
+// which keeps renewed sessions working without rewriting the cookie.
+// A fresh CSRF token is issued with it, in a readable cookie and in the
+// X-CSRF-Token response header for frontends served from another origin.
+func (h *Handler) setSessionCookie(e *core.RequestEvent, sessionID string) {
+	if !h.cfg.SessionCookie {
+		return
+	}
+	e.SetCookie(h.sessionCookie(sessionCookieName, sessionID, 0))
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		h.logger.Error("Failed to generate CSRF token", "error", err)
+		return
+	}
+	csrfToken := hex.EncodeToString(secret)
+	csrfCookie := h.sessionCookie(csrfCookieName, csrfToken, 0)
+	csrfCookie.HttpOnly = false
+	csrfCookie.Path = "/"
+	e.SetCookie(csrfCookie)
+	e.Response.Header().Set(csrfHeader, csrfToken)
+}
+
+// clearSessionCookie tells the browser to drop the session and CSRF cookies
+func (h *Handler) clearSessionCookie(e *core.RequestEvent) {
+	if !h.cfg.SessionCookie {
+		return
+	}
+	e.SetCookie(h.sessionCookie(sessionCookieName, "", -1))
+	csrfCookie := h.sessionCookie(csrfCookieName, "", -1)
+	csrfCookie.Path = "/"
+	e.SetCookie(csrfCookie)
+}
+
+// requireCSRF rejects state-changing custom requests that carry the session
+// cookie unless they echo the CSRF cookie in X-CSRF-Token. Browsers attach
+// cookies to cross-site requests but another site cannot read the CSRF cookie,
+// so only the frontend that received the session can send a matching header.
+// Clients passing X-Session-ID without the cookie are not affected.
+func (h *Handler) requireCSRF(e *core.RequestEvent) error {
+	if !h.cfg.SessionCookie || !strings.HasPrefix(e.Request.URL.Path, "/api/custom/") {
+		return e.Next()
+	}
+	switch e.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return e.Next()
+	}
+	if _, err := e.Request.Cookie(sessionCookieName); err != nil {
+		return e.Next()
+	}
+
+	cookie, err := e.Request.Cookie(csrfCookieName)
+	sent := e.Request.Header.Get(csrfHeader)
+	if err != nil || cookie.Value == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(sent)) != 1 {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "CSRF token missing or invalid")
+	}
+	return e.Next()
+}
+
+func (h *Handler) sessionCookie(name, value string, maxAge int) *http.Cookie {
+	sameSite := http.SameSiteStrictMode
+	switch strings.ToLower(h.cfg.SessionCookieSameSite) {
+	case "lax":
+		sameSite = http.SameSiteLaxMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/api/custom/",
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: sameSite,
+	}
+}
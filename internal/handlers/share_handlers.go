@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"generatio-pb/internal/cdn"
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/moderation"
+	"generatio-pb/internal/share"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// CreateShareLink handles POST /api/custom/images/{id}/share
+// It returns a signed, expiring URL for a single image that works without authentication
+func (h *Handler) CreateShareLink(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.CreateShareRequest
+	if e.Request.ContentLength != 0 {
+		if err := h.decodeJSON(e, &req); err != nil {
+			return h.invalidBodyResponse(e, err)
+		}
+	}
+
+	ttl := share.DefaultTTL
+	if req.TTLSeconds != 0 {
+		ttl = time.Duration(req.TTLSeconds) * time.Second
+	}
+	if ttl <= 0 || ttl > share.MaxTTL {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, fmt.Sprintf("ttl_seconds must be between 1 and %d", int(share.MaxTTL.Seconds())))
+	}
+
+	record, err := h.imageRepo.Owned(user.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Image not found")
+	}
+
+	if record.GetString("moderation_status") == moderation.StatusQuarantined {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Image is withheld by moderation")
+	}
+
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	query := h.shareSigner.Sign(record.Id, expires)
+	appURL := strings.TrimSuffix(h.app.Settings().Meta.AppURL, "/")
+
+	h.logger.Info("Share link created", "image_id", record.Id, "user_id", user.Id, "expires_at", expires)
+
+	return h.respond(e, http.StatusOK, localmodels.ShareLinkResponse{
+		URL:       appURL + "/api/custom/shared/" + record.Id + "?" + query,
+		PageURL:   appURL + "/api/custom/shared/pages/" + record.Id + "?" + query,
+		ExpiresAt: expires.UTC(),
+	})
+}
+
+// ServeSharedImage handles GET /api/custom/shared/{id}
+// It is unauthenticated; access is granted by the signed expires/sig query parameters
+func (h *Handler) ServeSharedImage(e *core.RequestEvent) error {
+	record, err := h.sharedImage(e)
+	if err != nil {
+		return err
+	}
+
+	return h.streamImage(e, record, shareCacheControl(e.Request.URL.Query().Get("expires")))
+}
+
+// ServeSharePage handles GET /api/custom/shared/pages/{id}
+// It wraps a share link in an HTML page with OpenGraph and Twitter card tags,
+// so the link unfurls in Discord, Slack and Twitter; browsers are redirected
+// to the image itself
+func (h *Handler) ServeSharePage(e *core.RequestEvent) error {
+	record, err := h.sharedImage(e)
+	if err != nil {
+		return err
+	}
+
+	appURL := strings.TrimSuffix(h.app.Settings().Meta.AppURL, "/")
+	query := e.Request.URL.Query()
+	signed := record.Id + "?expires=" + query.Get("expires") + "&sig=" + query.Get("sig")
+
+	e.Response.Header().Set("Cache-Control", shareCacheControl(query.Get("expires")))
+	return e.HTML(http.StatusOK, sharePageHTML(h.app.Settings().Meta.AppName, record,
+		appURL+"/api/custom/shared/"+signed, appURL+"/api/custom/shared/pages/"+signed))
+}
+
+// sharedImage verifies the request's share signature and loads the image.
+// Deleted and quarantined images stop being shared even if the link is still valid.
+// The returned error is the response already written.
+func (h *Handler) sharedImage(e *core.RequestEvent) (*core.Record, error) {
+	id := e.Request.PathValue("id")
+	query := e.Request.URL.Query()
+
+	if err := h.shareSigner.Verify(id, query.Get("expires"), query.Get("sig")); err != nil {
+		return nil, h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Share link is invalid or has expired")
+	}
+
+	record, err := h.imageRepo.Get(id)
+	if err != nil || record.GetString("moderation_status") == moderation.StatusQuarantined {
+		return nil, h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Image not found")
+	}
+
+	cdn.SetTags(e.Response.Header(), cdn.ImageTag(record.Id))
+	return record, nil
+}
+
+// shareMaxAge is how long caches may keep a shared image or page
+const shareMaxAge = time.Hour
+
+// shareCacheControl lets caches keep a share link's response for at most the
+// link's remaining lifetime, so a CDN stops serving it once the link expires.
+// expires is the link's verified Unix expiry.
+func shareCacheControl(expires string) string {
+	exp, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return "private, no-store"
+	}
+	remaining := min(time.Until(time.Unix(exp, 0)), shareMaxAge)
+	if remaining < time.Second {
+		return "private, no-store"
+	}
+	return fmt.Sprintf("public, max-age=%d", int(remaining.Seconds()))
+}
+
+// maxShareTitleRunes keeps unfurled titles short; prompts can be long
+const maxShareTitleRunes = 100
+
+// sharePageHTML renders the unfurl page for a shared image
+func sharePageHTML(siteName string, record *core.Record, imageURL, pageURL string) string {
+	title := record.GetString("title")
+	if title == "" {
+		title = record.GetString("prompt")
+	}
+	if runes := []rune(title); len(runes) > maxShareTitleRunes {
+		title = strings.TrimSpace(string(runes[:maxShareTitleRunes-1])) + "…"
+	}
+	if title == "" {
+		title = "Shared image"
+	}
+	description := record.GetString("prompt")
+	if model := record.GetString("model"); model != "" {
+		description = strings.TrimSpace(description + " (" + model + ")")
+	}
+
+	esc := html.EscapeString
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	fmt.Fprintf(&b, "<title>%s</title>\n", esc(title))
+	fmt.Fprintf(&b, "<meta property=\"og:type\" content=\"website\">\n")
+	if siteName != "" {
+		fmt.Fprintf(&b, "<meta property=\"og:site_name\" content=\"%s\">\n", esc(siteName))
+	}
+	fmt.Fprintf(&b, "<meta property=\"og:title\" content=\"%s\">\n", esc(title))
+	fmt.Fprintf(&b, "<meta property=\"og:description\" content=\"%s\">\n", esc(description))
+	fmt.Fprintf(&b, "<meta property=\"og:url\" content=\"%s\">\n", esc(pageURL))
+	fmt.Fprintf(&b, "<meta property=\"og:image\" content=\"%s\">\n", esc(imageURL))
+	fmt.Fprintf(&b, "<meta property=\"og:image:alt\" content=\"%s\">\n", esc(title))
+	fmt.Fprintf(&b, "<meta name=\"twitter:card\" content=\"summary_large_image\">\n")
+	fmt.Fprintf(&b, "<meta name=\"twitter:title\" content=\"%s\">\n", esc(title))
+	fmt.Fprintf(&b, "<meta name=\"twitter:image\" content=\"%s\">\n", esc(imageURL))
+	// Crawlers read the tags above; browsers follow the refresh to the image
+	fmt.Fprintf(&b, "<meta http-equiv=\"refresh\" content=\"0; url=%s\">\n", esc(imageURL))
+	b.WriteString("</head>\n<body>\n")
+	fmt.Fprintf(&b, "<p><a href=\"%s\"><img src=\"%s\" alt=\"%s\"></a></p>\n", esc(imageURL), esc(imageURL), esc(title))
+	b.WriteString("</body>\n</html>\n")
+	return b.String()
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"generatio-pb/internal/embeddings"
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/moderation"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// maxSearchQueryRunes caps text queries, which CLIP truncates anyway
+const maxSearchQueryRunes = 300
+
+// similarityLimit reads ?limit=, defaulting and capping it
+func similarityLimit(e *core.RequestEvent) int {
+	limit, _ := strconv.Atoi(e.Request.URL.Query().Get("limit"))
+	if limit <= 0 {
+		return embeddings.DefaultResults
+	}
+	return min(limit, embeddings.MaxResults)
+}
+
+// similarImages builds the response entries of search matches
+func (h *Handler) similarImages(matches []embeddings.Match) []localmodels.SimilarImage {
+	images := make([]localmodels.SimilarImage, 0, len(matches))
+	for _, match := range matches {
+		record := match.Image
+		info := withTimestamps(moderatedImageInfo(record.Id, record.GetString("url"), "", record.GetString("moderation_status")), record)
+		images = append(images, localmodels.SimilarImage{
+			ID:      record.Id,
+			Prompt:  record.GetString("prompt"),
+			Caption: record.GetString("caption"),
+			Model:   record.GetString("model"),
+			Score:   match.Score,
+			Image:   h.withVariants(info),
+			Created: record.GetDateTime("created").Time(),
+		})
+	}
+	return images
+}
+
+// GetSimilarImages handles GET /api/custom/images/{id}/similar?limit=
+// It ranks the user's other images by embedding similarity to the image.
+// Images the background indexer has not reached yet are embedded on the
+// spot, which needs a session to pay for the call.
+func (h *Handler) GetSimilarImages(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	if h.embeddings == nil {
+		return h.errorResponse(e, http.StatusServiceUnavailable, localmodels.ErrCodeUnavailable, "Similarity search is not enabled")
+	}
+
+	record, err := h.imageRepo.Owned(user.Id, e.Request.PathValue("id"))
+	if err != nil {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Image not found")
+	}
+	if record.GetString("moderation_status") == moderation.StatusQuarantined {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Image is withheld by moderation")
+	}
+
+	vector, ok := h.embeddings.Store().Vector(record.Id, h.embeddings.Model())
+	if !ok {
+		_, session, err := h.getAuthenticatedUserAndSession(e)
+		if err != nil {
+			return h.sessionErrorResponse(e, err)
+		}
+		if err := h.embeddings.Embed(e.Request.Context(), session.FALToken, record); err != nil {
+			h.logger.Error("Failed to embed image", "image_id", record.Id, "error", err)
+			return h.errorResponse(e, http.StatusBadGateway, localmodels.ErrCodeExternal, "Failed to embed image")
+		}
+		vector, _ = h.embeddings.Store().Vector(record.Id, h.embeddings.Model())
+	}
+
+	matches, err := h.embeddings.Store().Search(user.Id, h.embeddings.Model(), vector, record.Id, similarityLimit(e))
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to search images")
+	}
+
+	return h.listJSON(e, h.similarImages(matches), nil)
+}
+
+// SearchImages handles GET /api/custom/images/search?q=&limit=
+// It ranks the user's images by how well they match a text description.
+// Embedding the query is paid with the session's FAL AI key.
+func (h *Handler) SearchImages(e *core.RequestEvent) error {
+	user, session, err := h.getAuthenticatedUserAndSession(e)
+	if err != nil {
+		return h.sessionErrorResponse(e, err)
+	}
+
+	if h.embeddings == nil {
+		return h.errorResponse(e, http.StatusServiceUnavailable, localmodels.ErrCodeUnavailable, "Similarity search is not enabled")
+	}
+
+	query := strings.TrimSpace(e.Request.URL.Query().Get("q"))
+	if query == "" {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "q is required")
+	}
+	if utf8.RuneCountInString(query) > maxSearchQueryRunes {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "q must be at most "+strconv.Itoa(maxSearchQueryRunes)+" characters")
+	}
+
+	vector, err := h.embeddings.Query(e.Request.Context(), session.FALToken, query)
+	if err != nil {
+		h.logger.Error("Failed to embed search query", "user_id", user.Id, "error", err)
+		return h.errorResponse(e, http.StatusBadGateway, localmodels.ErrCodeExternal, "Failed to embed search query")
+	}
+
+	matches, err := h.embeddings.Store().Search(user.Id, h.embeddings.Model(), vector, "", similarityLimit(e))
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to search images")
+	}
+
+	return h.listJSON(e, h.similarImages(matches), nil)
+}
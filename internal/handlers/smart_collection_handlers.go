@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/pagination"
+	"generatio-pb/internal/smartfolders"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// smartFilter converts a request filter to a smart folder filter
+func smartFilter(req localmodels.SmartCollectionFilter) smartfolders.Filter {
+	return smartfolders.Filter{
+		Model:    req.Model,
+		Tag:      req.Tag,
+		Prompt:   req.Prompt,
+		Favorite: req.Favorite,
+		Days:     req.Days,
+	}
+}
+
+// smartFolderErrorResponse maps smart folder store errors to responses
+func (h *Handler) smartFolderErrorResponse(e *core.RequestEvent, err error) error {
+	if errors.Is(err, smartfolders.ErrNotFound) {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Smart collection not found")
+	}
+	return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+}
+
+// ListSmartCollections handles GET /api/custom/smart-collections
+func (h *Handler) ListSmartCollections(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	folders, err := h.smartFolders.ForUser(user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch smart collections")
+	}
+
+	return h.listJSON(e, folders, nil)
+}
+
+// CreateSmartCollection handles POST /api/custom/smart-collections
+func (h *Handler) CreateSmartCollection(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.SaveSmartCollectionRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	folder, err := h.smartFolders.Create(user.Id, req.Name, smartFilter(req.Filter))
+	if err != nil {
+		return h.smartFolderErrorResponse(e, err)
+	}
+
+	h.logger.Info("Smart collection saved", "user_id", user.Id, "smart_collection_id", folder.ID)
+
+	return h.respond(e, http.StatusOK, folder)
+}
+
+// GetSmartCollection handles GET /api/custom/smart-collections/{id}
+func (h *Handler) GetSmartCollection(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	folder, err := h.smartFolders.Find(e.Request.PathValue("id"), user.Id)
+	if err != nil {
+		return h.smartFolderErrorResponse(e, err)
+	}
+
+	return h.respond(e, http.StatusOK, folder)
+}
+
+// UpdateSmartCollection handles PUT /api/custom/smart-collections/{id}
+// It replaces the name and the whole filter
+func (h *Handler) UpdateSmartCollection(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.SaveSmartCollectionRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	folder, err := h.smartFolders.Update(e.Request.PathValue("id"), user.Id, req.Name, smartFilter(req.Filter))
+	if err != nil {
+		return h.smartFolderErrorResponse(e, err)
+	}
+
+	return h.respond(e, http.StatusOK, folder)
+}
+
+// DeleteSmartCollection handles DELETE /api/custom/smart-collections/{id}
+// The images it showed are untouched
+func (h *Handler) DeleteSmartCollection(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	if err := h.smartFolders.Delete(e.Request.PathValue("id"), user.Id); err != nil {
+		return h.smartFolderErrorResponse(e, err)
+	}
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"success": true,
+	})
+}
+
+// GetSmartCollectionImages handles GET /api/custom/smart-collections/{id}/images
+// The images are selected by the saved filter at read time, newest first.
+// Query parameters: page and limit, or the cursor from a previous page's
+// next_cursor
+func (h *Handler) GetSmartCollectionImages(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	folder, err := h.smartFolders.Find(e.Request.PathValue("id"), user.Id)
+	if err != nil {
+		return h.smartFolderErrorResponse(e, err)
+	}
+
+	page, err := pagination.Parse(e.Request.URL.Query(), maxListedFolderImages)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	records, next, err := h.imageRepo.Search(user.Id, folder.Filter, page)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch images")
+	}
+
+	images := make([]localmodels.GeneratedImageInfo, 0, len(records))
+	for _, record := range records {
+		status := record.GetString("moderation_status")
+		images = append(images, h.withVariants(withTimestamps(moderatedImageInfo(record.Id, record.GetString("url"), "", status), record)))
+	}
+
+	return h.listJSON(e, images, map[string]interface{}{
+		"smart_collection": folder,
+		"next_cursor":      next,
+	})
+}
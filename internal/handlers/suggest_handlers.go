@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"generatio-pb/internal/community"
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/suggest"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// suggestHistoryWindow is how many of the user's latest images feed suggestions
+const suggestHistoryWindow = 500
+
+// SuggestPrompts handles GET /api/custom/prompts/suggest?q=&limit=
+// It completes q from the user's prompt history and community prompt
+// templates, ranked by prefix match and frequency
+func (h *Handler) SuggestPrompts(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	query := e.Request.URL.Query().Get("q")
+	limit, _ := strconv.Atoi(e.Request.URL.Query().Get("limit"))
+
+	records, err := h.imageRepo.PromptHistory(user.Id, suggest.Normalize(query), suggestHistoryWindow)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch prompt history")
+	}
+
+	// Every use of a prompt counts towards its frequency
+	counts := make(map[string]int)
+	texts := make(map[string]string)
+	for _, record := range records {
+		prompt := record.GetString("prompt")
+		key := suggest.Normalize(prompt)
+		if _, seen := texts[key]; !seen {
+			texts[key] = prompt
+		}
+		counts[key]++
+	}
+
+	candidates := make([]suggest.Candidate, 0, len(counts))
+	for key, count := range counts {
+		candidates = append(candidates, suggest.Candidate{Text: texts[key], Source: suggest.SourceHistory, Count: count})
+	}
+
+	// Popular community prompts serve as templates
+	if templates, err := h.community.Browse("", query, community.SortPopular, 1); err == nil {
+		for _, template := range templates {
+			candidates = append(candidates, suggest.Candidate{Text: template.Prompt, Source: suggest.SourceTemplate, Count: template.Likes + template.Uses})
+		}
+	}
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"query":       query,
+		"suggestions": suggest.Rank(query, candidates, limit),
+	})
+}
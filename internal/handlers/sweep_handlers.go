@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"generatio-pb/internal/fal"
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/money"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// Sweep limits
+const (
+	maxSweepCells       = 36
+	maxSweepConcurrency = 4
+	// defaultSweepBudget caps a sweep's cost in USD when max_cost is not set
+	defaultSweepBudget = 1.00
+)
+
+// sweepAxes are the parameters a sweep can vary, in matrix order
+var sweepAxes = []string{"guidance_scale", "num_inference_steps", "seed"}
+
+// sweepCell is one planned cell of a sweep matrix
+type sweepCell struct {
+	coordinates []int
+	parameters  map[string]interface{}
+}
+
+// planSweep expands the requested axes into the cells of the matrix in
+// row-major order, validating every cell's parameters against the model
+func planSweep(model fal.ModelInfo, base map[string]interface{}, requested map[string][]float64) ([]localmodels.SweepAxis, []sweepCell, error) {
+	for name := range requested {
+		if !slices.Contains(sweepAxes, name) {
+			return nil, nil, fmt.Errorf("%s cannot be swept; supported axes are guidance_scale, num_inference_steps and seed", name)
+		}
+	}
+
+	var axes []localmodels.SweepAxis
+	total := 1
+	for _, name := range sweepAxes {
+		values := requested[name]
+		if len(values) == 0 {
+			continue
+		}
+		if _, exists := model.Parameters[name]; !exists {
+			return nil, nil, fmt.Errorf("%s does not support %s", model.Name, name)
+		}
+		axes = append(axes, localmodels.SweepAxis{Name: name, Values: values})
+		total *= len(values)
+		if total > maxSweepCells {
+			return nil, nil, fmt.Errorf("a sweep cannot have more than %d cells", maxSweepCells)
+		}
+	}
+	if total < 2 {
+		return nil, nil, fmt.Errorf("a sweep needs at least two cells")
+	}
+
+	cells := make([]sweepCell, 0, total)
+	coordinates := make([]int, len(axes))
+	for {
+		params := make(map[string]interface{}, len(base)+len(axes))
+		for key, value := range base {
+			params[key] = value
+		}
+		for i, axis := range axes {
+			params[axis.Name] = axis.Values[coordinates[i]]
+		}
+		if err := model.ValidateParameters(params); err != nil {
+			return nil, nil, err
+		}
+		cells = append(cells, sweepCell{
+			coordinates: append([]int(nil), coordinates...),
+			parameters:  params,
+		})
+
+		// Advance the last axis first so cells are in row-major order
+		i := len(axes) - 1
+		for ; i >= 0; i-- {
+			coordinates[i]++
+			if coordinates[i] < len(axes[i].Values) {
+				break
+			}
+			coordinates[i] = 0
+		}
+		if i < 0 {
+			return axes, cells, nil
+		}
+	}
+}
+
+// sweepImageCount returns the number of images each cell generates
+func sweepImageCount(params map[string]interface{}) int {
+	if n, ok := params["num_images"].(int); ok && n > 0 {
+		return n
+	}
+	return 1
+}
+
+// GenerateSweep handles POST /api/custom/generate/sweep
+// It generates a grid across guidance_scale, num_inference_steps and seed
+// values, capped by a budget, and returns the matrix with per-cell parameters
+func (h *Handler) GenerateSweep(e *core.RequestEvent) error {
+	var req localmodels.SweepRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	user, _ := h.getAuthenticatedUser(e)
+	req.Model, req.Parameters = h.resolveAlias(user, req.Model, req.Parameters)
+	var err error
+	if req.Model, req.Parameters, _, err = h.migrateModel(e, req.Model, req.Parameters); err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+	model, exists := h.findModel(user, req.Model)
+	if !exists {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, fmt.Sprintf("Unsupported model %q", req.Model))
+	}
+
+	axes, cells, err := planSweep(model, req.Parameters, req.Axes)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	budget := money.FromUSD(req.MaxCost)
+	if budget <= 0 {
+		budget = money.FromUSD(defaultSweepBudget)
+	}
+	cellCost := money.FromUSD(model.CostPerImage).Times(sweepImageCount(cells[0].parameters))
+	if estimate := cellCost.Times(len(cells)); estimate > budget {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, fmt.Sprintf("Estimated cost $%.4f exceeds the budget of $%.4f", estimate.USD(), budget.USD()))
+	}
+
+	caller, handled, err := h.prepareGeneration(e, req.Prompt, req.CollectionID)
+	if handled {
+		return err
+	}
+
+	sweepID := security.RandomString(15)
+	customModel := h.customModel(caller.user, req.Model)
+
+	ctx, cancel := context.WithTimeout(e.Request.Context(), generationTimeout)
+	defer cancel()
+
+	resp := localmodels.SweepResponse{
+		SweepID:      sweepID,
+		Model:        req.Model,
+		Prompt:       req.Prompt,
+		Axes:         axes,
+		Cells:        make([]localmodels.SweepCell, len(cells)),
+		Budget:       budget.USD(),
+		BudgetMicros: budget,
+	}
+
+	// Run the cells with bounded concurrency. Each cell reserves its estimated
+	// cost before it starts so the actual spend never runs past the budget.
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		reserved money.Micros
+	)
+	slots := make(chan struct{}, maxSweepConcurrency)
+	for i, cell := range cells {
+		resp.Cells[i] = localmodels.SweepCell{
+			Coordinates: cell.coordinates,
+			Parameters:  cell.parameters,
+			Images:      []localmodels.GeneratedImageInfo{},
+		}
+
+		wg.Add(1)
+		go func(i int, cell sweepCell) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			mu.Lock()
+			if reserved+cellCost > budget {
+				mu.Unlock()
+				resp.Cells[i].Error = "budget exhausted"
+				return
+			}
+			reserved += cellCost
+			mu.Unlock()
+
+			startTime := time.Now()
+			result, release, err := h.generate(ctx, caller.user.Id, caller.falToken, fal.GenerationRequest{
+				Model:       req.Model,
+				Prompt:      req.Prompt,
+				Parameters:  cell.parameters,
+				Priority:    caller.priority,
+				CustomModel: customModel,
+			})
+			generationTime := time.Since(startTime)
+
+			mu.Lock()
+			if err != nil {
+				reserved -= cellCost
+			} else {
+				// Settle the reservation against what the cell actually cost
+				reserved += money.FromUSD(result.Cost) - cellCost
+			}
+			mu.Unlock()
+
+			resp.Cells[i].GenerationTimeMs = generationTime.Milliseconds()
+			if err != nil {
+				h.logger.Warn("Sweep cell failed", "sweep_id", sweepID, "cell", i, "error", err)
+				classified := describeGenerationError(err)
+				resp.Cells[i].Error = classified.Message
+				resp.Cells[i].ErrorCode = classified.Code
+				resp.Cells[i].Retryable = classified.Retryable
+				return
+			}
+			defer release()
+
+			imageReq := localmodels.GenerateImageRequest{
+				Model:        req.Model,
+				Prompt:       req.Prompt,
+				Parameters:   cell.parameters,
+				CollectionID: req.CollectionID,
+			}
+			group := map[string]interface{}{
+				"id":          sweepID,
+				"kind":        groupKindSweep,
+				"cell":        i,
+				"coordinates": cell.coordinates,
+			}
+
+			images, err := h.saveGeneration(ctx, caller.user, caller.falToken, caller.environment, caller.orgID, imageReq, result, generationTime, &imageLinks{group: group})
+			resp.Cells[i].Cost = result.Cost
+			resp.Cells[i].CostMicros = money.FromUSD(result.Cost)
+			if err != nil {
+				classified := describeGenerationError(err)
+				resp.Cells[i].Error = classified.Message
+				resp.Cells[i].ErrorCode = classified.Code
+				return
+			}
+			resp.Cells[i].Images = images
+		}(i, cell)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, cell := range resp.Cells {
+		if cell.Error == "" {
+			succeeded++
+		}
+		resp.TotalMicros += cell.CostMicros
+	}
+	resp.TotalCost = resp.TotalMicros.USD()
+
+	if succeeded == 0 {
+		return h.errorResponse(e, http.StatusBadGateway, localmodels.ErrCodeExternal, "All sweep cells failed")
+	}
+
+	h.logger.Info("Sweep generated", "sweep_id", sweepID, "user_id", caller.user.Id, "model", req.Model, "cells", len(cells), "succeeded", succeeded, "cost", resp.TotalCost)
+
+	return h.respond(e, http.StatusOK, resp)
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"net/http"
+
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/pagination"
+	"generatio-pb/internal/repository"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// maxListedTrash caps the images returned per page of the trash
+const maxListedTrash = 200
+
+// ownedImage finds the user's image named in the path, either in the trash
+// or not depending on trashed
+func (h *Handler) ownedImage(e *core.RequestEvent, user *core.Record, trashed bool) (*core.Record, bool) {
+	scope := repository.Live
+	if trashed {
+		scope = repository.Trashed
+	}
+	record, err := h.imageRepo.OwnedIn(scope, user.Id, e.Request.PathValue("id"))
+	return record, err == nil
+}
+
+// DeleteImage handles DELETE /api/custom/images/{id}
+// The image moves to the trash, where it can be restored until it is purged
+func (h *Handler) DeleteImage(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	record, found := h.ownedImage(e, user, false)
+	if !found {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Image not found")
+	}
+
+	if err := h.trash.Move(record); err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to delete image")
+	}
+
+	h.logger.Info("Image moved to trash", "image_id", record.Id, "user_id", user.Id)
+
+	return h.respond(e, http.StatusOK, h.trashedImage(record))
+}
+
+// GetTrash handles GET /api/custom/images/trash
+// Query parameters: page and limit, or the cursor from a previous page's
+// next_cursor
+func (h *Handler) GetTrash(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	page, err := pagination.Parse(e.Request.URL.Query(), maxListedTrash)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+
+	records, next, err := h.imageRepo.TrashPage(user.Id, page)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch trash")
+	}
+
+	images := make([]localmodels.TrashedImage, 0, len(records))
+	for _, record := range records {
+		images = append(images, h.trashedImage(record))
+	}
+
+	return h.listJSON(e, images, map[string]interface{}{
+		"retention_days": h.trash.Days(),
+		"next_cursor":    next,
+	})
+}
+
+// RestoreImage handles POST /api/custom/images/{id}/restore
+// Images whose folder was deleted meanwhile are restored to the library root
+func (h *Handler) RestoreImage(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	record, found := h.ownedImage(e, user, true)
+	if !found {
+		return h.errorResponse(e, http.StatusNotFound, localmodels.ErrCodeNotFound, "Image not in trash")
+	}
+
+	if err := h.trash.Restore(record); err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to restore image")
+	}
+
+	h.logger.Info("Image restored from trash", "image_id", record.Id, "user_id", user.Id)
+
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"id":        record.Id,
+		"folder_id": record.GetString("folder_id"),
+		"restored":  true,
+	})
+}
+
+// EmptyTrash handles DELETE /api/custom/images/trash
+// It permanently deletes every image in the user's trash
+func (h *Handler) EmptyTrash(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	report, err := h.trash.Empty(user.Id)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to empty trash")
+	}
+
+	h.logger.Info("Trash emptied", "purged", report.Purged, "user_id", user.Id)
+
+	return h.respond(e, http.StatusOK, report)
+}
+
+// RunTrashPurge handles POST /api/custom/admin/trash/purge
+// It purges images past the trash retention window instead of waiting for the schedule
+func (h *Handler) RunTrashPurge(e *core.RequestEvent) error {
+	if err := h.requireSuperuser(e); err != nil {
+		return h.errorResponse(e, http.StatusForbidden, localmodels.ErrCodeAuthorization, "Superuser access required")
+	}
+
+	report, err := h.trash.Run()
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Trash purge failed")
+	}
+
+	h.logger.Info("Trash purge triggered", "purged", report.Purged, "superuser_id", e.Auth.Id)
+
+	return h.respond(e, http.StatusOK, report)
+}
+
+// trashedImage describes an image in the trash
+func (h *Handler) trashedImage(record *core.Record) localmodels.TrashedImage {
+	return localmodels.TrashedImage{
+		ID:        record.Id,
+		URL:       record.GetString("url"),
+		Title:     record.GetString("title"),
+		Model:     record.GetString("model"),
+		DeletedAt: record.GetDateTime("deleted_at").Time(),
+		PurgeAt:   h.trash.PurgeAt(record),
+	}
+}
@@ -1,10 +1,13 @@
 package handlers
 
 import (
-	"encoding/json"
+	"fmt"
 	"net/http"
 
+	"generatio-pb/internal/currency"
+	"generatio-pb/internal/fal"
 	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/recordjson"
 
 	"github.com/pocketbase/pocketbase/core"
 )
@@ -18,18 +21,7 @@ func (h *Handler) GetFinancialStats(e *core.RequestEvent) error {
 	}
 
 	// Get financial data from user record
-	financialDataRaw := user.Get("financial_data")
-	var financialData localmodels.FinancialData
-	if financialDataRaw != nil {
-		if data, ok := financialDataRaw.(map[string]interface{}); ok {
-			if totalSpent, ok := data["total_spent"].(float64); ok {
-				financialData.TotalSpent = totalSpent
-			}
-			if totalImages, ok := data["total_images"].(float64); ok {
-				financialData.TotalImages = int(totalImages)
-			}
-		}
-	}
+	financialData := userFinancialData(user)
 
 	// Calculate recent spending (last 30 days)
 	recentSpending, err := h.calculateRecentSpending(user.Id, 30)
@@ -40,83 +32,203 @@ func (h *Handler) GetFinancialStats(e *core.RequestEvent) error {
 	// Calculate average cost
 	var averageCost float64
 	if financialData.TotalImages > 0 {
-		averageCost = financialData.TotalSpent / float64(financialData.TotalImages)
+		averageCost = financialData.TotalSpentMicros.USD() / float64(financialData.TotalImages)
 	}
 
 	resp := localmodels.FinancialStatsResponse{
-		TotalSpent:     financialData.TotalSpent,
-		TotalImages:    financialData.TotalImages,
-		RecentSpending: recentSpending,
-		AverageCost:    averageCost,
+		TotalSpent:           financialData.TotalSpentMicros.USD(),
+		TotalImages:          financialData.TotalImages,
+		RecentSpending:       recentSpending.USD(),
+		AverageCost:          averageCost,
+		TotalSpentMicros:     financialData.TotalSpentMicros,
+		RecentSpendingMicros: recentSpending,
+	}
+
+	// Costs are stored in USD and converted for display only
+	if code := displayCurrency(user); code != currency.USD {
+		rate, err := h.currencies.Rate(e.Request.Context(), code)
+		if err != nil {
+			h.logger.Warn("Failed to convert costs for display", "error", err, "currency", code)
+		} else {
+			resp.Display = &localmodels.DisplayCosts{
+				Currency:       code,
+				Rate:           rate,
+				TotalSpent:     currency.Convert(resp.TotalSpent, rate),
+				RecentSpending: currency.Convert(resp.RecentSpending, rate),
+				AverageCost:    currency.Convert(resp.AverageCost, rate),
+			}
+		}
+	}
+
+	return h.respond(e, http.StatusOK, resp)
+}
+
+// ListSpendingAnomalies handles GET /api/custom/financial/anomalies
+// It lists the spending spikes flagged on the user's account
+func (h *Handler) ListSpendingAnomalies(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	anomalies, err := h.anomalies.List(user.Id, 50)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch anomalies")
+	}
+
+	return h.listJSON(e, anomalies, nil)
+}
+
+// displayCurrency returns the user's display currency, USD unless they chose another
+func displayCurrency(user *core.Record) string {
+	if code := user.GetString("display_currency"); code != "" {
+		return code
+	}
+	return currency.USD
+}
+
+// GetDisplayCurrency handles GET /api/custom/financial/currency
+func (h *Handler) GetDisplayCurrency(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	code := displayCurrency(user)
+	resp := map[string]interface{}{"currency": code}
+	if rate, err := h.currencies.Rate(e.Request.Context(), code); err == nil {
+		resp["rate"] = rate
+		if code != currency.USD {
+			resp["rates_updated"] = h.currencies.Updated()
+		}
+	}
+
+	return h.respond(e, http.StatusOK, resp)
+}
+
+// SetDisplayCurrency handles PUT /api/custom/financial/currency
+// Only currencies the exchange rate provider knows can be chosen
+func (h *Handler) SetDisplayCurrency(e *core.RequestEvent) error {
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
+	}
+
+	var req localmodels.DisplayCurrencyRequest
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
+	}
+
+	code, err := currency.Normalize(req.Currency)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, err.Error())
+	}
+	rate, err := h.currencies.Rate(e.Request.Context(), code)
+	if err != nil {
+		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, fmt.Sprintf("Currency %s is not available: %v", code, err))
+	}
+
+	user.Set("display_currency", code)
+	if err := h.app.Save(user); err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to save display currency")
 	}
 
-	return e.JSON(http.StatusOK, resp)
+	return h.respond(e, http.StatusOK, map[string]interface{}{
+		"currency": code,
+		"rate":     rate,
+	})
 }
 
 // GetPreferences handles POST /api/custom/preferences/get
 func (h *Handler) GetPreferences(e *core.RequestEvent) error {
 	var req localmodels.GetPreferencesRequest
-	if err := json.NewDecoder(e.Request.Body).Decode(&req); err != nil {
-		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Invalid request body")
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
 	}
 
-	if req.ModelName == "" {
-		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Model name is required")
+	// Get authenticated user
+	user, err := h.getAuthenticatedUser(e)
+	if err != nil {
+		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
 	}
 
-	// Get authenticated user
+	return h.jsonWithETag(e, http.StatusOK, h.preferencesFor(user, req.ModelName))
+}
+
+// QueryPreferences handles GET /api/custom/preferences
+// Without parameters it returns the preferences saved for every model, so
+// clients load them in one call; ?model_name= answers like
+// POST /api/custom/preferences/get. Both carry an ETag for If-None-Match.
+func (h *Handler) QueryPreferences(e *core.RequestEvent) error {
 	user, err := h.getAuthenticatedUser(e)
 	if err != nil {
 		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
 	}
 
-	// Find user preferences for this model
-	record, err := h.app.FindFirstRecordByFilter(
-		"model_preferences",
-		"model_name = {:model_name}",
-		map[string]any{
-			"model_name": req.ModelName,
-		},
-	)
+	if modelName := e.Request.URL.Query().Get("model_name"); modelName != "" {
+		return h.jsonWithETag(e, http.StatusOK, h.preferencesFor(user, modelName))
+	}
+
+	records, err := h.prefRepo.ForUser(user)
+	if err != nil {
+		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to fetch preferences")
+	}
+
+	resp := localmodels.AllPreferencesResponse{
+		Preferences: make(map[string]map[string]interface{}, len(records)),
+	}
+	for _, record := range records {
+		if prefs := savedPreferences(record); prefs != nil {
+			resp.Preferences[record.GetString("model_name")] = prefs
+		}
+	}
+
+	return h.jsonWithETag(e, http.StatusOK, resp)
+}
+
+// savedPreferences decodes a model_preferences record's preferences, or
+// returns nil when none are stored
+func savedPreferences(record *core.Record) map[string]interface{} {
+	var prefs map[string]interface{}
+	if err := recordjson.Decode(record, "preferences", &prefs); err != nil {
+		return nil
+	}
+	return prefs
+}
 
+// preferencesFor returns the user's saved preferences for a model
+func (h *Handler) preferencesFor(user *core.Record, modelName string) localmodels.PreferencesResponse {
 	resp := localmodels.PreferencesResponse{
-		ModelName:      req.ModelName,
+		ModelName:      modelName,
 		HasPreferences: false,
 		Preferences:    make(map[string]interface{}),
 	}
 
-	if err == nil && record != nil {
-		// Check if this preference record is linked to the current user
-		userPrefs := user.Get("model_preferences")
-		if userPrefs != nil {
-			if prefsList, ok := userPrefs.([]interface{}); ok {
-				for _, prefID := range prefsList {
-					if prefID == record.Id {
-						if prefs := record.Get("preferences"); prefs != nil {
-							if prefsMap, ok := prefs.(map[string]interface{}); ok {
-								resp.Preferences = prefsMap
-								resp.HasPreferences = true
-								break
-							}
-						}
-					}
-				}
-			}
+	if record, err := h.prefRepo.ForModel(user, modelName); err == nil {
+		if prefs := savedPreferences(record); prefs != nil {
+			resp.Preferences = prefs
+			resp.HasPreferences = true
 		}
 	}
 
-	return e.JSON(http.StatusOK, resp)
+	return resp
+}
+
+// mergeParameters layers a generation request's parameters over the user's
+// saved preferences and the model's defaults; values in the request win
+func (h *Handler) mergeParameters(user *core.Record, modelName string, params map[string]interface{}) fal.MergedParameters {
+	var model *fal.ModelInfo
+	if info, exists := h.findModel(user, modelName); exists {
+		model = &info
+	}
+	return fal.MergeParameters(model, h.preferencesFor(user, modelName).Preferences, params)
 }
 
 // SavePreferences handles POST /api/custom/preferences/save
 func (h *Handler) SavePreferences(e *core.RequestEvent) error {
 	var req localmodels.SavePreferencesRequest
-	if err := json.NewDecoder(e.Request.Body).Decode(&req); err != nil {
-		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Invalid request body")
-	}
-
-	if req.ModelName == "" {
-		return h.errorResponse(e, http.StatusBadRequest, localmodels.ErrCodeValidation, "Model name is required")
+	if err := h.decodeJSON(e, &req); err != nil {
+		return h.invalidBodyResponse(e, err)
 	}
 
 	// Get authenticated user
@@ -125,49 +237,21 @@ func (h *Handler) SavePreferences(e *core.RequestEvent) error {
 		return h.errorResponse(e, http.StatusUnauthorized, localmodels.ErrCodeAuth, "Authentication required")
 	}
 
-	// Find existing preferences record for this model
-	record, err := h.app.FindFirstRecordByFilter(
-		"model_preferences",
-		"model_name = {:model_name}",
-		map[string]any{
-			"model_name": req.ModelName,
-		},
-	)
-
-	var isNewRecord bool
+	record, created, err := h.prefRepo.Upsert(user, req.ModelName, req.Preferences)
 	if err != nil {
-		// Create new record
-		collection, err := h.app.FindCollectionByNameOrId("model_preferences")
-		if err != nil {
-			return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to find preferences collection")
-		}
-		record = core.NewRecord(collection)
-		record.Set("model_name", req.ModelName)
-		isNewRecord = true
-	}
-
-	record.Set("preferences", req.Preferences)
-
-	if err := h.app.Save(record); err != nil {
+		h.logger.Error("Failed to save preferences", "error", err, "user_id", user.Id, "model_name", req.ModelName)
 		return h.errorResponse(e, http.StatusInternalServerError, localmodels.ErrCodeInternal, "Failed to save preferences")
 	}
 
 	// If new record, link it to the user
-	if isNewRecord {
-		userPrefs := user.Get("model_preferences")
-		var prefsList []interface{}
-		if userPrefs != nil {
-			if existing, ok := userPrefs.([]interface{}); ok {
-				prefsList = existing
-			}
-		}
-		prefsList = append(prefsList, record.Id)
+	if created {
+		prefsList := append(user.GetStringSlice("model_preferences"), record.Id)
 		user.Set("model_preferences", prefsList)
-		h.app.Save(user) // Update user with new preference link
+		h.userRepo.Save(user) // Update user with new preference link
 	}
 
-	return e.JSON(http.StatusOK, map[string]interface{}{
+	return h.respond(e, http.StatusOK, map[string]interface{}{
 		"success": true,
 		"message": "Preferences saved successfully",
 	})
-}
\ No newline at end of file
+}
@@ -0,0 +1,140 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"generatio-pb/internal/netguard"
+	"generatio-pb/internal/notify"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Delivery headers. The signature is "t=<unix time>,v1=<hex HMAC-SHA256 of
+// "<unix time>.<body>" keyed with the subscription secret>".
+const (
+	SignatureHeader = "X-Generatio-Signature"
+	EventHeader     = "X-Generatio-Event"
+	DeliveryHeader  = "X-Generatio-Delivery"
+)
+
+// Delivery is the JSON body posted to a subscription's target
+type Delivery struct {
+	ID             string                 `json:"id"`
+	Event          string                 `json:"event"`
+	SubscriptionID string                 `json:"subscription_id"`
+	Created        time.Time              `json:"created"`
+	Data           map[string]interface{} `json:"data"`
+}
+
+// Sign returns the signature header value of a delivery body sent at timestamp
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// sender delivers outbox messages of the hook channel. Their target is the
+// subscription ID, so deliveries follow target changes and stop once the
+// subscription is disabled or deleted. Targets are user-supplied, so only
+// public addresses are dialled.
+type sender struct {
+	app        core.App
+	httpClient *http.Client
+}
+
+func newSender(app core.App) *sender {
+	return &sender{
+		app: app,
+		httpClient: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: netguard.NewTransport(),
+		},
+	}
+}
+
+// Send posts the signed delivery and records the outcome on the subscription
+func (s *sender) Send(ctx context.Context, msg notify.Message) error {
+	record, err := s.app.FindRecordById(Collection, msg.Target)
+	if err != nil {
+		return notify.Permanent(fmt.Errorf("subscription was deleted"))
+	}
+	if !record.GetBool("enabled") {
+		return notify.Permanent(fmt.Errorf("subscription is disabled"))
+	}
+
+	now := time.Now().UTC()
+	body, err := json.Marshal(Delivery{
+		ID:             msg.ID,
+		Event:          msg.Event,
+		SubscriptionID: record.Id,
+		Created:        now,
+		Data:           msg.Data,
+	})
+	if err != nil {
+		return notify.Permanent(fmt.Errorf("failed to marshal delivery: %w", err))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, record.GetString("target_url"), bytes.NewReader(body))
+	if err != nil {
+		return s.failed(record, notify.Permanent(fmt.Errorf("failed to create request: %w", err)), "")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, msg.Event)
+	req.Header.Set(DeliveryHeader, msg.ID)
+	req.Header.Set(SignatureHeader, Sign(record.GetString("secret"), now.Unix(), body))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return s.failed(record, fmt.Errorf("failed to send request: %w", err), "")
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusGone:
+		// The receiver asks to be unsubscribed
+		return s.failed(record, notify.Permanent(fmt.Errorf("target returned HTTP 410")), "the target answered 410 Gone")
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		// The body is not kept: last_error is shown to the subscriber
+		return s.failed(record, fmt.Errorf("target returned HTTP %d", resp.StatusCode), "")
+	}
+
+	record.Set("failures", 0)
+	record.Set("last_error", "")
+	record.Set("last_delivery_at", types.NowDateTime())
+	if err := s.app.Save(record); err != nil {
+		s.app.Logger().Error("Failed to update hook subscription", "id", record.Id, "error", err)
+	}
+	return nil
+}
+
+// failed counts a failed attempt and disables the subscription with reason,
+// or after too many failures in a row. It returns sendErr.
+func (s *sender) failed(record *core.Record, sendErr error, reason string) error {
+	failures := record.GetInt("failures") + 1
+	record.Set("failures", failures)
+	record.Set("last_error", sendErr.Error())
+	if reason == "" && failures >= MaxConsecutiveFailures {
+		reason = fmt.Sprintf("%d deliveries in a row failed", failures)
+	}
+	if reason != "" {
+		record.Set("enabled", false)
+		record.Set("disabled_reason", reason)
+		s.app.Logger().Warn("Hook subscription disabled", "id", record.Id, "user_id", record.GetString("user_id"), "reason", reason)
+	}
+
+	if err := s.app.Save(record); err != nil {
+		s.app.Logger().Error("Failed to update hook subscription", "id", record.Id, "error", err)
+	}
+	return sendErr
+}
@@ -0,0 +1,309 @@
+package hooks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"generatio-pb/internal/netguard"
+	"generatio-pb/internal/notify"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Collection stores REST hook subscriptions
+const Collection = "hook_subscriptions"
+
+// Channel is the notification channel signed hook deliveries go through
+const Channel = "hook"
+
+// Events a subscription can be made to
+const (
+	EventImageCreated   = "image.created"
+	EventJobFailed      = "job.failed"
+	EventBudgetExceeded = "budget.exceeded"
+)
+
+// Events lists every subscribable event
+var Events = []string{EventImageCreated, EventJobFailed, EventBudgetExceeded}
+
+const (
+	// MaxSubscriptionsPerUser caps the subscriptions one user can hold
+	MaxSubscriptionsPerUser = 25
+
+	// MaxConsecutiveFailures is how many failed delivery attempts in a row
+	// disable a subscription
+	MaxConsecutiveFailures = 10
+)
+
+// SecretPrefix marks a string as a hook signing secret
+const SecretPrefix = "whsec_"
+
+// ErrNotFound is returned for subscriptions that do not exist or belong to
+// another user
+var ErrNotFound = errors.New("subscription not found")
+
+// Subscription sends one event of a user's to a URL
+type Subscription struct {
+	ID             string     `json:"id"`
+	Event          string     `json:"event"`
+	TargetURL      string     `json:"target_url"`
+	Enabled        bool       `json:"enabled"`
+	Failures       int        `json:"failures"`
+	LastError      string     `json:"last_error,omitempty"`
+	DisabledReason string     `json:"disabled_reason,omitempty"`
+	LastDeliveryAt *time.Time `json:"last_delivery_at,omitempty"`
+	Created        time.Time  `json:"created"`
+
+	// Secret signs the deliveries; it is only returned on creation
+	Secret string `json:"secret,omitempty"`
+}
+
+// Update holds the changes to a subscription; nil fields are left alone
+type Update struct {
+	TargetURL *string
+	Enabled   *bool
+}
+
+// Service stores subscriptions and publishes events to them through the
+// notification outbox, which retries failed deliveries
+type Service struct {
+	app      core.App
+	notifier *notify.Service
+	sender   *sender
+}
+
+// NewService creates the REST hook service and registers its sender with
+// the notifier. Every image saved for a user is published as image.created.
+func NewService(app core.App, notifier *notify.Service) *Service {
+	s := &Service{app: app, notifier: notifier, sender: newSender(app)}
+	notifier.SetSender(Channel, s.sender)
+
+	app.OnRecordAfterCreateSuccess("images").BindFunc(func(e *core.RecordEvent) error {
+		if userID := e.Record.GetString("user_id"); userID != "" {
+			if err := s.Publish(userID, EventImageCreated, "Image created", imageData(e.Record)); err != nil {
+				app.Logger().Error("Failed to publish image.created", "error", err, "image_id", e.Record.Id)
+			}
+		}
+		return e.Next()
+	})
+
+	return s
+}
+
+// SetAllowPrivateHosts lets deliveries reach loopback and private addresses,
+// for receivers on the server's own network. By default only public
+// addresses are dialled, since targets are user-supplied.
+func (s *Service) SetAllowPrivateHosts(allow bool) {
+	if allow {
+		s.sender.httpClient.Transport = http.DefaultTransport
+	} else {
+		s.sender.httpClient.Transport = netguard.NewTransport()
+	}
+}
+
+// ValidateTargetURL checks that rawURL can receive deliveries. Hosts naming
+// a non-public address are refused here; names resolving to one are refused
+// when a delivery dials them.
+func ValidateTargetURL(rawURL string) error {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" || parsed.User != nil {
+		return fmt.Errorf("target_url must be an https URL")
+	}
+	if netguard.CheckHost(parsed.Hostname()) != nil {
+		return fmt.Errorf("target_url must be a public address")
+	}
+	return nil
+}
+
+// Subscribe sends event to targetURL for userID. The returned subscription
+// carries the signing secret, which is not shown again.
+func (s *Service) Subscribe(userID, event, targetURL string) (*Subscription, error) {
+	if !slices.Contains(Events, event) {
+		return nil, fmt.Errorf("unknown event %q (valid: %s)", event, strings.Join(Events, ", "))
+	}
+	if err := ValidateTargetURL(targetURL); err != nil {
+		return nil, err
+	}
+
+	existing, err := s.userSubscriptions(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= MaxSubscriptionsPerUser {
+		return nil, fmt.Errorf("you cannot hold more than %d subscriptions", MaxSubscriptionsPerUser)
+	}
+
+	collection, err := s.app.FindCollectionByNameOrId(Collection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find subscriptions collection: %w", err)
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("user_id", userID)
+	record.Set("event", event)
+	record.Set("target_url", targetURL)
+	record.Set("secret", SecretPrefix+hex.EncodeToString(secret))
+	record.Set("enabled", true)
+	record.Set("failures", 0)
+	if err := s.app.Save(record); err != nil {
+		return nil, fmt.Errorf("failed to save subscription: %w", err)
+	}
+
+	subscription := fromRecord(record)
+	subscription.Secret = record.GetString("secret")
+	return subscription, nil
+}
+
+// List returns a user's subscriptions, oldest first
+func (s *Service) List(userID string) ([]*Subscription, error) {
+	records, err := s.userSubscriptions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	subscriptions := make([]*Subscription, 0, len(records))
+	for _, record := range records {
+		subscriptions = append(subscriptions, fromRecord(record))
+	}
+	return subscriptions, nil
+}
+
+// Get returns one of the user's subscriptions
+func (s *Service) Get(id, userID string) (*Subscription, error) {
+	record, err := s.find(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	return fromRecord(record), nil
+}
+
+// Update changes a subscription's target or turns it on or off. Enabling a
+// subscription clears its failures.
+func (s *Service) Update(id, userID string, update Update) (*Subscription, error) {
+	record, err := s.find(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.TargetURL != nil {
+		if err := ValidateTargetURL(*update.TargetURL); err != nil {
+			return nil, err
+		}
+		record.Set("target_url", *update.TargetURL)
+	}
+	if update.Enabled != nil {
+		record.Set("enabled", *update.Enabled)
+		if *update.Enabled {
+			record.Set("failures", 0)
+			record.Set("last_error", "")
+			record.Set("disabled_reason", "")
+		} else {
+			record.Set("disabled_reason", "disabled by the user")
+		}
+	}
+
+	if err := s.app.Save(record); err != nil {
+		return nil, fmt.Errorf("failed to save subscription: %w", err)
+	}
+	return fromRecord(record), nil
+}
+
+// Delete removes one of the user's subscriptions. Deliveries still queued
+// for it are dropped.
+func (s *Service) Delete(id, userID string) error {
+	record, err := s.find(id, userID)
+	if err != nil {
+		return err
+	}
+	return s.app.Delete(record)
+}
+
+// Publish queues a signed delivery of data to each of the user's enabled
+// subscriptions to event. The summary is kept as the outbox subject.
+func (s *Service) Publish(userID, event, summary string, data map[string]interface{}) error {
+	records, err := s.userSubscriptions(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		if record.GetString("event") != event || !record.GetBool("enabled") {
+			continue
+		}
+		err := s.notifier.Enqueue(notify.Message{
+			UserID:  userID,
+			Channel: Channel,
+			Target:  record.Id,
+			Event:   event,
+			Subject: summary,
+			Data:    data,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) find(id, userID string) (*core.Record, error) {
+	record, err := s.app.FindRecordById(Collection, id)
+	if err != nil || record.GetString("user_id") != userID {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+func (s *Service) userSubscriptions(userID string) ([]*core.Record, error) {
+	// Deployments without the collection have no subscriptions
+	if _, err := s.app.FindCollectionByNameOrId(Collection); err != nil {
+		return nil, nil
+	}
+
+	records, err := s.app.FindRecordsByFilter(Collection, "user_id = {:user_id}", "created", 0, 0, map[string]any{"user_id": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch subscriptions: %w", err)
+	}
+	return records, nil
+}
+
+// imageData is the image.created payload
+func imageData(record *core.Record) map[string]interface{} {
+	return map[string]interface{}{
+		"id":        record.Id,
+		"url":       record.GetString("url"),
+		"prompt":    record.GetString("prompt"),
+		"model":     record.GetString("model"),
+		"folder_id": record.GetString("folder_id"),
+		"created":   record.GetDateTime("created").Time(),
+	}
+}
+
+func fromRecord(record *core.Record) *Subscription {
+	subscription := &Subscription{
+		ID:             record.Id,
+		Event:          record.GetString("event"),
+		TargetURL:      record.GetString("target_url"),
+		Enabled:        record.GetBool("enabled"),
+		Failures:       record.GetInt("failures"),
+		LastError:      record.GetString("last_error"),
+		DisabledReason: record.GetString("disabled_reason"),
+		Created:        record.GetDateTime("created").Time(),
+	}
+	if lastDelivery := record.GetDateTime("last_delivery_at"); !lastDelivery.IsZero() {
+		t := lastDelivery.Time()
+		subscription.LastDeliveryAt = &t
+	}
+	return subscription
+}
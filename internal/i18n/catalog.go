@@ -0,0 +1,76 @@
+package i18n
+
+import (
+	"generatio-pb/internal/fal"
+	localmodels "generatio-pb/internal/models"
+)
+
+// catalogs maps each supported locale to its messages by error code. The
+// default locale has no entries: handlers already write English messages,
+// which are more specific than a per-code translation can be.
+var catalogs = map[string]map[string]string{
+	DefaultLocale: {},
+	"de": {
+		localmodels.ErrCodeValidation:    "Die Anfrage ist ungültig.",
+		localmodels.ErrCodeAuth:          "Anmeldung erforderlich.",
+		localmodels.ErrCodeAuthorization: "Dafür fehlt dir die Berechtigung.",
+		localmodels.ErrCodeNotFound:      "Die Ressource wurde nicht gefunden.",
+		localmodels.ErrCodeInternal:      "Ein interner Fehler ist aufgetreten.",
+		localmodels.ErrCodeExternal:      "Ein externer Dienst hat einen Fehler gemeldet.",
+		localmodels.ErrCodeRateLimit:     "Zu viele Anfragen. Bitte versuche es später erneut.",
+		localmodels.ErrCodeQuota:         "Dein Kontingent ist aufgebraucht.",
+		localmodels.ErrCodeUnavailable:   "Der Dienst ist vorübergehend nicht verfügbar.",
+		localmodels.ErrCodeContentPolicy: "Der Inhalt verstößt gegen die Inhaltsrichtlinie.",
+		localmodels.ErrCodeInvalidFolder: "In diesen Ordner kann nicht gespeichert werden.",
+		fal.ErrorCodeInvalidRequest:      "Das Modell hat die Anfrage abgelehnt.",
+		fal.ErrorCodeAuth:                "Der FAL-AI-Token wurde abgelehnt.",
+		fal.ErrorCodeRateLimited:         "FAL AI drosselt die Anfragen. Bitte versuche es später erneut.",
+		fal.ErrorCodeUnavailable:         "FAL AI ist nicht erreichbar.",
+		fal.ErrorCodeTimeout:             "Die Generierung wurde nicht rechtzeitig fertig.",
+		fal.ErrorCodeGenerationFailed:    "Die Generierung ist fehlgeschlagen.",
+		fal.ErrorCodeCancelled:           "Die Generierung wurde abgebrochen.",
+		fal.ErrorCodeUnknown:             "Bei FAL AI ist ein Fehler aufgetreten.",
+	},
+	"es": {
+		localmodels.ErrCodeValidation:    "La solicitud no es válida.",
+		localmodels.ErrCodeAuth:          "Se requiere autenticación.",
+		localmodels.ErrCodeAuthorization: "No tienes permiso para hacer esto.",
+		localmodels.ErrCodeNotFound:      "No se encontró el recurso.",
+		localmodels.ErrCodeInternal:      "Se produjo un error interno.",
+		localmodels.ErrCodeExternal:      "Un servicio externo devolvió un error.",
+		localmodels.ErrCodeRateLimit:     "Demasiadas solicitudes. Inténtalo de nuevo más tarde.",
+		localmodels.ErrCodeQuota:         "Has agotado tu cuota.",
+		localmodels.ErrCodeUnavailable:   "El servicio no está disponible temporalmente.",
+		localmodels.ErrCodeContentPolicy: "El contenido infringe la política de contenido.",
+		localmodels.ErrCodeInvalidFolder: "No se puede guardar en esta carpeta.",
+		fal.ErrorCodeInvalidRequest:      "El modelo rechazó la solicitud.",
+		fal.ErrorCodeAuth:                "FAL AI rechazó el token.",
+		fal.ErrorCodeRateLimited:         "FAL AI está limitando las solicitudes. Inténtalo de nuevo más tarde.",
+		fal.ErrorCodeUnavailable:         "No se pudo contactar con FAL AI.",
+		fal.ErrorCodeTimeout:             "La generación no terminó a tiempo.",
+		fal.ErrorCodeGenerationFailed:    "La generación falló.",
+		fal.ErrorCodeCancelled:           "La generación se canceló.",
+		fal.ErrorCodeUnknown:             "FAL AI devolvió un error.",
+	},
+	"fr": {
+		localmodels.ErrCodeValidation:    "La requête n'est pas valide.",
+		localmodels.ErrCodeAuth:          "Authentification requise.",
+		localmodels.ErrCodeAuthorization: "Vous n'avez pas l'autorisation de faire cela.",
+		localmodels.ErrCodeNotFound:      "La ressource est introuvable.",
+		localmodels.ErrCodeInternal:      "Une erreur interne s'est produite.",
+		localmodels.ErrCodeExternal:      "Un service externe a renvoyé une erreur.",
+		localmodels.ErrCodeRateLimit:     "Trop de requêtes. Veuillez réessayer plus tard.",
+		localmodels.ErrCodeQuota:         "Votre quota est épuisé.",
+		localmodels.ErrCodeUnavailable:   "Le service est temporairement indisponible.",
+		localmodels.ErrCodeContentPolicy: "Le contenu enfreint la politique de contenu.",
+		localmodels.ErrCodeInvalidFolder: "Impossible d'enregistrer dans ce dossier.",
+		fal.ErrorCodeInvalidRequest:      "Le modèle a refusé la requête.",
+		fal.ErrorCodeAuth:                "FAL AI a refusé le jeton.",
+		fal.ErrorCodeRateLimited:         "FAL AI limite les requêtes. Veuillez réessayer plus tard.",
+		fal.ErrorCodeUnavailable:         "FAL AI est injoignable.",
+		fal.ErrorCodeTimeout:             "La génération ne s'est pas terminée à temps.",
+		fal.ErrorCodeGenerationFailed:    "La génération a échoué.",
+		fal.ErrorCodeCancelled:           "La génération a été annulée.",
+		fal.ErrorCodeUnknown:             "FAL AI a renvoyé une erreur.",
+	},
+}
@@ -0,0 +1,75 @@
+// Package i18n translates user-facing error messages. Messages are keyed by
+// error code, so clients keep matching on the stable code while people read
+// the message in the language their Accept-Language header asks for.
+package i18n
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLocale is the locale handlers write their messages in
+const DefaultLocale = "en"
+
+// Negotiate picks the supported locale an Accept-Language header prefers,
+// honouring q-values and falling back from regional tags such as de-AT to
+// their language. It returns DefaultLocale when nothing else matches.
+func Negotiate(acceptLanguage string) string {
+	type preference struct {
+		tag     string
+		quality float64
+	}
+
+	var preferences []preference
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality > 0 {
+			preferences = append(preferences, preference{tag, quality})
+		}
+	}
+	sort.SliceStable(preferences, func(i, j int) bool {
+		return preferences[i].quality > preferences[j].quality
+	})
+
+	for _, p := range preferences {
+		if p.tag == "*" {
+			return DefaultLocale
+		}
+		language, _, _ := strings.Cut(p.tag, "-")
+		if _, ok := catalogs[language]; ok {
+			return language
+		}
+	}
+	return DefaultLocale
+}
+
+// Message returns the message for an error code in a locale. It reports
+// false for the default locale, whose messages are the handlers' own, and
+// for codes the locale has no translation of.
+func Message(locale, code string) (string, bool) {
+	message, ok := catalogs[locale][code]
+	return message, ok
+}
+
+// Supported lists the locales Negotiate can select, in sorted order
+func Supported() []string {
+	locales := make([]string, 0, len(catalogs))
+	for locale := range catalogs {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
@@ -0,0 +1,405 @@
+package imagecache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"generatio-pb/internal/netguard"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// MaxImageBytes caps the size of a single cached image
+const MaxImageBytes = 50 << 20
+
+// Cache stores upstream image files on disk so they can be served after the
+// FAL URLs expire. Files are content-addressed: each blob is stored once under
+// its SHA-256 hash and image records reference it through content_hash, so
+// identical images (e.g. a batch with a repeated seed) share storage. A blob is
+// removed when the last record referencing it is deleted.
+type Cache struct {
+	app        core.App
+	dir        string
+	httpClient *http.Client
+
+	sizes   []int
+	formats []string
+
+	mutex sync.Mutex
+	locks map[string]*sync.Mutex
+
+	queue    chan string
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	started  bool
+	stopOnce sync.Once
+}
+
+// Entry is an open cached image
+type Entry struct {
+	File        *os.File
+	ContentType string
+	ModTime     time.Time
+}
+
+// Report summarises how much storage deduplication saves
+type Report struct {
+	Images       int   `json:"images"`
+	UniqueBlobs  int   `json:"unique_blobs"`
+	LogicalBytes int64 `json:"logical_bytes"`
+	StoredBytes  int64 `json:"stored_bytes"`
+	SavedBytes   int64 `json:"saved_bytes"`
+}
+
+// NewCache creates a cache in dir (defaults to <data dir>/image_cache)
+func NewCache(app core.App, dir string) *Cache {
+	if dir == "" {
+		dir = filepath.Join(app.DataDir(), "image_cache")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	c := &Cache{
+		app: app,
+		dir: dir,
+		httpClient: &http.Client{
+			Timeout:   60 * time.Second,
+			Transport: netguard.NewTransport(),
+		},
+		locks:  make(map[string]*sync.Mutex),
+		queue:  make(chan string, 256),
+		ctx:    ctx,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	app.OnRecordAfterDeleteSuccess("images").BindFunc(func(e *core.RecordEvent) error {
+		c.release(e.Record.GetString("content_hash"))
+		return e.Next()
+	})
+
+	return c
+}
+
+// SetAllowPrivateHosts lets the cache fetch from loopback and private
+// addresses, for origins on the server's own network. By default only
+// public addresses are fetched, since image URLs can be user-supplied.
+func (c *Cache) SetAllowPrivateHosts(allow bool) {
+	if allow {
+		c.httpClient.Transport = http.DefaultTransport
+	} else {
+		c.httpClient.Transport = netguard.NewTransport()
+	}
+}
+
+// Start begins the background worker that persists newly generated images and their variants
+func (c *Cache) Start() {
+	c.started = true
+	go c.run()
+	log.Printf("Image cache worker started")
+}
+
+// Stop cancels any in-flight download and waits for the worker to exit
+func (c *Cache) Stop() {
+	c.stopOnce.Do(func() {
+		c.cancel()
+		if c.started {
+			<-c.done
+		}
+	})
+}
+
+// Enqueue schedules an image to be downloaded and hashed in the background.
+// When the queue is full the image is persisted lazily on first access instead.
+func (c *Cache) Enqueue(imageID string) {
+	select {
+	case c.queue <- imageID:
+	default:
+	}
+}
+
+func (c *Cache) run() {
+	defer close(c.done)
+
+	for {
+		select {
+		case id := <-c.queue:
+			if c.ctx.Err() != nil {
+				return
+			}
+
+			record, err := c.app.FindRecordById("images", id)
+			if err != nil {
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(c.ctx, 2*time.Minute)
+			if err := c.Persist(ctx, record); err != nil {
+				if c.ctx.Err() == nil {
+					c.app.Logger().Warn("Failed to persist image file", "error", err, "image_id", id)
+				}
+			} else if err := c.ensureVariants(record.GetString("content_hash")); err != nil {
+				c.app.Logger().Warn("Failed to generate image variants", "error", err, "image_id", id)
+			}
+			cancel()
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// Open returns the cached file for an image, persisting it first on a miss
+func (c *Cache) Open(ctx context.Context, record *core.Record) (*Entry, error) {
+	if entry, err := c.openBlob(record.GetString("content_hash")); err == nil {
+		return entry, nil
+	}
+
+	if err := c.Persist(ctx, record); err != nil {
+		return nil, err
+	}
+	return c.openBlob(record.GetString("content_hash"))
+}
+
+// Persist downloads the image, stores it under its content hash and records
+// the hash and size on the image record. Already persisted images are skipped.
+func (c *Cache) Persist(ctx context.Context, record *core.Record) error {
+	lock := c.lockFor(record.Id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if hash := record.GetString("content_hash"); hash != "" && c.hasBlob(hash) {
+		return nil
+	}
+
+	hash, size, err := c.fetch(ctx, record.GetString("url"))
+	if err != nil {
+		return err
+	}
+
+	record.Set("content_hash", hash)
+	record.Set("content_size", size)
+	if err := c.app.Save(record); err != nil {
+		return fmt.Errorf("failed to save content hash: %w", err)
+	}
+	return nil
+}
+
+// Report computes deduplication savings across every persisted image that
+// is not in the trash
+func (c *Cache) Report() (*Report, error) {
+	records, err := c.app.FindRecordsByFilter("images", "content_hash != '' && deleted_at = null", "", 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch images: %w", err)
+	}
+
+	report := &Report{}
+	seen := make(map[string]bool)
+	for _, record := range records {
+		size := int64(record.GetInt("content_size"))
+		report.Images++
+		report.LogicalBytes += size
+
+		hash := record.GetString("content_hash")
+		if !seen[hash] {
+			seen[hash] = true
+			report.UniqueBlobs++
+			report.StoredBytes += size
+		}
+	}
+	report.SavedBytes = report.LogicalBytes - report.StoredBytes
+
+	return report, nil
+}
+
+// release removes a blob once no image record references it. Trashed images
+// still count, since restoring one needs its blob.
+func (c *Cache) release(hash string) {
+	if !validHash(hash) {
+		return
+	}
+
+	lock := c.lockFor(hash)
+	lock.Lock()
+	defer lock.Unlock()
+
+	remaining, err := c.app.CountRecords("images", dbx.HashExp{"content_hash": hash})
+	if err != nil || remaining > 0 {
+		return
+	}
+
+	os.Remove(c.blobPath(hash))
+	os.Remove(c.blobPath(hash) + ".type")
+	os.RemoveAll(filepath.Join(c.dir, "variants", hash))
+}
+
+func (c *Cache) openBlob(hash string) (*Entry, error) {
+	if !validHash(hash) {
+		return nil, fmt.Errorf("invalid content hash")
+	}
+
+	file, err := os.Open(c.blobPath(hash))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	contentType, _ := os.ReadFile(c.blobPath(hash) + ".type")
+
+	return &Entry{
+		File:        file,
+		ContentType: string(contentType),
+		ModTime:     info.ModTime(),
+	}, nil
+}
+
+func (c *Cache) hasBlob(hash string) bool {
+	if !validHash(hash) {
+		return false
+	}
+	_, err := os.Stat(c.blobPath(hash))
+	return err == nil
+}
+
+// fetch downloads the upstream file into the blob store. The upstream
+// Content-Type is ignored: cached files are served from our own origin, so
+// only content that sniffs as an image is kept, under its sniffed type.
+func (c *Cache) fetch(ctx context.Context, sourceURL string) (string, int64, error) {
+	if sourceURL == "" {
+		return "", 0, fmt.Errorf("image has no source URL")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sourceURL, nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to fetch image: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("failed to fetch image: HTTP %d", resp.StatusCode)
+	}
+
+	return c.storeBlob(resp.Body)
+}
+
+// Store saves uploaded image content and records its hash and size on the
+// image record. Content that does not sniff as an image is rejected.
+func (c *Cache) Store(record *core.Record, r io.Reader) error {
+	hash, size, err := c.storeBlob(r)
+	if err != nil {
+		return err
+	}
+
+	record.Set("content_hash", hash)
+	record.Set("content_size", size)
+	return nil
+}
+
+// storeBlob hashes content while writing it to a temporary file and moves it
+// into place unless an identical blob already exists. Content that does not
+// sniff as an image is rejected; the sniffed type is stored with the blob.
+func (c *Cache) storeBlob(r io.Reader) (string, int64, error) {
+	blobDir := filepath.Join(c.dir, "blobs")
+	if err := os.MkdirAll(blobDir, 0o755); err != nil {
+		return "", 0, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(blobDir, "download.*.tmp")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hasher), io.LimitReader(r, MaxImageBytes+1))
+	tmp.Close()
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read image: %w", err)
+	}
+	if written > MaxImageBytes {
+		return "", 0, fmt.Errorf("image exceeds %d bytes", MaxImageBytes)
+	}
+
+	contentType := detectContentType(tmp.Name())
+	if !strings.HasPrefix(contentType, "image/") {
+		return "", 0, fmt.Errorf("unsupported file type: %s", contentType)
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	lock := c.lockFor(hash)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if c.hasBlob(hash) {
+		return hash, written, nil
+	}
+
+	if err := os.WriteFile(c.blobPath(hash)+".type", []byte(contentType), 0o644); err != nil {
+		return "", 0, fmt.Errorf("failed to write cache metadata: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.blobPath(hash)); err != nil {
+		return "", 0, fmt.Errorf("failed to store cache file: %w", err)
+	}
+	return hash, written, nil
+}
+
+func (c *Cache) blobPath(hash string) string {
+	return filepath.Join(c.dir, "blobs", hash)
+}
+
+// lockFor serialises work on the same image id or blob hash
+func (c *Cache) lockFor(key string) *sync.Mutex {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	lock, exists := c.locks[key]
+	if !exists {
+		lock = &sync.Mutex{}
+		c.locks[key] = lock
+	}
+	return lock
+}
+
+// detectContentType sniffs the first bytes of a downloaded file
+func detectContentType(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	defer file.Close()
+
+	header := make([]byte, 512)
+	n, _ := io.ReadFull(file, header)
+	return http.DetectContentType(header[:n])
+}
+
+// validHash guards the cache directory against path traversal
+func validHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
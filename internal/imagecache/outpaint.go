@@ -0,0 +1,90 @@
+package imagecache
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+
+	"github.com/disintegration/imaging"
+)
+
+// Outpainting canvas limits in pixels
+const (
+	MaxCanvasSide = 2048
+	MaxPadding    = 1024
+)
+
+// Padding is how many pixels to extend an image by on each side
+type Padding struct {
+	Left   int `json:"left"`
+	Right  int `json:"right"`
+	Top    int `json:"top"`
+	Bottom int `json:"bottom"`
+}
+
+// Validate checks the padding itself; canvas limits are checked by Pad
+// once the source dimensions are known
+func (p Padding) Validate() error {
+	for _, side := range []int{p.Left, p.Right, p.Top, p.Bottom} {
+		if side < 0 || side > MaxPadding {
+			return fmt.Errorf("padding must be between 0 and %d pixels per side", MaxPadding)
+		}
+	}
+	if p.Left+p.Right+p.Top+p.Bottom == 0 {
+		return fmt.Errorf("at least one side must be extended")
+	}
+	return nil
+}
+
+// Canvas is a padded image ready for a fill model: the mask is white where
+// the model should paint and black over the original pixels
+type Canvas struct {
+	Image  []byte
+	Mask   []byte
+	Width  int
+	Height int
+}
+
+// paddingColor fills the new area so the model sees a neutral background
+var paddingColor = color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+
+// Pad places the image read from r on a larger canvas and builds its mask.
+// Both are PNG encoded.
+func Pad(r io.Reader, padding Padding) (*Canvas, error) {
+	if err := padding.Validate(); err != nil {
+		return nil, err
+	}
+
+	src, err := imaging.Decode(r, imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	bounds := src.Bounds()
+	width := bounds.Dx() + padding.Left + padding.Right
+	height := bounds.Dy() + padding.Top + padding.Bottom
+	if width > MaxCanvasSide || height > MaxCanvasSide {
+		return nil, fmt.Errorf("extended canvas %dx%d exceeds the %dx%d limit", width, height, MaxCanvasSide, MaxCanvasSide)
+	}
+
+	offset := image.Pt(padding.Left, padding.Top)
+	canvas := imaging.Paste(imaging.New(width, height, paddingColor), src, offset)
+	mask := imaging.Paste(imaging.New(width, height, color.White), imaging.New(bounds.Dx(), bounds.Dy(), color.Black), offset)
+
+	var canvasPNG, maskPNG bytes.Buffer
+	if err := imaging.Encode(&canvasPNG, canvas, imaging.PNG); err != nil {
+		return nil, fmt.Errorf("failed to encode canvas: %w", err)
+	}
+	if err := imaging.Encode(&maskPNG, mask, imaging.PNG); err != nil {
+		return nil, fmt.Errorf("failed to encode mask: %w", err)
+	}
+
+	return &Canvas{
+		Image:  canvasPNG.Bytes(),
+		Mask:   maskPNG.Bytes(),
+		Width:  width,
+		Height: height,
+	}, nil
+}
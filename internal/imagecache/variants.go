@@ -0,0 +1,203 @@
+package imagecache
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+	"github.com/pocketbase/pocketbase/core"
+	_ "golang.org/x/image/webp" // Decode WebP sources
+)
+
+// Variant output formats with a pure-Go encoder
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+)
+
+// encoders maps supported variant formats to their imaging encoder and MIME type
+var encoders = map[string]struct {
+	format      imaging.Format
+	contentType string
+}{
+	FormatJPEG: {imaging.JPEG, "image/jpeg"},
+	FormatPNG:  {imaging.PNG, "image/png"},
+}
+
+// SetVariants configures the resized variants generated for every image.
+// Formats without an available encoder (e.g. webp, avif) are skipped.
+func (c *Cache) SetVariants(sizes []int, formats []string) {
+	c.sizes = nil
+	for _, size := range sizes {
+		if size > 0 && size <= 4096 {
+			c.sizes = append(c.sizes, size)
+		}
+	}
+
+	c.formats = nil
+	for _, format := range formats {
+		if _, supported := encoders[format]; !supported {
+			c.app.Logger().Warn("Skipping unsupported thumbnail format", "format", format)
+			continue
+		}
+		c.formats = append(c.formats, format)
+	}
+}
+
+// Variants returns variant paths for an image keyed by format and then size,
+// ready for building srcset attributes
+func (c *Cache) Variants(imageID string) map[string]map[string]string {
+	if len(c.sizes) == 0 || len(c.formats) == 0 {
+		return nil
+	}
+
+	variants := make(map[string]map[string]string, len(c.formats))
+	for _, format := range c.formats {
+		bySize := make(map[string]string, len(c.sizes))
+		for _, size := range c.sizes {
+			bySize[strconv.Itoa(size)] = fmt.Sprintf("/api/custom/images/%s/file?size=%d&format=%s", imageID, size, format)
+		}
+		variants[format] = bySize
+	}
+	return variants
+}
+
+// HasVariant reports whether size and format are configured
+func (c *Cache) HasVariant(size int, format string) bool {
+	sizeOK := false
+	for _, s := range c.sizes {
+		if s == size {
+			sizeOK = true
+		}
+	}
+	for _, f := range c.formats {
+		if f == format {
+			return sizeOK
+		}
+	}
+	return false
+}
+
+// SmallestSize returns the smallest configured variant size, or 0 without variants
+func (c *Cache) SmallestSize() int {
+	smallest := 0
+	for _, size := range c.sizes {
+		if smallest == 0 || size < smallest {
+			smallest = size
+		}
+	}
+	return smallest
+}
+
+// DefaultFormat returns the first configured variant format
+func (c *Cache) DefaultFormat() string {
+	if len(c.formats) == 0 {
+		return ""
+	}
+	return c.formats[0]
+}
+
+// OpenVariant returns a resized variant of an image, creating it on a miss
+func (c *Cache) OpenVariant(ctx context.Context, record *core.Record, size int, format string) (*Entry, error) {
+	if !c.HasVariant(size, format) {
+		return nil, fmt.Errorf("unsupported variant")
+	}
+
+	entry, err := c.Open(ctx, record)
+	if err != nil {
+		return nil, err
+	}
+	entry.File.Close()
+
+	hash := record.GetString("content_hash")
+	if err := c.ensureVariant(hash, size, format); err != nil {
+		return nil, err
+	}
+
+	path := c.variantPath(hash, size, format)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &Entry{
+		File:        file,
+		ContentType: encoders[format].contentType,
+		ModTime:     info.ModTime(),
+	}, nil
+}
+
+// ensureVariants generates every configured variant for a blob
+func (c *Cache) ensureVariants(hash string) error {
+	for _, format := range c.formats {
+		for _, size := range c.sizes {
+			if err := c.ensureVariant(hash, size, format); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ensureVariant resizes the blob to fit within size x size, never upscaling
+func (c *Cache) ensureVariant(hash string, size int, format string) error {
+	if !validHash(hash) {
+		return fmt.Errorf("invalid content hash")
+	}
+
+	path := c.variantPath(hash, size, format)
+
+	lock := c.lockFor(path)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	src, err := imaging.Open(c.blobPath(hash), imaging.AutoOrientation(true))
+	if err != nil {
+		return fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	var resized image.Image = src
+	bounds := src.Bounds()
+	if bounds.Dx() > size || bounds.Dy() > size {
+		resized = imaging.Fit(src, size, size, imaging.Lanczos)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create variant directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "variant.*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create variant file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	err = imaging.Encode(tmp, resized, encoders[format].format, imaging.JPEGQuality(85))
+	tmp.Close()
+	if err != nil {
+		return fmt.Errorf("failed to encode variant: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to store variant: %w", err)
+	}
+	return nil
+}
+
+func (c *Cache) variantPath(hash string, size int, format string) string {
+	return filepath.Join(c.dir, "variants", hash, fmt.Sprintf("%d.%s", size, format))
+}
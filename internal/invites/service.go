@@ -0,0 +1,261 @@
+package invites
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"generatio-pb/internal/folderacl"
+	"generatio-pb/internal/orgs"
+	"generatio-pb/internal/repository"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Collection stores invitations; only a hash of each accept token is kept
+const Collection = "invitations"
+
+// What an invitation grants access to
+const (
+	KindFolder = "folder"
+	KindOrg    = "org"
+)
+
+// Invitation states. Expired is reported for pending invitations past
+// expires_at and is never stored.
+const (
+	StatusPending  = "pending"
+	StatusAccepted = "accepted"
+	StatusRevoked  = "revoked"
+	StatusExpired  = "expired"
+)
+
+// DefaultTTL is how long an invitation can be accepted
+const DefaultTTL = 7 * 24 * time.Hour
+
+// Invitation is a pending or settled invitation to a folder or organization
+type Invitation struct {
+	ID         string     `json:"id"`
+	Kind       string     `json:"kind"`
+	TargetID   string     `json:"target_id"`
+	TargetName string     `json:"target_name,omitempty"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	InviterID  string     `json:"inviter_id"`
+	Status     string     `json:"status"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+}
+
+// Service creates invitations and applies them when accepted
+type Service struct {
+	app        core.App
+	orgs       *orgs.Service
+	folders    *folderacl.Service
+	folderRepo *repository.Folders
+}
+
+// NewService creates an invitation service granting through the org and folder services
+func NewService(app core.App, orgService *orgs.Service, folderService *folderacl.Service) *Service {
+	return &Service{app: app, orgs: orgService, folders: folderService, folderRepo: repository.NewFolders(app)}
+}
+
+// Create stores a pending invitation and returns its plaintext accept token.
+// Callers check that the inviter may share the target.
+func (s *Service) Create(inviterID, kind, targetID, email, role string) (string, *Invitation, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if email == "" || !strings.Contains(email, "@") {
+		return "", nil, fmt.Errorf("a valid email is required")
+	}
+
+	switch kind {
+	case KindFolder:
+		if !folderacl.ValidGrantRole(role) {
+			return "", nil, fmt.Errorf("role must be one of: editor, viewer")
+		}
+	case KindOrg:
+		if !orgs.ValidRole(role) {
+			return "", nil, fmt.Errorf("role must be one of: admin, member, viewer")
+		}
+	default:
+		return "", nil, fmt.Errorf("kind must be one of: folder, org")
+	}
+
+	collection, err := s.app.FindCollectionByNameOrId(Collection)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to find invitations collection: %w", err)
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := hex.EncodeToString(secret)
+
+	expires, _ := types.ParseDateTime(time.Now().Add(DefaultTTL))
+
+	record := core.NewRecord(collection)
+	record.Set("kind", kind)
+	record.Set("target_id", targetID)
+	record.Set("email", email)
+	record.Set("role", role)
+	record.Set("inviter_id", inviterID)
+	record.Set("token_hash", hashToken(token))
+	record.Set("status", StatusPending)
+	record.Set("expires_at", expires)
+
+	if err := s.app.Save(record); err != nil {
+		return "", nil, fmt.Errorf("failed to save invitation: %w", err)
+	}
+
+	return token, s.fromRecord(record), nil
+}
+
+// ForTarget lists the invitations sent for a folder or organization, newest first
+func (s *Service) ForTarget(kind, targetID string) ([]*Invitation, error) {
+	records, err := s.app.FindRecordsByFilter(Collection, "kind = {:kind} && target_id = {:target_id}", "-created", 0, 0, map[string]any{
+		"kind":      kind,
+		"target_id": targetID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch invitations: %w", err)
+	}
+	return s.fromRecords(records), nil
+}
+
+// PendingFor lists the open invitations addressed to an email
+func (s *Service) PendingFor(email string) ([]*Invitation, error) {
+	records, err := s.app.FindRecordsByFilter(Collection, "email = {:email} && status = {:status}", "-created", 0, 0, map[string]any{
+		"email":  strings.ToLower(email),
+		"status": StatusPending,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch invitations: %w", err)
+	}
+
+	pending := make([]*Invitation, 0, len(records))
+	for _, invitation := range s.fromRecords(records) {
+		if invitation.Status == StatusPending {
+			pending = append(pending, invitation)
+		}
+	}
+	return pending, nil
+}
+
+// Find loads a single invitation
+func (s *Service) Find(id string) (*Invitation, error) {
+	record, err := s.app.FindRecordById(Collection, id)
+	if err != nil {
+		return nil, fmt.Errorf("invitation not found")
+	}
+	return s.fromRecord(record), nil
+}
+
+// Accept grants the invitation's role to user. The invitation must be
+// pending and addressed to the user's email.
+func (s *Service) Accept(token string, user *core.Record) (*Invitation, error) {
+	record, err := s.app.FindFirstRecordByData(Collection, "token_hash", hashToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("invitation not found")
+	}
+
+	invitation := s.fromRecord(record)
+	if invitation.Status != StatusPending {
+		return nil, fmt.Errorf("invitation is %s", invitation.Status)
+	}
+	if !strings.EqualFold(invitation.Email, user.Email()) {
+		return nil, fmt.Errorf("invitation was sent to a different email address")
+	}
+
+	switch invitation.Kind {
+	case KindFolder:
+		folder, err := s.folderRepo.Get(invitation.TargetID)
+		if err != nil {
+			return nil, fmt.Errorf("folder no longer exists")
+		}
+		if err := s.folders.Grant(folder, user.Id, invitation.Role); err != nil {
+			return nil, err
+		}
+	case KindOrg:
+		if _, err := s.orgs.AddMember(invitation.TargetID, user.Email(), invitation.Role); err != nil {
+			return nil, err
+		}
+	}
+
+	record.Set("status", StatusAccepted)
+	record.Set("accepted_by", user.Id)
+	record.Set("accepted_at", types.NowDateTime())
+	if err := s.app.Save(record); err != nil {
+		return nil, fmt.Errorf("failed to update invitation: %w", err)
+	}
+
+	return s.fromRecord(record), nil
+}
+
+// Revoke cancels a pending invitation
+func (s *Service) Revoke(id string) error {
+	record, err := s.app.FindRecordById(Collection, id)
+	if err != nil {
+		return fmt.Errorf("invitation not found")
+	}
+	if record.GetString("status") != StatusPending {
+		return fmt.Errorf("only pending invitations can be revoked")
+	}
+
+	record.Set("status", StatusRevoked)
+	if err := s.app.Save(record); err != nil {
+		return fmt.Errorf("failed to revoke invitation: %w", err)
+	}
+	return nil
+}
+
+func (s *Service) fromRecords(records []*core.Record) []*Invitation {
+	list := make([]*Invitation, 0, len(records))
+	for _, record := range records {
+		list = append(list, s.fromRecord(record))
+	}
+	return list
+}
+
+func (s *Service) fromRecord(record *core.Record) *Invitation {
+	invitation := &Invitation{
+		ID:        record.Id,
+		Kind:      record.GetString("kind"),
+		TargetID:  record.GetString("target_id"),
+		Email:     record.GetString("email"),
+		Role:      record.GetString("role"),
+		InviterID: record.GetString("inviter_id"),
+		Status:    record.GetString("status"),
+		ExpiresAt: record.GetDateTime("expires_at").Time(),
+	}
+
+	if invitation.Status == StatusPending && time.Now().After(invitation.ExpiresAt) {
+		invitation.Status = StatusExpired
+	}
+	if accepted := record.GetDateTime("accepted_at"); !accepted.IsZero() {
+		t := accepted.Time()
+		invitation.AcceptedAt = &t
+	}
+
+	if target, err := s.app.FindRecordById(targetCollection(invitation.Kind), invitation.TargetID); err == nil {
+		invitation.TargetName = target.GetString("name")
+	}
+	return invitation
+}
+
+// targetCollection returns the collection holding an invitation kind's targets
+func targetCollection(kind string) string {
+	if kind == KindOrg {
+		return orgs.OrgsCollection
+	}
+	return "folders"
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,141 @@
+// Package jobs tracks generations that outlast the time their caller agreed
+// to wait. The caller is answered with the job's ID and collects the result
+// with it later. Jobs are kept in memory and are lost on restart.
+package jobs
+
+import (
+	"sync"
+	"time"
+
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// Job statuses
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+)
+
+// Job is a generation running in the background, or its outcome
+type Job struct {
+	ID       string                             `json:"id"`
+	Status   string                             `json:"status"`
+	Result   *localmodels.GenerateImageResponse `json:"result,omitempty"` // the response of a completed generation
+	Error    *localmodels.APIError              `json:"error,omitempty"`  // why a failed generation failed
+	Created  time.Time                          `json:"created"`
+	Finished *time.Time                         `json:"finished,omitempty"`
+
+	// HTTPStatus is the status a failed generation is answered with
+	HTTPStatus int `json:"-"`
+}
+
+// entry is a stored job together with its owner
+type entry struct {
+	job    Job
+	userID string
+	done   chan struct{}
+}
+
+// Run is a job started by Start. Exactly one of Complete or Fail must be called.
+type Run struct {
+	store *Store
+	entry *entry
+}
+
+// ID returns the job's ID
+func (r *Run) ID() string {
+	return r.entry.job.ID
+}
+
+// Done is closed once the job has completed or failed
+func (r *Run) Done() <-chan struct{} {
+	return r.entry.done
+}
+
+// Complete records the generation's response
+func (r *Run) Complete(result *localmodels.GenerateImageResponse) {
+	r.store.finish(r.entry, func(job *Job) {
+		job.Status = StatusCompleted
+		job.Result = result
+	})
+}
+
+// Fail records why the generation failed and the status it is answered with
+func (r *Run) Fail(status int, apiErr localmodels.APIError) {
+	r.store.finish(r.entry, func(job *Job) {
+		job.Status = StatusFailed
+		job.Error = &apiErr
+		job.HTTPStatus = status
+	})
+}
+
+// Store keeps each job until its outcome has been available for the retention
+type Store struct {
+	retention time.Duration
+
+	mutex sync.Mutex
+	jobs  map[string]*entry
+}
+
+// NewStore creates a store keeping finished jobs for retention
+func NewStore(retention time.Duration) *Store {
+	return &Store{
+		retention: retention,
+		jobs:      make(map[string]*entry),
+	}
+}
+
+// Start tracks a new running job of userID
+func (s *Store) Start(userID string) *Run {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	for id, e := range s.jobs {
+		if e.job.Finished != nil && now.Sub(*e.job.Finished) > s.retention {
+			delete(s.jobs, id)
+		}
+	}
+
+	e := &entry{
+		job: Job{
+			ID:      security.RandomString(15),
+			Status:  StatusRunning,
+			Created: now,
+		},
+		userID: userID,
+		done:   make(chan struct{}),
+	}
+	s.jobs[e.job.ID] = e
+	return &Run{store: s, entry: e}
+}
+
+// Get returns a copy of the job with id if it belongs to userID and has not
+// expired
+func (s *Store) Get(userID, id string) (Job, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, ok := s.jobs[id]
+	if !ok || e.userID != userID {
+		return Job{}, false
+	}
+	if e.job.Finished != nil && time.Since(*e.job.Finished) > s.retention {
+		delete(s.jobs, id)
+		return Job{}, false
+	}
+	return e.job, true
+}
+
+// finish applies a job's outcome and releases callers waiting on it
+func (s *Store) finish(e *entry, outcome func(job *Job)) {
+	s.mutex.Lock()
+	now := time.Now()
+	outcome(&e.job)
+	e.job.Finished = &now
+	s.mutex.Unlock()
+	close(e.done)
+}
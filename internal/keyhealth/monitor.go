@@ -0,0 +1,224 @@
+package keyhealth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"generatio-pb/internal/auth"
+	"generatio-pb/internal/fal"
+	"generatio-pb/internal/notify"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Key health states stored in generatio_users.key_health
+const (
+	StatusHealthy     = "healthy"
+	StatusRevoked     = "revoked"
+	StatusRateLimited = "rate_limited"
+)
+
+// checkTimeout bounds a single key validation
+const checkTimeout = 15 * time.Second
+
+// Health is the latest verdict on a user's FAL AI key
+type Health struct {
+	Status    string     `json:"status"`
+	Code      string     `json:"code,omitempty"` // stable FAL error code behind an unhealthy status
+	CheckedAt time.Time  `json:"checked_at"`
+	FlaggedAt *time.Time `json:"flagged_at,omitempty"` // when the key last turned unhealthy
+}
+
+// Healthy reports whether the key passed its last check
+func (h Health) Healthy() bool {
+	return h.Status == StatusHealthy
+}
+
+// Report summarises a check run
+type Report struct {
+	Checked int `json:"checked"`
+	Skipped int `json:"skipped"` // opted-in users without an active session, or FAL AI was unavailable
+	Flagged int `json:"flagged"`
+}
+
+// Monitor periodically validates the FAL AI keys of users who opted in
+// through generatio_users.key_health_checks. Stored keys are encrypted with
+// the user's password, so only keys held by an active session can be
+// checked. Validation uses the same non-generating request as token setup.
+// When a key turns revoked or rate limited the account is flagged and the
+// user is emailed once; the flag clears on the next healthy check.
+type Monitor struct {
+	app       core.App
+	sessions  *auth.SessionStore
+	falClient fal.FALClient
+	notifier  *notify.Service
+	interval  time.Duration
+
+	runMutex sync.Mutex
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// NewMonitor creates a monitor running every interval; a non-positive
+// interval disables scheduled runs
+func NewMonitor(app core.App, sessions *auth.SessionStore, falClient fal.FALClient, notifier *notify.Service, interval time.Duration) *Monitor {
+	return &Monitor{
+		app:       app,
+		sessions:  sessions,
+		falClient: falClient,
+		notifier:  notifier,
+		interval:  interval,
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// Start begins the scheduled check loop
+func (m *Monitor) Start() {
+	if m.interval <= 0 {
+		return
+	}
+	go m.run()
+	log.Printf("FAL key health checks started with interval: %v", m.interval)
+}
+
+// Stop stops the scheduled check loop
+func (m *Monitor) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopChan)
+	})
+}
+
+func (m *Monitor) run() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			report, err := m.Run(context.Background())
+			if err != nil {
+				log.Printf("FAL key health check failed: %v", err)
+			} else if report.Flagged > 0 {
+				log.Printf("FAL key health check flagged %d users", report.Flagged)
+			}
+		case <-m.stopChan:
+			return
+		}
+	}
+}
+
+// Run checks the key of every opted-in user with an active session
+func (m *Monitor) Run(ctx context.Context) (*Report, error) {
+	m.runMutex.Lock()
+	defer m.runMutex.Unlock()
+
+	users, err := m.app.FindRecordsByFilter("generatio_users", "key_health_checks = true", "", 0, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch users: %w", err)
+	}
+
+	report := &Report{}
+	for _, user := range users {
+		flagged, checked, err := m.Check(ctx, user)
+		if err != nil {
+			m.app.Logger().Error("Failed to record FAL key health", "error", err, "user_id", user.Id)
+			continue
+		}
+		if !checked {
+			report.Skipped++
+			continue
+		}
+		report.Checked++
+		if flagged {
+			report.Flagged++
+		}
+	}
+	return report, nil
+}
+
+// Check validates the key held by the user's active session and records the
+// verdict. checked is false when there is no session or FAL AI did not give
+// a verdict; flagged is true when the key just turned unhealthy.
+func (m *Monitor) Check(ctx context.Context, user *core.Record) (flagged, checked bool, err error) {
+	session, err := m.sessions.GetUserSession(user.Id)
+	if err != nil {
+		return false, false, nil
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+	validateErr := m.falClient.ValidateToken(checkCtx, session.FALToken)
+	cancel()
+
+	health := Health{Status: StatusHealthy, CheckedAt: time.Now().UTC()}
+	if validateErr != nil {
+		switch code := fal.Classify(validateErr).Code; code {
+		case fal.ErrorCodeAuth:
+			health.Status, health.Code = StatusRevoked, code
+		case fal.ErrorCodeRateLimited:
+			health.Status, health.Code = StatusRateLimited, code
+		default:
+			// An outage says nothing about the key
+			return false, false, nil
+		}
+	}
+
+	previous := Get(user)
+	if !health.Healthy() {
+		if previous.Status == health.Status {
+			health.FlaggedAt = previous.FlaggedAt
+		} else {
+			now := health.CheckedAt
+			health.FlaggedAt = &now
+			flagged = true
+		}
+	}
+
+	user.Set("key_health", health)
+	if err := m.app.Save(user); err != nil {
+		return false, true, fmt.Errorf("failed to save key health: %w", err)
+	}
+
+	if flagged {
+		m.notify(user, health)
+	}
+	return flagged, true, nil
+}
+
+// Get returns the user's last recorded key health; Status is empty when the
+// key was never checked
+func Get(user *core.Record) Health {
+	var health Health
+	user.UnmarshalJSONField("key_health", &health)
+	return health
+}
+
+// notify emails the user that their key turned unhealthy
+func (m *Monitor) notify(user *core.Record, health Health) {
+	if user.Email() == "" {
+		return
+	}
+
+	subject := "Your FAL AI key was rejected"
+	body := "FAL AI rejected the key saved to your Generatio account during a routine check, so generations will fail. " +
+		"It may have been revoked or deleted; set up a new key to keep generating."
+	if health.Status == StatusRateLimited {
+		subject = "Your FAL AI key is being rate limited"
+		body = "FAL AI is rate limiting the key saved to your Generatio account, so generations may fail until the limit lifts. " +
+			"Check your FAL AI usage and plan limits."
+	}
+
+	err := m.notifier.Enqueue(notify.Message{
+		UserID:  user.Id,
+		Channel: notify.ChannelEmail,
+		Target:  user.Email(),
+		Event:   notify.EventKeyHealth,
+		Subject: subject,
+		Body:    body,
+	})
+	if err != nil {
+		m.app.Logger().Error("Failed to queue key health notice", "error", err, "user_id", user.Id)
+	}
+}
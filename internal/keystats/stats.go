@@ -0,0 +1,152 @@
+package keystats
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"generatio-pb/internal/money"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// UnknownKey groups images generated before keys were tracked
+const UnknownKey = "unknown"
+
+// MaxLabelLength caps key labels
+const MaxLabelLength = 50
+
+// KeyID identifies a FAL AI key without revealing it. Images record the ID
+// of the key that paid for them in other_info.key_id.
+func KeyID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "key_" + hex.EncodeToString(sum[:])[:12]
+}
+
+// KeyHint returns the last characters of a key, for telling keys apart
+func KeyHint(token string) string {
+	if len(token) <= 4 {
+		return ""
+	}
+	return "…" + token[len(token)-4:]
+}
+
+// KeyUsage is the generations and spend paid for with one key
+type KeyUsage struct {
+	KeyID       string       `json:"key_id"`
+	Label       string       `json:"label,omitempty"`
+	Hint        string       `json:"hint,omitempty"`
+	Current     bool         `json:"current"` // the key of the caller's session
+	Generations int          `json:"generations"`
+	Images      int          `json:"images"`
+	Spent       float64      `json:"spent"`        // SpentMicros in USD, for older readers
+	SpentMicros money.Micros `json:"spent_micros"` // what the key's images cost
+	FirstUsedAt time.Time    `json:"first_used_at"`
+	LastUsedAt  time.Time    `json:"last_used_at"`
+}
+
+// Usage totals a user's images generated since since by the key that paid
+// for them, most spent first. Trashed images are left out. currentKeyID marks the caller's key and may be empty.
+func Usage(app core.App, user *core.Record, since time.Time, currentKeyID string) ([]KeyUsage, error) {
+	records, err := app.FindRecordsByFilter("images", "user_id = {:user_id} && created >= {:since} && deleted_at = null", "created", 0, 0, map[string]any{
+		"user_id": user.Id,
+		"since":   since.UTC().Format(types.DefaultDateLayout),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch images: %w", err)
+	}
+
+	labels := Labels(user)
+	byKey := make(map[string]*KeyUsage)
+	requests := make(map[string]map[string]bool)
+	for _, record := range records {
+		var info struct {
+			money.ImageCost
+			KeyID   string `json:"key_id"`
+			KeyHint string `json:"key_hint"`
+			Source  string `json:"source"`
+		}
+		record.UnmarshalJSONField("other_info", &info)
+		// Imported images were not generated with any key
+		if info.Source != "" {
+			continue
+		}
+
+		keyID := info.KeyID
+		if keyID == "" {
+			keyID = UnknownKey
+		}
+		usage, exists := byKey[keyID]
+		if !exists {
+			usage = &KeyUsage{KeyID: keyID, Label: labels[keyID], Current: keyID == currentKeyID}
+			byKey[keyID] = usage
+			requests[keyID] = make(map[string]bool)
+		}
+		if info.KeyHint != "" {
+			usage.Hint = info.KeyHint
+		}
+
+		created := record.GetDateTime("created").Time()
+		if usage.FirstUsedAt.IsZero() {
+			usage.FirstUsedAt = created
+		}
+		usage.LastUsedAt = created
+		usage.Images++
+		usage.SpentMicros += info.Amount()
+
+		// Images of one FAL request are one generation
+		requestID := record.GetString("request_id")
+		if requestID == "" {
+			requestID = record.Id
+		}
+		if !requests[keyID][requestID] {
+			requests[keyID][requestID] = true
+			usage.Generations++
+		}
+	}
+
+	usages := make([]KeyUsage, 0, len(byKey))
+	for _, usage := range byKey {
+		usage.Spent = usage.SpentMicros.USD()
+		usages = append(usages, *usage)
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Spent != usages[j].Spent {
+			return usages[i].Spent > usages[j].Spent
+		}
+		return usages[i].KeyID < usages[j].KeyID
+	})
+	return usages, nil
+}
+
+// Labels returns the names a user gave their keys, by key ID
+func Labels(user *core.Record) map[string]string {
+	labels := map[string]string{}
+	user.UnmarshalJSONField("fal_key_labels", &labels)
+	return labels
+}
+
+// SetLabel names one of the user's keys; an empty label removes the name.
+// The caller saves the user.
+func SetLabel(user *core.Record, keyID, label string) error {
+	if !strings.HasPrefix(keyID, "key_") {
+		return fmt.Errorf("invalid key ID %q", keyID)
+	}
+	label = strings.TrimSpace(label)
+	if len(label) > MaxLabelLength {
+		return fmt.Errorf("label cannot exceed %d characters", MaxLabelLength)
+	}
+
+	labels := Labels(user)
+	if label == "" {
+		delete(labels, keyID)
+	} else {
+		labels[keyID] = label
+	}
+	user.Set("fal_key_labels", labels)
+	return nil
+}
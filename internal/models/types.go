@@ -1,7 +1,10 @@
 package models
 
 import (
+	"fmt"
 	"time"
+
+	"generatio-pb/internal/money"
 )
 
 // User represents the extended user data
@@ -15,10 +18,12 @@ type User struct {
 	Updated      time.Time              `json:"updated"`
 }
 
-// FinancialData tracks user spending and usage
+// FinancialData tracks user spending and usage. TotalSpentMicros is the
+// authoritative total; TotalSpent repeats it in USD for older readers.
 type FinancialData struct {
-	TotalSpent   float64 `json:"total_spent"`   // Total amount spent in USD
-	TotalImages  int     `json:"total_images"`  // Total images generated
+	TotalSpent       float64      `json:"total_spent"`        // Total amount spent in USD
+	TotalSpentMicros money.Micros `json:"total_spent_micros"` // Total amount spent in micro-dollars
+	TotalImages      int          `json:"total_images"`       // Total images generated
 }
 
 // GeneratedImage represents a generated AI image
@@ -54,17 +59,37 @@ type Collection struct {
 	UserID   string    `json:"user_id"`
 	Name     string    `json:"name"`
 	ParentID string    `json:"parent_id,omitempty"` // Optional parent collection
+	OrgID    string    `json:"org_id,omitempty"`    // Set for organization libraries
+	Role     string    `json:"role,omitempty"`      // The requesting user's folder role
 	Created  time.Time `json:"created"`
 	Updated  time.Time `json:"updated"`
 }
 
 // Session represents an in-memory user session
 type Session struct {
-	ID        string    `json:"id"`
-	UserID    string    `json:"user_id"`
-	FALToken  string    `json:"-"`        // Never serialize - keep in memory only
-	CreatedAt time.Time `json:"created_at"`
-	ExpiresAt time.Time `json:"expires_at"`
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	FALToken   string    `json:"-"` // Never serialize - keep in memory only
+	CreatedAt  time.Time `json:"created_at"`
+
+	// TestingFALToken is the key of the user's testing slot, if they set one up
+	TestingFALToken string `json:"-"`
+	// Environment is the key slot requests use unless they pick another
+	Environment string `json:"environment,omitempty"`
+
+	ExpiresAt  time.Time `json:"expires_at"`
+	LastUsedAt time.Time `json:"last_used_at"` // Zero until a request uses the session
+
+	// DerivedKeys caches, by salt, the keys derived from the password the
+	// session was created with
+	DerivedKeys map[string]DerivedKey `json:"-"`
+}
+
+// DerivedKey is a key derived from a session's password. Requests repeating
+// the password during the session decrypt with it instead of deriving again.
+type DerivedKey struct {
+	Key   []byte
+	Check []byte // crypto.PasswordCheck of the password under Key
 }
 
 // IsExpired checks if the session has expired
@@ -75,6 +100,42 @@ func (s *Session) IsExpired() bool {
 // Clear clears sensitive data from the session
 func (s *Session) Clear() {
 	s.FALToken = ""
+	s.TestingFALToken = ""
+	for salt, derived := range s.DerivedKeys {
+		for i := range derived.Key {
+			derived.Key[i] = 0
+		}
+		delete(s.DerivedKeys, salt)
+	}
+}
+
+// FAL key slots. Testing generations are left out of financial stats.
+const (
+	EnvironmentProduction = "production"
+	EnvironmentTesting    = "testing"
+)
+
+// ForEnvironment returns a copy of the session whose FALToken is the key of
+// the given slot; an empty environment selects the session's default
+func (s *Session) ForEnvironment(environment string) (*Session, error) {
+	if environment == "" {
+		environment = s.Environment
+	}
+
+	selected := *s
+	switch environment {
+	case "", EnvironmentProduction:
+		selected.Environment = EnvironmentProduction
+	case EnvironmentTesting:
+		if s.TestingFALToken == "" {
+			return nil, fmt.Errorf("no testing FAL AI key is set up; set one up or use the production key")
+		}
+		selected.FALToken = s.TestingFALToken
+		selected.Environment = EnvironmentTesting
+	default:
+		return nil, fmt.Errorf("environment must be %q or %q", EnvironmentProduction, EnvironmentTesting)
+	}
+	return &selected, nil
 }
 
 // API Request/Response Types
@@ -83,6 +144,9 @@ func (s *Session) Clear() {
 type SetupTokenRequest struct {
 	FALToken string `json:"fal_token" validate:"required"`
 	Password string `json:"password" validate:"required"`
+
+	// Environment is the key slot to store the token in (production by default)
+	Environment string `json:"environment,omitempty"`
 }
 
 // VerifyTokenRequest represents the request to verify token accessibility
@@ -99,12 +163,54 @@ type VerifyTokenResponse struct {
 // CreateSessionRequest represents the request to create a session
 type CreateSessionRequest struct {
 	Password string `json:"password" validate:"required"`
+
+	// Environment is the key slot the session uses by default (production unless set)
+	Environment string `json:"environment,omitempty"`
+
+	// RememberDevice asks for a refresh token bound to DeviceID
+	RememberDevice bool   `json:"remember_device,omitempty"`
+	DeviceID       string `json:"device_id,omitempty" validate:"max=200"`
+	DeviceName     string `json:"device_name,omitempty" validate:"max=100"`
 }
 
 // CreateSessionResponse represents the response for session creation
 type CreateSessionResponse struct {
-	SessionID string    `json:"session_id"`
-	ExpiresAt time.Time `json:"expires_at"`
+	SessionID        string     `json:"session_id"`
+	ExpiresAt        time.Time  `json:"expires_at"`
+	RefreshToken     string     `json:"refresh_token,omitempty"`
+	RefreshExpiresAt *time.Time `json:"refresh_expires_at,omitempty"`
+	Environment      string     `json:"environment"`
+	Environments     []string   `json:"environments"` // key slots the session can use
+}
+
+// RefreshSessionRequest exchanges a remembered device's refresh token for a new session
+type RefreshSessionRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+	DeviceID     string `json:"device_id" validate:"required,max=200"`
+}
+
+// RememberDeviceSettingsRequest sets how long a user's devices may stay
+// remembered (0 turns remembering off and forgets every device)
+type RememberDeviceSettingsRequest struct {
+	Days *int `json:"remember_days"`
+}
+
+// KeyLabelRequest names a FAL AI key in per-key usage statistics
+type KeyLabelRequest struct {
+	Label string `json:"label"`
+}
+
+// KeyHealthSettingsRequest turns background FAL AI key health checks on or off
+type KeyHealthSettingsRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+// SessionInfoResponse describes a session without revealing its FAL token
+type SessionInfoResponse struct {
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	TTLSeconds int        `json:"ttl_seconds"`
+	LastUsedAt *time.Time `json:"last_used_at"` // null until a request uses the session
 }
 
 // GenerateImageRequest represents the request to generate an image
@@ -113,20 +219,77 @@ type GenerateImageRequest struct {
 	Prompt       string                 `json:"prompt" validate:"required,max=1000"`
 	Parameters   map[string]interface{} `json:"parameters,omitempty"`
 	CollectionID string                 `json:"collection_id,omitempty"`
+	Priority     string                 `json:"priority,omitempty"` // interactive (default) or batch, capped by the user's tier
+	DryRun       bool                   `json:"dry_run,omitempty"`  // validate and price the request without calling FAL AI
+
+	// AllowDuplicate generates even when an identical request was just submitted
+	AllowDuplicate bool `json:"allow_duplicate,omitempty"`
+
+	// NoCache generates even when an identical seeded request was generated before
+	NoCache bool `json:"no_cache,omitempty"`
+
+	// MaxWait is how many seconds to wait for the images before answering
+	// 202 with a job to poll instead, capped by the server (0 waits until done)
+	MaxWait int `json:"max_wait,omitempty"`
 }
 
 // GenerateImageResponse represents the response for image generation
 type GenerateImageResponse struct {
-	Images []GeneratedImageInfo `json:"images"`
-	Cost   float64              `json:"cost"`
-	Model  string               `json:"model"`
+	Images     []GeneratedImageInfo `json:"images"`
+	Cost       float64              `json:"cost"`
+	CostMicros money.Micros         `json:"cost_micros"` // Cost in micro-dollars, exact
+	Model      string               `json:"model"`
+
+	// Warnings explains adjustments made to the request, such as a deprecated
+	// model being replaced
+	Warnings []string `json:"warnings,omitempty"`
+
+	// Duplicate is set when the images come from an identical request
+	// submitted moments earlier and nothing was generated or charged
+	Duplicate bool `json:"duplicate,omitempty"`
+
+	// Cached is set when the images are the stored result of an identical
+	// seeded request and nothing was generated or charged
+	Cached bool `json:"cached,omitempty"`
+
+	// Partial is set when fewer images were delivered than requested; only
+	// the delivered ones are charged. ImageStatuses then gives the status of
+	// each requested image: "delivered", "failed" or "missing".
+	Partial       bool     `json:"partial,omitempty"`
+	ImageStatuses []string `json:"image_statuses,omitempty"`
+}
+
+// DryRunResponse describes the request a generation would submit to FAL AI
+// and what it would cost, returned instead of images when dry_run is set
+type DryRunResponse struct {
+	DryRun        bool                   `json:"dry_run"`
+	Model         string                 `json:"model"`
+	Prompt        string                 `json:"prompt"`
+	Parameters    map[string]interface{} `json:"parameters"`
+	Priority      string                 `json:"priority"`
+	CollectionID  string                 `json:"collection_id,omitempty"`
+	EstimatedCost float64                `json:"estimated_cost"`
+	Warnings      []string               `json:"warnings,omitempty"`
+
+	// EffectiveParameters adds the model defaults FAL AI applies to the
+	// submitted parameters, and ParameterSources tells for each whether it
+	// came from the model ("model_default"), a saved preference ("preference")
+	// or the request ("request")
+	EffectiveParameters map[string]interface{} `json:"effective_parameters"`
+	ParameterSources    map[string]string      `json:"parameter_sources"`
 }
 
 // GeneratedImageInfo represents basic info about a generated image
 type GeneratedImageInfo struct {
-	ID           string `json:"id"`
-	URL          string `json:"url"`
-	ThumbnailURL string `json:"thumbnail_url,omitempty"`
+	ID               string     `json:"id"`
+	URL              string     `json:"url"`
+	ThumbnailURL     string     `json:"thumbnail_url,omitempty"`
+	ModerationStatus string     `json:"moderation_status,omitempty"` // Set when moderation is enabled
+	Created          *time.Time `json:"created,omitempty"`           // Set for saved images
+	Updated          *time.Time `json:"updated,omitempty"`
+
+	// Variants maps format to size to resized file path, for building srcset
+	Variants map[string]map[string]string `json:"variants,omitempty"`
 }
 
 // FinancialStatsResponse represents financial statistics
@@ -135,6 +298,63 @@ type FinancialStatsResponse struct {
 	TotalImages     int     `json:"total_images"`
 	RecentSpending  float64 `json:"recent_spending"`  // Last 30 days
 	AverageCost     float64 `json:"average_cost"`     // Per image
+
+	// The totals in micro-dollars, exact
+	TotalSpentMicros     money.Micros `json:"total_spent_micros"`
+	RecentSpendingMicros money.Micros `json:"recent_spending_micros"`
+
+	// Display repeats the USD amounts in the user's display currency, when it is not USD
+	Display *DisplayCosts `json:"display,omitempty"`
+}
+
+// DisplayCosts are USD amounts converted to a display currency
+type DisplayCosts struct {
+	Currency       string  `json:"currency"`
+	Rate           float64 `json:"rate"` // units of Currency per USD
+	TotalSpent     float64 `json:"total_spent"`
+	RecentSpending float64 `json:"recent_spending"`
+	AverageCost    float64 `json:"average_cost"`
+}
+
+// LimitsResponse summarizes everything that can stop a user from generating
+type LimitsResponse struct {
+	RateLimit *RateLimitStanding `json:"rate_limit"` // nil when rate limiting is disabled
+	Budget    BudgetStanding     `json:"budget"`
+	Quota     QuotaStanding      `json:"quota"`
+}
+
+// RateLimitStanding is the caller's standing in the current generation rate limit window
+type RateLimitStanding struct {
+	Limit         int       `json:"limit"`
+	Remaining     int       `json:"remaining"`
+	Reset         time.Time `json:"reset"`
+	WindowSeconds int       `json:"window_seconds"`
+}
+
+// BudgetStanding is the user's spending against their monthly budget
+type BudgetStanding struct {
+	MonthlyBudgetUSD float64   `json:"monthly_budget_usd"` // 0 is unlimited
+	CreditUSD        float64   `json:"credit_usd"`
+	SpentUSD         float64   `json:"spent_usd"`
+	RemainingUSD     *float64  `json:"remaining_usd"` // nil when unlimited
+	Reset            time.Time `json:"reset"`
+
+	// The spent and remaining amounts in micro-dollars, exact
+	SpentMicros     money.Micros  `json:"spent_micros"`
+	RemainingMicros *money.Micros `json:"remaining_micros"`
+}
+
+// QuotaStanding is the user's images against their daily image quota
+type QuotaStanding struct {
+	DailyImageQuota int       `json:"daily_image_quota"` // 0 is unlimited
+	UsedToday       int       `json:"used_today"`
+	Remaining       *int      `json:"remaining"` // nil when unlimited
+	Reset           time.Time `json:"reset"`
+}
+
+// DisplayCurrencyRequest sets the currency financial endpoints display costs in
+type DisplayCurrencyRequest struct {
+	Currency string `json:"currency"` // ISO 4217 code; stored costs stay in USD
 }
 
 // PreferencesResponse represents user preferences for a model
@@ -144,6 +364,11 @@ type PreferencesResponse struct {
 	HasPreferences bool                `json:"has_preferences"`
 }
 
+// AllPreferencesResponse holds a user's saved preferences keyed by model name
+type AllPreferencesResponse struct {
+	Preferences map[string]map[string]interface{} `json:"preferences"`
+}
+
 // SavePreferencesRequest represents the request to save preferences
 type SavePreferencesRequest struct {
 	ModelName   string                 `json:"model_name" validate:"required"`
@@ -166,7 +391,9 @@ type CreateCollectionResponse struct {
 	ID       string    `json:"id"`
 	Name     string    `json:"name"`
 	ParentID string    `json:"parent_id,omitempty"`
+	OrgID    string    `json:"org_id,omitempty"`
 	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
 }
 
 // MoveCollectionRequest represents the request to move a collection
@@ -174,16 +401,71 @@ type MoveCollectionRequest struct {
 	ParentID string `json:"parent_id,omitempty"` // Empty string for root level
 }
 
+// DuplicateCollectionRequest represents the request to deep-copy a collection
+type DuplicateCollectionRequest struct {
+	Name      string `json:"name,omitempty" validate:"max=100"` // Defaults to "<name> (copy)"
+	ParentID  string `json:"parent_id,omitempty"`                // Empty string for root level
+	CopyFiles bool   `json:"copy_files,omitempty"`               // Store image files so the copy outlives expiring FAL URLs
+}
+
+// DuplicateCollectionResponse represents the copy of a collection
+type DuplicateCollectionResponse struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	ParentID    string `json:"parent_id,omitempty"`
+	OrgID       string `json:"org_id,omitempty"`
+	Folders     int    `json:"folders"` // Including the copy itself
+	Images      int    `json:"images"`
+	FilesFailed int    `json:"files_failed,omitempty"` // Images whose file could not be stored; they still reference the URL
+}
+
 // AddImagesToCollectionRequest represents the request to add images to a collection
 type AddImagesToCollectionRequest struct {
 	ImageIDs []string `json:"image_ids" validate:"required,min=1"`
 }
 
-// APIError represents a standardized API error response
+// SmartCollectionFilter selects the images of a smart collection; every set criterion must match
+type SmartCollectionFilter struct {
+	Model    string `json:"model,omitempty"`    // Model ID contains this, e.g. "flux"
+	Tag      string `json:"tag,omitempty"`      // images.tags includes this tag
+	Prompt   string `json:"prompt,omitempty"`   // Prompt contains this text
+	Favorite *bool  `json:"favorite,omitempty"` // Only favorites, or only non-favorites
+	Days     int    `json:"days,omitempty"`     // Created within the last days
+}
+
+// SaveSmartCollectionRequest creates or replaces a smart collection
+type SaveSmartCollectionRequest struct {
+	Name   string                `json:"name" validate:"required,max=100"`
+	Filter SmartCollectionFilter `json:"filter"`
+}
+
+// ReorderImagesRequest represents the manual order of a folder's images
+type ReorderImagesRequest struct {
+	ImageIDs []string `json:"image_ids" validate:"required,min=1,max=1000"`
+}
+
+// Response is the envelope of every JSON response. Data holds the result,
+// null when the request failed; Error describes the failure and Meta carries
+// listing details such as next_cursor. Field names are snake_case.
+type Response struct {
+	Data  interface{}            `json:"data"`
+	Error *APIError              `json:"error,omitempty"`
+	Meta  map[string]interface{} `json:"meta,omitempty"`
+}
+
+// APIError describes a failed request in the response envelope
 type APIError struct {
-	Code    string      `json:"error"`
+	Code    string      `json:"code"`
 	Message string      `json:"message"`
 	Details interface{} `json:"details,omitempty"`
+
+	// Detail keeps the handler's specific English message when Message is
+	// translated into the more general text for the code
+	Detail string `json:"detail,omitempty"`
+
+	// Retryable is set for failed FAL AI calls and tells clients whether
+	// sending the same request again may succeed
+	Retryable *bool `json:"retryable,omitempty"`
 }
 
 // Error implements the error interface
@@ -200,6 +482,10 @@ const (
 	ErrCodeInternal      = "internal_error"
 	ErrCodeExternal      = "external_error"
 	ErrCodeRateLimit     = "rate_limit_error"
+	ErrCodeQuota         = "quota_exceeded"
+	ErrCodeUnavailable   = "service_unavailable"
+	ErrCodeContentPolicy = "content_policy_violation"
+	ErrCodeInvalidFolder = "invalid_folder" // collection_id or folder_id the caller cannot save into
 )
 
 // CustomLoginRequest represents the request for custom login with auto-session creation
@@ -221,4 +507,434 @@ type TokenStatusResponse struct {
 	HasToken         bool `json:"has_token"`
 	HasActiveSession bool `json:"has_active_session"`
 	RequiresLogin    bool `json:"requires_login"`
-}
\ No newline at end of file
+	HasTestingToken  bool `json:"has_testing_token"`
+}
+
+// MaintenanceStatus represents the current maintenance mode state
+type MaintenanceStatus struct {
+	Enabled bool       `json:"enabled"`
+	Message string     `json:"message,omitempty"`
+	Since   *time.Time `json:"since,omitempty"`
+}
+
+// SetMaintenanceRequest represents the request to toggle maintenance mode
+type SetMaintenanceRequest struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+}
+
+// RunReconciliationRequest represents the request to reconcile recorded spending
+type RunReconciliationRequest struct {
+	Fix bool `json:"fix"` // reset drifted totals to the sum of image costs
+}
+
+// GrantCreditRequest represents the request to extend a user's budget for the current month
+type GrantCreditRequest struct {
+	AmountUSD float64 `json:"amount_usd"`
+	Reason    string  `json:"reason"`
+}
+
+// SetBudgetRequest represents the request to change a user's limits; omitted limits are unchanged and zero removes a limit
+type SetBudgetRequest struct {
+	MonthlyBudgetUSD *float64 `json:"monthly_budget_usd,omitempty"`
+	DailyImageQuota  *int     `json:"daily_image_quota,omitempty"`
+	Reason           string   `json:"reason"`
+}
+
+// ResetQuotaRequest represents the request to discard a user's usage so far
+type ResetQuotaRequest struct {
+	Reason string `json:"reason"`
+}
+
+// DeadNotification represents an outbound notification that exhausted its retries
+type DeadNotification struct {
+	ID        string `json:"id"`
+	UserID    string `json:"user_id"`
+	Channel   string `json:"channel"`
+	Event     string `json:"event"`
+	Subject   string `json:"subject"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error"`
+}
+
+// QuarantinedImage represents an image withheld by the moderation stage
+type QuarantinedImage struct {
+	ID      string    `json:"id"`
+	Prompt  string    `json:"prompt"`
+	Model   string    `json:"model"`
+	Created time.Time `json:"created"`
+}
+
+// CheckPromptRequest represents a content filter dry run
+type CheckPromptRequest struct {
+	Prompt string `json:"prompt" validate:"required"`
+}
+
+// AddFilterTermRequest represents a new content filter block or allow entry
+type AddFilterTermRequest struct {
+	Term     string `json:"term"`
+	Kind     string `json:"kind"`
+	Severity string `json:"severity,omitempty"`
+}
+
+// SetRetentionRequest represents a user's retention override (0 inherits the deployment default, negative keeps forever)
+type SetRetentionRequest struct {
+	RetentionDays *int `json:"retention_days"`
+}
+
+// ExpiringImage represents an image the next retention run would purge
+type ExpiringImage struct {
+	ID      string    `json:"id"`
+	Title   string    `json:"title"`
+	Model   string    `json:"model"`
+	Created time.Time `json:"created"`
+}
+
+// TrashedImage represents a deleted image that can still be restored
+type TrashedImage struct {
+	ID        string     `json:"id"`
+	URL       string     `json:"url"`
+	Title     string     `json:"title"`
+	Model     string     `json:"model"`
+	DeletedAt time.Time  `json:"deleted_at"`
+	PurgeAt   *time.Time `json:"purge_at,omitempty"` // nil when the trash is never emptied automatically
+}
+
+// RegisterChatWebhookRequest registers a Discord or Slack webhook for notifications
+type RegisterChatWebhookRequest struct {
+	Channel string   `json:"channel" validate:"required"` // discord or slack
+	URL     string   `json:"url" validate:"required,max=500"`
+	Events  []string `json:"events,omitempty"` // job.completed and/or budget.alert; all when empty
+}
+
+// CreateHookRequest subscribes a URL to an event
+type CreateHookRequest struct {
+	Event     string `json:"event" validate:"required"` // image.created, job.failed or budget.exceeded
+	TargetURL string `json:"target_url" validate:"required,max=500"`
+}
+
+// UpdateHookRequest changes a hook subscription; omitted fields are left alone
+type UpdateHookRequest struct {
+	TargetURL *string `json:"target_url,omitempty"`
+	Enabled   *bool   `json:"enabled,omitempty"` // true re-enables a subscription disabled after failures
+}
+
+// DatasetRow is one line of a JSONL prompt export
+type DatasetRow struct {
+	ID         string                 `json:"id"`
+	Prompt     string                 `json:"prompt"`
+	Model      string                 `json:"model"`
+	Seed       int64                  `json:"seed,omitempty"` // Omitted when FAL reported no seed
+	Parameters map[string]interface{} `json:"parameters"`
+	ImageSize  interface{}            `json:"image_size,omitempty"`
+	ImageURL   string                 `json:"image_url"`
+	Created    time.Time              `json:"created"`
+}
+
+// ImportImageItem represents a single external image to import by URL
+type ImportImageItem struct {
+	URL    string `json:"url"`
+	Title  string `json:"title,omitempty"`
+	Prompt string `json:"prompt,omitempty"`
+	Model  string `json:"model,omitempty"`
+}
+
+// ImportImagesRequest represents a JSON bulk import request
+type ImportImagesRequest struct {
+	FolderID string            `json:"folder_id,omitempty"`
+	Images   []ImportImageItem `json:"images"`
+}
+
+// ImportedImage represents an image record created by an import
+type ImportedImage struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	URL    string `json:"url"`
+	Source string `json:"source"` // Original URL or uploaded file name
+
+	Variants map[string]map[string]string `json:"variants,omitempty"`
+}
+
+// ImportFailure represents an item that could not be imported
+type ImportFailure struct {
+	Source string `json:"source"`
+	Error  string `json:"error"`
+}
+
+// ImportImagesResponse represents the result of a bulk import
+type ImportImagesResponse struct {
+	Imported []ImportedImage `json:"imported"`
+	Failed   []ImportFailure `json:"failed"`
+}
+
+// CreateShareRequest represents a request for a signed share link
+type CreateShareRequest struct {
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+}
+
+// CreateFeedRequest publishes the user's gallery, or one folder, as a feed
+type CreateFeedRequest struct {
+	FolderID string `json:"folder_id,omitempty"` // empty publishes the gallery
+	Title    string `json:"title,omitempty" validate:"max=120"`
+}
+
+// GalleryWidget is the compact listing external sites embed for a published
+// folder or gallery
+type GalleryWidget struct {
+	Title  string               `json:"title"`
+	Feed   string               `json:"feed"`
+	Images []GalleryWidgetImage `json:"images"`
+}
+
+// GalleryWidgetImage is one image in a gallery widget
+type GalleryWidgetImage struct {
+	Title     string `json:"title"`
+	Thumbnail string `json:"thumbnail"`
+	Link      string `json:"link"`
+}
+
+// ShareLinkResponse represents a signed, expiring link to a single image
+type ShareLinkResponse struct {
+	URL       string    `json:"url"`
+	PageURL   string    `json:"page_url"` // HTML page with OpenGraph tags, for posting in chat and social apps
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// CreateAPIKeyRequest represents a request to issue a scoped API key
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// CreateOrgRequest represents a request to create an organization
+type CreateOrgRequest struct {
+	Name string `json:"name"`
+}
+
+// AddOrgMemberRequest represents a request to add a member or change their role
+type AddOrgMemberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// ShareFolderRequest represents a request to grant another user access to a folder
+type ShareFolderRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// CreateInvitationRequest represents an invitation to a folder or organization
+type CreateInvitationRequest struct {
+	Kind     string `json:"kind"` // folder or org
+	TargetID string `json:"target_id"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+}
+
+// AcceptInvitationRequest carries the token from an invitation email
+type AcceptInvitationRequest struct {
+	Token string `json:"token"`
+}
+
+// CompareVariant is one model or parameter set in a comparison
+type CompareVariant struct {
+	Model      string                 `json:"model"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+}
+
+// CompareRequest runs one prompt against several variants
+type CompareRequest struct {
+	Prompt       string           `json:"prompt" validate:"required,max=1000"`
+	Variants     []CompareVariant `json:"variants"`
+	CollectionID string           `json:"collection_id,omitempty"`
+}
+
+// CompareResult is the output of one comparison variant
+type CompareResult struct {
+	Variant          int                    `json:"variant"` // 1-based position in the request
+	Model            string                 `json:"model"`
+	Parameters       map[string]interface{} `json:"parameters,omitempty"`
+	Images           []GeneratedImageInfo   `json:"images"`
+	Cost             float64                `json:"cost"`
+	CostMicros       money.Micros           `json:"cost_micros"` // Cost in micro-dollars, exact
+	GenerationTimeMs int64                  `json:"generation_time_ms,omitempty"`
+	Error            string                 `json:"error,omitempty"`
+	ErrorCode        string                 `json:"error_code,omitempty"` // stable FAL error code when Error is set
+	Retryable        bool                   `json:"retryable,omitempty"`  // whether retrying the failed request may succeed
+}
+
+// CompareResponse groups the outputs of a comparison for evaluation
+type CompareResponse struct {
+	ComparisonID string          `json:"comparison_id"`
+	Prompt       string          `json:"prompt"`
+	Results      []CompareResult `json:"results"`
+	TotalCost    float64         `json:"total_cost"`
+	TotalMicros  money.Micros    `json:"total_cost_micros"`
+}
+
+// SweepRequest generates a grid across ranges of generation parameters
+type SweepRequest struct {
+	Model        string                 `json:"model" validate:"required"`
+	Prompt       string                 `json:"prompt" validate:"required,max=1000"`
+	Parameters   map[string]interface{} `json:"parameters,omitempty"` // shared by every cell
+	Axes         map[string][]float64   `json:"axes"`                 // guidance_scale, num_inference_steps and/or seed
+	MaxCost      float64                `json:"max_cost,omitempty"`   // budget in USD
+	CollectionID string                 `json:"collection_id,omitempty"`
+}
+
+// SweepAxis is one varied parameter of a sweep matrix
+type SweepAxis struct {
+	Name   string    `json:"name"`
+	Values []float64 `json:"values"`
+}
+
+// SweepCell is one cell of a sweep matrix
+type SweepCell struct {
+	Coordinates      []int                  `json:"coordinates"` // index into each axis' values
+	Parameters       map[string]interface{} `json:"parameters"`
+	Images           []GeneratedImageInfo   `json:"images"`
+	Cost             float64                `json:"cost"`
+	CostMicros       money.Micros           `json:"cost_micros"` // Cost in micro-dollars, exact
+	GenerationTimeMs int64                  `json:"generation_time_ms,omitempty"`
+	Error            string                 `json:"error,omitempty"`
+	ErrorCode        string                 `json:"error_code,omitempty"` // stable FAL error code when Error is set
+	Retryable        bool                   `json:"retryable,omitempty"`  // whether retrying the failed request may succeed
+}
+
+// SweepResponse is the generated matrix, cells in row-major order
+type SweepResponse struct {
+	SweepID      string       `json:"sweep_id"`
+	Model        string       `json:"model"`
+	Prompt       string       `json:"prompt"`
+	Axes         []SweepAxis  `json:"axes"`
+	Cells        []SweepCell  `json:"cells"`
+	TotalCost    float64      `json:"total_cost"`
+	TotalMicros  money.Micros `json:"total_cost_micros"`
+	Budget       float64      `json:"budget"`
+	BudgetMicros money.Micros `json:"budget_micros"`
+}
+
+// DeriveImageRequest generates a new image from an existing one: an edit
+// takes a new prompt, a variation takes parameter overrides
+type DeriveImageRequest struct {
+	Prompt       string                 `json:"prompt,omitempty"`
+	Parameters   map[string]interface{} `json:"parameters,omitempty"`
+	CollectionID string                 `json:"collection_id,omitempty"`
+}
+
+// RetryGenerationRequest is the optional body of a failed generation retry
+type RetryGenerationRequest struct {
+	CollectionID string `json:"collection_id,omitempty"`
+}
+
+// DeriveImageResponse is a generation derived from a parent image
+type DeriveImageResponse struct {
+	GenerateImageResponse
+	ParentID string `json:"parent_id"`
+	Relation string `json:"relation"` // edit, regenerate or variation
+	Seed     int64  `json:"seed,omitempty"`
+}
+
+// LineageNode is one image in a lineage tree
+type LineageNode struct {
+	ID       string              `json:"id"`
+	ParentID string              `json:"parent_id,omitempty"`
+	Relation string              `json:"relation,omitempty"` // how the image derives from its parent
+	Prompt   string              `json:"prompt"`
+	Model    string              `json:"model"`
+	Seed     int64               `json:"seed,omitempty"`
+	Image    *GeneratedImageInfo `json:"image,omitempty"` // omitted for deleted images
+	Current  bool                `json:"current,omitempty"`
+	Deleted  bool                `json:"deleted,omitempty"`
+	Created  time.Time           `json:"created"`
+	Children []LineageNode       `json:"children"`
+}
+
+// SimilarImage is an image ranked by embedding similarity to a query image or text
+type SimilarImage struct {
+	ID      string             `json:"id"`
+	Prompt  string             `json:"prompt"`
+	Caption string             `json:"caption,omitempty"`
+	Model   string             `json:"model"`
+	Score   float64            `json:"score"` // cosine similarity; 1 is identical
+	Image   GeneratedImageInfo `json:"image"`
+	Created time.Time          `json:"created"`
+}
+
+// ImageCaptionResponse is the caption stored for an image
+type ImageCaptionResponse struct {
+	ID      string `json:"id"`
+	Caption string `json:"caption"`
+}
+
+// LineageResponse is the lineage tree an image belongs to
+type LineageResponse struct {
+	ImageID   string      `json:"image_id"`
+	Root      LineageNode `json:"root"`
+	Nodes     int         `json:"nodes"`
+	Truncated bool        `json:"truncated,omitempty"`
+}
+
+// PublishPromptRequest shares a prompt in the community library
+type PublishPromptRequest struct {
+	Title      string                 `json:"title"`
+	Prompt     string                 `json:"prompt"`
+	Model      string                 `json:"model"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	ExampleIDs []string               `json:"example_ids,omitempty"` // the author's images shown as examples
+}
+
+// ReportPromptRequest flags a community prompt
+type ReportPromptRequest struct {
+	Reason string `json:"reason,omitempty"`
+}
+
+// UsePromptRequest generates from a community prompt
+type UsePromptRequest struct {
+	CollectionID string `json:"collection_id,omitempty"`
+}
+
+// OutpaintRequest extends an image by the given number of pixels per side
+type OutpaintRequest struct {
+	Left         int    `json:"left"`
+	Right        int    `json:"right"`
+	Top          int    `json:"top"`
+	Bottom       int    `json:"bottom"`
+	Prompt       string `json:"prompt,omitempty"` // defaults to the source image's prompt
+	CollectionID string `json:"collection_id,omitempty"`
+}
+
+// PipelineStep is one stage of a pipeline: generate, upscale,
+// remove_background or save_to_folder
+type PipelineStep struct {
+	Type        string                 `json:"type"`
+	Model       string                 `json:"model,omitempty"`
+	Prompt      string                 `json:"prompt,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	FolderID    string                 `json:"folder_id,omitempty"`
+	MaxAttempts int                    `json:"max_attempts,omitempty"`
+}
+
+// CreatePipelineRequest queues a multi-step pipeline run
+type CreatePipelineRequest struct {
+	Steps    []PipelineStep `json:"steps"`
+	Priority string         `json:"priority,omitempty"` // batch (default) or interactive, capped by the user's tier
+}
+
+// SavePipelineTemplateRequest creates a pipeline template or saves a new
+// version of one. Steps may use {{name}} placeholders declared in Variables,
+// whose values are the defaults (empty means required on every run).
+type SavePipelineTemplateRequest struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	OrgID       string            `json:"org_id,omitempty"` // share with an organization's members
+	Steps       []PipelineStep    `json:"steps"`
+	Variables   map[string]string `json:"variables,omitempty"`
+}
+
+// RunPipelineTemplateRequest runs a template with per-run variable values
+type RunPipelineTemplateRequest struct {
+	Version   int               `json:"version,omitempty"` // defaults to the latest version
+	Variables map[string]string `json:"variables,omitempty"`
+	Priority  string            `json:"priority,omitempty"` // as in CreatePipelineRequest
+}
@@ -0,0 +1,93 @@
+package modelstats
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"generatio-pb/internal/pagination"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// MaxListedFailures caps a page of failed generations
+const MaxListedFailures = 50
+
+// ErrFailureNotFound is returned for jobs that did not fail or belong to
+// another user
+var ErrFailureNotFound = errors.New("failed generation not found")
+
+// Failure is a failed generation job as shown to its user
+type Failure struct {
+	ID         string                 `json:"id"`
+	Model      string                 `json:"model"`
+	Prompt     string                 `json:"prompt"`
+	Parameters map[string]interface{} `json:"parameters"`
+	ErrorCode  string                 `json:"error_code"`
+	Message    string                 `json:"message"`
+	Retryable  bool                   `json:"retryable"`
+	RequestID  string                 `json:"request_id,omitempty"`
+	Cost       float64                `json:"cost"`
+	RetriedAt  *time.Time             `json:"retried_at,omitempty"`
+	Created    time.Time              `json:"created"`
+}
+
+// Failures returns the user's failed generations on the requested page,
+// newest first, and the cursor of the following page
+func (r *Recorder) Failures(userID string, page pagination.Page) ([]*Failure, string, error) {
+	records, next, err := pagination.Find(r.app, Collection, "user_id = {:user_id} && success = false",
+		map[string]any{"user_id": userID}, page)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch failed generations: %w", err)
+	}
+
+	failures := make([]*Failure, 0, len(records))
+	for _, record := range records {
+		failures = append(failures, failureFromRecord(record))
+	}
+	return failures, next, nil
+}
+
+// Failure returns one of the user's failed generations
+func (r *Recorder) Failure(userID, id string) (*Failure, error) {
+	record, err := r.app.FindRecordById(Collection, id)
+	if err != nil || record.GetString("user_id") != userID || record.GetBool("success") {
+		return nil, ErrFailureNotFound
+	}
+	return failureFromRecord(record), nil
+}
+
+// MarkRetried records that a failed generation was retried successfully
+func (r *Recorder) MarkRetried(id string) error {
+	record, err := r.app.FindRecordById(Collection, id)
+	if err != nil {
+		return ErrFailureNotFound
+	}
+	record.Set("retried_at", types.NowDateTime())
+	if err := r.app.Save(record); err != nil {
+		return fmt.Errorf("failed to mark generation retried: %w", err)
+	}
+	return nil
+}
+
+func failureFromRecord(record *core.Record) *Failure {
+	failure := &Failure{
+		ID:         record.Id,
+		Model:      record.GetString("model"),
+		Prompt:     record.GetString("prompt"),
+		Parameters: map[string]interface{}{},
+		ErrorCode:  record.GetString("error_code"),
+		Message:    record.GetString("error_message"),
+		Retryable:  record.GetBool("retryable"),
+		RequestID:  record.GetString("request_id"),
+		Cost:       record.GetFloat("cost"),
+		Created:    record.GetDateTime("created").Time(),
+	}
+	record.UnmarshalJSONField("parameters", &failure.Parameters)
+	if retried := record.GetDateTime("retried_at"); !retried.IsZero() {
+		retriedAt := retried.Time()
+		failure.RetriedAt = &retriedAt
+	}
+	return failure
+}
@@ -0,0 +1,154 @@
+package modelstats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Collection stores one record per generation job sent to FAL
+const Collection = "generation_jobs"
+
+// Window limits for the statistics endpoint
+const (
+	DefaultWindowDays = 7
+	MaxWindowDays     = 90
+)
+
+// Job is the outcome of one generation
+type Job struct {
+	Model    string
+	Duration time.Duration
+	Success  bool
+	Cost     float64
+
+	// Failed jobs keep what was requested and why it failed, so the user can
+	// see and retry them
+	Prompt     string
+	Parameters map[string]interface{}
+	ErrorCode  string
+	Message    string
+	Retryable  bool
+	RequestID  string // FAL AI's queue request ID, when it got that far
+}
+
+// ModelStats summarises the jobs of one model. Timings only cover successful
+// jobs, since failures often return before any work was done.
+type ModelStats struct {
+	Model       string  `json:"model"`
+	Jobs        int     `json:"jobs"`
+	Failures    int     `json:"failures"`
+	FailureRate float64 `json:"failure_rate"`
+	AvgMS       int64   `json:"avg_generation_ms"`
+	P95MS       int64   `json:"p95_generation_ms"`
+}
+
+// Recorder stores job outcomes and aggregates them per model
+type Recorder struct {
+	app core.App
+}
+
+// NewRecorder creates a recorder backed by the generation_jobs collection
+func NewRecorder(app core.App) *Recorder {
+	return &Recorder{app: app}
+}
+
+// Record stores the outcome of a job run by userID
+func (r *Recorder) Record(userID string, job Job) error {
+	return r.RecordIn(r.app, userID, job)
+}
+
+// RecordIn records a job through app, so it can be saved in a transaction
+// with the generation's other writes
+func (r *Recorder) RecordIn(app core.App, userID string, job Job) error {
+	collection, err := app.FindCollectionByNameOrId(Collection)
+	if err != nil {
+		return fmt.Errorf("failed to find generation jobs collection: %w", err)
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("user_id", userID)
+	record.Set("model", job.Model)
+	record.Set("duration_ms", job.Duration.Milliseconds())
+	record.Set("success", job.Success)
+	record.Set("cost", job.Cost)
+	if !job.Success {
+		record.Set("prompt", job.Prompt)
+		record.Set("parameters", job.Parameters)
+		record.Set("error_code", job.ErrorCode)
+		record.Set("error_message", job.Message)
+		record.Set("retryable", job.Retryable)
+		record.Set("request_id", job.RequestID)
+	}
+	if err := app.Save(record); err != nil {
+		return fmt.Errorf("failed to save generation job: %w", err)
+	}
+	return nil
+}
+
+// Stats summarises the jobs recorded since the given time, sorted by model
+func (r *Recorder) Stats(since time.Time) ([]ModelStats, error) {
+	records, err := r.app.FindRecordsByFilter(Collection, "created >= {:since}", "", 0, 0, map[string]any{
+		"since": since.UTC().Format("2006-01-02 15:04:05"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch generation jobs: %w", err)
+	}
+
+	jobs := make([]Job, 0, len(records))
+	for _, record := range records {
+		jobs = append(jobs, Job{
+			Model:    record.GetString("model"),
+			Duration: time.Duration(record.GetInt("duration_ms")) * time.Millisecond,
+			Success:  record.GetBool("success"),
+		})
+	}
+	return Summarize(jobs), nil
+}
+
+// Summarize aggregates jobs per model, sorted by model
+func Summarize(jobs []Job) []ModelStats {
+	byModel := make(map[string]*ModelStats)
+	durations := make(map[string][]time.Duration)
+
+	for _, job := range jobs {
+		stats, exists := byModel[job.Model]
+		if !exists {
+			stats = &ModelStats{Model: job.Model}
+			byModel[job.Model] = stats
+		}
+		stats.Jobs++
+		if !job.Success {
+			stats.Failures++
+			continue
+		}
+		durations[job.Model] = append(durations[job.Model], job.Duration)
+	}
+
+	summary := make([]ModelStats, 0, len(byModel))
+	for model, stats := range byModel {
+		stats.FailureRate = float64(stats.Failures) / float64(stats.Jobs)
+
+		successful := durations[model]
+		if len(successful) > 0 {
+			sort.Slice(successful, func(i, j int) bool { return successful[i] < successful[j] })
+
+			var total time.Duration
+			for _, d := range successful {
+				total += d
+			}
+			stats.AvgMS = (total / time.Duration(len(successful))).Milliseconds()
+
+			// Nearest-rank percentile
+			rank := int(math.Ceil(0.95*float64(len(successful)))) - 1
+			stats.P95MS = successful[rank].Milliseconds()
+		}
+		summary = append(summary, *stats)
+	}
+
+	sort.Slice(summary, func(i, j int) bool { return summary[i].Model < summary[j].Model })
+	return summary
+}
@@ -0,0 +1,96 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Image moderation states stored in images.moderation_status
+const (
+	StatusApproved    = "approved"
+	StatusQuarantined = "quarantined"
+	StatusOverridden  = "overridden"
+)
+
+// Result is the outcome of classifying a single image
+type Result struct {
+	Flagged bool    `json:"flagged"`
+	Score   float64 `json:"score"`
+	Reason  string  `json:"reason,omitempty"`
+}
+
+// Classifier decides whether a generated image is safe to show
+type Classifier interface {
+	Classify(ctx context.Context, token, imageURL string) (*Result, error)
+}
+
+// FALClassifier runs images through FAL's hosted NSFW detector
+type FALClassifier struct {
+	endpoint   string
+	threshold  float64
+	httpClient *http.Client
+}
+
+// NewFALClassifier creates a classifier that flags images at or above threshold NSFW probability
+func NewFALClassifier(endpoint string, threshold float64) *FALClassifier {
+	if endpoint == "" {
+		endpoint = "https://fal.run/fal-ai/imageutils/nsfw"
+	}
+
+	return &FALClassifier{
+		endpoint:  endpoint,
+		threshold: threshold,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// Classify submits the image URL to the detector and compares its probability against the threshold
+func (c *FALClassifier) Classify(ctx context.Context, token, imageURL string) (*Result, error) {
+	body, err := json.Marshal(map[string]string{"image_url": imageURL})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Key "+token)
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("moderation request failed: HTTP %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	var parsed struct {
+		NSFWProbability float64 `json:"nsfw_probability"`
+	}
+	if err := json.Unmarshal(respBody, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	result := &Result{Score: parsed.NSFWProbability}
+	if parsed.NSFWProbability >= c.threshold {
+		result.Flagged = true
+		result.Reason = "nsfw"
+	}
+	return result, nil
+}
@@ -0,0 +1,79 @@
+// Package money keeps costs as whole micro-dollars, so totals summed over
+// thousands of generations do not drift the way float64 dollars do
+package money
+
+import (
+	"fmt"
+	"math"
+)
+
+// Micros is an amount of USD in millionths of a dollar
+type Micros int64
+
+// PerDollar is the number of micro-dollars in one USD
+const PerDollar Micros = 1_000_000
+
+// FromUSD converts a USD amount, rounding to the nearest micro-dollar
+func FromUSD(usd float64) Micros {
+	return Micros(math.Round(usd * float64(PerDollar)))
+}
+
+// USD returns the amount in dollars, for display and older readers
+func (m Micros) USD() float64 {
+	return float64(m) / float64(PerDollar)
+}
+
+// Abs returns the magnitude of m
+func (m Micros) Abs() Micros {
+	if m < 0 {
+		return -m
+	}
+	return m
+}
+
+// Times returns the cost of n items priced m each
+func (m Micros) Times(n int) Micros {
+	return m * Micros(n)
+}
+
+// Split divides m into n shares that differ by at most one micro-dollar and
+// sum to exactly m, the larger shares first. It returns nil for n < 1.
+func (m Micros) Split(n int) []Micros {
+	if n < 1 {
+		return nil
+	}
+	share, remainder := m/Micros(n), m%Micros(n)
+	shares := make([]Micros, n)
+	for i := range shares {
+		shares[i] = share
+		if Micros(i) < remainder {
+			shares[i]++
+		}
+	}
+	return shares
+}
+
+// ImageCost is the cost an image record keeps in other_info. Images saved
+// before micro-dollar accounting only have cost_usd.
+type ImageCost struct {
+	CostUSD    float64 `json:"cost_usd"`
+	CostMicros Micros  `json:"cost_micros"`
+}
+
+// Amount returns the recorded cost
+func (c ImageCost) Amount() Micros {
+	if c.CostMicros != 0 {
+		return c.CostMicros
+	}
+	return FromUSD(c.CostUSD)
+}
+
+// CostSQL is a SQLite expression for the ImageCost.Amount of the JSON kept in
+// column, so costs can be summed by the database instead of loading every
+// record. Rows without valid JSON cost nothing.
+func CostSQL(column string) string {
+	return fmt.Sprintf("(CASE WHEN json_valid([[%[1]s]]) THEN COALESCE("+
+		"NULLIF(CAST(json_extract([[%[1]s]], '$.cost_micros') AS INTEGER), 0), "+
+		"CAST(ROUND(COALESCE(json_extract([[%[1]s]], '$.cost_usd'), 0) * %[2]d) AS INTEGER)"+
+		") ELSE 0 END)", column, PerDollar)
+}
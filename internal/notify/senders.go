@@ -0,0 +1,156 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+)
+
+// Sender delivers a message over a single channel
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// EmailSender delivers messages through the PocketBase mailer
+type EmailSender struct {
+	app core.App
+}
+
+// NewEmailSender creates an email sender using the app's mail settings
+func NewEmailSender(app core.App) *EmailSender {
+	return &EmailSender{app: app}
+}
+
+// Send sends the message to msg.Target as an email
+func (s *EmailSender) Send(ctx context.Context, msg Message) error {
+	settings := s.app.Settings()
+
+	html := msg.HTML
+	if html == "" {
+		html = "<p>" + msg.Body + "</p>"
+	}
+
+	return s.app.NewMailClient().Send(&mailer.Message{
+		From: mail.Address{
+			Address: settings.Meta.SenderAddress,
+			Name:    settings.Meta.SenderName,
+		},
+		To:      []mail.Address{{Address: msg.Target}},
+		Subject: msg.Subject,
+		HTML:    html,
+		Text:    msg.Body,
+	})
+}
+
+// WebhookSender posts messages as JSON to an HTTP endpoint.
+// The payload shape depends on the channel (generic webhook, Discord or Slack).
+type WebhookSender struct {
+	channel    string
+	httpClient *http.Client
+}
+
+// NewWebhookSender creates a sender for the webhook, Discord or Slack channel
+func NewWebhookSender(channel string) *WebhookSender {
+	return &WebhookSender{
+		channel: channel,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Send posts the formatted message to msg.Target
+func (s *WebhookSender) Send(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(s.payload(msg))
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, msg.Target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned HTTP %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	return nil
+}
+
+// maxChatImages caps the thumbnails attached to a chat message
+const maxChatImages = 4
+
+// payload formats the message for the target channel
+func (s *WebhookSender) payload(msg Message) interface{} {
+	text := msg.Body
+	if msg.Subject != "" {
+		text = msg.Subject + "\n" + msg.Body
+	}
+
+	images := msg.Images
+	if len(images) > maxChatImages {
+		images = images[:maxChatImages]
+	}
+
+	switch s.channel {
+	case ChannelDiscord:
+		// Each thumbnail is an embed; the first one carries the message
+		payload := map[string]interface{}{"content": text}
+		if len(images) > 0 {
+			embeds := make([]map[string]interface{}, 0, len(images))
+			for _, image := range images {
+				embeds = append(embeds, map[string]interface{}{"image": map[string]string{"url": image}})
+			}
+			embeds[0]["title"] = msg.Subject
+			embeds[0]["description"] = msg.Body
+			payload["content"] = ""
+			payload["embeds"] = embeds
+		}
+		return payload
+	case ChannelSlack:
+		// text is the notification fallback; blocks render the thumbnails
+		payload := map[string]interface{}{"text": text}
+		if len(images) > 0 {
+			blocks := []map[string]interface{}{{
+				"type": "section",
+				"text": map[string]string{"type": "mrkdwn", "text": "*" + msg.Subject + "*\n" + msg.Body},
+			}}
+			for _, image := range images {
+				blocks = append(blocks, map[string]interface{}{"type": "image", "image_url": image, "alt_text": "generated image"})
+			}
+			payload["blocks"] = blocks
+		}
+		return payload
+	default:
+		payload := map[string]interface{}{
+			"event":   msg.Event,
+			"user_id": msg.UserID,
+			"subject": msg.Subject,
+			"body":    msg.Body,
+		}
+		if len(msg.Images) > 0 {
+			payload["images"] = msg.Images
+		}
+		if len(msg.Data) > 0 {
+			payload["data"] = msg.Data
+		}
+		return payload
+	}
+}
@@ -0,0 +1,332 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// OutboxCollection is the collection persisting queued notifications
+const OutboxCollection = "notification_outbox"
+
+// Delivery channels
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+	ChannelDiscord = "discord"
+	ChannelSlack   = "slack"
+)
+
+// Outbox record states
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusDead    = "dead"
+)
+
+// Event names attached to notifications
+const (
+	EventJobCompleted    = "job.completed"
+	EventBudgetAlert     = "budget.alert"
+	EventSecurityNotice  = "security.notice"
+	EventInvitation      = "invitation.created"
+	EventSpendingAnomaly = "spending.anomaly"
+	EventKeyHealth       = "key.health"
+	EventWebhookTest     = "webhook.test"
+)
+
+const (
+	// DefaultMaxAttempts is how many deliveries are tried before a message is dead-lettered
+	DefaultMaxAttempts = 5
+
+	// DefaultBaseBackoff is the delay before the first retry; it doubles on each attempt
+	DefaultBaseBackoff = 30 * time.Second
+)
+
+// Message is a single outbound notification
+type Message struct {
+	ID      string `json:"-"` // Outbox record ID, set on delivery
+	UserID  string `json:"user_id"`
+	Channel string `json:"channel"`
+	Target  string `json:"target"` // Email address or webhook URL
+	Event   string `json:"event"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	HTML    string `json:"html,omitempty"`
+
+	// Images are thumbnail URLs chat channels show with the message
+	Images []string `json:"images,omitempty"`
+
+	// Data is the structured payload of machine-readable deliveries
+	Data map[string]interface{} `json:"data,omitempty"`
+}
+
+// permanentError marks a delivery failure that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the message is dead-lettered without further attempts
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Service queues notifications in a persisted outbox and delivers them with retries
+type Service struct {
+	app         core.App
+	senders     map[string]Sender
+	maxAttempts int
+	baseBackoff time.Duration
+	interval    time.Duration
+
+	processMutex sync.Mutex
+	kick         chan struct{}
+	stopChan     chan struct{}
+	stopOnce     sync.Once
+}
+
+// NewService creates a notifier with the default email, webhook, Discord and Slack senders
+func NewService(app core.App) *Service {
+	return &Service{
+		app: app,
+		senders: map[string]Sender{
+			ChannelEmail:   NewEmailSender(app),
+			ChannelWebhook: NewWebhookSender(ChannelWebhook),
+			ChannelDiscord: NewWebhookSender(ChannelDiscord),
+			ChannelSlack:   NewWebhookSender(ChannelSlack),
+		},
+		maxAttempts: DefaultMaxAttempts,
+		baseBackoff: DefaultBaseBackoff,
+		interval:    30 * time.Second,
+		kick:        make(chan struct{}, 1),
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// SetSender registers or replaces the sender for a channel
+func (s *Service) SetSender(channel string, sender Sender) {
+	s.senders[channel] = sender
+}
+
+// SetRetryPolicy configures the maximum delivery attempts and the base backoff
+func (s *Service) SetRetryPolicy(maxAttempts int, baseBackoff time.Duration) {
+	if maxAttempts > 0 {
+		s.maxAttempts = maxAttempts
+	}
+	if baseBackoff >= 0 {
+		s.baseBackoff = baseBackoff
+	}
+}
+
+// Start begins the background delivery loop
+func (s *Service) Start() {
+	go s.run()
+	log.Printf("Notification service started with interval: %v", s.interval)
+}
+
+// Stop stops the background delivery loop and waits for a delivery pass in progress
+func (s *Service) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+	})
+	s.processMutex.Lock()
+	s.processMutex.Unlock()
+}
+
+// Enqueue persists a message in the outbox and schedules immediate delivery
+func (s *Service) Enqueue(msg Message) error {
+	if _, exists := s.senders[msg.Channel]; !exists {
+		return fmt.Errorf("unsupported notification channel: %s", msg.Channel)
+	}
+	if msg.Target == "" {
+		return fmt.Errorf("notification target cannot be empty")
+	}
+
+	collection, err := s.app.FindCollectionByNameOrId(OutboxCollection)
+	if err != nil {
+		return fmt.Errorf("failed to find outbox collection: %w", err)
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("user_id", msg.UserID)
+	record.Set("channel", msg.Channel)
+	record.Set("target", msg.Target)
+	record.Set("event", msg.Event)
+	record.Set("subject", msg.Subject)
+	record.Set("body", msg.Body)
+	record.Set("html", msg.HTML)
+	record.Set("images", msg.Images)
+	record.Set("data", msg.Data)
+	record.Set("status", StatusPending)
+	record.Set("attempts", 0)
+	record.Set("next_attempt_at", types.NowDateTime())
+
+	if err := s.app.Save(record); err != nil {
+		return fmt.Errorf("failed to save notification: %w", err)
+	}
+
+	// Wake the delivery loop without blocking the caller
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// ProcessDue attempts delivery of every pending message whose retry time has come
+func (s *Service) ProcessDue(ctx context.Context) {
+	s.processMutex.Lock()
+	defer s.processMutex.Unlock()
+
+	// A kick can still be pending when the service stops
+	select {
+	case <-s.stopChan:
+		return
+	default:
+	}
+
+	records, err := s.app.FindRecordsByFilter(
+		OutboxCollection,
+		"status = {:status} && next_attempt_at <= {:now}",
+		"next_attempt_at",
+		100,
+		0,
+		map[string]any{
+			"status": StatusPending,
+			"now":    types.NowDateTime().String(),
+		},
+	)
+	if err != nil {
+		return
+	}
+
+	for _, record := range records {
+		if ctx.Err() != nil {
+			return
+		}
+		s.deliver(ctx, record)
+	}
+}
+
+// Retry moves a dead-lettered message back to pending with a fresh attempt budget
+func (s *Service) Retry(id string) error {
+	record, err := s.app.FindRecordById(OutboxCollection, id)
+	if err != nil {
+		return fmt.Errorf("notification not found")
+	}
+
+	if record.GetString("status") != StatusDead {
+		return fmt.Errorf("only dead-lettered notifications can be retried")
+	}
+
+	record.Set("status", StatusPending)
+	record.Set("attempts", 0)
+	record.Set("next_attempt_at", types.NowDateTime())
+	if err := s.app.Save(record); err != nil {
+		return fmt.Errorf("failed to save notification: %w", err)
+	}
+
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// DeadLetters returns the most recent messages that exhausted their retries
+func (s *Service) DeadLetters(limit int) ([]*core.Record, error) {
+	return s.app.FindRecordsByFilter(
+		OutboxCollection,
+		"status = {:status}",
+		"-updated",
+		limit,
+		0,
+		map[string]any{"status": StatusDead},
+	)
+}
+
+// run is the main delivery loop
+func (s *Service) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.ProcessDue(context.Background())
+		case <-s.kick:
+			s.ProcessDue(context.Background())
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// deliver sends a single outbox record and records the outcome
+func (s *Service) deliver(ctx context.Context, record *core.Record) {
+	msg := Message{
+		ID:      record.Id,
+		UserID:  record.GetString("user_id"),
+		Channel: record.GetString("channel"),
+		Target:  record.GetString("target"),
+		Event:   record.GetString("event"),
+		Subject: record.GetString("subject"),
+		Body:    record.GetString("body"),
+		HTML:    record.GetString("html"),
+	}
+	record.UnmarshalJSONField("images", &msg.Images)
+	record.UnmarshalJSONField("data", &msg.Data)
+
+	attempts := record.GetInt("attempts") + 1
+	record.Set("attempts", attempts)
+
+	var sendErr error
+	if sender, exists := s.senders[msg.Channel]; exists {
+		sendErr = sender.Send(ctx, msg)
+	} else {
+		sendErr = fmt.Errorf("unsupported notification channel: %s", msg.Channel)
+	}
+
+	switch {
+	case sendErr == nil:
+		record.Set("status", StatusSent)
+		record.Set("last_error", "")
+	case attempts >= s.maxAttempts || errors.As(sendErr, new(*permanentError)):
+		record.Set("status", StatusDead)
+		record.Set("last_error", sendErr.Error())
+		s.app.Logger().Warn("Notification dead-lettered",
+			"id", record.Id,
+			"channel", msg.Channel,
+			"event", msg.Event,
+			"attempts", attempts,
+			"error", sendErr,
+		)
+	default:
+		record.Set("last_error", sendErr.Error())
+		record.Set("next_attempt_at", types.NowDateTime().Add(s.backoff(attempts)))
+	}
+
+	if err := s.app.Save(record); err != nil {
+		s.app.Logger().Error("Failed to update notification", "id", record.Id, "error", err)
+	}
+}
+
+// backoff returns the exponential delay before the next attempt
+func (s *Service) backoff(attempts int) time.Duration {
+	delay := s.baseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+	}
+	return delay
+}
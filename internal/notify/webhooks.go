@@ -0,0 +1,225 @@
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// WebhooksCollection stores the Discord and Slack webhooks users register
+const WebhooksCollection = "chat_webhooks"
+
+// MaxWebhooksPerUser caps the chat webhooks one user can register
+const MaxWebhooksPerUser = 5
+
+// ChatEvents are the events chat webhooks can subscribe to
+var ChatEvents = []string{EventJobCompleted, EventBudgetAlert}
+
+// chatHosts are the hosts chat webhook URLs must point at, by channel
+var chatHosts = map[string][]string{
+	ChannelDiscord: {"discord.com", "discordapp.com", "ptb.discord.com", "canary.discord.com"},
+	ChannelSlack:   {"hooks.slack.com"},
+}
+
+// chatPaths are the path prefixes of chat webhook URLs, by channel
+var chatPaths = map[string]string{
+	ChannelDiscord: "/api/webhooks/",
+	ChannelSlack:   "/services/",
+}
+
+// ErrWebhookNotFound is returned for chat webhooks the user does not own
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// ChatWebhook is a Discord or Slack webhook receiving a user's notifications.
+// The URL is a secret, so only its host and last characters are shown.
+type ChatWebhook struct {
+	ID      string    `json:"id"`
+	Channel string    `json:"channel"`
+	URL     string    `json:"url"`
+	Events  []string  `json:"events"`
+	Created time.Time `json:"created"`
+}
+
+// ValidateChatWebhook checks that rawURL is a webhook of the channel's
+// service and that events are chat events
+func ValidateChatWebhook(channel, rawURL string, events []string) error {
+	hosts, exists := chatHosts[channel]
+	if !exists {
+		return fmt.Errorf("channel must be %q or %q", ChannelDiscord, ChannelSlack)
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Scheme != "https" || !slices.Contains(hosts, parsed.Hostname()) ||
+		!strings.HasPrefix(parsed.Path, chatPaths[channel]) || parsed.User != nil {
+		return fmt.Errorf("url must be a %s webhook URL (https://%s%s...)", channel, hosts[0], chatPaths[channel])
+	}
+
+	for _, event := range events {
+		if !slices.Contains(ChatEvents, event) {
+			return fmt.Errorf("unknown event %q; use %s", event, strings.Join(ChatEvents, " or "))
+		}
+	}
+	return nil
+}
+
+// RegisterWebhook saves a chat webhook for userID. No events subscribes it
+// to every chat event.
+func (s *Service) RegisterWebhook(userID, channel, rawURL string, events []string) (*ChatWebhook, error) {
+	if err := ValidateChatWebhook(channel, rawURL, events); err != nil {
+		return nil, err
+	}
+	if len(events) == 0 {
+		events = ChatEvents
+	}
+
+	existing, err := s.userWebhooks(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= MaxWebhooksPerUser {
+		return nil, fmt.Errorf("you cannot register more than %d webhooks", MaxWebhooksPerUser)
+	}
+
+	collection, err := s.app.FindCollectionByNameOrId(WebhooksCollection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find webhooks collection: %w", err)
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("user_id", userID)
+	record.Set("channel", channel)
+	record.Set("url", rawURL)
+	events = slices.Clone(events)
+	slices.Sort(events)
+	record.Set("events", slices.Compact(events))
+	if err := s.app.Save(record); err != nil {
+		return nil, fmt.Errorf("failed to save webhook: %w", err)
+	}
+	return webhookFromRecord(record), nil
+}
+
+// Webhooks lists a user's chat webhooks, oldest first
+func (s *Service) Webhooks(userID string) ([]*ChatWebhook, error) {
+	records, err := s.userWebhooks(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks := make([]*ChatWebhook, 0, len(records))
+	for _, record := range records {
+		webhooks = append(webhooks, webhookFromRecord(record))
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook removes one of the user's chat webhooks
+func (s *Service) DeleteWebhook(id, userID string) error {
+	record, err := s.app.FindRecordById(WebhooksCollection, id)
+	if err != nil || record.GetString("user_id") != userID {
+		return ErrWebhookNotFound
+	}
+	return s.app.Delete(record)
+}
+
+// TestWebhook queues a test message to one of the user's chat webhooks
+func (s *Service) TestWebhook(id, userID string) error {
+	record, err := s.app.FindRecordById(WebhooksCollection, id)
+	if err != nil || record.GetString("user_id") != userID {
+		return ErrWebhookNotFound
+	}
+
+	return s.Enqueue(Message{
+		UserID:  userID,
+		Channel: record.GetString("channel"),
+		Target:  record.GetString("url"),
+		Event:   EventWebhookTest,
+		Subject: "Generatio notifications are connected",
+		Body:    "This channel will receive the notifications you subscribed it to.",
+	})
+}
+
+// EnqueueChat queues msg to each of msg.UserID's chat webhooks subscribed to
+// msg.Event; Channel and Target are set per webhook. It returns how many
+// messages were queued.
+func (s *Service) EnqueueChat(msg Message) (int, error) {
+	records, err := s.userWebhooks(msg.UserID)
+	if err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, record := range records {
+		var events []string
+		record.UnmarshalJSONField("events", &events)
+		if !slices.Contains(events, msg.Event) {
+			continue
+		}
+
+		msg.Channel = record.GetString("channel")
+		msg.Target = record.GetString("url")
+		if err := s.Enqueue(msg); err != nil {
+			return queued, err
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+// Sent reports whether a message with the user, event and subject was
+// queued since the given time
+func (s *Service) Sent(userID, event, subject string, since time.Time) bool {
+	records, err := s.app.FindRecordsByFilter(
+		OutboxCollection,
+		"user_id = {:user_id} && event = {:event} && subject = {:subject} && created >= {:since}",
+		"",
+		1,
+		0,
+		map[string]any{
+			"user_id": userID,
+			"event":   event,
+			"subject": subject,
+			"since":   since.UTC().Format(types.DefaultDateLayout),
+		},
+	)
+	return err == nil && len(records) > 0
+}
+
+func (s *Service) userWebhooks(userID string) ([]*core.Record, error) {
+	// Deployments without the collection have no webhooks
+	if _, err := s.app.FindCollectionByNameOrId(WebhooksCollection); err != nil {
+		return nil, nil
+	}
+
+	records, err := s.app.FindRecordsByFilter(WebhooksCollection, "user_id = {:user_id}", "created", 0, 0, map[string]any{"user_id": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch webhooks: %w", err)
+	}
+	return records, nil
+}
+
+func webhookFromRecord(record *core.Record) *ChatWebhook {
+	webhook := &ChatWebhook{
+		ID:      record.Id,
+		Channel: record.GetString("channel"),
+		URL:     maskWebhookURL(record.GetString("url")),
+		Events:  []string{},
+		Created: record.GetDateTime("created").Time(),
+	}
+	record.UnmarshalJSONField("events", &webhook.Events)
+	return webhook
+}
+
+// maskWebhookURL keeps a webhook URL's host and last four characters
+func maskWebhookURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || len(rawURL) < 4 {
+		return "…"
+	}
+	return "https://" + parsed.Host + "/…" + rawURL[len(rawURL)-4:]
+}
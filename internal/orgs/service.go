@@ -0,0 +1,287 @@
+package orgs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"generatio-pb/internal/money"
+	"generatio-pb/internal/repository"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Collections backing organizations
+const (
+	OrgsCollection    = "organizations"
+	MembersCollection = "organization_members"
+)
+
+// Member roles, from most to least privileged
+const (
+	RoleOwner  = "owner"  // Created the org; cannot be removed
+	RoleAdmin  = "admin"  // Manages members and sees spending
+	RoleMember = "member" // Adds folders and images to the shared library
+	RoleViewer = "viewer" // Browses the shared library
+)
+
+// ErrNotMember is returned when a user has no role in an organization
+var ErrNotMember = fmt.Errorf("organization not found")
+
+// Organization is a workspace whose folders and images are shared by its members
+type Organization struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	OwnerID string    `json:"owner_id"`
+	Role    string    `json:"role,omitempty"` // The requesting user's role
+	Created time.Time `json:"created"`
+}
+
+// Member is a user's membership in an organization
+type Member struct {
+	UserID string `json:"user_id"`
+	Email  string `json:"email"`
+	Role   string `json:"role"`
+}
+
+// MemberSpending is one member's share of an organization's spending
+type MemberSpending struct {
+	UserID string  `json:"user_id"`
+	Spent  float64 `json:"spent"`
+	Images int     `json:"images"`
+}
+
+// Spending aggregates generation cost across an organization's images
+type Spending struct {
+	TotalSpent  float64          `json:"total_spent"`
+	TotalImages int              `json:"total_images"`
+	Members     []MemberSpending `json:"members"`
+}
+
+// ValidRole reports whether role can be assigned to a member
+func ValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleMember, RoleViewer:
+		return true
+	}
+	return false
+}
+
+// CanWrite reports whether role may add folders and images to the org library
+func CanWrite(role string) bool {
+	return role == RoleOwner || role == RoleAdmin || role == RoleMember
+}
+
+// CanManage reports whether role may manage members and view spending
+func CanManage(role string) bool {
+	return role == RoleOwner || role == RoleAdmin
+}
+
+// Service manages organizations and their memberships
+type Service struct {
+	app    core.App
+	images *repository.Images
+}
+
+// NewService creates an organization service
+func NewService(app core.App) *Service {
+	return &Service{app: app, images: repository.NewImages(app)}
+}
+
+// Create creates an organization owned by ownerID
+func (s *Service) Create(ownerID, name string) (*Organization, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	if len(name) > 100 {
+		return nil, fmt.Errorf("name cannot exceed 100 characters")
+	}
+
+	collection, err := s.app.FindCollectionByNameOrId(OrgsCollection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find organizations collection: %w", err)
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("name", name)
+	record.Set("owner_id", ownerID)
+
+	err = s.app.RunInTransaction(func(txApp core.App) error {
+		if err := txApp.Save(record); err != nil {
+			return err
+		}
+		return saveMembership(txApp, record.Id, ownerID, RoleOwner)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create organization: %w", err)
+	}
+
+	org := orgFromRecord(record)
+	org.Role = RoleOwner
+	return org, nil
+}
+
+// ForUser lists the organizations a user belongs to with their role in each
+func (s *Service) ForUser(userID string) ([]*Organization, error) {
+	memberships, err := s.app.FindRecordsByFilter(MembersCollection, "user_id = {:user_id}", "", 0, 0, map[string]any{"user_id": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch memberships: %w", err)
+	}
+
+	orgs := make([]*Organization, 0, len(memberships))
+	for _, membership := range memberships {
+		record, err := s.app.FindRecordById(OrgsCollection, membership.GetString("org_id"))
+		if err != nil {
+			continue
+		}
+		org := orgFromRecord(record)
+		org.Role = membership.GetString("role")
+		orgs = append(orgs, org)
+	}
+
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].Name < orgs[j].Name })
+	return orgs, nil
+}
+
+// Role returns a user's role in an organization or ErrNotMember
+func (s *Service) Role(orgID, userID string) (string, error) {
+	membership, err := s.findMembership(orgID, userID)
+	if err != nil {
+		return "", ErrNotMember
+	}
+	return membership.GetString("role"), nil
+}
+
+// Members lists an organization's members
+func (s *Service) Members(orgID string) ([]Member, error) {
+	records, err := s.app.FindRecordsByFilter(MembersCollection, "org_id = {:org_id}", "", 0, 0, map[string]any{"org_id": orgID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch members: %w", err)
+	}
+
+	members := make([]Member, 0, len(records))
+	for _, record := range records {
+		member := Member{
+			UserID: record.GetString("user_id"),
+			Role:   record.GetString("role"),
+		}
+		if user, err := s.app.FindRecordById("generatio_users", member.UserID); err == nil {
+			member.Email = user.Email()
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// AddMember adds the user with the given email or changes their role
+func (s *Service) AddMember(orgID, email, role string) (*Member, error) {
+	if !ValidRole(role) {
+		return nil, fmt.Errorf("role must be one of: admin, member, viewer")
+	}
+
+	user, err := s.app.FindAuthRecordByEmail("generatio_users", strings.TrimSpace(email))
+	if err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	if existing, err := s.findMembership(orgID, user.Id); err == nil {
+		if existing.GetString("role") == RoleOwner {
+			return nil, fmt.Errorf("the owner's role cannot be changed")
+		}
+		existing.Set("role", role)
+		if err := s.app.Save(existing); err != nil {
+			return nil, fmt.Errorf("failed to update member: %w", err)
+		}
+	} else if err := saveMembership(s.app, orgID, user.Id, role); err != nil {
+		return nil, fmt.Errorf("failed to add member: %w", err)
+	}
+
+	return &Member{UserID: user.Id, Email: user.Email(), Role: role}, nil
+}
+
+// RemoveMember removes a user from an organization. Images and folders they
+// added stay in the shared library.
+func (s *Service) RemoveMember(orgID, userID string) error {
+	membership, err := s.findMembership(orgID, userID)
+	if err != nil {
+		return fmt.Errorf("member not found")
+	}
+	if membership.GetString("role") == RoleOwner {
+		return fmt.Errorf("the owner cannot be removed")
+	}
+
+	if err := s.app.Delete(membership); err != nil {
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+	return nil
+}
+
+// Spending totals the cost of every image generated into an organization
+func (s *Service) Spending(orgID string) (*Spending, error) {
+	records, err := s.images.Find("org_id = {:org_id}", "", 0, 0, map[string]any{"org_id": orgID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch images: %w", err)
+	}
+
+	// Costs are summed in micro-dollars and converted once
+	byMember := make(map[string]*MemberSpending)
+	memberSpent := make(map[string]money.Micros)
+	var totalSpent money.Micros
+	spending := &Spending{Members: []MemberSpending{}}
+	for _, record := range records {
+		var info money.ImageCost
+		record.UnmarshalJSONField("other_info", &info)
+
+		userID := record.GetString("user_id")
+		member, exists := byMember[userID]
+		if !exists {
+			member = &MemberSpending{UserID: userID}
+			byMember[userID] = member
+		}
+		memberSpent[userID] += info.Amount()
+		member.Images++
+
+		totalSpent += info.Amount()
+		spending.TotalImages++
+	}
+
+	spending.TotalSpent = totalSpent.USD()
+	for userID, member := range byMember {
+		member.Spent = memberSpent[userID].USD()
+		spending.Members = append(spending.Members, *member)
+	}
+	sort.Slice(spending.Members, func(i, j int) bool { return spending.Members[i].Spent > spending.Members[j].Spent })
+
+	return spending, nil
+}
+
+func (s *Service) findMembership(orgID, userID string) (*core.Record, error) {
+	return s.app.FindFirstRecordByFilter(MembersCollection, "org_id = {:org_id} && user_id = {:user_id}", map[string]any{
+		"org_id":  orgID,
+		"user_id": userID,
+	})
+}
+
+func saveMembership(app core.App, orgID, userID, role string) error {
+	collection, err := app.FindCollectionByNameOrId(MembersCollection)
+	if err != nil {
+		return err
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("org_id", orgID)
+	record.Set("user_id", userID)
+	record.Set("role", role)
+	return app.Save(record)
+}
+
+func orgFromRecord(record *core.Record) *Organization {
+	return &Organization{
+		ID:      record.Id,
+		Name:    record.GetString("name"),
+		OwnerID: record.GetString("owner_id"),
+		Created: record.GetDateTime("created").Time(),
+	}
+}
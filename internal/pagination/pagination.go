@@ -0,0 +1,123 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// ErrInvalidCursor is returned for cursors this package did not issue
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page selects a slice of a listing newest first. With a Cursor the slice
+// starts after the record the cursor names, so iteration is stable while
+// records are added; otherwise it is page Number of Limit records.
+type Page struct {
+	Number int
+	Limit  int
+	Cursor *Cursor
+}
+
+// Cursor is the keyset position of a record in a created, id ordering
+type Cursor struct {
+	Created string
+	ID      string
+}
+
+// Encode returns the cursor's opaque form
+func (c Cursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.Created + "|" + c.ID))
+}
+
+// DecodeCursor parses a cursor returned by Encode
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	created, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return nil, ErrInvalidCursor
+	}
+	if _, err := time.Parse(types.DefaultDateLayout, created); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &Cursor{Created: created, ID: id}, nil
+}
+
+// CursorFor returns the cursor positioned at record
+func CursorFor(record *core.Record) Cursor {
+	return Cursor{
+		Created: record.GetDateTime("created").String(),
+		ID:      record.Id,
+	}
+}
+
+// Parse reads the page, limit and cursor query parameters. limit defaults to
+// and is capped at maxLimit. A cursor cannot be combined with a page.
+func Parse(query url.Values, maxLimit int) (Page, error) {
+	page := Page{Number: 1, Limit: maxLimit}
+
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return page, errors.New("limit must be a positive integer")
+		}
+		if limit < maxLimit {
+			page.Limit = limit
+		}
+	}
+
+	if raw := query.Get("page"); raw != "" {
+		number, err := strconv.Atoi(raw)
+		if err != nil || number < 1 {
+			return page, errors.New("page must be a positive integer")
+		}
+		page.Number = number
+	}
+
+	if raw := query.Get("cursor"); raw != "" {
+		if query.Get("page") != "" {
+			return page, errors.New("cursor and page cannot be combined")
+		}
+		cursor, err := DecodeCursor(raw)
+		if err != nil {
+			return page, err
+		}
+		page.Cursor = cursor
+	}
+	return page, nil
+}
+
+// Find returns the records matching filter on the requested page, newest
+// first, and the cursor of the following page, which is empty on the last.
+func Find(app core.App, collection, filter string, params map[string]any, page Page) ([]*core.Record, string, error) {
+	offset := (page.Number - 1) * page.Limit
+	if page.Cursor != nil {
+		if params == nil {
+			params = map[string]any{}
+		}
+		offset = 0
+		filter = "(" + filter + ") && (created < {:cursor_created} || (created = {:cursor_created} && id < {:cursor_id}))"
+		params["cursor_created"] = page.Cursor.Created
+		params["cursor_id"] = page.Cursor.ID
+	}
+
+	// One extra record tells whether another page follows
+	records, err := app.FindRecordsByFilter(collection, filter, "-created,-id", page.Limit+1, offset, params)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(records) <= page.Limit {
+		return records, "", nil
+	}
+
+	records = records[:page.Limit]
+	return records, CursorFor(records[len(records)-1]).Encode(), nil
+}
@@ -0,0 +1,554 @@
+package pipelines
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"generatio-pb/internal/fal"
+	"generatio-pb/internal/money"
+	"generatio-pb/internal/pagination"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// RunsCollection is the collection persisting pipeline runs
+const RunsCollection = "pipeline_runs"
+
+// Step types
+const (
+	StepGenerate         = "generate"
+	StepUpscale          = "upscale"
+	StepRemoveBackground = "remove_background"
+	StepSaveToFolder     = "save_to_folder"
+)
+
+// Run and step states
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+	StatusCancelled = "cancelled"
+
+	// StatusPending and StatusSkipped only apply to steps
+	StatusPending = "pending"
+	StatusSkipped = "skipped"
+)
+
+const (
+	// MaxSteps caps the length of a pipeline
+	MaxSteps = 8
+
+	// DefaultMaxAttempts is how many times a step is tried before the run fails
+	DefaultMaxAttempts = 3
+
+	// MaxAttemptsLimit caps the attempts a step may ask for
+	MaxAttemptsLimit = 5
+
+	// DefaultBaseBackoff is the delay before the first retry; it doubles on each attempt
+	DefaultBaseBackoff = 5 * time.Second
+)
+
+// Step is one stage of a pipeline as defined by the user
+type Step struct {
+	Type        string                 `json:"type"`
+	Model       string                 `json:"model,omitempty"`
+	Prompt      string                 `json:"prompt,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	FolderID    string                 `json:"folder_id,omitempty"`
+	MaxAttempts int                    `json:"max_attempts,omitempty"`
+}
+
+// StepState is a step together with its progress within a run
+type StepState struct {
+	Step
+	Status     string       `json:"status"`
+	Attempts   int          `json:"attempts"`
+	Error      string       `json:"error,omitempty"`
+	Cost       float64      `json:"cost"`        // CostMicros in USD, for older readers
+	CostMicros money.Micros `json:"cost_micros"` // what the step's attempts were charged
+	ImageIDs   []string     `json:"image_ids"`
+	StartedAt  *time.Time   `json:"started_at,omitempty"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+}
+
+// Run is a pipeline execution
+type Run struct {
+	ID              string       `json:"id"`
+	UserID          string       `json:"user_id"`
+	OrgID           string       `json:"org_id,omitempty"`
+	Status          string       `json:"status"`
+	Steps           []StepState  `json:"steps"`
+	TotalCost       float64      `json:"total_cost"` // TotalCostMicros in USD, for older readers
+	TotalCostMicros money.Micros `json:"total_cost_micros"`
+	Error           string       `json:"error,omitempty"`
+	Priority        string       `json:"priority"`
+	Template        *TemplateRef `json:"template,omitempty"` // set when started from a template
+	Created         time.Time    `json:"created"`
+	Updated         time.Time    `json:"updated"`
+}
+
+// RunOptions are the optional settings of a new run
+type RunOptions struct {
+	Priority string       // fal.PriorityInteractive or fal.PriorityBatch (the default)
+	Template *TemplateRef // set when the run starts from a template
+}
+
+// Executor performs a single step of a run. inputs are the images produced
+// by the previous step; the returned images feed the next one.
+type Executor interface {
+	ExecuteStep(ctx context.Context, run *Run, step Step, inputs []string) (imageIDs []string, cost money.Micros, err error)
+}
+
+// permanentError marks a step failure that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the step fails without further attempts
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// Service stores pipeline runs and executes them one at a time in the background
+type Service struct {
+	app         core.App
+	executor    Executor
+	onFinish    func(run *Run)
+	baseBackoff time.Duration
+	interval    time.Duration
+
+	processMutex sync.Mutex
+	kick         chan struct{}
+	ctx          context.Context
+	cancel       context.CancelFunc
+	stopOnce     sync.Once
+}
+
+// NewService creates a pipeline runner. An executor must be set before runs are processed.
+func NewService(app core.App) *Service {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Service{
+		app:         app,
+		baseBackoff: DefaultBaseBackoff,
+		interval:    time.Minute,
+		kick:        make(chan struct{}, 1),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// SetExecutor sets the executor performing the steps
+func (s *Service) SetExecutor(executor Executor) {
+	s.executor = executor
+}
+
+// SetFinishHook sets a function called with each run that succeeds, fails
+// or is cancelled while executing
+func (s *Service) SetFinishHook(hook func(run *Run)) {
+	s.onFinish = hook
+}
+
+// SetBaseBackoff configures the delay before a step is retried
+func (s *Service) SetBaseBackoff(backoff time.Duration) {
+	if backoff >= 0 {
+		s.baseBackoff = backoff
+	}
+}
+
+// Start requeues runs interrupted by a restart and begins the background loop
+func (s *Service) Start() {
+	interrupted, err := s.app.FindAllRecords(RunsCollection, dbx.HashExp{"status": StatusRunning})
+	if err == nil {
+		for _, record := range interrupted {
+			record.Set("status", StatusQueued)
+			s.app.Save(record)
+		}
+	}
+
+	go s.run()
+	log.Printf("Pipeline service started with interval: %v", s.interval)
+}
+
+// Stop stops the background loop and waits for the step in progress to finish.
+// Interrupted runs are requeued on the next Start.
+func (s *Service) Stop() {
+	s.stopOnce.Do(func() {
+		s.cancel()
+		s.processMutex.Lock()
+		s.processMutex.Unlock()
+	})
+}
+
+// Validate checks the shape of a pipeline. Models, prompts and folders are
+// checked by the caller, which knows the user and the model catalogue.
+func Validate(steps []Step) error {
+	if len(steps) == 0 || len(steps) > MaxSteps {
+		return fmt.Errorf("a pipeline needs between 1 and %d steps", MaxSteps)
+	}
+
+	for i, step := range steps {
+		position := i + 1
+		switch step.Type {
+		case StepGenerate:
+			if i != 0 {
+				return fmt.Errorf("step %d: generate can only be the first step", position)
+			}
+			if strings.TrimSpace(step.Prompt) == "" || step.Model == "" {
+				return fmt.Errorf("step %d: generate needs a model and a prompt", position)
+			}
+		case StepUpscale, StepRemoveBackground:
+			if i == 0 {
+				return fmt.Errorf("step %d: %s needs images from a previous step", position, step.Type)
+			}
+		case StepSaveToFolder:
+			if i == 0 {
+				return fmt.Errorf("step %d: %s needs images from a previous step", position, step.Type)
+			}
+			if step.FolderID == "" {
+				return fmt.Errorf("step %d: save_to_folder needs a folder_id", position)
+			}
+		default:
+			return fmt.Errorf("step %d: unknown step type %q", position, step.Type)
+		}
+
+		if step.MaxAttempts < 0 || step.MaxAttempts > MaxAttemptsLimit {
+			return fmt.Errorf("step %d: max_attempts must be between 1 and %d", position, MaxAttemptsLimit)
+		}
+	}
+	return nil
+}
+
+// Create queues a new run for userID and wakes the runner
+func (s *Service) Create(userID, orgID string, steps []Step, opts RunOptions) (*Run, error) {
+	if err := Validate(steps); err != nil {
+		return nil, err
+	}
+	if opts.Priority == "" {
+		opts.Priority = fal.PriorityBatch
+	}
+	if !fal.ValidPriority(opts.Priority) {
+		return nil, fmt.Errorf("invalid priority %q", opts.Priority)
+	}
+
+	collection, err := s.app.FindCollectionByNameOrId(RunsCollection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find pipeline runs collection: %w", err)
+	}
+
+	states := make([]StepState, len(steps))
+	for i, step := range steps {
+		if step.MaxAttempts == 0 {
+			step.MaxAttempts = DefaultMaxAttempts
+		}
+		states[i] = StepState{Step: step, Status: StatusPending, ImageIDs: []string{}}
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("user_id", userID)
+	if orgID != "" {
+		record.Set("org_id", orgID)
+	}
+	record.Set("status", StatusQueued)
+	record.Set("steps", states)
+	record.Set("total_cost", 0)
+	record.Set("priority", opts.Priority)
+	if opts.Template != nil {
+		record.Set("template", opts.Template)
+	}
+
+	if err := s.app.Save(record); err != nil {
+		return nil, fmt.Errorf("failed to save pipeline run: %w", err)
+	}
+
+	select {
+	case s.kick <- struct{}{}:
+	default:
+	}
+
+	return fromRecord(record), nil
+}
+
+// Find loads a run owned by userID
+func (s *Service) Find(id, userID string) (*Run, error) {
+	record, err := s.app.FindRecordById(RunsCollection, id)
+	if err != nil || record.GetString("user_id") != userID {
+		return nil, fmt.Errorf("pipeline run not found")
+	}
+	return fromRecord(record), nil
+}
+
+// ForUser lists a user's most recent runs
+func (s *Service) ForUser(userID string, page pagination.Page) ([]*Run, string, error) {
+	records, next, err := pagination.Find(s.app, RunsCollection, "user_id = {:user_id}", map[string]any{"user_id": userID}, page)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch pipeline runs: %w", err)
+	}
+
+	runs := make([]*Run, 0, len(records))
+	for _, record := range records {
+		runs = append(runs, fromRecord(record))
+	}
+	return runs, next, nil
+}
+
+// Cancel stops a queued or running run. A running step finishes, but no
+// further steps start.
+func (s *Service) Cancel(id, userID string) (*Run, error) {
+	record, err := s.app.FindRecordById(RunsCollection, id)
+	if err != nil || record.GetString("user_id") != userID {
+		return nil, fmt.Errorf("pipeline run not found")
+	}
+
+	switch record.GetString("status") {
+	case StatusQueued, StatusRunning:
+	default:
+		return nil, fmt.Errorf("only queued or running pipelines can be cancelled")
+	}
+
+	record.Set("status", StatusCancelled)
+	if err := s.app.Save(record); err != nil {
+		return nil, fmt.Errorf("failed to cancel pipeline run: %w", err)
+	}
+	return fromRecord(record), nil
+}
+
+// Process executes every queued run. Interactive runs go first, so runs
+// queued while a batch is in progress jump ahead of the batch's remaining runs.
+func (s *Service) Process(ctx context.Context) {
+	s.processMutex.Lock()
+	defer s.processMutex.Unlock()
+
+	if s.executor == nil || s.ctx.Err() != nil {
+		return
+	}
+
+	// A run whose progress could not be saved stays queued; never pick it twice
+	seen := map[string]bool{}
+	for ctx.Err() == nil {
+		record := s.next()
+		if record == nil || seen[record.Id] {
+			return
+		}
+		seen[record.Id] = true
+		s.execute(ctx, record)
+	}
+}
+
+// next returns the oldest queued interactive run, or else the oldest queued
+// batch run. Runs saved before priorities existed count as batch runs.
+func (s *Service) next() *core.Record {
+	filters := []string{
+		"status = {:status} && priority = {:interactive}",
+		"status = {:status} && priority != {:interactive}",
+	}
+	for _, filter := range filters {
+		records, err := s.app.FindRecordsByFilter(RunsCollection, filter, "created", 1, 0, map[string]any{
+			"status":      StatusQueued,
+			"interactive": fal.PriorityInteractive,
+		})
+		if err != nil {
+			return nil
+		}
+		if len(records) > 0 {
+			return records[0]
+		}
+	}
+	return nil
+}
+
+// run is the main processing loop
+func (s *Service) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Process(s.ctx)
+		case <-s.kick:
+			s.Process(s.ctx)
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// execute runs the remaining steps of a run, persisting progress after each change
+func (s *Service) execute(ctx context.Context, record *core.Record) {
+	run := fromRecord(record)
+	run.Status = StatusRunning
+	s.save(record, run)
+
+	// Resume after the last finished step when a run was interrupted
+	var inputs []string
+	for i := range run.Steps {
+		state := &run.Steps[i]
+		if state.Status == StatusSucceeded {
+			inputs = state.ImageIDs
+			continue
+		}
+
+		if s.cancelled(record.Id) {
+			s.finish(record, run, StatusCancelled, "")
+			return
+		}
+
+		outputs, err := s.executeStep(ctx, record, run, state, inputs)
+		if err != nil && ctx.Err() != nil {
+			// Shutting down: the run stays running so Start requeues it and the step runs again
+			state.Status = StatusPending
+			s.save(record, run)
+			return
+		}
+		if err != nil {
+			s.finish(record, run, StatusFailed, fmt.Sprintf("step %d (%s) failed: %v", i+1, state.Type, err))
+			return
+		}
+		inputs = outputs
+	}
+
+	s.finish(record, run, StatusSucceeded, "")
+}
+
+// executeStep runs one step with retries and records its outcome on the run
+func (s *Service) executeStep(ctx context.Context, record *core.Record, run *Run, state *StepState, inputs []string) ([]string, error) {
+	started := time.Now().UTC()
+	state.Status = StatusRunning
+	state.StartedAt = &started
+	state.Error = ""
+	state.Attempts = 0
+	s.save(record, run)
+
+	maxAttempts := state.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+
+	var lastErr error
+	for state.Attempts < maxAttempts {
+		if state.Attempts > 0 {
+			select {
+			case <-time.After(s.baseBackoff << (state.Attempts - 1)):
+			case <-ctx.Done():
+				lastErr = ctx.Err()
+			}
+			if ctx.Err() != nil {
+				break
+			}
+		}
+		state.Attempts++
+
+		outputs, cost, err := s.executor.ExecuteStep(ctx, run, state.Step, inputs)
+		state.CostMicros += cost
+		state.Cost = state.CostMicros.USD()
+		run.TotalCostMicros += cost
+		run.TotalCost = run.TotalCostMicros.USD()
+		if err == nil {
+			finished := time.Now().UTC()
+			state.Status = StatusSucceeded
+			state.ImageIDs = outputs
+			if state.ImageIDs == nil {
+				state.ImageIDs = []string{}
+			}
+			state.FinishedAt = &finished
+			s.save(record, run)
+			return outputs, nil
+		}
+
+		lastErr = err
+		state.Error = err.Error()
+		s.save(record, run)
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			break
+		}
+	}
+
+	finished := time.Now().UTC()
+	state.Status = StatusFailed
+	state.FinishedAt = &finished
+	s.save(record, run)
+	return nil, lastErr
+}
+
+// cancelled reports whether a run was cancelled while it was executing
+func (s *Service) cancelled(id string) bool {
+	latest, err := s.app.FindRecordById(RunsCollection, id)
+	return err == nil && latest.GetString("status") == StatusCancelled
+}
+
+// finish records the final state of a run and skips the steps that never ran
+func (s *Service) finish(record *core.Record, run *Run, status, message string) {
+	for i := range run.Steps {
+		if run.Steps[i].Status == StatusPending {
+			run.Steps[i].Status = StatusSkipped
+		}
+	}
+	run.Status = status
+	run.Error = message
+	s.save(record, run)
+
+	log.Printf("Pipeline run %s %s (cost %.4f)", run.ID, status, run.TotalCost)
+
+	if s.onFinish != nil {
+		s.onFinish(run)
+	}
+}
+
+// save writes the progress of a run back to its record
+func (s *Service) save(record *core.Record, run *Run) {
+	// Keep a cancellation requested during the step
+	if run.Status == StatusRunning && s.cancelled(record.Id) {
+		run.Status = StatusCancelled
+	}
+
+	record.Set("status", run.Status)
+	record.Set("steps", run.Steps)
+	record.Set("total_cost", run.TotalCostMicros.USD())
+	record.Set("error", run.Error)
+	if run.Status != StatusQueued && run.Status != StatusRunning {
+		record.Set("finished_at", types.NowDateTime())
+	}
+	if err := s.app.Save(record); err != nil {
+		log.Printf("Failed to save pipeline run %s: %v", record.Id, err)
+	}
+}
+
+func fromRecord(record *core.Record) *Run {
+	run := &Run{
+		ID:       record.Id,
+		UserID:   record.GetString("user_id"),
+		OrgID:    record.GetString("org_id"),
+		Status:   record.GetString("status"),
+		Steps:    []StepState{},
+		Error:    record.GetString("error"),
+		Priority: record.GetString("priority"),
+		Created:  record.GetDateTime("created").Time(),
+		Updated:  record.GetDateTime("updated").Time(),
+	}
+	record.UnmarshalJSONField("steps", &run.Steps)
+	record.UnmarshalJSONField("template", &run.Template)
+
+	// total_cost is stored in USD; steps saved before micro-dollar accounting
+	// only have their USD cost
+	run.TotalCostMicros = money.FromUSD(record.GetFloat("total_cost"))
+	run.TotalCost = run.TotalCostMicros.USD()
+	for i := range run.Steps {
+		if run.Steps[i].CostMicros == 0 {
+			run.Steps[i].CostMicros = money.FromUSD(run.Steps[i].Cost)
+		}
+	}
+	return run
+}
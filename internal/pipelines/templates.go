@@ -0,0 +1,430 @@
+package pipelines
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"generatio-pb/internal/orgs"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Collections backing workflow templates
+const (
+	TemplatesCollection = "pipeline_templates"
+	VersionsCollection  = "pipeline_template_versions"
+)
+
+const (
+	// MaxTemplateNameLen caps template names
+	MaxTemplateNameLen = 120
+
+	// MaxVariables caps the variables a template may declare
+	MaxVariables = 20
+)
+
+// variablePattern matches {{name}} placeholders in step fields
+var variablePattern = regexp.MustCompile(`\{\{\s*([a-z][a-z0-9_]*)\s*\}\}`)
+
+// variableName matches a declarable variable name
+var variableName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// ErrTemplateNotFound is returned for templates the user cannot see
+var ErrTemplateNotFound = fmt.Errorf("template not found")
+
+// Template is a saved pipeline. Steps may use {{name}} placeholders that are
+// filled from Variables, whose values are the defaults; an empty default
+// must be supplied on every run.
+type Template struct {
+	ID          string            `json:"id"`
+	OwnerID     string            `json:"owner_id"`
+	OrgID       string            `json:"org_id,omitempty"` // shared with the organization's members
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Version     int               `json:"version"`
+	Steps       []Step            `json:"steps"`
+	Variables   map[string]string `json:"variables"`
+	CanEdit     bool              `json:"can_edit"`
+	Created     time.Time         `json:"created"`
+	Updated     time.Time         `json:"updated"`
+}
+
+// TemplateVersion is a saved revision of a template
+type TemplateVersion struct {
+	Version   int               `json:"version"`
+	AuthorID  string            `json:"author_id"`
+	Steps     []Step            `json:"steps"`
+	Variables map[string]string `json:"variables"`
+	Created   time.Time         `json:"created"`
+}
+
+// TemplateInput describes a template being saved
+type TemplateInput struct {
+	Name        string
+	Description string
+	OrgID       string
+	Steps       []Step
+	Variables   map[string]string
+}
+
+// TemplateRef records which template version a run was started from
+type TemplateRef struct {
+	ID      string `json:"id"`
+	Version int    `json:"version"`
+}
+
+// TemplateStore saves versioned pipeline templates, optionally shared within an organization
+type TemplateStore struct {
+	app  core.App
+	orgs *orgs.Service
+}
+
+// NewTemplateStore creates a template store
+func NewTemplateStore(app core.App, orgService *orgs.Service) *TemplateStore {
+	return &TemplateStore{app: app, orgs: orgService}
+}
+
+// Create saves the first version of a template
+func (s *TemplateStore) Create(userID string, input TemplateInput) (*Template, error) {
+	if err := s.validate(userID, &input); err != nil {
+		return nil, err
+	}
+
+	collection, err := s.app.FindCollectionByNameOrId(TemplatesCollection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find templates collection: %w", err)
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("user_id", userID)
+	err = s.app.RunInTransaction(func(txApp core.App) error {
+		return saveVersion(txApp, record, userID, input, 1)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save template: %w", err)
+	}
+	return s.fromRecord(record, userID), nil
+}
+
+// Update saves a new version of a template. Owners and, for shared
+// templates, organization owners and admins may edit.
+func (s *TemplateStore) Update(id, userID string, input TemplateInput) (*Template, error) {
+	record, err := s.visible(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.canEdit(record, userID) {
+		return nil, fmt.Errorf("only the template owner or organization admins can edit it")
+	}
+	// Only the owner decides where the template is shared
+	if record.GetString("user_id") != userID {
+		input.OrgID = record.GetString("org_id")
+	}
+	if err := s.validate(record.GetString("user_id"), &input); err != nil {
+		return nil, err
+	}
+
+	err = s.app.RunInTransaction(func(txApp core.App) error {
+		return saveVersion(txApp, record, userID, input, record.GetInt("version")+1)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save template: %w", err)
+	}
+	return s.fromRecord(record, userID), nil
+}
+
+// Find loads a template the user can see
+func (s *TemplateStore) Find(id, userID string) (*Template, error) {
+	record, err := s.visible(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.fromRecord(record, userID), nil
+}
+
+// ForUser lists the user's own templates and those shared with their organizations
+func (s *TemplateStore) ForUser(userID string) ([]*Template, error) {
+	filter := "user_id = {:user_id}"
+	params := map[string]any{"user_id": userID}
+
+	memberships, err := s.orgs.ForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i, org := range memberships {
+		key := fmt.Sprintf("org%d", i)
+		filter += " || org_id = {:" + key + "}"
+		params[key] = org.ID
+	}
+
+	records, err := s.app.FindRecordsByFilter(TemplatesCollection, filter, "name", 0, 0, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch templates: %w", err)
+	}
+
+	templates := make([]*Template, 0, len(records))
+	for _, record := range records {
+		templates = append(templates, s.fromRecord(record, userID))
+	}
+	return templates, nil
+}
+
+// Versions lists a template's revisions, newest first
+func (s *TemplateStore) Versions(id, userID string) ([]TemplateVersion, error) {
+	if _, err := s.visible(id, userID); err != nil {
+		return nil, err
+	}
+
+	records, err := s.app.FindRecordsByFilter(VersionsCollection, "template_id = {:template_id}", "-version", 0, 0, map[string]any{"template_id": id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch template versions: %w", err)
+	}
+
+	versions := make([]TemplateVersion, 0, len(records))
+	for _, record := range records {
+		versions = append(versions, versionFromRecord(record))
+	}
+	return versions, nil
+}
+
+// Version loads one revision of a template; version 0 is the latest
+func (s *TemplateStore) Version(id, userID string, version int) (*TemplateVersion, error) {
+	record, err := s.visible(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		version = record.GetInt("version")
+	}
+
+	versionRecord, err := s.app.FindFirstRecordByFilter(VersionsCollection, "template_id = {:template_id} && version = {:version}", dbx.Params{
+		"template_id": id,
+		"version":     version,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("template version %d not found", version)
+	}
+	v := versionFromRecord(versionRecord)
+	return &v, nil
+}
+
+// Delete removes a template with its versions
+func (s *TemplateStore) Delete(id, userID string) error {
+	record, err := s.visible(id, userID)
+	if err != nil {
+		return err
+	}
+	if !s.canEdit(record, userID) {
+		return fmt.Errorf("only the template owner or organization admins can delete it")
+	}
+
+	return s.app.RunInTransaction(func(txApp core.App) error {
+		versions, err := txApp.FindAllRecords(VersionsCollection, dbx.HashExp{"template_id": id})
+		if err != nil {
+			return err
+		}
+		for _, version := range versions {
+			if err := txApp.Delete(version); err != nil {
+				return err
+			}
+		}
+		return txApp.Delete(record)
+	})
+}
+
+// Resolve fills a template version's placeholders from its defaults and the
+// run's overrides and returns the steps to run
+func (v *TemplateVersion) Resolve(overrides map[string]string) ([]Step, error) {
+	values := make(map[string]string, len(v.Variables))
+	for name, value := range v.Variables {
+		values[name] = value
+	}
+	for name, value := range overrides {
+		if _, declared := v.Variables[name]; !declared {
+			return nil, fmt.Errorf("unknown variable %q", name)
+		}
+		values[name] = value
+	}
+
+	var missing []string
+	for name, value := range values {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("missing values for variables: %s", strings.Join(missing, ", "))
+	}
+
+	fill := func(text string) string {
+		return variablePattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+			return values[variablePattern.FindStringSubmatch(placeholder)[1]]
+		})
+	}
+
+	steps := make([]Step, len(v.Steps))
+	for i, step := range v.Steps {
+		step.Model = fill(step.Model)
+		step.Prompt = fill(step.Prompt)
+		step.FolderID = fill(step.FolderID)
+		if step.Parameters != nil {
+			parameters := make(map[string]interface{}, len(step.Parameters))
+			for key, value := range step.Parameters {
+				if text, ok := value.(string); ok {
+					value = fill(text)
+				}
+				parameters[key] = value
+			}
+			step.Parameters = parameters
+		}
+		steps[i] = step
+	}
+	return steps, nil
+}
+
+// validate checks a template and the owner's right to share it
+func (s *TemplateStore) validate(ownerID string, input *TemplateInput) error {
+	input.Name = strings.TrimSpace(input.Name)
+	if input.Name == "" || len(input.Name) > MaxTemplateNameLen {
+		return fmt.Errorf("name is required and must be at most %d characters", MaxTemplateNameLen)
+	}
+	if err := Validate(input.Steps); err != nil {
+		return err
+	}
+
+	if len(input.Variables) > MaxVariables {
+		return fmt.Errorf("at most %d variables can be declared", MaxVariables)
+	}
+	for name := range input.Variables {
+		if !variableName.MatchString(name) {
+			return fmt.Errorf("invalid variable name %q", name)
+		}
+	}
+	for i, step := range input.Steps {
+		for _, name := range placeholders(step) {
+			if _, declared := input.Variables[name]; !declared {
+				return fmt.Errorf("step %d uses undeclared variable %q", i+1, name)
+			}
+		}
+	}
+	if input.Variables == nil {
+		input.Variables = map[string]string{}
+	}
+
+	if input.OrgID != "" {
+		role, err := s.orgs.Role(input.OrgID, ownerID)
+		if err != nil {
+			return err
+		}
+		if !orgs.CanWrite(role) {
+			return fmt.Errorf("viewers cannot share templates with the organization")
+		}
+	}
+	return nil
+}
+
+// placeholders returns the variable names used by a step
+func placeholders(step Step) []string {
+	fields := []string{step.Model, step.Prompt, step.FolderID}
+	for _, value := range step.Parameters {
+		if text, ok := value.(string); ok {
+			fields = append(fields, text)
+		}
+	}
+
+	var names []string
+	for _, field := range fields {
+		for _, match := range variablePattern.FindAllStringSubmatch(field, -1) {
+			names = append(names, match[1])
+		}
+	}
+	return names
+}
+
+// saveVersion writes the template's current fields and a snapshot of the new version
+func saveVersion(txApp core.App, record *core.Record, authorID string, input TemplateInput, version int) error {
+	record.Set("name", input.Name)
+	record.Set("description", strings.TrimSpace(input.Description))
+	record.Set("org_id", input.OrgID)
+	record.Set("version", version)
+	record.Set("steps", input.Steps)
+	record.Set("variables", input.Variables)
+	if err := txApp.Save(record); err != nil {
+		return err
+	}
+
+	collection, err := txApp.FindCollectionByNameOrId(VersionsCollection)
+	if err != nil {
+		return err
+	}
+	snapshot := core.NewRecord(collection)
+	snapshot.Set("template_id", record.Id)
+	snapshot.Set("version", version)
+	snapshot.Set("user_id", authorID)
+	snapshot.Set("steps", input.Steps)
+	snapshot.Set("variables", input.Variables)
+	return txApp.Save(snapshot)
+}
+
+// visible loads a template owned by the user or shared with one of their organizations
+func (s *TemplateStore) visible(id, userID string) (*core.Record, error) {
+	record, err := s.app.FindRecordById(TemplatesCollection, id)
+	if err != nil {
+		return nil, ErrTemplateNotFound
+	}
+	if record.GetString("user_id") == userID {
+		return record, nil
+	}
+	if orgID := record.GetString("org_id"); orgID != "" {
+		if _, err := s.orgs.Role(orgID, userID); err == nil {
+			return record, nil
+		}
+	}
+	return nil, ErrTemplateNotFound
+}
+
+// canEdit reports whether the user may change or delete a visible template
+func (s *TemplateStore) canEdit(record *core.Record, userID string) bool {
+	if record.GetString("user_id") == userID {
+		return true
+	}
+	role, err := s.orgs.Role(record.GetString("org_id"), userID)
+	return err == nil && orgs.CanManage(role)
+}
+
+func (s *TemplateStore) fromRecord(record *core.Record, viewerID string) *Template {
+	template := &Template{
+		ID:          record.Id,
+		OwnerID:     record.GetString("user_id"),
+		OrgID:       record.GetString("org_id"),
+		Name:        record.GetString("name"),
+		Description: record.GetString("description"),
+		Version:     record.GetInt("version"),
+		Steps:       []Step{},
+		Variables:   map[string]string{},
+		CanEdit:     s.canEdit(record, viewerID),
+		Created:     record.GetDateTime("created").Time(),
+		Updated:     record.GetDateTime("updated").Time(),
+	}
+	record.UnmarshalJSONField("steps", &template.Steps)
+	record.UnmarshalJSONField("variables", &template.Variables)
+	return template
+}
+
+func versionFromRecord(record *core.Record) TemplateVersion {
+	version := TemplateVersion{
+		Version:   record.GetInt("version"),
+		AuthorID:  record.GetString("user_id"),
+		Steps:     []Step{},
+		Variables: map[string]string{},
+		Created:   record.GetDateTime("created").Time(),
+	}
+	record.UnmarshalJSONField("steps", &version.Steps)
+	record.UnmarshalJSONField("variables", &version.Variables)
+	return version
+}
@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Status is a caller's standing in the current window
+type Status struct {
+	Limit     int
+	Remaining int
+	Reset     time.Time // when the current window ends
+}
+
+// UserKey identifies an authenticated caller
+func UserKey(userID string) string {
+	return "user:" + userID
+}
+
+// IPKey identifies an anonymous caller
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+type window struct {
+	start time.Time
+	count int
+}
+
+// Limiter allows limit requests per key in fixed windows. Windows start with
+// a key's first request, so callers are not synchronised at window edges.
+type Limiter struct {
+	limit  int
+	length time.Duration
+
+	mutex     sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+// NewLimiter creates a limiter; a non-positive limit or window disables it
+func NewLimiter(limit int, length time.Duration) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		length:  length,
+		windows: make(map[string]*window),
+	}
+}
+
+// Enabled reports whether requests are limited at all
+func (l *Limiter) Enabled() bool {
+	return l.limit > 0 && l.length > 0
+}
+
+// Window returns the window length
+func (l *Limiter) Window() time.Duration {
+	return l.length
+}
+
+// Allow counts a request for key and reports whether it is within the limit
+func (l *Limiter) Allow(key string) (Status, bool) {
+	if !l.Enabled() {
+		return Status{}, true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	w := l.current(key, now)
+	if w == nil {
+		w = &window{start: now}
+		l.windows[key] = w
+	}
+
+	allowed := w.count < l.limit
+	if allowed {
+		w.count++
+	}
+	return l.status(w), allowed
+}
+
+// Peek returns key's standing without counting a request
+func (l *Limiter) Peek(key string) Status {
+	if !l.Enabled() {
+		return Status{}
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	w := l.current(key, now)
+	if w == nil {
+		return Status{Limit: l.limit, Remaining: l.limit, Reset: now.Add(l.length)}
+	}
+	return l.status(w)
+}
+
+// current returns key's window if it has not ended
+func (l *Limiter) current(key string, now time.Time) *window {
+	w, exists := l.windows[key]
+	if !exists || !now.Before(w.start.Add(l.length)) {
+		return nil
+	}
+	return w
+}
+
+func (l *Limiter) status(w *window) Status {
+	return Status{
+		Limit:     l.limit,
+		Remaining: l.limit - w.count,
+		Reset:     w.start.Add(l.length),
+	}
+}
+
+// sweep drops ended windows, at most once per window length
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.length {
+		return
+	}
+	for key, w := range l.windows {
+		if !now.Before(w.start.Add(l.length)) {
+			delete(l.windows, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,257 @@
+package reconcile
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"generatio-pb/internal/money"
+	"generatio-pb/internal/recordjson"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Tolerance is the difference below which totals are considered equal
+const Tolerance money.Micros = 100
+
+// BillingSource reports what FAL billed a user, where billing data is available
+type BillingSource interface {
+	UserSpend(ctx context.Context, userID string) (money.Micros, error)
+}
+
+// UserDrift describes a user whose recorded spending disagrees with their images
+// or with FAL billing. Amounts are in micro-dollars; the USD fields repeat
+// them for older readers.
+type UserDrift struct {
+	UserID string `json:"user_id"`
+
+	// RecordedSpent and RecordedImages are the generatio_users.financial_data totals
+	RecordedSpent       float64      `json:"recorded_spent"`
+	RecordedSpentMicros money.Micros `json:"recorded_spent_micros"`
+	RecordedImages      int          `json:"recorded_images"`
+
+	// ImageSpent and ImageCount sum the cost recorded in other_info over the user's images
+	ImageSpent       float64      `json:"image_spent"`
+	ImageSpentMicros money.Micros `json:"image_spent_micros"`
+	ImageCount       int          `json:"image_count"`
+
+	// BilledSpent is set when a billing source is configured
+	BilledSpent       *float64      `json:"billed_spent,omitempty"`
+	BilledSpentMicros *money.Micros `json:"billed_spent_micros,omitempty"`
+
+	Drift             float64      `json:"drift"` // RecordedSpent - ImageSpent
+	DriftMicros       money.Micros `json:"drift_micros"`
+	BilledDrift       float64      `json:"billed_drift,omitempty"` // RecordedSpent - BilledSpent
+	BilledDriftMicros money.Micros `json:"billed_drift_micros,omitempty"`
+	Fixed             bool         `json:"fixed"`
+}
+
+// Report summarises a reconciliation run
+type Report struct {
+	StartedAt        time.Time    `json:"started_at"`
+	UsersScanned     int          `json:"users_scanned"`
+	Drifted          int          `json:"drifted"`
+	Fixed            int          `json:"fixed"`
+	TotalDrift       float64      `json:"total_drift"`
+	TotalDriftMicros money.Micros `json:"total_drift_micros"`
+	BillingChecked   bool         `json:"billing_checked"`
+	Users            []UserDrift  `json:"users"`
+}
+
+// Service compares each user's recorded spending with the cost recorded on
+// their images and, when configured, with FAL billing. Fixing resets the
+// recorded totals to the image sums.
+type Service struct {
+	app      core.App
+	interval time.Duration
+	autoFix  bool
+	billing  BillingSource
+
+	runMutex sync.Mutex
+	mutex    sync.RWMutex
+	last     *Report
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// NewService creates a reconciliation service running every interval; a
+// non-positive interval disables scheduled runs. Scheduled runs fix drift
+// only when autoFix is set.
+func NewService(app core.App, interval time.Duration, autoFix bool) *Service {
+	return &Service{
+		app:      app,
+		interval: interval,
+		autoFix:  autoFix,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// SetBillingSource enables comparing recorded spending with FAL billing
+func (s *Service) SetBillingSource(billing BillingSource) {
+	s.billing = billing
+}
+
+// Start begins the scheduled reconciliation loop
+func (s *Service) Start() {
+	if s.interval <= 0 {
+		return
+	}
+	go s.run()
+	log.Printf("Cost reconciliation started with interval: %v (auto-fix: %v)", s.interval, s.autoFix)
+}
+
+// Stop stops the scheduled reconciliation loop
+func (s *Service) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+	})
+}
+
+func (s *Service) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			report, err := s.Run(context.Background(), s.autoFix)
+			if err != nil {
+				log.Printf("Cost reconciliation failed: %v", err)
+			} else if report.Drifted > 0 {
+				log.Printf("Cost reconciliation found %d users with drift totalling $%.4f (%d fixed)", report.Drifted, report.TotalDrift, report.Fixed)
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// LastReport returns the report of the most recent run, if any
+func (s *Service) LastReport() *Report {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.last
+}
+
+// Run reconciles every user, resetting drifted totals when fix is set
+func (s *Service) Run(ctx context.Context, fix bool) (*Report, error) {
+	s.runMutex.Lock()
+	defer s.runMutex.Unlock()
+
+	report := &Report{StartedAt: time.Now().UTC(), BillingChecked: s.billing != nil, Users: []UserDrift{}}
+
+	users, err := s.app.FindAllRecords("generatio_users")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch users: %w", err)
+	}
+
+	// Deleted images were still paid for, so every image counts
+	images, err := s.app.FindAllRecords("images")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch images: %w", err)
+	}
+	spent := make(map[string]money.Micros)
+	counts := make(map[string]int)
+	for _, record := range images {
+		var info money.ImageCost
+		record.UnmarshalJSONField("other_info", &info)
+		spent[record.GetString("user_id")] += info.Amount()
+		counts[record.GetString("user_id")]++
+	}
+
+	for _, user := range users {
+		report.UsersScanned++
+
+		var recorded recordedTotals
+		if err := recordjson.Decode(user, "financial_data", &recorded); err != nil {
+			s.app.Logger().Warn("Failed to read recorded spending", "error", err, "user_id", user.Id)
+		}
+		// Totals recorded before micro-dollar accounting only have USD
+		if recorded.TotalSpentMicros == 0 {
+			recorded.TotalSpentMicros = money.FromUSD(recorded.TotalSpent)
+		}
+
+		drift := UserDrift{
+			UserID:              user.Id,
+			RecordedSpent:       recorded.TotalSpentMicros.USD(),
+			RecordedSpentMicros: recorded.TotalSpentMicros,
+			RecordedImages:      recorded.TotalImages,
+			ImageSpent:          spent[user.Id].USD(),
+			ImageSpentMicros:    spent[user.Id],
+			ImageCount:          counts[user.Id],
+			DriftMicros:         recorded.TotalSpentMicros - spent[user.Id],
+		}
+		drift.Drift = drift.DriftMicros.USD()
+		drifted := drift.DriftMicros.Abs() > Tolerance || drift.RecordedImages != drift.ImageCount
+
+		if s.billing != nil {
+			billed, err := s.billing.UserSpend(ctx, user.Id)
+			if err != nil {
+				s.app.Logger().Warn("Failed to fetch billing data", "error", err, "user_id", user.Id)
+			} else {
+				billedUSD := billed.USD()
+				drift.BilledSpent = &billedUSD
+				drift.BilledSpentMicros = &billed
+				drift.BilledDriftMicros = recorded.TotalSpentMicros - billed
+				drift.BilledDrift = drift.BilledDriftMicros.USD()
+				drifted = drifted || drift.BilledDriftMicros.Abs() > Tolerance
+			}
+		}
+
+		if !drifted {
+			continue
+		}
+
+		if fix {
+			if err := s.fix(user, drift); err != nil {
+				s.app.Logger().Error("Failed to fix recorded spending", "error", err, "user_id", user.Id)
+			} else {
+				drift.Fixed = true
+				report.Fixed++
+			}
+		}
+
+		report.Drifted++
+		report.TotalDriftMicros += drift.DriftMicros
+		report.Users = append(report.Users, drift)
+	}
+	report.TotalDrift = report.TotalDriftMicros.USD()
+
+	sort.Slice(report.Users, func(i, j int) bool {
+		return report.Users[i].DriftMicros.Abs() > report.Users[j].DriftMicros.Abs()
+	})
+
+	s.mutex.Lock()
+	s.last = report
+	s.mutex.Unlock()
+
+	return report, nil
+}
+
+// recordedTotals are the generatio_users.financial_data keys reconciliation
+// reads and fixes
+type recordedTotals struct {
+	TotalSpent       float64      `json:"total_spent"`
+	TotalSpentMicros money.Micros `json:"total_spent_micros"`
+	TotalImages      int          `json:"total_images"`
+}
+
+// fix resets a user's recorded totals to their image sums, keeping the other
+// financial_data keys
+func (s *Service) fix(user *core.Record, drift UserDrift) error {
+	totals := recordedTotals{
+		TotalSpent:       drift.ImageSpentMicros.USD(),
+		TotalSpentMicros: drift.ImageSpentMicros,
+		TotalImages:      drift.ImageCount,
+	}
+	if err := recordjson.Merge(user, "financial_data", totals); err != nil {
+		return err
+	}
+	if err := s.app.Save(user); err != nil {
+		return fmt.Errorf("failed to save financial data: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// Package recordjson maps JSON record fields onto typed values with a JSON
+// round-trip, so struct tags decide the field names. A field may hold
+// types.JSONRaw when read from the database, the map or struct it was last
+// set to, or a string; strings that themselves encode JSON, as older clients
+// saved financial_data and preferences, are decoded too.
+package recordjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Decode reads a record's JSON field into dst. An empty or null field
+// leaves dst untouched.
+func Decode(record *core.Record, field string, dst any) error {
+	raw, err := fieldJSON(record.Get(field))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", field, err)
+	}
+	if raw == nil {
+		return nil
+	}
+	if err := json.Unmarshal(raw, dst); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", field, err)
+	}
+	return nil
+}
+
+// Encode sets a record's JSON field to value
+func Encode(record *core.Record, field string, value any) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", field, err)
+	}
+	record.Set(field, types.JSONRaw(raw))
+	return nil
+}
+
+// Merge sets the keys of value, a struct or map, in a record's JSON object
+// field and keeps the keys it does not name. A field that is not an object
+// is replaced.
+func Merge(record *core.Record, field string, value any) error {
+	var updates map[string]any
+	raw, err := json.Marshal(value)
+	if err == nil {
+		err = json.Unmarshal(raw, &updates)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", field, err)
+	}
+
+	merged := map[string]any{}
+	if err := Decode(record, field, &merged); err != nil || merged == nil {
+		merged = map[string]any{}
+	}
+	for key, update := range updates {
+		merged[key] = update
+	}
+	return Encode(record, field, merged)
+}
+
+// fieldJSON returns the JSON held by a field value, or nil when it is empty.
+// A JSON string is unwrapped once, since it may encode the actual value.
+func fieldJSON(value any) ([]byte, error) {
+	var raw []byte
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case types.JSONRaw:
+		raw = v
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		raw = encoded
+	}
+
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '"' {
+		var inner string
+		if err := json.Unmarshal(raw, &inner); err != nil {
+			return nil, err
+		}
+		raw = bytes.TrimSpace([]byte(inner))
+	}
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	return raw, nil
+}
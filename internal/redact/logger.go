@@ -0,0 +1,96 @@
+package redact
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// credentialSchemes prefix Authorization values; strings starting with one are
+// credentials whatever key they are logged under
+var credentialSchemes = []string{"Bearer ", "Basic ", "Key "}
+
+// Logger wraps logger so attributes naming a secret, credential headers and
+// Authorization-style values are masked before they reach its handler. A nil
+// logger wraps slog.Default(); wrapping twice returns the same logger.
+func Logger(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if _, ok := logger.Handler().(*sanitizingHandler); ok {
+		return logger
+	}
+	return slog.New(&sanitizingHandler{inner: logger.Handler()})
+}
+
+// sanitizingHandler masks sensitive attributes of the records it passes on
+type sanitizingHandler struct {
+	inner slog.Handler
+}
+
+func (h *sanitizingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.inner.Enabled(ctx, level)
+}
+
+func (h *sanitizingHandler) Handle(ctx context.Context, record slog.Record) error {
+	sanitized := slog.NewRecord(record.Time, record.Level, record.Message, record.PC)
+	record.Attrs(func(attr slog.Attr) bool {
+		sanitized.AddAttrs(sanitizeAttr(attr))
+		return true
+	})
+	return h.inner.Handle(ctx, sanitized)
+}
+
+func (h *sanitizingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	sanitized := make([]slog.Attr, len(attrs))
+	for i, attr := range attrs {
+		sanitized[i] = sanitizeAttr(attr)
+	}
+	return &sanitizingHandler{inner: h.inner.WithAttrs(sanitized)}
+}
+
+func (h *sanitizingHandler) WithGroup(name string) slog.Handler {
+	return &sanitizingHandler{inner: h.inner.WithGroup(name)}
+}
+
+// sanitizeAttr masks an attribute whose key names a secret or credential
+// header, or whose value is a credential; headers are logged redacted
+func sanitizeAttr(attr slog.Attr) slog.Attr {
+	if IsSensitiveField(attr.Key) || IsSensitiveHeader(attr.Key) {
+		return slog.String(attr.Key, Mask)
+	}
+
+	value := attr.Value.Resolve()
+	switch value.Kind() {
+	case slog.KindGroup:
+		group := value.Group()
+		sanitized := make([]any, len(group))
+		for i, inner := range group {
+			sanitized[i] = sanitizeAttr(inner)
+		}
+		return slog.Group(attr.Key, sanitized...)
+	case slog.KindString:
+		if isCredential(value.String()) {
+			return slog.String(attr.Key, Mask)
+		}
+	case slog.KindAny:
+		switch v := value.Any().(type) {
+		case http.Header:
+			return slog.Any(attr.Key, Headers(v))
+		case *http.Request:
+			return slog.Group(attr.Key, "method", v.Method, "path", v.URL.Path, "headers", Headers(v.Header))
+		}
+	}
+	return slog.Attr{Key: attr.Key, Value: value}
+}
+
+// isCredential reports whether a string is an Authorization-style credential
+func isCredential(value string) bool {
+	for _, scheme := range credentialSchemes {
+		if strings.HasPrefix(value, scheme) {
+			return true
+		}
+	}
+	return false
+}
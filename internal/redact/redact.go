@@ -0,0 +1,112 @@
+// Package redact masks secrets in request and response data before it is
+// logged: FAL AI tokens, passwords, session IDs, API keys, share link
+// signatures and credential headers.
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Mask replaces every redacted value
+const Mask = "[REDACTED]"
+
+// sensitiveHeaders are the canonical names of headers carrying credentials
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Session-Id":        true,
+	"X-Api-Key":           true,
+	"X-Csrf-Token":        true,
+	"Proxy-Authorization": true,
+}
+
+// sensitiveFields are the JSON keys holding secrets, compared case-insensitively
+var sensitiveFields = map[string]bool{
+	"fal_token":     true,
+	"password":      true,
+	"refresh_token": true,
+	"token":         true,
+	"session_id":    true,
+	"secret":        true,
+	"key":           true,
+	"sig":           true,
+}
+
+// IsSensitiveHeader reports whether a header carries credentials
+func IsSensitiveHeader(name string) bool {
+	return sensitiveHeaders[http.CanonicalHeaderKey(name)]
+}
+
+// IsSensitiveField reports whether a JSON key or log attribute holds a secret
+func IsSensitiveField(name string) bool {
+	return sensitiveFields[strings.ToLower(name)]
+}
+
+// Headers flattens headers for logging with credential values masked
+func Headers(header http.Header) map[string]string {
+	flat := make(map[string]string, len(header))
+	for name, values := range header {
+		if IsSensitiveHeader(name) {
+			flat[name] = Mask
+			continue
+		}
+		flat[name] = strings.Join(values, ", ")
+	}
+	return flat
+}
+
+// Query returns a URL query for logging with sensitive parameters masked
+func Query(query url.Values) string {
+	masked := make(url.Values, len(query))
+	for key, values := range query {
+		if IsSensitiveField(key) {
+			masked[key] = []string{Mask}
+			continue
+		}
+		masked[key] = values
+	}
+	return masked.Encode()
+}
+
+// JSON returns a body for logging with the values of sensitive keys masked
+// at any depth. Bodies that are not JSON are summarised by their size, since
+// there is no telling which parts of them are secret.
+func JSON(body []byte) string {
+	if len(bytes.TrimSpace(body)) == 0 {
+		return ""
+	}
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Sprintf("<%d bytes>", len(body))
+	}
+	masked, err := json.Marshal(maskValue(value))
+	if err != nil {
+		return fmt.Sprintf("<%d bytes>", len(body))
+	}
+	return string(masked)
+}
+
+// maskValue masks sensitive keys of the objects within a decoded JSON value
+func maskValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, inner := range v {
+			if IsSensitiveField(key) {
+				v[key] = Mask
+				continue
+			}
+			v[key] = maskValue(inner)
+		}
+	case []any:
+		for i, inner := range v {
+			v[i] = maskValue(inner)
+		}
+	}
+	return value
+}
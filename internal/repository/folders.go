@@ -0,0 +1,33 @@
+package repository
+
+import "github.com/pocketbase/pocketbase/core"
+
+// Folders reads folder records
+type Folders struct {
+	records
+}
+
+// NewFolders creates a folder repository
+func NewFolders(app core.App) *Folders {
+	return &Folders{records{app: app, collection: FoldersCollection}}
+}
+
+// LibraryFilter returns the filter selecting records of a library: the
+// organization's when orgID is set, otherwise the user's personal library
+func LibraryFilter(userID, orgID string) (string, map[string]any) {
+	if orgID != "" {
+		return "org_id = {:org_id}", map[string]any{"org_id": orgID}
+	}
+	return "user_id = {:user_id} && org_id = ''", map[string]any{"user_id": userID}
+}
+
+// InLibrary returns up to limit live folders of a library, newest first
+func (r *Folders) InLibrary(userID, orgID string, limit int) ([]*core.Record, error) {
+	filter, params := LibraryFilter(userID, orgID)
+	return r.Find(filter, "-created", limit, 0, params)
+}
+
+// Children returns the live subfolders of a folder, oldest first
+func (r *Folders) Children(parentID string) ([]*core.Record, error) {
+	return r.Find("parent_id = {:parent_id}", "created", 0, 0, map[string]any{"parent_id": parentID})
+}
@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"time"
+
+	"generatio-pb/internal/pagination"
+	"generatio-pb/internal/smartfolders"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Images reads image records
+type Images struct {
+	records
+}
+
+// NewImages creates an image repository
+func NewImages(app core.App) *Images {
+	return &Images{records{app: app, collection: ImagesCollection}}
+}
+
+// Owned returns a live image of the user
+func (r *Images) Owned(userID, id string) (*core.Record, error) {
+	return r.OwnedIn(Live, userID, id)
+}
+
+// OwnedIn returns an image of the user within scope
+func (r *Images) OwnedIn(scope Scope, userID, id string) (*core.Record, error) {
+	record, err := r.GetIn(scope, id)
+	if err != nil || record.GetString("user_id") != userID {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+// InFolder returns the live images directly in a folder in sort order;
+// a limit of 0 returns them all
+func (r *Images) InFolder(folderID, sort string, limit, offset int) ([]*core.Record, error) {
+	return r.Find("folder_id = {:folder_id}", sort, limit, offset, map[string]any{"folder_id": folderID})
+}
+
+// FolderPage returns a page of the live images directly in a folder, newest first
+func (r *Images) FolderPage(folderID string, page pagination.Page) ([]*core.Record, string, error) {
+	return r.Page("folder_id = {:folder_id}", map[string]any{"folder_id": folderID}, page)
+}
+
+// TrashPage returns a page of the user's trashed images, newest first
+func (r *Images) TrashPage(userID string, page pagination.Page) ([]*core.Record, string, error) {
+	return r.PageIn(Trashed, "user_id = {:user_id}", map[string]any{"user_id": userID}, page)
+}
+
+// Search returns a page of the live images in the user's personal library
+// matching a smart folder filter, newest first
+func (r *Images) Search(userID string, filter smartfolders.Filter, page pagination.Page) ([]*core.Record, string, error) {
+	clauses, params := smartfolders.Query(userID, filter)
+	return r.Page(clauses, params, page)
+}
+
+// CreatedSince returns the live images the user created at or after since
+func (r *Images) CreatedSince(userID string, since time.Time) ([]*core.Record, error) {
+	return r.Find(
+		"user_id = {:user_id} && created >= {:since}",
+		"",
+		-1,
+		0,
+		map[string]any{"user_id": userID, "since": since.UTC().Format(types.DefaultDateLayout)},
+	)
+}
+
+// InGroup returns the live images generated together, e.g. by one
+// comparison, oldest first
+func (r *Images) InGroup(groupID string) ([]*core.Record, error) {
+	return r.Find("group_id = {:group_id}", "created", 0, 0, map[string]any{"group_id": groupID})
+}
+
+// Moderated returns up to limit live images of a library with a moderation
+// status, newest first
+func (r *Images) Moderated(userID, orgID, status string, limit int) ([]*core.Record, error) {
+	filter, params := LibraryFilter(userID, orgID)
+	params["status"] = status
+	return r.Find(filter+" && moderation_status = {:status}", "-created", limit, 0, params)
+}
+
+// PromptHistory returns up to limit of the user's live images with a prompt
+// containing query (any prompt when query is empty), newest first
+func (r *Images) PromptHistory(userID, query string, limit int) ([]*core.Record, error) {
+	filter := "user_id = {:user_id} && prompt != ''"
+	params := map[string]any{"user_id": userID}
+	if query != "" {
+		filter += " && prompt ~ {:query}"
+		params["query"] = query
+	}
+	return r.Find(filter, "-created", limit, 0, params)
+}
+
+// DerivedFrom returns the images derived from a parent image, oldest first.
+// Trashed images are included so a lineage tree stays connected.
+func (r *Images) DerivedFrom(parentID string) ([]*core.Record, error) {
+	return r.FindIn(All, "parent_id = {:parent_id}", "created", 0, 0, map[string]any{"parent_id": parentID})
+}
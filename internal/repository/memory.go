@@ -0,0 +1,380 @@
+package repository
+
+import (
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"generatio-pb/internal/pagination"
+	"generatio-pb/internal/recordjson"
+	"generatio-pb/internal/smartfolders"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// memoryRecords keeps copies of records in memory. Saved records are cloned,
+// so changes only take effect when they are saved, like in the database.
+type memoryRecords struct {
+	mu         sync.Mutex
+	collection *core.Collection
+	records    map[string]*core.Record
+}
+
+func newMemoryRecords(collection string) *memoryRecords {
+	return &memoryRecords{
+		collection: core.NewBaseCollection(collection),
+		records:    make(map[string]*core.Record),
+	}
+}
+
+// newRecord returns an unsaved record of the collection
+func (m *memoryRecords) newRecord() *core.Record {
+	return core.NewRecord(m.collection)
+}
+
+// Save stores a copy of a record, assigning its id and timestamps
+func (m *memoryRecords) Save(record *core.Record) error {
+	if record.Id == "" {
+		record.Id = core.GenerateDefaultRandomId()
+	}
+	now := types.NowDateTime()
+	if record.GetDateTime("created").IsZero() {
+		record.Set("created", now)
+	}
+	record.Set("updated", now)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[record.Id] = record.Clone()
+	return nil
+}
+
+// GetIn returns a copy of a record within scope
+func (m *memoryRecords) GetIn(scope Scope, id string) (*core.Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.records[id]
+	if !ok || !scope.includes(record) {
+		return nil, ErrNotFound
+	}
+	return record.Clone(), nil
+}
+
+// Get returns a copy of a live record
+func (m *memoryRecords) Get(id string) (*core.Record, error) {
+	return m.GetIn(Live, id)
+}
+
+// ByIDs returns copies of the live records among ids
+func (m *memoryRecords) ByIDs(ids []string) ([]*core.Record, error) {
+	return m.find(Live, func(record *core.Record) bool {
+		return slices.Contains(ids, record.Id)
+	}, "", 0, 0), nil
+}
+
+// find returns copies of the records within scope that match, in sort order
+// (comma separated fields, "-" for descending); a limit of 0 returns them all
+func (m *memoryRecords) find(scope Scope, match func(*core.Record) bool, sortBy string, limit, offset int) []*core.Record {
+	m.mu.Lock()
+	found := make([]*core.Record, 0, len(m.records))
+	for _, record := range m.records {
+		if scope.includes(record) && match(record) {
+			found = append(found, record.Clone())
+		}
+	}
+	m.mu.Unlock()
+
+	if sortBy != "" {
+		fields := strings.Split(sortBy, ",")
+		sort.SliceStable(found, func(i, j int) bool {
+			return compareRecords(found[i], found[j], fields) < 0
+		})
+	}
+
+	if offset >= len(found) {
+		return nil
+	}
+	found = found[offset:]
+	if limit > 0 && limit < len(found) {
+		found = found[:limit]
+	}
+	return found
+}
+
+// page returns a page of the records within scope that match, like
+// pagination.Find
+func (m *memoryRecords) page(scope Scope, match func(*core.Record) bool, page pagination.Page) ([]*core.Record, string, error) {
+	offset := (page.Number - 1) * page.Limit
+	if cursor := page.Cursor; cursor != nil {
+		offset = 0
+		inner := match
+		match = func(record *core.Record) bool {
+			created := record.GetDateTime("created").String()
+			before := created < cursor.Created || (created == cursor.Created && record.Id < cursor.ID)
+			return before && inner(record)
+		}
+	}
+
+	records := m.find(scope, match, "-created,-id", page.Limit+1, offset)
+	if len(records) <= page.Limit {
+		return records, "", nil
+	}
+	records = records[:page.Limit]
+	return records, pagination.CursorFor(records[len(records)-1]).Encode(), nil
+}
+
+// compareRecords orders two records by the sort fields
+func compareRecords(a, b *core.Record, fields []string) int {
+	for _, field := range fields {
+		desc := strings.HasPrefix(field, "-")
+		field = strings.TrimPrefix(field, "-")
+
+		var c int
+		switch a.Get(field).(type) {
+		case types.DateTime:
+			c = a.GetDateTime(field).Time().Compare(b.GetDateTime(field).Time())
+		case int, int64, float64:
+			c = compareFloats(a.GetFloat(field), b.GetFloat(field))
+		default:
+			c = strings.Compare(a.GetString(field), b.GetString(field))
+		}
+		if desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// containsFold reports whether s contains substr, ignoring case like a
+// "~" filter
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// inLibrary reports whether a record belongs to a library, like LibraryFilter
+func inLibrary(record *core.Record, userID, orgID string) bool {
+	if orgID != "" {
+		return record.GetString("org_id") == orgID
+	}
+	return record.GetString("user_id") == userID && record.GetString("org_id") == ""
+}
+
+// MemoryFolders is an in-memory Folders for tests
+type MemoryFolders struct {
+	*memoryRecords
+}
+
+// NewMemoryFolders creates an empty in-memory folder repository
+func NewMemoryFolders() *MemoryFolders {
+	return &MemoryFolders{newMemoryRecords(FoldersCollection)}
+}
+
+// InLibrary returns up to limit live folders of a library, newest first
+func (m *MemoryFolders) InLibrary(userID, orgID string, limit int) ([]*core.Record, error) {
+	return m.find(Live, func(record *core.Record) bool {
+		return inLibrary(record, userID, orgID)
+	}, "-created", limit, 0), nil
+}
+
+// Children returns the live subfolders of a folder, oldest first
+func (m *MemoryFolders) Children(parentID string) ([]*core.Record, error) {
+	return m.find(Live, func(record *core.Record) bool {
+		return record.GetString("parent_id") == parentID
+	}, "created", 0, 0), nil
+}
+
+// MemoryImages is an in-memory Images for tests
+type MemoryImages struct {
+	*memoryRecords
+}
+
+// NewMemoryImages creates an empty in-memory image repository
+func NewMemoryImages() *MemoryImages {
+	return &MemoryImages{newMemoryRecords(ImagesCollection)}
+}
+
+// Owned returns a live image of the user
+func (m *MemoryImages) Owned(userID, id string) (*core.Record, error) {
+	return m.OwnedIn(Live, userID, id)
+}
+
+// OwnedIn returns an image of the user within scope
+func (m *MemoryImages) OwnedIn(scope Scope, userID, id string) (*core.Record, error) {
+	record, err := m.GetIn(scope, id)
+	if err != nil || record.GetString("user_id") != userID {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+// InFolder returns the live images directly in a folder in sort order
+func (m *MemoryImages) InFolder(folderID, sortBy string, limit, offset int) ([]*core.Record, error) {
+	return m.find(Live, func(record *core.Record) bool {
+		return record.GetString("folder_id") == folderID
+	}, sortBy, limit, offset), nil
+}
+
+// FolderPage returns a page of the live images directly in a folder
+func (m *MemoryImages) FolderPage(folderID string, page pagination.Page) ([]*core.Record, string, error) {
+	return m.page(Live, func(record *core.Record) bool {
+		return record.GetString("folder_id") == folderID
+	}, page)
+}
+
+// TrashPage returns a page of the user's trashed images
+func (m *MemoryImages) TrashPage(userID string, page pagination.Page) ([]*core.Record, string, error) {
+	return m.page(Trashed, func(record *core.Record) bool {
+		return record.GetString("user_id") == userID
+	}, page)
+}
+
+// Search returns a page of the user's live personal images matching a smart
+// folder filter
+func (m *MemoryImages) Search(userID string, filter smartfolders.Filter, page pagination.Page) ([]*core.Record, string, error) {
+	var since time.Time
+	if filter.Days > 0 {
+		since = time.Now().UTC().AddDate(0, 0, -filter.Days)
+	}
+
+	return m.page(Live, func(record *core.Record) bool {
+		switch {
+		case !inLibrary(record, userID, ""):
+			return false
+		case filter.Model != "" && !containsFold(record.GetString("model"), filter.Model):
+			return false
+		case filter.Tag != "" && !slices.ContainsFunc(record.GetStringSlice("tags"), func(tag string) bool {
+			return strings.EqualFold(tag, filter.Tag)
+		}):
+			return false
+		case filter.Prompt != "" && !containsFold(record.GetString("prompt"), filter.Prompt) &&
+			!containsFold(record.GetString("caption"), filter.Prompt):
+			return false
+		case filter.Favorite != nil && record.GetBool("favorite") != *filter.Favorite:
+			return false
+		case !since.IsZero() && record.GetDateTime("created").Time().Before(since):
+			return false
+		}
+		return true
+	}, page)
+}
+
+// CreatedSince returns the live images the user created at or after since
+func (m *MemoryImages) CreatedSince(userID string, since time.Time) ([]*core.Record, error) {
+	return m.find(Live, func(record *core.Record) bool {
+		return record.GetString("user_id") == userID && !record.GetDateTime("created").Time().Before(since)
+	}, "", 0, 0), nil
+}
+
+// InGroup returns the live images generated together, oldest first
+func (m *MemoryImages) InGroup(groupID string) ([]*core.Record, error) {
+	return m.find(Live, func(record *core.Record) bool {
+		return record.GetString("group_id") == groupID
+	}, "created", 0, 0), nil
+}
+
+// Moderated returns up to limit live images of a library with a moderation
+// status, newest first
+func (m *MemoryImages) Moderated(userID, orgID, status string, limit int) ([]*core.Record, error) {
+	return m.find(Live, func(record *core.Record) bool {
+		return inLibrary(record, userID, orgID) && record.GetString("moderation_status") == status
+	}, "-created", limit, 0), nil
+}
+
+// PromptHistory returns up to limit of the user's live images with a prompt
+// containing query, newest first
+func (m *MemoryImages) PromptHistory(userID, query string, limit int) ([]*core.Record, error) {
+	return m.find(Live, func(record *core.Record) bool {
+		prompt := record.GetString("prompt")
+		return record.GetString("user_id") == userID && prompt != "" && containsFold(prompt, query)
+	}, "-created", limit, 0), nil
+}
+
+// DerivedFrom returns the images derived from a parent image, trashed ones
+// included, oldest first
+func (m *MemoryImages) DerivedFrom(parentID string) ([]*core.Record, error) {
+	return m.find(All, func(record *core.Record) bool {
+		return record.GetString("parent_id") == parentID
+	}, "created", 0, 0), nil
+}
+
+// MemoryPreferences is an in-memory Preferences for tests. It has no
+// records saved before user_id, so only user_id selects a user's records.
+type MemoryPreferences struct {
+	*memoryRecords
+}
+
+// NewMemoryPreferences creates an empty in-memory preferences repository
+func NewMemoryPreferences() *MemoryPreferences {
+	return &MemoryPreferences{newMemoryRecords(PreferencesCollection)}
+}
+
+// ForUser returns the user's preference records for every model
+func (m *MemoryPreferences) ForUser(user *core.Record) ([]*core.Record, error) {
+	return m.find(All, func(record *core.Record) bool {
+		return record.GetString("user_id") == user.Id
+	}, "created", 0, 0), nil
+}
+
+// ForModel returns the user's preference record for a model
+func (m *MemoryPreferences) ForModel(user *core.Record, modelName string) (*core.Record, error) {
+	found := m.find(All, func(record *core.Record) bool {
+		return record.GetString("user_id") == user.Id && record.GetString("model_name") == modelName
+	}, "", 1, 0)
+	if len(found) == 0 {
+		return nil, ErrNotFound
+	}
+	return found[0], nil
+}
+
+// Upsert saves the user's preferences for a model and reports whether a
+// record was created
+func (m *MemoryPreferences) Upsert(user *core.Record, modelName string, prefs map[string]any) (*core.Record, bool, error) {
+	record, err := m.ForModel(user, modelName)
+	created := err != nil
+	if created {
+		record = m.newRecord()
+		record.Set("user_id", user.Id)
+		record.Set("model_name", modelName)
+	}
+	if err := recordjson.Encode(record, "preferences", prefs); err != nil {
+		return nil, false, err
+	}
+	return record, created, m.Save(record)
+}
+
+// MemoryUsers is an in-memory Users for tests
+type MemoryUsers struct {
+	*memoryRecords
+}
+
+// NewMemoryUsers creates an empty in-memory user repository
+func NewMemoryUsers() *MemoryUsers {
+	return &MemoryUsers{newMemoryRecords(UsersCollection)}
+}
+
+// ByEmail returns the user with an email address
+func (m *MemoryUsers) ByEmail(email string) (*core.Record, error) {
+	found := m.find(All, func(record *core.Record) bool {
+		return strings.EqualFold(record.GetString("email"), email)
+	}, "", 1, 0)
+	if len(found) == 0 {
+		return nil, ErrNotFound
+	}
+	return found[0], nil
+}
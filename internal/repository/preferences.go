@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"fmt"
+
+	"generatio-pb/internal/recordjson"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// PreferencesCollection stores users' saved parameters per model
+const PreferencesCollection = "model_preferences"
+
+// Preferences reads and saves users' model preferences. Records saved
+// before preferences carried a user_id are found through the user's
+// model_preferences links.
+type Preferences struct {
+	app core.App
+}
+
+// NewPreferences creates a preferences repository
+func NewPreferences(app core.App) *Preferences {
+	return &Preferences{app: app}
+}
+
+// ForUser returns the user's preference records for every model
+func (r *Preferences) ForUser(user *core.Record) ([]*core.Record, error) {
+	records, err := r.app.FindAllRecords(PreferencesCollection, dbx.HashExp{"user_id": user.Id})
+	if err != nil {
+		return nil, err
+	}
+
+	linked, err := r.legacy(user)
+	if err != nil {
+		return nil, err
+	}
+	return append(records, linked...), nil
+}
+
+// ForModel returns the user's preference record for a model
+func (r *Preferences) ForModel(user *core.Record, modelName string) (*core.Record, error) {
+	record, err := r.app.FindFirstRecordByFilter(
+		PreferencesCollection,
+		"user_id = {:user_id} && model_name = {:model_name}",
+		map[string]any{
+			"user_id":    user.Id,
+			"model_name": modelName,
+		},
+	)
+	if err == nil {
+		return record, nil
+	}
+
+	linked, err := r.legacy(user)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range linked {
+		if record.GetString("model_name") == modelName {
+			return record, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// Upsert saves the user's preferences for a model and reports whether a
+// record was created. The unique (user_id, model_name) index rejects the
+// second of two concurrent creates, which then updates the record the first
+// one saved.
+func (r *Preferences) Upsert(user *core.Record, modelName string, prefs map[string]any) (*core.Record, bool, error) {
+	if record, err := r.ForModel(user, modelName); err == nil {
+		record.Set("user_id", user.Id) // claims records saved before user_id
+		if err := recordjson.Encode(record, "preferences", prefs); err != nil {
+			return nil, false, err
+		}
+		return record, false, r.app.Save(record)
+	}
+
+	collection, err := r.app.FindCollectionByNameOrId(PreferencesCollection)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to find preferences collection: %w", err)
+	}
+	record := core.NewRecord(collection)
+	record.Set("user_id", user.Id)
+	record.Set("model_name", modelName)
+	if err := recordjson.Encode(record, "preferences", prefs); err != nil {
+		return nil, false, err
+	}
+	if err := r.app.Save(record); err != nil {
+		existing, findErr := r.ForModel(user, modelName)
+		if findErr != nil {
+			return nil, false, err
+		}
+		if err := recordjson.Encode(existing, "preferences", prefs); err != nil {
+			return nil, false, err
+		}
+		return existing, false, r.app.Save(existing)
+	}
+	return record, true, nil
+}
+
+// legacy returns the records linked from the user that have no user_id
+func (r *Preferences) legacy(user *core.Record) ([]*core.Record, error) {
+	linked, err := r.app.FindRecordsByIds(PreferencesCollection, user.GetStringSlice("model_preferences"))
+	if err != nil {
+		return nil, err
+	}
+
+	legacy := make([]*core.Record, 0, len(linked))
+	for _, record := range linked {
+		if record.GetString("user_id") == "" {
+			legacy = append(legacy, record)
+		}
+	}
+	return legacy, nil
+}
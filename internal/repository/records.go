@@ -0,0 +1,138 @@
+// Package repository reads folders and images with the soft-delete and
+// ownership rules applied in one place. Folders and images are moved to the
+// trash by setting deleted_at; every lookup leaves trashed records out
+// unless the caller asks for them with a Scope.
+//
+// Preferences and Users wrap the model_preferences and generatio_users
+// collections. Each repository has an in-memory counterpart (see memory.go)
+// for testing handler logic without a database.
+package repository
+
+import (
+	"errors"
+
+	"generatio-pb/internal/pagination"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Collection names
+const (
+	FoldersCollection = "folders"
+	ImagesCollection  = "images"
+)
+
+// ErrNotFound is returned for records that do not exist, are outside the
+// requested scope or belong to another user
+var ErrNotFound = errors.New("record not found")
+
+// Scope selects records by whether they are in the trash
+type Scope int
+
+const (
+	Live    Scope = iota // Not in the trash
+	Trashed              // Only in the trash
+	All                  // Both, e.g. to keep a lineage tree connected
+)
+
+// clause returns the filter clause for the scope, or "" for All
+func (s Scope) clause() string {
+	switch s {
+	case Live:
+		return "deleted_at = null"
+	case Trashed:
+		return "deleted_at != null"
+	}
+	return ""
+}
+
+// includes reports whether a record belongs to the scope
+func (s Scope) includes(record *core.Record) bool {
+	trashed := !record.GetDateTime("deleted_at").IsZero()
+	switch s {
+	case Live:
+		return !trashed
+	case Trashed:
+		return trashed
+	}
+	return true
+}
+
+// scoped adds the scope's clause to filter
+func scoped(filter string, scope Scope) string {
+	clause := scope.clause()
+	switch {
+	case clause == "":
+		return filter
+	case filter == "":
+		return clause
+	}
+	return "(" + filter + ") && " + clause
+}
+
+// records holds the scoped lookups shared by folders and images
+type records struct {
+	app        core.App
+	collection string
+}
+
+// Get returns a live record
+func (r records) Get(id string) (*core.Record, error) {
+	return r.GetIn(Live, id)
+}
+
+// GetIn returns a record within scope
+func (r records) GetIn(scope Scope, id string) (*core.Record, error) {
+	if id == "" {
+		return nil, ErrNotFound
+	}
+	record, err := r.app.FindRecordById(r.collection, id)
+	if err != nil || !scope.includes(record) {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+// Find returns the live records matching filter
+func (r records) Find(filter, sort string, limit, offset int, params map[string]any) ([]*core.Record, error) {
+	return r.FindIn(Live, filter, sort, limit, offset, params)
+}
+
+// FindIn returns the records within scope matching filter
+func (r records) FindIn(scope Scope, filter, sort string, limit, offset int, params map[string]any) ([]*core.Record, error) {
+	return r.app.FindRecordsByFilter(r.collection, scoped(filter, scope), sort, limit, offset, params)
+}
+
+// Page returns a page of the live records matching filter, newest first,
+// and the cursor of the following page
+func (r records) Page(filter string, params map[string]any, page pagination.Page) ([]*core.Record, string, error) {
+	return r.PageIn(Live, filter, params, page)
+}
+
+// PageIn returns a page of the records within scope matching filter
+func (r records) PageIn(scope Scope, filter string, params map[string]any, page pagination.Page) ([]*core.Record, string, error) {
+	return pagination.Find(r.app, r.collection, scoped(filter, scope), params, page)
+}
+
+// Save creates or updates a record
+func (r records) Save(record *core.Record) error {
+	return r.app.Save(record)
+}
+
+// ByIDs returns the live records among ids, in no particular order
+func (r records) ByIDs(ids []string) ([]*core.Record, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	found, err := r.app.FindRecordsByIds(r.collection, ids)
+	if err != nil {
+		return nil, err
+	}
+	live := make([]*core.Record, 0, len(found))
+	for _, record := range found {
+		if Live.includes(record) {
+			live = append(live, record)
+		}
+	}
+	return live, nil
+}
@@ -0,0 +1,42 @@
+package repository
+
+import "github.com/pocketbase/pocketbase/core"
+
+// UsersCollection is the auth collection of application users
+const UsersCollection = "generatio_users"
+
+// Users reads and saves user records
+type Users struct {
+	app core.App
+}
+
+// NewUsers creates a user repository
+func NewUsers(app core.App) *Users {
+	return &Users{app: app}
+}
+
+// Get returns a user by id
+func (r *Users) Get(id string) (*core.Record, error) {
+	if id == "" {
+		return nil, ErrNotFound
+	}
+	user, err := r.app.FindRecordById(UsersCollection, id)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return user, nil
+}
+
+// ByEmail returns the user with an email address
+func (r *Users) ByEmail(email string) (*core.Record, error) {
+	user, err := r.app.FindAuthRecordByEmail(UsersCollection, email)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return user, nil
+}
+
+// Save updates a user
+func (r *Users) Save(user *core.Record) error {
+	return r.app.Save(user)
+}
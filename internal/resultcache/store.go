@@ -0,0 +1,82 @@
+package resultcache
+
+import (
+	"fmt"
+	"time"
+
+	"generatio-pb/internal/dedupe"
+	"generatio-pb/internal/moderation"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Field is the images field holding the key of the request that produced them
+const Field = "cache_key"
+
+// Key returns the cache key of a generation, or "" when its result is not
+// reproducible. Only an explicit seed makes a model return the same images
+// for the same prompt and parameters, so unseeded requests are never cached.
+func Key(userID, orgID, collectionID, model, prompt string, parameters map[string]interface{}) string {
+	if _, ok := parameters["seed"]; !ok {
+		return ""
+	}
+	return dedupe.Request{
+		UserID:       userID,
+		OrgID:        orgID,
+		CollectionID: collectionID,
+		Model:        model,
+		Prompt:       prompt,
+		Parameters:   parameters,
+	}.Fingerprint()
+}
+
+// Store finds the saved images of an earlier identical generation, so a
+// repeat can be answered without generating or charging anything
+type Store struct {
+	app core.App
+	ttl time.Duration
+}
+
+// NewStore creates a store answering from generations made within ttl; a
+// non-positive ttl disables the cache
+func NewStore(app core.App, ttl time.Duration) *Store {
+	return &Store{app: app, ttl: ttl}
+}
+
+// Enabled reports whether repeats are answered from the cache
+func (s *Store) Enabled() bool {
+	return s.ttl > 0
+}
+
+// Lookup returns the images of the newest generation stored under key. It
+// misses when the cache is disabled, the generation is older than the ttl,
+// or any of its images was trashed or quarantined since.
+func (s *Store) Lookup(key string) ([]*core.Record, error) {
+	if !s.Enabled() || key == "" {
+		return nil, nil
+	}
+
+	cutoff := time.Now().UTC().Add(-s.ttl).Format(types.DefaultDateLayout)
+	newest, err := s.app.FindRecordsByFilter("images", Field+" = {:key} && created >= {:cutoff} && deleted_at = null",
+		"-created", 1, 0, dbx.Params{"key": key, "cutoff": cutoff})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up cached images: %w", err)
+	}
+	if len(newest) == 0 {
+		return nil, nil
+	}
+
+	images, err := s.app.FindRecordsByFilter("images", Field+" = {:key} && request_id = {:request_id}",
+		"batch_number", 0, 0, dbx.Params{"key": key, "request_id": newest[0].GetString("request_id")})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up cached images: %w", err)
+	}
+	for _, image := range images {
+		if !image.GetDateTime("deleted_at").IsZero() || image.GetString("moderation_status") == moderation.StatusQuarantined {
+			return nil, nil
+		}
+	}
+	return images, nil
+}
@@ -0,0 +1,205 @@
+package retention
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"generatio-pb/internal/repository"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Actions applied to expired images
+const (
+	ActionArchive = "archive" // Sets archived_at and keeps the record
+	ActionDelete  = "delete"  // Permanently deletes the record
+)
+
+// Policy sources reported to users
+const (
+	SourceUser       = "user"
+	SourceDeployment = "deployment"
+)
+
+// Policy is the retention rule that applies to a user's images
+type Policy struct {
+	Days   int    `json:"retention_days"` // 0 means images never expire
+	Action string `json:"action"`
+	Source string `json:"source"`
+}
+
+// Report summarises a purge run
+type Report struct {
+	UsersScanned int `json:"users_scanned"`
+	Archived     int `json:"archived"`
+	Deleted      int `json:"deleted"`
+	Failed       int `json:"failed"`
+}
+
+// Service expires images older than the applicable retention policy.
+// Users override the deployment default with generatio_users.retention_days:
+// 0 inherits the default, a negative value keeps images forever.
+// Favorited images are never expired.
+type Service struct {
+	app         core.App
+	images      *repository.Images
+	defaultDays int
+	action      string
+	interval    time.Duration
+
+	runMutex sync.Mutex
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// NewService creates a retention service with the deployment default policy
+func NewService(app core.App, defaultDays int, action string, interval time.Duration) *Service {
+	if action != ActionDelete {
+		action = ActionArchive
+	}
+	if interval <= 0 {
+		interval = 1 * time.Hour
+	}
+
+	return &Service{
+		app:         app,
+		images:      repository.NewImages(app),
+		defaultDays: defaultDays,
+		action:      action,
+		interval:    interval,
+		stopChan:    make(chan struct{}),
+	}
+}
+
+// Start begins the background purge loop
+func (s *Service) Start() {
+	go s.run()
+	log.Printf("Retention service started with interval: %v", s.interval)
+}
+
+// Stop stops the background purge loop
+func (s *Service) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+	})
+}
+
+func (s *Service) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			report, err := s.Run()
+			if err != nil {
+				log.Printf("Retention run failed: %v", err)
+			} else if report.Archived+report.Deleted+report.Failed > 0 {
+				log.Printf("Retention run completed: %d archived, %d deleted, %d failed", report.Archived, report.Deleted, report.Failed)
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// PolicyFor returns the policy that applies to a user
+func (s *Service) PolicyFor(user *core.Record) Policy {
+	days := user.GetInt("retention_days")
+	if days != 0 {
+		if days < 0 {
+			days = 0
+		}
+		return Policy{Days: days, Action: s.action, Source: SourceUser}
+	}
+
+	days = s.defaultDays
+	if days < 0 {
+		days = 0
+	}
+	return Policy{Days: days, Action: s.action, Source: SourceDeployment}
+}
+
+// SetUserDays stores a user's override (0 inherits the default, negative keeps forever)
+func (s *Service) SetUserDays(user *core.Record, days int) error {
+	user.Set("retention_days", days)
+	if err := s.app.Save(user); err != nil {
+		return fmt.Errorf("failed to save retention policy: %w", err)
+	}
+	return nil
+}
+
+// Preview returns the images that the next run would expire for a user
+func (s *Service) Preview(user *core.Record) ([]*core.Record, error) {
+	policy := s.PolicyFor(user)
+	if policy.Days == 0 {
+		return []*core.Record{}, nil
+	}
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -policy.Days)
+	records, err := s.images.Find(
+		"user_id = {:user_id} && created < {:cutoff} && favorite != true && archived_at = null",
+		"created",
+		0,
+		0,
+		map[string]any{
+			"user_id": user.Id,
+			"cutoff":  cutoff.Format(types.DefaultDateLayout),
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch expired images: %w", err)
+	}
+	return records, nil
+}
+
+// Run expires images for every user with an active policy
+func (s *Service) Run() (*Report, error) {
+	s.runMutex.Lock()
+	defer s.runMutex.Unlock()
+
+	users, err := s.app.FindAllRecords("generatio_users")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch users: %w", err)
+	}
+
+	report := &Report{}
+	for _, user := range users {
+		report.UsersScanned++
+
+		expired, err := s.Preview(user)
+		if err != nil {
+			s.app.Logger().Error("Failed to find expired images", "error", err, "user_id", user.Id)
+			continue
+		}
+
+		for _, record := range expired {
+			if err := s.expire(record); err != nil {
+				s.app.Logger().Error("Failed to expire image", "error", err, "image_id", record.Id)
+				report.Failed++
+				continue
+			}
+
+			if s.action == ActionDelete {
+				report.Deleted++
+			} else {
+				report.Archived++
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// expire applies the configured action to a single image
+func (s *Service) expire(record *core.Record) error {
+	if s.action == ActionDelete {
+		return s.app.Delete(record)
+	}
+
+	record.Set("archived_at", types.NowDateTime())
+	return s.app.Save(record)
+}
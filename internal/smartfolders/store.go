@@ -0,0 +1,201 @@
+package smartfolders
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Collection stores saved searches
+const Collection = "smart_folders"
+
+// Limits on saved searches
+const (
+	MaxNameLength   = 100
+	MaxPromptLength = 200
+	MaxTagLength    = 50
+	MaxDays         = 3650
+)
+
+// ErrNotFound is returned for smart folders the user does not own
+var ErrNotFound = errors.New("smart folder not found")
+
+// Filter selects the images a smart folder shows. Every set criterion must
+// match.
+type Filter struct {
+	Model    string `json:"model,omitempty"`    // Model ID contains this, e.g. "flux"
+	Tag      string `json:"tag,omitempty"`      // images.tags includes this tag
+	Prompt   string `json:"prompt,omitempty"`   // Prompt or caption contains this text
+	Favorite *bool  `json:"favorite,omitempty"` // Only favorites, or only non-favorites
+	Days     int    `json:"days,omitempty"`     // Created within the last Days days
+}
+
+// Empty reports whether the filter has no criteria
+func (f Filter) Empty() bool {
+	return f.Model == "" && f.Tag == "" && f.Prompt == "" && f.Favorite == nil && f.Days == 0
+}
+
+// SmartFolder is a saved search whose images are selected when it is read
+type SmartFolder struct {
+	ID      string    `json:"id"`
+	Name    string    `json:"name"`
+	Filter  Filter    `json:"filter"`
+	Created time.Time `json:"created"`
+	Updated time.Time `json:"updated"`
+}
+
+// Store saves users' smart folders
+type Store struct {
+	app core.App
+}
+
+// NewStore creates a smart folder store
+func NewStore(app core.App) *Store {
+	return &Store{app: app}
+}
+
+// Create saves a new smart folder
+func (s *Store) Create(userID, name string, filter Filter) (*SmartFolder, error) {
+	collection, err := s.app.FindCollectionByNameOrId(Collection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find smart folders collection: %w", err)
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("user_id", userID)
+	if err := s.save(record, name, filter); err != nil {
+		return nil, err
+	}
+	return fromRecord(record), nil
+}
+
+// Update replaces a smart folder's name and filter
+func (s *Store) Update(id, userID, name string, filter Filter) (*SmartFolder, error) {
+	record, err := s.owned(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.save(record, name, filter); err != nil {
+		return nil, err
+	}
+	return fromRecord(record), nil
+}
+
+// Find loads one of the user's smart folders
+func (s *Store) Find(id, userID string) (*SmartFolder, error) {
+	record, err := s.owned(id, userID)
+	if err != nil {
+		return nil, err
+	}
+	return fromRecord(record), nil
+}
+
+// ForUser lists the user's smart folders by name
+func (s *Store) ForUser(userID string) ([]*SmartFolder, error) {
+	records, err := s.app.FindRecordsByFilter(Collection, "user_id = {:user_id}", "name", 0, 0, map[string]any{"user_id": userID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch smart folders: %w", err)
+	}
+
+	folders := make([]*SmartFolder, 0, len(records))
+	for _, record := range records {
+		folders = append(folders, fromRecord(record))
+	}
+	return folders, nil
+}
+
+// Delete removes a smart folder; the images it showed are untouched
+func (s *Store) Delete(id, userID string) error {
+	record, err := s.owned(id, userID)
+	if err != nil {
+		return err
+	}
+	return s.app.Delete(record)
+}
+
+// Query returns the images filter and parameters selecting a smart folder's
+// images in the user's personal library. Query it through the image
+// repository, which leaves trashed images out.
+func Query(userID string, filter Filter) (string, map[string]any) {
+	clauses := []string{"user_id = {:user_id}", "org_id = ''"}
+	params := map[string]any{"user_id": userID}
+
+	if filter.Model != "" {
+		clauses = append(clauses, "model ~ {:model}")
+		params["model"] = filter.Model
+	}
+	if filter.Tag != "" {
+		// tags is a JSON array, so the quoted tag matches whole elements only
+		clauses = append(clauses, "tags ~ {:tag}")
+		params["tag"] = `"` + filter.Tag + `"`
+	}
+	if filter.Prompt != "" {
+		// Captions describe images whose prompts were terse
+		clauses = append(clauses, "(prompt ~ {:prompt} || caption ~ {:prompt})")
+		params["prompt"] = filter.Prompt
+	}
+	if filter.Favorite != nil {
+		clauses = append(clauses, "favorite = {:favorite}")
+		params["favorite"] = *filter.Favorite
+	}
+	if filter.Days > 0 {
+		clauses = append(clauses, "created >= {:since}")
+		params["since"] = time.Now().UTC().AddDate(0, 0, -filter.Days).Format(types.DefaultDateLayout)
+	}
+
+	return strings.Join(clauses, " && "), params
+}
+
+// save validates and stores a smart folder's name and filter
+func (s *Store) save(record *core.Record, name string, filter Filter) error {
+	name = strings.TrimSpace(name)
+	filter.Model = strings.TrimSpace(filter.Model)
+	filter.Tag = strings.TrimSpace(filter.Tag)
+	filter.Prompt = strings.TrimSpace(filter.Prompt)
+
+	switch {
+	case name == "":
+		return fmt.Errorf("name is required")
+	case len(name) > MaxNameLength:
+		return fmt.Errorf("name cannot exceed %d characters", MaxNameLength)
+	case filter.Empty():
+		return fmt.Errorf("filter needs at least one of model, tag, prompt, favorite or days")
+	case len(filter.Prompt) > MaxPromptLength:
+		return fmt.Errorf("prompt cannot exceed %d characters", MaxPromptLength)
+	case len(filter.Tag) > MaxTagLength || strings.Contains(filter.Tag, `"`):
+		return fmt.Errorf("tag must be at most %d characters without quotes", MaxTagLength)
+	case filter.Days < 0 || filter.Days > MaxDays:
+		return fmt.Errorf("days must be between 0 and %d", MaxDays)
+	}
+
+	record.Set("name", name)
+	record.Set("filter", filter)
+	if err := s.app.Save(record); err != nil {
+		return fmt.Errorf("failed to save smart folder: %w", err)
+	}
+	return nil
+}
+
+// owned loads a smart folder record owned by userID
+func (s *Store) owned(id, userID string) (*core.Record, error) {
+	record, err := s.app.FindRecordById(Collection, id)
+	if err != nil || record.GetString("user_id") != userID {
+		return nil, ErrNotFound
+	}
+	return record, nil
+}
+
+func fromRecord(record *core.Record) *SmartFolder {
+	folder := &SmartFolder{
+		ID:      record.Id,
+		Name:    record.GetString("name"),
+		Created: record.GetDateTime("created").Time(),
+		Updated: record.GetDateTime("updated").Time(),
+	}
+	record.UnmarshalJSONField("filter", &folder.Filter)
+	return folder
+}
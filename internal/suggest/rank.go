@@ -0,0 +1,112 @@
+package suggest
+
+import (
+	"math"
+	"sort"
+	"strings"
+)
+
+// Candidate sources
+const (
+	SourceHistory  = "history"
+	SourceTemplate = "template"
+)
+
+// Suggestion limits
+const (
+	DefaultLimit = 10
+	MaxLimit     = 25
+)
+
+// Match weights: a prompt starting with the query beats one where the query
+// only starts a later word. Templates weigh less than the user's own history.
+const (
+	prefixWeight   = 1.0
+	wordWeight     = 0.5
+	templateWeight = 0.75
+)
+
+// Candidate is a prompt that may complete a query. Count is how often the
+// user used it, or how popular a template is.
+type Candidate struct {
+	Text   string
+	Source string
+	Count  int
+}
+
+// Suggestion is a ranked completion
+type Suggestion struct {
+	Text   string  `json:"text"`
+	Source string  `json:"source"`
+	Count  int     `json:"count"`
+	Score  float64 `json:"score"`
+}
+
+// Normalize folds a prompt for matching and deduplication
+func Normalize(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// Rank scores candidates against query by prefix match and frequency and
+// returns the best limit completions. Duplicate prompts keep their best score.
+// An empty query matches every candidate as a prefix, so it ranks by
+// frequency with templates still weighed down: a history prompt used 20
+// times outranks a template used 40 times.
+func Rank(query string, candidates []Candidate, limit int) []Suggestion {
+	if limit <= 0 || limit > MaxLimit {
+		limit = DefaultLimit
+	}
+	query = Normalize(query)
+
+	best := make(map[string]Suggestion)
+	for _, candidate := range candidates {
+		text := Normalize(candidate.Text)
+		if text == "" {
+			continue
+		}
+
+		var match float64
+		switch {
+		case strings.HasPrefix(text, query):
+			match = prefixWeight
+		case strings.Contains(" "+text, " "+query):
+			match = wordWeight
+		default:
+			continue
+		}
+
+		score := match * (1 + math.Log1p(float64(candidate.Count)))
+		if candidate.Source == SourceTemplate {
+			score *= templateWeight
+		}
+
+		if existing, ok := best[text]; ok && existing.Score >= score {
+			continue
+		}
+		best[text] = Suggestion{
+			Text:   strings.TrimSpace(candidate.Text),
+			Source: candidate.Source,
+			Count:  candidate.Count,
+			Score:  math.Round(score*1000) / 1000,
+		}
+	}
+
+	ranked := make([]Suggestion, 0, len(best))
+	for _, suggestion := range best {
+		ranked = append(ranked, suggestion)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		if len(ranked[i].Text) != len(ranked[j].Text) {
+			return len(ranked[i].Text) < len(ranked[j].Text)
+		}
+		return ranked[i].Text < ranked[j].Text
+	})
+
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
@@ -0,0 +1,181 @@
+package trash
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"generatio-pb/internal/repository"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Report summarises a purge run
+type Report struct {
+	Purged int `json:"purged"`
+	Failed int `json:"failed"`
+}
+
+// Service keeps deleted images restorable for a retention window and then
+// deletes them permanently. Deleting an image sets images.deleted_at; the
+// record and its cached file stay until the window has passed or the owner
+// empties the trash.
+type Service struct {
+	app      core.App
+	folders  *repository.Folders
+	days     int
+	interval time.Duration
+
+	runMutex sync.Mutex
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// NewService creates a trash service that purges images deleted more than
+// days ago (0 keeps deleted images until the trash is emptied)
+func NewService(app core.App, days int, interval time.Duration) *Service {
+	if days < 0 {
+		days = 0
+	}
+	if interval <= 0 {
+		interval = 1 * time.Hour
+	}
+
+	return &Service{
+		app:      app,
+		folders:  repository.NewFolders(app),
+		days:     days,
+		interval: interval,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start begins the background purge loop
+func (s *Service) Start() {
+	go s.run()
+	log.Printf("Trash service started with interval: %v", s.interval)
+}
+
+// Stop stops the background purge loop
+func (s *Service) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+	})
+}
+
+func (s *Service) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			report, err := s.Run()
+			if err != nil {
+				log.Printf("Trash purge failed: %v", err)
+			} else if report.Purged+report.Failed > 0 {
+				log.Printf("Trash purge completed: %d purged, %d failed", report.Purged, report.Failed)
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// Days returns how long deleted images stay in the trash (0 means until emptied)
+func (s *Service) Days() int {
+	return s.days
+}
+
+// PurgeAt returns when a deleted image will be purged, or nil if it stays
+// until the trash is emptied
+func (s *Service) PurgeAt(record *core.Record) *time.Time {
+	deletedAt := record.GetDateTime("deleted_at")
+	if s.days == 0 || deletedAt.IsZero() {
+		return nil
+	}
+	purgeAt := deletedAt.Time().AddDate(0, 0, s.days)
+	return &purgeAt
+}
+
+// Move puts an image in the trash
+func (s *Service) Move(record *core.Record) error {
+	record.Set("deleted_at", types.NowDateTime())
+	if err := s.app.Save(record); err != nil {
+		return fmt.Errorf("failed to move image to trash: %w", err)
+	}
+	return nil
+}
+
+// Restore takes an image out of the trash. An image whose folder has been
+// deleted since is restored to the library root.
+func (s *Service) Restore(record *core.Record) error {
+	if folderID := record.GetString("folder_id"); folderID != "" {
+		if _, err := s.folders.Get(folderID); err != nil {
+			record.Set("folder_id", "")
+		}
+	}
+
+	record.Set("deleted_at", nil)
+	if err := s.app.Save(record); err != nil {
+		return fmt.Errorf("failed to restore image: %w", err)
+	}
+	return nil
+}
+
+// Empty permanently deletes every image in a user's trash
+func (s *Service) Empty(userID string) (*Report, error) {
+	records, err := s.app.FindRecordsByFilter(
+		"images",
+		"user_id = {:user_id} && deleted_at != null",
+		"deleted_at",
+		0,
+		0,
+		map[string]any{"user_id": userID},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch trashed images: %w", err)
+	}
+	return s.purge(records), nil
+}
+
+// Run permanently deletes images that have been in the trash for longer
+// than the retention window
+func (s *Service) Run() (*Report, error) {
+	s.runMutex.Lock()
+	defer s.runMutex.Unlock()
+
+	if s.days == 0 {
+		return &Report{}, nil
+	}
+
+	cutoff := time.Now().UTC().AddDate(0, 0, -s.days)
+	records, err := s.app.FindRecordsByFilter(
+		"images",
+		"deleted_at != null && deleted_at < {:cutoff}",
+		"deleted_at",
+		0,
+		0,
+		map[string]any{"cutoff": cutoff.Format(types.DefaultDateLayout)},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch expired trash: %w", err)
+	}
+	return s.purge(records), nil
+}
+
+// purge deletes records; the image cache releases their files on delete
+func (s *Service) purge(records []*core.Record) *Report {
+	report := &Report{}
+	for _, record := range records {
+		if err := s.app.Delete(record); err != nil {
+			s.app.Logger().Error("Failed to purge trashed image", "error", err, "image_id", record.Id)
+			report.Failed++
+			continue
+		}
+		report.Purged++
+	}
+	return report
+}
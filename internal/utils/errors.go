@@ -43,11 +43,9 @@ func NewRateLimitError() error {
 	return apis.NewTooManyRequestsError("rate limit exceeded", nil)
 }
 
-// APIResponse creates a standardized API response
-func APIResponse(data interface{}) map[string]interface{} {
-	return map[string]interface{}{
-		"data": data,
-	}
+// APIResponse wraps data in the response envelope
+func APIResponse(data interface{}) models.Response {
+	return models.Response{Data: data}
 }
 
 // ErrorResponse creates a standardized error response
@@ -7,6 +7,7 @@ import (
 	"strings"
 	"unicode/utf8"
 
+	"generatio-pb/internal/contentfilter"
 	"generatio-pb/internal/fal"
 	"generatio-pb/internal/models"
 )
@@ -22,9 +23,10 @@ func ValidatePrompt(prompt string) error {
 		return NewValidationError("prompt cannot exceed 1000 characters")
 	}
 
-	// Check for potentially harmful content
-	if containsHarmfulContent(prompt) {
-		return NewValidationError("prompt contains inappropriate content")
+	// Check against the built-in content policy; handlers use the
+	// deployment's configured filter instead
+	if decision := contentfilter.Default().Evaluate(prompt); !decision.Allowed {
+		return NewValidationError(decision.Reason())
 	}
 
 	return nil
@@ -221,39 +223,6 @@ func ValidatePagination(page, limit int) error {
 	return nil
 }
 
-// containsHarmfulContent checks for potentially harmful content in prompts
-func containsHarmfulContent(prompt string) bool {
-	// Convert to lowercase for case-insensitive matching
-	lower := strings.ToLower(prompt)
-
-	// List of potentially harmful keywords/patterns
-	harmfulPatterns := []string{
-		// Violence and harm
-		"violence", "kill", "murder", "death", "suicide", "self-harm",
-		"torture", "abuse", "assault", "weapon", "bomb", "explosive",
-		
-		// Adult content
-		"nude", "naked", "sex", "porn", "erotic", "adult", "nsfw",
-		
-		// Hate speech
-		"hate", "racist", "nazi", "terrorist", "extremist",
-		
-		// Personal information
-		"ssn", "social security", "credit card", "password", "private key",
-		
-		// Illegal activities
-		"drug", "illegal", "piracy", "fraud", "scam", "hack",
-	}
-
-	for _, pattern := range harmfulPatterns {
-		if strings.Contains(lower, pattern) {
-			return true
-		}
-	}
-
-	return false
-}
-
 // SanitizeInput sanitizes general string input
 func SanitizeInput(input string) string {
 	// Remove null bytes
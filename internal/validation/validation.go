@@ -0,0 +1,169 @@
+package validation
+
+import (
+	"fmt"
+	"net/mail"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Errors maps JSON field names to what is wrong with them
+type Errors map[string]string
+
+// Error lists the field errors in field order
+func (e Errors) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	messages := make([]string, 0, len(fields))
+	for _, field := range fields {
+		messages = append(messages, field+" "+e[field])
+	}
+	return strings.Join(messages, "; ")
+}
+
+// Struct checks the validate tags on v's fields and returns Errors naming
+// every failing field, or nil. Supported rules:
+//
+//	required  strings must not be blank, slices, maps and pointers not nil, numbers not zero
+//	min=N     strings need N characters, slices and maps N elements, numbers a value of N
+//	max=N     the upper bound counterpart of min
+//	email     strings must be a bare email address
+//
+// Embedded structs are checked as part of v; other nested structs are not.
+func Struct(v interface{}) error {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer {
+		if value.IsNil() {
+			return nil
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return nil
+	}
+
+	errs := Errors{}
+	check(value, errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func check(value reflect.Value, errs Errors) {
+	t := value.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			check(value.Field(i), errs)
+			continue
+		}
+
+		rules := field.Tag.Get("validate")
+		if rules == "" || !field.IsExported() {
+			continue
+		}
+		if message := checkRules(value.Field(i), rules); message != "" {
+			errs[jsonName(field)] = message
+		}
+	}
+}
+
+// checkRules returns the message for the first rule the value breaks
+func checkRules(value reflect.Value, rules string) string {
+	required := false
+	for _, rule := range strings.Split(rules, ",") {
+		if rule == "required" {
+			required = true
+		}
+	}
+	if isEmpty(value) {
+		if required {
+			return "is required"
+		}
+		// Optional fields are only checked when they are set
+		return ""
+	}
+
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(rule, "=")
+		switch name {
+		case "min", "max":
+			limit, err := strconv.ParseFloat(arg, 64)
+			if err != nil {
+				panic(fmt.Sprintf("validation: invalid %s rule %q", name, rule))
+			}
+			if message := checkBound(value, name, limit); message != "" {
+				return message
+			}
+		case "email":
+			address, err := mail.ParseAddress(value.String())
+			if err != nil || address.Address != value.String() {
+				return "must be a valid email address"
+			}
+		}
+	}
+	return ""
+}
+
+// checkBound checks a min or max rule against a value's length or size
+func checkBound(value reflect.Value, rule string, limit float64) string {
+	var size float64
+	var unit string
+	switch value.Kind() {
+	case reflect.String:
+		size, unit = float64(utf8.RuneCountInString(value.String())), "characters"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		size, unit = float64(value.Len()), "items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		size = float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		size = float64(value.Uint())
+	case reflect.Float32, reflect.Float64:
+		size = value.Float()
+	default:
+		return ""
+	}
+
+	bound := strconv.FormatFloat(limit, 'f', -1, 64)
+	if limit == 1 {
+		unit = strings.TrimSuffix(unit, "s")
+	}
+	switch {
+	case rule == "min" && size < limit && unit != "":
+		return fmt.Sprintf("must have at least %s %s", bound, unit)
+	case rule == "min" && size < limit:
+		return "must be at least " + bound
+	case rule == "max" && size > limit && unit != "":
+		return fmt.Sprintf("must have at most %s %s", bound, unit)
+	case rule == "max" && size > limit:
+		return "must be at most " + bound
+	}
+	return ""
+}
+
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String:
+		return strings.TrimSpace(value.String()) == ""
+	case reflect.Slice, reflect.Map, reflect.Pointer, reflect.Interface:
+		return value.IsNil()
+	}
+	return value.IsZero()
+}
+
+// jsonName returns the name a field has in JSON request bodies
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
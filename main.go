@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"generatio-pb/internal/auth"
+	"generatio-pb/internal/batches"
+	"generatio-pb/internal/config"
 	"generatio-pb/internal/crypto"
 	"generatio-pb/internal/fal"
 	"generatio-pb/internal/handlers"
@@ -21,18 +23,37 @@ func main() {
 	// Initialize services
 	log.Println("Initializing Generatio PocketBase extension...")
 
+	// Load deployment configuration from GENERATIO_* environment variables
+	cfg := config.Load()
+	log.Println("✓ Configuration loaded")
+
 	// Create encryption service
 	encService := crypto.NewEncryptionService(100000) // 100k PBKDF2 iterations
+	encService.SetWorkers(cfg.KDFWorkers)
 	log.Println("✓ Encryption service initialized")
 
 	// Create session store with 24-hour timeout
 	sessionStore := auth.NewSessionStore(24 * time.Hour)
-	log.Println("✓ Session store initialized")
+	sessionStore.SetMaxSessions(cfg.MaxSessions)
+	log.Printf("✓ Session store initialized (capacity: %d)", cfg.MaxSessions)
 
 	// Create FAL AI client
-	falClient := fal.NewClient("https://queue.fal.run")
+	falClient := fal.NewClient(cfg.FALBaseURL)
 	falClient.SetTimeout(10 * time.Minute) // 10-minute generation timeout
-	log.Println("✓ FAL AI client initialized")
+	if err := falClient.SetProxy(cfg.FALProxyURL); err != nil {
+		log.Fatalf("Invalid GENERATIO_FAL_PROXY: %v", err)
+	}
+	if err := falClient.SetTLS(cfg.FALCAFile, cfg.FALClientCertFile, cfg.FALClientKeyFile); err != nil {
+		log.Fatalf("Invalid FAL AI TLS configuration: %v", err)
+	}
+	if proxy := falClient.Proxy(); proxy != "" {
+		log.Printf("✓ FAL AI client initialized (%s via proxy %s)", cfg.FALBaseURL, proxy)
+	} else {
+		log.Printf("✓ FAL AI client initialized (%s)", cfg.FALBaseURL)
+	}
+	if cfg.FakeFAL {
+		log.Println("✓ Fake FAL mode enabled: FAL AI is not called and generations return placeholder images")
+	}
 
 	// Create cleanup service
 	cleanupService := auth.NewCleanupService(sessionStore, 1*time.Hour)
@@ -64,30 +85,173 @@ func main() {
 		log.Println("   - generatio_users (auth collection)")
 		log.Println("   - images (for generated images)")
 		log.Println("   - folders (for collections/organization)")
-		log.Println("   - model_preferences (for user preferences)")
+		log.Println("   - model_preferences (user_id, model_name, preferences (json)) with a unique (user_id, model_name) index where user_id != '' - for user preferences")
+		log.Println("   - notification_outbox (queued email/webhook notifications and CDN purges with retry state, images (json) thumbnails, data (json) hook payload)")
+		log.Println("   - chat_webhooks (user_id, channel: discord/slack, url, events (json), created autodate)")
+		log.Println("   - image_embeddings (image_id, user_id, model, vector (json), created autodate) - CLIP vectors for similarity search")
+		log.Println("   - gallery_feeds (user_id, folder_id (empty for the gallery), title, token, created autodate)")
+		log.Println("   - hook_subscriptions (user_id, event, target_url, secret, enabled (bool), failures (number), last_error, disabled_reason, last_delivery_at (date), created autodate)")
+		log.Println("   - content_filter_terms (term, kind: block/allow, severity: low/medium/high)")
+		log.Println("   - organizations (name, owner_id) and organization_members (org_id, user_id, role: owner/admin/member/viewer)")
+		log.Println("   - folder_permissions (folder_id, user_id, role: editor/viewer)")
+		log.Println("   - invitations (kind: folder/org, target_id, email, role, inviter_id, token_hash, status: pending/accepted/revoked, expires_at, accepted_by, accepted_at)")
+		log.Println("   - community_prompts (user_id, title, prompt, model, parameters, example_ids, likes, uses, reports, hidden)")
+		log.Println("   - community_prompt_likes (prompt_id, user_id), community_prompt_reports (prompt_id, user_id, reason)")
+		log.Println("   - pipeline_runs (user_id, org_id, status: queued/running/succeeded/failed/cancelled, steps (json), template (json), priority: interactive/batch, total_cost, error, finished_at)")
+		log.Println("   - generation_batches (user_id, org_id, file_name, rows, run_ids (json), rejected (json), created autodate)")
+		log.Println("   - pipeline_templates (user_id, org_id, name, description, version, steps (json), variables (json))")
+		log.Println("   - smart_folders (user_id, name, filter (json), created/updated autodate)")
+		log.Println("   - pipeline_template_versions (template_id, version, user_id, steps (json), variables (json))")
+		log.Println("   - custom_models (user_id, name, display_name, description, endpoint, cost_per_image, parameters (json))")
+		log.Println("   - model_aliases (user_id, name, model, parameters (json))")
+		log.Println("   - generation_jobs (user_id, model, duration_ms (number), success (bool), cost (number), prompt, parameters (json), error_code, error_message, retryable (bool), request_id, retried_at (date), created autodate) - per-model statistics and failed generations")
+		log.Println("   - spending_anomalies (user_id, spent (number), daily_average (number), created autodate)")
+		log.Println("   - user_budgets (user_id, monthly_budget (number), daily_images (number), credit (number), credit_month, quota_reset_at (date))")
+		log.Println("   - audit_log (actor_id, action, target_id, reason, details (json), created autodate)")
+		log.Println("   - api_keys (user_id, name, key_hash, prefix, scopes: images:read/generate:write/financial:read/hooks:write, last_used_at)")
+		log.Println("   - trusted_devices (user_id, name, device_hash, token_hash, previous_token_hash, encrypted_token, salt, expires_at (date), last_used_at (date))")
 		log.Println("2. generatio_users collection should have:")
 		log.Println("   - fal_token (text) - for encrypted FAL AI token")
-		log.Println("   - financial_data (json) - for spending tracking & salt storage")
+		log.Println("   - fal_token_testing (text) - encrypted testing key; its generations are left out of financial stats")
+		log.Println("   - financial_data (json) - for spending tracking & salt storage; total_spent_micros is the exact total in micro-dollars")
+		log.Println("   - completion_email (select: off, long_running, always) - generation completion emails")
+		log.Println("   - retention_days (number) - image retention override (0 = deployment default, negative = keep forever)")
+		log.Println("   - display_currency (text) - ISO 4217 code financial endpoints convert USD costs to")
+		log.Println("   - tier (text) - plan name; GENERATIO_TIER_PRIORITIES caps its request priority")
+		log.Println("   - remember_device_days (number) - how long devices may stay remembered (0 = off, capped by GENERATIO_REMEMBER_DEVICE_MAX_DAYS)")
+		log.Println("   - fal_key_labels (json) - names given to FAL keys in per-key usage statistics")
+		log.Println("   - key_health_checks (bool) - opt in to periodic FAL key validation; key_health (json) - last verdict")
+		log.Println("3. images collection should have:")
+		log.Println("   - moderation_status (text) - approved, quarantined or overridden when moderation is enabled")
+		log.Println("   - favorite (bool) - favorited images are exempt from retention")
+		log.Println("   - archived_at (date) - set when retention archives an image")
+		log.Println("   - deleted_at (date) - set when an image moves to the trash (also on folders)")
+		log.Println("   - content_hash (text), content_size (number) - SHA-256 of the cached file for deduplication")
+		log.Println("   - group_id (text) - links images of one comparison, sweep or pipeline run")
+		log.Println("   - parent_id (text) - image this one was derived from")
+		log.Println("   - position (number) - manual order within the folder")
+		log.Println("   - tags (json) - array of tags smart collections can filter on")
+		log.Println("   - cache_key (text) - fingerprint of the seeded request that produced the image, for the result cache")
+		log.Println("   - caption (text) - model-written description prompt searches also match (GENERATIO_CAPTION_PROVIDER)")
+		log.Println("   - org_id (text) - organization library the image belongs to (also on folders)")
 		log.Println("")
 		log.Println("🔧 API Endpoints will be available at:")
 		log.Println("   POST /api/custom/tokens/setup")
 		log.Println("   POST /api/custom/tokens/verify")
-		log.Println("   POST /api/custom/auth/create-session")
-		log.Println("   DELETE /api/custom/auth/session")
+		log.Println("   DELETE /api/custom/tokens/testing (send X-FAL-Environment: testing to generate with the testing key)")
+		log.Println("   POST /api/custom/auth/create-session (keys are derived in parallel and cached for the session)")
+		if cfg.VerificationCacheTTL > 0 {
+			log.Printf("   (keys derived by a token verify are reused for %s, e.g. by the create-session that follows)", cfg.VerificationCacheTTL)
+		}
+		log.Println("   GET/DELETE /api/custom/auth/session")
+		log.Println("   POST /api/custom/auth/refresh, GET /api/custom/auth/devices")
+		log.Println("   PUT /api/custom/auth/devices/settings, DELETE /api/custom/auth/devices/{id}")
 		log.Println("   GET /api/custom/auth/token-status")
-		log.Println("   POST /api/custom/generate/image")
+		log.Println("   GET /api/custom/auth/key-health, PUT /api/custom/auth/key-health/settings")
+		log.Println("   POST /api/custom/generate/image (dry_run validates, prices and shows merged parameters without calling FAL AI; partial results charge only delivered images)")
+		log.Printf("   (identical requests within %s: %s; allow_duplicate skips the check)", cfg.DuplicateWindow, cfg.DuplicateAction)
+		if cfg.CoalesceSeeded {
+			log.Println("   (identical seeded requests in flight at once are submitted once and shared)")
+		}
+		if cfg.ResultCacheTTL > 0 {
+			log.Printf("   (repeats of a seeded request within %s return its stored images; no_cache skips the cache)", cfg.ResultCacheTTL)
+		}
 		log.Println("   GET /api/custom/generate/models")
+		log.Println("   GET /api/custom/stats/models")
+		log.Println("   POST /api/custom/content-filter/check")
+		log.Println("   POST /api/custom/generate/compare, GET /api/custom/generate/compare/{id}")
+		log.Println("   POST /api/custom/generate/sweep")
+		log.Println("   GET  /api/custom/generate/failures")
+		log.Println("   POST /api/custom/generate/failures/{id}/retry")
+		log.Println("   GET/POST /api/custom/pipelines (?page=&limit= or ?cursor=), GET /api/custom/pipelines/{id}")
+		log.Println("   POST /api/custom/pipelines/{id}/cancel")
+		log.Printf("   POST /api/custom/batches/import (multipart CSV: prompt, model, folder_id, parameters; up to %d rows)", batches.MaxRows)
+		log.Println("   GET /api/custom/batches/{id}, GET /api/custom/batches/{id}/errors (CSV of rejected rows)")
+		log.Println("   GET/POST /api/custom/pipelines/templates, GET/PUT/DELETE /api/custom/pipelines/templates/{id}")
+		log.Println("   GET /api/custom/pipelines/templates/{id}/versions, POST /api/custom/pipelines/templates/{id}/run")
+		log.Println("   GET/POST /api/custom/models, GET/PUT/DELETE /api/custom/models/{id}")
+		log.Println("   GET /api/custom/models/aliases, PUT/DELETE /api/custom/models/aliases/{name}")
 		log.Println("   GET /api/custom/financial/stats")
-		log.Println("   POST /api/custom/preferences/get")
+		log.Println("   GET/PUT /api/custom/financial/currency")
+		log.Println("   GET /api/custom/financial/anomalies")
+		log.Println("   GET /api/custom/financial/keys (?days=), PUT /api/custom/financial/keys/{key_id}")
+		log.Println("   GET /api/custom/limits")
+		log.Println("   GET/DELETE /api/custom/debug/errors")
+		log.Println("   GET/POST /api/custom/orgs, GET/POST /api/custom/orgs/{id}/members")
+		log.Println("   DELETE /api/custom/orgs/{id}/members/{user_id}, GET /api/custom/orgs/{id}/spending")
+		log.Println("   (send X-Org-ID to list and create in an organization library)")
+		log.Println("   GET/POST /api/custom/invitations, GET /api/custom/invitations/pending")
+		log.Println("   POST /api/custom/invitations/accept, DELETE /api/custom/invitations/{id}")
+		log.Println("   GET/POST /api/custom/community/prompts, GET/DELETE /api/custom/community/prompts/{id}")
+		log.Println("   POST/DELETE /api/custom/community/prompts/{id}/like, POST /api/custom/community/prompts/{id}/report")
+		log.Println("   POST /api/custom/community/prompts/{id}/generate, GET /api/custom/community/prompts/{id}/examples/{image_id}")
+		log.Println("   GET /api/custom/prompts/suggest?q=")
+		log.Println("   GET/POST /api/custom/api-keys, DELETE /api/custom/api-keys/{id}")
+		log.Println("   (send X-API-Key: gpk_... to scoped routes instead of a user token)")
+		log.Println("   (listings accept ?fields=id,url,... to trim each item)")
+		log.Println("   GET /api/custom/preferences (every model, or ?model_name=), POST /api/custom/preferences/get")
 		log.Println("   POST /api/custom/preferences/save")
 		log.Println("   POST /api/custom/collections/create")
 		log.Println("   GET /api/custom/collections")
+		log.Println("   DELETE /api/custom/collections/{id} (owner only)")
+		log.Println("   PUT /api/custom/collections/{id}/move (owner only; parent_id, max 10 levels, no cycles)")
+		log.Println("   GET /api/custom/collections/{id}/images (?page=&limit= or ?cursor= from next_cursor, ?sort=recent|manual)")
+		log.Println("   PUT /api/custom/collections/{id}/order (editors; ordered image_ids for sort=manual)")
+		log.Println("   GET/POST /api/custom/smart-collections, GET/PUT/DELETE /api/custom/smart-collections/{id}")
+		log.Println("   GET /api/custom/smart-collections/{id}/images (saved filter on model, tag, prompt, favorite, days)")
+		log.Println("   POST /api/custom/collections/{id}/duplicate (deep copy; copy_files stores the image files)")
+		log.Println("   GET/POST /api/custom/collections/{id}/permissions, DELETE /api/custom/collections/{id}/permissions/{user_id}")
+		log.Println("   GET/POST /api/custom/notifications/webhooks, DELETE /api/custom/notifications/webhooks/{id}")
+		log.Println("   POST /api/custom/notifications/webhooks/{id}/test (Discord/Slack: job.completed, budget.alert)")
+		log.Println("   GET/POST /api/custom/hooks, GET/PATCH/DELETE /api/custom/hooks/{id} (signed REST hooks: image.created, job.failed, budget.exceeded)")
+		log.Println("   GET /api/custom/activity (?types=generation,pipeline,folder.created,folder.shared,alert,account, ?cursor= from next_cursor)")
+		log.Println("   GET /api/custom/images/quarantine")
+		log.Println("   POST /api/custom/images/{id}/override")
+		log.Println("   GET /api/custom/images/{id}/file (?size=&format= for resized variants)")
+		log.Println("   POST /api/custom/images/import")
+		log.Println("   GET /api/custom/images/export (JSONL of prompt/model/seed/parameters; ?smart_collection= or ?model=&tag=&prompt=&favorite=&days=)")
+		log.Println("   POST /api/custom/images/{id}/share")
+		log.Println("   POST /api/custom/images/{id}/edit, /regenerate, /variation, /outpaint")
+		log.Println("   GET /api/custom/images/{id}/lineage")
+		log.Println("   POST /api/custom/images/{id}/caption")
+		log.Println("   GET /api/custom/images/{id}/similar, GET /api/custom/images/search?q= (CLIP similarity search, GENERATIO_EMBEDDING_PROVIDER=fal)")
+		log.Printf("   DELETE /api/custom/images/{id}, GET/DELETE /api/custom/images/trash (purged after %d days, 0 = never)", cfg.TrashDays)
+		log.Println("   POST /api/custom/images/{id}/restore")
+		log.Println("   GET /api/custom/shared/{id}?expires=&sig= (public, signed)")
+		log.Println("   GET /api/custom/shared/pages/{id}?expires=&sig= (HTML with OpenGraph tags for link previews)")
+		log.Println("   GET/POST /api/custom/feeds, DELETE /api/custom/feeds/{id} (publish the gallery or a folder)")
+		log.Println("   GET /api/custom/shared/feeds/{token} (public RSS, ?format=atom), GET /api/custom/shared/feeds/{token}/images/{image_id}")
+		log.Println("   GET /api/custom/shared/feeds/{token}/widget (public JSON for embedded galleries, CORS-enabled)")
+		log.Println("   GET/POST /api/custom/retention")
+		log.Println("   GET /api/custom/retention/preview")
+		log.Println("   GET/POST /api/custom/admin/maintenance (superuser)")
+		log.Println("   POST /api/custom/admin/connectivity/check (superuser) - re-probe FAL AI to leave degraded mode")
+		log.Println("   GET /api/custom/health - \"degraded\" while FAL AI is unreachable at startup or in probes")
+		log.Println("   GET /api/custom/admin/notifications/dead (superuser)")
+		log.Println("   POST /api/custom/admin/notifications/{id}/retry (superuser)")
+		log.Println("   GET/POST /api/custom/admin/content-filter/terms (superuser)")
+		log.Println("   DELETE /api/custom/admin/content-filter/terms/{id} (superuser)")
+		log.Println("   POST /api/custom/admin/retention/run (superuser)")
+		log.Println("   POST /api/custom/admin/trash/purge (superuser)")
+		log.Println("   GET /api/custom/admin/storage/report (superuser)")
+		log.Println("   GET /api/custom/admin/sessions (superuser) - session counts, capacity and evictions")
+		log.Println("   POST /api/custom/admin/crypto/benchmark (superuser) - time PBKDF2 settings on this machine")
+		log.Println("   GET /api/custom/admin/reconciliation, POST /api/custom/admin/reconciliation/run (superuser)")
+		log.Println("   POST /api/custom/admin/anomalies/run (superuser)")
+		log.Println("   POST /api/custom/admin/key-health/run (superuser)")
+		log.Println("   GET/PUT /api/custom/admin/users/{id}/budget, POST /api/custom/admin/users/{id}/credits (superuser)")
+		log.Println("   POST /api/custom/admin/users/{id}/quota/reset, GET /api/custom/admin/audit (superuser)")
 		log.Println("   (Note: Status endpoint removed to avoid conflicts)")
 		log.Println("")
 		log.Println("🔄 Session Management:")
 		log.Println("   ✓ Use standard PocketBase auth endpoints for authentication")
 		log.Println("   ✓ Check token-status endpoint to determine session needs")
 		log.Println("   ✓ Manual session creation via create-session endpoint")
+		log.Println("   ✓ Session expiry and last use via GET auth/session, for renewing in time")
+		log.Println("   ✓ GENERATIO_SESSION_RENEWAL=true extends sessions on each successful generation")
+		log.Println("   ✓ Opt-in remembered devices recreate sessions with rotating, device-bound refresh tokens")
+		log.Println("   ✓ GENERATIO_SESSION_COOKIE=true also carries session IDs in a Secure, HttpOnly, SameSite cookie")
+		log.Println("   ✓ Cookie sessions need the generatio_csrf cookie echoed in X-CSRF-Token on POST/PUT/PATCH/DELETE")
 		log.Println("   ✓ Secure separation of auth and session management")
 		log.Println("")
 		log.Println("🔧 Route Debugging & Testing:")
@@ -100,8 +264,11 @@ func main() {
 		// Serve static files from the provided public dir (if exists) - register BEFORE custom routes
 		se.Router.GET("/static/{path...}", apis.Static(os.DirFS("./pb_public"), false))
 
+		// FAL AI requests are logged to the app log, with tokens masked
+		falClient.SetLogger(app.Logger())
+
 		// Register production API routes
-		handlers.RegisterRoutes(se, app, sessionStore, encService, falClient)
+		handlers.RegisterRoutes(se, app, sessionStore, encService, falClient, cfg)
 		log.Println("✓ API routes registered")
 
 		return se.Next()
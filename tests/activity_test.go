@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/activity"
+	"generatio-pb/internal/notify"
+	"generatio-pb/internal/pagination"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// createActivityRecord saves a record created the given number of hours ago
+func createActivityRecord(t testing.TB, env *testEnv, collection, id string, hoursAgo int, fields map[string]any) {
+	t.Helper()
+
+	coll, err := env.app.FindCollectionByNameOrId(collection)
+	require.NoError(t, err)
+	created, err := types.ParseDateTime(time.Now().Add(-time.Duration(hoursAgo) * time.Hour))
+	require.NoError(t, err)
+
+	record := core.NewRecord(coll)
+	record.Id = id
+	record.SetRaw("created", created)
+	for key, value := range fields {
+		record.Set(key, value)
+	}
+	require.NoError(t, env.app.Save(record))
+}
+
+// seedActivity gives the seeded user one event of each type, an hour apart
+// with the generation newest, plus records that must not show up
+func seedActivity(t testing.TB, env *testEnv) {
+	other := env.createUser(t, "activitystrange", "activity-stranger@test.com")
+
+	createActivityRecord(t, env, "generation_jobs", "activitygen0001", 1, map[string]any{"user_id": env.user.Id, "model": "fal-ai/flux/dev", "success": true, "duration_ms": 1200})
+	createActivityRecord(t, env, "pipeline_runs", "activitypipe001", 2, map[string]any{"user_id": env.user.Id, "status": "completed"})
+	createActivityRecord(t, env, "folders", "activityfold001", 3, map[string]any{"user_id": env.user.Id, "name": "Moodboard"})
+	createActivityRecord(t, env, "folder_permissions", "activityshare01", 4, map[string]any{"user_id": env.user.Id, "folder_id": "otherfolder0001", "role": "viewer"})
+	createActivityRecord(t, env, notify.OutboxCollection, "activityalert01", 5, map[string]any{"user_id": env.user.Id, "channel": notify.ChannelEmail, "target": "test@example.com", "event": notify.EventBudgetAlert, "subject": "80% of your budget used", "status": "sent"})
+	createActivityRecord(t, env, "audit_log", "activityaudit01", 6, map[string]any{"actor_id": "admin", "action": "budget.changed", "target_id": env.user.Id, "reason": "raise"})
+
+	// The same alert sent to a webhook, another user's generation and an
+	// unrelated notification stay out of the feed
+	createActivityRecord(t, env, notify.OutboxCollection, "activityhook001", 5, map[string]any{"user_id": env.user.Id, "channel": notify.ChannelWebhook, "target": "https://hooks.test", "event": notify.EventBudgetAlert, "status": "sent"})
+	createActivityRecord(t, env, "generation_jobs", "activityother01", 1, map[string]any{"user_id": other.Id, "model": "fal-ai/flux/dev", "success": true})
+	createActivityRecord(t, env, notify.OutboxCollection, "activitymail001", 7, map[string]any{"user_id": env.user.Id, "channel": notify.ChannelEmail, "target": "test@example.com", "event": "invite", "status": "sent"})
+}
+
+func TestActivityFeed(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+	seedActivity(t, env)
+	feed := activity.NewFeed(env.app)
+
+	ids := func(events []activity.Event) []string {
+		result := make([]string, 0, len(events))
+		for _, event := range events {
+			result = append(result, event.ID)
+		}
+		return result
+	}
+
+	t.Run("MergesSourcesNewestFirst", func(t *testing.T) {
+		events, next, err := feed.List(env.user.Id, nil, pagination.Page{Number: 1, Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"activitygen0001", "activitypipe001", "activityfold001", "activityshare01", "activityalert01", "activityaudit01"}, ids(events))
+		assert.Empty(t, next)
+
+		assert.Equal(t, activity.TypeGeneration, events[0].Type)
+		assert.Equal(t, "Generated images with fal-ai/flux/dev", events[0].Title)
+		assert.Equal(t, activity.TypeAlert, events[4].Type)
+		assert.Equal(t, "80% of your budget used", events[4].Title)
+	})
+
+	t.Run("PagesByCursor", func(t *testing.T) {
+		var seen []string
+		page := pagination.Page{Number: 1, Limit: 4}
+		for {
+			events, next, err := feed.List(env.user.Id, nil, page)
+			require.NoError(t, err)
+			seen = append(seen, ids(events)...)
+			if next == "" {
+				break
+			}
+			page.Cursor, err = pagination.DecodeCursor(next)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, []string{"activitygen0001", "activitypipe001", "activityfold001", "activityshare01", "activityalert01", "activityaudit01"}, seen)
+	})
+
+	t.Run("FiltersTypes", func(t *testing.T) {
+		events, _, err := feed.List(env.user.Id, []string{activity.TypeAlert, activity.TypeAccount}, pagination.Page{Number: 1, Limit: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"activityalert01", "activityaudit01"}, ids(events))
+	})
+}
+
+func TestActivityRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "the feed lists the user's events",
+			method:             http.MethodGet,
+			url:                "/api/custom/activity?fields=id,type",
+			setup:              seedActivity,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`{"id":"activitygen0001","type":"generation"}`, `{"id":"activityshare01","type":"folder.shared"}`, `"next_cursor":""`},
+			notExpectedContent: []string{"activityother01", "activityhook001", "activitymail001"},
+		},
+		{
+			name:               "types narrow the feed",
+			method:             http.MethodGet,
+			url:                "/api/custom/activity?types=folder.created,pipeline",
+			setup:              seedActivity,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"id":"activitypipe001"`, `"title":"Created folder Moodboard"`},
+			notExpectedContent: []string{"activitygen0001", "activityalert01"},
+		},
+		{
+			name:            "unknown types are rejected",
+			method:          http.MethodGet,
+			url:             "/api/custom/activity?types=likes",
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"Unknown event type likes"},
+		},
+		{
+			name:            "the feed pages by cursor only",
+			method:          http.MethodGet,
+			url:             "/api/custom/activity?page=2",
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"The activity feed pages by cursor"},
+		},
+		{
+			name:            "authentication is required",
+			method:          http.MethodGet,
+			url:             "/api/custom/activity",
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{"Authentication required"},
+		},
+	})
+}
@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/anomaly"
+	"generatio-pb/internal/money"
+	"generatio-pb/internal/notify"
+
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withSpendingSpike gives the seeded user a $3 baseline over the last month
+// ($0.10 a day) and $6 of spending today, plus a trashed $2 image that is
+// left out
+func withSpendingSpike(t testing.TB, env *testEnv) {
+	old, err := types.ParseDateTime(time.Now().AddDate(0, 0, -10))
+	require.NoError(t, err)
+
+	env.createImage(t, map[string]any{"other_info": map[string]any{"cost_usd": 3.0}, "created": old})
+	env.createImage(t, map[string]any{"other_info": map[string]any{"cost_usd": 6.0}})
+	env.createImage(t, map[string]any{"other_info": map[string]any{"cost_usd": 2.0}, "deleted_at": "2026-01-01 00:00:00.000Z"})
+}
+
+func TestAnomalyDetector(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+	withSpendingSpike(t, env)
+
+	notifier := notify.NewService(env.app)
+	ctx := context.Background()
+
+	t.Run("SpendingBelowTheMinimumIsIgnored", func(t *testing.T) {
+		report, err := anomaly.NewDetector(env.app, notifier, 5, 10, 0).Run(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.UsersScanned)
+		assert.Zero(t, report.Flagged)
+	})
+
+	t.Run("SpendingWithinTheFactorIsIgnored", func(t *testing.T) {
+		report, err := anomaly.NewDetector(env.app, notifier, 100, 1, 0).Run(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, report.Flagged)
+	})
+
+	detector := anomaly.NewDetector(env.app, notifier, 5, 5, 0)
+
+	t.Run("SpikesAreFlaggedAndNotified", func(t *testing.T) {
+		report, err := detector.Run(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Flagged)
+
+		anomalies, err := detector.List(env.user.Id, 10)
+		require.NoError(t, err)
+		require.Len(t, anomalies, 1)
+		assert.InDelta(t, 6.0, anomalies[0].Spent, 1e-9)
+		assert.InDelta(t, 0.1, anomalies[0].DailyAverage, 1e-9)
+		assert.Equal(t, money.FromUSD(6), anomalies[0].SpentMicros)
+		assert.Equal(t, money.FromUSD(0.1), anomalies[0].DailyAverageMicros)
+
+		messages, err := env.app.FindAllRecords(notify.OutboxCollection)
+		require.NoError(t, err)
+		require.Len(t, messages, 1)
+		assert.Equal(t, notify.EventSpendingAnomaly, messages[0].GetString("event"))
+		assert.Equal(t, env.user.Email(), messages[0].GetString("target"))
+		assert.Contains(t, messages[0].GetString("body"), "$6.00")
+	})
+
+	t.Run("UsersAreFlaggedOncePerDay", func(t *testing.T) {
+		report, err := detector.Run(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, report.Flagged)
+
+		count, err := env.app.CountRecords(notify.OutboxCollection)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, count)
+	})
+}
+
+func TestSpendingAnomalyRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "users without anomalies get an empty list",
+			method:          http.MethodGet,
+			url:             "/api/custom/financial/anomalies",
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"data":[]`},
+		},
+		{
+			name:            "anomaly checks require a superuser",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/anomalies/run",
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"Superuser access required"},
+		},
+		{
+			name:            "anomaly checks flag spikes",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/anomalies/run",
+			headers:         superuserOnly,
+			setup:           withSpendingSpike,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"users_scanned":1`, `"flagged":1`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				anomalies, err := env.handler.Anomalies().List(env.user.Id, 10)
+				require.NoError(t, err)
+				assert.Len(t, anomalies, 1)
+			},
+		},
+	})
+}
@@ -13,7 +13,6 @@ import (
 	"generatio-pb/internal/fal"
 	localmodels "generatio-pb/internal/models"
 
-	"github.com/pocketbase/pocketbase/tests"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -153,22 +152,6 @@ func TestAuthAndCrypto(t *testing.T) {
 	})
 }
 
-func TestHandlerUtilities(t *testing.T) {
-	// Test with minimal setup using test framework
-	app, _ := tests.NewTestApp()
-	defer app.ResetBootstrapState()
-
-	encService := crypto.NewEncryptionService(1000)
-	sessionStore := auth.NewSessionStore(time.Hour)
-	mockClient := fal.NewMockClient()
-
-	// Can't directly test handlers without proper PocketBase setup,
-	// but we can test that they can be created
-	assert.NotNil(t, encService)
-	assert.NotNil(t, sessionStore)
-	assert.NotNil(t, mockClient)
-}
-
 func TestAPIModels(t *testing.T) {
 	t.Run("SetupTokenRequest", func(t *testing.T) {
 		req := localmodels.SetupTokenRequest{
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/apikeys"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withAPIKey issues a key with the given scopes to the seeded user and
+// returns headers authenticating with it instead of a user token
+func withAPIKey(scopes ...string) func(t testing.TB, env *testEnv) map[string]string {
+	return func(t testing.TB, env *testEnv) map[string]string {
+		plaintext, _, err := apikeys.NewStore(env.app).Create(env.user.Id, "gallery", scopes)
+		require.NoError(t, err)
+		return map[string]string{"X-API-Key": plaintext}
+	}
+}
+
+func TestAPIKeyStore(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+
+	store := apikeys.NewStore(env.app)
+
+	plaintext, key, err := store.Create(env.user.Id, "gallery", []string{apikeys.ScopeImagesRead})
+	require.NoError(t, err)
+	assert.Contains(t, plaintext, apikeys.KeyPrefix)
+	assert.Equal(t, plaintext[:len(key.Prefix)], key.Prefix)
+
+	record, err := env.app.FindRecordById(apikeys.Collection, key.ID)
+	require.NoError(t, err)
+	assert.NotContains(t, record.GetString("key_hash"), plaintext[len(apikeys.KeyPrefix):], "plaintext must not be stored")
+
+	authed, err := store.Authenticate(plaintext)
+	require.NoError(t, err)
+	assert.True(t, authed.HasScope(apikeys.ScopeImagesRead))
+	assert.False(t, authed.HasScope(apikeys.ScopeGenerateWrite))
+	assert.NotNil(t, authed.LastUsedAt)
+
+	_, err = store.Authenticate(plaintext + "0")
+	assert.Error(t, err)
+
+	_, _, err = store.Create(env.user.Id, "bad", []string{"admin:write"})
+	assert.ErrorContains(t, err, "unknown scope")
+	_, _, err = store.Create(env.user.Id, "none", nil)
+	assert.ErrorContains(t, err, "at least one scope")
+
+	assert.Error(t, store.Revoke("someoneelse0001", key.ID))
+	require.NoError(t, store.Revoke(env.user.Id, key.ID))
+	_, err = store.Authenticate(plaintext)
+	assert.Error(t, err)
+}
+
+func TestAPIKeyRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "creates a key and returns the plaintext once",
+			method:          http.MethodPost,
+			url:             "/api/custom/api-keys",
+			body:            `{"name":"gallery","scopes":["images:read"]}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"key":"gpk_`, `"scopes":["images:read"]`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				keys, err := apikeys.NewStore(env.app).List(env.user.Id)
+				require.NoError(t, err)
+				assert.Len(t, keys, 1)
+			},
+		},
+		{
+			name:            "rejects unknown scopes",
+			method:          http.MethodPost,
+			url:             "/api/custom/api-keys",
+			body:            `{"name":"gallery","scopes":["admin"]}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`},
+		},
+		{
+			name:            "keys cannot manage keys",
+			method:          http.MethodPost,
+			url:             "/api/custom/api-keys",
+			body:            `{"name":"escalate","scopes":["generate:write"]}`,
+			headers:         withAPIKey(apikeys.ScopeImagesRead, apikeys.ScopeGenerateWrite, apikeys.ScopeFinancialRead),
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"code":"authentication_error"`},
+		},
+		{
+			name:            "read-only key can list collections",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections",
+			headers:         withAPIKey(apikeys.ScopeImagesRead),
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"data":[`},
+		},
+		{
+			name:            "read-only key cannot generate",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"prompt":"a castle","model":"fal-ai/flux/schnell"}`,
+			headers:         withAPIKey(apikeys.ScopeImagesRead),
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{`"message":"API key is missing the generate:write scope"`},
+		},
+		{
+			name:            "read-only key cannot see financial stats",
+			method:          http.MethodGet,
+			url:             "/api/custom/financial/stats",
+			headers:         withAPIKey(apikeys.ScopeImagesRead),
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{`"code":"authorization_error"`},
+		},
+		{
+			name:            "financial key can see financial stats",
+			method:          http.MethodGet,
+			url:             "/api/custom/financial/stats",
+			headers:         withAPIKey(apikeys.ScopeFinancialRead),
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"total_spent"`},
+		},
+		{
+			name:            "unknown keys are rejected",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections",
+			headers:         func(t testing.TB, env *testEnv) map[string]string { return map[string]string{"X-API-Key": "gpk_nope"} },
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"message":"Invalid API key"`},
+		},
+		{
+			name:            "revoking an unknown key",
+			method:          http.MethodDelete,
+			url:             "/api/custom/api-keys/missingapikey01",
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"message":"key not found"`},
+		},
+	})
+}
@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/availability"
+	"generatio-pb/internal/fal"
+	"generatio-pb/internal/fal/faltest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failProbes makes availability probes of modelID fail
+func failProbes(modelID string) func(ctx context.Context, id string) error {
+	return func(ctx context.Context, id string) error {
+		if id == modelID {
+			return errors.New("endpoint returned HTTP 503")
+		}
+		return nil
+	}
+}
+
+func TestFALClientProbe(t *testing.T) {
+	t.Run("RejectedProbesMeanTheEndpointIsUp", func(t *testing.T) {
+		client, server := newFakeFALClient(t, faltest.Scenario{})
+		require.NoError(t, client.ProbeModel(context.Background(), "flux/schnell"))
+		assert.Equal(t, 1, server.Counts().Probes)
+		assert.Zero(t, server.Counts().StatusChecks)
+	})
+
+	t.Run("ServerErrorsMeanTheEndpointIsDown", func(t *testing.T) {
+		client, _ := newFakeFALClient(t, faltest.Scenario{ProbeStatus: http.StatusServiceUnavailable})
+		err := client.ProbeModel(context.Background(), "flux/schnell")
+		assert.ErrorContains(t, err, "HTTP 503")
+	})
+}
+
+func TestAvailabilityMonitor(t *testing.T) {
+	client := fal.NewMockClient()
+	client.SetProbeModelFunc(failProbes("flux/schnell"))
+	monitor := availability.NewMonitor(client, 0)
+
+	_, probed := monitor.Status("flux/schnell")
+	assert.False(t, probed)
+
+	monitor.ProbeAll(context.Background())
+
+	down, probed := monitor.Status("flux/schnell")
+	require.True(t, probed)
+	assert.Equal(t, fal.AvailabilityDown, down.Status)
+	assert.Contains(t, down.Error, "HTTP 503")
+	assert.False(t, down.CheckedAt.IsZero())
+
+	for id := range client.GetModels() {
+		if id == "flux/schnell" {
+			continue
+		}
+		status, probed := monitor.Status(id)
+		require.True(t, probed, id)
+		assert.Equal(t, fal.AvailabilityUp, status.Status, id)
+		assert.Empty(t, status.Error, id)
+	}
+}
+
+func TestModelAvailabilityRoute(t *testing.T) {
+	// Background probes are disabled so only the scenario's probe is recorded
+	withoutProbes := func(t testing.TB, env *testEnv) {
+		env.cfg.ModelProbeInterval = 0
+		env.falClient.SetProbeModelFunc(failProbes("flux/schnell"))
+	}
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "models are listed without availability until probed",
+			method:             http.MethodGet,
+			url:                "/api/custom/generate/models",
+			headers:            authOnly,
+			setup:              withoutProbes,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"flux/schnell":{`},
+			notExpectedContent: []string{`"availability"`},
+		},
+		{
+			name:    "models carry their latest probe outcome",
+			method:  http.MethodGet,
+			url:     "/api/custom/generate/models",
+			headers: authOnly,
+			setup:   withoutProbes,
+			before: func(t testing.TB, env *testEnv) {
+				env.handler.Availability().ProbeAll(context.Background())
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"availability":{"status":"unavailable"`, `"error":"endpoint returned HTTP 503"`, `"availability":{"status":"available"`},
+		},
+	})
+}
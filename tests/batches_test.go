@@ -0,0 +1,212 @@
+package tests
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"generatio-pb/internal/batches"
+	"generatio-pb/internal/pipelines"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBatchCSV has two valid rows and one of each kind of rejection
+const testBatchCSV = `prompt,model,parameters
+a red fox,,"{""num_inference_steps"":4}"
+a blue whale,hidream/hidream-i1-fast,
+a terrorist attack,,
+,,
+a green frog,nope/model,
+a grey owl,,"{""num_inference_steps"":""many""}"
+`
+
+// testBatchID is the batch withBatch saves
+const testBatchID = "testbatch000001"
+
+// withBatch saves a batch with a finished run, a queued run and a rejected row
+func withBatch(t testing.TB, env *testEnv) {
+	runs, err := env.app.FindCollectionByNameOrId(pipelines.RunsCollection)
+	require.NoError(t, err)
+	for id, status := range map[string]string{"batchrunfinish1": pipelines.StatusSucceeded, "batchrunqueued1": pipelines.StatusQueued} {
+		record := core.NewRecord(runs)
+		record.Id = id
+		record.Set("user_id", env.user.Id)
+		record.Set("status", status)
+		require.NoError(t, env.app.Save(record))
+	}
+
+	collection, err := env.app.FindCollectionByNameOrId(batches.Collection)
+	require.NoError(t, err)
+	record := core.NewRecord(collection)
+	record.Id = testBatchID
+	record.Set("user_id", env.user.Id)
+	record.Set("file_name", "prompts.csv")
+	record.Set("rows", 3)
+	record.Set("run_ids", []string{"batchrunfinish1", "batchrunqueued1"})
+	record.Set("rejected", []batches.Rejection{{Row: 3, Prompt: "a terrorist attack, again", Error: "blocked"}})
+	require.NoError(t, env.app.Save(record))
+}
+
+// csvForm builds a multipart body with a CSV file and form fields
+func csvForm(t testing.TB, csv string, fields map[string]string) (string, string) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	part, err := writer.CreateFormFile("file", "prompts.csv")
+	require.NoError(t, err)
+	_, err = part.Write([]byte(csv))
+	require.NoError(t, err)
+	for key, value := range fields {
+		require.NoError(t, writer.WriteField(key, value))
+	}
+	require.NoError(t, writer.Close())
+
+	return body.String(), writer.FormDataContentType()
+}
+
+// withCSVSession sends a session and the multipart content type
+func withCSVSession(contentType string) func(t testing.TB, env *testEnv) map[string]string {
+	return func(t testing.TB, env *testEnv) map[string]string {
+		headers := env.sessionHeaders(t)
+		headers["Content-Type"] = contentType
+		return headers
+	}
+}
+
+func TestParseBatchCSV(t *testing.T) {
+	t.Run("ReadsRowsAndFlagsMalformedOnes", func(t *testing.T) {
+		rows, err := batches.Parse(strings.NewReader("\ufeffPrompt,folder_id,parameters\na fox,folder1,\"{\"\"seed\"\":1}\"\n,folder1,\na cat,,[1]\na dog\n"))
+		require.NoError(t, err)
+		require.Len(t, rows, 4)
+
+		assert.Equal(t, batches.Row{Line: 2, Prompt: "a fox", FolderID: "folder1", Parameters: map[string]interface{}{"seed": float64(1)}}, rows[0])
+		assert.Equal(t, "prompt is required", rows[1].Error)
+		assert.Equal(t, "parameters must be a JSON object", rows[2].Error)
+		assert.Equal(t, "expected 3 fields, found 1", rows[3].Error)
+		assert.Equal(t, 5, rows[3].Line)
+	})
+
+	cases := []struct {
+		name string
+		csv  string
+		err  string
+	}{
+		{"NeedsPromptColumn", "model\nflux/dev\n", "a prompt column is required"},
+		{"RejectsUnknownColumns", "prompt,style\na fox,noir\n", `unknown column "style"`},
+		{"NeedsRows", "prompt\n", "the file has no rows"},
+		{"CapsRows", "prompt\n" + strings.Repeat("a fox\n", batches.MaxRows+1), "more than 500 rows"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := batches.Parse(strings.NewReader(c.csv))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), c.err)
+		})
+	}
+}
+
+func TestBatchImportRoutes(t *testing.T) {
+	body, contentType := csvForm(t, testBatchCSV, map[string]string{"model": "flux/schnell"})
+	missingFolderBody, missingFolderType := csvForm(t, "prompt\na red fox\n", map[string]string{"model": "flux/schnell", "folder_id": "missingfolder01"})
+	unknownColumnBody, unknownColumnType := csvForm(t, "prompt,style\na fox,noir\n", nil)
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:           "valid rows are queued and the others rejected",
+			method:         http.MethodPost,
+			url:            "/api/custom/batches/import",
+			body:           body,
+			headers:        withCSVSession(contentType),
+			expectedStatus: http.StatusAccepted,
+			expectedContent: []string{
+				`"file_name":"prompts.csv"`, `"rows":6`,
+				`{"row":4,"prompt":"a terrorist attack","model":"flux/schnell","error":"prompt contains blocked terms: terrorist"}`,
+				`{"row":5,"prompt":"","model":"flux/schnell","error":"prompt is required"}`,
+				`{"row":6,"prompt":"a green frog","model":"nope/model","error":"unsupported model \"nope/model\""}`,
+				`{"row":7,"prompt":"a grey owl","model":"flux/schnell","error":"`,
+				`"error_report":"/api/custom/batches/`,
+			},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				runs, err := env.app.FindAllRecords(pipelines.RunsCollection)
+				require.NoError(t, err)
+				require.Len(t, runs, 2)
+
+				batchRecords, err := env.app.FindAllRecords(batches.Collection)
+				require.NoError(t, err)
+				require.Len(t, batchRecords, 1)
+				batch, err := batches.NewStore(env.app).Find(batchRecords[0].Id, env.user.Id)
+				require.NoError(t, err)
+				assert.Len(t, batch.RunIDs, 2)
+				assert.Len(t, batch.Rejected, 4)
+			},
+		},
+		{
+			name:   "imports require a session",
+			method: http.MethodPost,
+			url:    "/api/custom/batches/import",
+			body:   body,
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				headers := env.authHeaders()
+				headers["Content-Type"] = contentType
+				return headers
+			},
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"code":"authentication_error"`},
+		},
+		{
+			name:            "rows are checked against the target folder",
+			method:          http.MethodPost,
+			url:             "/api/custom/batches/import",
+			body:            missingFolderBody,
+			headers:         withCSVSession(missingFolderType),
+			expectedStatus:  http.StatusAccepted,
+			expectedContent: []string{`"run_ids":[]`, `"error":"folder not found"`},
+		},
+		{
+			name:            "unusable files are rejected as a whole",
+			method:          http.MethodPost,
+			url:             "/api/custom/batches/import",
+			body:            unknownColumnBody,
+			headers:         withCSVSession(unknownColumnType),
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`unknown column \"style\"`},
+		},
+		{
+			name:            "batches report their runs' progress",
+			method:          http.MethodGet,
+			url:             "/api/custom/batches/" + testBatchID,
+			setup:           withBatch,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"progress":{"queued":1,"succeeded":1}`, `"error_report":"/api/custom/batches/`},
+		},
+		{
+			name:            "the error report downloads as CSV",
+			method:          http.MethodGet,
+			url:             "/api/custom/batches/" + testBatchID + "/errors",
+			setup:           withBatch,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{"row,prompt,model,error\n3,\"a terrorist attack, again\",,blocked\n"},
+		},
+		{
+			name:   "other users' batches are hidden",
+			method: http.MethodGet,
+			url:    "/api/custom/batches/" + testBatchID,
+			setup:  withBatch,
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				other := env.createUser(t, "batchstranger01", "batch-stranger@test.com")
+				token, err := other.NewAuthToken()
+				require.NoError(t, err)
+				return map[string]string{"Authorization": token}
+			},
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"Batch not found"},
+		},
+	})
+}
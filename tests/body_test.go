@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// withMaxBodyBytes caps JSON request bodies at limit bytes
+func withMaxBodyBytes(limit int) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		env.cfg.MaxBodyBytes = limit
+	}
+}
+
+func TestRequestBodyDecoding(t *testing.T) {
+	uploadBody, uploadType := uploadForm(t, map[string][]byte{"large.png": bytes.Repeat([]byte{0}, 4096)}, nil)
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "unknown fields are named",
+			method:          http.MethodPut,
+			url:             "/api/custom/financial/currency",
+			body:            `{"currency":"USD","curency":"EUR"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`Unknown field \"curency\"`},
+		},
+		{
+			name:    "unknown fields are ignored when strict decoding is off",
+			method:  http.MethodPut,
+			url:     "/api/custom/financial/currency",
+			body:    `{"currency":"USD","curency":"EUR"}`,
+			headers: authOnly,
+			setup: func(t testing.TB, env *testEnv) {
+				env.cfg.StrictJSON = false
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"currency":"USD"`},
+		},
+		{
+			name:            "wrong types name the field and expected type",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":42,"prompt":"a castle"}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`Field \"model\" must be a string, not number`},
+		},
+		{
+			name:            "bodies must be objects",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `["a castle"]`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"Request body must be a JSON object"},
+		},
+		{
+			name:            "syntax errors report their position",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model" "flux"}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"Malformed JSON at byte 10"},
+		},
+		{
+			name:            "truncated bodies are reported",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux"`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"the body ends unexpectedly"},
+		},
+		{
+			name:            "trailing values are rejected",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux","prompt":"a castle"} {}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"Request body must contain a single JSON object"},
+		},
+		{
+			name:            "empty bodies are reported",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"Request body is required"},
+		},
+		{
+			name:            "oversized bodies are rejected",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"fal-ai/flux/schnell","prompt":"` + strings.Repeat("a", 200) + `"}`,
+			headers:         withSession,
+			setup:           withMaxBodyBytes(128),
+			expectedStatus:  http.StatusRequestEntityTooLarge,
+			expectedContent: []string{"Request body must not exceed 128 bytes"},
+		},
+		{
+			name:            "optional bodies may be empty",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/reconciliation/run",
+			headers:         superuserOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"users_scanned"`},
+		},
+		{
+			name:   "oversized uploads are rejected",
+			method: http.MethodPost,
+			url:    "/api/custom/images/import",
+			body:   uploadBody,
+			setup: func(t testing.TB, env *testEnv) {
+				env.cfg.MaxUploadBytes = 1024
+			},
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				headers := env.authHeaders()
+				headers["Content-Type"] = uploadType
+				return headers
+			},
+			expectedStatus:  http.StatusRequestEntityTooLarge,
+			expectedContent: []string{"Uploads must not exceed 1024 bytes"},
+		},
+	})
+}
@@ -0,0 +1,322 @@
+package tests
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"generatio-pb/internal/audit"
+	"generatio-pb/internal/budget"
+	"generatio-pb/internal/money"
+
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// budgetUserID is a user whose limits superusers change in route tests
+const budgetUserID = "budgetuser00001"
+
+// exhaustBudget limits a user to $1.00 a month and records an image that cost
+// exactly that
+func exhaustBudget(t testing.TB, env *testEnv, userID string) {
+	monthly := money.FromUSD(1.0)
+	_, err := budget.NewService(env.app).SetLimits(userID, &monthly, nil)
+	require.NoError(t, err)
+
+	env.createImage(t, map[string]any{"user_id": userID, "other_info": map[string]any{"cost_usd": 1.0}})
+}
+
+func withBudgetSpent(t testing.TB, env *testEnv) {
+	exhaustBudget(t, env, env.user.Id)
+}
+
+func withBudgetUser(t testing.TB, env *testEnv) {
+	env.createUser(t, budgetUserID, "budget@test.com")
+	exhaustBudget(t, env, budgetUserID)
+}
+
+// withDailyQuotaUsed limits the seeded user to one image a day and records one
+func withDailyQuotaUsed(t testing.TB, env *testEnv) {
+	daily := 1
+	_, err := budget.NewService(env.app).SetLimits(env.user.Id, nil, &daily)
+	require.NoError(t, err)
+
+	env.createImage(t, nil)
+}
+
+func TestBudgetService(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+	service := budget.NewService(env.app)
+
+	t.Run("UsersWithoutLimitsAreUnlimited", func(t *testing.T) {
+		env.createImage(t, map[string]any{"other_info": map[string]any{"cost_usd": 50.0}})
+		assert.NoError(t, service.Check(env.user.Id))
+		assert.Zero(t, service.Get(env.user.Id).MonthlyBudget)
+	})
+
+	t.Run("BudgetsStopGeneration", func(t *testing.T) {
+		monthly := money.FromUSD(40.0)
+		_, err := service.SetLimits(env.user.Id, &monthly, nil)
+		require.NoError(t, err)
+		assert.ErrorIs(t, service.Check(env.user.Id), budget.ErrBudgetExceeded)
+
+		status, err := service.Status(env.user.Id)
+		require.NoError(t, err)
+		assert.Equal(t, money.FromUSD(50.0), status.Usage.MonthSpent)
+		assert.InDelta(t, 50.0, status.Usage.MonthSpentUSD, 1e-9)
+		require.NotNil(t, status.Usage.Remaining)
+		assert.Zero(t, *status.Usage.Remaining)
+		require.NotNil(t, status.Usage.RemainingUSD)
+		assert.Zero(t, *status.Usage.RemainingUSD)
+	})
+
+	t.Run("CreditsExtendTheBudget", func(t *testing.T) {
+		limits, err := service.GrantCredit(env.user.Id, money.FromUSD(15))
+		require.NoError(t, err)
+		assert.Equal(t, money.FromUSD(15), limits.Credit)
+		assert.InDelta(t, 15.0, limits.CreditUSD, 1e-9)
+		assert.NoError(t, service.Check(env.user.Id))
+
+		_, err = service.GrantCredit(env.user.Id, money.FromUSD(-5))
+		assert.Error(t, err)
+	})
+
+	t.Run("QuotasStopGeneration", func(t *testing.T) {
+		daily := 1
+		_, err := service.SetLimits(env.user.Id, nil, &daily)
+		require.NoError(t, err)
+		assert.ErrorIs(t, service.Check(env.user.Id), budget.ErrQuotaExceeded)
+
+		negative := -1
+		_, err = service.SetLimits(env.user.Id, nil, &negative)
+		assert.Error(t, err)
+	})
+
+	t.Run("ResetsDiscardUsage", func(t *testing.T) {
+		limits, err := service.ResetQuota(env.user.Id)
+		require.NoError(t, err)
+		require.NotNil(t, limits.QuotaResetAt)
+		assert.NoError(t, service.Check(env.user.Id))
+
+		status, err := service.Status(env.user.Id)
+		require.NoError(t, err)
+		assert.Zero(t, status.Usage.MonthSpent)
+		assert.Zero(t, status.Usage.TodayImages)
+	})
+
+	t.Run("TestingGenerationsAreLeftOut", func(t *testing.T) {
+		env.createImage(t, map[string]any{"other_info": map[string]any{"cost_usd": 100.0, "environment": "testing"}})
+		assert.NoError(t, service.Check(env.user.Id))
+
+		status, err := service.Status(env.user.Id)
+		require.NoError(t, err)
+		assert.Zero(t, status.Usage.MonthSpent)
+		assert.Zero(t, status.Usage.TodayImages)
+	})
+
+	t.Run("TrashedImagesAreLeftOut", func(t *testing.T) {
+		env.createImage(t, map[string]any{"other_info": map[string]any{"cost_usd": 100.0}, "deleted_at": types.NowDateTime()})
+		assert.NoError(t, service.Check(env.user.Id))
+
+		status, err := service.Status(env.user.Id)
+		require.NoError(t, err)
+		assert.Zero(t, status.Usage.MonthSpent)
+		assert.Zero(t, status.Usage.TodayImages)
+	})
+
+	// $40 of budget and $15 of credit are left after the reset, and one image
+	t.Run("ReservationsCountAsSpent", func(t *testing.T) {
+		release, err := service.Reserve(env.user.Id, money.FromUSD(30), 0)
+		require.NoError(t, err)
+		_, err = service.Reserve(env.user.Id, money.FromUSD(30), 0)
+		assert.ErrorIs(t, err, budget.ErrBudgetExceeded, "the first reservation is still held")
+		release()
+		release()
+
+		release, err = service.Reserve(env.user.Id, 0, 1)
+		require.NoError(t, err)
+		assert.ErrorIs(t, service.Check(env.user.Id), budget.ErrQuotaExceeded, "an image in flight uses the quota")
+		release()
+		assert.NoError(t, service.Check(env.user.Id))
+	})
+
+	t.Run("ConcurrentReservationsCannotOverspend", func(t *testing.T) {
+		var (
+			wg       sync.WaitGroup
+			mu       sync.Mutex
+			releases []func()
+		)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				release, err := service.Reserve(env.user.Id, money.FromUSD(30), 0)
+				if err != nil {
+					assert.ErrorIs(t, err, budget.ErrBudgetExceeded)
+					return
+				}
+				mu.Lock()
+				releases = append(releases, release)
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		assert.Len(t, releases, 1, "only one $30 generation fits in $55")
+		for _, release := range releases {
+			release()
+		}
+	})
+}
+
+func TestUserBudgetRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "budget changes require a superuser",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/users/" + budgetUserID + "/credits",
+			body:            `{"amount_usd":5}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"Superuser access required"},
+		},
+		{
+			name:            "unknown users are not found",
+			method:          http.MethodGet,
+			url:             "/api/custom/admin/users/missinguser0000/budget",
+			headers:         superuserOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"User not found"},
+		},
+		{
+			name:            "generation stops at the monthly budget",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"prompt":"a castle","model":"fal-ai/flux/schnell"}`,
+			headers:         withSession,
+			setup:           withBudgetSpent,
+			expectedStatus:  http.StatusPaymentRequired,
+			expectedContent: []string{`"code":"quota_exceeded"`, "monthly budget is exhausted"},
+		},
+		{
+			name:            "generation stops at the daily quota",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"prompt":"a castle","model":"fal-ai/flux/schnell"}`,
+			headers:         withSession,
+			setup:           withDailyQuotaUsed,
+			expectedStatus:  http.StatusTooManyRequests,
+			expectedContent: []string{`"code":"quota_exceeded"`, "daily image quota is reached"},
+		},
+		{
+			name:   "generations estimated past the budget are refused",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"prompt":"a castle","model":"flux/schnell","parameters":{"num_images":4}}`,
+			setup: func(t testing.TB, env *testEnv) {
+				monthly := money.FromUSD(0.01)
+				_, err := budget.NewService(env.app).SetLimits(env.user.Id, &monthly, nil)
+				require.NoError(t, err)
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusPaymentRequired,
+			expectedContent: []string{`"code":"quota_exceeded"`, "monthly budget is exhausted"},
+		},
+		{
+			name:   "generations of more images than the quota has left are refused",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"prompt":"a castle","model":"flux/schnell","parameters":{"num_images":3}}`,
+			setup: func(t testing.TB, env *testEnv) {
+				daily := 2
+				_, err := budget.NewService(env.app).SetLimits(env.user.Id, nil, &daily)
+				require.NoError(t, err)
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusTooManyRequests,
+			expectedContent: []string{`"code":"quota_exceeded"`},
+		},
+		{
+			name:            "budgets report usage",
+			method:          http.MethodGet,
+			url:             "/api/custom/admin/users/" + budgetUserID + "/budget",
+			headers:         superuserOnly,
+			setup:           withBudgetUser,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"monthly_budget_usd":1`, `"month_spent_usd":1`, `"remaining_usd":0`, `"monthly_budget_micros":1000000`, `"month_spent_micros":1000000`, `"remaining_micros":0`},
+		},
+		{
+			name:            "credits are granted and audited",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/users/" + budgetUserID + "/credits",
+			body:            `{"amount_usd":2.5,"reason":"support ticket"}`,
+			headers:         superuserOnly,
+			setup:           withBudgetUser,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"credit_usd":2.5`, `"credit_micros":2500000`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.NoError(t, budget.NewService(env.app).Check(budgetUserID))
+
+				entries, err := audit.NewLog(env.app).List(budgetUserID, 10)
+				require.NoError(t, err)
+				require.Len(t, entries, 1)
+				assert.Equal(t, audit.ActionCreditGranted, entries[0].Action)
+				assert.Equal(t, "support ticket", entries[0].Reason)
+				assert.EqualValues(t, 2.5, entries[0].Details["amount_usd"])
+				assert.EqualValues(t, 2_500_000, entries[0].Details["amount_micros"])
+				assert.NotEmpty(t, entries[0].ActorID)
+			},
+		},
+		{
+			name:            "budgets are raised and audited",
+			method:          http.MethodPut,
+			url:             "/api/custom/admin/users/" + budgetUserID + "/budget",
+			body:            `{"monthly_budget_usd":20}`,
+			headers:         superuserOnly,
+			setup:           withBudgetUser,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"monthly_budget_usd":20`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				entries, err := audit.NewLog(env.app).List(budgetUserID, 10)
+				require.NoError(t, err)
+				require.Len(t, entries, 1)
+				assert.Equal(t, audit.ActionBudgetChanged, entries[0].Action)
+			},
+		},
+		{
+			name:            "budget changes need a limit",
+			method:          http.MethodPut,
+			url:             "/api/custom/admin/users/" + budgetUserID + "/budget",
+			body:            `{"reason":"nothing"}`,
+			headers:         superuserOnly,
+			setup:           withBudgetUser,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"monthly_budget_usd or daily_image_quota is required"},
+		},
+		{
+			name:            "quotas are reset and audited",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/users/" + budgetUserID + "/quota/reset",
+			headers:         superuserOnly,
+			setup:           withBudgetUser,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"quota_reset_at"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.NoError(t, budget.NewService(env.app).Check(budgetUserID))
+			},
+		},
+		{
+			name:    "the audit log lists changes",
+			method:  http.MethodGet,
+			url:     "/api/custom/admin/audit?target_id=" + budgetUserID,
+			headers: superuserOnly,
+			before: func(t testing.TB, env *testEnv) {
+				require.NoError(t, audit.NewLog(env.app).Record(audit.Entry{ActorID: "admin", Action: audit.ActionQuotaReset, TargetID: budgetUserID}))
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"action":"budget.quota_reset"`},
+		},
+	})
+}
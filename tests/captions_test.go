@@ -0,0 +1,193 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/captions"
+	"generatio-pb/internal/moderation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixedCaptioner answers every image with the same caption
+type fixedCaptioner struct {
+	caption string
+	fail    bool
+}
+
+func (f fixedCaptioner) Caption(ctx context.Context, token, imageURL string) (string, error) {
+	if f.fail {
+		return "", errors.New("caption request failed: HTTP 500")
+	}
+	return f.caption, nil
+}
+
+func TestCaptionService(t *testing.T) {
+	env := newTestEnv(t)
+	t.Cleanup(env.app.Cleanup)
+
+	t.Run("DisabledWithoutCaptioner", func(t *testing.T) {
+		service := captions.NewService(env.app, nil)
+		image := env.createImage(t, nil)
+
+		assert.False(t, service.Enabled())
+		_, err := service.Caption(context.Background(), testFALToken, image)
+		assert.ErrorIs(t, err, captions.ErrDisabled)
+	})
+
+	t.Run("StoresCleanedCaptions", func(t *testing.T) {
+		service := captions.NewService(env.app, fixedCaptioner{caption: "  A red   lighthouse\n on a cliff "})
+		image := env.createImage(t, nil)
+
+		caption, err := service.Caption(context.Background(), testFALToken, image)
+		require.NoError(t, err)
+		assert.Equal(t, "A red lighthouse on a cliff", caption)
+
+		stored, err := env.app.FindRecordById("images", image.Id)
+		require.NoError(t, err)
+		assert.Equal(t, caption, stored.GetString("caption"))
+	})
+
+	t.Run("TruncatesLongCaptions", func(t *testing.T) {
+		service := captions.NewService(env.app, fixedCaptioner{caption: strings.Repeat("é", captions.MaxLength+20)})
+		caption, err := service.Caption(context.Background(), testFALToken, env.createImage(t, nil))
+		require.NoError(t, err)
+		assert.Equal(t, captions.MaxLength, len([]rune(caption)))
+	})
+
+	t.Run("RejectsEmptyCaptions", func(t *testing.T) {
+		service := captions.NewService(env.app, fixedCaptioner{caption: " \n "})
+		image := env.createImage(t, nil)
+
+		_, err := service.Caption(context.Background(), testFALToken, image)
+		assert.Error(t, err)
+		stored, err := env.app.FindRecordById("images", image.Id)
+		require.NoError(t, err)
+		assert.Empty(t, stored.GetString("caption"))
+	})
+
+	t.Run("WorkerSkipsQuarantinedImages", func(t *testing.T) {
+		service := captions.NewService(env.app, fixedCaptioner{caption: "a foggy harbour"})
+		service.Start()
+		defer service.Stop()
+
+		quarantined := env.createImage(t, map[string]any{"moderation_status": moderation.StatusQuarantined})
+		approved := env.createImage(t, map[string]any{"moderation_status": moderation.StatusApproved})
+		service.Enqueue(testFALToken, quarantined.Id)
+		service.Enqueue(testFALToken, approved.Id)
+
+		assert.Eventually(t, func() bool {
+			stored, err := env.app.FindRecordById("images", approved.Id)
+			return err == nil && stored.GetString("caption") == "a foggy harbour"
+		}, 5*time.Second, 20*time.Millisecond)
+
+		stored, err := env.app.FindRecordById("images", quarantined.Id)
+		require.NoError(t, err)
+		assert.Empty(t, stored.GetString("caption"))
+	})
+}
+
+func TestCaptionRoutes(t *testing.T) {
+	withCaptioner := func(t testing.TB, env *testEnv) {
+		env.handler.SetCaptioner(fixedCaptioner{caption: "a lighthouse on a rocky coast at dusk"})
+	}
+	ownImage := func(t testing.TB, env *testEnv) {
+		env.createImage(t, map[string]any{"id": "captionimage001", "prompt": "lh"})
+	}
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "generated images are captioned in the background",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"lh dusk"}`,
+			before:          withCaptioner,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"images":[`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Eventually(t, func() bool {
+					records, err := env.app.FindAllRecords("images")
+					return err == nil && len(records) == 1 &&
+						records[0].GetString("caption") == "a lighthouse on a rocky coast at dusk"
+				}, 5*time.Second, 20*time.Millisecond)
+			},
+		},
+		{
+			name:            "captioning needs a captioner",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/captionimage001/caption",
+			setup:           ownImage,
+			headers:         withSession,
+			expectedStatus:  http.StatusServiceUnavailable,
+			expectedContent: []string{"Captioning is not enabled"},
+		},
+		{
+			name:            "captioning needs a session",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/captionimage001/caption",
+			setup:           ownImage,
+			before:          withCaptioner,
+			headers:         authOnly,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{"Valid session required"},
+		},
+		{
+			name:            "images are captioned on request",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/captionimage001/caption",
+			setup:           ownImage,
+			before:          withCaptioner,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"id":"captionimage001"`, `"caption":"a lighthouse on a rocky coast at dusk"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				stored, err := env.app.FindRecordById("images", "captionimage001")
+				require.NoError(t, err)
+				assert.Equal(t, "a lighthouse on a rocky coast at dusk", stored.GetString("caption"))
+			},
+		},
+		{
+			name:   "quarantined images are not captioned",
+			method: http.MethodPost,
+			url:    "/api/custom/images/captionimage001/caption",
+			setup: func(t testing.TB, env *testEnv) {
+				env.createImage(t, map[string]any{"id": "captionimage001", "moderation_status": moderation.StatusQuarantined})
+			},
+			before:          withCaptioner,
+			headers:         withSession,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"Image is withheld by moderation"},
+		},
+		{
+			name:   "other users' images are not found",
+			method: http.MethodPost,
+			url:    "/api/custom/images/strangerimage01/caption",
+			setup: func(t testing.TB, env *testEnv) {
+				env.createImage(t, map[string]any{"id": "strangerimage01", "user_id": "someoneelse0001"})
+			},
+			before:          withCaptioner,
+			headers:         withSession,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"Image not found"},
+		},
+		{
+			name:   "captioning failures are reported",
+			method: http.MethodPost,
+			url:    "/api/custom/images/captionimage001/caption",
+			setup:  ownImage,
+			before: func(t testing.TB, env *testEnv) {
+				env.handler.SetCaptioner(fixedCaptioner{fail: true})
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusBadGateway,
+			expectedContent: []string{"Failed to caption image"},
+		},
+	})
+}
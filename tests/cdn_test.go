@@ -0,0 +1,202 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/cdn"
+	"generatio-pb/internal/moderation"
+	"generatio-pb/internal/notify"
+	"generatio-pb/internal/share"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// purgeRequest is a purge call received by a fake CDN API
+type purgeRequest struct {
+	path   string
+	header http.Header
+	body   string
+}
+
+// newCDNAPI serves a fake CDN API answering status and recording calls
+func newCDNAPI(t testing.TB, status int) (*httptest.Server, chan purgeRequest) {
+	received := make(chan purgeRequest, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- purgeRequest{path: r.URL.Path, header: r.Header, body: string(body)}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, received
+}
+
+// purgeMessages returns the queued purges, oldest first
+func purgeMessages(t testing.TB, env *testEnv) []*core.Record {
+	records, err := env.app.FindRecordsByFilter(notify.OutboxCollection, "channel = {:channel}", "created", 0, 0,
+		map[string]any{"channel": cdn.Channel})
+	require.NoError(t, err)
+	return records
+}
+
+func TestCDNPurgers(t *testing.T) {
+	t.Run("Cloudflare", func(t *testing.T) {
+		server, received := newCDNAPI(t, http.StatusOK)
+		purger := &cdn.Cloudflare{ZoneID: "zone123", Token: "cf-token", Endpoint: server.URL}
+
+		require.NoError(t, purger.Purge(context.Background(), []string{"image-a", "image-b"}))
+		got := <-received
+		assert.Equal(t, "/zones/zone123/purge_cache", got.path)
+		assert.Equal(t, "Bearer cf-token", got.header.Get("Authorization"))
+		assert.JSONEq(t, `{"tags":["image-a","image-b"]}`, got.body)
+	})
+
+	t.Run("Fastly", func(t *testing.T) {
+		server, received := newCDNAPI(t, http.StatusOK)
+		purger := &cdn.Fastly{ServiceID: "svc123", Token: "fastly-token", Endpoint: server.URL}
+
+		require.NoError(t, purger.Purge(context.Background(), []string{"image-a", "image-b"}))
+		got := <-received
+		assert.Equal(t, "/service/svc123/purge", got.path)
+		assert.Equal(t, "fastly-token", got.header.Get("Fastly-Key"))
+		assert.Equal(t, "image-a image-b", got.header.Get("Surrogate-Key"))
+	})
+
+	t.Run("Configuration", func(t *testing.T) {
+		purger, err := cdn.NewPurger("", "", "")
+		assert.NoError(t, err)
+		assert.Nil(t, purger)
+
+		_, err = cdn.NewPurger(cdn.ProviderCloudflare, "zone123", "")
+		assert.Error(t, err)
+
+		_, err = cdn.NewPurger("akamai", "zone123", "token")
+		assert.ErrorContains(t, err, `unknown CDN provider "akamai"`)
+
+		purger, err = cdn.NewPurger(cdn.ProviderFastly, "svc123", "token")
+		require.NoError(t, err)
+		assert.IsType(t, &cdn.Fastly{}, purger)
+	})
+
+	t.Run("Tags", func(t *testing.T) {
+		header := http.Header{}
+		cdn.SetTags(header, cdn.ImageTag("a"), cdn.ImageTag("b"))
+		assert.Equal(t, "image-a,image-b", header.Get("Cache-Tag"))
+		assert.Equal(t, "image-a image-b", header.Get("Surrogate-Key"))
+	})
+}
+
+func TestCDNPurgeHooks(t *testing.T) {
+	setup := func(t *testing.T, status int) (*testEnv, *notify.Service, chan purgeRequest) {
+		env := newTestEnv(t)
+		t.Cleanup(env.app.Cleanup)
+
+		server, received := newCDNAPI(t, status)
+		notifier := notify.NewService(env.app)
+		cdn.NewService(env.app, notifier, cdn.ProviderCloudflare, &cdn.Cloudflare{ZoneID: "zone123", Token: "cf-token", Endpoint: server.URL})
+		return env, notifier, received
+	}
+
+	t.Run("PurgesChangedImages", func(t *testing.T) {
+		for name, change := range map[string]func(record *core.Record){
+			"Trashed":     func(record *core.Record) { record.Set("deleted_at", time.Now().UTC()) },
+			"Quarantined": func(record *core.Record) { record.Set("moderation_status", moderation.StatusQuarantined) },
+			"Replaced":    func(record *core.Record) { record.Set("content_hash", strings.Repeat("b", 64)) },
+			"NewURL":      func(record *core.Record) { record.Set("url", "https://example.com/other.png") },
+		} {
+			t.Run(name, func(t *testing.T) {
+				env, notifier, received := setup(t, http.StatusOK)
+				image := env.createImage(t, map[string]any{"content_hash": strings.Repeat("a", 64)})
+
+				record, err := env.app.FindRecordById("images", image.Id)
+				require.NoError(t, err)
+				change(record)
+				require.NoError(t, env.app.Save(record))
+
+				notifier.ProcessDue(context.Background())
+				got := <-received
+				assert.JSONEq(t, `{"tags":["image-`+image.Id+`"]}`, got.body)
+
+				messages := purgeMessages(t, env)
+				require.Len(t, messages, 1)
+				assert.Equal(t, notify.StatusSent, messages[0].GetString("status"))
+			})
+		}
+	})
+
+	t.Run("PurgesDeletedImages", func(t *testing.T) {
+		env, _, _ := setup(t, http.StatusOK)
+		image := env.createImage(t, nil)
+
+		record, err := env.app.FindRecordById("images", image.Id)
+		require.NoError(t, err)
+		require.NoError(t, env.app.Delete(record))
+
+		messages := purgeMessages(t, env)
+		require.Len(t, messages, 1)
+		assert.Equal(t, cdn.ProviderCloudflare, messages[0].GetString("target"))
+	})
+
+	t.Run("IgnoresOtherUpdates", func(t *testing.T) {
+		env, _, _ := setup(t, http.StatusOK)
+		image := env.createImage(t, nil)
+
+		record, err := env.app.FindRecordById("images", image.Id)
+		require.NoError(t, err)
+		record.Set("title", "Renamed")
+		record.Set("favorite", true)
+		// The first hash is set when the file is persisted, which replaces nothing
+		record.Set("content_hash", strings.Repeat("a", 64))
+		require.NoError(t, env.app.Save(record))
+
+		assert.Empty(t, purgeMessages(t, env))
+	})
+
+	t.Run("RejectedPurgesAreDeadLettered", func(t *testing.T) {
+		env, notifier, received := setup(t, http.StatusForbidden)
+		image := env.createImage(t, nil)
+
+		record, err := env.app.FindRecordById("images", image.Id)
+		require.NoError(t, err)
+		require.NoError(t, env.app.Delete(record))
+
+		notifier.ProcessDue(context.Background())
+		<-received
+
+		messages := purgeMessages(t, env)
+		require.Len(t, messages, 1)
+		assert.Equal(t, notify.StatusDead, messages[0].GetString("status"))
+		assert.Equal(t, 1, messages[0].GetInt("attempts"))
+		assert.Contains(t, messages[0].GetString("last_error"), "cloudflare purge failed: HTTP 403")
+	})
+}
+
+func TestCDNCacheTags(t *testing.T) {
+	origin, _ := newImageOrigin(t)
+	signer, _ := share.NewSigner(testShareSecret)
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:   "shared images are tagged for purging",
+			method: http.MethodGet,
+			url:    "/api/custom/shared/taggedimage0001?" + signer.Sign("taggedimage0001", time.Now().Add(time.Hour)),
+			setup: func(t testing.TB, env *testEnv) {
+				env.cfg.ShareSecret = testShareSecret
+				env.createImage(t, map[string]any{"id": "taggedimage0001", "url": origin.URL + "/image.png"})
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{"PNG"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, "image-taggedimage0001", res.Header.Get("Cache-Tag"))
+				assert.Equal(t, "image-taggedimage0001", res.Header.Get("Surrogate-Key"))
+			},
+		},
+	})
+}
@@ -0,0 +1,240 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"generatio-pb/internal/notify"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testDiscordURL is the webhook withChatWebhook registers
+const testDiscordURL = "https://discord.com/api/webhooks/123/secrettoken"
+
+// withChatWebhook registers a Discord webhook for the seeded user
+func withChatWebhook(t testing.TB, env *testEnv) {
+	collection, err := env.app.FindCollectionByNameOrId(notify.WebhooksCollection)
+	require.NoError(t, err)
+	record := core.NewRecord(collection)
+	record.Id = "chatwebhook0001"
+	record.Set("user_id", env.user.Id)
+	record.Set("channel", notify.ChannelDiscord)
+	record.Set("url", testDiscordURL)
+	record.Set("events", notify.ChatEvents)
+	require.NoError(t, env.app.Save(record))
+}
+
+// chatMessages returns the outbox messages queued for the test webhook
+func chatMessages(t testing.TB, env *testEnv) []*core.Record {
+	records, err := env.app.FindAllRecords(notify.OutboxCollection)
+	require.NoError(t, err)
+
+	var messages []*core.Record
+	for _, record := range records {
+		if record.GetString("target") == testDiscordURL {
+			messages = append(messages, record)
+		}
+	}
+	return messages
+}
+
+func TestChatWebhookPayloads(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+
+	service := notify.NewService(env.app)
+	received := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload map[string]interface{}
+		json.Unmarshal(body, &payload)
+		received <- payload
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	send := func(t *testing.T, channel string, images []string) map[string]interface{} {
+		require.NoError(t, service.Enqueue(notify.Message{
+			UserID:  env.user.Id,
+			Channel: channel,
+			Target:  server.URL,
+			Event:   notify.EventJobCompleted,
+			Subject: "Your pipeline finished",
+			Body:    "2 image(s)",
+			Images:  images,
+		}))
+		service.ProcessDue(context.Background())
+		return <-received
+	}
+	thumbnails := []string{"https://cdn.test/1.png", "https://cdn.test/2.png", "https://cdn.test/3.png", "https://cdn.test/4.png", "https://cdn.test/5.png"}
+
+	t.Run("DiscordEmbedsThumbnails", func(t *testing.T) {
+		payload := send(t, notify.ChannelDiscord, thumbnails)
+		embeds, ok := payload["embeds"].([]interface{})
+		require.True(t, ok)
+		require.Len(t, embeds, 4)
+		first := embeds[0].(map[string]interface{})
+		assert.Equal(t, "Your pipeline finished", first["title"])
+		assert.Equal(t, "2 image(s)", first["description"])
+		assert.Equal(t, map[string]interface{}{"url": "https://cdn.test/1.png"}, first["image"])
+	})
+
+	t.Run("SlackImageBlocks", func(t *testing.T) {
+		payload := send(t, notify.ChannelSlack, thumbnails[:2])
+		assert.Equal(t, "Your pipeline finished\n2 image(s)", payload["text"])
+		blocks, ok := payload["blocks"].([]interface{})
+		require.True(t, ok)
+		require.Len(t, blocks, 3)
+		assert.Equal(t, "image", blocks[1].(map[string]interface{})["type"])
+		assert.Equal(t, "https://cdn.test/2.png", blocks[2].(map[string]interface{})["image_url"])
+	})
+
+	t.Run("TextOnlyWithoutImages", func(t *testing.T) {
+		payload := send(t, notify.ChannelDiscord, nil)
+		assert.Equal(t, map[string]interface{}{"content": "Your pipeline finished\n2 image(s)"}, payload)
+	})
+}
+
+func TestChatWebhookRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "webhooks are registered for every chat event by default",
+			method:             http.MethodPost,
+			url:                "/api/custom/notifications/webhooks",
+			body:               `{"channel":"slack","url":"https://hooks.slack.com/services/T000/B000/abcdwxyz"}`,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"channel":"slack"`, `"url":"https://hooks.slack.com/…wxyz"`, `"events":["budget.alert","job.completed"]`},
+			notExpectedContent: []string{"T000"},
+		},
+		{
+			name:            "only Discord and Slack URLs are accepted",
+			method:          http.MethodPost,
+			url:             "/api/custom/notifications/webhooks",
+			body:            `{"channel":"discord","url":"https://internal.example/api/webhooks/1"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"url must be a discord webhook URL"},
+		},
+		{
+			name:            "unknown events are rejected",
+			method:          http.MethodPost,
+			url:             "/api/custom/notifications/webhooks",
+			body:            `{"channel":"discord","url":"` + testDiscordURL + `","events":["image.liked"]}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`unknown event \"image.liked\"`},
+		},
+		{
+			name:               "listings mask the URLs",
+			method:             http.MethodGet,
+			url:                "/api/custom/notifications/webhooks",
+			setup:              withChatWebhook,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"id":"chatwebhook0001"`, `"url":"https://discord.com/…oken"`},
+			notExpectedContent: []string{"secrettoken"},
+		},
+		{
+			name:            "test messages are queued",
+			method:          http.MethodPost,
+			url:             "/api/custom/notifications/webhooks/chatwebhook0001/test",
+			setup:           withChatWebhook,
+			headers:         authOnly,
+			expectedStatus:  http.StatusAccepted,
+			expectedContent: []string{`"queued":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				messages := chatMessages(t, env)
+				require.Len(t, messages, 1)
+				assert.Equal(t, notify.EventWebhookTest, messages[0].GetString("event"))
+			},
+		},
+		{
+			name:   "other users' webhooks cannot be deleted",
+			method: http.MethodDelete,
+			url:    "/api/custom/notifications/webhooks/chatwebhook0001",
+			setup:  withChatWebhook,
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				other := env.createUser(t, "chatstranger001", "chat-stranger@test.com")
+				token, err := other.NewAuthToken()
+				require.NoError(t, err)
+				return map[string]string{"Authorization": token}
+			},
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"Webhook not found"},
+		},
+		{
+			name:   "refused generations send a budget alert",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"model":"flux/schnell","prompt":"a red fox"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withChatWebhook(t, env)
+				withDailyQuotaUsed(t, env)
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusTooManyRequests,
+			expectedContent: []string{"Your daily image quota is reached"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				messages := chatMessages(t, env)
+				require.Len(t, messages, 1)
+				assert.Equal(t, notify.EventBudgetAlert, messages[0].GetString("event"))
+				assert.Equal(t, "Your daily image quota is reached", messages[0].GetString("subject"))
+			},
+		},
+		{
+			name:   "budget alerts are sent once per period",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"model":"flux/schnell","prompt":"a red fox"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withChatWebhook(t, env)
+				withBudgetSpent(t, env)
+				require.NoError(t, notify.NewService(env.app).Enqueue(notify.Message{
+					UserID:  env.user.Id,
+					Channel: notify.ChannelDiscord,
+					Target:  testDiscordURL,
+					Event:   notify.EventBudgetAlert,
+					Subject: "Your monthly budget is exhausted",
+				}))
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusPaymentRequired,
+			expectedContent: []string{"monthly budget is exhausted"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Len(t, chatMessages(t, env), 1)
+			},
+		},
+		{
+			name:   "finished pipelines are posted with thumbnails",
+			method: http.MethodPost,
+			url:    "/api/custom/pipelines",
+			body:   `{"steps":[{"type":"generate","model":"flux/schnell","prompt":"a red fox"}]}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withChatWebhook(t, env)
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusAccepted,
+			expectedContent: []string{`"status":"queued"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				run := processedRun(t, env, res)
+				require.Len(t, run.Steps[0].ImageIDs, 1)
+
+				messages := chatMessages(t, env)
+				require.Len(t, messages, 1)
+				assert.Equal(t, notify.EventJobCompleted, messages[0].GetString("event"))
+				assert.Equal(t, "Your pipeline finished", messages[0].GetString("subject"))
+				var images []string
+				require.NoError(t, messages[0].UnmarshalJSONField("images", &images))
+				assert.Equal(t, []string{"https://mock-image-url.com/image.jpg"}, images)
+			},
+		},
+	})
+}
@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"generatio-pb/internal/folderacl"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seedProject creates a folder with a subfolder, an image in each, a
+// trashed image and a trashed subfolder. Image URLs point at baseURL.
+func seedProject(baseURL string) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		folders, err := env.app.FindCollectionByNameOrId("folders")
+		require.NoError(t, err)
+		createFolder := func(id, name, parentID string, deleted bool) {
+			folder := core.NewRecord(folders)
+			folder.Id = id
+			folder.Set("name", name)
+			folder.Set("user_id", env.user.Id)
+			folder.Set("parent_id", parentID)
+			if deleted {
+				folder.Set("deleted_at", types.NowDateTime())
+			}
+			require.NoError(t, env.app.Save(folder))
+		}
+		createFolder("projectfolder01", "Project", "", false)
+		createFolder("draftsfolder001", "Drafts", "projectfolder01", false)
+		createFolder("oldfolder000001", "Old", "projectfolder01", true)
+
+		env.createImage(t, map[string]any{"id": "projectimage001", "folder_id": "projectfolder01", "url": baseURL + "/hero.png", "other_info": map[string]any{"cost_usd": 0.05}})
+		env.createImage(t, map[string]any{"id": "draftimage00001", "folder_id": "draftsfolder001", "url": baseURL + "/missing.png", "other_info": map[string]any{"cost_usd": 0.05}})
+		env.createImage(t, map[string]any{"id": "trashedimage001", "folder_id": "projectfolder01", "deleted_at": types.NowDateTime()})
+		env.createImage(t, map[string]any{"id": "oldimage0000001", "folder_id": "oldfolder000001"})
+	}
+}
+
+// findCopy returns the live folder with name under parentID
+func findCopy(t testing.TB, env *testEnv, name, parentID string) *core.Record {
+	folders, err := env.app.FindAllRecords("folders", dbx.HashExp{"name": name, "parent_id": parentID})
+	require.NoError(t, err)
+	require.Len(t, folders, 1)
+	return folders[0]
+}
+
+func TestDuplicateCollectionRoutes(t *testing.T) {
+	origin, _ := newImageOrigin(t)
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "duplicates live subfolders and images",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/projectfolder01/duplicate",
+			setup:           seedProject(origin.URL),
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"name":"Project (copy)"`, `"folders":2`, `"images":2`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				project := findCopy(t, env, "Project (copy)", "")
+				drafts := findCopy(t, env, "Drafts", project.Id)
+
+				copies, err := env.app.FindRecordsByFilter("images", "folder_id = {:project} || folder_id = {:drafts}", "", 0, 0, map[string]any{"project": project.Id, "drafts": drafts.Id})
+				require.NoError(t, err)
+				require.Len(t, copies, 2)
+				for _, image := range copies {
+					info := image.GetString("other_info")
+					assert.NotContains(t, info, "cost_usd", "copies must not count as spending")
+					assert.Contains(t, info, `"copied_from"`)
+					assert.True(t, strings.HasPrefix(image.GetString("url"), origin.URL))
+				}
+
+				// The originals are untouched
+				original, err := env.app.FindRecordById("images", "projectimage001")
+				require.NoError(t, err)
+				assert.Equal(t, "projectfolder01", original.GetString("folder_id"))
+			},
+		},
+		{
+			name:            "copying files stores them for the originals and copies",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/projectfolder01/duplicate",
+			body:            `{"name":"Branch","copy_files":true}`,
+			setup:           seedProject(origin.URL),
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"name":"Branch"`, `"files_failed":1`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				original, err := env.app.FindRecordById("images", "projectimage001")
+				require.NoError(t, err)
+				require.NotEmpty(t, original.GetString("content_hash"))
+
+				branch := findCopy(t, env, "Branch", "")
+				copied, err := env.app.FindFirstRecordByFilter("images", "folder_id = {:folder_id}", map[string]any{"folder_id": branch.Id})
+				require.NoError(t, err)
+				assert.Equal(t, original.GetString("content_hash"), copied.GetString("content_hash"))
+			},
+		},
+		{
+			name:            "viewers can branch a shared folder into their own library",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/" + testFolderID + "/duplicate",
+			setup:           withFolderRole(folderacl.RoleViewer),
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"name":"Shared moodboard (copy)"`, `"folders":1`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, env.user.Id, findCopy(t, env, "Shared moodboard (copy)", "").GetString("user_id"))
+			},
+		},
+		{
+			name:            "copies need edit access to their parent",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/" + testFolderID + "/duplicate",
+			body:            `{"parent_id":"` + testFolderID + `"}`,
+			setup:           withFolderRole(folderacl.RoleViewer),
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"You do not have edit access to the parent folder"},
+		},
+		{
+			name:            "deleted folders cannot be duplicated",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/oldfolder000001/duplicate",
+			setup:           seedProject(origin.URL),
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"Folder not found"},
+		},
+		{
+			name:            "names are bounded",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/projectfolder01/duplicate",
+			body:            `{"name":"` + strings.Repeat("x", 101) + `"}`,
+			setup:           seedProject(origin.URL),
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"name"`},
+		},
+	})
+}
@@ -0,0 +1,226 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/community"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPromptID = "communityprompt"
+
+// seedPrompt publishes a prompt by another user with one example image
+func seedPrompt(t testing.TB, env *testEnv) {
+	author := env.createUser(t, "promptauthor001", "author@test.com")
+	env.createImage(t, map[string]any{"id": "promptexample01", "user_id": author.Id})
+
+	collection, err := env.app.FindCollectionByNameOrId(community.PromptsCollection)
+	require.NoError(t, err)
+	record := core.NewRecord(collection)
+	record.Id = testPromptID
+	record.Set("user_id", author.Id)
+	record.Set("title", "Foggy harbour")
+	record.Set("prompt", "a foggy harbour at dawn, film grain")
+	record.Set("model", "flux/schnell")
+	record.Set("parameters", map[string]any{"num_inference_steps": 6})
+	record.Set("example_ids", []string{"promptexample01"})
+	require.NoError(t, env.app.Save(record))
+}
+
+func TestCommunityLibrary(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+
+	library := community.NewLibrary(env.app)
+	other := env.createUser(t, "someoneelse0001", "someone@test.com")
+	env.createImage(t, map[string]any{"id": "myexample000001"})
+	env.createImage(t, map[string]any{"id": "theirexample001", "user_id": other.Id})
+
+	_, err := library.Publish(env.user.Id, community.PublishInput{Prompt: "a lighthouse", Model: "flux/schnell"})
+	assert.ErrorContains(t, err, "title is required")
+	_, err = library.Publish(env.user.Id, community.PublishInput{Title: "Stolen", Prompt: "a lighthouse", Model: "flux/schnell", ExampleIDs: []string{"theirexample001"}})
+	assert.ErrorContains(t, err, "example image theirexample001 not found")
+
+	lighthouse, err := library.Publish(env.user.Id, community.PublishInput{Title: "Lighthouse", Prompt: "a lighthouse at dusk", Model: "flux/schnell", ExampleIDs: []string{"myexample000001"}})
+	require.NoError(t, err)
+	harbour, err := library.Publish(other.Id, community.PublishInput{Title: "Harbour", Prompt: "a foggy harbour", Model: "flux/schnell"})
+	require.NoError(t, err)
+
+	// Likes are counted once per user
+	likes, err := library.SetLiked(harbour.ID, env.user.Id, true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, likes)
+	likes, err = library.SetLiked(harbour.ID, env.user.Id, true)
+	require.NoError(t, err)
+	assert.Equal(t, 1, likes)
+
+	popular, err := library.Browse(env.user.Id, "", community.SortPopular, 1)
+	require.NoError(t, err)
+	require.Len(t, popular, 2)
+	assert.Equal(t, harbour.ID, popular[0].ID)
+	assert.True(t, popular[0].LikedByMe)
+	assert.False(t, popular[1].LikedByMe)
+
+	found, err := library.Browse(env.user.Id, "lighthouse", community.SortRecent, 1)
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, lighthouse.ID, found[0].ID)
+
+	likes, err = library.SetLiked(harbour.ID, env.user.Id, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, likes)
+
+	// Reports hide a prompt from everyone but its author
+	assert.ErrorContains(t, library.Report(lighthouse.ID, env.user.Id, "spam"), "your own prompt")
+	for i, id := range []string{"reporter0000001", "reporter0000002", "reporter0000003"} {
+		env.createUser(t, id, id+"@test.com")
+		require.NoError(t, library.Report(lighthouse.ID, id, "spam"))
+		if i == 0 {
+			require.NoError(t, library.Report(lighthouse.ID, id, "spam again"), "reporting twice is a no-op")
+		}
+
+		record, err := env.app.FindRecordById(community.PromptsCollection, lighthouse.ID)
+		require.NoError(t, err)
+		assert.Equal(t, i+1, record.GetInt("reports"))
+	}
+
+	_, err = library.Find(lighthouse.ID, other.Id)
+	assert.ErrorContains(t, err, "prompt not found")
+	_, err = library.Find(lighthouse.ID, env.user.Id)
+	assert.NoError(t, err)
+
+	visible, err := library.Browse(other.Id, "", community.SortRecent, 1)
+	require.NoError(t, err)
+	require.Len(t, visible, 1)
+	assert.Equal(t, harbour.ID, visible[0].ID)
+
+	// Unpublishing removes the prompt with its reports
+	assert.ErrorContains(t, library.Unpublish(lighthouse.ID, other.Id), "prompt not found")
+	require.NoError(t, library.Unpublish(lighthouse.ID, env.user.Id))
+	reports, err := env.app.FindAllRecords(community.ReportsCollection)
+	require.NoError(t, err)
+	assert.Empty(t, reports)
+}
+
+func TestCommunityRoutes(t *testing.T) {
+	origin, _ := newImageOrigin(t)
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:   "publish a prompt with an example",
+			method: http.MethodPost,
+			url:    "/api/custom/community/prompts",
+			body:   `{"title":"Lighthouse","prompt":"a lighthouse at dusk","model":"flux/schnell","example_ids":["myexample000001"]}`,
+			setup: func(t testing.TB, env *testEnv) {
+				env.createImage(t, map[string]any{"id": "myexample000001"})
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"title":"Lighthouse"`, `/examples/myexample000001"`, `"likes":0`},
+		},
+		{
+			name:            "published prompts follow the content policy",
+			method:          http.MethodPost,
+			url:             "/api/custom/community/prompts",
+			body:            `{"title":"Nope","prompt":"a terrorist attack","model":"flux/schnell"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"content_policy_violation"`},
+		},
+		{
+			name:            "browse and search prompts",
+			method:          http.MethodGet,
+			url:             "/api/custom/community/prompts?q=harbour&sort=popular",
+			setup:           seedPrompt,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"id":"communityprompt"`, `"per_page":24`},
+		},
+		{
+			name:            "search without matches",
+			method:          http.MethodGet,
+			url:             "/api/custom/community/prompts?q=volcano",
+			setup:           seedPrompt,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"data":[]`},
+		},
+		{
+			name:            "like a prompt",
+			method:          http.MethodPost,
+			url:             "/api/custom/community/prompts/" + testPromptID + "/like",
+			setup:           seedPrompt,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"liked":true`, `"likes":1`},
+		},
+		{
+			name:            "report a prompt",
+			method:          http.MethodPost,
+			url:             "/api/custom/community/prompts/" + testPromptID + "/report",
+			body:            `{"reason":"spam"}`,
+			setup:           seedPrompt,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+		},
+		{
+			name:            "only authors unpublish",
+			method:          http.MethodDelete,
+			url:             "/api/custom/community/prompts/" + testPromptID,
+			setup:           seedPrompt,
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"code":"not_found"`},
+		},
+		{
+			name:            "generate from a prompt in one click",
+			method:          http.MethodPost,
+			url:             "/api/custom/community/prompts/" + testPromptID + "/generate",
+			setup:           seedPrompt,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"flux/schnell"`, `"url":"https://mock-image-url.com/image.jpg"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				prompt, err := env.app.FindRecordById(community.PromptsCollection, testPromptID)
+				require.NoError(t, err)
+				assert.Equal(t, 1, prompt.GetInt("uses"))
+
+				image, err := env.app.FindFirstRecordByData("images", "user_id", env.user.Id)
+				require.NoError(t, err)
+				assert.Equal(t, "a foggy harbour at dawn, film grain", image.GetString("prompt"))
+			},
+		},
+		{
+			name:   "examples of other users' prompts are served",
+			method: http.MethodGet,
+			url:    "/api/custom/community/prompts/" + testPromptID + "/examples/promptexample01",
+			setup: func(t testing.TB, env *testEnv) {
+				seedPrompt(t, env)
+				example, err := env.app.FindRecordById("images", "promptexample01")
+				require.NoError(t, err)
+				example.Set("url", origin.URL+"/image.png")
+				require.NoError(t, env.app.Save(example))
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{"PNG"},
+		},
+		{
+			name:   "images that are not examples are not served",
+			method: http.MethodGet,
+			url:    "/api/custom/community/prompts/" + testPromptID + "/examples/privateimage001",
+			setup: func(t testing.TB, env *testEnv) {
+				seedPrompt(t, env)
+				env.createImage(t, map[string]any{"id": "privateimage001", "user_id": "promptauthor001"})
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"code":"not_found"`},
+		},
+	})
+}
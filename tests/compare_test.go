@@ -0,0 +1,138 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/fal"
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testComparisonID = "comparison00001"
+
+// failModel makes every generation for model fail
+func failModel(model string) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		mock := fal.NewMockClient()
+		env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+			if req.Model == model {
+				return nil, &fal.FALError{Code: "model_error", Message: "model unavailable"}
+			}
+			return mock.GenerateImage(ctx, token, req)
+		})
+	}
+}
+
+func TestCompareRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "compare requires a session",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/compare",
+			body:            `{"prompt":"a red fox","variants":[{"model":"flux/schnell"},{"model":"hidream/hidream-i1-fast"}]}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"code":"authentication_error"`},
+		},
+		{
+			name:            "compare needs at least two variants",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/compare",
+			body:            `{"prompt":"a red fox","variants":[{"model":"flux/schnell"}]}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"Between 2 and 4 variants"},
+		},
+		{
+			name:            "compare rejects unknown models",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/compare",
+			body:            `{"prompt":"a red fox","variants":[{"model":"flux/schnell"},{"model":"nope/model"}]}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`Variant 2: unsupported model`},
+		},
+		{
+			name:            "compare runs every variant and links the images",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/compare",
+			body:            `{"prompt":"a red fox","variants":[{"model":"flux/schnell","parameters":{"num_inference_steps":4}},{"model":"hidream/hidream-i1-fast"}]}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"comparison_id":"`, `"model":"hidream/hidream-i1-fast"`, `"total_cost":0.006`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				var resp localmodels.CompareResponse
+				decodeData(t, res, &resp)
+				require.Len(t, resp.Results, 2)
+				assert.Equal(t, 1, resp.Results[0].Variant)
+				assert.Equal(t, "flux/schnell", resp.Results[0].Model)
+				assert.Len(t, resp.Results[1].Images, 1)
+
+				records, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				require.Len(t, records, 2)
+				for _, record := range records {
+					assert.Equal(t, resp.ComparisonID, record.GetString("group_id"))
+				}
+
+				user, err := env.app.FindRecordById("generatio_users", env.user.Id)
+				require.NoError(t, err)
+				var financial localmodels.FinancialData
+				require.NoError(t, user.UnmarshalJSONField("financial_data", &financial))
+				assert.Equal(t, 2, financial.TotalImages)
+			},
+		},
+		{
+			name:            "compare reports failed variants",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/compare",
+			body:            `{"prompt":"a red fox","variants":[{"model":"flux/schnell"},{"model":"hidream/hidream-i1-fast"}]}`,
+			before:          failModel("hidream/hidream-i1-fast"),
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"error":"Image generation failed","error_code":"fal_error"`, `"total_cost":0.003`},
+		},
+		{
+			name:            "compare fails when every variant fails",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/compare",
+			body:            `{"prompt":"a red fox","variants":[{"model":"flux/schnell"},{"model":"flux/schnell","parameters":{"seed":7}}]}`,
+			before:          failModel("flux/schnell"),
+			headers:         withSession,
+			expectedStatus:  http.StatusBadGateway,
+			expectedContent: []string{`"code":"external_error"`},
+		},
+		{
+			name:   "get comparison groups images by variant",
+			method: http.MethodGet,
+			url:    "/api/custom/generate/compare/" + testComparisonID,
+			setup: func(t testing.TB, env *testEnv) {
+				for variant, model := range []string{"flux/schnell", "hidream/hidream-i1-fast"} {
+					env.createImage(t, map[string]any{
+						"model":    model,
+						"group_id": testComparisonID,
+						"other_info": map[string]any{
+							"cost_usd": 0.003,
+							"group":    map[string]any{"id": testComparisonID, "kind": "comparison", "variant": variant + 1},
+						},
+					})
+				}
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"comparison_id":"comparison00001"`, `"variant":2`, `"model":"hidream/hidream-i1-fast"`},
+		},
+		{
+			name:            "unknown comparison",
+			method:          http.MethodGet,
+			url:             "/api/custom/generate/compare/" + testComparisonID,
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"code":"not_found"`},
+		},
+	})
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/availability"
+	"generatio-pb/internal/fal"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unreachableProbe fails every probe the way the FAL client does when the
+// network is misconfigured
+func unreachableProbe(ctx context.Context, id string) error {
+	return fmt.Errorf("%w: failed to reach endpoint: dial tcp: lookup fal.run: no such host", fal.ErrUnreachable)
+}
+
+// withUnreachableFAL makes the startup connectivity check fail
+func withUnreachableFAL(t testing.TB, env *testEnv) {
+	env.cfg.ModelProbeInterval = 0
+	env.falClient.SetProbeModelFunc(unreachableProbe)
+}
+
+func TestConnectivityMonitor(t *testing.T) {
+	client := fal.NewMockClient()
+	monitor := availability.NewMonitor(client, 0)
+
+	assert.True(t, monitor.Connectivity().Reachable, "FAL AI is assumed reachable until checked")
+
+	client.SetProbeModelFunc(unreachableProbe)
+	down := monitor.CheckConnectivity(context.Background())
+	assert.False(t, down.Reachable)
+	assert.Contains(t, down.Error, "no such host")
+	require.NotNil(t, down.Since)
+	require.NotNil(t, down.CheckedAt)
+
+	client.SetProbeModelFunc(nil)
+	up := monitor.CheckConnectivity(context.Background())
+	assert.True(t, up.Reachable)
+	assert.Empty(t, up.Error)
+	assert.Nil(t, up.Since)
+
+	// Endpoints answering with errors still prove the network works
+	client.SetProbeModelFunc(func(ctx context.Context, id string) error {
+		return errors.New("endpoint returned HTTP 503")
+	})
+	monitor.ProbeAll(context.Background())
+	assert.True(t, monitor.Connectivity().Reachable)
+
+	client.SetProbeModelFunc(unreachableProbe)
+	monitor.ProbeAll(context.Background())
+	assert.False(t, monitor.Connectivity().Reachable)
+}
+
+func TestDegradedModeRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "health reports ok when FAL AI is reachable",
+			method:          http.MethodGet,
+			url:             "/api/custom/health",
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"status":"ok"`, `"generation_available":true`, `"reachable":true`},
+		},
+		{
+			name:            "health reports degraded when FAL AI is unreachable",
+			method:          http.MethodGet,
+			url:             "/api/custom/health",
+			setup:           withUnreachableFAL,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"status":"degraded"`, `"generation_available":false`, `"reachable":false`, "no such host"},
+		},
+		{
+			name:            "generation is refused in degraded mode",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"prompt":"a lighthouse at dusk","model":"fal-ai/flux/schnell"}`,
+			setup:           withUnreachableFAL,
+			headers:         withSession,
+			expectedStatus:  http.StatusServiceUnavailable,
+			expectedContent: []string{"cannot reach FAL AI"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, "60", res.Header.Get("Retry-After"))
+			},
+		},
+		{
+			name:            "reads keep working in degraded mode",
+			method:          http.MethodGet,
+			url:             "/api/custom/generate/models",
+			setup:           withUnreachableFAL,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"flux/schnell":`},
+		},
+		{
+			name:   "the startup check can be turned off",
+			method: http.MethodGet,
+			url:    "/api/custom/health",
+			setup: func(t testing.TB, env *testEnv) {
+				withUnreachableFAL(t, env)
+				env.cfg.StartupConnectivityCheck = false
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"status":"ok"`},
+		},
+		{
+			name:   "superusers can leave degraded mode by checking again",
+			method: http.MethodPost,
+			url:    "/api/custom/admin/connectivity/check",
+			setup:  withUnreachableFAL,
+			before: func(t testing.TB, env *testEnv) {
+				env.falClient.SetProbeModelFunc(nil)
+			},
+			headers:         superuserOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"reachable":true`},
+		},
+		{
+			name:            "connectivity checks need a superuser",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/connectivity/check",
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"Superuser access required"},
+		},
+	})
+}
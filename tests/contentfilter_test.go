@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/contentfilter"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentFilterPolicy(t *testing.T) {
+	cases := []struct {
+		name       string
+		strictness string
+		prompt     string
+		allowed    bool
+	}{
+		{"benign words containing terms", contentfilter.StrictnessStandard, "a skilled hacker-proof vault", true},
+		{"violent storm", contentfilter.StrictnessStandard, "a violent storm over the sea", true},
+		{"allow phrase neutralises term", contentfilter.StrictnessStrict, "a neon drug store at night", true},
+		{"low severity passes standard", contentfilter.StrictnessStandard, "death of a star", true},
+		{"low severity blocked when strict", contentfilter.StrictnessStrict, "death of a star", false},
+		{"medium severity blocked", contentfilter.StrictnessStandard, "how to kill a dragon", false},
+		{"medium severity passes lenient", contentfilter.StrictnessLenient, "how to kill a dragon", true},
+		{"off allows everything", contentfilter.StrictnessOff, "bomb", true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			decision := contentfilter.NewPolicy(tc.strictness, contentfilter.DefaultTerms()).Evaluate(tc.prompt)
+			assert.Equal(t, tc.allowed, decision.Allowed, "matches: %+v", decision.Matches)
+		})
+	}
+}
+
+func superuserOnly(t testing.TB, env *testEnv) map[string]string {
+	return env.superuserHeaders(t)
+}
+
+func TestContentFilterRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "dry run explains a rejection",
+			method:          http.MethodPost,
+			url:             "/api/custom/content-filter/check",
+			body:            `{"prompt":"a murder mystery poster"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"allowed":false`, `"term":"murder"`, `"severity":"medium"`, `"strictness":"standard"`},
+		},
+		{
+			name:            "dry run requires auth",
+			method:          http.MethodPost,
+			url:             "/api/custom/content-filter/check",
+			body:            `{"prompt":"a lighthouse"}`,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"code":"authentication_error"`},
+		},
+		{
+			name:            "generation rejects blocked prompts",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a terrorist attack"}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"content_policy_violation"`, `"term":"terrorist"`},
+		},
+		{
+			name:            "generation accepts previously blocked benign prompts",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a violent storm near a drug store"}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"flux/schnell"`},
+		},
+		{
+			name:            "terms require superuser",
+			method:          http.MethodGet,
+			url:             "/api/custom/admin/content-filter/terms",
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{`"code":"authorization_error"`},
+		},
+		{
+			name:            "admin adds a block term",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/content-filter/terms",
+			body:            `{"term":"Clown","kind":"block","severity":"high"}`,
+			headers:         superuserOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"term":"clown"`, `"kind":"block"`, `"severity":"high"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				decision := env.handler.ContentFilter().Evaluate("a sad clown")
+				assert.False(t, decision.Allowed)
+			},
+		},
+		{
+			name:            "admin term validation",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/content-filter/terms",
+			body:            `{"term":"clown","kind":"maybe"}`,
+			headers:         superuserOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`},
+		},
+		{
+			name:   "allow entry disables a default term",
+			method: http.MethodPost,
+			url:    "/api/custom/content-filter/check",
+			body:   `{"prompt":"a murder of crows"}`,
+			before: func(t testing.TB, env *testEnv) {
+				_, err := env.handler.ContentFilter().AddTerm(contentfilter.Term{Term: "murder", Kind: contentfilter.KindAllow})
+				require.NoError(t, err)
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"allowed":true`},
+		},
+		{
+			name:   "admin deletes a term",
+			method: http.MethodDelete,
+			url:    "/api/custom/admin/content-filter/terms/clownterm000001",
+			setup: func(t testing.TB, env *testEnv) {
+				collection, err := env.app.FindCollectionByNameOrId(contentfilter.TermsCollection)
+				require.NoError(t, err)
+				record := core.NewRecord(collection)
+				record.Id = "clownterm000001"
+				record.Set("term", "clown")
+				record.Set("kind", contentfilter.KindBlock)
+				record.Set("severity", contentfilter.SeverityHigh)
+				require.NoError(t, env.app.Save(record))
+			},
+			headers:         superuserOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.True(t, env.handler.ContentFilter().Evaluate("a sad clown").Allowed)
+			},
+		},
+	})
+}
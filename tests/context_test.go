@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/fal"
+	"generatio-pb/internal/moderation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// requestTimeout ends the request context of a scenario part way through
+const requestTimeout = 100 * time.Millisecond
+
+// errNotCancelled is returned by FAL AI calls that outlived their request
+var errNotCancelled = errors.New("FAL AI call outlived its request")
+
+// blockFALCalls makes FAL AI calls wait until their context ends
+func blockFALCalls(t testing.TB, env *testEnv) {
+	wait := func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(5 * time.Second):
+			return errNotCancelled
+		}
+	}
+	env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+		return nil, wait(ctx)
+	})
+	env.falClient.SetValidateTokenFunc(func(ctx context.Context, token string) error {
+		return wait(ctx)
+	})
+}
+
+// contextModerator approves images unless the context it is given has ended
+type contextModerator struct{}
+
+func (contextModerator) Classify(ctx context.Context, token, imageURL string) (*moderation.Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &moderation.Result{}, nil
+}
+
+// deliverAsRequestEnds makes FAL AI deliver its images just as the request
+// context ends, with a moderator that fails on an ended context
+func deliverAsRequestEnds(t testing.TB, env *testEnv) {
+	env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+		<-ctx.Done()
+		return deliveredResult(req.Parameters, "https://fal.media/late.jpg"), nil
+	})
+}
+
+func TestRequestContextRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "generation stops when its request ends",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			setup:              blockFALCalls,
+			headers:            withSession,
+			timeout:            requestTimeout,
+			expectedStatus:     http.StatusGatewayTimeout,
+			expectedContent:    []string{`"code":"fal_timeout"`},
+			notExpectedContent: []string{errNotCancelled.Error()},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				records, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				assert.Empty(t, records)
+			},
+		},
+		{
+			name:   "derived generation stops when its request ends",
+			method: http.MethodPost,
+			url:    "/api/custom/images/" + testSourceImageID + "/edit",
+			body:   `{"prompt":"a lighthouse at dawn"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				seedSource(t, env)
+				blockFALCalls(t, env)
+			},
+			headers:         withSession,
+			timeout:         requestTimeout,
+			expectedStatus:  http.StatusGatewayTimeout,
+			expectedContent: []string{`"code":"fal_timeout"`},
+		},
+		{
+			name:            "token validation stops when its request ends",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/setup",
+			body:            `{"fal_token":"` + testFALToken + `","password":"` + testPassword + `"}`,
+			setup:           blockFALCalls,
+			headers:         authOnly,
+			timeout:         requestTimeout,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"message":"Invalid FAL AI token"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				user, err := env.app.FindRecordById("generatio_users", env.user.Id)
+				require.NoError(t, err)
+				assert.Empty(t, user.GetString("fal_token"))
+			},
+		},
+		{
+			name:   "images delivered as the request ends are still saved",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			setup:  deliverAsRequestEnds,
+			before: func(t testing.TB, env *testEnv) {
+				env.handler.SetModerator(contextModerator{})
+			},
+			headers:         withSession,
+			timeout:         requestTimeout,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"url":"https://fal.media/late.jpg"`, `"moderation_status":"approved"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				records, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				require.Len(t, records, 1)
+				assert.Equal(t, moderation.StatusApproved, records[0].GetString("moderation_status"))
+			},
+		},
+	})
+}
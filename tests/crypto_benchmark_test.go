@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/crypto"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cheapProfiles keeps benchmark scenarios fast
+const cheapProfiles = `[{"kdf":"pbkdf2-sha256","iterations":1000},{"kdf":"pbkdf2-sha256","iterations":2000}]`
+
+func TestKDFParams(t *testing.T) {
+	t.Run("Validate", func(t *testing.T) {
+		for _, profile := range crypto.DefaultProfiles() {
+			assert.NoError(t, profile.Validate(), profile)
+		}
+		assert.Error(t, crypto.KDFParams{KDF: crypto.KDFPBKDF2, Iterations: 10}.Validate())
+		assert.ErrorContains(t, crypto.KDFParams{KDF: "argon2id", Iterations: 2}.Validate(), `unknown kdf "argon2id"`)
+		assert.ErrorContains(t, crypto.KDFParams{KDF: "md5", Iterations: 1}.Validate(), `unknown kdf "md5"`)
+	})
+
+	t.Run("MeetsGuidance", func(t *testing.T) {
+		assert.False(t, crypto.KDFParams{KDF: crypto.KDFPBKDF2, Iterations: 310000}.MeetsGuidance())
+		assert.True(t, crypto.KDFParams{KDF: crypto.KDFPBKDF2, Iterations: 600000}.MeetsGuidance())
+	})
+
+	t.Run("WorkIsCapped", func(t *testing.T) {
+		opts := crypto.BenchmarkOptions{}
+		require.NoError(t, opts.Normalize(), "the defaults fit within the cap")
+
+		opts = crypto.BenchmarkOptions{Profiles: []crypto.KDFParams{{KDF: crypto.KDFPBKDF2, Iterations: 10000000}}, Samples: 2}
+		assert.ErrorContains(t, opts.Normalize(), "at most 20000000 can be benchmarked")
+	})
+
+	t.Run("SharesWorkers", func(t *testing.T) {
+		service := crypto.NewEncryptionService(1000)
+		service.SetWorkers(1)
+		report, err := service.Benchmark(crypto.BenchmarkOptions{
+			Profiles: []crypto.KDFParams{{KDF: crypto.KDFPBKDF2, Iterations: 1000}},
+			Samples:  2,
+		})
+		require.NoError(t, err)
+		require.Len(t, report.Results, 1)
+		assert.Greater(t, report.Results[0].DecryptMS, 0.0)
+	})
+}
+
+func TestRecommend(t *testing.T) {
+	pbkdf2 := func(iterations int, decryptMS float64) crypto.BenchmarkResult {
+		profile := crypto.KDFParams{KDF: crypto.KDFPBKDF2, Iterations: iterations}
+		return crypto.BenchmarkResult{KDFParams: profile, DecryptMS: decryptMS, MeetsGuidance: profile.MeetsGuidance()}
+	}
+
+	results := []crypto.BenchmarkResult{pbkdf2(100000, 40), pbkdf2(310000, 120), pbkdf2(600000, 230)}
+
+	recommendations := crypto.Recommend(results, 250*time.Millisecond)
+	require.Len(t, recommendations, 1)
+	assert.Equal(t, 600000, recommendations[0].Iterations)
+	assert.Contains(t, recommendations[0].Reason, "meets OWASP guidance")
+
+	recommendations = crypto.Recommend(results, 150*time.Millisecond)
+	assert.Equal(t, 310000, recommendations[0].Iterations)
+	assert.Contains(t, recommendations[0].Reason, "below OWASP guidance")
+
+	recommendations = crypto.Recommend(results, 30*time.Millisecond)
+	assert.Equal(t, 100000, recommendations[0].Iterations)
+	assert.Contains(t, recommendations[0].Reason, "this is the fastest")
+}
+
+func TestCryptoBenchmarkRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "superusers benchmark key derivation settings",
+			method:             http.MethodPost,
+			url:                "/api/custom/admin/crypto/benchmark",
+			body:               `{"profiles":` + cheapProfiles + `,"samples":1,"target_ms":5000}`,
+			headers:            superuserOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"current":{"kdf":"pbkdf2-sha256"`, `"results":[`, `"decrypt_ms":`, `"recommendations":[`, `"target_ms":5000`},
+			notExpectedContent: []string{"argon2id", "memory_kib"},
+		},
+		{
+			name:            "invalid profiles are rejected",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/crypto/benchmark",
+			body:            `{"profiles":[{"kdf":"pbkdf2-sha256","iterations":10}]}`,
+			headers:         superuserOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"pbkdf2-sha256 iterations must be between"},
+		},
+		{
+			name:            "sample counts are capped",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/crypto/benchmark",
+			body:            `{"profiles":` + cheapProfiles + `,"samples":50}`,
+			headers:         superuserOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"samples must be between 1 and 10"},
+		},
+		{
+			name:            "argon2id is not offered",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/crypto/benchmark",
+			body:            `{"profiles":[{"kdf":"argon2id","iterations":2}]}`,
+			headers:         superuserOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`unknown kdf \"argon2id\"`},
+		},
+		{
+			name:            "runs are capped in total work",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/crypto/benchmark",
+			body:            `{"profiles":[{"kdf":"pbkdf2-sha256","iterations":5000000},{"kdf":"pbkdf2-sha256","iterations":6000000}],"samples":1}`,
+			headers:         superuserOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"at most 20000000 can be benchmarked"},
+		},
+		{
+			name:            "benchmarks need a superuser",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/crypto/benchmark",
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"Superuser access required"},
+		},
+	})
+}
+
+// BenchmarkEncryptDecrypt times a full key round trip under each default profile
+func BenchmarkEncryptDecrypt(b *testing.B) {
+	for _, profile := range crypto.DefaultProfiles() {
+		b.Run(fmt.Sprint(profile.Iterations), func(b *testing.B) {
+			service := crypto.NewEncryptionService(1000)
+			for i := 0; i < b.N; i++ {
+				if _, err := service.Benchmark(crypto.BenchmarkOptions{Profiles: []crypto.KDFParams{profile}, Samples: 1}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"generatio-pb/internal/currency"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingProvider serves fixed rates and counts fetches, failing once fail is set
+type countingProvider struct {
+	fetches int
+	fail    bool
+}
+
+func (p *countingProvider) Rates(ctx context.Context) (map[string]float64, error) {
+	p.fetches++
+	if p.fail {
+		return nil, errors.New("provider down")
+	}
+	return map[string]float64{"eur": 0.5}, nil
+}
+
+// withEuroRates configures a fixed EUR rate of 0.5 per USD
+func withEuroRates(t testing.TB, env *testEnv) {
+	env.cfg.ExchangeRates = map[string]float64{"eur": 0.5}
+}
+
+func TestCurrencyConverter(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("USDNeedsNoProvider", func(t *testing.T) {
+		rate, err := currency.NewConverter(nil).Rate(ctx, currency.USD)
+		require.NoError(t, err)
+		assert.Equal(t, 1.0, rate)
+
+		_, err = currency.NewConverter(nil).Rate(ctx, "EUR")
+		assert.ErrorContains(t, err, "no exchange rate provider")
+	})
+
+	t.Run("RatesAreCached", func(t *testing.T) {
+		provider := &countingProvider{}
+		converter := currency.NewConverter(provider)
+
+		for i := 0; i < 3; i++ {
+			rate, err := converter.Rate(ctx, "EUR")
+			require.NoError(t, err)
+			assert.Equal(t, 0.5, rate)
+		}
+		assert.Equal(t, 1, provider.fetches)
+		assert.False(t, converter.Updated().IsZero())
+
+		_, err := converter.Rate(ctx, "JPY")
+		assert.ErrorContains(t, err, "no exchange rate for JPY")
+	})
+
+	t.Run("FetchFailuresWithoutCacheAreReported", func(t *testing.T) {
+		converter := currency.NewConverter(&countingProvider{fail: true})
+		_, err := converter.Rate(ctx, "EUR")
+		assert.ErrorContains(t, err, "provider down")
+	})
+
+	t.Run("HTTPProviderReadsRates", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"result":"success","base_code":"USD","rates":{"USD":1,"GBP":0.8}}`))
+		}))
+		defer server.Close()
+
+		rate, err := currency.NewConverter(&currency.HTTPProvider{URL: server.URL}).Rate(ctx, "GBP")
+		require.NoError(t, err)
+		assert.Equal(t, 0.8, rate)
+	})
+
+	t.Run("CodesAreNormalized", func(t *testing.T) {
+		code, err := currency.Normalize(" eur ")
+		require.NoError(t, err)
+		assert.Equal(t, "EUR", code)
+
+		_, err = currency.Normalize("euro")
+		assert.Error(t, err)
+	})
+
+	assert.Equal(t, 0.1235, currency.Convert(0.247, 0.5))
+}
+
+func TestDisplayCurrencyRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "the display currency defaults to USD",
+			method:          http.MethodGet,
+			url:             "/api/custom/financial/currency",
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"currency":"USD"`, `"rate":1`},
+		},
+		{
+			name:            "users choose a display currency",
+			method:          http.MethodPut,
+			url:             "/api/custom/financial/currency",
+			body:            `{"currency":"eur"}`,
+			headers:         authOnly,
+			setup:           withEuroRates,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"currency":"EUR"`, `"rate":0.5`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				user, err := env.app.FindRecordById("generatio_users", env.user.Id)
+				require.NoError(t, err)
+				assert.Equal(t, "EUR", user.GetString("display_currency"))
+			},
+		},
+		{
+			name:            "currencies without a rate are rejected",
+			method:          http.MethodPut,
+			url:             "/api/custom/financial/currency",
+			body:            `{"currency":"JPY"}`,
+			headers:         authOnly,
+			setup:           withEuroRates,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"Currency JPY is not available"},
+		},
+		{
+			name:            "currency codes must be ISO 4217",
+			method:          http.MethodPut,
+			url:             "/api/custom/financial/currency",
+			body:            `{"currency":"euro"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"three-letter ISO 4217 code"},
+		},
+		{
+			name:    "financial stats add converted amounts and keep USD",
+			method:  http.MethodGet,
+			url:     "/api/custom/financial/stats",
+			headers: authOnly,
+			setup: func(t testing.TB, env *testEnv) {
+				withEuroRates(t, env)
+				env.user.Set("display_currency", "EUR")
+				require.NoError(t, env.app.Save(env.user))
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"total_spent":0,`, `"display":{"currency":"EUR","rate":0.5,`},
+		},
+		{
+			name:               "USD users get no converted amounts",
+			method:             http.MethodGet,
+			url:                "/api/custom/financial/stats",
+			headers:            authOnly,
+			setup:              withEuroRates,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"total_spent":0,`},
+			notExpectedContent: []string{`"display"`},
+		},
+	})
+}
@@ -0,0 +1,234 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"generatio-pb/internal/custommodels"
+	"generatio-pb/internal/fal"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCustomModelID = "custommodel0001"
+
+// loraModel is a registered endpoint with a required parameter
+var loraModel = custommodels.Input{
+	Name:         "my-lora",
+	DisplayName:  "My LoRA",
+	Endpoint:     "acme/sdxl-lora/generate",
+	CostPerImage: 0.01,
+	Parameters: map[string]fal.Parameter{
+		"lora_scale": {Type: "float", Default: 0.8, Min: floatPtr(0), Max: floatPtr(2)},
+		"style":      {Type: "string", Options: []string{"ink", "oil"}, Required: true},
+	},
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+// withCustomModel registers loraModel for userID
+func withCustomModel(userID string) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		collection, err := env.app.FindCollectionByNameOrId(custommodels.Collection)
+		require.NoError(t, err)
+		require.NoError(t, custommodels.Validate(&loraModel))
+
+		record := core.NewRecord(collection)
+		record.Id = testCustomModelID
+		record.Set("user_id", userID)
+		record.Set("name", loraModel.Name)
+		record.Set("display_name", loraModel.DisplayName)
+		record.Set("endpoint", loraModel.Endpoint)
+		record.Set("cost_per_image", loraModel.CostPerImage)
+		record.Set("parameters", loraModel.Parameters)
+		require.NoError(t, env.app.Save(record))
+	}
+}
+
+func withOwnCustomModel(t testing.TB, env *testEnv) {
+	withCustomModel(env.user.Id)(t, env)
+}
+
+func TestCustomModelRoutes(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []fal.GenerationRequest
+	)
+	recordRequests := func(t testing.TB, env *testEnv) {
+		requests = nil
+		withCustomModel(env.user.Id)(t, env)
+		mock := fal.NewMockClient()
+		env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+			mu.Lock()
+			requests = append(requests, req)
+			mu.Unlock()
+			return mock.GenerateImage(ctx, token, req)
+		})
+	}
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "registered models are selected by their custom ID",
+			method:          http.MethodPost,
+			url:             "/api/custom/models",
+			body:            `{"name":"my-lora","endpoint":"acme/sdxl-lora","cost_per_image":0.01,"parameters":{"lora_scale":{"type":"float","default":0.8,"min":0,"max":2}}}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"custom/my-lora"`, `"display_name":"my-lora"`, `"endpoint":"acme/sdxl-lora"`},
+		},
+		{
+			name:            "endpoints must be FAL endpoint IDs",
+			method:          http.MethodPost,
+			url:             "/api/custom/models",
+			body:            `{"name":"my-lora","endpoint":"https://evil.example.com"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`endpoint must be a FAL endpoint ID`},
+		},
+		{
+			name:            "endpoints cannot climb out of the FAL path",
+			method:          http.MethodPost,
+			url:             "/api/custom/models",
+			body:            `{"name":"my-lora","endpoint":"acme/../../admin"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`endpoint must be a FAL endpoint ID`},
+		},
+		{
+			name:            "parameter defaults must fit their schema",
+			method:          http.MethodPost,
+			url:             "/api/custom/models",
+			body:            `{"name":"my-lora","endpoint":"acme/sdxl-lora","parameters":{"lora_scale":{"type":"float","default":5,"max":2}}}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`parameter \"lora_scale\": invalid default: lora_scale must be at most 2`},
+		},
+		{
+			name:            "the prompt cannot be declared as a parameter",
+			method:          http.MethodPost,
+			url:             "/api/custom/models",
+			body:            `{"name":"my-lora","endpoint":"acme/sdxl-lora","parameters":{"prompt":{"type":"string"}}}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"the prompt is sent separately"},
+		},
+		{
+			name:            "names are unique per user",
+			method:          http.MethodPost,
+			url:             "/api/custom/models",
+			body:            `{"name":"my-lora","endpoint":"acme/other"}`,
+			headers:         authOnly,
+			setup:           withOwnCustomModel,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`a custom model named \"my-lora\" already exists`},
+		},
+		{
+			name:            "custom models are listed with the built-in models",
+			method:          http.MethodGet,
+			url:             "/api/custom/generate/models",
+			headers:         authOnly,
+			setup:           withOwnCustomModel,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"custom/my-lora":{`, `"custom":true`, `"flux/schnell":{`},
+		},
+		{
+			name:            "generations send the custom model definition",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"custom/my-lora","prompt":"a red fox","parameters":{"style":"ink"}}`,
+			headers:         withSession,
+			setup:           recordRequests,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"custom/my-lora"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				require.Len(t, requests, 1)
+				require.NotNil(t, requests[0].CustomModel)
+				assert.Equal(t, "acme/sdxl-lora/generate", requests[0].CustomModel.Endpoint)
+			},
+		},
+		{
+			name:            "comparisons accept custom models",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/compare",
+			body:            `{"prompt":"a red fox","variants":[{"model":"flux/schnell"},{"model":"custom/my-lora","parameters":{"style":"oil"}}]}`,
+			headers:         withSession,
+			setup:           recordRequests,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"custom/my-lora"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				require.Len(t, requests, 2)
+				custom := 0
+				for _, req := range requests {
+					if req.CustomModel != nil {
+						custom++
+					}
+				}
+				assert.Equal(t, 1, custom)
+			},
+		},
+		{
+			name:            "unknown custom models are rejected",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"custom/missing","prompt":"a red fox"}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`Unsupported model \"custom/missing\"`},
+		},
+		{
+			name:    "custom models of other users cannot be used",
+			method:  http.MethodPost,
+			url:     "/api/custom/generate/image",
+			body:    `{"model":"custom/my-lora","prompt":"a red fox","parameters":{"style":"ink"}}`,
+			headers: withSession,
+			setup: func(t testing.TB, env *testEnv) {
+				env.createUser(t, "otheruser000001", "other@test.com")
+				withCustomModel("otheruser000001")(t, env)
+			},
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`Unsupported model \"custom/my-lora\"`},
+		},
+		{
+			name:    "custom models of other users are not found",
+			method:  http.MethodGet,
+			url:     "/api/custom/models/" + testCustomModelID,
+			headers: authOnly,
+			setup: func(t testing.TB, env *testEnv) {
+				env.createUser(t, "otheruser000001", "other@test.com")
+				withCustomModel("otheruser000001")(t, env)
+			},
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"code":"not_found"`},
+		},
+		{
+			name:            "updates replace the definition",
+			method:          http.MethodPut,
+			url:             "/api/custom/models/" + testCustomModelID,
+			body:            `{"name":"my-lora","endpoint":"acme/sdxl-lora-v2","cost_per_image":0.02}`,
+			headers:         authOnly,
+			setup:           withOwnCustomModel,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"endpoint":"acme/sdxl-lora-v2"`, `"parameters":{}`},
+		},
+		{
+			name:            "deleted models are removed from the registry",
+			method:          http.MethodDelete,
+			url:             "/api/custom/models/" + testCustomModelID,
+			headers:         authOnly,
+			setup:           withOwnCustomModel,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				count, err := env.app.CountRecords(custommodels.Collection)
+				require.NoError(t, err)
+				assert.Zero(t, count)
+			},
+		},
+	})
+}
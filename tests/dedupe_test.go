@@ -0,0 +1,323 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/dedupe"
+	"generatio-pb/internal/fal"
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDuplicateDetector(t *testing.T) {
+	detector := dedupe.NewDetector(50 * time.Millisecond)
+	key := dedupe.Request{UserID: "user1", Model: "flux/schnell", Prompt: "a lighthouse", Parameters: map[string]interface{}{"seed": 1, "num_images": 2}}.Fingerprint()
+
+	assert.Equal(t, key, dedupe.Request{UserID: "user1", Model: "flux/schnell", Prompt: "a lighthouse", Parameters: map[string]interface{}{"num_images": 2, "seed": 1}}.Fingerprint())
+	assert.NotEqual(t, key, dedupe.Request{UserID: "user2", Model: "flux/schnell", Prompt: "a lighthouse", Parameters: map[string]interface{}{"seed": 1, "num_images": 2}}.Fingerprint())
+	assert.Equal(t, dedupe.Request{UserID: "user1"}.Fingerprint(), dedupe.Request{UserID: "user1", Parameters: map[string]interface{}{}}.Fingerprint())
+
+	// A duplicate of a submission in flight waits for its result
+	first, previous := detector.Begin(key)
+	require.NotNil(t, first)
+	assert.Nil(t, previous)
+
+	current, previous := detector.Begin(key)
+	assert.Nil(t, current)
+	require.NotNil(t, previous)
+
+	waited := make(chan interface{})
+	go func() {
+		result, ok := previous.Wait(context.Background())
+		assert.True(t, ok)
+		waited <- result
+	}()
+	first.Complete("first result")
+	assert.Equal(t, "first result", <-waited)
+
+	// The window starts when the submission completes
+	_, previous = detector.Begin(key)
+	require.NotNil(t, previous)
+	time.Sleep(60 * time.Millisecond)
+	again, previous := detector.Begin(key)
+	assert.Nil(t, previous, "the window has passed")
+	require.NotNil(t, again)
+
+	// Failed submissions can be retried straight away
+	again.Fail()
+	retry, previous := detector.Begin(key)
+	assert.Nil(t, previous)
+	assert.NotNil(t, retry)
+
+	// Waiting gives up with the caller's context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, previous = detector.Begin(key)
+	_, ok := previous.Wait(ctx)
+	assert.False(t, ok)
+
+	disabled := dedupe.NewDetector(0)
+	current, previous = disabled.Begin(key)
+	assert.Nil(t, current)
+	assert.Nil(t, previous)
+	current.Complete("ignored")
+}
+
+// withEarlierGeneration records that the seeded user generated the scenario's
+// request moments ago, and fails any generation that reaches FAL AI
+func withEarlierGeneration(t testing.TB, env *testEnv) {
+	current, _ := env.handler.Duplicates().Begin(dedupe.Request{
+		UserID: env.user.Id,
+		Model:  "flux/schnell",
+		Prompt: "a lighthouse at dusk",
+	}.Fingerprint())
+	require.NotNil(t, current)
+	current.Complete(&localmodels.GenerateImageResponse{
+		Images: []localmodels.GeneratedImageInfo{{ID: "earlierimage001", URL: "https://fal.media/earlier.jpg"}},
+		Cost:   0.003,
+		Model:  "flux/schnell",
+	})
+}
+
+// failGenerations makes any generation that reaches FAL AI fail
+func failGenerations(t testing.TB, env *testEnv) {
+	env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+		return nil, &fal.FALError{Code: "generation_failed", Message: "FAL AI was called"}
+	})
+}
+
+func TestDuplicateRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "a double-click returns the earlier images without charging",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			setup:              failGenerations,
+			before:             withEarlierGeneration,
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"duplicate":true`, `"cost":0`, `"url":"https://fal.media/earlier.jpg"`, "nothing was charged again"},
+			notExpectedContent: []string{"FAL AI was called"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				var resp localmodels.GenerateImageResponse
+				decodeData(t, res, &resp)
+				assert.Zero(t, resp.Cost)
+				assert.Zero(t, resp.CostMicros, "the duplicate is not charged in micro-dollars either")
+			},
+		},
+		{
+			name:               "a different prompt is not a duplicate",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"flux/schnell","prompt":"a lighthouse at dawn"}`,
+			before:             withEarlierGeneration,
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"url":"https://mock-image-url.com/image.jpg"`},
+			notExpectedContent: []string{`"duplicate"`},
+		},
+		{
+			name:               "allow_duplicate generates anyway",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"flux/schnell","prompt":"a lighthouse at dusk","allow_duplicate":true}`,
+			before:             withEarlierGeneration,
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"url":"https://mock-image-url.com/image.jpg"`},
+			notExpectedContent: []string{`"duplicate"`},
+		},
+		{
+			name:   "warn mode generates the duplicate with a warning",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				env.cfg.DuplicateAction = "warn"
+			},
+			before:             withEarlierGeneration,
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"url":"https://mock-image-url.com/image.jpg"`, "this one is generated and charged again"},
+			notExpectedContent: []string{`"duplicate"`},
+		},
+	})
+}
+
+func TestCoalescer(t *testing.T) {
+	seeded := map[string]interface{}{"seed": 7, "num_images": 1}
+	key := dedupe.SeededKey("user1", "flux/schnell", "a lighthouse", seeded)
+	assert.NotEmpty(t, key)
+	assert.Empty(t, dedupe.SeededKey("user1", "flux/schnell", "a lighthouse", map[string]interface{}{"num_images": 1}))
+	assert.NotEqual(t, key, dedupe.SeededKey("user1", "flux/schnell", "a lighthouse", map[string]interface{}{"seed": 8, "num_images": 1}))
+	assert.NotEqual(t, key, dedupe.SeededKey("user2", "flux/schnell", "a lighthouse", seeded))
+
+	coalescer := dedupe.NewCoalescer(true)
+	release := make(chan struct{})
+	leader := make(chan interface{})
+	go func() {
+		result, shared, err := coalescer.Do(context.Background(), key, func() (interface{}, error) {
+			<-release
+			return "leader result", nil
+		})
+		assert.NoError(t, err)
+		assert.False(t, shared)
+		leader <- result
+	}()
+	require.Eventually(t, func() bool { return coalescer.InFlight() == 1 }, time.Second, time.Millisecond)
+
+	// Identical requests in flight share the first one's result
+	followers := make(chan interface{}, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			result, shared, err := coalescer.Do(context.Background(), key, func() (interface{}, error) {
+				return "submitted again", nil
+			})
+			assert.NoError(t, err)
+			assert.True(t, shared)
+			followers <- result
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	assert.Equal(t, "leader result", <-leader)
+	assert.Equal(t, "leader result", <-followers)
+	assert.Equal(t, "leader result", <-followers)
+	assert.Equal(t, 0, coalescer.InFlight())
+
+	// Finished requests are not shared
+	result, shared, err := coalescer.Do(context.Background(), key, func() (interface{}, error) {
+		return "fresh result", nil
+	})
+	require.NoError(t, err)
+	assert.False(t, shared)
+	assert.Equal(t, "fresh result", result)
+
+	// A failed request leaves its followers to submit themselves
+	failing := make(chan struct{})
+	go func() {
+		_, _, err := coalescer.Do(context.Background(), key, func() (interface{}, error) {
+			<-failing
+			return nil, &fal.FALError{Code: "invalid_token", Message: "key out of credit"}
+		})
+		assert.Error(t, err)
+	}()
+	require.Eventually(t, func() bool { return coalescer.InFlight() == 1 }, time.Second, time.Millisecond)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(failing)
+	}()
+	result, shared, err = coalescer.Do(context.Background(), key, func() (interface{}, error) {
+		return "own result", nil
+	})
+	require.NoError(t, err)
+	assert.False(t, shared)
+	assert.Equal(t, "own result", result)
+
+	// Unseeded and disabled requests always run themselves
+	calls := 0
+	disabled := dedupe.NewCoalescer(false)
+	for _, c := range []*dedupe.Coalescer{coalescer, disabled} {
+		_, shared, err = c.Do(context.Background(), "", func() (interface{}, error) {
+			calls++
+			return nil, nil
+		})
+		require.NoError(t, err)
+		assert.False(t, shared)
+	}
+	assert.Equal(t, 2, calls)
+}
+
+// withSeededGenerationInFlight keeps the scenario's seeded request in flight
+// for another caller of the same user until shortly after the scenario's
+// request arrives
+func withSeededGenerationInFlight(fails bool) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		keepSeededGenerationInFlight(t, env, env.user.Id, fails)
+	}
+}
+
+// keepSeededGenerationInFlight submits the scenario's seeded request for
+// userID and keeps it in flight for 300ms
+func keepSeededGenerationInFlight(t testing.TB, env *testEnv, userID string, fails bool) {
+	var shared fal.GenerationResponse
+	require.NoError(t, json.Unmarshal([]byte(`{"request_id":"sharedrequest","images":[{"url":"https://fal.media/shared.jpg"}],"cost":0.003,"seed":7}`), &shared))
+
+	coalescer := env.handler.Coalescer()
+	key := dedupe.SeededKey(userID, "flux/schnell", "a lighthouse at dusk", map[string]interface{}{"seed": float64(7)})
+	go coalescer.Do(context.Background(), key, func() (interface{}, error) {
+		time.Sleep(300 * time.Millisecond)
+		if fails {
+			return nil, &fal.FALError{Code: "invalid_token", Message: "the other caller's key was rejected"}
+		}
+		return &shared, nil
+	})
+	require.Eventually(t, func() bool { return coalescer.InFlight() == 1 }, time.Second, time.Millisecond)
+}
+
+func TestCoalescedGenerationRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "an identical seeded request in flight is shared without charging",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"flux/schnell","prompt":"a lighthouse at dusk","parameters":{"seed":7},"allow_duplicate":true}`,
+			setup:              failGenerations,
+			before:             withSeededGenerationInFlight(false),
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"url":"https://fal.media/shared.jpg"`, `"cost":0`, "its images are shared and nothing was charged"},
+			notExpectedContent: []string{"FAL AI was called"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				// The shared images are saved to the caller's own library
+				records, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				require.Len(t, records, 1)
+				assert.Equal(t, env.user.Id, records[0].GetString("user_id"))
+				assert.Equal(t, "https://fal.media/shared.jpg", records[0].GetString("url"))
+			},
+		},
+		{
+			name:               "a failed shared request is submitted again",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"flux/schnell","prompt":"a lighthouse at dusk","parameters":{"seed":7},"allow_duplicate":true}`,
+			before:             withSeededGenerationInFlight(true),
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"url":"https://mock-image-url.com/image.jpg"`},
+			notExpectedContent: []string{"rejected", "nothing was charged"},
+		},
+		{
+			name:   "another user's identical request is not shared",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"model":"flux/schnell","prompt":"a lighthouse at dusk","parameters":{"seed":7},"allow_duplicate":true}`,
+			before: func(t testing.TB, env *testEnv) {
+				keepSeededGenerationInFlight(t, env, "someoneelse0001", false)
+			},
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"url":"https://mock-image-url.com/image.jpg"`},
+			notExpectedContent: []string{"fal.media/shared.jpg", "nothing was charged"},
+		},
+		{
+			name:               "unseeded requests are not shared",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"flux/schnell","prompt":"a lighthouse at dusk","allow_duplicate":true}`,
+			before:             withSeededGenerationInFlight(false),
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"url":"https://mock-image-url.com/image.jpg"`},
+			notExpectedContent: []string{"fal.media/shared.jpg"},
+		},
+	})
+}
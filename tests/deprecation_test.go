@@ -0,0 +1,192 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/fal"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deprecatedModels adds deprecated models to the built-in catalog:
+// legacy/flux migrates to flux/schnell, legacy/aging has no replacement yet
+// and legacy/retired is past its sunset
+func deprecatedModels() map[string]fal.ModelInfo {
+	models := fal.NewMockClient().GetModels()
+
+	legacy := models["flux/schnell"]
+	legacy.Name = "legacy/flux"
+	legacy.Deprecation = &fal.Deprecation{
+		Sunset:       "2099-12-31",
+		Replacement:  "flux/schnell",
+		ParameterMap: map[string]string{"steps": "num_inference_steps", "sharpness": ""},
+	}
+	models[legacy.Name] = legacy
+
+	aging := models["flux/schnell"]
+	aging.Name = "legacy/aging"
+	aging.Deprecation = &fal.Deprecation{Sunset: "2099-12-31"}
+	models[aging.Name] = aging
+
+	retired := models["flux/schnell"]
+	retired.Name = "legacy/retired"
+	retired.Deprecation = &fal.Deprecation{Sunset: "2020-01-01"}
+	models[retired.Name] = retired
+
+	return models
+}
+
+func TestMigrateRequest(t *testing.T) {
+	now := time.Date(2030, 6, 1, 0, 0, 0, 0, time.UTC)
+	models := deprecatedModels()
+
+	t.Run("UnknownAndCurrentModelsAreUnchanged", func(t *testing.T) {
+		params := map[string]interface{}{"steps": 4}
+		for _, id := range []string{"flux/schnell", "custom/my-lora"} {
+			model, migrated, warnings, err := fal.MigrateRequest(models, id, params, now)
+			require.NoError(t, err)
+			assert.Equal(t, id, model)
+			assert.Equal(t, params, migrated)
+			assert.Empty(t, warnings)
+		}
+	})
+
+	t.Run("ChainsAreFollowed", func(t *testing.T) {
+		older := models["flux/schnell"]
+		older.Deprecation = &fal.Deprecation{Sunset: "2025-01-01", Replacement: "legacy/flux", ParameterMap: map[string]string{"num_steps": "steps"}}
+		models["legacy/older"] = older
+
+		model, params, warnings, err := fal.MigrateRequest(models, "legacy/older", map[string]interface{}{"num_steps": 4, "sharpness": 2}, now)
+		require.NoError(t, err)
+		assert.Equal(t, "flux/schnell", model)
+		assert.Equal(t, map[string]interface{}{"num_inference_steps": 4}, params)
+		assert.Len(t, warnings, 2)
+	})
+
+	t.Run("CyclesAreRejected", func(t *testing.T) {
+		a, b := models["flux/schnell"], models["flux/schnell"]
+		a.Deprecation = &fal.Deprecation{Sunset: "2099-01-01", Replacement: "cycle/b"}
+		b.Deprecation = &fal.Deprecation{Sunset: "2099-01-01", Replacement: "cycle/a"}
+		models["cycle/a"], models["cycle/b"] = a, b
+
+		_, _, _, err := fal.MigrateRequest(models, "cycle/a", nil, now)
+		assert.ErrorContains(t, err, "no usable replacement")
+	})
+
+	t.Run("SunsetDayIsStillUsable", func(t *testing.T) {
+		sunset := time.Date(2020, 1, 1, 23, 0, 0, 0, time.UTC)
+		_, _, warnings, err := fal.MigrateRequest(models, "legacy/retired", nil, sunset)
+		require.NoError(t, err)
+		assert.Len(t, warnings, 1)
+
+		_, _, _, err = fal.MigrateRequest(models, "legacy/retired", nil, sunset.Add(2*time.Hour))
+		assert.ErrorContains(t, err, `model "legacy/retired" was retired on 2020-01-01`)
+	})
+}
+
+func TestModelDeprecation(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []fal.GenerationRequest
+	)
+	withDeprecations := func(t testing.TB, env *testEnv) {
+		requests = nil
+		env.falClient.SetGetModelsFunc(deprecatedModels)
+		mock := fal.NewMockClient()
+		env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+			mu.Lock()
+			requests = append(requests, req)
+			mu.Unlock()
+			return mock.GenerateImage(ctx, token, req)
+		})
+	}
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "deprecations are listed with the models",
+			method:          http.MethodGet,
+			url:             "/api/custom/generate/models",
+			headers:         authOnly,
+			setup:           withDeprecations,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"deprecation":{"sunset":"2099-12-31","replacement":"flux/schnell","parameter_map":{`},
+		},
+		{
+			name:            "deprecated models are replaced with a warning",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"legacy/flux","prompt":"a red fox","parameters":{"steps":4,"sharpness":2}}`,
+			headers:         withSession,
+			setup:           withDeprecations,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"flux/schnell"`, `"warnings":["model \"legacy/flux\" is deprecated (sunset 2099-12-31); using \"flux/schnell\" instead"]`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Contains(t, res.Header.Get("Warning"), `299 - "model \"legacy/flux\" is deprecated`)
+				require.Len(t, requests, 1)
+				assert.Equal(t, "flux/schnell", requests[0].Model)
+				assert.Equal(t, map[string]interface{}{"num_inference_steps": float64(4)}, requests[0].Parameters)
+			},
+		},
+		{
+			name:               "models without a replacement are used until their sunset",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"legacy/aging","prompt":"a red fox"}`,
+			headers:            withSession,
+			setup:              withDeprecations,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"model":"legacy/aging"`, `will be retired on 2099-12-31`},
+			notExpectedContent: []string{`"model":"flux/schnell"`},
+		},
+		{
+			name:            "retired models are rejected",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"legacy/retired","prompt":"a red fox"}`,
+			headers:         withSession,
+			setup:           withDeprecations,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`model \"legacy/retired\" was retired on 2020-01-01`},
+		},
+		{
+			name:            "comparisons migrate each variant",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/compare",
+			body:            `{"prompt":"a red fox","variants":[{"model":"legacy/flux"},{"model":"hidream/hidream-i1-fast"}]}`,
+			headers:         withSession,
+			setup:           withDeprecations,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"flux/schnell"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.NotEmpty(t, res.Header.Get("Warning"))
+			},
+		},
+		{
+			name:            "pipelines store the replacement model",
+			method:          http.MethodPost,
+			url:             "/api/custom/pipelines",
+			body:            `{"steps":[{"type":"generate","model":"legacy/flux","prompt":"a red fox","parameters":{"steps":4}}]}`,
+			headers:         withSession,
+			setup:           withDeprecations,
+			expectedStatus:  http.StatusAccepted,
+			expectedContent: []string{`"model":"flux/schnell"`, `"num_inference_steps":4`},
+		},
+		{
+			name:    "images from deprecated models are derived with the replacement",
+			method:  http.MethodPost,
+			url:     "/api/custom/images/img000000000001/regenerate",
+			headers: withSession,
+			setup: func(t testing.TB, env *testEnv) {
+				withDeprecations(t, env)
+				env.createImage(t, map[string]any{"id": "img000000000001", "model": "legacy/flux", "parameters": map[string]any{"steps": 4}})
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"flux/schnell"`, `"warnings":[`},
+		},
+	})
+}
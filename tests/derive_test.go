@@ -0,0 +1,270 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSourceImageID = "sourceimage0001"
+
+// derivedImage returns the image derived from the test source image
+func derivedImage(t testing.TB, env *testEnv) *core.Record {
+	record, err := env.app.FindFirstRecordByData("images", "parent_id", testSourceImageID)
+	require.NoError(t, err)
+	return record
+}
+
+// seedSource saves the test source image with a recorded seed and parameters
+func seedSource(t testing.TB, env *testEnv) {
+	env.createImage(t, map[string]any{
+		"id": testSourceImageID,
+		"other_info": map[string]any{
+			"seed":       1234,
+			"parameters": map[string]any{"guidance_scale": 5, "num_inference_steps": 8},
+		},
+	})
+}
+
+// seedLineage saves a source image with an edit, a variation of the edit and
+// a deleted regeneration, plus an unrelated image
+func seedLineage(t testing.TB, env *testEnv) {
+	seedSource(t, env)
+	env.createImage(t, map[string]any{
+		"id":         "editedimage0001",
+		"parent_id":  testSourceImageID,
+		"prompt":     "a lighthouse at dawn",
+		"other_info": map[string]any{"seed": 1234, "lineage": map[string]any{"parent_id": testSourceImageID, "relation": "edit"}},
+	})
+	env.createImage(t, map[string]any{
+		"id":         "variation000001",
+		"parent_id":  "editedimage0001",
+		"other_info": map[string]any{"lineage": map[string]any{"parent_id": "editedimage0001", "relation": "variation"}},
+	})
+	env.createImage(t, map[string]any{
+		"id":         "regenerated0001",
+		"parent_id":  testSourceImageID,
+		"deleted_at": types.NowDateTime(),
+		"other_info": map[string]any{"lineage": map[string]any{"parent_id": testSourceImageID, "relation": "regenerate"}},
+	})
+	env.createImage(t, map[string]any{"id": "unrelated000001"})
+}
+
+func TestDeriveRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "edit reuses the seed and parameters",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/" + testSourceImageID + "/edit",
+			body:            `{"prompt":"a lighthouse at dawn"}`,
+			setup:           seedSource,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"parent_id":"sourceimage0001"`, `"seed":1234`, `"model":"flux/schnell"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				record := derivedImage(t, env)
+				assert.Equal(t, "a lighthouse at dawn", record.GetString("prompt"))
+
+				var info struct {
+					Seed       int64          `json:"seed"`
+					Parameters map[string]any `json:"parameters"`
+					Lineage    struct {
+						ParentID string `json:"parent_id"`
+						Relation string `json:"relation"`
+					} `json:"lineage"`
+				}
+				require.NoError(t, record.UnmarshalJSONField("other_info", &info))
+				assert.EqualValues(t, 1234, info.Seed)
+				assert.EqualValues(t, 1234, info.Parameters["seed"])
+				assert.EqualValues(t, 5, info.Parameters["guidance_scale"])
+				assert.EqualValues(t, 8, info.Parameters["num_inference_steps"])
+				assert.Equal(t, testSourceImageID, info.Lineage.ParentID)
+				assert.Equal(t, "edit", info.Lineage.Relation)
+			},
+		},
+		{
+			name:   "edit falls back to the requested seed",
+			method: http.MethodPost,
+			url:    "/api/custom/images/" + testSourceImageID + "/edit",
+			body:   `{"prompt":"a lighthouse at dawn"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				env.createImage(t, map[string]any{
+					"id":         testSourceImageID,
+					"other_info": map[string]any{"parameters": map[string]any{"seed": 77}},
+				})
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"seed":77`},
+		},
+		{
+			name:   "edit needs a recorded seed",
+			method: http.MethodPost,
+			url:    "/api/custom/images/" + testSourceImageID + "/edit",
+			body:   `{"prompt":"a lighthouse at dawn"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				env.createImage(t, map[string]any{"id": testSourceImageID, "other_info": map[string]any{}})
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"no recorded seed"},
+		},
+		{
+			name:   "imported images cannot be edited",
+			method: http.MethodPost,
+			url:    "/api/custom/images/" + testSourceImageID + "/edit",
+			body:   `{"prompt":"a lighthouse at dawn"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				env.createImage(t, map[string]any{"id": testSourceImageID, "model": "imported"})
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"supported model"},
+		},
+		{
+			name:   "other users' images cannot be edited",
+			method: http.MethodPost,
+			url:    "/api/custom/images/" + testSourceImageID + "/edit",
+			body:   `{"prompt":"a lighthouse at dawn"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				other := env.createUser(t, "someoneelse0001", "someone@test.com")
+				env.createImage(t, map[string]any{"id": testSourceImageID, "user_id": other.Id, "other_info": map[string]any{"seed": 1}})
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"code":"not_found"`},
+		},
+		{
+			name:            "edit requires a prompt",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/" + testSourceImageID + "/edit",
+			body:            `{}`,
+			setup:           seedSource,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"Prompt is required"},
+		},
+		{
+			name:            "regenerate keeps the parameters but not the seed",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/" + testSourceImageID + "/regenerate",
+			setup:           seedSource,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"relation":"regenerate"`, `"seed":42`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				record := derivedImage(t, env)
+				assert.Equal(t, "a lighthouse at dusk", record.GetString("prompt"))
+
+				settings := struct {
+					Parameters map[string]any `json:"parameters"`
+				}{}
+				require.NoError(t, record.UnmarshalJSONField("other_info", &settings))
+				assert.NotContains(t, settings.Parameters, "seed")
+				assert.EqualValues(t, 5, settings.Parameters["guidance_scale"])
+			},
+		},
+		{
+			name:            "variation overrides parameters and keeps the seed",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/" + testSourceImageID + "/variation",
+			body:            `{"parameters":{"guidance_scale":9}}`,
+			setup:           seedSource,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"relation":"variation"`, `"seed":1234`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				settings := struct {
+					Parameters map[string]any `json:"parameters"`
+				}{}
+				require.NoError(t, derivedImage(t, env).UnmarshalJSONField("other_info", &settings))
+				assert.EqualValues(t, 9, settings.Parameters["guidance_scale"])
+				assert.EqualValues(t, 8, settings.Parameters["num_inference_steps"])
+			},
+		},
+		{
+			name:            "variation validates the overrides",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/" + testSourceImageID + "/variation",
+			body:            `{"parameters":{"guidance_scale":90}}`,
+			setup:           seedSource,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"guidance_scale must be at most"},
+		},
+		{
+			name:            "variation needs a parameter to vary",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/" + testSourceImageID + "/variation",
+			setup:           seedSource,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"At least one parameter"},
+		},
+	})
+}
+
+func TestImageLineage(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "lineage returns the tree from the root",
+			method:             http.MethodGet,
+			url:                "/api/custom/images/variation000001/lineage",
+			setup:              seedLineage,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"image_id":"variation000001"`, `"nodes":4`},
+			notExpectedContent: []string{"unrelated000001"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				var resp localmodels.LineageResponse
+				decodeData(t, res, &resp)
+
+				root := resp.Root
+				assert.Equal(t, testSourceImageID, root.ID)
+				assert.EqualValues(t, 1234, root.Seed)
+				require.Len(t, root.Children, 2)
+
+				edit := root.Children[0]
+				assert.Equal(t, "edit", edit.Relation)
+				require.Len(t, edit.Children, 1)
+				assert.Equal(t, "variation", edit.Children[0].Relation)
+				assert.True(t, edit.Children[0].Current)
+
+				regenerated := root.Children[1]
+				assert.True(t, regenerated.Deleted)
+				assert.Nil(t, regenerated.Image)
+			},
+		},
+		{
+			name:   "lineage stops at ancestors the user cannot see",
+			method: http.MethodGet,
+			url:    "/api/custom/images/editedimage0001/lineage",
+			setup: func(t testing.TB, env *testEnv) {
+				seedLineage(t, env)
+				other := env.createUser(t, "someoneelse0001", "someone@test.com")
+				source, err := env.app.FindRecordById("images", testSourceImageID)
+				require.NoError(t, err)
+				source.Set("user_id", other.Id)
+				require.NoError(t, env.app.Save(source))
+			},
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"root":{"id":"editedimage0001"`, `"nodes":2`},
+			notExpectedContent: []string{`"id":"` + testSourceImageID + `"`},
+		},
+		{
+			name:            "lineage of an unknown image",
+			method:          http.MethodGet,
+			url:             "/api/custom/images/missingimage001/lineage",
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"code":"not_found"`},
+		},
+	})
+}
@@ -0,0 +1,313 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/devices"
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testDeviceID     = "laptop-3f9c2a"
+	testRefreshToken = devices.TokenPrefix + "00112233445566778899aabbccddeeff00112233445566778899aabbccddeeff"
+)
+
+// withRememberDays opts the seeded user into remembering devices for days
+func withRememberDays(days int) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		env.user.Set("remember_device_days", days)
+		require.NoError(t, env.app.Save(env.user))
+	}
+}
+
+// withRememberedDevice opts the seeded user in and stores testDeviceID as a
+// device whose refresh token is testRefreshToken
+func withRememberedDevice(t testing.TB, env *testEnv) {
+	withRememberDays(30)(t, env)
+
+	collection, err := env.app.FindCollectionByNameOrId(devices.Collection)
+	require.NoError(t, err)
+	encrypted, err := env.encService.Encrypt(testFALToken, testRefreshToken)
+	require.NoError(t, err)
+
+	tokenHash := sha256.Sum256([]byte(testRefreshToken))
+	deviceHash := sha256.Sum256([]byte(testDeviceID))
+
+	record := core.NewRecord(collection)
+	record.Set("id", "trusteddevice01")
+	record.Set("user_id", env.user.Id)
+	record.Set("name", "Work laptop")
+	record.Set("device_hash", hex.EncodeToString(deviceHash[:]))
+	record.Set("token_hash", hex.EncodeToString(tokenHash[:]))
+	record.Set("encrypted_token", encrypted.Encrypted)
+	record.Set("salt", encrypted.Salt)
+	record.Set("expires_at", time.Now().Add(30*24*time.Hour))
+	require.NoError(t, env.app.Save(record))
+}
+
+func TestDeviceStore(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+
+	store := devices.NewStore(env.app, env.encService)
+	month := 30 * 24 * time.Hour
+
+	token, device, err := store.Remember(env.user.Id, testDeviceID, "Phone", testFALToken, month)
+	require.NoError(t, err)
+	assert.Contains(t, token, devices.TokenPrefix)
+
+	record, err := env.app.FindRecordById(devices.Collection, device.ID)
+	require.NoError(t, err)
+	for _, field := range []string{"device_hash", "token_hash", "encrypted_token"} {
+		assert.NotContains(t, record.GetString(field), token[len(devices.TokenPrefix):], "refresh token must not be stored")
+		assert.NotContains(t, record.GetString(field), testFALToken, "FAL token must not be stored in plaintext")
+	}
+
+	_, _, _, err = store.Redeem(env.user.Id, "another-device", token, month)
+	assert.ErrorIs(t, err, devices.ErrInvalidToken, "tokens are bound to their device")
+	_, _, _, err = store.Redeem("someoneelse0001", testDeviceID, token, month)
+	assert.ErrorIs(t, err, devices.ErrInvalidToken)
+
+	falToken, rotated, _, err := store.Redeem(env.user.Id, testDeviceID, token, month)
+	require.NoError(t, err)
+	assert.Equal(t, testFALToken, falToken)
+	assert.NotEqual(t, token, rotated)
+
+	_, latest, _, err := store.Redeem(env.user.Id, testDeviceID, rotated, month)
+	require.NoError(t, err, "the replacement token works")
+
+	_, _, _, err = store.Redeem(env.user.Id, testDeviceID, rotated, month)
+	assert.ErrorIs(t, err, devices.ErrInvalidToken, "rotated tokens stop working")
+	_, _, _, err = store.Redeem(env.user.Id, testDeviceID, latest, month)
+	assert.ErrorIs(t, err, devices.ErrInvalidToken, "reusing a rotated token revokes the device")
+	listed, err := store.List(env.user.Id)
+	require.NoError(t, err)
+	assert.Empty(t, listed)
+
+	token, _, err = store.Remember(env.user.Id, testDeviceID, "Phone", testFALToken, month)
+	require.NoError(t, err)
+	_, _, _, err = store.Redeem(env.user.Id, testDeviceID, token, time.Nanosecond)
+	assert.ErrorIs(t, err, devices.ErrInvalidToken, "a lowered limit shortens the device")
+
+	listed, err = store.List(env.user.Id)
+	require.NoError(t, err)
+	assert.Empty(t, listed, "refused devices are forgotten")
+
+	_, again, err := store.Remember(env.user.Id, testDeviceID, "Phone", testFALToken, month)
+	require.NoError(t, err)
+	_, _, err = store.Remember(env.user.Id, testDeviceID, "Phone", testFALToken, month)
+	require.NoError(t, err)
+	listed, err = store.List(env.user.Id)
+	require.NoError(t, err)
+	assert.Len(t, listed, 1, "remembering a device again replaces it")
+
+	assert.Error(t, store.Revoke(env.user.Id, again.ID), "the replaced device is gone")
+	revoked, err := store.RevokeAll(env.user.Id)
+	require.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+}
+
+func TestDeviceRotationRace(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+
+	store := devices.NewStore(env.app, env.encService)
+	month := 30 * 24 * time.Hour
+	token, _, err := store.Remember(env.user.Id, testDeviceID, "Phone", testFALToken, month)
+	require.NoError(t, err)
+
+	const redeemers = 4
+	var wg sync.WaitGroup
+	var succeeded atomic.Int32
+	for i := 0; i < redeemers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, _, err := store.Redeem(env.user.Id, testDeviceID, token, month); err == nil {
+				succeeded.Add(1)
+			} else {
+				assert.ErrorIs(t, err, devices.ErrInvalidToken)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), succeeded.Load(), "a token rotates exactly once")
+	listed, err := store.List(env.user.Id)
+	require.NoError(t, err)
+	assert.Empty(t, listed, "the losing redeems reused a rotated token")
+}
+
+func TestDeviceRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "remembering is off by default",
+			method:          http.MethodPost,
+			url:             "/api/custom/auth/create-session",
+			body:            `{"password":"` + testPassword + `","remember_device":true,"device_id":"` + testDeviceID + `"}`,
+			headers:         authOnly,
+			setup:           withStoredToken,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"Remembering devices is turned off"},
+		},
+		{
+			name:   "create session remembers the device",
+			method: http.MethodPost,
+			url:    "/api/custom/auth/create-session",
+			body:   `{"password":"` + testPassword + `","remember_device":true,"device_id":"` + testDeviceID + `","device_name":"Work laptop"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withStoredToken(t, env)
+				withRememberDays(7)(t, env)
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"session_id":`, `"refresh_token":"grt_`, `"refresh_expires_at":`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				var resp localmodels.CreateSessionResponse
+				decodeData(t, res, &resp)
+				require.NotNil(t, resp.RefreshExpiresAt)
+				assert.WithinDuration(t, time.Now().Add(7*24*time.Hour), *resp.RefreshExpiresAt, time.Minute)
+
+				listed, err := devices.NewStore(env.app, env.encService).List(env.user.Id)
+				require.NoError(t, err)
+				require.Len(t, listed, 1)
+				assert.Equal(t, "Work laptop", listed[0].Name)
+			},
+		},
+		{
+			name:   "remembering needs a device id",
+			method: http.MethodPost,
+			url:    "/api/custom/auth/create-session",
+			body:   `{"password":"` + testPassword + `","remember_device":true}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withStoredToken(t, env)
+				withRememberDays(7)(t, env)
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"device_id is required"},
+		},
+		{
+			name:               "refresh recreates the session without the password",
+			method:             http.MethodPost,
+			url:                "/api/custom/auth/refresh",
+			body:               `{"refresh_token":"` + testRefreshToken + `","device_id":"` + testDeviceID + `"}`,
+			setup:              withRememberedDevice,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"session_id":`, `"refresh_token":"grt_`},
+			notExpectedContent: []string{testRefreshToken},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				session, err := env.sessionStore.GetUserSession(env.user.Id)
+				require.NoError(t, err)
+				assert.Equal(t, testFALToken, session.FALToken)
+			},
+		},
+		{
+			name:            "refresh tokens only work on their device",
+			method:          http.MethodPost,
+			url:             "/api/custom/auth/refresh",
+			body:            `{"refresh_token":"` + testRefreshToken + `","device_id":"stolen-elsewhere"}`,
+			setup:           withRememberedDevice,
+			headers:         authOnly,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{"Invalid or expired refresh token"},
+		},
+		{
+			name:   "refresh stops once the user turns remembering off",
+			method: http.MethodPost,
+			url:    "/api/custom/auth/refresh",
+			body:   `{"refresh_token":"` + testRefreshToken + `","device_id":"` + testDeviceID + `"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withRememberedDevice(t, env)
+				withRememberDays(0)(t, env)
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"Remembering devices is turned off"},
+		},
+		{
+			name:            "refresh requires login",
+			method:          http.MethodPost,
+			url:             "/api/custom/auth/refresh",
+			body:            `{"refresh_token":"` + testRefreshToken + `","device_id":"` + testDeviceID + `"}`,
+			setup:           withRememberedDevice,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{"Authentication required"},
+		},
+		{
+			name:               "list devices with the trade-off",
+			method:             http.MethodGet,
+			url:                "/api/custom/auth/devices",
+			setup:              withRememberedDevice,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"id":"trusteddevice01"`, `"name":"Work laptop"`, `"remember_days":30`, `"max_days":30`, `"warning":"`},
+			notExpectedContent: []string{"token_hash", "device_hash", "encrypted_token"},
+		},
+		{
+			name:            "remember days are capped by the deployment",
+			method:          http.MethodPut,
+			url:             "/api/custom/auth/devices/settings",
+			body:            `{"remember_days":365}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"remember_days must be between 0 and 30"},
+		},
+		{
+			name:            "turning remembering off forgets every device",
+			method:          http.MethodPut,
+			url:             "/api/custom/auth/devices/settings",
+			body:            `{"remember_days":0}`,
+			setup:           withRememberedDevice,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"remember_days":0`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				_, err := env.app.FindRecordById(devices.Collection, "trusteddevice01")
+				assert.Error(t, err)
+			},
+		},
+		{
+			name:            "revoke a device",
+			method:          http.MethodDelete,
+			url:             "/api/custom/auth/devices/trusteddevice01",
+			setup:           withRememberedDevice,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+		},
+		{
+			name:            "revoke an unknown device",
+			method:          http.MethodDelete,
+			url:             "/api/custom/auth/devices/missingdevice01",
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"device not found"},
+		},
+		{
+			name:            "a new FAL token forgets remembered devices",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/setup",
+			body:            `{"fal_token":"` + testFALToken + `","password":"` + testPassword + `"}`,
+			setup:           withRememberedDevice,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				_, err := env.app.FindRecordById(devices.Collection, "trusteddevice01")
+				assert.Error(t, err)
+			},
+		},
+	})
+}
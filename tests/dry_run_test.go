@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/fal"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withoutFALCalls seeds saved preferences and fails any generation that
+// reaches the FAL client
+func withoutFALCalls(t testing.TB, env *testEnv) {
+	withSavedPreferences(t, env)
+	env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+		return nil, &fal.FALError{Code: "generation_failed", Message: "FAL AI was called"}
+	})
+}
+
+func TestDryRunRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "dry run returns the request that would be submitted",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"hidream/hidream-i1-fast","prompt":"a lighthouse at dusk","parameters":{"num_images":2},"dry_run":true}`,
+			setup:              withoutFALCalls,
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"dry_run":true`, `"model":"hidream/hidream-i1-fast"`, `"num_inference_steps":8`, `"num_images":2`, `"priority":"interactive"`, `"estimated_cost":0.006`},
+			notExpectedContent: []string{"FAL AI was called", `"images"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				records, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				assert.Empty(t, records, "dry runs save nothing")
+			},
+		},
+		{
+			name:            "request parameters win over saved preferences",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"hidream/hidream-i1-fast","prompt":"a lighthouse at dusk","parameters":{"num_inference_steps":12},"dry_run":true}`,
+			setup:           withoutFALCalls,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"num_inference_steps":12`, `"estimated_cost":0.003`},
+		},
+		{
+			name:               "preferences of other users are not merged",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"hidream/hidream-i1-dev","prompt":"a lighthouse at dusk","dry_run":true}`,
+			setup:              withoutFALCalls,
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"parameters":{}`},
+			notExpectedContent: []string{`"seed":42`},
+		},
+		{
+			name:            "dry runs validate parameters",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk","parameters":{"num_images":9},"dry_run":true}`,
+			setup:           withoutFALCalls,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`, "num_images"},
+		},
+		{
+			name:            "dry runs reject unknown models",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"unknown/model","prompt":"a lighthouse at dusk","dry_run":true}`,
+			setup:           withoutFALCalls,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`Unsupported model \"unknown/model\"`},
+		},
+		{
+			name:            "dry runs still apply the content filter",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a terrorist attack","dry_run":true}`,
+			setup:           withoutFALCalls,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"content_policy_violation"`},
+		},
+	})
+}
@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResponseEnvelope(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "single resources are wrapped in data",
+			method:             http.MethodGet,
+			url:                "/api/custom/financial/stats",
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`{"data":{`, `"total_spent_micros":`},
+			notExpectedContent: []string{`"error":`, `"meta":`},
+		},
+		{
+			name:            "listings keep paging details in meta",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections/livefolder00001/images?limit=1",
+			setup:           seedTrashedRecords,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"data":[{`, `"meta":{`, `"next_cursor":`},
+		},
+		{
+			name:            "empty listings are empty arrays",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections",
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"data":[]`},
+		},
+		{
+			name:            "fields pick from data and keep meta",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections/livefolder00001/images?limit=1&fields=id",
+			setup:           seedTrashedRecords,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"data":[{"id":"`, `"next_cursor":`},
+		},
+		{
+			name:            "errors have null data and a coded error",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections",
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"data":null`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				var resp map[string]json.RawMessage
+				require.NoError(t, json.NewDecoder(res.Body).Decode(&resp))
+				assert.JSONEq(t, `null`, string(resp["data"]))
+				assert.JSONEq(t, `{"code":"authentication_error","message":"Authentication required"}`, string(resp["error"]))
+				assert.NotContains(t, resp, "meta")
+			},
+		},
+	})
+}
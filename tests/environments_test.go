@@ -0,0 +1,246 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/fal"
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testingFALToken = "fal_testing_key_4321"
+
+// withStoredTestingToken stores a production and a testing key, both
+// encrypted with the test password
+func withStoredTestingToken(t testing.TB, env *testEnv) {
+	env.storeEncryptedToken(t)
+	result, err := env.encService.Encrypt(testingFALToken, testPassword)
+	require.NoError(t, err)
+	env.user.Set("fal_token_testing", result.Encrypted+"."+result.Salt)
+	require.NoError(t, env.app.Save(env.user))
+}
+
+// withTestingKeySession creates a session holding both keys that uses the
+// given slot by default
+func withTestingKeySession(environment string) func(t testing.TB, env *testEnv) map[string]string {
+	return func(t testing.TB, env *testEnv) map[string]string {
+		headers := env.sessionHeaders(t)
+		require.NoError(t, env.sessionStore.SetTestingKey(headers["X-Session-ID"], testingFALToken, environment))
+		return headers
+	}
+}
+
+// withTestingHeader sends X-FAL-Environment: testing from a session that
+// defaults to production
+func withTestingHeader(t testing.TB, env *testEnv) map[string]string {
+	headers := withTestingKeySession(localmodels.EnvironmentProduction)(t, env)
+	headers["X-FAL-Environment"] = localmodels.EnvironmentTesting
+	return headers
+}
+
+// expectFALKey fails generations paid with any other key than token
+func expectFALKey(token string) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		env.falClient.SetGenerateImageFunc(func(ctx context.Context, used string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+			if used != token {
+				return nil, &fal.FALError{Code: "invalid_token", Message: "wrong key slot", Status: http.StatusUnauthorized}
+			}
+			return fal.NewMockClient().GenerateImage(ctx, used, req)
+		})
+	}
+}
+
+func TestSessionEnvironments(t *testing.T) {
+	session := &localmodels.Session{FALToken: testFALToken}
+
+	selected, err := session.ForEnvironment("")
+	require.NoError(t, err)
+	assert.Equal(t, localmodels.EnvironmentProduction, selected.Environment)
+	assert.Equal(t, testFALToken, selected.FALToken)
+
+	_, err = session.ForEnvironment(localmodels.EnvironmentTesting)
+	assert.ErrorContains(t, err, "no testing FAL AI key")
+	_, err = session.ForEnvironment("staging")
+	assert.ErrorContains(t, err, "environment must be")
+
+	session.TestingFALToken = testingFALToken
+	session.Environment = localmodels.EnvironmentTesting
+	selected, err = session.ForEnvironment("")
+	require.NoError(t, err)
+	assert.Equal(t, testingFALToken, selected.FALToken)
+	assert.Equal(t, testFALToken, session.FALToken, "the stored session is not changed")
+
+	selected, err = session.ForEnvironment(localmodels.EnvironmentProduction)
+	require.NoError(t, err)
+	assert.Equal(t, testFALToken, selected.FALToken)
+
+	session.Clear()
+	assert.Empty(t, session.TestingFALToken)
+}
+
+func TestEnvironmentRoutes(t *testing.T) {
+	assertTestingImage := func(t testing.TB, env *testEnv, res *http.Response) {
+		images, err := env.app.FindAllRecords("images")
+		require.NoError(t, err)
+		require.Len(t, images, 1)
+		var info map[string]interface{}
+		require.NoError(t, images[0].UnmarshalJSONField("other_info", &info))
+		assert.Equal(t, localmodels.EnvironmentTesting, info["environment"])
+
+		user, err := env.app.FindRecordById("generatio_users", env.user.Id)
+		require.NoError(t, err)
+		assert.NotContains(t, user.GetString("financial_data"), "total_images", "testing spend is left out of financial stats")
+	}
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "a testing key needs a production key first",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/setup",
+			body:            `{"fal_token":"` + testingFALToken + `","password":"` + testPassword + `","environment":"testing"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"Set up your production FAL token first"},
+		},
+		{
+			name:            "a testing key uses the production key's password",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/setup",
+			body:            `{"fal_token":"` + testingFALToken + `","password":"another password","environment":"testing"}`,
+			setup:           withStoredToken,
+			headers:         authOnly,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{"Invalid password"},
+		},
+		{
+			name:            "unknown environments are rejected",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/setup",
+			body:            `{"fal_token":"` + testingFALToken + `","password":"` + testPassword + `","environment":"staging"}`,
+			setup:           withStoredToken,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"environment must be"},
+		},
+		{
+			name:            "a testing key is stored next to the production key",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/setup",
+			body:            `{"fal_token":"` + testingFALToken + `","password":"` + testPassword + `","environment":"testing"}`,
+			setup:           withStoredToken,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"environment":"testing"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				user, err := env.app.FindRecordById("generatio_users", env.user.Id)
+				require.NoError(t, err)
+				assert.NotEmpty(t, user.GetString("fal_token_testing"))
+				assert.Equal(t, env.user.GetString("fal_token"), user.GetString("fal_token"))
+			},
+		},
+		{
+			name:            "sessions unlock both keys",
+			method:          http.MethodPost,
+			url:             "/api/custom/auth/create-session",
+			body:            `{"password":"` + testPassword + `","environment":"testing"}`,
+			setup:           withStoredTestingToken,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"environment":"testing"`, `"environments":["production","testing"]`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				session, err := env.sessionStore.GetUserSession(env.user.Id)
+				require.NoError(t, err)
+				assert.Equal(t, testFALToken, session.FALToken)
+				assert.Equal(t, testingFALToken, session.TestingFALToken)
+			},
+		},
+		{
+			name:            "a testing session needs a testing key",
+			method:          http.MethodPost,
+			url:             "/api/custom/auth/create-session",
+			body:            `{"password":"` + testPassword + `","environment":"testing"}`,
+			setup:           withStoredToken,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"No testing FAL token is set up"},
+		},
+		{
+			name:            "token status reports the testing key",
+			method:          http.MethodGet,
+			url:             "/api/custom/auth/token-status",
+			setup:           withStoredTestingToken,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"has_testing_token":true`},
+		},
+		{
+			name:            "a request can pick the testing key",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			setup:           expectFALKey(testingFALToken),
+			headers:         withTestingHeader,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"url":"https://mock-image-url.com/image.jpg"`},
+			after:           assertTestingImage,
+		},
+		{
+			name:            "a testing session uses the testing key by default",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			setup:           expectFALKey(testingFALToken),
+			headers:         withTestingKeySession(localmodels.EnvironmentTesting),
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"url":"https://mock-image-url.com/image.jpg"`},
+			after:           assertTestingImage,
+		},
+		{
+			name:    "production generations count towards financial stats",
+			method:  http.MethodPost,
+			url:     "/api/custom/generate/image",
+			body:    `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			setup:   expectFALKey(testFALToken),
+			headers: withTestingKeySession(localmodels.EnvironmentProduction),
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				user, err := env.app.FindRecordById("generatio_users", env.user.Id)
+				require.NoError(t, err)
+				assert.Contains(t, user.GetString("financial_data"), `"total_images":1`)
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"cost":0.003`},
+		},
+		{
+			name:   "picking a missing testing key is refused",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				headers := env.sessionHeaders(t)
+				headers["X-FAL-Environment"] = localmodels.EnvironmentTesting
+				return headers
+			},
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"no testing FAL AI key is set up"},
+		},
+		{
+			name:            "the testing key can be removed",
+			method:          http.MethodDelete,
+			url:             "/api/custom/tokens/testing",
+			setup:           withStoredTestingToken,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				user, err := env.app.FindRecordById("generatio_users", env.user.Id)
+				require.NoError(t, err)
+				assert.Empty(t, user.GetString("fal_token_testing"))
+				assert.NotEmpty(t, user.GetString("fal_token"))
+			},
+		},
+	})
+}
@@ -0,0 +1,119 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/errorlog"
+	"generatio-pb/internal/fal"
+	"generatio-pb/internal/fal/faltest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecentErrorLog(t *testing.T) {
+	log := errorlog.NewLog(3)
+	for i := 1; i <= 5; i++ {
+		log.Record("user1", errorlog.Entry{Code: fmt.Sprintf("error_%d", i)})
+	}
+	log.Record("user2", errorlog.Entry{Code: "other_user"})
+
+	recent := log.Recent("user1")
+	require.Len(t, recent, 3)
+	assert.Equal(t, "error_5", recent[0].Code, "newest first")
+	assert.Equal(t, "error_3", recent[2].Code, "the oldest are dropped")
+	assert.False(t, recent[0].Time.IsZero())
+
+	log.Clear("user1")
+	assert.Empty(t, log.Recent("user1"))
+	assert.Len(t, log.Recent("user2"), 1)
+
+	disabled := errorlog.NewLog(0)
+	disabled.Record("user1", errorlog.Entry{Code: "ignored"})
+	assert.Empty(t, disabled.Recent("user1"))
+}
+
+func TestFALClientErrorRequestID(t *testing.T) {
+	client, _ := newFakeFALClient(t, faltest.Scenario{Fail: true})
+	_, err := client.GenerateImage(context.Background(), testFALToken, fal.GenerationRequest{Model: "flux/schnell", Prompt: "a lighthouse"})
+
+	var falErr *fal.FALError
+	require.ErrorAs(t, err, &falErr)
+	assert.Equal(t, "generation_failed", falErr.Code)
+	assert.Equal(t, "fake_request_1", falErr.RequestID)
+}
+
+// withRecentError records a failed generation for the seeded user
+func withRecentError(t testing.TB, env *testEnv) {
+	env.handler.RecentErrors().Record(env.user.Id, errorlog.Entry{
+		Code:      "generation_failed",
+		Message:   "model worker crashed",
+		RequestID: "fal_request_42",
+		Model:     "flux/schnell",
+	})
+}
+
+func TestRecentErrorRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:   "failed generations are recorded",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+					return nil, &fal.FALError{Code: "generation_failed", Message: "model worker crashed", RequestID: "fal_request_42"}
+				})
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusBadGateway,
+			expectedContent: []string{`"code":"fal_generation_failed"`, `"request_id":"fal_request_42"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				recent := env.handler.RecentErrors().Recent(env.user.Id)
+				require.Len(t, recent, 1)
+				assert.Equal(t, "generation_failed", recent[0].Code)
+				assert.Equal(t, "fal_request_42", recent[0].RequestID)
+				assert.Equal(t, "flux/schnell", recent[0].Model)
+			},
+		},
+		{
+			name:            "users see their recent errors",
+			method:          http.MethodGet,
+			url:             "/api/custom/debug/errors",
+			before:          withRecentError,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"code":"generation_failed"`, `"request_id":"fal_request_42"`, `"message":"model worker crashed"`, `"limit":20`},
+		},
+		{
+			name:            "no errors yet",
+			method:          http.MethodGet,
+			url:             "/api/custom/debug/errors",
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"data":[]`},
+		},
+		{
+			name:            "recent errors can be cleared",
+			method:          http.MethodDelete,
+			url:             "/api/custom/debug/errors",
+			before:          withRecentError,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Empty(t, env.handler.RecentErrors().Recent(env.user.Id))
+			},
+		},
+		{
+			name:            "recent errors require login",
+			method:          http.MethodGet,
+			url:             "/api/custom/debug/errors",
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{"Authentication required"},
+		},
+	})
+}
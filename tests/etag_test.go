@@ -0,0 +1,100 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestETagRoutes(t *testing.T) {
+	var modelsTag, preferencesTag string
+
+	// ifNoneMatch sends the tag an earlier scenario captured
+	ifNoneMatch := func(tag *string) func(t testing.TB, env *testEnv) map[string]string {
+		return func(t testing.TB, env *testEnv) map[string]string {
+			require.NotEmpty(t, *tag, "an earlier scenario captures the tag")
+			headers := env.authHeaders()
+			headers["If-None-Match"] = *tag
+			return headers
+		}
+	}
+
+	// Probe outcomes change the model list, so background probes are disabled
+	withoutProbes := func(t testing.TB, env *testEnv) {
+		env.cfg.ModelProbeInterval = 0
+	}
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "models carry an ETag",
+			method:          http.MethodGet,
+			url:             "/api/custom/generate/models",
+			headers:         authOnly,
+			setup:           withoutProbes,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{"flux/schnell"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				modelsTag = res.Header.Get("ETag")
+				assert.Regexp(t, `^"[0-9a-f]{32}"$`, modelsTag)
+				assert.Equal(t, "private, no-cache", res.Header.Get("Cache-Control"))
+			},
+		},
+		{
+			name:           "unchanged models are not sent again",
+			method:         http.MethodGet,
+			url:            "/api/custom/generate/models",
+			headers:        ifNoneMatch(&modelsTag),
+			setup:          withoutProbes,
+			expectedStatus: http.StatusNotModified,
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				body, _ := io.ReadAll(res.Body)
+				assert.Empty(t, body)
+				assert.Equal(t, modelsTag, res.Header.Get("ETag"))
+			},
+		},
+		{
+			name:   "stale tags get the full response",
+			method: http.MethodGet,
+			url:    "/api/custom/generate/models",
+			setup:  withoutProbes,
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				headers := env.authHeaders()
+				headers["If-None-Match"] = `"0123456789abcdef0123456789abcdef", W/"fedcba9876543210"`
+				return headers
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{"flux/schnell"},
+		},
+		{
+			name:            "preferences carry an ETag",
+			method:          http.MethodGet,
+			url:             "/api/custom/preferences?model_name=fal-ai/flux/schnell",
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model_name":"fal-ai/flux/schnell"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				preferencesTag = res.Header.Get("ETag")
+				assert.NotEmpty(t, preferencesTag)
+			},
+		},
+		{
+			name:           "posted preference reads honor If-None-Match",
+			method:         http.MethodPost,
+			url:            "/api/custom/preferences/get",
+			body:           `{"model_name":"fal-ai/flux/schnell"}`,
+			headers:        ifNoneMatch(&preferencesTag),
+			expectedStatus: http.StatusNotModified,
+		},
+		{
+			name:            "another model's preferences have another tag",
+			method:          http.MethodGet,
+			url:             "/api/custom/preferences?model_name=fal-ai/flux/dev",
+			headers:         ifNoneMatch(&preferencesTag),
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model_name":"fal-ai/flux/dev"`},
+		},
+	})
+}
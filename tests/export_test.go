@@ -0,0 +1,101 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/require"
+)
+
+// seedExport creates two generations a day apart, generation01 older, and
+// images that are not generations
+func seedExport(t testing.TB, env *testEnv) {
+	yesterday, err := types.ParseDateTime(time.Now().AddDate(0, 0, -1))
+	require.NoError(t, err)
+
+	env.createImage(t, map[string]any{
+		"id":         "generation00001",
+		"prompt":     "a red fox",
+		"model":      "fal-ai/flux/dev",
+		"created":    yesterday,
+		"image_size": map[string]any{"width": 512, "height": 512},
+		"other_info": map[string]any{"seed": 42, "cost_usd": 0.01, "parameters": map[string]any{"num_inference_steps": 28, "seed": 7}},
+	})
+	env.createImage(t, map[string]any{
+		"id":         "generation00002",
+		"prompt":     "a blue whale",
+		"model":      "fal-ai/flux/schnell",
+		"favorite":   true,
+		"other_info": map[string]any{"parameters": map[string]any{"guidance_scale": 3.5}},
+	})
+	env.createImage(t, map[string]any{"id": "importedimage01", "other_info": map[string]any{"source": "import"}})
+	env.createImage(t, map[string]any{"id": "duplicatedimg01", "other_info": map[string]any{"source": "duplicate"}})
+	env.createImage(t, map[string]any{"id": "trashedimage001", "deleted_at": types.NowDateTime()})
+	env.createImage(t, map[string]any{"id": "orgimage0000001", "org_id": "someorg00000001"})
+}
+
+func TestPromptExportRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:           "generations export as JSONL newest first",
+			method:         http.MethodGet,
+			url:            "/api/custom/images/export",
+			setup:          seedExport,
+			headers:        authOnly,
+			expectedStatus: http.StatusOK,
+			expectedContent: []string{
+				`{"id":"generation00002","prompt":"a blue whale","model":"fal-ai/flux/schnell","parameters":{"guidance_scale":3.5}`,
+				`}` + "\n" + `{"id":"generation00001","prompt":"a red fox","model":"fal-ai/flux/dev","seed":42,"parameters":{"num_inference_steps":28},"image_size":{"height":512,"width":512}`,
+			},
+			notExpectedContent: []string{"importedimage01", "duplicatedimg01", "trashedimage001", "orgimage0000001", "cost_usd"},
+		},
+		{
+			name:               "query parameters filter the export",
+			method:             http.MethodGet,
+			url:                "/api/custom/images/export?model=flux/dev",
+			setup:              seedExport,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"id":"generation00001"`},
+			notExpectedContent: []string{"generation00002"},
+		},
+		{
+			name:   "smart collections select what is exported",
+			method: http.MethodGet,
+			url:    "/api/custom/images/export?smart_collection=smartfolder0001",
+			setup: func(t testing.TB, env *testEnv) {
+				withSmartFolder(t, env)
+				seedExport(t, env)
+			},
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"id":"fluxportrait001"`},
+			notExpectedContent: []string{"generation00001", "generation00002", "sdxlportrait001"},
+		},
+		{
+			name:            "unknown smart collections are not found",
+			method:          http.MethodGet,
+			url:             "/api/custom/images/export?smart_collection=missing",
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"Smart collection not found"},
+		},
+		{
+			name:            "the window is bounded",
+			method:          http.MethodGet,
+			url:             "/api/custom/images/export?days=0",
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"days must be between 1 and 3650"},
+		},
+		{
+			name:            "authentication is required",
+			method:          http.MethodGet,
+			url:             "/api/custom/images/export",
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{"Authentication required"},
+		},
+	})
+}
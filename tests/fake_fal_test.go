@@ -0,0 +1,64 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/fal"
+)
+
+// withFakeFAL turns on fake FAL mode while the configured client would fail
+// every call, so passing scenarios prove the built-in fake answered
+func withFakeFAL(t testing.TB, env *testEnv) {
+	env.cfg.FakeFAL = true
+	env.falClient.SetValidateTokenFunc(func(ctx context.Context, token string) error {
+		return &fal.FALError{Code: "invalid_token", Message: "real FAL AI was called"}
+	})
+	env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+		return nil, &fal.FALError{Code: "generation_failed", Message: "real FAL AI was called"}
+	})
+	env.falClient.SetProbeModelFunc(unreachableProbe)
+}
+
+func TestFakeFALRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "generation uses the built-in fake",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			setup:              withFakeFAL,
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"model":"flux/schnell"`, `"url":"https://mock-image-url.com/image.jpg"`},
+			notExpectedContent: []string{"real FAL AI was called"},
+		},
+		{
+			name:               "any FAL key is accepted",
+			method:             http.MethodPost,
+			url:                "/api/custom/tokens/setup",
+			body:               `{"fal_token":"not-a-paid-key","password":"` + testPassword + `"}`,
+			setup:              withFakeFAL,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"success":true`},
+			notExpectedContent: []string{"real FAL AI was called"},
+		},
+		{
+			name:            "health tells frontends about fake mode",
+			method:          http.MethodGet,
+			url:             "/api/custom/health",
+			setup:           withFakeFAL,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"status":"ok"`, `"fake_fal":true`},
+		},
+		{
+			name:            "fake mode is off by default",
+			method:          http.MethodGet,
+			url:             "/api/custom/health",
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"fake_fal":false`},
+		},
+	})
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/fal"
+	"generatio-pb/internal/fal/faltest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFALErrorClassification(t *testing.T) {
+	req := fal.GenerationRequest{Model: "flux/schnell", Prompt: "a lighthouse at dusk"}
+
+	// Errors produced by the real client against the fake queue
+	cases := []struct {
+		name      string
+		scenario  faltest.Scenario
+		token     string
+		timeout   time.Duration
+		code      string
+		retryable bool
+	}{
+		{"Validation", faltest.Scenario{SubmitStatus: http.StatusUnprocessableEntity, SubmitBody: `{"detail":"image_size is invalid"}`}, testFALToken, 0, fal.ErrorCodeInvalidRequest, false},
+		{"Auth", faltest.Scenario{}, "invalid_token", 0, fal.ErrorCodeAuth, false},
+		{"RateLimit", faltest.Scenario{RateLimitSubmits: 1}, testFALToken, 0, fal.ErrorCodeRateLimited, true},
+		{"ServerError", faltest.Scenario{SubmitStatus: http.StatusBadGateway, SubmitBody: "upstream unavailable"}, testFALToken, 0, fal.ErrorCodeUnavailable, true},
+		{"ModelFailure", faltest.Scenario{Fail: true}, testFALToken, 0, fal.ErrorCodeGenerationFailed, true},
+		{"Timeout", faltest.Scenario{NeverComplete: true}, testFALToken, 50 * time.Millisecond, fal.ErrorCodeTimeout, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			client, _ := newFakeFALClient(t, c.scenario)
+			if c.timeout > 0 {
+				client.SetTimeout(c.timeout)
+			}
+			_, err := client.GenerateImage(context.Background(), c.token, req)
+
+			classified := fal.Classify(err)
+			assert.Equal(t, c.code, classified.Code)
+			assert.Equal(t, c.retryable, classified.Retryable)
+			assert.NotEmpty(t, classified.Message)
+			assert.NotZero(t, classified.Status)
+		})
+	}
+
+	t.Run("Unreachable", func(t *testing.T) {
+		client, server := newFakeFALClient(t, faltest.Scenario{})
+		server.Close()
+		_, err := client.GenerateImage(context.Background(), testFALToken, req)
+		assert.ErrorIs(t, err, fal.ErrUnreachable)
+		assert.Equal(t, fal.ErrorCodeUnavailable, fal.Classify(err).Code)
+	})
+
+	t.Run("LocalValidation", func(t *testing.T) {
+		client, _ := newFakeFALClient(t, faltest.Scenario{})
+		_, err := client.GenerateImage(context.Background(), testFALToken, fal.GenerationRequest{
+			Model: "flux/schnell", Prompt: "a lighthouse", Parameters: map[string]interface{}{"num_images": 9},
+		})
+		classified := fal.Classify(err)
+		assert.Equal(t, fal.ErrorCodeInvalidRequest, classified.Code)
+		assert.Equal(t, http.StatusUnprocessableEntity, classified.Status)
+	})
+
+	t.Run("OtherErrors", func(t *testing.T) {
+		assert.Equal(t, fal.ErrorCodeCancelled, fal.Classify(fmt.Errorf("polling: %w", context.Canceled)).Code)
+		assert.Equal(t, fal.ErrorCodeUnknown, fal.Classify(errors.New("something odd")).Code)
+		assert.Equal(t, fal.ErrorCodeUnknown, fal.Classify(&fal.FALError{Code: "brand_new_code"}).Code)
+	})
+}
+
+// failGenerationWith makes every generation fail with err
+func failGenerationWith(err error) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+			return nil, err
+		})
+	}
+}
+
+func TestFALErrorRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "rate limits are retryable",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			setup:              failGenerationWith(&fal.FALError{Code: "http_error", Message: `HTTP 429: {"detail":"slow down"}`, Status: http.StatusTooManyRequests}),
+			headers:            withSession,
+			expectedStatus:     http.StatusTooManyRequests,
+			expectedContent:    []string{`"code":"fal_rate_limited"`, `"retryable":true`},
+			notExpectedContent: []string{"slow down"},
+		},
+		{
+			name:            "rejected parameters explain why and are not retryable",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			setup:           failGenerationWith(&fal.FALError{Code: "parameter_out_of_range", Message: "num_images must be at most 4"}),
+			headers:         withSession,
+			expectedStatus:  http.StatusUnprocessableEntity,
+			expectedContent: []string{`"code":"fal_invalid_request"`, `"retryable":false`, `"reason":"num_images must be at most 4"`},
+		},
+		{
+			name:               "rejected tokens are reported without FAL's wording",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			setup:              failGenerationWith(&fal.FALError{Code: "unauthorized", Message: "key sk-live-123 revoked", Status: http.StatusUnauthorized}),
+			headers:            withSession,
+			expectedStatus:     http.StatusBadGateway,
+			expectedContent:    []string{`"code":"fal_auth"`, `"retryable":false`},
+			notExpectedContent: []string{"sk-live-123"},
+		},
+		{
+			name:            "unreachable FAL AI is retryable",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			setup:           failGenerationWith(fmt.Errorf("%w: failed to send request: connection refused", fal.ErrUnreachable)),
+			headers:         withSession,
+			expectedStatus:  http.StatusBadGateway,
+			expectedContent: []string{`"code":"fal_unavailable"`, `"retryable":true`},
+		},
+	})
+}
@@ -0,0 +1,285 @@
+package tests
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/feeds"
+	"generatio-pb/internal/folderacl"
+	"generatio-pb/internal/moderation"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Tokens of the feeds withFeeds publishes
+const (
+	testGalleryToken = "gallerytoken0001"
+	testFolderToken  = "foldertoken00001"
+)
+
+// withFeeds publishes the seeded user's gallery and public folder, with
+// images in and out of them. imageURL is where the image files are served.
+func withFeeds(imageURL string) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		folders, err := env.app.FindCollectionByNameOrId("folders")
+		require.NoError(t, err)
+		for id, private := range map[string]bool{"galleryfolder01": false, "privatefolder01": true} {
+			folder := core.NewRecord(folders)
+			folder.Id = id
+			folder.Set("name", "Landscapes")
+			folder.Set("user_id", env.user.Id)
+			folder.Set("private", private)
+			require.NoError(t, env.app.Save(folder))
+		}
+
+		now := time.Now().UTC()
+		for _, image := range []map[string]any{
+			{"id": "feedimage000001", "folder_id": "galleryfolder01", "prompt": "a misty <forest>", "created": types.NowDateTime().Add(-time.Hour)},
+			{"id": "feedimage000002", "folder_id": "galleryfolder01", "title": "Harbour at dawn"},
+			{"id": "privateimage001", "folder_id": "privatefolder01"},
+			{"id": "looseimage00001"},
+			{"id": "quarantined0001", "folder_id": "galleryfolder01", "moderation_status": moderation.StatusQuarantined},
+			{"id": "trashedimage001", "folder_id": "galleryfolder01", "deleted_at": now},
+		} {
+			image["url"] = imageURL
+			env.createImage(t, image)
+		}
+
+		collection, err := env.app.FindCollectionByNameOrId(feeds.Collection)
+		require.NoError(t, err)
+		for token, folderID := range map[string]string{testGalleryToken: "", testFolderToken: "galleryfolder01"} {
+			record := core.NewRecord(collection)
+			record.Set("user_id", env.user.Id)
+			record.Set("folder_id", folderID)
+			record.Set("title", "My gallery")
+			record.Set("token", token)
+			require.NoError(t, env.app.Save(record))
+		}
+	}
+}
+
+func TestFeedRendering(t *testing.T) {
+	channel := feeds.Channel{Title: "My gallery", Link: "https://generatio.test/api/custom/shared/feeds/abc", Updated: time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)}
+	items := []feeds.Item{{
+		ID:        "feedimage000001",
+		Title:     "a misty <forest>",
+		Link:      "https://generatio.test/api/custom/shared/feeds/abc/images/feedimage000001",
+		Prompt:    "a misty <forest>",
+		Model:     "flux/schnell",
+		Published: time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC),
+	}}
+
+	t.Run("RSS", func(t *testing.T) {
+		body, err := feeds.Render(feeds.FormatRSS, channel, items)
+		require.NoError(t, err)
+		require.NoError(t, xml.Unmarshal(body, new(struct{})))
+
+		doc := string(body)
+		assert.True(t, strings.HasPrefix(doc, xml.Header))
+		assert.Contains(t, doc, `<rss version="2.0" xmlns:media="http://search.yahoo.com/mrss/" xmlns:atom="http://www.w3.org/2005/Atom">`)
+		assert.Contains(t, doc, `<guid isPermaLink="false">feedimage000001</guid>`)
+		assert.Contains(t, doc, `<pubDate>Fri, 01 May 2026 12:00:00 +0000</pubDate>`)
+		assert.Contains(t, doc, `<media:content url="https://generatio.test/api/custom/shared/feeds/abc/images/feedimage000001" medium="image"></media:content>`)
+		// The prompt is escaped in the HTML description, which is escaped again in the XML
+		assert.Contains(t, doc, `a misty &amp;lt;forest&amp;gt;`)
+	})
+
+	t.Run("Atom", func(t *testing.T) {
+		body, err := feeds.Render(feeds.FormatAtom, channel, items)
+		require.NoError(t, err)
+		require.NoError(t, xml.Unmarshal(body, new(struct{})))
+
+		doc := string(body)
+		assert.Contains(t, doc, `<feed xmlns="http://www.w3.org/2005/Atom">`)
+		assert.Contains(t, doc, `<id>urn:generatio:image:feedimage000001</id>`)
+		assert.Contains(t, doc, `<updated>2026-05-01T12:00:00Z</updated>`)
+		assert.Contains(t, doc, `<link href="https://generatio.test/api/custom/shared/feeds/abc/images/feedimage000001" rel="enclosure"></link>`)
+	})
+}
+
+func TestFeedRoutes(t *testing.T) {
+	origin, _ := newImageOrigin(t)
+	imageURL := origin.URL + "/image.png"
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "the gallery is published",
+			method:          http.MethodPost,
+			url:             "/api/custom/feeds",
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"title":"Generatio gallery"`, `/api/custom/shared/feeds/`},
+		},
+		{
+			name:            "publishing a target again returns its feed",
+			method:          http.MethodPost,
+			url:             "/api/custom/feeds",
+			body:            `{"folder_id":"galleryfolder01"}`,
+			setup:           withFeeds(imageURL),
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"folder_id":"galleryfolder01"`, `/api/custom/shared/feeds/` + testFolderToken},
+		},
+		{
+			name:            "only folder owners can publish a folder",
+			method:          http.MethodPost,
+			url:             "/api/custom/feeds",
+			body:            `{"folder_id":"` + testFolderID + `"}`,
+			setup:           withFolderRole(folderacl.RoleEditor),
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"Only the folder owner can do this"},
+		},
+		{
+			name:            "private folders cannot be published",
+			method:          http.MethodPost,
+			url:             "/api/custom/feeds",
+			body:            `{"folder_id":"privatefolder01"}`,
+			setup:           withFeeds(imageURL),
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"Private folders cannot be published"},
+		},
+		{
+			name:           "the gallery feed lists images in public folders without auth",
+			method:         http.MethodGet,
+			url:            "/api/custom/shared/feeds/" + testGalleryToken,
+			setup:          withFeeds(imageURL),
+			expectedStatus: http.StatusOK,
+			expectedContent: []string{
+				`<title>My gallery</title>`,
+				`<title>Harbour at dawn</title>`,
+				`/api/custom/shared/feeds/` + testGalleryToken + `/images/feedimage000001`,
+				`/api/custom/shared/feeds/` + testGalleryToken + `/images/feedimage000002`,
+			},
+			notExpectedContent: []string{"privateimage001", "looseimage00001", "quarantined0001", "trashedimage001"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, feeds.ContentTypeRSS, res.Header.Get("Content-Type"))
+				assert.True(t, strings.HasPrefix(res.Header.Get("Cache-Control"), "public"))
+			},
+		},
+		{
+			name:            "feeds are also served as Atom",
+			method:          http.MethodGet,
+			url:             "/api/custom/shared/feeds/" + testFolderToken + "?format=atom",
+			setup:           withFeeds(imageURL),
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`<feed xmlns="http://www.w3.org/2005/Atom">`, `urn:generatio:image:feedimage000002`},
+		},
+		{
+			name:            "unknown formats are rejected",
+			method:          http.MethodGet,
+			url:             "/api/custom/shared/feeds/" + testFolderToken + "?format=json",
+			setup:           withFeeds(imageURL),
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`format must be \"rss\" or \"atom\"`},
+		},
+		{
+			name:   "folder feeds stop once the folder is made private",
+			method: http.MethodGet,
+			url:    "/api/custom/shared/feeds/" + testFolderToken,
+			setup: func(t testing.TB, env *testEnv) {
+				withFeeds(imageURL)(t, env)
+				folder, err := env.app.FindRecordById("folders", "galleryfolder01")
+				require.NoError(t, err)
+				folder.Set("private", true)
+				require.NoError(t, env.app.Save(folder))
+			},
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"Feed not found"},
+		},
+		{
+			name:            "feed images are served without auth",
+			method:          http.MethodGet,
+			url:             "/api/custom/shared/feeds/" + testGalleryToken + "/images/feedimage000001",
+			setup:           withFeeds(imageURL),
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{"PNG"},
+		},
+		{
+			name:            "images outside the feed are not served",
+			method:          http.MethodGet,
+			url:             "/api/custom/shared/feeds/" + testGalleryToken + "/images/privateimage001",
+			setup:           withFeeds(imageURL),
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"Image not found"},
+		},
+		{
+			name:   "the widget lists the newest images as compact JSON for any origin",
+			method: http.MethodGet,
+			url:    "/api/custom/shared/feeds/" + testGalleryToken + "/widget?limit=1",
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				return map[string]string{"Origin": "https://blog.example"}
+			},
+			setup:          withFeeds(imageURL),
+			expectedStatus: http.StatusOK,
+			expectedContent: []string{
+				`"title":"My gallery"`,
+				`/api/custom/shared/feeds/` + testGalleryToken + `"`,
+				`"title":"Harbour at dawn"`,
+				`/api/custom/shared/feeds/` + testGalleryToken + `/images/feedimage000002?size=128\u0026format=jpeg"`,
+				`/api/custom/shared/feeds/` + testGalleryToken + `/images/feedimage000002"`,
+			},
+			notExpectedContent: []string{"feedimage000001", "privateimage001"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, "*", res.Header.Get("Access-Control-Allow-Origin"))
+				assert.True(t, strings.HasPrefix(res.Header.Get("Cache-Control"), "public"))
+				assert.NotEmpty(t, res.Header.Get("ETag"))
+			},
+		},
+		{
+			name:            "widget thumbnails are served through the feed",
+			method:          http.MethodGet,
+			url:             "/api/custom/shared/feeds/" + testGalleryToken + "/images/feedimage000001?size=128&format=jpeg",
+			setup:           withFeeds(imageURL),
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{"\xff\xd8"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, "image/jpeg", res.Header.Get("Content-Type"))
+			},
+		},
+		{
+			name:            "widgets of unknown feeds are not found",
+			method:          http.MethodGet,
+			url:             "/api/custom/shared/feeds/unknowntoken001/widget",
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"Feed not found"},
+		},
+		{
+			name:   "revoked feeds are not found",
+			method: http.MethodGet,
+			url:    "/api/custom/shared/feeds/" + testGalleryToken,
+			setup: func(t testing.TB, env *testEnv) {
+				withFeeds(imageURL)(t, env)
+				record, err := env.app.FindFirstRecordByData(feeds.Collection, "token", testGalleryToken)
+				require.NoError(t, err)
+				require.NoError(t, feeds.NewStore(env.app).Delete(record.Id, env.user.Id))
+			},
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"Feed not found"},
+		},
+		{
+			name:   "other users cannot revoke a feed",
+			method: http.MethodDelete,
+			url:    "/api/custom/feeds/strangerfeed001",
+			setup: func(t testing.TB, env *testEnv) {
+				collection, err := env.app.FindCollectionByNameOrId(feeds.Collection)
+				require.NoError(t, err)
+				record := core.NewRecord(collection)
+				record.Id = "strangerfeed001"
+				record.Set("user_id", "someoneelse0001")
+				record.Set("token", "strangertoken01")
+				require.NoError(t, env.app.Save(record))
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"Feed not found"},
+		},
+	})
+}
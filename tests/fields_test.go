@@ -0,0 +1,53 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/moderation"
+)
+
+func TestSparseFieldsRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "fields trim every listed item",
+			method:             http.MethodGet,
+			url:                "/api/custom/collections/sharedfolder001/images?limit=2&fields=id,url",
+			setup:              withFolderImages,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`{"id":"pageimage000005","url":"https://mock-image-url.com/image.jpg"}`, `"role":"owner"`, `"next_cursor":"`},
+			notExpectedContent: []string{`"moderation_status"`, `"status"`},
+		},
+		{
+			name:               "unknown fields are left out",
+			method:             http.MethodGet,
+			url:                "/api/custom/collections/sharedfolder001/images?fields=id,nonexistent",
+			setup:              withFolderImages,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`{"id":"pageimage000001"}`},
+			notExpectedContent: []string{"nonexistent"},
+		},
+		{
+			name:   "fields apply to other listings",
+			method: http.MethodGet,
+			url:    "/api/custom/images/quarantine?fields=prompt",
+			setup: func(t testing.TB, env *testEnv) {
+				env.createImage(t, map[string]any{"moderation_status": moderation.StatusQuarantined, "prompt": "held back"})
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"data":[{"prompt":"held back"}]`},
+		},
+		{
+			name:            "malformed fields are rejected",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections/sharedfolder001/images?fields=prompt:unknown(1)",
+			setup:           withFolderImages,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"Invalid fields parameter"},
+		},
+	})
+}
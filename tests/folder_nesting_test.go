@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/folderacl"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nestedFolderID is the id of the folder at depth in the chain from withFolderChain
+func nestedFolderID(depth int) string {
+	return fmt.Sprintf("nestedfolder%03d", depth)
+}
+
+// seedFolder creates a folder owned by the seeded user
+func seedFolder(t testing.TB, env *testEnv, id, parentID string) {
+	folders, err := env.app.FindCollectionByNameOrId("folders")
+	require.NoError(t, err)
+	folder := core.NewRecord(folders)
+	folder.Id = id
+	folder.Set("name", "Folder "+id)
+	folder.Set("user_id", env.user.Id)
+	folder.Set("parent_id", parentID)
+	require.NoError(t, env.app.Save(folder))
+}
+
+// withFolderChain seeds the maximum of 10 folders, each nested in the one
+// before, and a separate root folder
+func withFolderChain(t testing.TB, env *testEnv) {
+	for depth := 1; depth <= 10; depth++ {
+		parentID := ""
+		if depth > 1 {
+			parentID = nestedFolderID(depth - 1)
+		}
+		seedFolder(t, env, nestedFolderID(depth), parentID)
+	}
+	seedFolder(t, env, "otherroot000001", "")
+}
+
+// assertParent checks a folder's parent_id after a request
+func assertParent(folderID, parentID string) func(t testing.TB, env *testEnv, res *http.Response) {
+	return func(t testing.TB, env *testEnv, res *http.Response) {
+		folder, err := env.app.FindRecordById("folders", folderID)
+		require.NoError(t, err)
+		assert.Equal(t, parentID, folder.GetString("parent_id"))
+	}
+}
+
+func TestFolderNestingRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "subfolders can be created up to the maximum depth",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/create",
+			body:            `{"name":"Deepest","parent_id":"` + nestedFolderID(9) + `"}`,
+			setup:           withFolderChain,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"name":"Deepest"`},
+		},
+		{
+			name:            "subfolders beyond the maximum depth are rejected",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/create",
+			body:            `{"name":"Too deep","parent_id":"` + nestedFolderID(10) + `"}`,
+			setup:           withFolderChain,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`, "at most 10 levels deep"},
+		},
+		{
+			name:   "subfolders of folders in an existing cycle are rejected",
+			method: http.MethodPost,
+			url:    "/api/custom/collections/create",
+			body:   `{"name":"Inside","parent_id":"cyclefolder0001"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				seedFolder(t, env, "cyclefolder0001", "cyclefolder0002")
+				seedFolder(t, env, "cyclefolder0002", "cyclefolder0001")
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`, "inside itself"},
+		},
+		{
+			name:            "folders move with their subfolders",
+			method:          http.MethodPut,
+			url:             "/api/custom/collections/" + nestedFolderID(8) + "/move",
+			body:            `{"parent_id":"otherroot000001"}`,
+			setup:           withFolderChain,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`, `"parent_id":"otherroot000001"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assertParent(nestedFolderID(8), "otherroot000001")(t, env, res)
+				assertParent(nestedFolderID(9), nestedFolderID(8))(t, env, res)
+			},
+		},
+		{
+			name:            "folders can move to the root level",
+			method:          http.MethodPut,
+			url:             "/api/custom/collections/" + nestedFolderID(5) + "/move",
+			body:            `{}`,
+			setup:           withFolderChain,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after:           assertParent(nestedFolderID(5), ""),
+		},
+		{
+			name:            "a folder cannot be its own parent",
+			method:          http.MethodPut,
+			url:             "/api/custom/collections/" + nestedFolderID(3) + "/move",
+			body:            `{"parent_id":"` + nestedFolderID(3) + `"}`,
+			setup:           withFolderChain,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`, "inside itself"},
+			after:           assertParent(nestedFolderID(3), nestedFolderID(2)),
+		},
+		{
+			name:            "a folder cannot move into one of its subfolders",
+			method:          http.MethodPut,
+			url:             "/api/custom/collections/" + nestedFolderID(2) + "/move",
+			body:            `{"parent_id":"` + nestedFolderID(6) + `"}`,
+			setup:           withFolderChain,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`, "one of its subfolders"},
+			after:           assertParent(nestedFolderID(2), nestedFolderID(1)),
+		},
+		{
+			name:            "moves that nest the subtree too deeply are rejected",
+			method:          http.MethodPut,
+			url:             "/api/custom/collections/" + nestedFolderID(1) + "/move",
+			body:            `{"parent_id":"otherroot000001"}`,
+			setup:           withFolderChain,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"at most 10 levels deep"},
+			after:           assertParent(nestedFolderID(1), ""),
+		},
+		{
+			name:            "only the owner can move a folder",
+			method:          http.MethodPut,
+			url:             "/api/custom/collections/" + testFolderID + "/move",
+			body:            `{}`,
+			setup:           withFolderRole(folderacl.RoleEditor),
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"Only the folder owner can move it"},
+		},
+		{
+			name:            "copies that would nest too deeply are rejected",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/" + nestedFolderID(6) + "/duplicate",
+			body:            `{"parent_id":"` + nestedFolderID(6) + `"}`,
+			setup:           withFolderChain,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"at most 10 levels deep"},
+		},
+		{
+			name:            "copies that fit within the maximum depth are created",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/" + nestedFolderID(6) + "/duplicate",
+			body:            `{"parent_id":"` + nestedFolderID(5) + `"}`,
+			setup:           withFolderChain,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"folders":5`},
+		},
+	})
+}
@@ -0,0 +1,231 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/folderacl"
+	"generatio-pb/internal/invites"
+	"generatio-pb/internal/orgs"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testFolderID = "sharedfolder001"
+
+// withFolderRole seeds a folder owned by another user and grants the seeded
+// user role on it (owner makes the seeded user the folder's owner instead)
+func withFolderRole(role string) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		other := env.createUser(t, "foldercolleague", "colleague@test.com")
+
+		folders, err := env.app.FindCollectionByNameOrId("folders")
+		require.NoError(t, err)
+		folder := core.NewRecord(folders)
+		folder.Id = testFolderID
+		folder.Set("name", "Shared moodboard")
+		folder.Set("user_id", other.Id)
+		if role == folderacl.RoleOwner {
+			folder.Set("user_id", env.user.Id)
+		}
+		require.NoError(t, env.app.Save(folder))
+
+		if role == folderacl.RoleEditor || role == folderacl.RoleViewer {
+			require.NoError(t, folderacl.NewService(env.app, orgs.NewService(env.app)).Grant(folder, env.user.Id, role))
+		}
+	}
+}
+
+func TestFolderRoles(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+
+	withOrgRole(orgs.RoleMember)(t, env)
+	acl := folderacl.NewService(env.app, orgs.NewService(env.app))
+
+	folders, err := env.app.FindCollectionByNameOrId("folders")
+	require.NoError(t, err)
+	folder := core.NewRecord(folders)
+	folder.Set("name", "Team")
+	folder.Set("user_id", "orgcolleague001")
+	folder.Set("org_id", testOrgID)
+	require.NoError(t, env.app.Save(folder))
+
+	// Org members edit org folders; an explicit viewer grant does not downgrade them
+	assert.Equal(t, folderacl.RoleEditor, acl.Role(folder, env.user.Id))
+	require.NoError(t, acl.Grant(folder, env.user.Id, folderacl.RoleViewer))
+	assert.Equal(t, folderacl.RoleEditor, acl.Role(folder, env.user.Id))
+	assert.Equal(t, folderacl.RoleOwner, acl.Role(folder, "orgcolleague001"))
+	assert.Equal(t, "", acl.Role(folder, "strangerstrange"))
+
+	assert.Error(t, acl.Grant(folder, "orgcolleague001", folderacl.RoleEditor), "owner cannot be granted a role")
+	assert.Error(t, acl.Grant(folder, env.user.Id, folderacl.RoleOwner))
+}
+
+func TestFolderPermissionRoutes(t *testing.T) {
+	origin, _ := newImageOrigin(t)
+
+	folderImage := func(role string) func(t testing.TB, env *testEnv) {
+		return func(t testing.TB, env *testEnv) {
+			withFolderRole(role)(t, env)
+			env.createImage(t, map[string]any{"id": "folderimage0001", "user_id": "foldercolleague", "folder_id": testFolderID, "url": origin.URL + "/image.png"})
+		}
+	}
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "shared folders appear in the personal listing with their role",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections",
+			setup:           withFolderRole(folderacl.RoleViewer),
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"name":"Shared moodboard"`, `"role":"viewer"`},
+		},
+		{
+			name:            "viewers list the folder's images",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections/sharedfolder001/images",
+			setup:           folderImage(folderacl.RoleViewer),
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"role":"viewer"`, `"id":"folderimage0001"`},
+		},
+		{
+			name:            "viewers open images in the folder",
+			method:          http.MethodGet,
+			url:             "/api/custom/images/folderimage0001/file",
+			setup:           folderImage(folderacl.RoleViewer),
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{"PNG"},
+		},
+		{
+			name:            "outsiders cannot see the folder",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections/sharedfolder001/images",
+			setup:           withFolderRole(""),
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"code":"not_found"`},
+		},
+		{
+			name:            "viewers cannot add images",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/import",
+			body:            `{"folder_id":"sharedfolder001","images":[{"url":"https://example.com/a.png"}]}`,
+			setup:           withFolderRole(folderacl.RoleViewer),
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"message":"you do not have edit access to this folder"`},
+		},
+		{
+			name:            "editors add images",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/import",
+			body:            `{"folder_id":"sharedfolder001","images":[{"url":"https://example.com/a.png"}]}`,
+			setup:           withFolderRole(folderacl.RoleEditor),
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"source":"https://example.com/a.png"`},
+		},
+		{
+			name:            "editors cannot delete the folder",
+			method:          http.MethodDelete,
+			url:             "/api/custom/collections/sharedfolder001",
+			setup:           withFolderRole(folderacl.RoleEditor),
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{`"message":"Only the folder owner can delete it"`},
+		},
+		{
+			name:            "owners delete the folder",
+			method:          http.MethodDelete,
+			url:             "/api/custom/collections/sharedfolder001",
+			setup:           withFolderRole(folderacl.RoleOwner),
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				folder, err := env.app.FindRecordById("folders", testFolderID)
+				require.NoError(t, err)
+				assert.False(t, folder.GetDateTime("deleted_at").IsZero())
+			},
+		},
+		{
+			name:            "owners share the folder by email",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/sharedfolder001/permissions",
+			body:            `{"email":"colleague@test.com","role":"editor"}`,
+			setup:           withFolderRole(folderacl.RoleOwner),
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"email":"colleague@test.com"`, `"role":"editor"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				ids, err := folderacl.NewService(env.app, orgs.NewService(env.app)).SharedWith("foldercolleague")
+				require.NoError(t, err)
+				assert.Equal(t, []string{testFolderID}, ids)
+			},
+		},
+		{
+			name:               "sharing with an unknown email answers the same and sends an invitation",
+			method:             http.MethodPost,
+			url:                "/api/custom/collections/sharedfolder001/permissions",
+			body:               `{"email":"Nobody@Test.com","role":"editor"}`,
+			setup:              withFolderRole(folderacl.RoleOwner),
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"success":true`, `"email":"nobody@test.com"`, `"role":"editor"`},
+			notExpectedContent: []string{"user not found", `"user_id"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				invitations, err := env.app.FindAllRecords(invites.Collection)
+				require.NoError(t, err)
+				require.Len(t, invitations, 1)
+				assert.Equal(t, "nobody@test.com", invitations[0].GetString("email"))
+				assert.Equal(t, testFolderID, invitations[0].GetString("target_id"))
+				assert.Equal(t, invites.StatusPending, invitations[0].GetString("status"))
+			},
+		},
+		{
+			name:            "sharing needs a valid role",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/sharedfolder001/permissions",
+			body:            `{"email":"nobody@test.com","role":"owner"}`,
+			setup:           withFolderRole(folderacl.RoleOwner),
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"role must be one of: editor, viewer"},
+		},
+		{
+			name:            "editors cannot share the folder",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/sharedfolder001/permissions",
+			body:            `{"email":"colleague@test.com","role":"editor"}`,
+			setup:           withFolderRole(folderacl.RoleEditor),
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{`"message":"Only the folder owner can do this"`},
+		},
+		{
+			name:   "owners revoke access",
+			method: http.MethodDelete,
+			url:    "/api/custom/collections/sharedfolder001/permissions/foldercolleague",
+			setup: func(t testing.TB, env *testEnv) {
+				withFolderRole(folderacl.RoleOwner)(t, env)
+				folder, err := env.app.FindRecordById("folders", testFolderID)
+				require.NoError(t, err)
+				require.NoError(t, folderacl.NewService(env.app, orgs.NewService(env.app)).Grant(folder, "foldercolleague", folderacl.RoleViewer))
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				ids, err := folderacl.NewService(env.app, orgs.NewService(env.app)).SharedWith("foldercolleague")
+				require.NoError(t, err)
+				assert.Empty(t, ids)
+			},
+		},
+	})
+}
@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/fal"
+	"generatio-pb/internal/modelstats"
+	"generatio-pb/internal/pagination"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withFailedJob records a failed flux/schnell generation of the seeded user
+// under a fixed id, plus one of another user and a successful job
+func withFailedJob(t testing.TB, env *testEnv) {
+	collection, err := env.app.FindCollectionByNameOrId(modelstats.Collection)
+	require.NoError(t, err)
+
+	jobs := []struct {
+		id, userID string
+		success    bool
+	}{
+		{"failedjob000001", env.user.Id, false},
+		{"strangerjob0001", "someoneelse0001", false},
+		{"succeededjob001", env.user.Id, true},
+	}
+	for _, job := range jobs {
+		record := core.NewRecord(collection)
+		record.Id = job.id
+		record.Set("user_id", job.userID)
+		record.Set("model", "flux/schnell")
+		record.Set("success", job.success)
+		record.Set("prompt", "a lighthouse at dusk")
+		record.Set("parameters", map[string]interface{}{"num_images": 1})
+		if !job.success {
+			record.Set("error_code", fal.ErrorCodeUnavailable)
+			record.Set("error_message", "FAL AI is temporarily unavailable")
+			record.Set("retryable", true)
+		}
+		require.NoError(t, env.app.Save(record))
+	}
+}
+
+func TestFailureRecords(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+
+	recorder := modelstats.NewRecorder(env.app)
+	require.NoError(t, recorder.Record(env.user.Id, modelstats.Job{Model: "flux/schnell", Duration: time.Second, Success: true, Cost: 0.003}))
+	require.NoError(t, recorder.Record(env.user.Id, modelstats.Job{
+		Model:      "flux/schnell",
+		Duration:   time.Second,
+		Prompt:     "a lighthouse at dusk",
+		Parameters: map[string]interface{}{"num_images": 2},
+		ErrorCode:  fal.ErrorCodeRateLimited,
+		Message:    "Too many requests",
+		Retryable:  true,
+		RequestID:  "req_123",
+	}))
+	require.NoError(t, recorder.Record("someoneelse0001", modelstats.Job{Model: "flux/schnell", ErrorCode: fal.ErrorCodeUnknown}))
+
+	failures, next, err := recorder.Failures(env.user.Id, pagination.Page{Number: 1, Limit: 10})
+	require.NoError(t, err)
+	assert.Empty(t, next)
+	require.Len(t, failures, 1, "only the user's failed jobs are listed")
+	failure := failures[0]
+	assert.Equal(t, "a lighthouse at dusk", failure.Prompt)
+	assert.EqualValues(t, 2, failure.Parameters["num_images"])
+	assert.Equal(t, fal.ErrorCodeRateLimited, failure.ErrorCode)
+	assert.True(t, failure.Retryable)
+	assert.Equal(t, "req_123", failure.RequestID)
+	assert.Zero(t, failure.Cost)
+	assert.Nil(t, failure.RetriedAt)
+
+	_, err = recorder.Failure("someoneelse0001", failure.ID)
+	assert.ErrorIs(t, err, modelstats.ErrFailureNotFound)
+
+	require.NoError(t, recorder.MarkRetried(failure.ID))
+	failure, err = recorder.Failure(env.user.Id, failure.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, failure.RetriedAt)
+}
+
+func TestGenerationFailureRoutes(t *testing.T) {
+	unavailable := func(t testing.TB, env *testEnv) {
+		env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+			return nil, &fal.FALError{Status: http.StatusServiceUnavailable, Message: "upstream overloaded", RequestID: "req_503"}
+		})
+	}
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "failed generations are stored with their error",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk","parameters":{"num_images":2}}`,
+			setup:           unavailable,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadGateway,
+			expectedContent: []string{`"code":"` + fal.ErrorCodeUnavailable + `"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				failures, _, err := modelstats.NewRecorder(env.app).Failures(env.user.Id, pagination.Page{Number: 1, Limit: 10})
+				require.NoError(t, err)
+				require.Len(t, failures, 1)
+				assert.Equal(t, "flux/schnell", failures[0].Model)
+				assert.Equal(t, "a lighthouse at dusk", failures[0].Prompt)
+				assert.EqualValues(t, 2, failures[0].Parameters["num_images"])
+				assert.Equal(t, fal.ErrorCodeUnavailable, failures[0].ErrorCode)
+				assert.True(t, failures[0].Retryable)
+				assert.Equal(t, "req_503", failures[0].RequestID)
+				assert.Zero(t, failures[0].Cost)
+			},
+		},
+		{
+			name:               "users list only their own failures",
+			method:             http.MethodGet,
+			url:                "/api/custom/generate/failures",
+			setup:              withFailedJob,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"data":[`, `"id":"failedjob000001"`, `"error_code":"` + fal.ErrorCodeUnavailable + `"`, `"retryable":true`},
+			notExpectedContent: []string{"strangerjob0001", "succeededjob001"},
+		},
+		{
+			name:            "a retry submits the failed generation again",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/failures/failedjob000001/retry",
+			setup:           withFailedJob,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"images":[`, `"model":"flux/schnell"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				failure, err := modelstats.NewRecorder(env.app).Failure(env.user.Id, "failedjob000001")
+				require.NoError(t, err)
+				assert.NotNil(t, failure.RetriedAt)
+			},
+		},
+		{
+			name:            "a retry failing again is not marked retried",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/failures/failedjob000001/retry",
+			setup:           func(t testing.TB, env *testEnv) { withFailedJob(t, env); unavailable(t, env) },
+			headers:         withSession,
+			expectedStatus:  http.StatusBadGateway,
+			expectedContent: []string{`"code":"` + fal.ErrorCodeUnavailable + `"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				failure, err := modelstats.NewRecorder(env.app).Failure(env.user.Id, "failedjob000001")
+				require.NoError(t, err)
+				assert.Nil(t, failure.RetriedAt)
+			},
+		},
+		{
+			name:            "another user's failure cannot be retried",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/failures/strangerjob0001/retry",
+			setup:           withFailedJob,
+			headers:         withSession,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"message":"Failed generation not found"`},
+		},
+		{
+			name:            "successful jobs cannot be retried",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/failures/succeededjob001/retry",
+			setup:           withFailedJob,
+			headers:         withSession,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"message":"Failed generation not found"`},
+		},
+		{
+			name:            "listing failures requires authentication",
+			method:          http.MethodGet,
+			url:             "/api/custom/generate/failures",
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"message":"Authentication required"`},
+		},
+	})
+}
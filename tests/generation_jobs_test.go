@@ -0,0 +1,217 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/fal"
+	"generatio-pb/internal/jobs"
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// slowGenerations makes generations finish after delay, delivering an image
+// or failing, unless their context ends first
+func slowGenerations(delay time.Duration, fail bool) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if fail {
+				return nil, &fal.FALError{Code: "generation_failed", Message: "model crashed"}
+			}
+			return deliveredResult(req.Parameters, "https://fal.media/slow.jpg"), nil
+		})
+	}
+}
+
+// capGenerationWait caps max_wait well below the generations of slowGenerations
+func capGenerationWait(t testing.TB, env *testEnv) {
+	env.cfg.MaxGenerationWait = 50 * time.Millisecond
+}
+
+// awaitJob polls the job named by a 202 response until it has finished
+func awaitJob(t testing.TB, env *testEnv, res *http.Response, headers map[string]string) jobs.Job {
+	t.Helper()
+
+	location := res.Header.Get("Location")
+	require.True(t, strings.HasPrefix(location, "/api/custom/generate/jobs/"), location)
+
+	var job jobs.Job
+	require.Eventually(t, func() bool {
+		rec := env.serve(t, http.MethodGet, location, headers)
+		if rec.Code != http.StatusOK {
+			return false
+		}
+		job = jobs.Job{}
+		decodeData(t, rec.Result(), &job)
+		return job.Status != jobs.StatusRunning
+	}, 5*time.Second, 20*time.Millisecond)
+	return job
+}
+
+func TestGenerationJobRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "a generation finishing within max_wait is answered directly",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"flux/schnell","prompt":"a lighthouse at dusk","max_wait":30}`,
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"url":"https://mock-image-url.com/image.jpg"`},
+			notExpectedContent: []string{`"status":"running"`},
+		},
+		{
+			name:            "a generation failing within max_wait reports its error directly",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk","max_wait":30}`,
+			setup:           failGenerations,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadGateway,
+			expectedContent: []string{`"code":"fal_generation_failed"`},
+		},
+		{
+			name:            "a generation outlasting max_wait is answered with a job",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk","max_wait":30}`,
+			setup:           slowGenerations(300*time.Millisecond, false),
+			before:          capGenerationWait,
+			headers:         withSession,
+			expectedStatus:  http.StatusAccepted,
+			expectedContent: []string{`"status":"running"`, `"id":"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				job := awaitJob(t, env, res, env.authHeaders())
+				assert.Equal(t, jobs.StatusCompleted, job.Status)
+				require.NotNil(t, job.Result)
+				require.Len(t, job.Result.Images, 1)
+				assert.Equal(t, "https://fal.media/slow.jpg", job.Result.Images[0].URL)
+				assert.NotNil(t, job.Finished)
+
+				records, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				assert.Len(t, records, 1)
+			},
+		},
+		{
+			name:            "a job keeps generating after its client disconnects",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk","max_wait":30}`,
+			setup:           slowGenerations(200*time.Millisecond, false),
+			headers:         withSession,
+			timeout:         30 * time.Millisecond,
+			expectedStatus:  http.StatusAccepted,
+			expectedContent: []string{`"status":"running"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				job := awaitJob(t, env, res, env.authHeaders())
+				assert.Equal(t, jobs.StatusCompleted, job.Status)
+
+				records, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				assert.Len(t, records, 1)
+			},
+		},
+		{
+			name:            "a failed job reports its error in the caller's language",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk","max_wait":30}`,
+			setup:           slowGenerations(200*time.Millisecond, true),
+			before:          capGenerationWait,
+			headers:         withSession,
+			expectedStatus:  http.StatusAccepted,
+			expectedContent: []string{`"status":"running"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				headers := env.authHeaders()
+				headers["Accept-Language"] = "de"
+				job := awaitJob(t, env, res, headers)
+				assert.Equal(t, jobs.StatusFailed, job.Status)
+				assert.Nil(t, job.Result)
+				require.NotNil(t, job.Error)
+				assert.Equal(t, fal.ErrorCodeGenerationFailed, job.Error.Code)
+				assert.Equal(t, "Die Generierung ist fehlgeschlagen.", job.Error.Message)
+			},
+		},
+		{
+			name:            "max_wait is ignored when the server disables it",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk","max_wait":1}`,
+			setup:           slowGenerations(100*time.Millisecond, false),
+			before:          func(t testing.TB, env *testEnv) { env.cfg.MaxGenerationWait = 0 },
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"url":"https://fal.media/slow.jpg"`},
+		},
+		{
+			name:            "negative max_wait",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk","max_wait":-1}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"message":"max_wait must not be negative"`},
+		},
+		{
+			name:            "unknown job",
+			method:          http.MethodGet,
+			url:             "/api/custom/generate/jobs/missingjob00001",
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"code":"not_found"`},
+		},
+	})
+}
+
+func TestGenerationJobStore(t *testing.T) {
+	store := jobs.NewStore(time.Hour)
+
+	run := store.Start("user1")
+	job, ok := store.Get("user1", run.ID())
+	require.True(t, ok)
+	assert.Equal(t, jobs.StatusRunning, job.Status)
+	assert.Nil(t, job.Finished)
+
+	// Jobs belong to the user who started them
+	_, ok = store.Get("user2", run.ID())
+	assert.False(t, ok)
+
+	run.Complete(&localmodels.GenerateImageResponse{Model: "flux/schnell"})
+	select {
+	case <-run.Done():
+	default:
+		t.Fatal("Done is not closed after Complete")
+	}
+	job, ok = store.Get("user1", run.ID())
+	require.True(t, ok)
+	assert.Equal(t, jobs.StatusCompleted, job.Status)
+	assert.Equal(t, "flux/schnell", job.Result.Model)
+	assert.NotNil(t, job.Finished)
+
+	failed := store.Start("user1")
+	failed.Fail(http.StatusBadGateway, localmodels.APIError{Code: fal.ErrorCodeGenerationFailed})
+	job, ok = store.Get("user1", failed.ID())
+	require.True(t, ok)
+	assert.Equal(t, jobs.StatusFailed, job.Status)
+	assert.Equal(t, http.StatusBadGateway, job.HTTPStatus)
+	assert.Equal(t, fal.ErrorCodeGenerationFailed, job.Error.Code)
+
+	// Finished jobs expire after the retention
+	expiring := jobs.NewStore(time.Millisecond)
+	done := expiring.Start("user1")
+	done.Complete(&localmodels.GenerateImageResponse{})
+	time.Sleep(5 * time.Millisecond)
+	_, ok = expiring.Get("user1", done.ID())
+	assert.False(t, ok)
+}
@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/fal"
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/modelstats"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// twoImages makes FAL AI deliver two flux/schnell images
+func twoImages(t testing.TB, env *testEnv) {
+	env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+		return deliveredResult(req.Parameters, "https://a.example/1.jpg", "https://a.example/2.jpg"), nil
+	})
+}
+
+// failSecondImage makes saving the second image of a batch fail
+func failSecondImage(t testing.TB, env *testEnv) {
+	env.app.OnRecordCreate("images").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetInt("batch_number") == 2 {
+			return errors.New("disk full")
+		}
+		return e.Next()
+	})
+}
+
+// savedGeneration returns the saved images, the recorded financial totals
+// and the number of successful jobs
+func savedGeneration(t testing.TB, env *testEnv) ([]*core.Record, localmodels.FinancialData, int) {
+	images, err := env.app.FindAllRecords("images")
+	require.NoError(t, err)
+
+	user, err := env.app.FindRecordById("generatio_users", env.user.Id)
+	require.NoError(t, err)
+	var financial localmodels.FinancialData
+	user.UnmarshalJSONField("financial_data", &financial)
+
+	jobs, err := env.app.FindAllRecords(modelstats.Collection)
+	require.NoError(t, err)
+	succeeded := 0
+	for _, job := range jobs {
+		if job.GetBool("success") {
+			succeeded++
+		}
+	}
+	return images, financial, succeeded
+}
+
+func TestGenerationTransactionRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "a generation saves its images, totals and job together",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk","parameters":{"num_images":2}}`,
+			setup:           twoImages,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"images":[`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				images, financial, jobs := savedGeneration(t, env)
+				assert.Len(t, images, 2)
+				assert.Equal(t, 2, financial.TotalImages)
+				assert.EqualValues(t, 6000, financial.TotalSpentMicros)
+				assert.Equal(t, 1, jobs)
+			},
+		},
+		{
+			name:    "a failed save leaves nothing half written but records the spend",
+			method:  http.MethodPost,
+			url:     "/api/custom/generate/image",
+			body:    `{"model":"flux/schnell","prompt":"a lighthouse at dusk","parameters":{"num_images":2}}`,
+			setup:   twoImages,
+			before:  failSecondImage,
+			headers: withSession,
+			// The images were charged for, so the client is told and given their URLs
+			expectedStatus:  http.StatusInternalServerError,
+			expectedContent: []string{`"code":"internal_error"`, "could not be saved", `"image_urls":["https://a.example/1.jpg","https://a.example/2.jpg"]`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				images, financial, jobs := savedGeneration(t, env)
+				assert.Empty(t, images, "the first image is rolled back")
+				assert.Equal(t, 2, financial.TotalImages)
+				assert.EqualValues(t, 6000, financial.TotalSpentMicros)
+				assert.Zero(t, jobs)
+			},
+		},
+		{
+			name:   "a comparison variant that cannot be saved fails but is charged",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/compare",
+			body:   `{"prompt":"a red fox","variants":[{"model":"flux/schnell","parameters":{"num_images":2}},{"model":"flux/schnell","parameters":{"seed":7}}]}`,
+			setup: func(t testing.TB, env *testEnv) {
+				env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+					urls := []string{"https://a.example/1.jpg", "https://a.example/2.jpg"}
+					return deliveredResult(req.Parameters, urls[:fal.RequestedImages(req.Parameters)]...), nil
+				})
+			},
+			before:          failSecondImage,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"error_code":"internal_error"`, `"total_cost_micros":9000`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				images, financial, _ := savedGeneration(t, env)
+				assert.Len(t, images, 1, "only the variant that saved keeps its image")
+				assert.EqualValues(t, 9000, financial.TotalSpentMicros)
+			},
+		},
+		{
+			name:   "a missing images collection is reported instead of updating the totals alone",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"model":"flux/schnell","prompt":"a lighthouse at dusk","parameters":{"num_images":2}}`,
+			setup:  twoImages,
+			before: func(t testing.TB, env *testEnv) {
+				collection, err := env.app.FindCollectionByNameOrId("images")
+				require.NoError(t, err)
+				require.NoError(t, env.app.Delete(collection))
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusInternalServerError,
+			expectedContent: []string{`"code":"internal_error"`, "https://a.example/2.jpg"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				user, err := env.app.FindRecordById("generatio_users", env.user.Id)
+				require.NoError(t, err)
+				var financial localmodels.FinancialData
+				user.UnmarshalJSONField("financial_data", &financial)
+				assert.Equal(t, 2, financial.TotalImages, "the charged images are still counted")
+				assert.EqualValues(t, 6000, financial.TotalSpentMicros)
+			},
+		},
+		{
+			name:    "concurrent comparison variants all reach the totals",
+			method:  http.MethodPost,
+			url:     "/api/custom/generate/compare",
+			body:    `{"prompt":"a red fox","variants":[{"model":"flux/schnell"},{"model":"hidream/hidream-i1-fast"},{"model":"flux/schnell","parameters":{"seed":7}}]}`,
+			headers: withSession,
+			setup: func(t testing.TB, env *testEnv) {
+				env.user.Set("financial_data", map[string]interface{}{"total_spent_micros": 1000, "total_images": 1, "salt": "keep-me"})
+				require.NoError(t, env.app.Save(env.user))
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"total_cost_micros":9000`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				images, financial, _ := savedGeneration(t, env)
+				assert.Len(t, images, 3)
+				assert.Equal(t, 4, financial.TotalImages)
+				assert.EqualValues(t, 10000, financial.TotalSpentMicros)
+
+				user, err := env.app.FindRecordById("generatio_users", env.user.Id)
+				require.NoError(t, err)
+				assert.Contains(t, user.GetString("financial_data"), `"salt":"keep-me"`, "other keys are kept")
+			},
+		},
+	})
+}
@@ -0,0 +1,673 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// handlerScenario describes a single HTTP request against the custom routes
+type handlerScenario struct {
+	name               string
+	method             string
+	url                string
+	body               string
+	headers            func(t testing.TB, env *testEnv) map[string]string
+	setup              func(t testing.TB, env *testEnv)
+	before             func(t testing.TB, env *testEnv)
+	delay              time.Duration
+	timeout            time.Duration // cancels the request context, as a client disconnect would
+	expectedStatus     int
+	expectedContent    []string
+	notExpectedContent []string
+	after              func(t testing.TB, env *testEnv, res *http.Response)
+}
+
+// runScenarios executes each scenario against a freshly seeded test app
+func runScenarios(t *testing.T, scenarios []handlerScenario) {
+	for _, s := range scenarios {
+		env := newTestEnv(t)
+		if s.setup != nil {
+			s.setup(t, env)
+		}
+
+		var headers map[string]string
+		if s.headers != nil {
+			headers = s.headers(t, env)
+		}
+
+		scenario := tests.ApiScenario{
+			Name:               s.name,
+			Method:             s.method,
+			URL:                s.url,
+			Headers:            headers,
+			Delay:              s.delay,
+			Timeout:            s.timeout,
+			ExpectedStatus:     s.expectedStatus,
+			ExpectedContent:    s.expectedContent,
+			NotExpectedContent: s.notExpectedContent,
+			TestAppFactory:     env.factory,
+		}
+		if s.body != "" {
+			scenario.Body = strings.NewReader(s.body)
+		}
+		if s.before != nil {
+			before := s.before
+			scenario.BeforeTestFunc = func(t testing.TB, app *tests.TestApp, e *core.ServeEvent) {
+				before(t, env)
+			}
+		}
+		if s.after != nil {
+			after := s.after
+			scenario.AfterTestFunc = func(t testing.TB, app *tests.TestApp, res *http.Response) {
+				after(t, env, res)
+			}
+		}
+
+		scenario.Test(t)
+	}
+}
+
+func authOnly(t testing.TB, env *testEnv) map[string]string {
+	return env.authHeaders()
+}
+
+func withSession(t testing.TB, env *testEnv) map[string]string {
+	return env.sessionHeaders(t)
+}
+
+func withStoredToken(t testing.TB, env *testEnv) {
+	env.storeEncryptedToken(t)
+}
+
+// decodeData decodes the data of an enveloped response into dst
+func decodeData(t testing.TB, res *http.Response, dst any) {
+	t.Helper()
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&envelope))
+	require.NoError(t, json.Unmarshal(envelope.Data, dst))
+}
+
+func TestTokenRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "setup without auth",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/setup",
+			body:            `{"fal_token":"` + testFALToken + `","password":"` + testPassword + `"}`,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"code":"authentication_error"`},
+		},
+		{
+			name:            "setup with missing fields",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/setup",
+			body:            `{"fal_token":"` + testFALToken + `"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`},
+		},
+		{
+			name:            "setup with rejected FAL token",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/setup",
+			body:            `{"fal_token":"invalid_token","password":"` + testPassword + `"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"message":"Invalid FAL AI token"`},
+		},
+		{
+			name:            "setup stores encrypted token",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/setup",
+			body:            `{"fal_token":"` + testFALToken + `","password":"` + testPassword + `"}`,
+			headers:         authOnly,
+			delay:           100 * time.Millisecond,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				require.Equal(t, 1, env.app.TestMailer.TotalSend())
+				assert.Contains(t, env.app.TestMailer.LastMessage().Subject, "FAL AI token was changed")
+
+				user, err := env.app.FindRecordById("generatio_users", env.user.Id)
+				require.NoError(t, err)
+				stored := user.GetString("fal_token")
+				assert.NotContains(t, stored, testFALToken)
+				assert.Len(t, strings.Split(stored, "."), 2)
+			},
+		},
+		{
+			name:            "verify with correct password",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/verify",
+			body:            `{"password":"` + testPassword + `"}`,
+			headers:         authOnly,
+			setup:           withStoredToken,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"has_token":true`, `"can_decrypt":true`},
+		},
+		{
+			name:            "verify with wrong password",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/verify",
+			body:            `{"password":"wrongpassword"}`,
+			headers:         authOnly,
+			setup:           withStoredToken,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"has_token":true`, `"can_decrypt":false`},
+		},
+	})
+}
+
+func TestSessionRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "create session without stored token",
+			method:          http.MethodPost,
+			url:             "/api/custom/auth/create-session",
+			body:            `{"password":"` + testPassword + `"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`},
+		},
+		{
+			name:            "create session with wrong password",
+			method:          http.MethodPost,
+			url:             "/api/custom/auth/create-session",
+			body:            `{"password":"wrongpassword"}`,
+			headers:         authOnly,
+			setup:           withStoredToken,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"message":"Invalid password"`},
+		},
+		{
+			name:            "create session",
+			method:          http.MethodPost,
+			url:             "/api/custom/auth/create-session",
+			body:            `{"password":"` + testPassword + `"}`,
+			headers:         authOnly,
+			setup:           withStoredToken,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"session_id":`, `"expires_at":`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				session, err := env.sessionStore.GetUserSession(env.user.Id)
+				require.NoError(t, err)
+				assert.Equal(t, testFALToken, session.FALToken)
+			},
+		},
+		{
+			name:            "delete session without header",
+			method:          http.MethodDelete,
+			url:             "/api/custom/auth/session",
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`},
+		},
+		{
+			name:   "delete unknown session",
+			method: http.MethodDelete,
+			url:    "/api/custom/auth/session",
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				headers := env.authHeaders()
+				headers["X-Session-ID"] = "missing"
+				return headers
+			},
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"code":"not_found"`},
+		},
+		{
+			name:   "delete another user's session",
+			method: http.MethodDelete,
+			url:    "/api/custom/auth/session",
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				sessionID, err := env.sessionStore.Create("other_user", testFALToken)
+				require.NoError(t, err)
+				headers := env.authHeaders()
+				headers["X-Session-ID"] = sessionID
+				return headers
+			},
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{`"code":"authorization_error"`},
+		},
+		{
+			name:            "delete own session",
+			method:          http.MethodDelete,
+			url:             "/api/custom/auth/session",
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, 0, env.sessionStore.GetSessionCount())
+			},
+		},
+		{
+			name:               "session info omits the token",
+			method:             http.MethodGet,
+			url:                "/api/custom/auth/session",
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"created_at":`, `"expires_at":`, `"last_used_at":null`},
+			notExpectedContent: []string{testFALToken, `"session_id"`, `"user_id"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				var info localmodels.SessionInfoResponse
+				decodeData(t, res, &info)
+				assert.InDelta(t, time.Hour.Seconds(), info.TTLSeconds, 5)
+				assert.WithinDuration(t, time.Now().Add(time.Hour), info.ExpiresAt, 5*time.Second)
+			},
+		},
+		{
+			name:   "session info reports the last use",
+			method: http.MethodGet,
+			url:    "/api/custom/auth/session",
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				headers := env.sessionHeaders(t)
+				env.sessionStore.Touch(headers["X-Session-ID"])
+				return headers
+			},
+			expectedStatus:     http.StatusOK,
+			notExpectedContent: []string{`"last_used_at":null`},
+		},
+		{
+			name:   "session info for an unknown session",
+			method: http.MethodGet,
+			url:    "/api/custom/auth/session",
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				headers := env.authHeaders()
+				headers["X-Session-ID"] = "missing"
+				return headers
+			},
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"code":"not_found"`},
+		},
+		{
+			name:            "generation marks the session used",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"prompt":"a castle","model":"fal-ai/flux/schnell"}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"images"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				session, err := env.sessionStore.GetUserSession(env.user.Id)
+				require.NoError(t, err)
+				assert.WithinDuration(t, time.Now(), session.LastUsedAt, 5*time.Second)
+			},
+		},
+		{
+			name:            "successful generations renew the session when enabled",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"prompt":"a castle","model":"fal-ai/flux/schnell"}`,
+			setup:           withSessionRenewal(true),
+			headers:         withExpiringSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"images"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				session, err := env.sessionStore.GetUserSession(env.user.Id)
+				require.NoError(t, err)
+				assert.WithinDuration(t, time.Now().Add(time.Hour), session.ExpiresAt, 5*time.Second)
+			},
+		},
+		{
+			name:            "sessions are not renewed by default",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"prompt":"a castle","model":"fal-ai/flux/schnell"}`,
+			setup:           withSessionRenewal(false),
+			headers:         withExpiringSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"images"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				session, err := env.sessionStore.GetUserSession(env.user.Id)
+				require.NoError(t, err)
+				assert.WithinDuration(t, time.Now().Add(time.Minute), session.ExpiresAt, 5*time.Second)
+			},
+		},
+		{
+			name:            "failed generations do not renew the session",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"fal-ai/flux/schnell"}`,
+			setup:           withSessionRenewal(true),
+			headers:         withExpiringSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				session, err := env.sessionStore.GetUserSession(env.user.Id)
+				require.NoError(t, err)
+				assert.WithinDuration(t, time.Now().Add(time.Minute), session.ExpiresAt, 5*time.Second)
+			},
+		},
+		{
+			name:            "token status requires login",
+			method:          http.MethodGet,
+			url:             "/api/custom/auth/token-status",
+			headers:         authOnly,
+			setup:           withStoredToken,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"has_token":true`, `"has_active_session":false`, `"requires_login":true`},
+		},
+		{
+			name:   "token status with active session",
+			method: http.MethodGet,
+			url:    "/api/custom/auth/token-status",
+			setup:  withStoredToken,
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				return env.sessionHeaders(t)
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"has_active_session":true`, `"requires_login":false`},
+		},
+	})
+}
+
+// withSessionRenewal sets whether successful generations renew the session
+func withSessionRenewal(enabled bool) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		env.cfg.RenewSessionOnGeneration = enabled
+	}
+}
+
+// withExpiringSession creates a session for the seeded user that expires in a minute
+func withExpiringSession(t testing.TB, env *testEnv) map[string]string {
+	headers := env.sessionHeaders(t)
+	require.NoError(t, env.sessionStore.SetExpiry(headers["X-Session-ID"], time.Now().Add(time.Minute)))
+	return headers
+}
+
+func TestGenerationRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "generate with missing prompt",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell"}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`},
+		},
+		{
+			name:            "generate without session",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"code":"authentication_error"`},
+		},
+		{
+			name:            "generate image",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"flux/schnell"`, `"url":"https://mock-image-url.com/image.jpg"`, `"variants":{"jpeg":{"1024":`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				records, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				require.Len(t, records, 1)
+				assert.Equal(t, env.user.Id, records[0].GetString("user_id"))
+				assert.Equal(t, "a lighthouse at dusk", records[0].GetString("prompt"))
+				assert.Equal(t, 0, env.app.TestMailer.TotalSend())
+			},
+		},
+		{
+			name:   "generate sends completion email when opted in",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				env.user.Set("completion_email", "always")
+				require.NoError(t, env.app.Save(env.user))
+			},
+			headers:         withSession,
+			delay:           100 * time.Millisecond,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"flux/schnell"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				require.Equal(t, 1, env.app.TestMailer.TotalSend())
+				message := env.app.TestMailer.LastMessage()
+				assert.Equal(t, testEmail, message.To[0].Address)
+				assert.Contains(t, message.HTML, "https://mock-image-url.com/thumb.jpg")
+				assert.Contains(t, message.HTML, "a lighthouse at dusk")
+			},
+		},
+		{
+			name:            "models without auth",
+			method:          http.MethodGet,
+			url:             "/api/custom/generate/models",
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"code":"authentication_error"`},
+		},
+		{
+			name:            "models",
+			method:          http.MethodGet,
+			url:             "/api/custom/generate/models",
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"flux/schnell"`, `"hidream/hidream-i1-dev"`},
+		},
+	})
+}
+
+func TestUserRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "financial stats",
+			method:          http.MethodGet,
+			url:             "/api/custom/financial/stats",
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"total_spent":0`, `"total_images":0`},
+		},
+		{
+			name:            "get preferences without model",
+			method:          http.MethodPost,
+			url:             "/api/custom/preferences/get",
+			body:            `{}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`},
+		},
+		{
+			name:            "get preferences when none saved",
+			method:          http.MethodPost,
+			url:             "/api/custom/preferences/get",
+			body:            `{"model_name":"flux/schnell"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model_name":"flux/schnell"`, `"has_preferences":false`},
+		},
+		{
+			name:            "save preferences",
+			method:          http.MethodPost,
+			url:             "/api/custom/preferences/save",
+			body:            `{"model_name":"flux/schnell","preferences":{"num_inference_steps":4}}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				record, err := env.app.FindFirstRecordByData("model_preferences", "model_name", "flux/schnell")
+				require.NoError(t, err)
+				assert.Contains(t, record.GetString("preferences"), "num_inference_steps")
+			},
+		},
+		{
+			name:            "saving preferences keeps earlier models linked",
+			method:          http.MethodPost,
+			url:             "/api/custom/preferences/save",
+			body:            `{"model_name":"flux/schnell","preferences":{"num_inference_steps":4}}`,
+			headers:         authOnly,
+			setup:           withSavedPreferences,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				user, err := env.app.FindRecordById("generatio_users", env.user.Id)
+				require.NoError(t, err)
+				assert.Len(t, user.GetStringSlice("model_preferences"), 3)
+			},
+		},
+		{
+			name:            "get saved preferences",
+			method:          http.MethodPost,
+			url:             "/api/custom/preferences/get",
+			body:            `{"model_name":"flux/dev"}`,
+			headers:         authOnly,
+			setup:           withSavedPreferences,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"preferences":{"guidance_scale":3}`, `"has_preferences":true`},
+		},
+		{
+			name:               "every saved preference is fetched in one call",
+			method:             http.MethodGet,
+			url:                "/api/custom/preferences",
+			headers:            authOnly,
+			setup:              withSavedPreferences,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`{"preferences":{"flux/dev":{"guidance_scale":3},"hidream/hidream-i1-fast":{"num_inference_steps":8}}}`},
+			notExpectedContent: []string{"seed"},
+		},
+		{
+			name:            "users without preferences fetch an empty set",
+			method:          http.MethodGet,
+			url:             "/api/custom/preferences",
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`{"preferences":{}}`},
+		},
+	})
+}
+
+// withSavedPreferences saves preferences for two models linked to the seeded
+// user, and one for another model that is not linked to them
+func withSavedPreferences(t testing.TB, env *testEnv) {
+	collection, err := env.app.FindCollectionByNameOrId("model_preferences")
+	require.NoError(t, err)
+
+	saved := map[string]map[string]any{
+		"flux/dev":                {"guidance_scale": 3},
+		"hidream/hidream-i1-fast": {"num_inference_steps": 8},
+		"hidream/hidream-i1-dev":  {"seed": 42},
+	}
+	var linked []string
+	for _, model := range []string{"flux/dev", "hidream/hidream-i1-fast", "hidream/hidream-i1-dev"} {
+		record := core.NewRecord(collection)
+		record.Set("model_name", model)
+		record.Set("preferences", saved[model])
+		require.NoError(t, env.app.Save(record))
+		if model != "hidream/hidream-i1-dev" {
+			linked = append(linked, record.Id)
+		}
+	}
+
+	env.user.Set("model_preferences", linked)
+	require.NoError(t, env.app.Save(env.user))
+}
+
+func TestCollectionRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "create collection without name",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/create",
+			body:            `{}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`},
+		},
+		{
+			name:            "create collection",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/create",
+			body:            `{"name":"Landscapes"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"name":"Landscapes"`},
+		},
+		{
+			name:    "list collections",
+			method:  http.MethodGet,
+			url:     "/api/custom/collections",
+			headers: authOnly,
+			setup: func(t testing.TB, env *testEnv) {
+				folders, err := env.app.FindCollectionByNameOrId("folders")
+				require.NoError(t, err)
+				folder := core.NewRecord(folders)
+				folder.Set("user_id", env.user.Id)
+				folder.Set("name", "Portraits")
+				require.NoError(t, env.app.Save(folder))
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"name":"Portraits"`},
+		},
+		{
+			name:            "list collections without auth",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections",
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"code":"authentication_error"`},
+		},
+	})
+}
+
+func enableMaintenance(t testing.TB, env *testEnv) {
+	env.handler.Maintenance().Set(true, "Back soon")
+}
+
+func TestMaintenanceRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "maintenance status requires superuser",
+			method:          http.MethodGet,
+			url:             "/api/custom/admin/maintenance",
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{`"code":"authorization_error"`},
+		},
+		{
+			name:   "enable maintenance",
+			method: http.MethodPost,
+			url:    "/api/custom/admin/maintenance",
+			body:   `{"enabled":true,"message":"FAL incident in progress"}`,
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				return env.superuserHeaders(t)
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"enabled":true`, `"message":"FAL incident in progress"`, `"since":`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.True(t, env.handler.Maintenance().Status().Enabled)
+			},
+		},
+		{
+			name:            "generation blocked during maintenance",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			headers:         withSession,
+			before:          enableMaintenance,
+			expectedStatus:  http.StatusServiceUnavailable,
+			expectedContent: []string{`"code":"service_unavailable"`, `"message":"Back soon"`},
+		},
+		{
+			name:            "reads keep working during maintenance",
+			method:          http.MethodGet,
+			url:             "/api/custom/generate/models",
+			headers:         authOnly,
+			before:          enableMaintenance,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"flux/schnell"`},
+		},
+	})
+}
@@ -0,0 +1,650 @@
+package tests
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/auth"
+	"generatio-pb/internal/config"
+	"generatio-pb/internal/crypto"
+	"generatio-pb/internal/fal"
+	"generatio-pb/internal/handlers"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/router"
+)
+
+// testEnv bundles a PocketBase test app seeded with the Generatio schema
+// together with the services that RegisterRoutes wires into the handlers
+type testEnv struct {
+	app          *tests.TestApp
+	sessionStore *auth.SessionStore
+	encService   *crypto.EncryptionService
+	falClient    *fal.MockClient
+	cfg          *config.Config
+	handler      *handlers.Handler
+	router       *router.Router[*core.RequestEvent]
+	user         *core.Record
+	token        string
+}
+
+// newTestEnv creates a test app with the required collections, a seeded
+// generatio_users record and the custom routes bound to OnServe
+func newTestEnv(t testing.TB) *testEnv {
+	t.Helper()
+
+	app, err := tests.NewTestApp()
+	if err != nil {
+		t.Fatalf("Failed to create test app: %v", err)
+	}
+
+	env := &testEnv{
+		app:          app,
+		sessionStore: auth.NewSessionStore(time.Hour),
+		encService:   crypto.NewEncryptionService(1000), // Reduced iterations for testing
+		falClient:    fal.NewMockClient(),
+		cfg:          config.Default(),
+	}
+
+	// Test image origins are httptest servers on loopback
+	env.cfg.AllowPrivateImageURLs = true
+
+	if err := seedSchema(app); err != nil {
+		app.Cleanup()
+		t.Fatalf("Failed to seed schema: %v", err)
+	}
+
+	users, err := app.FindCollectionByNameOrId("generatio_users")
+	if err != nil {
+		app.Cleanup()
+		t.Fatalf("Failed to find generatio_users: %v", err)
+	}
+
+	env.user = core.NewRecord(users)
+	env.user.SetEmail(testEmail)
+	env.user.SetPassword(testPassword)
+	env.user.SetVerified(true)
+	if err := app.Save(env.user); err != nil {
+		app.Cleanup()
+		t.Fatalf("Failed to seed user: %v", err)
+	}
+
+	env.token, err = env.user.NewAuthToken()
+	if err != nil {
+		app.Cleanup()
+		t.Fatalf("Failed to create auth token: %v", err)
+	}
+
+	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
+		env.handler = handlers.RegisterRoutes(se, app, env.sessionStore, env.encService, env.falClient, env.cfg)
+		env.router = se.Router
+		return se.Next()
+	})
+
+	return env
+}
+
+// factory returns the prepared app for use as an ApiScenario.TestAppFactory
+func (env *testEnv) factory(t testing.TB) *tests.TestApp {
+	return env.app
+}
+
+// authHeaders returns headers authenticating as the seeded user
+func (env *testEnv) authHeaders() map[string]string {
+	return map[string]string{
+		"Authorization": env.token,
+	}
+}
+
+// superuserHeaders returns headers authenticating as the default test superuser
+func (env *testEnv) superuserHeaders(t testing.TB) map[string]string {
+	t.Helper()
+
+	superuser, err := env.app.FindAuthRecordByEmail(core.CollectionNameSuperusers, "test@example.com")
+	if err != nil {
+		t.Fatalf("Failed to find test superuser: %v", err)
+	}
+
+	token, err := superuser.NewAuthToken()
+	if err != nil {
+		t.Fatalf("Failed to create superuser token: %v", err)
+	}
+
+	return map[string]string{
+		"Authorization": token,
+	}
+}
+
+// serve sends a follow-up request through the routes of the app under test,
+// for requests that depend on the response to the scenario's own
+func (env *testEnv) serve(t testing.TB, method, url string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	mux, err := env.router.BuildMux()
+	if err != nil {
+		t.Fatalf("Failed to build routes: %v", err)
+	}
+
+	req := httptest.NewRequest(method, url, nil)
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+// sessionHeaders creates a FAL session for the seeded user and returns
+// headers carrying both the auth token and the session ID
+func (env *testEnv) sessionHeaders(t testing.TB) map[string]string {
+	t.Helper()
+
+	sessionID, err := env.sessionStore.Create(env.user.Id, testFALToken)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	headers := env.authHeaders()
+	headers["X-Session-ID"] = sessionID
+	return headers
+}
+
+// storeEncryptedToken encrypts the test FAL token with the test password and
+// saves it on the seeded user in the combined "encrypted.salt" format
+func (env *testEnv) storeEncryptedToken(t testing.TB) {
+	t.Helper()
+
+	result, err := env.encService.Encrypt(testFALToken, testPassword)
+	if err != nil {
+		t.Fatalf("Failed to encrypt token: %v", err)
+	}
+
+	env.user.Set("fal_token", result.Encrypted+"."+result.Salt)
+	if err := env.app.Save(env.user); err != nil {
+		t.Fatalf("Failed to save token: %v", err)
+	}
+}
+
+// createImage saves an image record owned by the seeded user
+func (env *testEnv) createImage(t testing.TB, fields map[string]any) *core.Record {
+	t.Helper()
+
+	images, err := env.app.FindCollectionByNameOrId("images")
+	if err != nil {
+		t.Fatalf("Failed to find images collection: %v", err)
+	}
+
+	record := core.NewRecord(images)
+	record.Set("user_id", env.user.Id)
+	record.Set("url", "https://mock-image-url.com/image.jpg")
+	record.Set("prompt", "a lighthouse at dusk")
+	record.Set("model", "flux/schnell")
+	for key, value := range fields {
+		if key == "created" {
+			// Autodate fields keep manually set values only through SetRaw
+			record.SetRaw(key, value)
+			continue
+		}
+		record.Set(key, value)
+	}
+
+	if err := env.app.Save(record); err != nil {
+		t.Fatalf("Failed to save image: %v", err)
+	}
+	return record
+}
+
+// seedSchema creates the collections the extension expects to exist
+func seedSchema(app core.App) error {
+	preferences := core.NewBaseCollection("model_preferences")
+	preferences.Fields.Add(
+		&core.TextField{Name: "user_id"},
+		&core.TextField{Name: "model_name", Required: true},
+		&core.JSONField{Name: "preferences"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+	preferences.AddIndex("idx_model_preferences_user_model", true, "user_id, model_name", "user_id != ''")
+	if err := app.Save(preferences); err != nil {
+		return err
+	}
+
+	users := core.NewAuthCollection("generatio_users")
+	users.Fields.Add(
+		&core.TextField{Name: "fal_token"},
+		&core.TextField{Name: "fal_token_testing"},
+		&core.JSONField{Name: "financial_data"},
+		&core.SelectField{Name: "completion_email", Values: []string{"off", "long_running", "always"}, MaxSelect: 1},
+		&core.NumberField{Name: "retention_days", OnlyInt: true},
+		&core.TextField{Name: "tier"},
+		&core.TextField{Name: "display_currency"},
+		&core.NumberField{Name: "remember_device_days", OnlyInt: true},
+		&core.JSONField{Name: "fal_key_labels"},
+		&core.BoolField{Name: "key_health_checks"},
+		&core.JSONField{Name: "key_health"},
+		&core.RelationField{Name: "model_preferences", CollectionId: preferences.Id, MaxSelect: 999},
+	)
+	if err := app.Save(users); err != nil {
+		return err
+	}
+
+	folders := core.NewBaseCollection("folders")
+	folders.Fields.Add(
+		&core.TextField{Name: "user_id", Required: true},
+		&core.TextField{Name: "name", Required: true},
+		&core.TextField{Name: "parent_id"},
+		&core.TextField{Name: "org_id"},
+		&core.BoolField{Name: "private"},
+		&core.DateField{Name: "deleted_at"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+	if err := app.Save(folders); err != nil {
+		return err
+	}
+
+	images := core.NewBaseCollection("images")
+	images.Fields.Add(
+		&core.TextField{Name: "user_id", Required: true},
+		&core.TextField{Name: "title"},
+		&core.URLField{Name: "url"},
+		&core.TextField{Name: "prompt"},
+		&core.TextField{Name: "request_id"},
+		&core.TextField{Name: "model"},
+		&core.NumberField{Name: "batch_number"},
+		&core.JSONField{Name: "image_size"},
+		&core.JSONField{Name: "other_info"},
+		&core.TextField{Name: "folder_id"},
+		&core.TextField{Name: "org_id"},
+		&core.TextField{Name: "group_id"},
+		&core.TextField{Name: "parent_id"},
+		&core.NumberField{Name: "position", OnlyInt: true},
+		&core.JSONField{Name: "tags"},
+		&core.TextField{Name: "caption"},
+		&core.TextField{Name: "cache_key"},
+		&core.TextField{Name: "moderation_status"},
+		&core.BoolField{Name: "favorite"},
+		&core.DateField{Name: "archived_at"},
+		&core.TextField{Name: "content_hash"},
+		&core.NumberField{Name: "content_size", OnlyInt: true},
+		&core.DateField{Name: "deleted_at"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+	if err := app.Save(images); err != nil {
+		return err
+	}
+
+	outbox := core.NewBaseCollection("notification_outbox")
+	outbox.Fields.Add(
+		&core.TextField{Name: "user_id"},
+		&core.TextField{Name: "channel", Required: true},
+		&core.TextField{Name: "target", Required: true},
+		&core.TextField{Name: "event"},
+		&core.TextField{Name: "subject"},
+		&core.TextField{Name: "body"},
+		&core.TextField{Name: "html"},
+		&core.JSONField{Name: "images"},
+		&core.JSONField{Name: "data"},
+		&core.TextField{Name: "status", Required: true},
+		&core.NumberField{Name: "attempts"},
+		&core.DateField{Name: "next_attempt_at"},
+		&core.TextField{Name: "last_error"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+	if err := app.Save(outbox); err != nil {
+		return err
+	}
+
+	filterTerms := core.NewBaseCollection("content_filter_terms")
+	filterTerms.Fields.Add(
+		&core.TextField{Name: "term", Required: true},
+		&core.SelectField{Name: "kind", Values: []string{"block", "allow"}, MaxSelect: 1, Required: true},
+		&core.SelectField{Name: "severity", Values: []string{"low", "medium", "high"}, MaxSelect: 1},
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+	if err := app.Save(filterTerms); err != nil {
+		return err
+	}
+
+	apiKeys := core.NewBaseCollection("api_keys")
+	apiKeys.Fields.Add(
+		&core.TextField{Name: "user_id", Required: true},
+		&core.TextField{Name: "name", Required: true},
+		&core.TextField{Name: "key_hash", Required: true},
+		&core.TextField{Name: "prefix"},
+		&core.SelectField{Name: "scopes", Values: []string{"images:read", "generate:write", "financial:read", "hooks:write"}, MaxSelect: 4},
+		&core.DateField{Name: "last_used_at"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+	if err := app.Save(apiKeys); err != nil {
+		return err
+	}
+
+	trustedDevices := core.NewBaseCollection("trusted_devices")
+	trustedDevices.Fields.Add(
+		&core.TextField{Name: "user_id", Required: true},
+		&core.TextField{Name: "name", Required: true},
+		&core.TextField{Name: "device_hash", Required: true},
+		&core.TextField{Name: "token_hash", Required: true},
+		&core.TextField{Name: "previous_token_hash"},
+		&core.TextField{Name: "encrypted_token", Required: true},
+		&core.TextField{Name: "salt", Required: true},
+		&core.DateField{Name: "expires_at", Required: true},
+		&core.DateField{Name: "last_used_at"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+	if err := app.Save(trustedDevices); err != nil {
+		return err
+	}
+
+	organizations := core.NewBaseCollection("organizations")
+	organizations.Fields.Add(
+		&core.TextField{Name: "name", Required: true},
+		&core.TextField{Name: "owner_id", Required: true},
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+	if err := app.Save(organizations); err != nil {
+		return err
+	}
+
+	members := core.NewBaseCollection("organization_members")
+	members.Fields.Add(
+		&core.TextField{Name: "org_id", Required: true},
+		&core.TextField{Name: "user_id", Required: true},
+		&core.SelectField{Name: "role", Values: []string{"owner", "admin", "member", "viewer"}, MaxSelect: 1, Required: true},
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+	if err := app.Save(members); err != nil {
+		return err
+	}
+
+	chatWebhooks := core.NewBaseCollection("chat_webhooks")
+	chatWebhooks.Fields.Add(
+		&core.TextField{Name: "user_id", Required: true},
+		&core.TextField{Name: "channel", Required: true},
+		&core.TextField{Name: "url", Required: true},
+		&core.JSONField{Name: "events"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+	)
+	if err := app.Save(chatWebhooks); err != nil {
+		return err
+	}
+
+	galleryFeeds := core.NewBaseCollection("gallery_feeds")
+	galleryFeeds.Fields.Add(
+		&core.TextField{Name: "user_id", Required: true},
+		&core.TextField{Name: "folder_id"},
+		&core.TextField{Name: "title"},
+		&core.TextField{Name: "token", Required: true},
+		&core.AutodateField{Name: "created", OnCreate: true},
+	)
+	if err := app.Save(galleryFeeds); err != nil {
+		return err
+	}
+
+	imageEmbeddings := core.NewBaseCollection("image_embeddings")
+	imageEmbeddings.Fields.Add(
+		&core.TextField{Name: "image_id", Required: true},
+		&core.TextField{Name: "user_id", Required: true},
+		&core.TextField{Name: "model", Required: true},
+		&core.JSONField{Name: "vector"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+	)
+	if err := app.Save(imageEmbeddings); err != nil {
+		return err
+	}
+
+	hookSubscriptions := core.NewBaseCollection("hook_subscriptions")
+	hookSubscriptions.Fields.Add(
+		&core.TextField{Name: "user_id", Required: true},
+		&core.TextField{Name: "event", Required: true},
+		&core.TextField{Name: "target_url", Required: true},
+		&core.TextField{Name: "secret", Required: true},
+		&core.BoolField{Name: "enabled"},
+		&core.NumberField{Name: "failures"},
+		&core.TextField{Name: "last_error"},
+		&core.TextField{Name: "disabled_reason"},
+		&core.DateField{Name: "last_delivery_at"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+	)
+	if err := app.Save(hookSubscriptions); err != nil {
+		return err
+	}
+
+	folderPermissions := core.NewBaseCollection("folder_permissions")
+	folderPermissions.Fields.Add(
+		&core.TextField{Name: "folder_id", Required: true},
+		&core.TextField{Name: "user_id", Required: true},
+		&core.SelectField{Name: "role", Values: []string{"editor", "viewer"}, MaxSelect: 1, Required: true},
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+	if err := app.Save(folderPermissions); err != nil {
+		return err
+	}
+
+	invitations := core.NewBaseCollection("invitations")
+	invitations.Fields.Add(
+		&core.SelectField{Name: "kind", Values: []string{"folder", "org"}, MaxSelect: 1, Required: true},
+		&core.TextField{Name: "target_id", Required: true},
+		&core.EmailField{Name: "email", Required: true},
+		&core.TextField{Name: "role", Required: true},
+		&core.TextField{Name: "inviter_id"},
+		&core.TextField{Name: "token_hash", Required: true},
+		&core.SelectField{Name: "status", Values: []string{"pending", "accepted", "revoked"}, MaxSelect: 1, Required: true},
+		&core.DateField{Name: "expires_at"},
+		&core.TextField{Name: "accepted_by"},
+		&core.DateField{Name: "accepted_at"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+	if err := app.Save(invitations); err != nil {
+		return err
+	}
+
+	communityPrompts := core.NewBaseCollection("community_prompts")
+	communityPrompts.Fields.Add(
+		&core.TextField{Name: "user_id", Required: true},
+		&core.TextField{Name: "title", Required: true},
+		&core.TextField{Name: "prompt", Required: true},
+		&core.TextField{Name: "model", Required: true},
+		&core.JSONField{Name: "parameters"},
+		&core.JSONField{Name: "example_ids"},
+		&core.NumberField{Name: "likes"},
+		&core.NumberField{Name: "uses"},
+		&core.NumberField{Name: "reports"},
+		&core.BoolField{Name: "hidden"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+	if err := app.Save(communityPrompts); err != nil {
+		return err
+	}
+
+	promptLikes := core.NewBaseCollection("community_prompt_likes")
+	promptLikes.Fields.Add(
+		&core.TextField{Name: "prompt_id", Required: true},
+		&core.TextField{Name: "user_id", Required: true},
+		&core.AutodateField{Name: "created", OnCreate: true},
+	)
+	if err := app.Save(promptLikes); err != nil {
+		return err
+	}
+
+	promptReports := core.NewBaseCollection("community_prompt_reports")
+	promptReports.Fields.Add(
+		&core.TextField{Name: "prompt_id", Required: true},
+		&core.TextField{Name: "user_id", Required: true},
+		&core.TextField{Name: "reason"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+	)
+	if err := app.Save(promptReports); err != nil {
+		return err
+	}
+
+	generationBatches := core.NewBaseCollection("generation_batches")
+	generationBatches.Fields.Add(
+		&core.TextField{Name: "user_id", Required: true},
+		&core.TextField{Name: "org_id"},
+		&core.TextField{Name: "file_name"},
+		&core.NumberField{Name: "rows", OnlyInt: true},
+		&core.JSONField{Name: "run_ids"},
+		&core.JSONField{Name: "rejected"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+	)
+	if err := app.Save(generationBatches); err != nil {
+		return err
+	}
+
+	pipelineRuns := core.NewBaseCollection("pipeline_runs")
+	pipelineRuns.Fields.Add(
+		&core.TextField{Name: "user_id", Required: true},
+		&core.TextField{Name: "org_id"},
+		&core.TextField{Name: "status", Required: true},
+		&core.JSONField{Name: "steps"},
+		&core.JSONField{Name: "template"},
+		&core.TextField{Name: "priority"},
+		&core.NumberField{Name: "total_cost"},
+		&core.TextField{Name: "error"},
+		&core.DateField{Name: "finished_at"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+	if err := app.Save(pipelineRuns); err != nil {
+		return err
+	}
+
+	smartFolders := core.NewBaseCollection("smart_folders")
+	smartFolders.Fields.Add(
+		&core.TextField{Name: "user_id", Required: true},
+		&core.TextField{Name: "name", Required: true},
+		&core.JSONField{Name: "filter"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+	if err := app.Save(smartFolders); err != nil {
+		return err
+	}
+
+	pipelineTemplates := core.NewBaseCollection("pipeline_templates")
+	pipelineTemplates.Fields.Add(
+		&core.TextField{Name: "user_id", Required: true},
+		&core.TextField{Name: "org_id"},
+		&core.TextField{Name: "name", Required: true},
+		&core.TextField{Name: "description"},
+		&core.NumberField{Name: "version", OnlyInt: true},
+		&core.JSONField{Name: "steps"},
+		&core.JSONField{Name: "variables"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+	if err := app.Save(pipelineTemplates); err != nil {
+		return err
+	}
+
+	templateVersions := core.NewBaseCollection("pipeline_template_versions")
+	templateVersions.Fields.Add(
+		&core.TextField{Name: "template_id", Required: true},
+		&core.NumberField{Name: "version", OnlyInt: true},
+		&core.TextField{Name: "user_id", Required: true},
+		&core.JSONField{Name: "steps"},
+		&core.JSONField{Name: "variables"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+	)
+	if err := app.Save(templateVersions); err != nil {
+		return err
+	}
+
+	customModels := core.NewBaseCollection("custom_models")
+	customModels.Fields.Add(
+		&core.TextField{Name: "user_id", Required: true},
+		&core.TextField{Name: "name", Required: true},
+		&core.TextField{Name: "display_name"},
+		&core.TextField{Name: "description"},
+		&core.TextField{Name: "endpoint", Required: true},
+		&core.NumberField{Name: "cost_per_image"},
+		&core.JSONField{Name: "parameters"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+	if err := app.Save(customModels); err != nil {
+		return err
+	}
+
+	modelAliases := core.NewBaseCollection("model_aliases")
+	modelAliases.Fields.Add(
+		&core.TextField{Name: "user_id", Required: true},
+		&core.TextField{Name: "name", Required: true},
+		&core.TextField{Name: "model", Required: true},
+		&core.JSONField{Name: "parameters"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+		&core.AutodateField{Name: "updated", OnCreate: true, OnUpdate: true},
+	)
+	if err := app.Save(modelAliases); err != nil {
+		return err
+	}
+
+	generationJobs := core.NewBaseCollection("generation_jobs")
+	generationJobs.Fields.Add(
+		&core.TextField{Name: "user_id", Required: true},
+		&core.TextField{Name: "model", Required: true},
+		&core.NumberField{Name: "duration_ms"},
+		&core.BoolField{Name: "success"},
+		&core.NumberField{Name: "cost"},
+		&core.TextField{Name: "prompt"},
+		&core.JSONField{Name: "parameters"},
+		&core.TextField{Name: "error_code"},
+		&core.TextField{Name: "error_message"},
+		&core.BoolField{Name: "retryable"},
+		&core.TextField{Name: "request_id"},
+		&core.DateField{Name: "retried_at"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+	)
+	if err := app.Save(generationJobs); err != nil {
+		return err
+	}
+
+	spendingAnomalies := core.NewBaseCollection("spending_anomalies")
+	spendingAnomalies.Fields.Add(
+		&core.TextField{Name: "user_id", Required: true},
+		&core.NumberField{Name: "spent"},
+		&core.NumberField{Name: "daily_average"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+	)
+	if err := app.Save(spendingAnomalies); err != nil {
+		return err
+	}
+
+	userBudgets := core.NewBaseCollection("user_budgets")
+	userBudgets.Fields.Add(
+		&core.TextField{Name: "user_id", Required: true},
+		&core.NumberField{Name: "monthly_budget"},
+		&core.NumberField{Name: "daily_images", OnlyInt: true},
+		&core.NumberField{Name: "credit"},
+		&core.TextField{Name: "credit_month"},
+		&core.DateField{Name: "quota_reset_at"},
+	)
+	if err := app.Save(userBudgets); err != nil {
+		return err
+	}
+
+	auditLog := core.NewBaseCollection("audit_log")
+	auditLog.Fields.Add(
+		&core.TextField{Name: "actor_id", Required: true},
+		&core.TextField{Name: "action", Required: true},
+		&core.TextField{Name: "target_id"},
+		&core.TextField{Name: "reason"},
+		&core.JSONField{Name: "details"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+	)
+	return app.Save(auditLog)
+}
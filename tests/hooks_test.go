@@ -0,0 +1,377 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"generatio-pb/internal/apikeys"
+	"generatio-pb/internal/hooks"
+	"generatio-pb/internal/netguard"
+	"generatio-pb/internal/notify"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testHookSecret signs the deliveries of subscriptions saved by saveHook
+const testHookSecret = "whsec_testsecret"
+
+// saveHook saves an enabled subscription of the seeded user
+func saveHook(t testing.TB, env *testEnv, id, event, targetURL string) *core.Record {
+	collection, err := env.app.FindCollectionByNameOrId(hooks.Collection)
+	require.NoError(t, err)
+	record := core.NewRecord(collection)
+	record.Id = id
+	record.Set("user_id", env.user.Id)
+	record.Set("event", event)
+	record.Set("target_url", targetURL)
+	record.Set("secret", testHookSecret)
+	record.Set("enabled", true)
+	require.NoError(t, env.app.Save(record))
+	return record
+}
+
+// withHook subscribes the seeded user's testhook0000001 to event
+func withHook(event string) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		saveHook(t, env, "testhook0000001", event, "https://automation.test/catch")
+	}
+}
+
+// hookMessages returns the queued deliveries of event
+func hookMessages(t testing.TB, env *testEnv, event string) []*core.Record {
+	records, err := env.app.FindRecordsByFilter(notify.OutboxCollection, "channel = {:channel} && event = {:event}", "", 0, 0,
+		map[string]any{"channel": hooks.Channel, "event": event})
+	require.NoError(t, err)
+	return records
+}
+
+func TestHookDeliveries(t *testing.T) {
+	type delivery struct {
+		header http.Header
+		body   []byte
+	}
+
+	// The test receiver is on loopback, which is refused unless allowed
+	setupGuarded := func(t *testing.T, status int, allowPrivate bool) (*testEnv, *notify.Service, *hooks.Service, *core.Record, chan delivery) {
+		env := newTestEnv(t)
+		t.Cleanup(env.app.Cleanup)
+
+		received := make(chan delivery, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			received <- delivery{header: r.Header, body: body}
+			w.WriteHeader(status)
+			w.Write([]byte("internal service details"))
+		}))
+		t.Cleanup(server.Close)
+
+		notifier := notify.NewService(env.app)
+		service := hooks.NewService(env.app, notifier)
+		service.SetAllowPrivateHosts(allowPrivate)
+		subscription := saveHook(t, env, "deliveryhook001", hooks.EventJobFailed, server.URL)
+		return env, notifier, service, subscription, received
+	}
+	setup := func(t *testing.T, status int) (*testEnv, *notify.Service, *hooks.Service, *core.Record, chan delivery) {
+		return setupGuarded(t, status, true)
+	}
+
+	t.Run("SignsDeliveries", func(t *testing.T) {
+		env, notifier, service, subscription, received := setup(t, http.StatusOK)
+
+		require.NoError(t, service.Publish(env.user.Id, hooks.EventJobFailed, "Your generation failed", map[string]interface{}{"model": "flux/schnell"}))
+		notifier.ProcessDue(context.Background())
+		got := <-received
+
+		messages := hookMessages(t, env, hooks.EventJobFailed)
+		require.Len(t, messages, 1)
+		assert.Equal(t, notify.StatusSent, messages[0].GetString("status"))
+
+		assert.Equal(t, hooks.EventJobFailed, got.header.Get(hooks.EventHeader))
+		assert.Equal(t, messages[0].Id, got.header.Get(hooks.DeliveryHeader))
+		signature := got.header.Get(hooks.SignatureHeader)
+		timestamp, err := strconv.ParseInt(strings.TrimPrefix(strings.Split(signature, ",")[0], "t="), 10, 64)
+		require.NoError(t, err)
+		assert.Equal(t, hooks.Sign(testHookSecret, timestamp, got.body), signature)
+
+		var body hooks.Delivery
+		require.NoError(t, json.Unmarshal(got.body, &body))
+		assert.Equal(t, messages[0].Id, body.ID)
+		assert.Equal(t, subscription.Id, body.SubscriptionID)
+		assert.Equal(t, map[string]interface{}{"model": "flux/schnell"}, body.Data)
+
+		record, err := env.app.FindRecordById(hooks.Collection, subscription.Id)
+		require.NoError(t, err)
+		assert.False(t, record.GetDateTime("last_delivery_at").IsZero())
+	})
+
+	t.Run("DisablesAfterRepeatedFailures", func(t *testing.T) {
+		env, notifier, service, subscription, received := setup(t, http.StatusInternalServerError)
+		subscription.Set("failures", hooks.MaxConsecutiveFailures-1)
+		require.NoError(t, env.app.Save(subscription))
+
+		require.NoError(t, service.Publish(env.user.Id, hooks.EventJobFailed, "Your generation failed", nil))
+		notifier.ProcessDue(context.Background())
+		<-received
+
+		record, err := env.app.FindRecordById(hooks.Collection, subscription.Id)
+		require.NoError(t, err)
+		assert.False(t, record.GetBool("enabled"))
+		assert.Equal(t, "10 deliveries in a row failed", record.GetString("disabled_reason"))
+		assert.Equal(t, "target returned HTTP 500", record.GetString("last_error"), "the response body is never kept")
+
+		// Disabled subscriptions receive nothing
+		require.NoError(t, service.Publish(env.user.Id, hooks.EventJobFailed, "Your generation failed", nil))
+		assert.Len(t, hookMessages(t, env, hooks.EventJobFailed), 1)
+	})
+
+	t.Run("GoneDisablesAtOnce", func(t *testing.T) {
+		env, notifier, service, subscription, received := setup(t, http.StatusGone)
+
+		require.NoError(t, service.Publish(env.user.Id, hooks.EventJobFailed, "Your generation failed", nil))
+		notifier.ProcessDue(context.Background())
+		<-received
+
+		record, err := env.app.FindRecordById(hooks.Collection, subscription.Id)
+		require.NoError(t, err)
+		assert.False(t, record.GetBool("enabled"))
+		assert.Equal(t, "the target answered 410 Gone", record.GetString("disabled_reason"))
+
+		messages := hookMessages(t, env, hooks.EventJobFailed)
+		require.Len(t, messages, 1)
+		assert.Equal(t, notify.StatusDead, messages[0].GetString("status"))
+		assert.Equal(t, 1, messages[0].GetInt("attempts"))
+	})
+
+	t.Run("RefusesPrivateAddresses", func(t *testing.T) {
+		env, notifier, service, subscription, received := setupGuarded(t, http.StatusOK, false)
+
+		require.NoError(t, service.Publish(env.user.Id, hooks.EventJobFailed, "Your generation failed", nil))
+		notifier.ProcessDue(context.Background())
+		assert.Empty(t, received)
+
+		record, err := env.app.FindRecordById(hooks.Collection, subscription.Id)
+		require.NoError(t, err)
+		assert.Contains(t, record.GetString("last_error"), netguard.ErrBlockedAddress.Error())
+		assert.Equal(t, 1, record.GetInt("failures"))
+	})
+
+	t.Run("PublishesSavedImages", func(t *testing.T) {
+		env, _, _, _, _ := setup(t, http.StatusOK)
+		saveHook(t, env, "imagehook000001", hooks.EventImageCreated, "https://automation.test/catch")
+
+		image := env.createImage(t, nil)
+
+		messages := hookMessages(t, env, hooks.EventImageCreated)
+		require.Len(t, messages, 1)
+		var data map[string]interface{}
+		require.NoError(t, messages[0].UnmarshalJSONField("data", &data))
+		assert.Equal(t, image.Id, data["id"])
+		assert.Equal(t, "a lighthouse at dusk", data["prompt"])
+	})
+}
+
+func TestHookRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "subscriptions are created with a signing secret",
+			method:             http.MethodPost,
+			url:                "/api/custom/hooks",
+			body:               `{"event":"image.created","target_url":"https://automation.test/catch"}`,
+			headers:            withAPIKey(apikeys.ScopeHooksWrite),
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"event":"image.created"`, `"enabled":true`, `"secret":"whsec_`},
+			notExpectedContent: []string{`"user_id"`},
+		},
+		{
+			name:            "API keys need the hooks:write scope",
+			method:          http.MethodPost,
+			url:             "/api/custom/hooks",
+			body:            `{"event":"image.created","target_url":"https://automation.test/catch"}`,
+			headers:         withAPIKey(apikeys.ScopeImagesRead),
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"API key is missing the hooks:write scope"},
+		},
+		{
+			name:            "unknown events are rejected",
+			method:          http.MethodPost,
+			url:             "/api/custom/hooks",
+			body:            `{"event":"image.liked","target_url":"https://automation.test/catch"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`unknown event \"image.liked\"`},
+		},
+		{
+			name:            "targets must be https",
+			method:          http.MethodPost,
+			url:             "/api/custom/hooks",
+			body:            `{"event":"job.failed","target_url":"http://automation.test/catch"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"target_url must be an https URL"},
+		},
+		{
+			name:            "loopback targets are rejected",
+			method:          http.MethodPost,
+			url:             "/api/custom/hooks",
+			body:            `{"event":"job.failed","target_url":"https://127.0.0.1:8090/api/admin"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"target_url must be a public address"},
+		},
+		{
+			name:            "targets cannot be moved to private addresses",
+			method:          http.MethodPatch,
+			url:             "/api/custom/hooks/testhook0000001",
+			body:            `{"target_url":"https://169.254.169.254/latest/meta-data"}`,
+			setup:           withHook(hooks.EventJobFailed),
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"target_url must be a public address"},
+		},
+		{
+			name:               "listings do not show the secret",
+			method:             http.MethodGet,
+			url:                "/api/custom/hooks",
+			setup:              withHook(hooks.EventJobFailed),
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"id":"testhook0000001"`, `"events":["image.created","job.failed","budget.exceeded"]`},
+			notExpectedContent: []string{testHookSecret},
+		},
+		{
+			name:   "disabled subscriptions can be re-enabled",
+			method: http.MethodPatch,
+			url:    "/api/custom/hooks/testhook0000001",
+			body:   `{"enabled":true}`,
+			setup: func(t testing.TB, env *testEnv) {
+				record := saveHook(t, env, "testhook0000001", hooks.EventJobFailed, "https://automation.test/catch")
+				record.Set("enabled", false)
+				record.Set("failures", hooks.MaxConsecutiveFailures)
+				record.Set("disabled_reason", "10 deliveries in a row failed")
+				require.NoError(t, env.app.Save(record))
+			},
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"enabled":true`, `"failures":0`},
+			notExpectedContent: []string{"disabled_reason"},
+		},
+		{
+			name:   "other users' subscriptions are hidden",
+			method: http.MethodGet,
+			url:    "/api/custom/hooks/testhook0000001",
+			setup:  withHook(hooks.EventJobFailed),
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				other := env.createUser(t, "hookstranger001", "hook-stranger@test.com")
+				token, err := other.NewAuthToken()
+				require.NoError(t, err)
+				return map[string]string{"Authorization": token}
+			},
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"Subscription not found"},
+		},
+		{
+			name:            "subscriptions can be deleted",
+			method:          http.MethodDelete,
+			url:             "/api/custom/hooks/testhook0000001",
+			setup:           withHook(hooks.EventJobFailed),
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				_, err := env.app.FindRecordById(hooks.Collection, "testhook0000001")
+				assert.Error(t, err)
+			},
+		},
+		{
+			name:   "failed generations are published",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"model":"flux/schnell","prompt":"a red fox"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withHook(hooks.EventJobFailed)(t, env)
+				failModel("flux/schnell")(t, env)
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusBadGateway,
+			expectedContent: []string{`"error":`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				messages := hookMessages(t, env, hooks.EventJobFailed)
+				require.Len(t, messages, 1)
+				var data map[string]interface{}
+				require.NoError(t, messages[0].UnmarshalJSONField("data", &data))
+				assert.Equal(t, "generation", data["kind"])
+				assert.Equal(t, "flux/schnell", data["model"])
+			},
+		},
+		{
+			name:   "failed pipeline runs are published",
+			method: http.MethodPost,
+			url:    "/api/custom/pipelines",
+			body:   `{"steps":[{"type":"generate","model":"flux/schnell","prompt":"a red fox","max_attempts":1}]}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withHook(hooks.EventJobFailed)(t, env)
+				failModel("flux/schnell")(t, env)
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusAccepted,
+			expectedContent: []string{`"status":"queued"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				run := processedRun(t, env, res)
+
+				messages := hookMessages(t, env, hooks.EventJobFailed)
+				require.Len(t, messages, 1)
+				var data map[string]interface{}
+				require.NoError(t, messages[0].UnmarshalJSONField("data", &data))
+				assert.Equal(t, "pipeline", data["kind"])
+				assert.Equal(t, run.ID, data["run_id"])
+			},
+		},
+		{
+			name:   "budget refusals are published",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"model":"flux/schnell","prompt":"a red fox"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withHook(hooks.EventBudgetExceeded)(t, env)
+				withBudgetSpent(t, env)
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusPaymentRequired,
+			expectedContent: []string{"monthly budget is exhausted"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				messages := hookMessages(t, env, hooks.EventBudgetExceeded)
+				require.Len(t, messages, 1)
+				var data map[string]interface{}
+				require.NoError(t, messages[0].UnmarshalJSONField("data", &data))
+				assert.Equal(t, "monthly_budget", data["limit"])
+			},
+		},
+		{
+			name:   "budget refusals are published once per period",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"model":"flux/schnell","prompt":"a red fox"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withHook(hooks.EventBudgetExceeded)(t, env)
+				withBudgetSpent(t, env)
+				// An earlier refusal this month was already published
+				service := hooks.NewService(env.app, notify.NewService(env.app))
+				require.NoError(t, service.Publish(env.user.Id, hooks.EventBudgetExceeded, "Your monthly budget is exhausted", nil))
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusPaymentRequired,
+			expectedContent: []string{"monthly budget is exhausted"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Len(t, hookMessages(t, env, hooks.EventBudgetExceeded), 1)
+			},
+		},
+	})
+}
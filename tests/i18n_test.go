@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/i18n"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withLanguage returns headers asking for responses in acceptLanguage,
+// authenticated when auth is set
+func withLanguage(acceptLanguage string, auth bool) func(t testing.TB, env *testEnv) map[string]string {
+	return func(t testing.TB, env *testEnv) map[string]string {
+		headers := map[string]string{}
+		if auth {
+			headers = env.authHeaders()
+		}
+		headers["Accept-Language"] = acceptLanguage
+		return headers
+	}
+}
+
+func TestNegotiateLocale(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                       i18n.DefaultLocale,
+		"de":                     "de",
+		"de-AT":                  "de",
+		"FR-ca, en;q=0.8":        "fr",
+		"ja, es;q=0.5, de;q=0.7": "de",
+		"es;q=0, fr;q=0.3":       "fr",
+		"ja, *;q=0.5":            i18n.DefaultLocale,
+		"pt-BR, en-GB;q=0.9":     i18n.DefaultLocale,
+		"de;q=bogus, fr;q=0.2":   "fr",
+	} {
+		assert.Equal(t, want, i18n.Negotiate(header), header)
+	}
+	assert.Equal(t, []string{"de", "en", "es", "fr"}, i18n.Supported())
+}
+
+func TestLocalizedErrorRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "errors are translated by code",
+			method:             http.MethodGet,
+			url:                "/api/custom/collections",
+			headers:            withLanguage("de-DE,de;q=0.9,en;q=0.8", false),
+			expectedStatus:     http.StatusUnauthorized,
+			expectedContent:    []string{`"code":"authentication_error"`, `"message":"Anmeldung erforderlich."`, `"detail":"Authentication required"`},
+			notExpectedContent: []string{`"message":"Authentication required"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, "de", res.Header.Get("Content-Language"))
+				assert.Contains(t, res.Header.Values("Vary"), "Accept-Language")
+			},
+		},
+		{
+			name:            "field details keep their codes",
+			method:          http.MethodPost,
+			url:             "/api/custom/auth/create-session",
+			body:            `{}`,
+			headers:         withLanguage("fr", true),
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`, `"message":"La requête n'est pas valide."`, `"detail":"`, `"details":{"password":"is required"}`},
+		},
+		{
+			name:               "unsupported languages get the handler's message",
+			method:             http.MethodGet,
+			url:                "/api/custom/collections/missingfolder01/images",
+			headers:            withLanguage("ja", true),
+			expectedStatus:     http.StatusNotFound,
+			expectedContent:    []string{`"code":"not_found"`, `"message":"Folder not found"`},
+			notExpectedContent: []string{`"detail":`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, i18n.DefaultLocale, res.Header.Get("Content-Language"))
+				assert.Contains(t, res.Header.Values("Vary"), "Accept-Language")
+			},
+		},
+		{
+			name:               "successful responses are not translated",
+			method:             http.MethodGet,
+			url:                "/api/custom/financial/stats",
+			headers:            withLanguage("es", true),
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"total_spent_micros":`},
+			notExpectedContent: []string{`"error":`},
+		},
+	})
+}
@@ -0,0 +1,303 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"generatio-pb/internal/imagecache"
+	"generatio-pb/internal/moderation"
+	"generatio-pb/internal/netguard"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pngBytes is a 1x1 transparent PNG
+var pngBytes = encodePNG(1, 1)
+
+// encodePNG encodes a blank image of the given dimensions
+func encodePNG(width, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+// newImageOrigin serves pngBytes as octet-stream so the cache has to sniff the type
+func newImageOrigin(t testing.TB) (*httptest.Server, *atomic.Int32) {
+	hits := &atomic.Int32{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		if r.URL.Path == "/missing.png" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Path == "/page.png" {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(`<html><script>alert(document.cookie)</script></html>`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(pngBytes)
+	}))
+	t.Cleanup(server.Close)
+	return server, hits
+}
+
+func TestImageCache(t *testing.T) {
+	app, err := tests.NewTestApp()
+	require.NoError(t, err)
+	defer app.Cleanup()
+	require.NoError(t, seedSchema(app))
+
+	origin, hits := newImageOrigin(t)
+	cache := imagecache.NewCache(app, "")
+
+	images, err := app.FindCollectionByNameOrId("images")
+	require.NoError(t, err)
+	newImage := func(id, path string) *core.Record {
+		record := core.NewRecord(images)
+		record.Id = id
+		record.Set("user_id", "someone")
+		record.Set("url", origin.URL+path)
+		require.NoError(t, app.Save(record))
+		return record
+	}
+
+	// The test origin is on loopback, which is refused unless allowed
+	blocked := newImage("blockedimage001", "/image.png")
+	err = cache.Persist(context.Background(), blocked)
+	assert.ErrorIs(t, err, netguard.ErrBlockedAddress)
+	assert.Equal(t, int32(0), hits.Load())
+	require.NoError(t, app.Delete(blocked))
+	cache.SetAllowPrivateHosts(true)
+
+	first := newImage("cachedimage0001", "/image.png")
+	for i := 0; i < 2; i++ {
+		entry, err := cache.Open(context.Background(), first)
+		require.NoError(t, err)
+		body, err := io.ReadAll(entry.File)
+		entry.File.Close()
+		require.NoError(t, err)
+		assert.Equal(t, pngBytes, body)
+		assert.Equal(t, "image/png", entry.ContentType)
+	}
+	assert.Equal(t, int32(1), hits.Load(), "second open should be served from disk")
+	assert.Len(t, first.GetString("content_hash"), 64)
+	assert.Equal(t, len(pngBytes), first.GetInt("content_size"))
+
+	// An identical file from a different URL shares the stored blob
+	second := newImage("cachedimage0002", "/copy.png")
+	require.NoError(t, cache.Persist(context.Background(), second))
+	assert.Equal(t, first.GetString("content_hash"), second.GetString("content_hash"))
+
+	report, err := cache.Report()
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Images)
+	assert.Equal(t, 1, report.UniqueBlobs)
+	assert.Equal(t, int64(len(pngBytes)), report.SavedBytes)
+
+	// The blob survives until its last referencing record is deleted
+	require.NoError(t, app.Delete(first))
+	entry, err := cache.Open(context.Background(), second)
+	require.NoError(t, err)
+	entry.File.Close()
+	assert.Equal(t, int32(2), hits.Load())
+
+	require.NoError(t, app.Delete(second))
+	third := newImage("cachedimage0003", "/image.png")
+	third.Set("content_hash", second.GetString("content_hash"))
+	entry, err = cache.Open(context.Background(), third)
+	require.NoError(t, err)
+	entry.File.Close()
+	assert.Equal(t, int32(3), hits.Load(), "released blob should be downloaded again")
+
+	_, err = cache.Open(context.Background(), newImage("missingimage001", "/missing.png"))
+	assert.Error(t, err)
+}
+
+func TestImageVariants(t *testing.T) {
+	app, err := tests.NewTestApp()
+	require.NoError(t, err)
+	defer app.Cleanup()
+	require.NoError(t, seedSchema(app))
+
+	cache := imagecache.NewCache(app, "")
+	cache.SetVariants([]int{128, 1024}, []string{"jpeg", "webp"})
+
+	images, err := app.FindCollectionByNameOrId("images")
+	require.NoError(t, err)
+	record := core.NewRecord(images)
+	record.Set("user_id", "someone")
+	require.NoError(t, cache.Store(record, bytes.NewReader(encodePNG(600, 300))))
+	require.NoError(t, app.Save(record))
+
+	// webp has no encoder, so only jpeg variants are advertised
+	variants := cache.Variants(record.Id)
+	require.Len(t, variants, 1)
+	assert.Equal(t, "/api/custom/images/"+record.Id+"/file?size=128&format=jpeg", variants["jpeg"]["128"])
+	assert.False(t, cache.HasVariant(128, "webp"))
+	assert.False(t, cache.HasVariant(256, "jpeg"))
+
+	cases := []struct {
+		size          int
+		width, height int
+	}{
+		{128, 128, 64},
+		{1024, 600, 300}, // Never upscaled
+	}
+	for _, tc := range cases {
+		entry, err := cache.OpenVariant(context.Background(), record, tc.size, "jpeg")
+		require.NoError(t, err)
+		assert.Equal(t, "image/jpeg", entry.ContentType)
+
+		decoded, format, err := image.Decode(entry.File)
+		entry.File.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "jpeg", format)
+		assert.Equal(t, tc.width, decoded.Bounds().Dx())
+		assert.Equal(t, tc.height, decoded.Bounds().Dy())
+	}
+}
+
+func TestImageFileRoute(t *testing.T) {
+	origin, _ := newImageOrigin(t)
+
+	imageAt := func(id, path string, fields map[string]any) func(t testing.TB, env *testEnv) {
+		return func(t testing.TB, env *testEnv) {
+			if fields == nil {
+				fields = map[string]any{}
+			}
+			fields["id"] = id
+			fields["url"] = origin.URL + path
+			env.createImage(t, fields)
+		}
+	}
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "streams the image with its content type",
+			method:          http.MethodGet,
+			url:             "/api/custom/images/proxyimage00001/file",
+			setup:           imageAt("proxyimage00001", "/image.png", nil),
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{"PNG"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, "image/png", res.Header.Get("Content-Type"))
+				assert.Equal(t, "bytes", res.Header.Get("Accept-Ranges"))
+				assert.Equal(t, "nosniff", res.Header.Get("X-Content-Type-Options"))
+				assert.Contains(t, res.Header.Get("Content-Security-Policy"), "default-src 'none'")
+			},
+		},
+		{
+			name:   "supports range requests",
+			method: http.MethodGet,
+			url:    "/api/custom/images/proxyimage00001/file",
+			setup:  imageAt("proxyimage00001", "/image.png", nil),
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				headers := env.authHeaders()
+				headers["Range"] = "bytes=1-3"
+				return headers
+			},
+			expectedStatus:  http.StatusPartialContent,
+			expectedContent: []string{"PNG"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, fmt.Sprintf("bytes 1-3/%d", len(pngBytes)), res.Header.Get("Content-Range"))
+			},
+		},
+		{
+			name:            "serves resized variants",
+			method:          http.MethodGet,
+			url:             "/api/custom/images/proxyimage00001/file?size=128",
+			setup:           imageAt("proxyimage00001", "/image.png", nil),
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{"\xff\xd8"}, // JPEG start of image marker
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, "image/jpeg", res.Header.Get("Content-Type"))
+			},
+		},
+		{
+			name:            "rejects unconfigured variants",
+			method:          http.MethodGet,
+			url:             "/api/custom/images/proxyimage00001/file?size=300&format=png",
+			setup:           imageAt("proxyimage00001", "/image.png", nil),
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"message":"Unsupported image variant"`},
+		},
+		{
+			name:            "requires auth",
+			method:          http.MethodGet,
+			url:             "/api/custom/images/proxyimage00001/file",
+			setup:           imageAt("proxyimage00001", "/image.png", nil),
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"code":"authentication_error"`},
+		},
+		{
+			name:            "quarantined images are withheld",
+			method:          http.MethodGet,
+			url:             "/api/custom/images/proxyimage00001/file",
+			setup:           imageAt("proxyimage00001", "/image.png", map[string]any{"moderation_status": moderation.StatusQuarantined}),
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{`"code":"authorization_error"`},
+		},
+		{
+			name:            "upstream failures surface as bad gateway",
+			method:          http.MethodGet,
+			url:             "/api/custom/images/proxyimage00001/file",
+			setup:           imageAt("proxyimage00001", "/missing.png", nil),
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadGateway,
+			expectedContent: []string{`"code":"external_error"`},
+		},
+		{
+			name:               "upstream content that is not an image is never served",
+			method:             http.MethodGet,
+			url:                "/api/custom/images/proxyimage00001/file",
+			setup:              imageAt("proxyimage00001", "/page.png", nil),
+			headers:            authOnly,
+			expectedStatus:     http.StatusBadGateway,
+			expectedContent:    []string{`"code":"external_error"`},
+			notExpectedContent: []string{"<script>"},
+		},
+		{
+			name:   "storage report shows deduplication savings",
+			method: http.MethodGet,
+			url:    "/api/custom/admin/storage/report",
+			setup: func(t testing.TB, env *testEnv) {
+				hash := strings.Repeat("ab", 32)
+				env.createImage(t, map[string]any{"content_hash": hash, "content_size": 1000})
+				env.createImage(t, map[string]any{"content_hash": hash, "content_size": 1000})
+				env.createImage(t, map[string]any{"content_hash": strings.Repeat("cd", 32), "content_size": 500})
+				env.createImage(t, map[string]any{"content_hash": strings.Repeat("ef", 32), "content_size": 700, "deleted_at": types.NowDateTime()})
+			},
+			headers:         superuserOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"images":3`, `"unique_blobs":2`, `"logical_bytes":2500`, `"stored_bytes":1500`, `"saved_bytes":1000`},
+		},
+		{
+			name:            "unknown image",
+			method:          http.MethodGet,
+			url:             "/api/custom/images/doesnotexist000/file",
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"code":"not_found"`},
+		},
+	})
+}
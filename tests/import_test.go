@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"testing"
+
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// uploadForm builds a multipart body with the given files and form fields
+func uploadForm(t testing.TB, files map[string][]byte, fields map[string]string) (string, string) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	for name, content := range files {
+		part, err := writer.CreateFormFile("files", name)
+		require.NoError(t, err)
+		_, err = part.Write(content)
+		require.NoError(t, err)
+	}
+	for key, value := range fields {
+		require.NoError(t, writer.WriteField(key, value))
+	}
+	require.NoError(t, writer.Close())
+
+	return body.String(), writer.FormDataContentType()
+}
+
+// createFolder saves a folder owned by the seeded user
+func (env *testEnv) createFolder(t testing.TB, id string) {
+	folders, err := env.app.FindCollectionByNameOrId("folders")
+	require.NoError(t, err)
+
+	record := core.NewRecord(folders)
+	record.Id = id
+	record.Set("user_id", env.user.Id)
+	record.Set("name", "Imports")
+	require.NoError(t, env.app.Save(record))
+}
+
+func TestImportRoutes(t *testing.T) {
+	uploadBody, uploadType := uploadForm(t,
+		map[string][]byte{"pixel.png": pngBytes, "notes.txt": []byte("not an image")},
+		map[string]string{"prompt": "made elsewhere", "model": "midjourney", "folder_id": "importfolder001"},
+	)
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:   "imports images by URL into a folder",
+			method: http.MethodPost,
+			url:    "/api/custom/images/import",
+			body: `{"folder_id":"importfolder001","images":[
+				{"url":"https://example.com/a.png","prompt":"a castle","model":"sdxl"},
+				{"url":"ftp://example.com/b.png"}
+			]}`,
+			setup: func(t testing.TB, env *testEnv) {
+				env.createFolder(t, "importfolder001")
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"source":"https://example.com/a.png"`, `"source":"ftp://example.com/b.png"`, `"error":"only http and https URLs can be imported"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				records, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				require.Len(t, records, 1)
+				assert.Equal(t, "importfolder001", records[0].GetString("folder_id"))
+				assert.Equal(t, "sdxl", records[0].GetString("model"))
+				assert.Equal(t, "a castle", records[0].GetString("prompt"))
+			},
+		},
+		{
+			name:   "refuses URLs on the server's own network",
+			method: http.MethodPost,
+			url:    "/api/custom/images/import",
+			body: `{"images":[
+				{"url":"http://127.0.0.1/admin.png"},
+				{"url":"http://169.254.169.254/latest/meta-data/"},
+				{"url":"http://[::1]:8090/a.png"},
+				{"url":"http://localhost/a.png"},
+				{"url":"http://10.0.0.7/a.png"}
+			]}`,
+			setup: func(t testing.TB, env *testEnv) {
+				env.cfg.AllowPrivateImageURLs = false
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"source":"http://127.0.0.1/admin.png"`, `"source":"http://169.254.169.254/latest/meta-data/"`, `"error":"URLs on private networks cannot be imported"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				var body localmodels.ImportImagesResponse
+				decodeData(t, res, &body)
+				assert.Empty(t, body.Imported)
+				assert.Len(t, body.Failed, 5)
+
+				records, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				assert.Empty(t, records)
+			},
+		},
+		{
+			name:            "rejects folders owned by someone else",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/import",
+			body:            `{"folder_id":"missingfolder01","images":[{"url":"https://example.com/a.png"}]}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"message":"folder not found"`},
+		},
+		{
+			name:            "requires at least one image",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/import",
+			body:            `{"images":[]}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`},
+		},
+		{
+			name:            "requires auth",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/import",
+			body:            `{"images":[{"url":"https://example.com/a.png"}]}`,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"code":"authentication_error"`},
+		},
+		{
+			name:   "imports uploaded files and skips non-images",
+			method: http.MethodPost,
+			url:    "/api/custom/images/import",
+			body:   uploadBody,
+			setup: func(t testing.TB, env *testEnv) {
+				env.createFolder(t, "importfolder001")
+			},
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				headers := env.authHeaders()
+				headers["Content-Type"] = uploadType
+				return headers
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"source":"pixel.png"`, `"title":"pixel.png"`, `/file"`, `"source":"notes.txt"`, `"error":"unsupported file type`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				records, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				require.Len(t, records, 1)
+				assert.Equal(t, "midjourney", records[0].GetString("model"))
+				assert.Equal(t, "importfolder001", records[0].GetString("folder_id"))
+				assert.Len(t, records[0].GetString("content_hash"), 64)
+				assert.Equal(t, len(pngBytes), records[0].GetInt("content_size"))
+			},
+		},
+	})
+}
@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/folderacl"
+	"generatio-pb/internal/invites"
+	"generatio-pb/internal/notify"
+	"generatio-pb/internal/orgs"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testInviteToken = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// seedInvitation stores a pending invitation with a known token for the seeded user
+func seedInvitation(kind, targetID, role string, expires time.Time) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		collection, err := env.app.FindCollectionByNameOrId(invites.Collection)
+		require.NoError(t, err)
+
+		sum := sha256.Sum256([]byte(testInviteToken))
+		expiresAt, err := types.ParseDateTime(expires)
+		require.NoError(t, err)
+
+		record := core.NewRecord(collection)
+		record.Id = "invitation00001"
+		record.Set("kind", kind)
+		record.Set("target_id", targetID)
+		record.Set("email", testEmail)
+		record.Set("role", role)
+		record.Set("inviter_id", "foldercolleague")
+		record.Set("token_hash", hex.EncodeToString(sum[:]))
+		record.Set("status", invites.StatusPending)
+		record.Set("expires_at", expiresAt)
+		require.NoError(t, env.app.Save(record))
+	}
+}
+
+func TestInvitationService(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+
+	withFolderRole("")(t, env)
+	orgService := orgs.NewService(env.app)
+	folders := folderacl.NewService(env.app, orgService)
+	service := invites.NewService(env.app, orgService, folders)
+
+	_, _, err := service.Create("foldercolleague", invites.KindFolder, testFolderID, testEmail, orgs.RoleAdmin)
+	assert.ErrorContains(t, err, "role must be one of: editor, viewer")
+	_, _, err = service.Create("foldercolleague", "album", testFolderID, testEmail, folderacl.RoleViewer)
+	assert.ErrorContains(t, err, "kind must be one of")
+
+	token, invitation, err := service.Create("foldercolleague", invites.KindFolder, testFolderID, strings.ToUpper(testEmail), folderacl.RoleEditor)
+	require.NoError(t, err)
+	assert.Equal(t, invites.StatusPending, invitation.Status)
+	assert.Equal(t, "Shared moodboard", invitation.TargetName)
+
+	// Only the addressee can accept
+	colleague, err := env.app.FindRecordById("generatio_users", "foldercolleague")
+	require.NoError(t, err)
+	_, err = service.Accept(token, colleague)
+	assert.ErrorContains(t, err, "different email")
+
+	accepted, err := service.Accept(token, env.user)
+	require.NoError(t, err)
+	assert.Equal(t, invites.StatusAccepted, accepted.Status)
+	assert.NotNil(t, accepted.AcceptedAt)
+
+	folder, err := env.app.FindRecordById("folders", testFolderID)
+	require.NoError(t, err)
+	assert.Equal(t, folderacl.RoleEditor, folders.Role(folder, env.user.Id))
+
+	_, err = service.Accept(token, env.user)
+	assert.EqualError(t, err, "invitation is accepted")
+	assert.Error(t, service.Revoke(invitation.ID), "accepted invitations cannot be revoked")
+
+	pending, err := service.PendingFor(testEmail)
+	require.NoError(t, err)
+	assert.Empty(t, pending)
+}
+
+func TestInvitationRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "folder owners invite by email",
+			method:             http.MethodPost,
+			url:                "/api/custom/invitations",
+			body:               `{"kind":"folder","target_id":"sharedfolder001","email":"friend@test.com","role":"viewer"}`,
+			setup:              withFolderRole(folderacl.RoleOwner),
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"status":"pending"`, `"email":"friend@test.com"`},
+			notExpectedContent: []string{`"token`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				messages, err := env.app.FindRecordsByFilter(notify.OutboxCollection, "event = {:event}", "", 0, 0, map[string]any{"event": notify.EventInvitation})
+				require.NoError(t, err)
+				require.Len(t, messages, 1)
+				assert.Equal(t, "friend@test.com", messages[0].GetString("target"))
+				assert.Contains(t, messages[0].GetString("body"), "/invitations/accept?token=")
+			},
+		},
+		{
+			name:            "editors cannot invite",
+			method:          http.MethodPost,
+			url:             "/api/custom/invitations",
+			body:            `{"kind":"folder","target_id":"sharedfolder001","email":"friend@test.com","role":"viewer"}`,
+			setup:           withFolderRole(folderacl.RoleEditor),
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{`"code":"authorization_error"`},
+		},
+		{
+			name:            "org members cannot invite to the org",
+			method:          http.MethodPost,
+			url:             "/api/custom/invitations",
+			body:            `{"kind":"org","target_id":"sharedorg000001","email":"friend@test.com","role":"member"}`,
+			setup:           withOrgRole(orgs.RoleMember),
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{`"code":"authorization_error"`},
+		},
+		{
+			name:   "lists pending invitations for the signed-in user",
+			method: http.MethodGet,
+			url:    "/api/custom/invitations/pending",
+			setup: func(t testing.TB, env *testEnv) {
+				withFolderRole("")(t, env)
+				seedInvitation(invites.KindFolder, testFolderID, folderacl.RoleViewer, time.Now().Add(time.Hour))(t, env)
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"id":"invitation00001"`, `"target_name":"Shared moodboard"`},
+		},
+		{
+			name:   "accepting grants folder access",
+			method: http.MethodPost,
+			url:    "/api/custom/invitations/accept",
+			body:   `{"token":"` + testInviteToken + `"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withFolderRole("")(t, env)
+				seedInvitation(invites.KindFolder, testFolderID, folderacl.RoleViewer, time.Now().Add(time.Hour))(t, env)
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"status":"accepted"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				ids, err := folderacl.NewService(env.app, orgs.NewService(env.app)).SharedWith(env.user.Id)
+				require.NoError(t, err)
+				assert.Equal(t, []string{testFolderID}, ids)
+			},
+		},
+		{
+			name:   "accepting adds organization membership",
+			method: http.MethodPost,
+			url:    "/api/custom/invitations/accept",
+			body:   `{"token":"` + testInviteToken + `"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withOrgRole(orgs.RoleViewer)(t, env)
+				seedInvitation(invites.KindOrg, testOrgID, orgs.RoleAdmin, time.Now().Add(time.Hour))(t, env)
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"status":"accepted"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				role, err := orgs.NewService(env.app).Role(testOrgID, env.user.Id)
+				require.NoError(t, err)
+				assert.Equal(t, orgs.RoleAdmin, role)
+			},
+		},
+		{
+			name:   "expired invitations cannot be accepted",
+			method: http.MethodPost,
+			url:    "/api/custom/invitations/accept",
+			body:   `{"token":"` + testInviteToken + `"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withFolderRole("")(t, env)
+				seedInvitation(invites.KindFolder, testFolderID, folderacl.RoleViewer, time.Now().Add(-time.Hour))(t, env)
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"message":"invitation is expired"`},
+		},
+		{
+			name:   "owners revoke pending invitations",
+			method: http.MethodDelete,
+			url:    "/api/custom/invitations/invitation00001",
+			setup: func(t testing.TB, env *testEnv) {
+				withFolderRole(folderacl.RoleOwner)(t, env)
+				seedInvitation(invites.KindFolder, testFolderID, folderacl.RoleViewer, time.Now().Add(time.Hour))(t, env)
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				record, err := env.app.FindRecordById(invites.Collection, "invitation00001")
+				require.NoError(t, err)
+				assert.Equal(t, invites.StatusRevoked, record.GetString("status"))
+			},
+		},
+	})
+}
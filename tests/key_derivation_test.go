@@ -0,0 +1,203 @@
+package tests
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/auth"
+	"generatio-pb/internal/crypto"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withCachedSession stores both keys and gives the user a session that
+// cached the keys derived from the test password, as create-session does
+func withCachedSession(t testing.TB, env *testEnv) {
+	withStoredTestingToken(t, env)
+
+	sessionID, err := env.sessionStore.Create(env.user.Id, testFALToken)
+	require.NoError(t, err)
+	for _, field := range []string{"fal_token", "fal_token_testing"} {
+		salt := strings.Split(env.user.GetString(field), ".")[1]
+		key, err := env.encService.DeriveKey(salt, testPassword)
+		require.NoError(t, err)
+		require.NoError(t, env.sessionStore.CacheDerivedKey(sessionID, salt, testPassword, key))
+	}
+}
+
+func TestDecryptAll(t *testing.T) {
+	service := crypto.NewEncryptionService(1000)
+	service.SetWorkers(1)
+
+	production, err := service.Encrypt(testFALToken, testPassword)
+	require.NoError(t, err)
+	testingKey, err := service.Encrypt(testingFALToken, testPassword)
+	require.NoError(t, err)
+
+	before := service.Derivations()
+	results := service.DecryptAll(testPassword, *production, *testingKey, *production)
+	require.Len(t, results, 3)
+	for _, result := range results {
+		require.NoError(t, result.Err)
+	}
+	assert.Equal(t, testFALToken, results[0].Plaintext)
+	assert.Equal(t, testingFALToken, results[1].Plaintext)
+	assert.Equal(t, testFALToken, results[2].Plaintext)
+	assert.Equal(t, before+2, service.Derivations(), "values sharing a salt derive their key once")
+
+	plaintext, err := service.DecryptWithKey(production.Encrypted, results[0].Key)
+	require.NoError(t, err)
+	assert.Equal(t, testFALToken, plaintext)
+	assert.Equal(t, before+2, service.Derivations())
+
+	_, err = service.DecryptWithKey(production.Encrypted, results[1].Key)
+	assert.Error(t, err, "a key derived for another salt does not decrypt")
+	_, err = service.DecryptWithKey(production.Encrypted, []byte("short"))
+	assert.ErrorContains(t, err, "invalid key size")
+
+	for _, result := range service.DecryptAll("wrongpassword", *production, *testingKey) {
+		assert.Error(t, result.Err)
+		assert.Empty(t, result.Plaintext)
+	}
+
+	// A single worker still serves concurrent callers
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			plaintext, err := service.Decrypt(production.Encrypted, production.Salt, testPassword)
+			assert.NoError(t, err)
+			assert.Equal(t, testFALToken, plaintext)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSessionDerivedKeys(t *testing.T) {
+	service := crypto.NewEncryptionService(1000)
+	result, err := service.Encrypt(testFALToken, testPassword)
+	require.NoError(t, err)
+	key, err := service.DeriveKey(result.Salt, testPassword)
+	require.NoError(t, err)
+
+	store := auth.NewSessionStore(time.Hour)
+	sessionID, err := store.Create("user1", testFALToken)
+	require.NoError(t, err)
+	require.NoError(t, store.CacheDerivedKey(sessionID, result.Salt, testPassword, key))
+	assert.Error(t, store.CacheDerivedKey("missing", result.Salt, testPassword, key))
+
+	cached, ok := store.DerivedKey("user1", result.Salt, testPassword)
+	require.True(t, ok)
+	assert.Equal(t, key, cached)
+
+	_, ok = store.DerivedKey("user1", result.Salt, "wrongpassword")
+	assert.False(t, ok, "another password misses the cache")
+	_, ok = store.DerivedKey("user1", "othersalt", testPassword)
+	assert.False(t, ok)
+	_, ok = store.DerivedKey("user2", result.Salt, testPassword)
+	assert.False(t, ok, "keys are only shared within the user's sessions")
+
+	described, err := store.Describe(sessionID)
+	require.NoError(t, err)
+	assert.Empty(t, described.DerivedKeys)
+
+	session, err := store.Get(sessionID)
+	require.NoError(t, err)
+	stored := session.DerivedKeys[result.Salt].Key
+	require.NoError(t, store.Delete(sessionID))
+	assert.Equal(t, make([]byte, len(stored)), stored, "ending the session wipes its keys")
+	_, ok = store.DerivedKey("user1", result.Salt, testPassword)
+	assert.False(t, ok)
+}
+
+func TestKeyDerivationRoutes(t *testing.T) {
+	var derivations uint64
+	countDerivations := func(t testing.TB, env *testEnv) {
+		derivations = env.encService.Derivations()
+	}
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "create session caches both derived keys",
+			method:          http.MethodPost,
+			url:             "/api/custom/auth/create-session",
+			body:            `{"password":"` + testPassword + `"}`,
+			headers:         authOnly,
+			setup:           withStoredTestingToken,
+			before:          countDerivations,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"environments":["production","testing"]`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, derivations+2, env.encService.Derivations())
+				session, err := env.sessionStore.GetUserSession(env.user.Id)
+				require.NoError(t, err)
+				assert.Len(t, session.DerivedKeys, 2)
+			},
+		},
+		{
+			name:            "a repeated create session reuses the cached keys",
+			method:          http.MethodPost,
+			url:             "/api/custom/auth/create-session",
+			body:            `{"password":"` + testPassword + `"}`,
+			headers:         authOnly,
+			setup:           withCachedSession,
+			before:          countDerivations,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"environments":["production","testing"]`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, derivations, env.encService.Derivations())
+				session, err := env.sessionStore.GetUserSession(env.user.Id)
+				require.NoError(t, err)
+				assert.Equal(t, testingFALToken, session.TestingFALToken)
+				assert.Len(t, session.DerivedKeys, 2, "the new session keeps the keys")
+			},
+		},
+		{
+			name:            "a wrong password is not answered from the cache",
+			method:          http.MethodPost,
+			url:             "/api/custom/auth/create-session",
+			body:            `{"password":"wrongpassword"}`,
+			headers:         authOnly,
+			setup:           withCachedSession,
+			before:          countDerivations,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"message":"Invalid password"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, derivations+2, env.encService.Derivations())
+			},
+		},
+		{
+			name:            "token verify reuses the session's key",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/verify",
+			body:            `{"password":"` + testPassword + `"}`,
+			headers:         authOnly,
+			setup:           withCachedSession,
+			before:          countDerivations,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"can_decrypt":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, derivations, env.encService.Derivations())
+			},
+		},
+		{
+			name:            "token verify without a session derives the key",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/verify",
+			body:            `{"password":"` + testPassword + `"}`,
+			headers:         authOnly,
+			setup:           withStoredToken,
+			before:          countDerivations,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"can_decrypt":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, derivations+1, env.encService.Derivations())
+			},
+		},
+	})
+}
@@ -0,0 +1,230 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/fal"
+	"generatio-pb/internal/fal/faltest"
+	"generatio-pb/internal/keyhealth"
+	"generatio-pb/internal/notify"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withKeyHealthChecks opts the seeded user in to key health checks
+func withKeyHealthChecks(t testing.TB, env *testEnv) {
+	env.user.Set("key_health_checks", true)
+	require.NoError(t, env.app.Save(env.user))
+}
+
+// rejectKeys makes FAL AI answer token validation with err
+func rejectKeys(env *testEnv, err error) {
+	env.falClient.SetValidateTokenFunc(func(ctx context.Context, token string) error {
+		return err
+	})
+}
+
+func TestKeyHealthMonitor(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+
+	monitor := keyhealth.NewMonitor(env.app, env.sessionStore, env.falClient, notify.NewService(env.app), 0)
+	ctx := context.Background()
+
+	reload := func(t *testing.T) keyhealth.Health {
+		user, err := env.app.FindRecordById("generatio_users", env.user.Id)
+		require.NoError(t, err)
+		return keyhealth.Get(user)
+	}
+	outbox := func(t *testing.T) int64 {
+		count, err := env.app.CountRecords(notify.OutboxCollection)
+		require.NoError(t, err)
+		return count
+	}
+
+	t.Run("UsersWhoDidNotOptInAreIgnored", func(t *testing.T) {
+		env.sessionHeaders(t)
+		report, err := monitor.Run(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, keyhealth.Report{}, *report)
+	})
+
+	withKeyHealthChecks(t, env)
+
+	t.Run("HealthyKeysAreRecorded", func(t *testing.T) {
+		report, err := monitor.Run(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Checked)
+		assert.Zero(t, report.Flagged)
+
+		health := reload(t)
+		assert.True(t, health.Healthy())
+		assert.False(t, health.CheckedAt.IsZero())
+		assert.Nil(t, health.FlaggedAt)
+	})
+
+	t.Run("OutagesGiveNoVerdict", func(t *testing.T) {
+		rejectKeys(env, fal.ErrUnreachable)
+		report, err := monitor.Run(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Skipped)
+		assert.True(t, reload(t).Healthy())
+	})
+
+	t.Run("RevokedKeysAreFlaggedAndNotifiedOnce", func(t *testing.T) {
+		rejectKeys(env, &fal.FALError{Code: "invalid_token", Message: "invalid or expired FAL AI token", Status: http.StatusUnauthorized})
+
+		report, err := monitor.Run(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Flagged)
+
+		health := reload(t)
+		assert.Equal(t, keyhealth.StatusRevoked, health.Status)
+		assert.Equal(t, fal.ErrorCodeAuth, health.Code)
+		require.NotNil(t, health.FlaggedAt)
+
+		messages, err := env.app.FindAllRecords(notify.OutboxCollection)
+		require.NoError(t, err)
+		require.Len(t, messages, 1)
+		assert.Equal(t, notify.EventKeyHealth, messages[0].GetString("event"))
+		assert.Equal(t, env.user.Email(), messages[0].GetString("target"))
+
+		report, err = monitor.Run(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, report.Flagged)
+		assert.Equal(t, health.FlaggedAt.Unix(), reload(t).FlaggedAt.Unix())
+		assert.EqualValues(t, 1, outbox(t))
+	})
+
+	t.Run("RateLimitedKeysAreFlagged", func(t *testing.T) {
+		rejectKeys(env, &fal.FALError{Code: "rate_limited", Message: "FAL AI token is rate limited", Status: http.StatusTooManyRequests})
+
+		report, err := monitor.Run(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Flagged)
+		assert.Equal(t, keyhealth.StatusRateLimited, reload(t).Status)
+		assert.EqualValues(t, 2, outbox(t))
+	})
+
+	t.Run("RecoveredKeysClearTheFlag", func(t *testing.T) {
+		rejectKeys(env, nil)
+
+		report, err := monitor.Run(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, report.Flagged)
+		assert.True(t, reload(t).Healthy())
+	})
+
+	t.Run("UsersWithoutASessionAreSkipped", func(t *testing.T) {
+		require.NoError(t, env.sessionStore.DeleteUserSessions(env.user.Id))
+		report, err := monitor.Run(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, report.Checked)
+		assert.Equal(t, 1, report.Skipped)
+	})
+}
+
+func TestFALClientValidateTokenStatuses(t *testing.T) {
+	cases := []struct {
+		status int
+		code   string
+	}{
+		{http.StatusOK, ""},
+		{http.StatusUnprocessableEntity, ""},
+		{http.StatusUnauthorized, fal.ErrorCodeAuth},
+		{http.StatusForbidden, fal.ErrorCodeAuth},
+		{http.StatusTooManyRequests, fal.ErrorCodeRateLimited},
+	}
+	for _, c := range cases {
+		t.Run(http.StatusText(c.status), func(t *testing.T) {
+			client, _ := newFakeFALClient(t, faltest.Scenario{SubmitStatus: c.status, SubmitBody: `{}`})
+			err := client.ValidateToken(context.Background(), testFALToken)
+			if c.code == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, c.code, fal.Classify(err).Code)
+		})
+	}
+}
+
+func TestKeyHealthRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "checks are off by default",
+			method:             http.MethodGet,
+			url:                "/api/custom/auth/key-health",
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"enabled":false`, `"requires_login":true`},
+			notExpectedContent: []string{`"health"`},
+		},
+		{
+			name:            "enabled is required",
+			method:          http.MethodPut,
+			url:             "/api/custom/auth/key-health/settings",
+			body:            `{}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"enabled is required"},
+		},
+		{
+			name:            "opting in checks the session's key straight away",
+			method:          http.MethodPut,
+			url:             "/api/custom/auth/key-health/settings",
+			body:            `{"enabled":true}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"enabled":true`, `"status":"healthy"`},
+		},
+		{
+			name:   "opting in with a revoked key flags it",
+			method: http.MethodPut,
+			url:    "/api/custom/auth/key-health/settings",
+			body:   `{"enabled":true}`,
+			setup: func(t testing.TB, env *testEnv) {
+				rejectKeys(env, &fal.FALError{Code: "invalid_token", Message: "invalid or expired FAL AI token", Status: http.StatusUnauthorized})
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"status":"revoked"`, `"code":"fal_auth"`, `"flagged_at"`},
+		},
+		{
+			name:   "opting out forgets the verdict",
+			method: http.MethodPut,
+			url:    "/api/custom/auth/key-health/settings",
+			body:   `{"enabled":false}`,
+			setup: func(t testing.TB, env *testEnv) {
+				env.user.Set("key_health", keyhealth.Health{Status: keyhealth.StatusRevoked})
+				withKeyHealthChecks(t, env)
+			},
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"enabled":false`},
+			notExpectedContent: []string{`"health"`},
+		},
+		{
+			name:            "key health runs require a superuser",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/key-health/run",
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"Superuser access required"},
+		},
+		{
+			name:   "manual runs check opted-in sessions",
+			method: http.MethodPost,
+			url:    "/api/custom/admin/key-health/run",
+			setup: func(t testing.TB, env *testEnv) {
+				withKeyHealthChecks(t, env)
+				env.sessionHeaders(t)
+			},
+			headers:         superuserOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"checked":1`, `"flagged":0`},
+		},
+	})
+}
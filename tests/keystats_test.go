@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/keystats"
+	"generatio-pb/internal/money"
+
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const clientFALToken = "fal_client_billing_key_9z8y"
+
+// withTwoKeys gives the seeded user two generations with the test key, one
+// of them trashed, one with a client key, one from before keys were tracked and an imported image
+func withTwoKeys(t testing.TB, env *testEnv) {
+	personal := map[string]any{"key_id": keystats.KeyID(testFALToken), "key_hint": keystats.KeyHint(testFALToken)}
+	client := map[string]any{"key_id": keystats.KeyID(clientFALToken), "key_hint": keystats.KeyHint(clientFALToken)}
+	with := func(info map[string]any, cost float64) map[string]any {
+		merged := map[string]any{"cost_usd": cost}
+		for k, v := range info {
+			merged[k] = v
+		}
+		return merged
+	}
+
+	env.createImage(t, map[string]any{"request_id": "req_1", "other_info": with(personal, 0.01)})
+	env.createImage(t, map[string]any{"request_id": "req_1", "other_info": with(personal, 0.01)})
+	env.createImage(t, map[string]any{"request_id": "req_2", "other_info": with(personal, 0.02), "deleted_at": "2026-01-01 00:00:00.000Z"})
+	env.createImage(t, map[string]any{"request_id": "req_3", "other_info": with(client, 0.5)})
+	env.createImage(t, map[string]any{"request_id": "req_0", "other_info": map[string]any{"cost_usd": 0.003}})
+	env.createImage(t, map[string]any{"other_info": map[string]any{"source": "import"}})
+
+	old, err := types.ParseDateTime(time.Now().AddDate(0, 0, -60))
+	require.NoError(t, err)
+	env.createImage(t, map[string]any{"request_id": "req_old", "other_info": with(client, 1.0), "created": old})
+}
+
+func TestKeyUsage(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+	withTwoKeys(t, env)
+
+	assert.Equal(t, keystats.KeyID(testFALToken), keystats.KeyID(testFALToken))
+	assert.NotEqual(t, keystats.KeyID(testFALToken), keystats.KeyID(clientFALToken))
+	assert.NotContains(t, keystats.KeyID(clientFALToken), "9z8y")
+	assert.Equal(t, "…9z8y", keystats.KeyHint(clientFALToken))
+
+	usage, err := keystats.Usage(env.app, env.user, time.Now().AddDate(0, 0, -30), keystats.KeyID(testFALToken))
+	require.NoError(t, err)
+	require.Len(t, usage, 3)
+
+	client, personal, unknown := usage[0], usage[1], usage[2]
+	assert.Equal(t, keystats.KeyID(clientFALToken), client.KeyID)
+	assert.Equal(t, 1, client.Generations)
+	assert.InDelta(t, 0.5, client.Spent, 1e-9)
+	assert.Equal(t, money.FromUSD(0.5), client.SpentMicros)
+	assert.False(t, client.Current)
+
+	assert.Equal(t, keystats.KeyID(testFALToken), personal.KeyID)
+	assert.True(t, personal.Current)
+	assert.Equal(t, 1, personal.Generations, "images of one request are one generation and trashed ones are left out")
+	assert.Equal(t, 2, personal.Images)
+	assert.InDelta(t, 0.02, personal.Spent, 1e-9)
+	assert.False(t, personal.FirstUsedAt.After(personal.LastUsedAt))
+
+	assert.Equal(t, keystats.UnknownKey, unknown.KeyID)
+	assert.Equal(t, 1, unknown.Images)
+
+	t.Run("Labels", func(t *testing.T) {
+		require.NoError(t, keystats.SetLabel(env.user, client.KeyID, "  Acme Corp "))
+		assert.Equal(t, "Acme Corp", keystats.Labels(env.user)[client.KeyID])
+
+		require.NoError(t, keystats.SetLabel(env.user, client.KeyID, ""))
+		assert.NotContains(t, keystats.Labels(env.user), client.KeyID)
+
+		assert.Error(t, keystats.SetLabel(env.user, "unknown", "legacy"))
+		assert.Error(t, keystats.SetLabel(env.user, client.KeyID, string(make([]byte, keystats.MaxLabelLength+1))))
+	})
+}
+
+func TestKeyUsageRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "generated images record their key",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"url":"https://mock-image-url.com/image.jpg"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				usage, err := keystats.Usage(env.app, env.user, time.Now().Add(-time.Hour), "")
+				require.NoError(t, err)
+				require.Len(t, usage, 1)
+				assert.Equal(t, keystats.KeyID(testFALToken), usage[0].KeyID)
+				assert.Equal(t, keystats.KeyHint(testFALToken), usage[0].Hint)
+				assert.Equal(t, 1, usage[0].Generations)
+			},
+		},
+		{
+			name:               "usage is compared per key and marks the session's key",
+			method:             http.MethodGet,
+			url:                "/api/custom/financial/keys",
+			setup:              withTwoKeys,
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"days":30`, `"key_id":"` + keystats.KeyID(clientFALToken) + `"`, `"hint":"…9z8y"`, `"current":true`, `"key_id":"unknown"`},
+			notExpectedContent: []string{clientFALToken, testFALToken},
+		},
+		{
+			name:            "a longer window includes older generations",
+			method:          http.MethodGet,
+			url:             "/api/custom/financial/keys?days=90",
+			setup:           withTwoKeys,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"days":90`, `"spent":1.5`, `"generations":2`},
+		},
+		{
+			name:            "the window is bounded",
+			method:          http.MethodGet,
+			url:             "/api/custom/financial/keys?days=0",
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"days must be between 1 and 365"},
+		},
+		{
+			name:            "keys can be labelled",
+			method:          http.MethodPut,
+			url:             "/api/custom/financial/keys/" + keystats.KeyID(clientFALToken),
+			body:            `{"label":"Acme Corp"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"label":"Acme Corp"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				user, err := env.app.FindRecordById("generatio_users", env.user.Id)
+				require.NoError(t, err)
+				assert.Equal(t, "Acme Corp", keystats.Labels(user)[keystats.KeyID(clientFALToken)])
+			},
+		},
+		{
+			name:            "only key IDs can be labelled",
+			method:          http.MethodPut,
+			url:             "/api/custom/financial/keys/unknown",
+			body:            `{"label":"legacy"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"invalid key ID"},
+		},
+	})
+}
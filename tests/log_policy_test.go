@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"generatio-pb/internal/redact"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loggedPackages must log through a redact.Logger and never pass it secrets
+var loggedPackages = []string{"../internal/handlers", "../internal/fal"}
+
+// loggerMethods are the slog.Logger methods that take attributes
+var loggerMethods = map[string]bool{
+	"Debug": true, "Info": true, "Warn": true, "Error": true, "Log": true, "With": true,
+	"DebugContext": true, "InfoContext": true, "WarnContext": true, "ErrorContext": true,
+}
+
+// unsanitizedLogging are package functions that bypass the sanitizing logger
+var unsanitizedLogging = map[string]bool{
+	"fmt.Print": true, "fmt.Printf": true, "fmt.Println": true,
+	"log.Print": true, "log.Printf": true, "log.Println": true,
+	"log.Fatal": true, "log.Fatalf": true, "log.Fatalln": true,
+	"slog.Debug": true, "slog.Info": true, "slog.Warn": true, "slog.Error": true, "slog.Log": true,
+}
+
+// isSecretName reports whether an identifier or field names a secret, such
+// as req.FALToken, password or sessionID; flags like hasToken do not
+func isSecretName(name string) bool {
+	lower := strings.ToLower(name)
+	if strings.HasPrefix(lower, "has") || strings.HasPrefix(lower, "is") {
+		return false
+	}
+	for _, suffix := range []string{"token", "password", "secret"} {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return lower == "sessionid" || lower == "authorization" || lower == "apikey"
+}
+
+// logPolicyViolations lists the logging calls of a file that could write
+// secrets: unsanitized loggers, and logger arguments reading raw headers or
+// token, password and session fields
+func logPolicyViolations(fset *token.FileSet, file *ast.File) []string {
+	var violations []string
+	report := func(node ast.Node, format string, args ...any) {
+		violations = append(violations, fmt.Sprintf("%s: %s", fset.Position(node.Pos()), fmt.Sprintf(format, args...)))
+	}
+
+	// Logger() may only be called to be wrapped by redact.Logger
+	wrapped := map[ast.Node]bool{}
+	ast.Inspect(file, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return true
+		}
+		if pkg, ok := sel.X.(*ast.Ident); ok {
+			name := pkg.Name + "." + sel.Sel.Name
+			if unsanitizedLogging[name] {
+				report(call, "%s bypasses the sanitizing logger", name)
+			}
+			if name == "redact.Logger" {
+				for _, arg := range call.Args {
+					wrapped[arg] = true
+				}
+			}
+		}
+
+		switch {
+		case sel.Sel.Name == "Logger" && len(call.Args) == 0 && !wrapped[call]:
+			report(call, "Logger() must be wrapped with redact.Logger")
+		case loggerMethods[sel.Sel.Name]:
+			for _, arg := range call.Args {
+				ast.Inspect(arg, func(node ast.Node) bool {
+					switch n := node.(type) {
+					case *ast.CallExpr:
+						// Values passed through the redact package are masked
+						if fn, ok := n.Fun.(*ast.SelectorExpr); ok {
+							if pkg, ok := fn.X.(*ast.Ident); ok && pkg.Name == "redact" {
+								return false
+							}
+						}
+					case *ast.SelectorExpr:
+						if n.Sel.Name == "Header" {
+							report(n, "raw headers are passed to %s", sel.Sel.Name)
+							return false
+						}
+						if isSecretName(n.Sel.Name) {
+							report(n, "%s is passed to %s", n.Sel.Name, sel.Sel.Name)
+							return false
+						}
+					case *ast.Ident:
+						if isSecretName(n.Name) {
+							report(n, "%s is passed to %s", n.Name, sel.Sel.Name)
+						}
+					}
+					return true
+				})
+			}
+		}
+		return true
+	})
+	return violations
+}
+
+func TestLogPolicy(t *testing.T) {
+	for _, dir := range loggedPackages {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.go"))
+		require.NoError(t, err)
+		require.NotEmpty(t, paths, dir)
+
+		for _, path := range paths {
+			if strings.HasSuffix(path, "_test.go") {
+				continue
+			}
+			fset := token.NewFileSet()
+			file, err := parser.ParseFile(fset, path, nil, 0)
+			require.NoError(t, err)
+			assert.Empty(t, logPolicyViolations(fset, file), path)
+		}
+	}
+
+	t.Run("CatchesViolations", func(t *testing.T) {
+		src := `package handlers
+
+func (h *Handler) leak(e *core.RequestEvent, req SetupTokenRequest, sessionID string, hasToken bool) {
+	fmt.Printf("headers: %v\n", e.Request.Header)
+	h.logger.Info("setup", "token", req.FALToken, "has_token", hasToken)
+	h.logger.Debug("session", "id", sessionID)
+	h.app.Logger().Info("unsanitized")
+	h.logger.Warn("agent", "user_agent", e.Request.Header.Get("User-Agent"))
+	logger := redact.Logger(h.app.Logger())
+	logger.Info("fine", "user_id", req.UserID, "headers", redact.Headers(e.Request.Header))
+}
+`
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "leak.go", src, 0)
+		require.NoError(t, err)
+
+		violations := strings.Join(logPolicyViolations(fset, file), "\n")
+		assert.Contains(t, violations, "leak.go:4:2: fmt.Printf bypasses the sanitizing logger")
+		assert.Contains(t, violations, "leak.go:5:34: FALToken is passed to Info")
+		assert.Contains(t, violations, "leak.go:6:34: sessionID is passed to Debug")
+		assert.Contains(t, violations, "leak.go:7:2: Logger() must be wrapped with redact.Logger")
+		assert.Contains(t, violations, "leak.go:8:39: raw headers are passed to Warn")
+		assert.NotContains(t, violations, "hasToken")
+		assert.NotContains(t, violations, ":9:")
+		assert.NotContains(t, violations, ":10:")
+	})
+}
+
+func TestSanitizingLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := redact.Logger(slog.New(slog.NewJSONHandler(&buf, nil)))
+	assert.Same(t, logger, redact.Logger(logger))
+
+	logger.With("password", "hunter2").Info("logged",
+		"fal_token", "fal-secret",
+		"Authorization", "Bearer header-secret",
+		"credential", "Key inline-secret",
+		"headers", http.Header{"X-Session-Id": {"session-secret"}, "Accept": {"*/*"}},
+		slog.Group("request", "refresh_token", "refresh-secret", "user_id", "user123"),
+	)
+
+	logged := buf.String()
+	for _, secret := range []string{"hunter2", "fal-secret", "header-secret", "inline-secret", "session-secret", "refresh-secret"} {
+		assert.NotContains(t, logged, secret)
+	}
+	assert.Contains(t, logged, `"user_id":"user123"`)
+	assert.Contains(t, logged, `"Accept":"*/*"`)
+	assert.Contains(t, logged, `"fal_token":"[REDACTED]"`)
+}
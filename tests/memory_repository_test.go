@@ -0,0 +1,216 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/handlers"
+	"generatio-pb/internal/pagination"
+	"generatio-pb/internal/repository"
+	"generatio-pb/internal/smartfolders"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// copyRecords saves a copy of every record of a collection with save
+func copyRecords(t testing.TB, env *testEnv, collection string, save func(*core.Record) error) {
+	records, err := env.app.FindAllRecords(collection)
+	require.NoError(t, err)
+	for _, record := range records {
+		require.NoError(t, save(record.Clone()))
+	}
+}
+
+// TestMemoryRepositories checks the in-memory repositories answer like the
+// PocketBase ones for the same records
+func TestMemoryRepositories(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+	seedTrashedRecords(t, env)
+	env.createImage(t, map[string]any{"id": "taggedimage0001", "prompt": "A Lighthouse at dusk", "tags": []string{"coast"}, "group_id": "group0000000001"})
+	env.createImage(t, map[string]any{"id": "derivedimage001", "prompt": "a harbour", "parent_id": "taggedimage0001", "group_id": "group0000000001"})
+
+	folders, memFolders := repository.NewFolders(env.app), repository.NewMemoryFolders()
+	images, memImages := repository.NewImages(env.app), repository.NewMemoryImages()
+	copyRecords(t, env, repository.FoldersCollection, memFolders.Save)
+	copyRecords(t, env, repository.ImagesCollection, memImages.Save)
+
+	same := func(t *testing.T, want func() ([]*core.Record, error), got func() ([]*core.Record, error)) {
+		wantRecords, err := want()
+		require.NoError(t, err)
+		gotRecords, err := got()
+		require.NoError(t, err)
+		assert.Equal(t, recordIDs(wantRecords), recordIDs(gotRecords))
+	}
+
+	t.Run("Folders", func(t *testing.T) {
+		_, err := memFolders.Get("trashedchild001")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+
+		same(t, func() ([]*core.Record, error) { return folders.Children("livefolder00001") },
+			func() ([]*core.Record, error) { return memFolders.Children("livefolder00001") })
+		same(t, func() ([]*core.Record, error) { return folders.InLibrary(env.user.Id, "", 0) },
+			func() ([]*core.Record, error) { return memFolders.InLibrary(env.user.Id, "", 0) })
+		same(t, func() ([]*core.Record, error) { return folders.ByIDs([]string{"livechild000001", "trashedchild001"}) },
+			func() ([]*core.Record, error) {
+				return memFolders.ByIDs([]string{"livechild000001", "trashedchild001"})
+			})
+	})
+
+	t.Run("Images", func(t *testing.T) {
+		_, err := memImages.Owned(env.user.Id, "otherimage00001")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+
+		same(t, func() ([]*core.Record, error) { return images.InFolder("livefolder00001", "created", 0, 0) },
+			func() ([]*core.Record, error) { return memImages.InFolder("livefolder00001", "created", 0, 0) })
+		same(t, func() ([]*core.Record, error) { return images.InGroup("group0000000001") },
+			func() ([]*core.Record, error) { return memImages.InGroup("group0000000001") })
+		same(t, func() ([]*core.Record, error) { return images.PromptHistory(env.user.Id, "lighthouse", 10) },
+			func() ([]*core.Record, error) { return memImages.PromptHistory(env.user.Id, "lighthouse", 10) })
+		same(t, func() ([]*core.Record, error) { return images.DerivedFrom("taggedimage0001") },
+			func() ([]*core.Record, error) { return memImages.DerivedFrom("taggedimage0001") })
+
+		page := pagination.Page{Number: 1, Limit: 10}
+		same(t, func() ([]*core.Record, error) {
+			records, _, err := images.TrashPage(env.user.Id, page)
+			return records, err
+		}, func() ([]*core.Record, error) {
+			records, _, err := memImages.TrashPage(env.user.Id, page)
+			return records, err
+		})
+
+		filter := smartfolders.Filter{Tag: "coast", Prompt: "LIGHTHOUSE"}
+		same(t, func() ([]*core.Record, error) {
+			records, _, err := images.Search(env.user.Id, filter, page)
+			return records, err
+		}, func() ([]*core.Record, error) {
+			records, _, err := memImages.Search(env.user.Id, filter, page)
+			return records, err
+		})
+	})
+
+	t.Run("PagesFollowCursors", func(t *testing.T) {
+		records, next, err := memImages.FolderPage("livefolder00001", pagination.Page{Number: 1, Limit: 1})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		require.NotEmpty(t, next)
+
+		cursor, err := pagination.DecodeCursor(next)
+		require.NoError(t, err)
+		rest, next, err := memImages.FolderPage("livefolder00001", pagination.Page{Limit: 1, Cursor: cursor})
+		require.NoError(t, err)
+		assert.Empty(t, next)
+		require.Len(t, rest, 1)
+		assert.NotEqual(t, records[0].Id, rest[0].Id)
+	})
+
+	t.Run("ChangesNeedASave", func(t *testing.T) {
+		image, err := memImages.Get("liveimage000001")
+		require.NoError(t, err)
+		image.Set("prompt", "changed")
+
+		stored, err := memImages.Get("liveimage000001")
+		require.NoError(t, err)
+		assert.NotEqual(t, "changed", stored.GetString("prompt"))
+
+		require.NoError(t, memImages.Save(image))
+		stored, err = memImages.Get("liveimage000001")
+		require.NoError(t, err)
+		assert.Equal(t, "changed", stored.GetString("prompt"))
+	})
+
+	t.Run("PreferencesUpsert", func(t *testing.T) {
+		prefs := repository.NewMemoryPreferences()
+		record, created, err := prefs.Upsert(env.user, "flux/schnell", map[string]any{"seed": 1})
+		require.NoError(t, err)
+		assert.True(t, created)
+
+		again, created, err := prefs.Upsert(env.user, "flux/schnell", map[string]any{"seed": 2})
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, record.Id, again.Id)
+
+		saved, err := prefs.ForModel(env.user, "flux/schnell")
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"seed":2}`, saved.GetString("preferences"))
+		_, err = prefs.ForModel(env.user, "flux/dev")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("Users", func(t *testing.T) {
+		users := repository.NewMemoryUsers()
+		require.NoError(t, users.Save(env.user.Clone()))
+
+		user, err := users.ByEmail(env.user.Email())
+		require.NoError(t, err)
+		assert.Equal(t, env.user.Id, user.Id)
+		_, err = users.Get("missinguser0001")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+}
+
+func TestMemoryRepositoryRoutes(t *testing.T) {
+	prefs := repository.NewMemoryPreferences()
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:    "preferences are saved to the configured repository",
+			method:  http.MethodPost,
+			url:     "/api/custom/preferences/save",
+			body:    `{"model_name":"flux/schnell","preferences":{"num_inference_steps":2}}`,
+			headers: authOnly,
+			before: func(t testing.TB, env *testEnv) {
+				env.handler.SetRepositories(handlers.Repositories{Preferences: prefs})
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Empty(t, preferenceRecords(t, env, "flux/schnell"))
+				saved, err := prefs.ForModel(env.user, "flux/schnell")
+				require.NoError(t, err)
+				assert.JSONEq(t, `{"num_inference_steps":2}`, saved.GetString("preferences"))
+			},
+		},
+		{
+			name:   "preferences are read from the configured repository",
+			method: http.MethodGet,
+			url:    "/api/custom/preferences?model_name=flux/dev",
+			before: func(t testing.TB, env *testEnv) {
+				memory := repository.NewMemoryPreferences()
+				_, _, err := memory.Upsert(env.user, "flux/dev", map[string]any{"guidance_scale": 7})
+				require.NoError(t, err)
+				env.handler.SetRepositories(handlers.Repositories{Preferences: memory})
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"has_preferences":true`, `"guidance_scale":7`},
+		},
+		{
+			name:   "folders are looked up in the configured repository",
+			method: http.MethodGet,
+			url:    "/api/custom/collections/memfolder000001/images",
+			before: func(t testing.TB, env *testEnv) {
+				folders, images := repository.NewMemoryFolders(), repository.NewMemoryImages()
+				folder := core.NewRecord(core.NewBaseCollection(repository.FoldersCollection))
+				folder.Id = "memfolder000001"
+				folder.Set("user_id", env.user.Id)
+				folder.Set("name", "In memory")
+				require.NoError(t, folders.Save(folder))
+
+				image := core.NewRecord(core.NewBaseCollection(repository.ImagesCollection))
+				image.Id = "memimage0000001"
+				image.Set("user_id", env.user.Id)
+				image.Set("folder_id", folder.Id)
+				image.Set("url", "https://example.com/memory.png")
+				require.NoError(t, images.Save(image))
+
+				env.handler.SetRepositories(handlers.Repositories{Folders: folders, Images: images})
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"id":"memimage0000001"`},
+		},
+	})
+}
@@ -0,0 +1,165 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"generatio-pb/internal/custommodels"
+	"generatio-pb/internal/fal"
+	"generatio-pb/internal/pipelines"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withFastAlias defines "fast" as flux/schnell with four steps at square_hd
+func withFastAlias(t testing.TB, env *testEnv) {
+	collection, err := env.app.FindCollectionByNameOrId(custommodels.AliasesCollection)
+	require.NoError(t, err)
+
+	record := core.NewRecord(collection)
+	record.Set("user_id", env.user.Id)
+	record.Set("name", "fast")
+	record.Set("model", "flux/schnell")
+	record.Set("parameters", map[string]interface{}{"num_inference_steps": 4, "image_size": "square_hd"})
+	require.NoError(t, env.app.Save(record))
+}
+
+func TestModelAliasRoutes(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []fal.GenerationRequest
+	)
+	recordRequests := func(t testing.TB, env *testEnv) {
+		requests = nil
+		withFastAlias(t, env)
+		mock := fal.NewMockClient()
+		env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+			mu.Lock()
+			requests = append(requests, req)
+			mu.Unlock()
+			return mock.GenerateImage(ctx, token, req)
+		})
+	}
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "aliases name a model with default parameters",
+			method:          http.MethodPut,
+			url:             "/api/custom/models/aliases/fast",
+			body:            `{"model":"flux/schnell","parameters":{"num_inference_steps":4,"image_size":"square_hd"}}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"name":"fast"`, `"model":"flux/schnell"`, `"num_inference_steps":4`},
+		},
+		{
+			name:            "saving an existing alias replaces it",
+			method:          http.MethodPut,
+			url:             "/api/custom/models/aliases/fast",
+			body:            `{"model":"hidream/hidream-i1-fast"}`,
+			headers:         authOnly,
+			setup:           withFastAlias,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"hidream/hidream-i1-fast"`, `"parameters":{}`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				count, err := env.app.CountRecords(custommodels.AliasesCollection)
+				require.NoError(t, err)
+				assert.EqualValues(t, 1, count)
+			},
+		},
+		{
+			name:            "alias defaults must fit the model",
+			method:          http.MethodPut,
+			url:             "/api/custom/models/aliases/fast",
+			body:            `{"model":"flux/schnell","parameters":{"num_inference_steps":400}}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"num_inference_steps must be at most"},
+		},
+		{
+			name:            "aliases must name a known model",
+			method:          http.MethodPut,
+			url:             "/api/custom/models/aliases/fast",
+			body:            `{"model":"custom/missing"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`Unsupported model \"custom/missing\"`},
+		},
+		{
+			name:            "alias names cannot look like model IDs",
+			method:          http.MethodPut,
+			url:             "/api/custom/models/aliases/Fast",
+			body:            `{"model":"flux/schnell"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"alias names must be"},
+		},
+		{
+			name:            "generations resolve aliases and let the request override defaults",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"fast","prompt":"a red fox","parameters":{"image_size":"portrait_4_3"}}`,
+			headers:         withSession,
+			setup:           recordRequests,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"flux/schnell"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				require.Len(t, requests, 1)
+				assert.Equal(t, "flux/schnell", requests[0].Model)
+				assert.EqualValues(t, 4, requests[0].Parameters["num_inference_steps"])
+				assert.Equal(t, "portrait_4_3", requests[0].Parameters["image_size"])
+			},
+		},
+		{
+			name:            "comparisons resolve aliases",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/compare",
+			body:            `{"prompt":"a red fox","variants":[{"model":"fast"},{"model":"hidream/hidream-i1-fast"}]}`,
+			headers:         withSession,
+			setup:           recordRequests,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"flux/schnell"`},
+		},
+		{
+			name:            "pipelines store the resolved model",
+			method:          http.MethodPost,
+			url:             "/api/custom/pipelines",
+			body:            `{"steps":[{"type":"generate","model":"fast","prompt":"a red fox"}]}`,
+			headers:         withSession,
+			setup:           withFastAlias,
+			expectedStatus:  http.StatusAccepted,
+			expectedContent: []string{`"model":"flux/schnell"`, `"image_size":"square_hd"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				run := processedRun(t, env, res)
+				assert.Equal(t, pipelines.StatusSucceeded, run.Status)
+			},
+		},
+		{
+			name:            "undefined aliases are rejected",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/compare",
+			body:            `{"prompt":"a red fox","variants":[{"model":"slow"},{"model":"flux/schnell"}]}`,
+			headers:         withSession,
+			setup:           withFastAlias,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`Variant 1: unsupported model \"slow\"`},
+		},
+		{
+			name:            "deleting an alias removes it",
+			method:          http.MethodDelete,
+			url:             "/api/custom/models/aliases/fast",
+			headers:         authOnly,
+			setup:           withFastAlias,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				count, err := env.app.CountRecords(custommodels.AliasesCollection)
+				require.NoError(t, err)
+				assert.Zero(t, count)
+			},
+		},
+	})
+}
@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/modelstats"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withJobs records twenty flux/schnell jobs taking 1-20s, two of which
+// failed, and one hidream job
+func withJobs(t testing.TB, env *testEnv) {
+	recorder := modelstats.NewRecorder(env.app)
+	for i := 1; i <= 20; i++ {
+		job := modelstats.Job{Model: "flux/schnell", Duration: time.Duration(i) * time.Second, Success: i > 2}
+		require.NoError(t, recorder.Record(env.user.Id, job))
+	}
+	require.NoError(t, recorder.Record(env.user.Id, modelstats.Job{Model: "hidream/hidream-i1-fast", Duration: 4 * time.Second, Success: true}))
+}
+
+// jobCounts returns the number of recorded jobs and how many succeeded
+func jobCounts(t testing.TB, env *testEnv) (total, succeeded int) {
+	records, err := env.app.FindAllRecords(modelstats.Collection)
+	require.NoError(t, err)
+	for _, record := range records {
+		if record.GetBool("success") {
+			succeeded++
+		}
+	}
+	return len(records), succeeded
+}
+
+func TestSummarize(t *testing.T) {
+	jobs := []modelstats.Job{
+		{Model: "b", Duration: 3 * time.Second, Success: true},
+		{Model: "a", Duration: 100 * time.Millisecond, Success: false},
+		{Model: "b", Duration: 1 * time.Second, Success: true},
+		{Model: "a", Duration: 2 * time.Second, Success: true},
+	}
+
+	stats := modelstats.Summarize(jobs)
+	require.Len(t, stats, 2)
+
+	assert.Equal(t, modelstats.ModelStats{Model: "a", Jobs: 2, Failures: 1, FailureRate: 0.5, AvgMS: 2000, P95MS: 2000}, stats[0])
+	assert.Equal(t, modelstats.ModelStats{Model: "b", Jobs: 2, AvgMS: 2000, P95MS: 3000}, stats[1])
+
+	assert.Empty(t, modelstats.Summarize(nil))
+	assert.Equal(t, []modelstats.ModelStats{{Model: "c", Jobs: 1, Failures: 1, FailureRate: 1}},
+		modelstats.Summarize([]modelstats.Job{{Model: "c", Duration: time.Second}}))
+}
+
+func TestModelStatsRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "statistics require authentication",
+			method:          http.MethodGet,
+			url:             "/api/custom/stats/models",
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"code":"authentication_error"`},
+		},
+		{
+			name:           "statistics summarise recorded jobs per model",
+			method:         http.MethodGet,
+			url:            "/api/custom/stats/models",
+			headers:        authOnly,
+			setup:          withJobs,
+			expectedStatus: http.StatusOK,
+			expectedContent: []string{
+				`"days":7`,
+				`{"model":"flux/schnell","jobs":20,"failures":2,"failure_rate":0.1,"avg_generation_ms":11500,"p95_generation_ms":20000}`,
+				`{"model":"hidream/hidream-i1-fast","jobs":1,"failures":0,"failure_rate":0,"avg_generation_ms":4000,"p95_generation_ms":4000}`,
+			},
+		},
+		{
+			name:            "the window is limited",
+			method:          http.MethodGet,
+			url:             "/api/custom/stats/models?days=365",
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"days must be between 1 and 90"},
+		},
+		{
+			name:            "successful generations are recorded",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a red fox"}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"flux/schnell"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				total, succeeded := jobCounts(t, env)
+				assert.Equal(t, 1, total)
+				assert.Equal(t, 1, succeeded)
+			},
+		},
+		{
+			name:            "failed comparison variants are recorded",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/compare",
+			body:            `{"prompt":"a red fox","variants":[{"model":"flux/schnell"},{"model":"hidream/hidream-i1-fast"}]}`,
+			headers:         withSession,
+			before:          failModel("hidream/hidream-i1-fast"),
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"hidream/hidream-i1-fast"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				total, succeeded := jobCounts(t, env)
+				assert.Equal(t, 2, total)
+				assert.Equal(t, 1, succeeded)
+			},
+		},
+		{
+			name:            "custom models are not recorded",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"custom/my-lora","prompt":"a red fox","parameters":{"style":"ink"}}`,
+			headers:         withSession,
+			setup:           withOwnCustomModel,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"custom/my-lora"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				total, _ := jobCounts(t, env)
+				assert.Zero(t, total)
+			},
+		},
+	})
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/moderation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// flagAllClassifier quarantines every image it sees
+type flagAllClassifier struct{}
+
+func (flagAllClassifier) Classify(ctx context.Context, token, imageURL string) (*moderation.Result, error) {
+	return &moderation.Result{Flagged: true, Score: 0.97, Reason: "nsfw"}, nil
+}
+
+func TestModerationRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:   "flagged images are quarantined",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			before: func(t testing.TB, env *testEnv) {
+				env.handler.SetModerator(flagAllClassifier{})
+			},
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"moderation_status":"quarantined"`, `"url":""`},
+			notExpectedContent: []string{"mock-image-url.com"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				records, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				require.Len(t, records, 1)
+				assert.Equal(t, moderation.StatusQuarantined, records[0].GetString("moderation_status"))
+			},
+		},
+		{
+			name:   "list quarantined images",
+			method: http.MethodGet,
+			url:    "/api/custom/images/quarantine",
+			setup: func(t testing.TB, env *testEnv) {
+				env.createImage(t, map[string]any{"moderation_status": moderation.StatusQuarantined, "prompt": "held back"})
+				env.createImage(t, map[string]any{"moderation_status": moderation.StatusApproved, "prompt": "shown"})
+			},
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"prompt":"held back"`},
+			notExpectedContent: []string{`"prompt":"shown"`},
+		},
+		{
+			name:   "owner overrides quarantine",
+			method: http.MethodPost,
+			url:    "/api/custom/images/quarantined0001/override",
+			setup: func(t testing.TB, env *testEnv) {
+				env.createImage(t, map[string]any{"id": "quarantined0001", "moderation_status": moderation.StatusQuarantined})
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"moderation_status":"overridden"`, `"url":"https://mock-image-url.com/image.jpg"`},
+		},
+		{
+			name:   "override requires a quarantined image",
+			method: http.MethodPost,
+			url:    "/api/custom/images/approved0000001/override",
+			setup: func(t testing.TB, env *testEnv) {
+				env.createImage(t, map[string]any{"id": "approved0000001", "moderation_status": moderation.StatusApproved})
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`},
+		},
+	})
+}
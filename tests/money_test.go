@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/fal"
+	"generatio-pb/internal/money"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMicros(t *testing.T) {
+	assert.Equal(t, money.Micros(3000), money.FromUSD(0.003))
+	assert.Equal(t, money.Micros(1), money.FromUSD(0.0000005), "amounts round to the nearest micro-dollar")
+	assert.Equal(t, 0.003, money.Micros(3000).USD())
+	assert.Equal(t, money.Micros(9000), money.FromUSD(0.003).Times(3))
+	assert.Equal(t, money.Micros(250), money.Micros(-250).Abs())
+
+	shares := money.Micros(10000).Split(3)
+	assert.Equal(t, []money.Micros{3334, 3333, 3333}, shares)
+	var sum money.Micros
+	for _, share := range shares {
+		sum += share
+	}
+	assert.Equal(t, money.Micros(10000), sum, "shares sum to the whole")
+	assert.Nil(t, money.Micros(10000).Split(0))
+
+	// Ten thousand generations at 0.003 drift as float64 but not as micros
+	var floatTotal float64
+	var microsTotal money.Micros
+	for i := 0; i < 10000; i++ {
+		floatTotal += 0.003
+		microsTotal += money.FromUSD(0.003)
+	}
+	assert.NotEqual(t, 30.0, floatTotal)
+	assert.Equal(t, 30.0, microsTotal.USD())
+
+	assert.Equal(t, money.Micros(2500), money.ImageCost{CostUSD: 0.0025}.Amount(), "older records only have cost_usd")
+	assert.Equal(t, money.Micros(3333), money.ImageCost{CostUSD: 0.003333, CostMicros: 3333}.Amount())
+}
+
+func TestCostSQL(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+
+	infos := []any{
+		map[string]any{"cost_micros": 3333, "cost_usd": 0.003333},
+		map[string]any{"cost_usd": 0.0025},
+		map[string]any{"cost_usd": 0.0000005},
+		map[string]any{"source": "import"},
+		nil,
+	}
+	var want money.Micros
+	for _, info := range infos {
+		record := env.createImage(t, map[string]any{"other_info": info})
+		var cost money.ImageCost
+		record.UnmarshalJSONField("other_info", &cost)
+		want += cost.Amount()
+	}
+
+	var got struct {
+		Spent money.Micros `db:"spent"`
+	}
+	require.NoError(t, env.app.DB().Select("SUM("+money.CostSQL("other_info")+") AS spent").From("images").One(&got))
+	assert.Equal(t, money.Micros(5834), want)
+	assert.Equal(t, want, got.Spent, "the database sums costs the way ImageCost.Amount reads them")
+}
+
+func TestMicroDollarAccounting(t *testing.T) {
+	// A result whose cost does not divide evenly between its images
+	threeImages := func(t testing.TB, env *testEnv) {
+		env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+			return &fal.GenerationResponse{
+				RequestID: "mock_request_123",
+				Status:    fal.StatusCompleted,
+				Images: []struct {
+					URL          string `json:"url"`
+					ThumbnailURL string `json:"thumbnail_url,omitempty"`
+					Width        int    `json:"width,omitempty"`
+					Height       int    `json:"height,omitempty"`
+				}{{URL: "https://a.example/1.jpg"}, {URL: "https://a.example/2.jpg"}, {URL: "https://a.example/3.jpg"}},
+				Cost: 0.01,
+			}, nil
+		})
+	}
+	legacyTotals := func(t testing.TB, env *testEnv) {
+		threeImages(t, env)
+		env.user.Set("financial_data", map[string]interface{}{"total_spent": 0.1, "total_images": 2})
+		require.NoError(t, env.app.Save(env.user))
+	}
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "image records share the cost exactly",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk","parameters":{"num_images":3}}`,
+			setup:           threeImages,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"cost":0.01`, `"cost_micros":10000`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				images, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				require.Len(t, images, 3)
+				var sum money.Micros
+				for _, image := range images {
+					var info money.ImageCost
+					require.NoError(t, image.UnmarshalJSONField("other_info", &info))
+					assert.Equal(t, info.CostMicros.USD(), info.CostUSD)
+					sum += info.CostMicros
+				}
+				assert.Equal(t, money.Micros(10000), sum)
+			},
+		},
+		{
+			name:            "totals recorded in USD carry on in micro-dollars",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a lighthouse at dusk","parameters":{"num_images":3}}`,
+			setup:           legacyTotals,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"cost_micros":10000`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				user, err := env.app.FindRecordById("generatio_users", env.user.Id)
+				require.NoError(t, err)
+				var data map[string]interface{}
+				require.NoError(t, user.UnmarshalJSONField("financial_data", &data))
+				assert.EqualValues(t, 110000, data["total_spent_micros"])
+				assert.EqualValues(t, 0.11, data["total_spent"])
+				assert.EqualValues(t, 5, data["total_images"])
+			},
+		},
+		{
+			name:   "financial stats report micro-dollar totals",
+			method: http.MethodGet,
+			url:    "/api/custom/financial/stats",
+			setup: func(t testing.TB, env *testEnv) {
+				env.user.Set("financial_data", map[string]interface{}{"total_spent": 0.3, "total_spent_micros": 300000, "total_images": 100})
+				require.NoError(t, env.app.Save(env.user))
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"total_spent":0.3`, `"total_spent_micros":300000`, `"average_cost":0.003`, `"recent_spending_micros":0`},
+		},
+	})
+}
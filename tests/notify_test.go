@@ -0,0 +1,110 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"generatio-pb/internal/notify"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotificationOutbox(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+
+	service := notify.NewService(env.app)
+	service.SetRetryPolicy(3, 0) // Retry immediately in tests
+
+	outboxStatus := func(t *testing.T, target string) (string, int) {
+		record, err := env.app.FindFirstRecordByData(notify.OutboxCollection, "target", target)
+		require.NoError(t, err)
+		return record.GetString("status"), record.GetInt("attempts")
+	}
+
+	t.Run("WebhookRetriesUntilDelivered", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		defer server.Close()
+
+		require.NoError(t, service.Enqueue(notify.Message{
+			UserID:  env.user.Id,
+			Channel: notify.ChannelWebhook,
+			Target:  server.URL,
+			Event:   notify.EventJobCompleted,
+			Subject: "Job finished",
+		}))
+
+		service.ProcessDue(context.Background())
+		status, attempts := outboxStatus(t, server.URL)
+		assert.Equal(t, notify.StatusPending, status)
+		assert.Equal(t, 1, attempts)
+
+		service.ProcessDue(context.Background())
+		service.ProcessDue(context.Background())
+		status, attempts = outboxStatus(t, server.URL)
+		assert.Equal(t, notify.StatusSent, status)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("DeadLetterAndRetry", func(t *testing.T) {
+		failing := &failingSender{}
+		service.SetSender(notify.ChannelSlack, failing)
+
+		target := "https://hooks.slack.example/dead"
+		require.NoError(t, service.Enqueue(notify.Message{
+			UserID:  env.user.Id,
+			Channel: notify.ChannelSlack,
+			Target:  target,
+			Event:   notify.EventSecurityNotice,
+		}))
+
+		for i := 0; i < 5; i++ {
+			service.ProcessDue(context.Background())
+		}
+
+		status, attempts := outboxStatus(t, target)
+		assert.Equal(t, notify.StatusDead, status)
+		assert.Equal(t, 3, attempts)
+
+		dead, err := service.DeadLetters(10)
+		require.NoError(t, err)
+		require.Len(t, dead, 1)
+		assert.Equal(t, "slack unavailable", dead[0].GetString("last_error"))
+
+		failing.recovered = true
+		require.NoError(t, service.Retry(dead[0].Id))
+		service.ProcessDue(context.Background())
+
+		status, _ = outboxStatus(t, target)
+		assert.Equal(t, notify.StatusSent, status)
+	})
+
+	t.Run("RejectsUnknownChannel", func(t *testing.T) {
+		err := service.Enqueue(notify.Message{Channel: "pager", Target: "x"})
+		assert.Error(t, err)
+	})
+}
+
+// failingSender fails every delivery until recovered is set
+type failingSender struct {
+	recovered bool
+}
+
+func (s *failingSender) Send(ctx context.Context, msg notify.Message) error {
+	if !s.recovered {
+		return errors.New("slack unavailable")
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/folderacl"
+	"generatio-pb/internal/pagination"
+
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withGallery gives the seeded user's folder three images created a day
+// apart, gallery001 oldest, with the given positions
+func withGallery(positions map[string]int) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		withFolderRole(folderacl.RoleOwner)(t, env)
+		for i, id := range []string{"gallery00000001", "gallery00000002", "gallery00000003"} {
+			created, err := types.ParseDateTime(time.Now().AddDate(0, 0, i-3))
+			require.NoError(t, err)
+			env.createImage(t, map[string]any{"id": id, "folder_id": testFolderID, "created": created, "position": positions[id]})
+		}
+	}
+}
+
+func TestManualOrderingRoutes(t *testing.T) {
+	arranged := map[string]int{"gallery00000002": 1, "gallery00000001": 2, "gallery00000003": 3}
+	withArrangedGallery := func(t testing.TB, env *testEnv) {
+		withGallery(arranged)(t, env)
+		env.createImage(t, map[string]any{"id": "gallery00000004", "folder_id": testFolderID})
+	}
+	cursor := pagination.Cursor{Created: "2026-01-01 00:00:00.000Z", ID: "gallery00000001"}.Encode()
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "folders list newest first by default",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections/" + testFolderID + "/images?fields=id",
+			setup:           withGallery(arranged),
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`[{"id":"gallery00000003"},{"id":"gallery00000002"},{"id":"gallery00000001"}]`, `"sort":"recent"`},
+		},
+		{
+			name:            "manual order follows positions with new images first",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections/" + testFolderID + "/images?fields=id&sort=manual",
+			setup:           withArrangedGallery,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`[{"id":"gallery00000004"},{"id":"gallery00000002"},{"id":"gallery00000001"},{"id":"gallery00000003"}]`, `"sort":"manual"`},
+		},
+		{
+			name:            "manual order pages by number",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections/" + testFolderID + "/images?fields=id&sort=manual&limit=2&page=2",
+			setup:           withArrangedGallery,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"data":[{"id":"gallery00000001"},{"id":"gallery00000003"}]`},
+		},
+		{
+			name:            "manual order cannot use cursors",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections/" + testFolderID + "/images?sort=manual&cursor=" + cursor,
+			setup:           withGallery(arranged),
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"cursor cannot be combined with sort=manual"},
+		},
+		{
+			name:            "unknown orderings are rejected",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections/" + testFolderID + "/images?sort=name",
+			setup:           withGallery(arranged),
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"sort must be recent or manual"},
+		},
+		{
+			name:            "listed images move to the front in order",
+			method:          http.MethodPut,
+			url:             "/api/custom/collections/" + testFolderID + "/order",
+			body:            `{"image_ids":["gallery00000001","gallery00000003"]}`,
+			setup:           withGallery(nil),
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"image_ids":["gallery00000001","gallery00000003","gallery00000002"]`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				for id, position := range map[string]int{"gallery00000001": 1, "gallery00000003": 2, "gallery00000002": 3} {
+					record, err := env.app.FindRecordById("images", id)
+					require.NoError(t, err)
+					assert.Equal(t, position, record.GetInt("position"), id)
+				}
+			},
+		},
+		{
+			name:            "images from other folders cannot be ordered",
+			method:          http.MethodPut,
+			url:             "/api/custom/collections/" + testFolderID + "/order",
+			body:            `{"image_ids":["gallery00000001","elsewhere000001"]}`,
+			setup:           withGallery(nil),
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"Image elsewhere000001 is not in this folder"},
+		},
+		{
+			name:            "images cannot be listed twice",
+			method:          http.MethodPut,
+			url:             "/api/custom/collections/" + testFolderID + "/order",
+			body:            `{"image_ids":["gallery00000001","gallery00000001"]}`,
+			setup:           withGallery(nil),
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"Image gallery00000001 is listed more than once"},
+		},
+		{
+			name:            "viewers cannot reorder",
+			method:          http.MethodPut,
+			url:             "/api/custom/collections/" + testFolderID + "/order",
+			body:            `{"image_ids":["gallery00000001"]}`,
+			setup:           withFolderRole(folderacl.RoleViewer),
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"You do not have edit access to this folder"},
+		},
+	})
+}
@@ -0,0 +1,249 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/orgs"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testOrgID = "sharedorg000001"
+
+// createUser saves another generatio_users record
+func (env *testEnv) createUser(t testing.TB, id, email string) *core.Record {
+	users, err := env.app.FindCollectionByNameOrId("generatio_users")
+	require.NoError(t, err)
+
+	user := core.NewRecord(users)
+	user.Id = id
+	user.SetEmail(email)
+	user.SetPassword(testPassword)
+	require.NoError(t, env.app.Save(user))
+	return user
+}
+
+// withOrgRole seeds an organization owned by another user in which the
+// seeded user has role (owner makes the seeded user the owner instead)
+func withOrgRole(role string) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		other := env.createUser(t, "orgcolleague001", "colleague@test.com")
+
+		ownerID := other.Id
+		if role == orgs.RoleOwner {
+			ownerID = env.user.Id
+		}
+
+		collection, err := env.app.FindCollectionByNameOrId(orgs.OrgsCollection)
+		require.NoError(t, err)
+		org := core.NewRecord(collection)
+		org.Id = testOrgID
+		org.Set("name", "Studio")
+		org.Set("owner_id", ownerID)
+		require.NoError(t, env.app.Save(org))
+
+		addMember := func(userID, role string) {
+			members, err := env.app.FindCollectionByNameOrId(orgs.MembersCollection)
+			require.NoError(t, err)
+			member := core.NewRecord(members)
+			member.Set("org_id", testOrgID)
+			member.Set("user_id", userID)
+			member.Set("role", role)
+			require.NoError(t, env.app.Save(member))
+		}
+		if role == orgs.RoleOwner {
+			addMember(env.user.Id, orgs.RoleOwner)
+			addMember(other.Id, orgs.RoleMember)
+		} else {
+			addMember(other.Id, orgs.RoleOwner)
+			addMember(env.user.Id, role)
+		}
+	}
+}
+
+// withOrgHeader authenticates as the seeded user with the org switcher set
+func withOrgHeader(t testing.TB, env *testEnv) map[string]string {
+	headers := env.authHeaders()
+	headers["X-Org-ID"] = testOrgID
+	return headers
+}
+
+// seedLibraries saves one personal folder and one organization folder
+func seedLibraries(t testing.TB, env *testEnv) {
+	folders, err := env.app.FindCollectionByNameOrId("folders")
+	require.NoError(t, err)
+
+	personal := core.NewRecord(folders)
+	personal.Set("user_id", env.user.Id)
+	personal.Set("name", "Personal sketches")
+	require.NoError(t, env.app.Save(personal))
+
+	shared := core.NewRecord(folders)
+	shared.Set("user_id", "orgcolleague001")
+	shared.Set("org_id", testOrgID)
+	shared.Set("name", "Team moodboard")
+	require.NoError(t, env.app.Save(shared))
+}
+
+func TestOrganizationRoutes(t *testing.T) {
+	origin, _ := newImageOrigin(t)
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "creates an organization owned by the caller",
+			method:          http.MethodPost,
+			url:             "/api/custom/orgs",
+			body:            `{"name":"Studio"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"name":"Studio"`, `"role":"owner"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				list, err := orgs.NewService(env.app).ForUser(env.user.Id)
+				require.NoError(t, err)
+				require.Len(t, list, 1)
+				assert.Equal(t, orgs.RoleOwner, list[0].Role)
+			},
+		},
+		{
+			name:            "lists the caller's organizations",
+			method:          http.MethodGet,
+			url:             "/api/custom/orgs",
+			setup:           withOrgRole(orgs.RoleViewer),
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"id":"sharedorg000001"`, `"role":"viewer"`},
+		},
+		{
+			name:   "org switcher lists the shared library",
+			method: http.MethodGet,
+			url:    "/api/custom/collections",
+			setup: func(t testing.TB, env *testEnv) {
+				withOrgRole(orgs.RoleViewer)(t, env)
+				seedLibraries(t, env)
+			},
+			headers:            withOrgHeader,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"name":"Team moodboard"`, `"org_id":"sharedorg000001"`},
+			notExpectedContent: []string{"Personal sketches"},
+		},
+		{
+			name:   "personal listing excludes org folders",
+			method: http.MethodGet,
+			url:    "/api/custom/collections",
+			setup: func(t testing.TB, env *testEnv) {
+				withOrgRole(orgs.RoleViewer)(t, env)
+				seedLibraries(t, env)
+			},
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{"Personal sketches"},
+			notExpectedContent: []string{"Team moodboard"},
+		},
+		{
+			name:            "switching to an org the caller is not in",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections?org_id=sharedorg000001",
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"message":"Organization not found"`},
+		},
+		{
+			name:            "viewers cannot add folders",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/create",
+			body:            `{"name":"Mine"}`,
+			setup:           withOrgRole(orgs.RoleViewer),
+			headers:         withOrgHeader,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{`"code":"authorization_error"`},
+		},
+		{
+			name:            "members add folders to the org library",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/create",
+			body:            `{"name":"Campaign"}`,
+			setup:           withOrgRole(orgs.RoleMember),
+			headers:         withOrgHeader,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"org_id":"sharedorg000001"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				folder, err := env.app.FindFirstRecordByData("folders", "name", "Campaign")
+				require.NoError(t, err)
+				assert.Equal(t, testOrgID, folder.GetString("org_id"))
+			},
+		},
+		{
+			name:   "outsiders cannot open org images",
+			method: http.MethodGet,
+			url:    "/api/custom/images/orgimage0000001/file",
+			setup: func(t testing.TB, env *testEnv) {
+				env.createImage(t, map[string]any{"id": "orgimage0000001", "user_id": "orgcolleague001", "org_id": testOrgID, "url": origin.URL + "/image.png"})
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"code":"not_found"`},
+		},
+		{
+			name:   "members can open images in the shared library",
+			method: http.MethodGet,
+			url:    "/api/custom/images/orgimage0000001/file",
+			setup: func(t testing.TB, env *testEnv) {
+				withOrgRole(orgs.RoleViewer)(t, env)
+				env.createImage(t, map[string]any{"id": "orgimage0000001", "user_id": "orgcolleague001", "org_id": testOrgID, "url": origin.URL + "/image.png"})
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{"PNG"},
+		},
+		{
+			name:   "owners add members by email",
+			method: http.MethodPost,
+			url:    "/api/custom/orgs/sharedorg000001/members",
+			body:   `{"email":"newcomer@test.com","role":"viewer"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withOrgRole(orgs.RoleOwner)(t, env)
+				env.createUser(t, "orgnewcomer0001", "newcomer@test.com")
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"user_id":"orgnewcomer0001"`, `"role":"viewer"`},
+		},
+		{
+			name:            "members cannot manage members",
+			method:          http.MethodPost,
+			url:             "/api/custom/orgs/sharedorg000001/members",
+			body:            `{"email":"colleague@test.com","role":"admin"}`,
+			setup:           withOrgRole(orgs.RoleMember),
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{`"code":"authorization_error"`},
+		},
+		{
+			name:            "the owner cannot be removed",
+			method:          http.MethodDelete,
+			url:             "/api/custom/orgs/sharedorg000001/members/orgcolleague001",
+			setup:           withOrgRole(orgs.RoleAdmin),
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"message":"the owner cannot be removed"`},
+		},
+		{
+			name:   "aggregates spending per member",
+			method: http.MethodGet,
+			url:    "/api/custom/orgs/sharedorg000001/spending",
+			setup: func(t testing.TB, env *testEnv) {
+				withOrgRole(orgs.RoleOwner)(t, env)
+				env.createImage(t, map[string]any{"org_id": testOrgID, "other_info": map[string]any{"cost_usd": 0.5}})
+				env.createImage(t, map[string]any{"org_id": testOrgID, "other_info": map[string]any{"cost_usd": 0.25}})
+				env.createImage(t, map[string]any{"org_id": testOrgID, "user_id": "orgcolleague001", "other_info": map[string]any{"cost_usd": 1.0}})
+				env.createImage(t, map[string]any{"other_info": map[string]any{"cost_usd": 9.0}}) // Personal, not counted
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"total_spent":1.75`, `"total_images":3`, `{"user_id":"orgcolleague001","spent":1,"images":1}`},
+		},
+	})
+}
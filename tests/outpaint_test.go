@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"generatio-pb/internal/fal"
+	"generatio-pb/internal/imagecache"
+
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPadCanvas(t *testing.T) {
+	assert.ErrorContains(t, imagecache.Padding{}.Validate(), "at least one side")
+	assert.ErrorContains(t, imagecache.Padding{Left: -1, Right: 10}.Validate(), "between 0 and 1024")
+	assert.ErrorContains(t, imagecache.Padding{Top: 2000}.Validate(), "between 0 and 1024")
+
+	canvas, err := imagecache.Pad(bytes.NewReader(encodePNG(100, 50)), imagecache.Padding{Left: 20, Top: 10})
+	require.NoError(t, err)
+	assert.Equal(t, 120, canvas.Width)
+	assert.Equal(t, 60, canvas.Height)
+
+	mask, err := imaging.Decode(bytes.NewReader(canvas.Mask))
+	require.NoError(t, err)
+	assert.Equal(t, 120, mask.Bounds().Dx())
+	r, _, _, _ := mask.At(5, 5).RGBA()
+	assert.EqualValues(t, 0xffff, r, "padding is painted")
+	r, _, _, _ = mask.At(30, 20).RGBA()
+	assert.EqualValues(t, 0, r, "original pixels are kept")
+
+	_, err = imagecache.Pad(bytes.NewReader(encodePNG(1500, 10)), imagecache.Padding{Left: 600})
+	assert.ErrorContains(t, err, "exceeds the 2048x2048 limit")
+}
+
+func TestOutpaintRoutes(t *testing.T) {
+	origin, _ := newImageOrigin(t)
+
+	sourceAt := func(t testing.TB, env *testEnv) {
+		env.createImage(t, map[string]any{"id": testSourceImageID, "url": origin.URL + "/image.png"})
+	}
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:   "outpainting extends the image and links it to the original",
+			method: http.MethodPost,
+			url:    "/api/custom/images/" + testSourceImageID + "/outpaint",
+			body:   `{"left":64,"right":64}`,
+			setup:  sourceAt,
+			before: func(t testing.TB, env *testEnv) {
+				mock := fal.NewMockClient()
+				env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+					assert.Equal(t, fal.OutpaintModel, req.Model)
+					assert.Equal(t, "a lighthouse at dusk", req.Prompt)
+					assert.True(t, strings.HasPrefix(req.Parameters["image_url"].(string), "data:image/png;base64,"))
+					assert.True(t, strings.HasPrefix(req.Parameters["mask_url"].(string), "data:image/png;base64,"))
+					return mock.GenerateImage(ctx, token, req)
+				})
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"relation":"outpaint"`, `"parent_id":"sourceimage0001"`, `"model":"flux-pro/v1/fill"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				record := derivedImage(t, env)
+
+				var info struct {
+					Parameters map[string]any `json:"parameters"`
+				}
+				require.NoError(t, record.UnmarshalJSONField("other_info", &info))
+				assert.NotContains(t, info.Parameters, "image_url", "canvas data is not stored")
+				assert.Equal(t, map[string]any{"left": 64.0, "right": 64.0, "top": 0.0, "bottom": 0.0}, info.Parameters["outpaint"])
+
+				var size map[string]any
+				require.NoError(t, record.UnmarshalJSONField("image_size", &size))
+				assert.EqualValues(t, 129, size["width"])
+				assert.EqualValues(t, 1, size["height"])
+			},
+		},
+		{
+			name:            "outpainting needs a direction",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/" + testSourceImageID + "/outpaint",
+			body:            `{}`,
+			setup:           sourceAt,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"at least one side"},
+		},
+		{
+			name:            "outpainting validates the canvas size",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/" + testSourceImageID + "/outpaint",
+			body:            `{"left":1024,"right":1024}`,
+			setup:           sourceAt,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"exceeds the 2048x2048 limit"},
+		},
+		{
+			name:   "quarantined images cannot be outpainted",
+			method: http.MethodPost,
+			url:    "/api/custom/images/" + testSourceImageID + "/outpaint",
+			body:   `{"top":32}`,
+			setup: func(t testing.TB, env *testEnv) {
+				env.createImage(t, map[string]any{"id": testSourceImageID, "moderation_status": "quarantined"})
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"withheld by moderation"},
+		},
+	})
+}
@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/folderacl"
+	"generatio-pb/internal/pagination"
+
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var paginationEpoch = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// withFolderImages seeds a folder owned by the seeded user holding five
+// images, newest first pageimage000001 to pageimage000005. The middle two
+// share a timestamp, so only their ids order them.
+func withFolderImages(t testing.TB, env *testEnv) {
+	withFolderRole(folderacl.RoleOwner)(t, env)
+
+	offsets := []time.Duration{0, -time.Minute, -time.Minute, -2 * time.Minute, -3 * time.Minute}
+	ids := []string{"pageimage000005", "pageimage000004", "pageimage000003", "pageimage000002", "pageimage000001"}
+	for i, id := range ids {
+		created, err := types.ParseDateTime(paginationEpoch.Add(offsets[i]))
+		require.NoError(t, err)
+		env.createImage(t, map[string]any{"id": id, "folder_id": testFolderID, "created": created})
+	}
+}
+
+func TestPagination(t *testing.T) {
+	t.Run("CursorsRoundTrip", func(t *testing.T) {
+		cursor := pagination.Cursor{Created: "2026-03-01 12:00:00.000Z", ID: "pageimage000003"}
+		decoded, err := pagination.DecodeCursor(cursor.Encode())
+		require.NoError(t, err)
+		assert.Equal(t, cursor, *decoded)
+
+		for _, raw := range []string{"not a cursor", "bm9waXBl", pagination.Cursor{Created: "yesterday", ID: "x"}.Encode()} {
+			_, err := pagination.DecodeCursor(raw)
+			assert.ErrorIs(t, err, pagination.ErrInvalidCursor, raw)
+		}
+	})
+
+	t.Run("QueryParameters", func(t *testing.T) {
+		page, err := pagination.Parse(url.Values{}, 50)
+		require.NoError(t, err)
+		assert.Equal(t, pagination.Page{Number: 1, Limit: 50}, page)
+
+		page, err = pagination.Parse(url.Values{"page": {"3"}, "limit": {"500"}}, 50)
+		require.NoError(t, err)
+		assert.Equal(t, pagination.Page{Number: 3, Limit: 50}, page)
+
+		_, err = pagination.Parse(url.Values{"limit": {"0"}}, 50)
+		assert.Error(t, err)
+		_, err = pagination.Parse(url.Values{"page": {"2"}, "cursor": {pagination.Cursor{Created: "2026-03-01 12:00:00.000Z", ID: "a"}.Encode()}}, 50)
+		assert.Error(t, err)
+	})
+
+	t.Run("CursorsVisitEveryRecordOnce", func(t *testing.T) {
+		env := newTestEnv(t)
+		defer env.app.Cleanup()
+		withFolderImages(t, env)
+
+		var seen []string
+		page := pagination.Page{Number: 1, Limit: 2}
+		for {
+			records, next, err := pagination.Find(env.app, "images", "folder_id = {:folder_id}", map[string]any{"folder_id": testFolderID}, page)
+			require.NoError(t, err)
+			for _, record := range records {
+				seen = append(seen, record.Id)
+			}
+			if next == "" {
+				break
+			}
+
+			// Records added while iterating do not shift later pages
+			env.createImage(t, map[string]any{"folder_id": testFolderID})
+
+			page.Cursor, err = pagination.DecodeCursor(next)
+			require.NoError(t, err)
+		}
+
+		assert.Equal(t, []string{"pageimage000005", "pageimage000004", "pageimage000003", "pageimage000002", "pageimage000001"}, seen)
+	})
+}
+
+func TestPaginationRoutes(t *testing.T) {
+	after := pagination.Cursor{Created: paginationEpoch.Add(-time.Minute).Format(types.DefaultDateLayout), ID: "pageimage000004"}.Encode()
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:               "folder images are limited and point to the next page",
+			method:             http.MethodGet,
+			url:                "/api/custom/collections/sharedfolder001/images?limit=2",
+			setup:              withFolderImages,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"id":"pageimage000005"`, `"id":"pageimage000004"`, `"next_cursor":"` + after + `"`},
+			notExpectedContent: []string{"pageimage000003"},
+		},
+		{
+			name:               "cursors continue after the previous page",
+			method:             http.MethodGet,
+			url:                "/api/custom/collections/sharedfolder001/images?limit=2&cursor=" + after,
+			setup:              withFolderImages,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"id":"pageimage000003"`, `"id":"pageimage000002"`},
+			notExpectedContent: []string{"pageimage000004", `"next_cursor":""`},
+		},
+		{
+			name:               "pages still work",
+			method:             http.MethodGet,
+			url:                "/api/custom/collections/sharedfolder001/images?limit=2&page=3",
+			setup:              withFolderImages,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"id":"pageimage000001"`, `"next_cursor":""`},
+			notExpectedContent: []string{"pageimage000002"},
+		},
+		{
+			name:            "invalid cursors are rejected",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections/sharedfolder001/images?cursor=garbage",
+			setup:           withFolderImages,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"invalid cursor"},
+		},
+		{
+			name:            "pipeline runs are paginated",
+			method:          http.MethodGet,
+			url:             "/api/custom/pipelines?limit=1",
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"data":[]`, `"next_cursor":""`},
+		},
+	})
+}
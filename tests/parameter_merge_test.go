@@ -0,0 +1,106 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/fal"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeParameters(t *testing.T) {
+	model, exists := fal.GetModel("flux/schnell")
+	require.True(t, exists)
+
+	merged := fal.MergeParameters(&model,
+		map[string]interface{}{"guidance_scale": 3, "num_images": 2, "output_format": "png", "seed": nil},
+		map[string]interface{}{"num_images": 4, "output_format": nil, "prompt_strength": 0.5},
+	)
+
+	assert.Equal(t, map[string]interface{}{"guidance_scale": 3, "num_images": 4, "prompt_strength": 0.5}, merged.Submitted,
+		"model defaults and cleared values are not submitted")
+
+	assert.Equal(t, 3, merged.Effective["guidance_scale"])
+	assert.Equal(t, fal.SourcePreference, merged.Sources["guidance_scale"])
+	assert.Equal(t, 4, merged.Effective["num_images"])
+	assert.Equal(t, fal.SourceRequest, merged.Sources["num_images"])
+	assert.Equal(t, model.Parameters["output_format"].Default, merged.Effective["output_format"], "a null request value restores the default")
+	assert.Equal(t, fal.SourceModelDefault, merged.Sources["output_format"])
+	assert.Equal(t, model.Parameters["num_inference_steps"].Default, merged.Effective["num_inference_steps"])
+	assert.NotContains(t, merged.Effective, "seed", "null preferences and defaults are ignored")
+	assert.Equal(t, len(merged.Effective), len(merged.Sources))
+
+	// The same layers always merge to the same result
+	for i := 0; i < 10; i++ {
+		again := fal.MergeParameters(&model,
+			map[string]interface{}{"guidance_scale": 3, "num_images": 2, "output_format": "png", "seed": nil},
+			map[string]interface{}{"num_images": 4, "output_format": nil, "prompt_strength": 0.5},
+		)
+		assert.Equal(t, merged, again)
+	}
+
+	unknown := fal.MergeParameters(nil, map[string]interface{}{"seed": 1}, nil)
+	assert.Equal(t, map[string]interface{}{"seed": 1}, unknown.Submitted)
+	assert.Equal(t, map[string]string{"seed": fal.SourcePreference}, unknown.Sources)
+
+	empty := fal.MergeParameters(nil, nil, nil)
+	assert.NotNil(t, empty.Submitted)
+	assert.Empty(t, empty.Effective)
+}
+
+func TestParameterMergeRoutes(t *testing.T) {
+	var submitted map[string]interface{}
+	recordParameters := func(t testing.TB, env *testEnv) {
+		withSavedPreferences(t, env)
+		env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+			submitted = req.Parameters
+			return fal.NewMockClient().GenerateImage(ctx, token, req)
+		})
+	}
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:           "dry runs report where each parameter came from",
+			method:         http.MethodPost,
+			url:            "/api/custom/generate/image",
+			body:           `{"model":"hidream/hidream-i1-fast","prompt":"a lighthouse at dusk","parameters":{"num_images":2},"dry_run":true}`,
+			setup:          withoutFALCalls,
+			headers:        withSession,
+			expectedStatus: http.StatusOK,
+			expectedContent: []string{
+				`"parameters":{"num_images":2,"num_inference_steps":8}`,
+				`"effective_parameters":{`, `"output_format":"jpeg"`,
+				`"num_inference_steps":"preference"`, `"num_images":"request"`, `"output_format":"model_default"`,
+			},
+		},
+		{
+			name:               "null clears a saved preference",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"hidream/hidream-i1-fast","prompt":"a lighthouse at dusk","parameters":{"num_inference_steps":null},"dry_run":true}`,
+			setup:              withoutFALCalls,
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"parameters":{}`, `"num_inference_steps":8`, `"num_inference_steps":"model_default"`},
+			notExpectedContent: []string{`"preference"`},
+		},
+		{
+			name:            "generations submit preferences and request values but not defaults",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"hidream/hidream-i1-fast","prompt":"a lighthouse at dusk","parameters":{"num_images":2}}`,
+			setup:           recordParameters,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"images":[`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.EqualValues(t, 8, submitted["num_inference_steps"])
+				assert.EqualValues(t, 2, submitted["num_images"])
+				assert.NotContains(t, submitted, "output_format")
+			},
+		},
+	})
+}
@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/fal"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deliveredResult returns a settled flux/schnell result with the given image
+// URLs, an empty URL standing for an image that failed
+func deliveredResult(params map[string]interface{}, urls ...string) *fal.GenerationResponse {
+	result := &fal.GenerationResponse{RequestID: "mock_request_123", Status: fal.StatusCompleted}
+	for _, url := range urls {
+		result.Images = append(result.Images, struct {
+			URL          string `json:"url"`
+			ThumbnailURL string `json:"thumbnail_url,omitempty"`
+			Width        int    `json:"width,omitempty"`
+			Height       int    `json:"height,omitempty"`
+		}{URL: url})
+	}
+	model, _ := fal.GetModel("flux/schnell")
+	result.Settle(&model, params)
+	return result
+}
+
+func TestSettle(t *testing.T) {
+	model, exists := fal.GetModel("flux/schnell")
+	require.True(t, exists)
+
+	partial := deliveredResult(map[string]interface{}{"num_images": 4}, "https://a.example/1.jpg", "", "https://a.example/3.jpg")
+	assert.Len(t, partial.Images, 2, "failed images are dropped")
+	assert.Equal(t, []string{fal.ImageDelivered, fal.ImageFailed, fal.ImageDelivered, fal.ImageMissing}, partial.ImageStatuses)
+	assert.True(t, partial.Partial())
+	assert.InDelta(t, 2*model.CostPerImage, partial.Cost, 1e-9, "only delivered images are charged")
+
+	complete := deliveredResult(map[string]interface{}{"num_images": float64(2)}, "https://a.example/1.jpg", "https://a.example/2.jpg")
+	assert.False(t, complete.Partial())
+	assert.InDelta(t, model.CostPerImage*2, complete.Cost, 1e-9)
+
+	single := deliveredResult(nil, "https://a.example/1.jpg")
+	assert.Equal(t, []string{fal.ImageDelivered}, single.ImageStatuses)
+
+	unsettled := &fal.GenerationResponse{}
+	assert.False(t, unsettled.Partial())
+}
+
+func TestPartialDeliveryRoutes(t *testing.T) {
+	deliverSome := func(t testing.TB, env *testEnv) {
+		env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+			return deliveredResult(req.Parameters, "https://a.example/1.jpg", "", "https://a.example/3.jpg"), nil
+		})
+	}
+	deliverAll := func(t testing.TB, env *testEnv) {
+		env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+			return deliveredResult(req.Parameters, "https://a.example/1.jpg", "https://a.example/2.jpg"), nil
+		})
+	}
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:           "partial results charge only the delivered images",
+			method:         http.MethodPost,
+			url:            "/api/custom/generate/image",
+			body:           `{"model":"flux/schnell","prompt":"a lighthouse at dusk","parameters":{"num_images":4}}`,
+			setup:          deliverSome,
+			headers:        withSession,
+			expectedStatus: http.StatusOK,
+			expectedContent: []string{
+				`"partial":true`,
+				`"image_statuses":["delivered","failed","delivered","missing"]`,
+				`"cost":0.006`,
+				`Only 2 of 4 requested images were delivered`,
+			},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				images, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				require.Len(t, images, 2, "failed images are not saved")
+				for _, image := range images {
+					var otherInfo map[string]interface{}
+					require.NoError(t, image.UnmarshalJSONField("other_info", &otherInfo))
+					assert.InDelta(t, 0.003, otherInfo["cost_usd"], 1e-9)
+					assert.Len(t, otherInfo["image_statuses"], 4)
+				}
+			},
+		},
+		{
+			name:               "complete results are not marked partial",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"flux/schnell","prompt":"a lighthouse at dusk","parameters":{"num_images":2}}`,
+			setup:              deliverAll,
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"cost":0.006`},
+			notExpectedContent: []string{`"partial"`, `"image_statuses"`, `"warnings"`},
+		},
+	})
+}
@@ -0,0 +1,201 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/orgs"
+	"generatio-pb/internal/pipelines"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTemplateID = "pipelinetmpl001"
+
+// seedTemplate saves a two-version template owned by ownerID. Version 1
+// defaults its variable; version 2 requires it on every run.
+func seedTemplate(t testing.TB, env *testEnv, ownerID, orgID string) {
+	templates, err := env.app.FindCollectionByNameOrId(pipelines.TemplatesCollection)
+	require.NoError(t, err)
+	versions, err := env.app.FindCollectionByNameOrId(pipelines.VersionsCollection)
+	require.NoError(t, err)
+
+	snapshots := []struct {
+		prompt    string
+		variables map[string]string
+	}{
+		{"a {{subject}} at dusk", map[string]string{"subject": "fox"}},
+		{"a {{subject}} in the snow", map[string]string{"subject": ""}},
+	}
+
+	for i, snapshot := range snapshots {
+		steps := []pipelines.Step{
+			{Type: pipelines.StepGenerate, Model: "flux/schnell", Prompt: snapshot.prompt},
+			{Type: pipelines.StepUpscale},
+		}
+		record := core.NewRecord(versions)
+		record.Set("template_id", testTemplateID)
+		record.Set("version", i+1)
+		record.Set("user_id", ownerID)
+		record.Set("steps", steps)
+		record.Set("variables", snapshot.variables)
+		require.NoError(t, env.app.Save(record))
+
+		if i == len(snapshots)-1 {
+			template := core.NewRecord(templates)
+			template.Id = testTemplateID
+			template.Set("user_id", ownerID)
+			template.Set("org_id", orgID)
+			template.Set("name", "Snow scenes")
+			template.Set("version", i+1)
+			template.Set("steps", steps)
+			template.Set("variables", snapshot.variables)
+			require.NoError(t, env.app.Save(template))
+		}
+	}
+}
+
+func withOwnTemplate(t testing.TB, env *testEnv) {
+	seedTemplate(t, env, env.user.Id, "")
+}
+
+func TestPipelineTemplateRoutes(t *testing.T) {
+	const templateURL = "/api/custom/pipelines/templates/" + testTemplateID
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "templates start at version 1",
+			method:          http.MethodPost,
+			url:             "/api/custom/pipelines/templates",
+			body:            `{"name":"Cutouts","steps":[{"type":"generate","model":"flux/schnell","prompt":"a {{subject}}"},{"type":"remove_background"}],"variables":{"subject":""}}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"version":1`, `"can_edit":true`, `"variables":{"subject":""}`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				count, err := env.app.CountRecords(pipelines.VersionsCollection)
+				require.NoError(t, err)
+				assert.EqualValues(t, 1, count)
+			},
+		},
+		{
+			name:            "templates declare the variables they use",
+			method:          http.MethodPost,
+			url:             "/api/custom/pipelines/templates",
+			body:            `{"name":"Cutouts","steps":[{"type":"generate","model":"flux/schnell","prompt":"a {{subject}}"}]}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`step 1 uses undeclared variable \"subject\"`},
+		},
+		{
+			name:            "saving a template adds a version",
+			method:          http.MethodPut,
+			url:             templateURL,
+			body:            `{"name":"Snow scenes","steps":[{"type":"generate","model":"flux/schnell","prompt":"a {{subject}} in a blizzard"}],"variables":{"subject":"wolf"}}`,
+			headers:         authOnly,
+			setup:           withOwnTemplate,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"version":3`, `a {{subject}} in a blizzard`},
+		},
+		{
+			name:            "versions are listed newest first",
+			method:          http.MethodGet,
+			url:             templateURL + "/versions",
+			headers:         authOnly,
+			setup:           withOwnTemplate,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"version":2`, `a {{subject}} at dusk`},
+		},
+		{
+			name:            "running a template requires its variables",
+			method:          http.MethodPost,
+			url:             templateURL + "/run",
+			body:            `{}`,
+			headers:         withSession,
+			setup:           withOwnTemplate,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"missing values for variables: subject"},
+		},
+		{
+			name:            "running a template rejects unknown variables",
+			method:          http.MethodPost,
+			url:             templateURL + "/run",
+			body:            `{"variables":{"subject":"owl","mood":"calm"}}`,
+			headers:         withSession,
+			setup:           withOwnTemplate,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`unknown variable \"mood\"`},
+		},
+		{
+			name:            "running a template fills in the overrides",
+			method:          http.MethodPost,
+			url:             templateURL + "/run",
+			body:            `{"variables":{"subject":"red fox"}}`,
+			headers:         withSession,
+			setup:           withOwnTemplate,
+			expectedStatus:  http.StatusAccepted,
+			expectedContent: []string{`"prompt":"a red fox in the snow"`, `"template":{"id":"pipelinetmpl001","version":2}`, `"model":"esrgan"`},
+		},
+		{
+			name:            "earlier versions run with their defaults",
+			method:          http.MethodPost,
+			url:             templateURL + "/run",
+			body:            `{"version":1}`,
+			headers:         withSession,
+			setup:           withOwnTemplate,
+			expectedStatus:  http.StatusAccepted,
+			expectedContent: []string{`"prompt":"a fox at dusk"`, `"version":1`},
+		},
+		{
+			name:    "organization members see shared templates read-only",
+			method:  http.MethodGet,
+			url:     "/api/custom/pipelines/templates",
+			headers: authOnly,
+			setup: func(t testing.TB, env *testEnv) {
+				withOrgRole(orgs.RoleMember)(t, env)
+				seedTemplate(t, env, "orgcolleague001", testOrgID)
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"id":"pipelinetmpl001"`, `"can_edit":false`},
+		},
+		{
+			name:    "organization members cannot edit shared templates",
+			method:  http.MethodDelete,
+			url:     templateURL,
+			headers: authOnly,
+			setup: func(t testing.TB, env *testEnv) {
+				withOrgRole(orgs.RoleMember)(t, env)
+				seedTemplate(t, env, "orgcolleague001", testOrgID)
+			},
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"only the template owner or organization admins"},
+		},
+		{
+			name:    "private templates of other users are not found",
+			method:  http.MethodGet,
+			url:     templateURL,
+			headers: authOnly,
+			setup: func(t testing.TB, env *testEnv) {
+				env.createUser(t, "otheruser000001", "other@test.com")
+				seedTemplate(t, env, "otheruser000001", "")
+			},
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"code":"not_found"`},
+		},
+		{
+			name:            "deleting a template removes its versions",
+			method:          http.MethodDelete,
+			url:             templateURL,
+			headers:         authOnly,
+			setup:           withOwnTemplate,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				count, err := env.app.CountRecords(pipelines.VersionsCollection)
+				require.NoError(t, err)
+				assert.Zero(t, count)
+			},
+		},
+	})
+}
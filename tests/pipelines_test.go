@@ -0,0 +1,224 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/fal"
+	"generatio-pb/internal/money"
+	"generatio-pb/internal/pipelines"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPipelineFolderID = "pipelinefolder1"
+
+// stubExecutor fails each step type a set number of times before succeeding
+type stubExecutor struct {
+	failures  map[string]int
+	permanent bool
+	calls     map[string]int
+}
+
+func (s *stubExecutor) ExecuteStep(ctx context.Context, run *pipelines.Run, step pipelines.Step, inputs []string) ([]string, money.Micros, error) {
+	s.calls[step.Type]++
+	if s.failures[step.Type] > 0 {
+		s.failures[step.Type]--
+		if s.permanent {
+			return nil, 0, pipelines.Permanent(errors.New("cannot run"))
+		}
+		return nil, money.FromUSD(0.001), errors.New("temporarily unavailable")
+	}
+	return []string{step.Type + "-image"}, money.FromUSD(0.01), nil
+}
+
+// processedRun runs the queued pipelines and returns the run from the response
+func processedRun(t testing.TB, env *testEnv, res *http.Response) *pipelines.Run {
+	var queued pipelines.Run
+	decodeData(t, res, &queued)
+	assert.Equal(t, pipelines.StatusQueued, queued.Status)
+
+	env.handler.Pipelines().Process(context.Background())
+
+	run, err := env.handler.Pipelines().Find(queued.ID, env.user.Id)
+	require.NoError(t, err)
+	return run
+}
+
+func TestPipelineRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "pipelines require a session",
+			method:          http.MethodPost,
+			url:             "/api/custom/pipelines",
+			body:            `{"steps":[{"type":"generate","model":"flux/schnell","prompt":"a red fox"}]}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"code":"authentication_error"`},
+		},
+		{
+			name:            "pipelines must start with images",
+			method:          http.MethodPost,
+			url:             "/api/custom/pipelines",
+			body:            `{"steps":[{"type":"upscale"}]}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"step 1: upscale needs images from a previous step"},
+		},
+		{
+			name:            "pipelines reject unknown models",
+			method:          http.MethodPost,
+			url:             "/api/custom/pipelines",
+			body:            `{"steps":[{"type":"generate","model":"nope/model","prompt":"a red fox"}]}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`Step 1: unsupported model`},
+		},
+		{
+			name:            "pipelines check the target folder up front",
+			method:          http.MethodPost,
+			url:             "/api/custom/pipelines",
+			body:            `{"steps":[{"type":"generate","model":"flux/schnell","prompt":"a red fox"},{"type":"save_to_folder","folder_id":"missingfolder01"}]}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"Step 2: folder not found"},
+		},
+		{
+			name:   "pipeline runs every step and tallies the cost",
+			method: http.MethodPost,
+			url:    "/api/custom/pipelines",
+			body: `{"steps":[{"type":"generate","model":"flux/schnell","prompt":"a red fox"},{"type":"upscale","parameters":{"scale":4}},` +
+				`{"type":"remove_background"},{"type":"save_to_folder","folder_id":"` + testPipelineFolderID + `"}]}`,
+			headers: withSession,
+			setup: func(t testing.TB, env *testEnv) {
+				env.createFolder(t, testPipelineFolderID)
+			},
+			expectedStatus:  http.StatusAccepted,
+			expectedContent: []string{`"status":"queued"`, `"type":"upscale","model":"esrgan"`, `"status":"pending"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				run := processedRun(t, env, res)
+				assert.Equal(t, pipelines.StatusSucceeded, run.Status)
+				assert.InDelta(t, 0.009, run.TotalCost, 1e-9)
+				assert.Equal(t, money.Micros(9000), run.TotalCostMicros)
+				require.Len(t, run.Steps, 4)
+				for _, step := range run.Steps {
+					assert.Equal(t, pipelines.StatusSucceeded, step.Status, step.Type)
+					assert.Equal(t, 1, step.Attempts, step.Type)
+					assert.Len(t, step.ImageIDs, 1, step.Type)
+				}
+
+				cutout, err := env.app.FindRecordById("images", run.Steps[3].ImageIDs[0])
+				require.NoError(t, err)
+				assert.Equal(t, fal.BackgroundRemovalModel, cutout.GetString("model"))
+				assert.Equal(t, testPipelineFolderID, cutout.GetString("folder_id"))
+				assert.Equal(t, run.Steps[1].ImageIDs[0], cutout.GetString("parent_id"))
+				assert.Equal(t, run.ID, cutout.GetString("group_id"))
+
+				upscaled, err := env.app.FindRecordById("images", run.Steps[1].ImageIDs[0])
+				require.NoError(t, err)
+				assert.Equal(t, run.Steps[0].ImageIDs[0], upscaled.GetString("parent_id"))
+			},
+		},
+		{
+			name:            "pipeline fails the run when a step keeps failing",
+			method:          http.MethodPost,
+			url:             "/api/custom/pipelines",
+			body:            `{"steps":[{"type":"generate","model":"flux/schnell","prompt":"a red fox","max_attempts":1},{"type":"upscale"}]}`,
+			headers:         withSession,
+			setup:           failModel("flux/schnell"),
+			expectedStatus:  http.StatusAccepted,
+			expectedContent: []string{`"max_attempts":1`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				run := processedRun(t, env, res)
+				assert.Equal(t, pipelines.StatusFailed, run.Status)
+				assert.Contains(t, run.Error, "step 1 (generate) failed: model unavailable")
+				assert.Equal(t, pipelines.StatusFailed, run.Steps[0].Status)
+				assert.Equal(t, pipelines.StatusSkipped, run.Steps[1].Status)
+			},
+		},
+		{
+			name:            "pipelines of other users are not found",
+			method:          http.MethodGet,
+			url:             "/api/custom/pipelines/otherpipeline1",
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"code":"not_found"`},
+		},
+	})
+}
+
+func TestPipelineService(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+
+	service := pipelines.NewService(env.app)
+	service.SetBaseBackoff(0) // Retry immediately in tests
+
+	steps := []pipelines.Step{
+		{Type: pipelines.StepGenerate, Model: "flux/schnell", Prompt: "a red fox"},
+		{Type: pipelines.StepUpscale, Model: fal.UpscaleModel},
+	}
+
+	t.Run("RetriesTransientFailures", func(t *testing.T) {
+		executor := &stubExecutor{failures: map[string]int{pipelines.StepUpscale: 2}, calls: map[string]int{}}
+		service.SetExecutor(executor)
+
+		run, err := service.Create(env.user.Id, "", steps, pipelines.RunOptions{})
+		require.NoError(t, err)
+		service.Process(context.Background())
+
+		run, err = service.Find(run.ID, env.user.Id)
+		require.NoError(t, err)
+		assert.Equal(t, pipelines.StatusSucceeded, run.Status)
+		assert.Equal(t, 3, run.Steps[1].Attempts)
+		assert.Equal(t, []string{"upscale-image"}, run.Steps[1].ImageIDs)
+		assert.InDelta(t, 0.022, run.TotalCost, 1e-9)
+		assert.Equal(t, money.Micros(22_000), run.TotalCostMicros)
+		assert.Equal(t, money.Micros(12_000), run.Steps[1].CostMicros, "failed attempts are charged too")
+	})
+
+	t.Run("PermanentFailuresAreNotRetried", func(t *testing.T) {
+		executor := &stubExecutor{failures: map[string]int{pipelines.StepGenerate: 1}, permanent: true, calls: map[string]int{}}
+		service.SetExecutor(executor)
+
+		run, err := service.Create(env.user.Id, "", steps, pipelines.RunOptions{})
+		require.NoError(t, err)
+		service.Process(context.Background())
+
+		run, err = service.Find(run.ID, env.user.Id)
+		require.NoError(t, err)
+		assert.Equal(t, pipelines.StatusFailed, run.Status)
+		assert.Equal(t, 1, executor.calls[pipelines.StepGenerate])
+		assert.Equal(t, 0, executor.calls[pipelines.StepUpscale])
+	})
+
+	t.Run("CancelledRunsDoNotStart", func(t *testing.T) {
+		executor := &stubExecutor{calls: map[string]int{}}
+		service.SetExecutor(executor)
+
+		run, err := service.Create(env.user.Id, "", steps, pipelines.RunOptions{})
+		require.NoError(t, err)
+		_, err = service.Cancel(run.ID, env.user.Id)
+		require.NoError(t, err)
+		service.Process(context.Background())
+
+		run, err = service.Find(run.ID, env.user.Id)
+		require.NoError(t, err)
+		assert.Equal(t, pipelines.StatusCancelled, run.Status)
+		assert.Empty(t, executor.calls)
+
+		_, err = service.Cancel(run.ID, env.user.Id)
+		assert.Error(t, err)
+	})
+
+	t.Run("RejectsMalformedPipelines", func(t *testing.T) {
+		_, err := service.Create(env.user.Id, "", []pipelines.Step{
+			{Type: pipelines.StepGenerate, Model: "flux/schnell", Prompt: "a red fox"},
+			{Type: pipelines.StepGenerate, Model: "flux/schnell", Prompt: "again"},
+		}, pipelines.RunOptions{})
+		assert.ErrorContains(t, err, "generate can only be the first step")
+	})
+}
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// preferenceRecords returns the stored preferences of a model
+func preferenceRecords(t testing.TB, env *testEnv, modelName string) []*core.Record {
+	records, err := env.app.FindAllRecords("model_preferences", dbx.HashExp{"model_name": modelName})
+	require.NoError(t, err)
+	return records
+}
+
+// newPreferences builds an unsaved preferences record
+func newPreferences(t testing.TB, env *testEnv, userID, modelName string, prefs map[string]any) *core.Record {
+	collection, err := env.app.FindCollectionByNameOrId("model_preferences")
+	require.NoError(t, err)
+	record := core.NewRecord(collection)
+	record.Set("user_id", userID)
+	record.Set("model_name", modelName)
+	record.Set("preferences", prefs)
+	return record
+}
+
+func TestPreferenceUniqueIndex(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+
+	require.NoError(t, env.app.Save(newPreferences(t, env, env.user.Id, "flux/schnell", map[string]any{"seed": 1})))
+	assert.Error(t, env.app.Save(newPreferences(t, env, env.user.Id, "flux/schnell", map[string]any{"seed": 2})))
+	assert.NoError(t, env.app.Save(newPreferences(t, env, "someoneelse0001", "flux/schnell", map[string]any{"seed": 3})))
+
+	// Records saved before user_id may share a model name
+	require.NoError(t, env.app.Save(newPreferences(t, env, "", "flux/schnell", nil)))
+	assert.NoError(t, env.app.Save(newPreferences(t, env, "", "flux/schnell", nil)))
+}
+
+func TestPreferenceUpsertRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:    "saving again updates the one record",
+			method:  http.MethodPost,
+			url:     "/api/custom/preferences/save",
+			body:    `{"model_name":"flux/schnell","preferences":{"num_inference_steps":2}}`,
+			headers: authOnly,
+			before: func(t testing.TB, env *testEnv) {
+				require.NoError(t, env.app.Save(newPreferences(t, env, env.user.Id, "flux/schnell", map[string]any{"num_inference_steps": 4})))
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				records := preferenceRecords(t, env, "flux/schnell")
+				require.Len(t, records, 1)
+				assert.JSONEq(t, `{"num_inference_steps":2}`, records[0].GetString("preferences"))
+			},
+		},
+		{
+			name:    "another user's preferences for the model are left alone",
+			method:  http.MethodPost,
+			url:     "/api/custom/preferences/save",
+			body:    `{"model_name":"flux/schnell","preferences":{"num_inference_steps":2}}`,
+			headers: authOnly,
+			before: func(t testing.TB, env *testEnv) {
+				require.NoError(t, env.app.Save(newPreferences(t, env, "someoneelse0001", "flux/schnell", map[string]any{"num_inference_steps": 4})))
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				records := preferenceRecords(t, env, "flux/schnell")
+				require.Len(t, records, 2)
+				for _, record := range records {
+					if record.GetString("user_id") == env.user.Id {
+						assert.JSONEq(t, `{"num_inference_steps":2}`, record.GetString("preferences"))
+					} else {
+						assert.JSONEq(t, `{"num_inference_steps":4}`, record.GetString("preferences"))
+					}
+				}
+			},
+		},
+		{
+			name:            "linked records saved before user_id are claimed",
+			method:          http.MethodPost,
+			url:             "/api/custom/preferences/save",
+			body:            `{"model_name":"flux/dev","preferences":{"guidance_scale":5}}`,
+			headers:         authOnly,
+			setup:           withSavedPreferences,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				records := preferenceRecords(t, env, "flux/dev")
+				require.Len(t, records, 1)
+				assert.Equal(t, env.user.Id, records[0].GetString("user_id"))
+				assert.JSONEq(t, `{"guidance_scale":5}`, records[0].GetString("preferences"))
+			},
+		},
+		{
+			name:    "a concurrent save that created the record first is updated",
+			method:  http.MethodPost,
+			url:     "/api/custom/preferences/save",
+			body:    `{"model_name":"flux/schnell","preferences":{"num_inference_steps":2}}`,
+			headers: authOnly,
+			before: func(t testing.TB, env *testEnv) {
+				// Another request saves the same model between the lookup and the create
+				raced := false
+				env.app.OnRecordCreate("model_preferences").BindFunc(func(e *core.RecordEvent) error {
+					if !raced {
+						raced = true
+						require.NoError(t, e.App.Save(newPreferences(t, env, env.user.Id, "flux/schnell", map[string]any{"num_inference_steps": 4})))
+					}
+					return e.Next()
+				})
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				records := preferenceRecords(t, env, "flux/schnell")
+				require.Len(t, records, 1, "no duplicate is created")
+				assert.JSONEq(t, `{"num_inference_steps":2}`, records[0].GetString("preferences"))
+			},
+		},
+	})
+}
@@ -0,0 +1,181 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/fal"
+	"generatio-pb/internal/money"
+	"generatio-pb/internal/pipelines"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordPriorities appends the priority of every generation to got
+func recordPriorities(got *[]string) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		*got = nil
+		mock := fal.NewMockClient()
+		env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+			*got = append(*got, req.Priority)
+			return mock.GenerateImage(ctx, token, req)
+		})
+	}
+}
+
+// withTier puts the test user on tier, which is capped at priority
+func withTier(tier, priority string) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		env.cfg.TierPriorities = map[string]string{tier: priority}
+		env.user.Set("tier", tier)
+		require.NoError(t, env.app.Save(env.user))
+	}
+}
+
+func TestRequestPriority(t *testing.T) {
+	var priorities []string
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "generations are interactive by default",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a red fox"}`,
+			headers:         withSession,
+			setup:           recordPriorities(&priorities),
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"flux/schnell"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, []string{fal.PriorityInteractive}, priorities)
+			},
+		},
+		{
+			name:            "generations can opt into batch priority",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a red fox","priority":"batch"}`,
+			headers:         withSession,
+			setup:           recordPriorities(&priorities),
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"flux/schnell"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, []string{fal.PriorityBatch}, priorities)
+			},
+		},
+		{
+			name:    "tiers cap the requested priority",
+			method:  http.MethodPost,
+			url:     "/api/custom/generate/image",
+			body:    `{"model":"flux/schnell","prompt":"a red fox","priority":"interactive"}`,
+			headers: withSession,
+			setup: func(t testing.TB, env *testEnv) {
+				recordPriorities(&priorities)(t, env)
+				withTier("free", fal.PriorityBatch)(t, env)
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"flux/schnell"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, []string{fal.PriorityBatch}, priorities)
+			},
+		},
+		{
+			name:    "tier caps apply to comparisons",
+			method:  http.MethodPost,
+			url:     "/api/custom/generate/compare",
+			body:    `{"prompt":"a red fox","variants":[{"model":"flux/schnell"},{"model":"hidream/hidream-i1-fast"}]}`,
+			headers: withSession,
+			setup: func(t testing.TB, env *testEnv) {
+				recordPriorities(&priorities)(t, env)
+				withTier("free", fal.PriorityBatch)(t, env)
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"model":"flux/schnell"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, []string{fal.PriorityBatch, fal.PriorityBatch}, priorities)
+			},
+		},
+		{
+			name:            "unknown priorities are rejected",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a red fox","priority":"urgent"}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`priority must be \"interactive\" or \"batch\"`},
+		},
+		{
+			name:            "pipelines are batch jobs by default",
+			method:          http.MethodPost,
+			url:             "/api/custom/pipelines",
+			body:            `{"steps":[{"type":"generate","model":"flux/schnell","prompt":"a red fox"}]}`,
+			headers:         withSession,
+			setup:           recordPriorities(&priorities),
+			expectedStatus:  http.StatusAccepted,
+			expectedContent: []string{`"priority":"batch"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				run := processedRun(t, env, res)
+				assert.Equal(t, pipelines.StatusSucceeded, run.Status)
+				assert.Equal(t, []string{fal.PriorityBatch}, priorities)
+			},
+		},
+		{
+			name:            "pipelines can run interactively",
+			method:          http.MethodPost,
+			url:             "/api/custom/pipelines",
+			body:            `{"steps":[{"type":"generate","model":"flux/schnell","prompt":"a red fox"}],"priority":"interactive"}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusAccepted,
+			expectedContent: []string{`"priority":"interactive"`},
+		},
+		{
+			name:            "tier caps apply to pipelines",
+			method:          http.MethodPost,
+			url:             "/api/custom/pipelines",
+			body:            `{"steps":[{"type":"generate","model":"flux/schnell","prompt":"a red fox"}],"priority":"interactive"}`,
+			headers:         withSession,
+			setup:           withTier("free", fal.PriorityBatch),
+			expectedStatus:  http.StatusAccepted,
+			expectedContent: []string{`"priority":"batch"`},
+		},
+	})
+}
+
+// orderedExecutor records the runs whose steps it executes
+type orderedExecutor struct {
+	runs []string
+}
+
+func (o *orderedExecutor) ExecuteStep(ctx context.Context, run *pipelines.Run, step pipelines.Step, inputs []string) ([]string, money.Micros, error) {
+	o.runs = append(o.runs, run.ID)
+	return []string{"image"}, 0, nil
+}
+
+func TestPipelinePriority(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+
+	service := pipelines.NewService(env.app)
+	executor := &orderedExecutor{}
+	service.SetExecutor(executor)
+
+	steps := []pipelines.Step{{Type: pipelines.StepGenerate, Model: "flux/schnell", Prompt: "a red fox"}}
+
+	first, err := service.Create(env.user.Id, "", steps, pipelines.RunOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, fal.PriorityBatch, first.Priority)
+	second, err := service.Create(env.user.Id, "", steps, pipelines.RunOptions{Priority: fal.PriorityBatch})
+	require.NoError(t, err)
+	urgent, err := service.Create(env.user.Id, "", steps, pipelines.RunOptions{Priority: fal.PriorityInteractive})
+	require.NoError(t, err)
+
+	_, err = service.Create(env.user.Id, "", steps, pipelines.RunOptions{Priority: "urgent"})
+	assert.ErrorContains(t, err, `invalid priority "urgent"`)
+
+	service.Process(context.Background())
+
+	require.Len(t, executor.runs, 3)
+	assert.Equal(t, urgent.ID, executor.runs[0])
+	assert.ElementsMatch(t, []string{first.ID, second.ID}, executor.runs[1:])
+}
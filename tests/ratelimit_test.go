@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/ratelimit"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withRateLimit allows limit generation requests per minute
+func withRateLimit(limit int) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		env.cfg.GenerationRateLimit = limit
+		env.cfg.GenerationRateWindow = time.Minute
+	}
+}
+
+// useRateLimit spends the seeded user's rate limit
+func useRateLimit(t testing.TB, env *testEnv) {
+	for {
+		if _, allowed := env.handler.RateLimiter().Allow(ratelimit.UserKey(env.user.Id)); !allowed {
+			return
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	t.Run("RequestsAreCountedPerKey", func(t *testing.T) {
+		limiter := ratelimit.NewLimiter(2, time.Minute)
+
+		status, allowed := limiter.Allow("a")
+		assert.True(t, allowed)
+		assert.Equal(t, 2, status.Limit)
+		assert.Equal(t, 1, status.Remaining)
+
+		_, allowed = limiter.Allow("a")
+		assert.True(t, allowed)
+		status, allowed = limiter.Allow("a")
+		assert.False(t, allowed)
+		assert.Zero(t, status.Remaining)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), status.Reset, time.Second)
+
+		_, allowed = limiter.Allow("b")
+		assert.True(t, allowed)
+		assert.Equal(t, 2, limiter.Peek("c").Remaining)
+	})
+
+	t.Run("WindowsEnd", func(t *testing.T) {
+		limiter := ratelimit.NewLimiter(1, 20*time.Millisecond)
+		_, allowed := limiter.Allow("a")
+		assert.True(t, allowed)
+		_, allowed = limiter.Allow("a")
+		assert.False(t, allowed)
+
+		time.Sleep(30 * time.Millisecond)
+		_, allowed = limiter.Allow("a")
+		assert.True(t, allowed)
+	})
+
+	t.Run("ZeroLimitsDisableLimiting", func(t *testing.T) {
+		limiter := ratelimit.NewLimiter(0, time.Minute)
+		assert.False(t, limiter.Enabled())
+		for i := 0; i < 100; i++ {
+			_, allowed := limiter.Allow("a")
+			require.True(t, allowed)
+		}
+	})
+}
+
+func TestRateLimitRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "generation reports the rate limit",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"prompt":"a castle","model":"fal-ai/flux/schnell"}`,
+			headers:         withSession,
+			setup:           withRateLimit(5),
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"images"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, "5", res.Header.Get("X-RateLimit-Limit"))
+				assert.Equal(t, "4", res.Header.Get("X-RateLimit-Remaining"))
+				reset, err := strconv.ParseInt(res.Header.Get("X-RateLimit-Reset"), 10, 64)
+				require.NoError(t, err)
+				assert.Greater(t, reset, time.Now().Unix())
+			},
+		},
+		{
+			name:            "generation over the rate limit is rejected",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"prompt":"a castle","model":"fal-ai/flux/schnell"}`,
+			headers:         withSession,
+			setup:           withRateLimit(1),
+			before:          useRateLimit,
+			expectedStatus:  http.StatusTooManyRequests,
+			expectedContent: []string{`"code":"rate_limit_error"`, "Rate limit exceeded"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, "0", res.Header.Get("X-RateLimit-Remaining"))
+				assert.NotEmpty(t, res.Header.Get("Retry-After"))
+			},
+		},
+		{
+			name:               "disabled rate limits send no headers",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"prompt":"a castle","model":"fal-ai/flux/schnell"}`,
+			headers:            withSession,
+			setup:              withRateLimit(0),
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"images"`},
+			notExpectedContent: []string{"rate_limit_error"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Empty(t, res.Header.Get("X-RateLimit-Limit"))
+			},
+		},
+		{
+			name:            "limits summarize every standing",
+			method:          http.MethodGet,
+			url:             "/api/custom/limits",
+			headers:         authOnly,
+			setup:           withRateLimit(5),
+			before:          useRateLimit,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"rate_limit":{"limit":5,"remaining":0,`, `"window_seconds":60`, `"budget":{"monthly_budget_usd":0,`, `"remaining_usd":null`, `"quota":{"daily_image_quota":0,"used_today":0,"remaining":null,`},
+		},
+		{
+			name:    "limits include budgets and quotas",
+			method:  http.MethodGet,
+			url:     "/api/custom/limits",
+			headers: authOnly,
+			setup: func(t testing.TB, env *testEnv) {
+				withBudgetSpent(t, env)
+				withDailyQuotaUsed(t, env)
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"monthly_budget_usd":1,`, `"spent_usd":1,`, `"remaining_usd":0,`, `"daily_image_quota":1,"used_today":2,"remaining":0,`},
+		},
+		{
+			name:            "limits require authentication",
+			method:          http.MethodGet,
+			url:             "/api/custom/limits",
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{"authentication_error"},
+		},
+	})
+}
@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/money"
+	"generatio-pb/internal/reconcile"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixedBilling reports the same billed amount for every user
+type fixedBilling float64
+
+func (b fixedBilling) UserSpend(ctx context.Context, userID string) (money.Micros, error) {
+	return money.FromUSD(float64(b)), nil
+}
+
+// withSpendingDrift records $1.00 over three images for the seeded user while
+// their two images only cost $0.50
+func withSpendingDrift(t testing.TB, env *testEnv) {
+	env.user.Set("financial_data", map[string]any{"total_spent": 1.0, "total_images": 3, "salt": "keep-me"})
+	require.NoError(t, env.app.Save(env.user))
+
+	env.createImage(t, map[string]any{"other_info": map[string]any{"cost_usd": 0.25}})
+	env.createImage(t, map[string]any{"other_info": map[string]any{"cost_usd": 0.25}, "deleted_at": "2026-01-01 00:00:00.000Z"})
+}
+
+func TestReconcileService(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+	withSpendingDrift(t, env)
+
+	service := reconcile.NewService(env.app, 0, false)
+	assert.Nil(t, service.LastReport())
+
+	t.Run("ReportsDriftWithoutFixing", func(t *testing.T) {
+		report, err := service.Run(context.Background(), false)
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.UsersScanned)
+		assert.Equal(t, 1, report.Drifted)
+		assert.Zero(t, report.Fixed)
+		assert.False(t, report.BillingChecked)
+
+		require.Len(t, report.Users, 1)
+		drift := report.Users[0]
+		assert.Equal(t, env.user.Id, drift.UserID)
+		assert.InDelta(t, 0.5, drift.ImageSpent, 1e-9)
+		assert.Equal(t, 2, drift.ImageCount)
+		assert.InDelta(t, 0.5, drift.Drift, 1e-9)
+		assert.Equal(t, money.Micros(500_000), drift.DriftMicros)
+		assert.Equal(t, money.Micros(500_000), report.TotalDriftMicros)
+		assert.False(t, drift.Fixed)
+		assert.Same(t, report, service.LastReport())
+	})
+
+	t.Run("ComparesBillingWhenAvailable", func(t *testing.T) {
+		service.SetBillingSource(fixedBilling(0.75))
+		defer service.SetBillingSource(nil)
+
+		report, err := service.Run(context.Background(), false)
+		require.NoError(t, err)
+		assert.True(t, report.BillingChecked)
+		require.Len(t, report.Users, 1)
+		require.NotNil(t, report.Users[0].BilledSpent)
+		assert.InDelta(t, 0.25, report.Users[0].BilledDrift, 1e-9)
+		assert.Equal(t, money.Micros(250_000), report.Users[0].BilledDriftMicros)
+	})
+
+	t.Run("FixResetsTotalsToImageCosts", func(t *testing.T) {
+		report, err := service.Run(context.Background(), true)
+		require.NoError(t, err)
+		assert.Equal(t, 1, report.Fixed)
+
+		user, err := env.app.FindRecordById("generatio_users", env.user.Id)
+		require.NoError(t, err)
+		data := map[string]any{}
+		require.NoError(t, user.UnmarshalJSONField("financial_data", &data))
+		assert.InDelta(t, 0.5, data["total_spent"], 1e-9)
+		assert.EqualValues(t, 2, data["total_images"])
+		assert.Equal(t, "keep-me", data["salt"])
+
+		report, err = service.Run(context.Background(), false)
+		require.NoError(t, err)
+		assert.Zero(t, report.Drifted)
+		assert.Empty(t, report.Users)
+	})
+}
+
+func TestReconciliationRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "reconciliation requires a superuser",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/reconciliation/run",
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"Superuser access required"},
+		},
+		{
+			name:            "no report exists before the first run",
+			method:          http.MethodGet,
+			url:             "/api/custom/admin/reconciliation",
+			headers:         superuserOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"No reconciliation has run yet"},
+		},
+		{
+			name:            "runs report drift",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/reconciliation/run",
+			headers:         superuserOnly,
+			setup:           withSpendingDrift,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"drifted":1`, `"fixed":0`, `"recorded_spent":1`, `"image_spent":0.5`},
+		},
+		{
+			name:            "runs can fix drift",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/reconciliation/run",
+			body:            `{"fix":true}`,
+			headers:         superuserOnly,
+			setup:           withSpendingDrift,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"drifted":1`, `"fixed":1`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				report := env.handler.Reconciler().LastReport()
+				require.NotNil(t, report)
+				assert.True(t, report.Users[0].Fixed)
+			},
+		},
+	})
+}
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/money"
+	"generatio-pb/internal/recordjson"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stringEncoded returns value encoded as JSON and then as a JSON string, the
+// way older clients saved JSON fields
+func stringEncoded(t testing.TB, value any) types.JSONRaw {
+	inner, err := json.Marshal(value)
+	require.NoError(t, err)
+	outer, err := json.Marshal(string(inner))
+	require.NoError(t, err)
+	return types.JSONRaw(outer)
+}
+
+func TestRecordJSON(t *testing.T) {
+	totals := map[string]any{"total_spent_micros": 300000, "total_images": 100, "salt": "keep-me"}
+	want := localmodels.FinancialData{TotalSpentMicros: 300000, TotalImages: 100}
+
+	for name, value := range map[string]any{
+		"raw JSON":       types.JSONRaw(`{"total_spent_micros":300000,"total_images":100}`),
+		"map":            totals,
+		"string":         `{"total_spent_micros":300000,"total_images":100}`,
+		"string-encoded": stringEncoded(t, totals),
+	} {
+		t.Run("Decode "+name, func(t *testing.T) {
+			record := core.NewRecord(core.NewBaseCollection("records"))
+			record.Set("financial_data", value)
+
+			var data localmodels.FinancialData
+			require.NoError(t, recordjson.Decode(record, "financial_data", &data))
+			assert.Equal(t, want, data)
+		})
+	}
+
+	t.Run("EmptyFieldsLeaveTheValue", func(t *testing.T) {
+		record := core.NewRecord(core.NewBaseCollection("records"))
+		data := localmodels.FinancialData{TotalImages: 1}
+		require.NoError(t, recordjson.Decode(record, "financial_data", &data))
+		record.Set("financial_data", types.JSONRaw("null"))
+		require.NoError(t, recordjson.Decode(record, "financial_data", &data))
+		assert.Equal(t, 1, data.TotalImages)
+	})
+
+	t.Run("MismatchedTypesAreErrors", func(t *testing.T) {
+		record := core.NewRecord(core.NewBaseCollection("records"))
+		record.Set("financial_data", types.JSONRaw(`[1,2]`))
+		var data localmodels.FinancialData
+		assert.Error(t, recordjson.Decode(record, "financial_data", &data))
+	})
+
+	t.Run("MergeKeepsOtherKeys", func(t *testing.T) {
+		record := core.NewRecord(core.NewBaseCollection("records"))
+		record.Set("financial_data", stringEncoded(t, totals))
+		require.NoError(t, recordjson.Merge(record, "financial_data", localmodels.FinancialData{TotalSpentMicros: 1000, TotalImages: 1}))
+
+		assert.JSONEq(t, `{"total_spent":0,"total_spent_micros":1000,"total_images":1,"salt":"keep-me"}`, record.GetString("financial_data"))
+	})
+}
+
+func TestRecordJSONRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:   "string-encoded financial data is read",
+			method: http.MethodGet,
+			url:    "/api/custom/financial/stats",
+			setup: func(t testing.TB, env *testEnv) {
+				env.user.Set("financial_data", stringEncoded(t, map[string]any{"total_spent_micros": 300000, "total_images": 100}))
+				require.NoError(t, env.app.Save(env.user))
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"total_spent_micros":300000`, `"total_images":100`},
+		},
+		{
+			name:   "generations add to string-encoded totals",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				env.user.Set("financial_data", stringEncoded(t, map[string]any{"total_spent_micros": 300000, "total_images": 100, "salt": "keep-me"}))
+				require.NoError(t, env.app.Save(env.user))
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"cost_micros":3000`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				user, err := env.app.FindRecordById("generatio_users", env.user.Id)
+				require.NoError(t, err)
+				var data struct {
+					TotalSpentMicros money.Micros `json:"total_spent_micros"`
+					TotalImages      int          `json:"total_images"`
+					Salt             string       `json:"salt"`
+				}
+				require.NoError(t, recordjson.Decode(user, "financial_data", &data))
+				assert.Equal(t, money.Micros(303000), data.TotalSpentMicros)
+				assert.Equal(t, 101, data.TotalImages)
+				assert.Equal(t, "keep-me", data.Salt)
+			},
+		},
+		{
+			name:   "string-encoded preferences are read",
+			method: http.MethodGet,
+			url:    "/api/custom/preferences?model_name=flux/schnell",
+			setup: func(t testing.TB, env *testEnv) {
+				record := newPreferences(t, env, env.user.Id, "flux/schnell", nil)
+				record.Set("preferences", stringEncoded(t, map[string]any{"num_inference_steps": 4}))
+				require.NoError(t, env.app.Save(record))
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"has_preferences":true`, `"num_inference_steps":4`},
+		},
+	})
+}
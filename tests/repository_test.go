@@ -0,0 +1,142 @@
+package tests
+
+import (
+	"net/http"
+	"sort"
+	"testing"
+
+	"generatio-pb/internal/moderation"
+	"generatio-pb/internal/pagination"
+	"generatio-pb/internal/repository"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seedTrashedRecords creates a live and a trashed subfolder of a live
+// folder, with a live and a trashed image in it and an image of another user
+func seedTrashedRecords(t testing.TB, env *testEnv) {
+	seedFolder(t, env, "livefolder00001", "")
+	seedFolder(t, env, "livechild000001", "livefolder00001")
+	seedFolder(t, env, "trashedchild001", "livefolder00001")
+	trashed, err := env.app.FindRecordById("folders", "trashedchild001")
+	require.NoError(t, err)
+	trashed.Set("deleted_at", types.NowDateTime())
+	require.NoError(t, env.app.Save(trashed))
+
+	other := env.createUser(t, "repoother000001", "other@test.com")
+	env.createImage(t, map[string]any{"id": "liveimage000001", "folder_id": "livefolder00001"})
+	env.createImage(t, map[string]any{"id": "trashedimage001", "folder_id": "livefolder00001", "deleted_at": types.NowDateTime()})
+	env.createImage(t, map[string]any{"id": "otherimage00001", "folder_id": "livefolder00001", "user_id": other.Id})
+}
+
+// recordIDs returns the sorted ids of records
+func recordIDs(records []*core.Record) []string {
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.Id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestRepositoryScopes(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+	seedTrashedRecords(t, env)
+
+	folders := repository.NewFolders(env.app)
+	images := repository.NewImages(env.app)
+
+	t.Run("GetLeavesOutTrashedRecords", func(t *testing.T) {
+		_, err := folders.Get("trashedchild001")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		_, err = images.Get("trashedimage001")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+
+		folder, err := folders.GetIn(repository.All, "trashedchild001")
+		require.NoError(t, err)
+		assert.Equal(t, "trashedchild001", folder.Id)
+		_, err = folders.GetIn(repository.Trashed, "livechild000001")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+	})
+
+	t.Run("OwnedChecksTheUser", func(t *testing.T) {
+		_, err := images.Owned(env.user.Id, "otherimage00001")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+		_, err = images.Owned(env.user.Id, "trashedimage001")
+		assert.ErrorIs(t, err, repository.ErrNotFound)
+
+		image, err := images.OwnedIn(repository.Trashed, env.user.Id, "trashedimage001")
+		require.NoError(t, err)
+		assert.Equal(t, "trashedimage001", image.Id)
+	})
+
+	t.Run("ListsLeaveOutTrashedRecords", func(t *testing.T) {
+		children, err := folders.Children("livefolder00001")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"livechild000001"}, recordIDs(children))
+
+		library, err := folders.InLibrary(env.user.Id, "", 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"livechild000001", "livefolder00001"}, recordIDs(library))
+
+		byIDs, err := folders.ByIDs([]string{"livechild000001", "trashedchild001"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"livechild000001"}, recordIDs(byIDs))
+
+		inFolder, err := images.InFolder("livefolder00001", "created", 0, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"liveimage000001", "otherimage00001"}, recordIDs(inFolder))
+	})
+
+	t.Run("TrashPageListsOnlyTheUsersTrash", func(t *testing.T) {
+		trash, next, err := images.TrashPage(env.user.Id, pagination.Page{Number: 1, Limit: 10})
+		require.NoError(t, err)
+		assert.Empty(t, next)
+		assert.Equal(t, []string{"trashedimage001"}, recordIDs(trash))
+	})
+}
+
+func TestSoftDeleteRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:   "trashed images cannot have their quarantine overridden",
+			method: http.MethodPost,
+			url:    "/api/custom/images/quarantined0001/override",
+			setup: func(t testing.TB, env *testEnv) {
+				env.createImage(t, map[string]any{"id": "quarantined0001", "moderation_status": moderation.StatusQuarantined, "deleted_at": types.NowDateTime()})
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"code":"not_found"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				image, err := env.app.FindRecordById("images", "quarantined0001")
+				require.NoError(t, err)
+				assert.Equal(t, moderation.StatusQuarantined, image.GetString("moderation_status"))
+			},
+		},
+		{
+			name:               "trashed subfolders are left out of listings",
+			method:             http.MethodGet,
+			url:                "/api/custom/collections",
+			setup:              seedTrashedRecords,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"id":"livefolder00001"`, `"id":"livechild000001"`},
+			notExpectedContent: []string{"trashedchild001"},
+		},
+		{
+			name:               "trashed images are left out of folder listings",
+			method:             http.MethodGet,
+			url:                "/api/custom/collections/livefolder00001/images",
+			setup:              seedTrashedRecords,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"id":"liveimage000001"`},
+			notExpectedContent: []string{"trashedimage001"},
+		},
+	})
+}
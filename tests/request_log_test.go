@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"generatio-pb/internal/redact"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// logRequestsTo turns request logging on and sends it to buf at debug level
+func logRequestsTo(buf *bytes.Buffer) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		env.cfg.LogRequests = true
+		env.handler.SetRequestLogger(slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	}
+}
+
+func TestRedact(t *testing.T) {
+	headers := redact.Headers(http.Header{
+		"Authorization": {"Bearer secret-jwt"},
+		"X-Session-Id":  {"session-123"},
+		"Accept":        {"application/json"},
+	})
+	assert.Equal(t, map[string]string{"Authorization": redact.Mask, "X-Session-Id": redact.Mask, "Accept": "application/json"}, headers)
+
+	assert.JSONEq(t, `{"fal_token":"[REDACTED]","nested":[{"Password":"[REDACTED]","name":"kept"}]}`,
+		redact.JSON([]byte(`{"fal_token":"fal-secret","nested":[{"Password":"hunter2","name":"kept"}]}`)))
+	assert.Equal(t, "<9 bytes>", redact.JSON([]byte("not json!")))
+	assert.Empty(t, redact.JSON(nil))
+	assert.Equal(t, "limit=2&token=%5BREDACTED%5D", redact.Query(url.Values{"token": {"share-secret"}, "limit": {"2"}}))
+	assert.Equal(t, "expires=1700000000&sig=%5BREDACTED%5D", redact.Query(url.Values{"sig": {"abcdef"}, "expires": {"1700000000"}}))
+	assert.JSONEq(t, `{"key":"[REDACTED]","api_key":{"id":"key1","prefix":"gpk_ab12"}}`,
+		redact.JSON([]byte(`{"key":"gpk_ab12secretpart","api_key":{"id":"key1","prefix":"gpk_ab12"}}`)))
+}
+
+func TestRequestLogRoutes(t *testing.T) {
+	var setupLog, sessionLog, apiKeyLog, offLog bytes.Buffer
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "token setup is logged without the token, password or credentials",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/setup",
+			body:            `{"fal_token":"` + testFALToken + `","password":"` + testPassword + `"}`,
+			headers:         withSession,
+			before:          logRequestsTo(&setupLog),
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				logged := setupLog.String()
+				assert.Contains(t, logged, `"path":"/api/custom/tokens/setup"`)
+				assert.Contains(t, logged, `"status":200`)
+				assert.Contains(t, logged, `"Authorization":"[REDACTED]"`)
+				assert.Contains(t, logged, `"X-Session-Id":"[REDACTED]"`)
+				assert.Contains(t, logged, `\"fal_token\":\"[REDACTED]\"`)
+				assert.NotContains(t, logged, testFALToken)
+				assert.NotContains(t, logged, testPassword)
+				assert.NotContains(t, logged, env.token)
+			},
+		},
+		{
+			name:            "session IDs in responses are redacted",
+			method:          http.MethodPost,
+			url:             "/api/custom/auth/create-session",
+			body:            `{"password":"` + testPassword + `"}`,
+			setup:           withStoredToken,
+			headers:         authOnly,
+			before:          logRequestsTo(&sessionLog),
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"session_id":"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				logged := sessionLog.String()
+				assert.Contains(t, logged, `\"session_id\":\"[REDACTED]\"`)
+				assert.NotContains(t, logged, testPassword)
+			},
+		},
+		{
+			name:            "new API keys in responses are redacted",
+			method:          http.MethodPost,
+			url:             "/api/custom/api-keys",
+			body:            `{"name":"gallery","scopes":["images:read"]}`,
+			headers:         authOnly,
+			before:          logRequestsTo(&apiKeyLog),
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"key":"gpk_`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				logged := apiKeyLog.String()
+				assert.Contains(t, logged, `\"key\":\"[REDACTED]\"`)
+				assert.NotContains(t, logged, `\"key\":\"gpk_`)
+			},
+		},
+		{
+			name:   "nothing is logged unless enabled",
+			method: http.MethodGet,
+			url:    "/api/custom/collections",
+			before: func(t testing.TB, env *testEnv) {
+				env.handler.SetRequestLogger(slog.New(slog.NewJSONHandler(&offLog, &slog.HandlerOptions{Level: slog.LevelDebug})))
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"data":[]`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Empty(t, offLog.String())
+			},
+		},
+	})
+}
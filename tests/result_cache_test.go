@@ -0,0 +1,189 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/moderation"
+	"generatio-pb/internal/resultcache"
+
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seededKey is the cache key of the seeded user's seed 7 lighthouse request
+func seededKey(env *testEnv) string {
+	return resultcache.Key(env.user.Id, "", "", "flux/schnell", "a lighthouse at dusk", map[string]interface{}{"seed": float64(7)})
+}
+
+// withCachedGeneration stores a two-image seeded generation under the
+// scenario request's cache key
+func withCachedGeneration(fields map[string]any) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		for i, id := range []string{"cachedimage0001", "cachedimage0002"} {
+			image := map[string]any{
+				"id":           id,
+				"url":          "https://fal.media/cached.jpg",
+				"request_id":   "cachedrequest01",
+				"batch_number": i + 1,
+				"cache_key":    seededKey(env),
+			}
+			if i == 1 {
+				for key, value := range fields {
+					image[key] = value
+				}
+			}
+			env.createImage(t, image)
+		}
+	}
+}
+
+func TestResultCacheStore(t *testing.T) {
+	seeded := map[string]interface{}{"seed": 7}
+	key := resultcache.Key("user1", "", "", "flux/schnell", "a lighthouse", seeded)
+	assert.NotEmpty(t, key)
+	assert.Empty(t, resultcache.Key("user1", "", "", "flux/schnell", "a lighthouse", nil))
+	assert.NotEqual(t, key, resultcache.Key("user2", "", "", "flux/schnell", "a lighthouse", seeded))
+	assert.NotEqual(t, key, resultcache.Key("user1", "org1", "", "flux/schnell", "a lighthouse", seeded))
+	assert.NotEqual(t, key, resultcache.Key("user1", "", "folder1", "flux/schnell", "a lighthouse", seeded))
+
+	env := newTestEnv(t)
+	t.Cleanup(env.app.Cleanup)
+	withCachedGeneration(nil)(t, env)
+
+	store := resultcache.NewStore(env.app, time.Hour)
+	images, err := store.Lookup(seededKey(env))
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+	assert.Equal(t, "cachedimage0001", images[0].Id)
+	assert.Equal(t, "cachedimage0002", images[1].Id)
+
+	// The newest generation under a key wins
+	newer := env.createImage(t, map[string]any{"request_id": "newerrequest001", "cache_key": seededKey(env)})
+	images, err = store.Lookup(seededKey(env))
+	require.NoError(t, err)
+	require.Len(t, images, 1)
+	assert.Equal(t, newer.Id, images[0].Id)
+
+	// A trashed generation does not hide the one before it
+	newer.Set("deleted_at", types.NowDateTime())
+	require.NoError(t, env.app.Save(newer))
+	images, err = store.Lookup(seededKey(env))
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+	assert.Equal(t, "cachedimage0001", images[0].Id)
+
+	images, err = resultcache.NewStore(env.app, 0).Lookup(seededKey(env))
+	require.NoError(t, err)
+	assert.Empty(t, images)
+
+	images, err = store.Lookup("")
+	require.NoError(t, err)
+	assert.Empty(t, images)
+}
+
+func TestResultCacheRoutes(t *testing.T) {
+	const seededRequest = `{"model":"flux/schnell","prompt":"a lighthouse at dusk","parameters":{"seed":7},"allow_duplicate":true}`
+
+	old, err := types.ParseDateTime(time.Now().AddDate(0, 0, -30))
+	require.NoError(t, err)
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:   "a repeated seeded request returns the stored images",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   seededRequest,
+			setup: func(t testing.TB, env *testEnv) {
+				failGenerations(t, env)
+				withCachedGeneration(nil)(t, env)
+			},
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"cached":true`, `"cost":0`, `"id":"cachedimage0001"`, `"id":"cachedimage0002"`},
+			notExpectedContent: []string{"FAL AI was called", "mock-image-url.com"},
+		},
+		{
+			name:               "no_cache generates anyway",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"flux/schnell","prompt":"a lighthouse at dusk","parameters":{"seed":7},"allow_duplicate":true,"no_cache":true}`,
+			setup:              withCachedGeneration(nil),
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"url":"https://mock-image-url.com/image.jpg"`},
+			notExpectedContent: []string{`"cached"`, "cachedimage0001"},
+		},
+		{
+			name:               "seeded generations are stored for repeats",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               seededRequest,
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"url":"https://mock-image-url.com/image.jpg"`},
+			notExpectedContent: []string{`"cached"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				records, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				require.Len(t, records, 1)
+				assert.Equal(t, seededKey(env), records[0].GetString(resultcache.Field))
+			},
+		},
+		{
+			name:               "unseeded generations are not stored",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               `{"model":"flux/schnell","prompt":"a lighthouse at dusk"}`,
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"url":"https://mock-image-url.com/image.jpg"`},
+			notExpectedContent: []string{`"cached"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				records, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				require.Len(t, records, 1)
+				assert.Empty(t, records[0].GetString(resultcache.Field))
+			},
+		},
+		{
+			name:               "a trashed image invalidates the stored generation",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               seededRequest,
+			setup:              withCachedGeneration(map[string]any{"deleted_at": types.NowDateTime()}),
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"url":"https://mock-image-url.com/image.jpg"`},
+			notExpectedContent: []string{`"cached"`},
+		},
+		{
+			name:               "a quarantined image invalidates the stored generation",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/image",
+			body:               seededRequest,
+			setup:              withCachedGeneration(map[string]any{"moderation_status": moderation.StatusQuarantined}),
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"url":"https://mock-image-url.com/image.jpg"`},
+			notExpectedContent: []string{`"cached"`},
+		},
+		{
+			name:   "generations older than the ttl are not reused",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   seededRequest,
+			setup: func(t testing.TB, env *testEnv) {
+				for _, id := range []string{"cachedimage0001", "cachedimage0002"} {
+					env.createImage(t, map[string]any{"id": id, "request_id": "cachedrequest01", "cache_key": seededKey(env), "created": old})
+				}
+			},
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"url":"https://mock-image-url.com/image.jpg"`},
+			notExpectedContent: []string{`"cached"`},
+		},
+	})
+}
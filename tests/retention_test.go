@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seedAgedImages creates an expired image, an expired favorite and a recent image
+func seedAgedImages(t testing.TB, env *testEnv) {
+	old, err := types.ParseDateTime(time.Now().AddDate(0, 0, -45))
+	require.NoError(t, err)
+
+	env.createImage(t, map[string]any{"id": "expiredimage001", "title": "old", "created": old})
+	env.createImage(t, map[string]any{"id": "favoriteimage01", "title": "old favorite", "created": old, "favorite": true})
+	env.createImage(t, map[string]any{"id": "recentimage0001", "title": "recent"})
+}
+
+func withRetentionDays(days int) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		env.user.Set("retention_days", days)
+		require.NoError(t, env.app.Save(env.user))
+		seedAgedImages(t, env)
+	}
+}
+
+func TestRetentionRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "default policy keeps images forever",
+			method:          http.MethodGet,
+			url:             "/api/custom/retention",
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"retention_days":0`, `"source":"deployment"`, `"action":"archive"`},
+		},
+		{
+			name:            "user sets a retention override",
+			method:          http.MethodPost,
+			url:             "/api/custom/retention",
+			body:            `{"retention_days":30}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"retention_days":30`, `"source":"user"`},
+		},
+		{
+			name:            "override requires retention_days",
+			method:          http.MethodPost,
+			url:             "/api/custom/retention",
+			body:            `{}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`},
+		},
+		{
+			name:               "preview lists expired images except favorites",
+			method:             http.MethodGet,
+			url:                "/api/custom/retention/preview",
+			setup:              withRetentionDays(30),
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"id":"expiredimage001"`},
+			notExpectedContent: []string{"favoriteimage01", "recentimage0001"},
+		},
+		{
+			name:               "negative override keeps images forever",
+			method:             http.MethodGet,
+			url:                "/api/custom/retention/preview",
+			setup:              withRetentionDays(-1),
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"data":[]`},
+			notExpectedContent: []string{"expiredimage001"},
+		},
+		{
+			name:            "retention run requires superuser",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/retention/run",
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{`"code":"authorization_error"`},
+		},
+		{
+			name:            "retention run archives expired images",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/retention/run",
+			setup:           withRetentionDays(30),
+			headers:         superuserOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"archived":1`, `"deleted":0`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				expired, err := env.app.FindRecordById("images", "expiredimage001")
+				require.NoError(t, err)
+				assert.False(t, expired.GetDateTime("archived_at").IsZero())
+
+				favorite, err := env.app.FindRecordById("images", "favoriteimage01")
+				require.NoError(t, err)
+				assert.True(t, favorite.GetDateTime("archived_at").IsZero())
+			},
+		},
+		{
+			name:   "retention run deletes when configured",
+			method: http.MethodPost,
+			url:    "/api/custom/admin/retention/run",
+			setup: func(t testing.TB, env *testEnv) {
+				env.cfg.RetentionDays = 30
+				env.cfg.RetentionAction = "delete"
+				seedAgedImages(t, env)
+			},
+			headers:         superuserOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"deleted":1`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				_, err := env.app.FindRecordById("images", "expiredimage001")
+				assert.Error(t, err)
+
+				records, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				assert.Len(t, records, 2)
+			},
+		},
+	})
+}
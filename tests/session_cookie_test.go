@@ -0,0 +1,229 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withSessionCookies enables cookie transport for session IDs
+func withSessionCookies(t testing.TB, env *testEnv) {
+	env.cfg.SessionCookie = true
+}
+
+// testCSRFToken is the double-submit token cookie sessions send back
+const testCSRFToken = "5f1c0e9a2b7d4c3e8f6a1b0c9d8e7f6a"
+
+// withSessionCookie creates a session and sends its ID only in the session
+// cookie, echoing the CSRF cookie like a browser frontend would
+func withSessionCookie(t testing.TB, env *testEnv) map[string]string {
+	headers := env.sessionHeaders(t)
+	headers["Cookie"] = "generatio_session=" + headers["X-Session-ID"] + "; generatio_csrf=" + testCSRFToken
+	headers["X-CSRF-Token"] = testCSRFToken
+	delete(headers, "X-Session-ID")
+	return headers
+}
+
+// withoutCSRFHeader sends the session cookie like withSessionCookie, but
+// without echoing the CSRF token, as a forged cross-site request would
+func withoutCSRFHeader(t testing.TB, env *testEnv) map[string]string {
+	headers := withSessionCookie(t, env)
+	delete(headers, "X-CSRF-Token")
+	return headers
+}
+
+// cookieNamed returns the cookie a response set under name, if any
+func cookieNamed(res *http.Response, name string) *http.Cookie {
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// sessionCookie returns the session cookie a response set, if any
+func sessionCookie(res *http.Response) *http.Cookie {
+	return cookieNamed(res, "generatio_session")
+}
+
+func TestSessionCookieRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:   "create session sets an HttpOnly cookie",
+			method: http.MethodPost,
+			url:    "/api/custom/auth/create-session",
+			body:   `{"password":"` + testPassword + `"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withStoredToken(t, env)
+				withSessionCookies(t, env)
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"session_id":`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				cookie := sessionCookie(res)
+				require.NotNil(t, cookie)
+				assert.True(t, cookie.HttpOnly)
+				assert.True(t, cookie.Secure)
+				assert.Equal(t, http.SameSiteStrictMode, cookie.SameSite)
+				assert.Equal(t, "/api/custom/", cookie.Path)
+
+				session, err := env.sessionStore.GetUserSession(env.user.Id)
+				require.NoError(t, err)
+				assert.Equal(t, session.ID, cookie.Value)
+
+				csrf := cookieNamed(res, "generatio_csrf")
+				require.NotNil(t, csrf)
+				assert.False(t, csrf.HttpOnly, "scripts read the CSRF token")
+				assert.Len(t, csrf.Value, 64)
+				assert.Equal(t, csrf.Value, res.Header.Get("X-CSRF-Token"))
+			},
+		},
+		{
+			name:            "no cookie unless enabled",
+			method:          http.MethodPost,
+			url:             "/api/custom/auth/create-session",
+			body:            `{"password":"` + testPassword + `"}`,
+			setup:           withStoredToken,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"session_id":`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Nil(t, sessionCookie(res))
+			},
+		},
+		{
+			name:   "SameSite is configurable",
+			method: http.MethodPost,
+			url:    "/api/custom/auth/create-session",
+			body:   `{"password":"` + testPassword + `"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withStoredToken(t, env)
+				withSessionCookies(t, env)
+				env.cfg.SessionCookieSameSite = "lax"
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"session_id":`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				cookie := sessionCookie(res)
+				require.NotNil(t, cookie)
+				assert.Equal(t, http.SameSiteLaxMode, cookie.SameSite)
+			},
+		},
+		{
+			name:            "generation reads the session from the cookie",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"prompt":"a castle","model":"fal-ai/flux/schnell"}`,
+			setup:           withSessionCookies,
+			headers:         withSessionCookie,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"images"`},
+		},
+		{
+			name:            "the cookie is ignored unless enabled",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"prompt":"a castle","model":"fal-ai/flux/schnell"}`,
+			headers:         withSessionCookie,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{"Valid session required"},
+		},
+		{
+			name:   "the header wins over the cookie",
+			method: http.MethodGet,
+			url:    "/api/custom/auth/session",
+			setup:  withSessionCookies,
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				headers := withSessionCookie(t, env)
+				headers["X-Session-ID"] = "unknown-session"
+				return headers
+			},
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"Session not found"},
+		},
+		{
+			name:            "deleting the session clears the cookie",
+			method:          http.MethodDelete,
+			url:             "/api/custom/auth/session",
+			setup:           withSessionCookies,
+			headers:         withSessionCookie,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				cookie := sessionCookie(res)
+				require.NotNil(t, cookie)
+				assert.Empty(t, cookie.Value)
+				assert.Negative(t, cookie.MaxAge)
+				require.NotNil(t, cookieNamed(res, "generatio_csrf"))
+
+				_, err := env.sessionStore.GetUserSession(env.user.Id)
+				assert.Error(t, err)
+			},
+		},
+	})
+}
+
+func TestCSRFRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "cookie sessions need the CSRF token",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"prompt":"a castle","model":"fal-ai/flux/schnell"}`,
+			setup:           withSessionCookies,
+			headers:         withoutCSRFHeader,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"CSRF token missing or invalid"},
+		},
+		{
+			name:   "a mismatched CSRF token is rejected",
+			method: http.MethodDelete,
+			url:    "/api/custom/auth/session",
+			setup:  withSessionCookies,
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				headers := withSessionCookie(t, env)
+				headers["X-CSRF-Token"] = "forged"
+				return headers
+			},
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"CSRF token missing or invalid"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				_, err := env.sessionStore.GetUserSession(env.user.Id)
+				assert.NoError(t, err, "the session survives")
+			},
+		},
+		{
+			name:            "reads do not need the CSRF token",
+			method:          http.MethodGet,
+			url:             "/api/custom/auth/session",
+			setup:           withSessionCookies,
+			headers:         withoutCSRFHeader,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"ttl_seconds":`},
+		},
+		{
+			name:            "header sessions do not need the CSRF token",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"prompt":"a castle","model":"fal-ai/flux/schnell"}`,
+			setup:           withSessionCookies,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"images"`},
+		},
+		{
+			name:            "no CSRF check while cookie transport is off",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"prompt":"a castle","model":"fal-ai/flux/schnell"}`,
+			headers:         withoutCSRFHeader,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{"Valid session required"},
+		},
+	})
+}
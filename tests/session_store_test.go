@@ -0,0 +1,273 @@
+package tests
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/auth"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fillSessionStore creates one session for each of n users and returns their IDs
+func fillSessionStore(tb testing.TB, store *auth.SessionStore, n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		sessionID, err := store.Create(fmt.Sprintf("user%06d", i), testFALToken)
+		require.NoError(tb, err)
+		ids[i] = sessionID
+	}
+	return ids
+}
+
+func TestSessionStoreConcurrency(t *testing.T) {
+	store := auth.NewSessionStore(time.Hour)
+
+	const workers, perWorker = 16, 50
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			userID := fmt.Sprintf("worker%02d", w)
+			for i := 0; i < perWorker; i++ {
+				sessionID, err := store.Create(userID, testFALToken)
+				if !assert.NoError(t, err) {
+					return
+				}
+				store.Touch(sessionID)
+				_, err = store.Get(sessionID)
+				assert.NoError(t, err)
+				assert.NoError(t, store.ExtendSession(sessionID))
+				if i%2 == 1 {
+					assert.NoError(t, store.Delete(sessionID))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	stats := store.Stats()
+	assert.Equal(t, workers*perWorker/2, stats.TotalSessions)
+	assert.Equal(t, stats.TotalSessions, stats.ActiveSessions)
+	assert.Equal(t, stats.TotalSessions, store.GetSessionCount())
+
+	// A user's sessions are spread over shards and all of them are removed
+	require.NoError(t, store.DeleteUserSessions("worker03"))
+	_, err := store.GetUserSession("worker03")
+	assert.Error(t, err)
+	_, err = store.GetUserSession("worker04")
+	assert.NoError(t, err)
+	assert.Equal(t, (workers-1)*perWorker/2, store.GetSessionCount())
+
+	store.Clear()
+	assert.Zero(t, store.GetSessionCount())
+}
+
+// TestSessionStoreCopies reads sessions while they are renewed; run it with
+// -race to check that readers never share the stored session
+func TestSessionStoreCopies(t *testing.T) {
+	store := auth.NewSessionStore(time.Hour)
+	sessionID, err := store.Create("copied_user", testFALToken)
+	require.NoError(t, err)
+
+	const workers, rounds = 8, 200
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				switch w % 4 {
+				case 0:
+					assert.NoError(t, store.ExtendSession(sessionID))
+				case 1:
+					store.Touch(sessionID)
+				case 2:
+					session, err := store.Get(sessionID)
+					if assert.NoError(t, err) {
+						assert.True(t, session.ExpiresAt.After(session.CreatedAt))
+					}
+				case 3:
+					session, err := store.GetUserSession("copied_user")
+					if assert.NoError(t, err) {
+						assert.False(t, session.IsExpired())
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Changing a copy leaves the stored session alone
+	session, err := store.Get(sessionID)
+	require.NoError(t, err)
+	session.ExpiresAt = time.Now().Add(-time.Second)
+	assert.True(t, store.ValidateSession(sessionID))
+}
+
+func TestSessionStoreUserIndex(t *testing.T) {
+	store := auth.NewSessionStore(time.Hour)
+	fillSessionStore(t, store, 100)
+
+	first, err := store.Create("indexed_user", testFALToken)
+	require.NoError(t, err)
+	second, err := store.Create("indexed_user", testFALToken)
+	require.NoError(t, err)
+
+	// Expired sessions are skipped in favour of the user's other session
+	require.NoError(t, store.SetExpiry(first, time.Now().Add(-time.Second)))
+	session, err := store.GetUserSession("indexed_user")
+	require.NoError(t, err)
+	assert.Equal(t, second, session.ID)
+
+	// Cleanup drops expired sessions from the index too
+	store.Cleanup()
+	require.NoError(t, store.DeleteUserSessions("indexed_user"))
+	_, err = store.GetUserSession("indexed_user")
+	assert.Error(t, err)
+	assert.Equal(t, 100, store.GetSessionCount(), "other users keep their sessions")
+
+	// Deleting by ID keeps the index in step
+	third, err := store.Create("indexed_user", testFALToken)
+	require.NoError(t, err)
+	require.NoError(t, store.Delete(third))
+	_, err = store.GetUserSession("indexed_user")
+	assert.Error(t, err)
+
+	store.Clear()
+	_, err = store.GetUserSession("user000001")
+	assert.Error(t, err)
+}
+
+func TestSessionStoreCapacity(t *testing.T) {
+	store := auth.NewSessionStore(time.Hour)
+	store.SetMaxSessions(3)
+
+	first, err := store.Create("first_user", testFALToken)
+	require.NoError(t, err)
+	second, err := store.Create("second_user", testFALToken)
+	require.NoError(t, err)
+	third, err := store.Create("third_user", testFALToken)
+	require.NoError(t, err)
+
+	// Using the oldest session makes the second one least recently used
+	store.Touch(first)
+	fourth, err := store.Create("fourth_user", testFALToken)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, store.GetSessionCount())
+	for _, kept := range []string{first, third, fourth} {
+		_, err := store.Get(kept)
+		assert.NoError(t, err)
+	}
+	_, err = store.Get(second)
+	assert.Error(t, err)
+	_, err = store.GetUserSession("second_user")
+	assert.Error(t, err, "evicted sessions leave the user index")
+
+	stats := store.Stats()
+	assert.Equal(t, 3, stats.Capacity)
+	assert.Equal(t, uint64(1), stats.Evictions)
+
+	// Lowering the cap evicts straight away; removing it stops evictions
+	store.SetMaxSessions(1)
+	assert.Equal(t, 1, store.GetSessionCount())
+	_, err = store.Get(fourth)
+	assert.NoError(t, err, "the most recently created session survives")
+
+	store.SetMaxSessions(0)
+	fillSessionStore(t, store, 50)
+	assert.Equal(t, 51, store.GetSessionCount())
+	assert.Equal(t, uint64(3), store.Stats().Evictions)
+}
+
+func TestSessionStatsRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:   "superusers see session capacity and evictions",
+			method: http.MethodGet,
+			url:    "/api/custom/admin/sessions",
+			before: func(t testing.TB, env *testEnv) {
+				env.sessionStore.SetMaxSessions(1)
+				fillSessionStore(t, env.sessionStore, 3)
+			},
+			headers:         superuserOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"total_sessions":1`, `"capacity":1`, `"evictions":2`},
+		},
+		{
+			name:            "session stats need a superuser",
+			method:          http.MethodGet,
+			url:             "/api/custom/admin/sessions",
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"Superuser access required"},
+		},
+	})
+}
+
+func BenchmarkSessionStoreGet(b *testing.B) {
+	store := auth.NewSessionStore(time.Hour)
+	ids := fillSessionStore(b, store, 10000)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := store.Get(ids[i%len(ids)]); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkSessionStoreGetAndTouch(b *testing.B) {
+	store := auth.NewSessionStore(time.Hour)
+	ids := fillSessionStore(b, store, 10000)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			sessionID := ids[i%len(ids)]
+			if _, err := store.Get(sessionID); err != nil {
+				b.Fatal(err)
+			}
+			store.Touch(sessionID)
+			i++
+		}
+	})
+}
+
+func BenchmarkSessionStoreCreateDelete(b *testing.B) {
+	store := auth.NewSessionStore(time.Hour)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			sessionID, err := store.Create("bench_user", testFALToken)
+			if err != nil {
+				b.Fatal(err)
+			}
+			store.Delete(sessionID)
+		}
+	})
+}
+
+func BenchmarkSessionStoreGetUserSession(b *testing.B) {
+	store := auth.NewSessionStore(time.Hour)
+	fillSessionStore(b, store, 10000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := store.GetUserSession(fmt.Sprintf("user%06d", i%10000)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
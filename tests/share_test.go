@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/moderation"
+	"generatio-pb/internal/share"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testShareSecret = "test-share-secret"
+
+func TestShareSigner(t *testing.T) {
+	signer, persistent := share.NewSigner(testShareSecret)
+	assert.True(t, persistent)
+
+	query := signer.Sign("sharedimage0001", time.Now().Add(time.Hour))
+	values := parseQuery(t, query)
+	assert.NoError(t, signer.Verify("sharedimage0001", values["expires"], values["sig"]))
+
+	// The key is derived from the secret, so another process can verify the link
+	other, _ := share.NewSigner(testShareSecret)
+	assert.NoError(t, other.Verify("sharedimage0001", values["expires"], values["sig"]))
+
+	assert.EqualError(t, signer.Verify("otherimage00001", values["expires"], values["sig"]), "invalid signature")
+	assert.EqualError(t, signer.Verify("sharedimage0001", "nope", values["sig"]), "invalid expiry")
+
+	random, persistent := share.NewSigner("")
+	assert.False(t, persistent)
+	assert.EqualError(t, random.Verify("sharedimage0001", values["expires"], values["sig"]), "invalid signature")
+
+	expired := parseQuery(t, signer.Sign("sharedimage0001", time.Now().Add(-time.Minute)))
+	assert.EqualError(t, signer.Verify("sharedimage0001", expired["expires"], expired["sig"]), "link has expired")
+}
+
+func parseQuery(t testing.TB, query string) map[string]string {
+	values := map[string]string{}
+	for _, pair := range strings.Split(query, "&") {
+		key, value, ok := strings.Cut(pair, "=")
+		require.True(t, ok)
+		values[key] = value
+	}
+	return values
+}
+
+func TestShareRoutes(t *testing.T) {
+	origin, _ := newImageOrigin(t)
+	signer, _ := share.NewSigner(testShareSecret)
+
+	sharedImage := func(fields map[string]any) func(t testing.TB, env *testEnv) {
+		return func(t testing.TB, env *testEnv) {
+			env.cfg.ShareSecret = testShareSecret
+			if fields == nil {
+				fields = map[string]any{}
+			}
+			fields["id"] = "sharedimage0001"
+			fields["url"] = origin.URL + "/image.png"
+			env.createImage(t, fields)
+		}
+	}
+
+	validLink := "/api/custom/shared/sharedimage0001?" + signer.Sign("sharedimage0001", time.Now().Add(time.Hour))
+	shortLink := "/api/custom/shared/sharedimage0001?" + signer.Sign("sharedimage0001", time.Now().Add(2*time.Minute))
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "creates a link with the requested ttl",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/sharedimage0001/share",
+			body:            `{"ttl_seconds":600}`,
+			setup:           sharedImage(nil),
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`/api/custom/shared/sharedimage0001?expires=`, `\u0026sig=`, `"page_url":"`, `/api/custom/shared/pages/sharedimage0001?expires=`, `"expires_at":`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				var link struct {
+					ExpiresAt time.Time `json:"expires_at"`
+				}
+				decodeData(t, res, &link)
+				assert.WithinDuration(t, time.Now().Add(10*time.Minute), link.ExpiresAt, 5*time.Second)
+			},
+		},
+		{
+			name:            "rejects ttls beyond the maximum",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/sharedimage0001/share",
+			body:            `{"ttl_seconds":99999999}`,
+			setup:           sharedImage(nil),
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`},
+		},
+		{
+			name:            "only the owner can share",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/sharedimage0001/share",
+			setup:           sharedImage(map[string]any{"user_id": "someoneelse0001"}),
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"code":"not_found"`},
+		},
+		{
+			name:            "quarantined images cannot be shared",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/sharedimage0001/share",
+			setup:           sharedImage(map[string]any{"moderation_status": moderation.StatusQuarantined}),
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{`"code":"authorization_error"`},
+		},
+		{
+			name:            "serves a signed link without auth",
+			method:          http.MethodGet,
+			url:             validLink,
+			setup:           sharedImage(nil),
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{"PNG"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, "image/png", res.Header.Get("Content-Type"))
+				assertShareMaxAge(t, res, 3600)
+			},
+		},
+		{
+			name:            "a short-lived link is cached no longer than it lives",
+			method:          http.MethodGet,
+			url:             shortLink,
+			setup:           sharedImage(nil),
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{"PNG"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assertShareMaxAge(t, res, 120)
+			},
+		},
+		{
+			name:            "a short-lived share page is cached no longer than it lives",
+			method:          http.MethodGet,
+			url:             strings.Replace(shortLink, "/shared/", "/shared/pages/", 1),
+			setup:           sharedImage(nil),
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`<meta property="og:image" content="`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assertShareMaxAge(t, res, 120)
+			},
+		},
+		{
+			name:            "rejects a tampered signature",
+			method:          http.MethodGet,
+			url:             strings.Replace(validLink, "sig=", "sig=0", 1),
+			setup:           sharedImage(nil),
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{`"code":"authorization_error"`},
+		},
+		{
+			name:            "rejects an expired link",
+			method:          http.MethodGet,
+			url:             "/api/custom/shared/sharedimage0001?" + signer.Sign("sharedimage0001", time.Now().Add(-time.Minute)),
+			setup:           sharedImage(nil),
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{`"code":"authorization_error"`},
+		},
+		{
+			name:           "the share page carries OpenGraph tags for link previews",
+			method:         http.MethodGet,
+			url:            strings.Replace(validLink, "/shared/", "/shared/pages/", 1),
+			setup:          sharedImage(map[string]any{"prompt": `a "lighthouse" <at> dusk`}),
+			expectedStatus: http.StatusOK,
+			expectedContent: []string{
+				`<meta property="og:title" content="a &#34;lighthouse&#34; &lt;at&gt; dusk">`,
+				`<meta property="og:image" content="`,
+				`/api/custom/shared/sharedimage0001?expires=`,
+				`<meta property="og:url" content="`,
+				`<meta name="twitter:card" content="summary_large_image">`,
+				`<meta http-equiv="refresh" content="0; url=`,
+			},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.True(t, strings.HasPrefix(res.Header.Get("Content-Type"), "text/html"))
+			},
+		},
+		{
+			name:            "the share page checks the signature",
+			method:          http.MethodGet,
+			url:             strings.Replace(strings.Replace(validLink, "/shared/", "/shared/pages/", 1), "sig=", "sig=0", 1),
+			setup:           sharedImage(nil),
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{`"code":"authorization_error"`},
+		},
+		{
+			name:            "stops serving images quarantined after sharing",
+			method:          http.MethodGet,
+			url:             validLink,
+			setup:           sharedImage(map[string]any{"moderation_status": moderation.StatusQuarantined}),
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{`"code":"not_found"`},
+		},
+	})
+}
+
+// assertShareMaxAge checks a shared response is public and cached for at most
+// limit seconds, allowing for the time spent since the link was signed
+func assertShareMaxAge(t testing.TB, res *http.Response, limit int) {
+	t.Helper()
+
+	maxAge, ok := strings.CutPrefix(res.Header.Get("Cache-Control"), "public, max-age=")
+	require.True(t, ok, res.Header.Get("Cache-Control"))
+	seconds, err := strconv.Atoi(maxAge)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, seconds, limit)
+	assert.Greater(t, seconds, limit-60)
+}
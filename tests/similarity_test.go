@@ -0,0 +1,262 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/embeddings"
+	"generatio-pb/internal/moderation"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// keywordEmbedder places images and queries on one axis per keyword they
+// mention, so similarity follows shared keywords
+type keywordEmbedder struct {
+	fail bool
+}
+
+var embeddingKeywords = []string{"lighthouse", "forest", "harbour"}
+
+func (keywordEmbedder) Model() string { return "test-clip" }
+
+func (k keywordEmbedder) EmbedImage(ctx context.Context, token, imageURL string) ([]float64, error) {
+	return k.EmbedText(ctx, token, imageURL)
+}
+
+func (k keywordEmbedder) EmbedText(ctx context.Context, token, text string) ([]float64, error) {
+	if k.fail {
+		return nil, errors.New("embedding request failed: HTTP 500")
+	}
+	vector := []float64{0.1}
+	for _, keyword := range embeddingKeywords {
+		if strings.Contains(text, keyword) {
+			vector = append(vector, 1)
+		} else {
+			vector = append(vector, 0)
+		}
+	}
+	return vector, nil
+}
+
+// createEmbeddedImage saves a persisted image whose file name carries keyword
+func createEmbeddedImage(t testing.TB, env *testEnv, id, keyword string, fields map[string]any) *core.Record {
+	if fields == nil {
+		fields = map[string]any{}
+	}
+	fields["id"] = id
+	fields["url"] = "https://img.test/" + keyword + ".png"
+	fields["prompt"] = "a " + keyword
+	fields["content_hash"] = strings.Repeat("c", 64)
+	return env.createImage(t, fields)
+}
+
+// indexImages embeds the given images with keywordEmbedder
+func indexImages(t testing.TB, env *testEnv, images ...*core.Record) {
+	store := embeddings.NewStore(env.app)
+	for _, image := range images {
+		vector, err := keywordEmbedder{}.EmbedImage(context.Background(), "", image.GetString("url"))
+		require.NoError(t, err)
+		require.NoError(t, store.Save(image, keywordEmbedder{}.Model(), vector))
+	}
+}
+
+func TestEmbeddingStore(t *testing.T) {
+	env := newTestEnv(t)
+	t.Cleanup(env.app.Cleanup)
+	store := embeddings.NewStore(env.app)
+
+	lighthouse := createEmbeddedImage(t, env, "lighthouseimg01", "lighthouse", nil)
+	harbour := createEmbeddedImage(t, env, "harbourimage001", "lighthouse-harbour", nil)
+	forest := createEmbeddedImage(t, env, "forestimage0001", "forest", nil)
+	trashed := createEmbeddedImage(t, env, "trashedimage001", "lighthouse", map[string]any{"deleted_at": time.Now().UTC()})
+	quarantined := createEmbeddedImage(t, env, "quarantined0001", "lighthouse", map[string]any{"moderation_status": moderation.StatusQuarantined})
+	notPersisted := env.createImage(t, map[string]any{"id": "notpersisted001"})
+	indexImages(t, env, lighthouse, harbour, trashed, quarantined)
+
+	t.Run("SavesUnitVectors", func(t *testing.T) {
+		vector, ok := store.Vector(lighthouse.Id, "test-clip")
+		require.True(t, ok)
+		var sum float64
+		for _, value := range vector {
+			sum += value * value
+		}
+		assert.InDelta(t, 1, sum, 1e-9)
+
+		_, ok = store.Vector(lighthouse.Id, "other-model")
+		assert.False(t, ok)
+	})
+
+	t.Run("RanksBySimilarity", func(t *testing.T) {
+		query, _ := keywordEmbedder{}.EmbedText(context.Background(), "", "lighthouse")
+		matches, err := store.Search(env.user.Id, "test-clip", query, "", 10)
+		require.NoError(t, err)
+
+		// Trashed and quarantined images are left out; the forest has no vector yet
+		require.Len(t, matches, 2)
+		assert.Equal(t, lighthouse.Id, matches[0].Image.Id)
+		assert.Equal(t, harbour.Id, matches[1].Image.Id)
+		assert.Greater(t, matches[0].Score, matches[1].Score)
+
+		matches, err = store.Search(env.user.Id, "test-clip", query, lighthouse.Id, 10)
+		require.NoError(t, err)
+		require.Len(t, matches, 1)
+		assert.Equal(t, harbour.Id, matches[0].Image.Id)
+
+		matches, err = store.Search("someoneelse0001", "test-clip", query, "", 10)
+		require.NoError(t, err)
+		assert.Empty(t, matches)
+	})
+
+	t.Run("FindsMissingVectors", func(t *testing.T) {
+		missing, err := store.Missing(env.user.Id, "test-clip", 10)
+		require.NoError(t, err)
+		require.Len(t, missing, 1)
+		assert.Equal(t, forest.Id, missing[0].Id)
+		assert.NotEqual(t, notPersisted.Id, missing[0].Id)
+	})
+
+	t.Run("DeletesVectorsWithTheirImage", func(t *testing.T) {
+		require.NoError(t, env.app.Delete(harbour))
+		_, ok := store.Vector(harbour.Id, "test-clip")
+		assert.False(t, ok)
+	})
+}
+
+func TestEmbeddingIndexer(t *testing.T) {
+	env := newTestEnv(t)
+	t.Cleanup(env.app.Cleanup)
+	store := embeddings.NewStore(env.app)
+	indexer := embeddings.NewIndexer(env.app, env.sessionStore, store, keywordEmbedder{}, 0)
+
+	forest := createEmbeddedImage(t, env, "forestimage0001", "forest", nil)
+
+	// Without a session there is no key to pay for embeddings
+	report, err := indexer.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Embedded)
+
+	_, err = env.sessionStore.Create(env.user.Id, testFALToken)
+	require.NoError(t, err)
+	report, err = indexer.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Users)
+	assert.Equal(t, 1, report.Embedded)
+	_, ok := store.Vector(forest.Id, "test-clip")
+	assert.True(t, ok)
+
+	// Embedded images are not embedded again
+	report, err = indexer.Run(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Embedded)
+}
+
+func TestSimilarityRoutes(t *testing.T) {
+	library := func(t testing.TB, env *testEnv) {
+		lighthouse := createEmbeddedImage(t, env, "lighthouseimg01", "lighthouse", nil)
+		harbour := createEmbeddedImage(t, env, "harbourimage001", "lighthouse-harbour", nil)
+		forest := createEmbeddedImage(t, env, "forestimage0001", "forest", nil)
+		quarantined := createEmbeddedImage(t, env, "quarantined0001", "forest", map[string]any{"moderation_status": moderation.StatusQuarantined})
+		indexImages(t, env, lighthouse, harbour, forest, quarantined)
+		createEmbeddedImage(t, env, "unindexedimg001", "lighthouse-forest", nil)
+	}
+	withEmbedder := func(t testing.TB, env *testEnv) {
+		env.handler.SetEmbedder(keywordEmbedder{})
+	}
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "search needs an embedder",
+			method:          http.MethodGet,
+			url:             "/api/custom/images/lighthouseimg01/similar",
+			setup:           library,
+			headers:         authOnly,
+			expectedStatus:  http.StatusServiceUnavailable,
+			expectedContent: []string{"Similarity search is not enabled"},
+		},
+		{
+			name:               "similar images are ranked by their vectors",
+			method:             http.MethodGet,
+			url:                "/api/custom/images/lighthouseimg01/similar?limit=2",
+			setup:              library,
+			before:             withEmbedder,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"data":[{"id":"harbourimage001"`, `"score":`, `"variants":`},
+			notExpectedContent: []string{`"id":"lighthouseimg01"`, "quarantined0001"},
+		},
+		{
+			name:            "unindexed images need a session to be embedded",
+			method:          http.MethodGet,
+			url:             "/api/custom/images/unindexedimg001/similar",
+			setup:           library,
+			before:          withEmbedder,
+			headers:         authOnly,
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{"Valid session required"},
+		},
+		{
+			name:            "unindexed images are embedded on the spot",
+			method:          http.MethodGet,
+			url:             "/api/custom/images/unindexedimg001/similar",
+			setup:           library,
+			before:          withEmbedder,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"id":"lighthouseimg01"`, `"id":"forestimage0001"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				_, ok := embeddings.NewStore(env.app).Vector("unindexedimg001", "test-clip")
+				assert.True(t, ok)
+			},
+		},
+		{
+			name:   "other users' images are not found",
+			method: http.MethodGet,
+			url:    "/api/custom/images/strangerimage01/similar",
+			setup: func(t testing.TB, env *testEnv) {
+				env.createImage(t, map[string]any{"id": "strangerimage01", "user_id": "someoneelse0001"})
+			},
+			before:          withEmbedder,
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"Image not found"},
+		},
+		{
+			name:               "text queries search the library",
+			method:             http.MethodGet,
+			url:                "/api/custom/images/search?q=a+misty+forest",
+			setup:              library,
+			before:             withEmbedder,
+			headers:            withSession,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"data":[{"id":"forestimage0001"`},
+			notExpectedContent: []string{"quarantined0001"},
+		},
+		{
+			name:            "text search needs a query",
+			method:          http.MethodGet,
+			url:             "/api/custom/images/search?q=+",
+			before:          withEmbedder,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"q is required"},
+		},
+		{
+			name:   "embedding failures are reported",
+			method: http.MethodGet,
+			url:    "/api/custom/images/search?q=forest",
+			before: func(t testing.TB, env *testEnv) {
+				env.handler.SetEmbedder(keywordEmbedder{fail: true})
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusBadGateway,
+			expectedContent: []string{"Failed to embed search query"},
+		},
+	})
+}
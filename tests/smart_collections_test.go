@@ -0,0 +1,166 @@
+package tests
+
+import (
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/repository"
+	"generatio-pb/internal/smartfolders"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seedSearchableImages creates images that differ in one attribute each
+func seedSearchableImages(t testing.TB, env *testEnv) {
+	old, err := types.ParseDateTime(time.Now().AddDate(0, 0, -60))
+	require.NoError(t, err)
+
+	env.createImage(t, map[string]any{"id": "fluxportrait001", "model": "fal-ai/flux/dev", "tags": []string{"portrait", "studio"}, "prompt": "a studio portrait"})
+	env.createImage(t, map[string]any{"id": "fluxportraitold", "model": "fal-ai/flux/dev", "tags": []string{"portrait"}, "created": old})
+	env.createImage(t, map[string]any{"id": "fluxlandscape01", "model": "fal-ai/flux/schnell", "tags": []string{"portraits"}, "favorite": true, "caption": "a misty valley at dawn"})
+	env.createImage(t, map[string]any{"id": "sdxlportrait001", "model": "fal-ai/fast-sdxl", "tags": []string{"portrait"}})
+	env.createImage(t, map[string]any{"id": "fluxtrashed0001", "model": "fal-ai/flux/dev", "tags": []string{"portrait"}, "deleted_at": types.NowDateTime()})
+	env.createImage(t, map[string]any{"id": "fluxorgimage001", "model": "fal-ai/flux/dev", "tags": []string{"portrait"}, "org_id": "someorg00000001"})
+}
+
+func TestSmartFolderQuery(t *testing.T) {
+	env := newTestEnv(t)
+	defer env.app.Cleanup()
+	seedSearchableImages(t, env)
+
+	favorite := true
+	cases := []struct {
+		name   string
+		filter smartfolders.Filter
+		want   []string
+	}{
+		{"ModelMatchesPartOfTheID", smartfolders.Filter{Model: "flux"}, []string{"fluxlandscape01", "fluxportrait001", "fluxportraitold"}},
+		{"TagMatchesWholeTags", smartfolders.Filter{Tag: "portrait"}, []string{"fluxportrait001", "fluxportraitold", "sdxlportrait001"}},
+		{"CriteriaCombine", smartfolders.Filter{Model: "flux", Tag: "portrait", Days: 30}, []string{"fluxportrait001"}},
+		{"Prompt", smartfolders.Filter{Prompt: "studio"}, []string{"fluxportrait001"}},
+		{"PromptMatchesCaptions", smartfolders.Filter{Prompt: "valley"}, []string{"fluxlandscape01"}},
+		{"Favorite", smartfolders.Filter{Favorite: &favorite}, []string{"fluxlandscape01"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			filter, params := smartfolders.Query(env.user.Id, c.filter)
+			records, err := repository.NewImages(env.app).Find(filter, "", 0, 0, params)
+			require.NoError(t, err)
+
+			ids := make([]string, 0, len(records))
+			for _, record := range records {
+				ids = append(ids, record.Id)
+			}
+			sort.Strings(ids)
+			assert.Equal(t, c.want, ids)
+		})
+	}
+}
+
+// withSmartFolder saves a smart folder for flux portraits from the last 30 days
+func withSmartFolder(t testing.TB, env *testEnv) {
+	seedSearchableImages(t, env)
+
+	collection, err := env.app.FindCollectionByNameOrId(smartfolders.Collection)
+	require.NoError(t, err)
+	record := core.NewRecord(collection)
+	record.Id = "smartfolder0001"
+	record.Set("user_id", env.user.Id)
+	record.Set("name", "Recent flux portraits")
+	record.Set("filter", smartfolders.Filter{Model: "flux", Tag: "portrait", Days: 30})
+	require.NoError(t, env.app.Save(record))
+}
+
+func TestSmartCollectionRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "smart collections are saved with their filter",
+			method:          http.MethodPost,
+			url:             "/api/custom/smart-collections",
+			body:            `{"name":"Flux portraits","filter":{"model":"flux","tag":"portrait","days":30}}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"name":"Flux portraits"`, `"filter":{"model":"flux","tag":"portrait","days":30}`},
+		},
+		{
+			name:            "a filter needs a criterion",
+			method:          http.MethodPost,
+			url:             "/api/custom/smart-collections",
+			body:            `{"name":"Everything","filter":{}}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"filter needs at least one of"},
+		},
+		{
+			name:            "the window is bounded",
+			method:          http.MethodPost,
+			url:             "/api/custom/smart-collections",
+			body:            `{"name":"Ancient","filter":{"days":5000}}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"days must be between 0 and 3650"},
+		},
+		{
+			name:               "images are selected when the collection is read",
+			method:             http.MethodGet,
+			url:                "/api/custom/smart-collections/smartfolder0001/images",
+			setup:              withSmartFolder,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"id":"fluxportrait001"`, `"smart_collection":{"id":"smartfolder0001"`},
+			notExpectedContent: []string{"fluxportraitold", "sdxlportrait001", "fluxtrashed0001", "fluxorgimage001"},
+		},
+		{
+			name:            "the folder tree lists smart collections",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections",
+			setup:           withSmartFolder,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"smart_collections":[{"id":"smartfolder0001","name":"Recent flux portraits"`},
+		},
+		{
+			name:            "updates replace the filter",
+			method:          http.MethodPut,
+			url:             "/api/custom/smart-collections/smartfolder0001",
+			body:            `{"name":"Favorites","filter":{"favorite":true}}`,
+			setup:           withSmartFolder,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"name":"Favorites"`, `"filter":{"favorite":true}`},
+		},
+		{
+			name:   "other users' smart collections are hidden",
+			method: http.MethodGet,
+			url:    "/api/custom/smart-collections/smartfolder0001",
+			setup:  withSmartFolder,
+			headers: func(t testing.TB, env *testEnv) map[string]string {
+				other := env.createUser(t, "smartstranger01", "stranger@test.com")
+				token, err := other.NewAuthToken()
+				require.NoError(t, err)
+				return map[string]string{"Authorization": token}
+			},
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"Smart collection not found"},
+		},
+		{
+			name:            "deleting a smart collection keeps its images",
+			method:          http.MethodDelete,
+			url:             "/api/custom/smart-collections/smartfolder0001",
+			setup:           withSmartFolder,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.True(t, imageExists(env, "fluxportrait001"))
+				_, err := env.app.FindRecordById(smartfolders.Collection, "smartfolder0001")
+				assert.Error(t, err)
+			},
+		},
+	})
+}
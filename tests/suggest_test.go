@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/suggest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSuggestRank(t *testing.T) {
+	candidates := []suggest.Candidate{
+		{Text: "a lighthouse at dusk", Source: suggest.SourceHistory, Count: 1},
+		{Text: "A  Lighthouse in fog", Source: suggest.SourceHistory, Count: 5},
+		{Text: "stormy sea with a lighthouse", Source: suggest.SourceHistory, Count: 9},
+		{Text: "a lighthouse in fog", Source: suggest.SourceTemplate, Count: 40},
+		{Text: "a red fox", Source: suggest.SourceHistory, Count: 20},
+	}
+
+	ranked := suggest.Rank("a light", candidates, 0)
+	require.Len(t, ranked, 3)
+	assert.Equal(t, "a lighthouse in fog", ranked[0].Text)
+	assert.Equal(t, suggest.SourceTemplate, ranked[0].Source, "duplicates keep their best score")
+	assert.Equal(t, "a lighthouse at dusk", ranked[1].Text)
+	assert.Equal(t, "stormy sea with a lighthouse", ranked[2].Text, "word matches rank below prefix matches")
+	assert.Less(t, ranked[2].Score, ranked[1].Score)
+
+	ranked = suggest.Rank("light", candidates, 0)
+	require.Len(t, ranked, 3)
+	assert.Equal(t, "stormy sea with a lighthouse", ranked[1].Text, "frequency orders word matches")
+	assert.Equal(t, "a lighthouse at dusk", ranked[2].Text)
+
+	ranked = suggest.Rank("", candidates, 2)
+	require.Len(t, ranked, 2)
+	assert.Equal(t, "a red fox", ranked[0].Text, "templates are weighed down for an empty query too")
+	assert.Equal(t, "a lighthouse in fog", suggest.Normalize(ranked[1].Text))
+}
+
+func TestSuggestRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:   "suggests completions from history and templates",
+			method: http.MethodGet,
+			url:    "/api/custom/prompts/suggest?q=a+fog",
+			setup: func(t testing.TB, env *testEnv) {
+				seedPrompt(t, env)
+				for _, prompt := range []string{"a forest path", "a forest path", "a foggy valley", "a fox"} {
+					env.createImage(t, map[string]any{"prompt": prompt})
+				}
+				env.createImage(t, map[string]any{"prompt": "a foggy morning", "user_id": "promptauthor001"})
+			},
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"query":"a fog"`},
+			notExpectedContent: []string{"a forest path", "a foggy morning"},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				var resp struct {
+					Suggestions []suggest.Suggestion `json:"suggestions"`
+				}
+				decodeData(t, res, &resp)
+
+				require.Len(t, resp.Suggestions, 2)
+				assert.Equal(t, "a foggy valley", resp.Suggestions[0].Text)
+				assert.Equal(t, "a foggy harbour at dawn, film grain", resp.Suggestions[1].Text)
+				assert.Equal(t, suggest.SourceTemplate, resp.Suggestions[1].Source)
+			},
+		},
+		{
+			name:   "repeated prompts rank first",
+			method: http.MethodGet,
+			url:    "/api/custom/prompts/suggest?q=a+fo&limit=1",
+			setup: func(t testing.TB, env *testEnv) {
+				for _, prompt := range []string{"a forest path", "a forest path", "a foggy valley"} {
+					env.createImage(t, map[string]any{"prompt": prompt})
+				}
+			},
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"text":"a forest path","source":"history","count":2`},
+			notExpectedContent: []string{"a foggy valley"},
+		},
+		{
+			name:            "suggestions require auth",
+			method:          http.MethodGet,
+			url:             "/api/custom/prompts/suggest?q=a",
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"code":"authentication_error"`},
+		},
+	})
+}
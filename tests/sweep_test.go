@@ -0,0 +1,113 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	localmodels "generatio-pb/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSweepRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "sweep generates the full matrix",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/sweep",
+			body:            `{"model":"flux/schnell","prompt":"a red fox","parameters":{"num_inference_steps":4},"axes":{"seed":[1,2],"guidance_scale":[2,7.5]}}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"sweep_id":"`, `"name":"guidance_scale"`, `"budget":1`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				var resp localmodels.SweepResponse
+				decodeData(t, res, &resp)
+
+				require.Len(t, resp.Axes, 2)
+				assert.Equal(t, "guidance_scale", resp.Axes[0].Name)
+				assert.Equal(t, "seed", resp.Axes[1].Name)
+
+				require.Len(t, resp.Cells, 4)
+				assert.Equal(t, []int{0, 1}, resp.Cells[1].Coordinates)
+				assert.Equal(t, 2.0, resp.Cells[1].Parameters["guidance_scale"])
+				assert.Equal(t, 2.0, resp.Cells[1].Parameters["seed"])
+				assert.Equal(t, 4.0, resp.Cells[1].Parameters["num_inference_steps"])
+				assert.Equal(t, []int{1, 0}, resp.Cells[2].Coordinates)
+				assert.InDelta(t, 0.012, resp.TotalCost, 1e-9)
+
+				records, err := env.app.FindAllRecords("images")
+				require.NoError(t, err)
+				require.Len(t, records, 4)
+				for _, record := range records {
+					assert.Equal(t, resp.SweepID, record.GetString("group_id"))
+				}
+			},
+		},
+		{
+			name:            "sweep rejects unsupported axes",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/sweep",
+			body:            `{"model":"flux/schnell","prompt":"a red fox","axes":{"num_images":[1,2]}}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"num_images cannot be swept"},
+		},
+		{
+			name:            "sweep rejects axes the model lacks",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/sweep",
+			body:            `{"model":"hidream/hidream-i1-fast","prompt":"a red fox","axes":{"guidance_scale":[2,5]}}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"does not support guidance_scale"},
+		},
+		{
+			name:            "sweep validates every cell",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/sweep",
+			body:            `{"model":"flux/schnell","prompt":"a red fox","axes":{"guidance_scale":[2,30]}}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"guidance_scale must be at most"},
+		},
+		{
+			name:            "sweep needs more than one cell",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/sweep",
+			body:            `{"model":"flux/schnell","prompt":"a red fox","axes":{"seed":[1]}}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"at least two cells"},
+		},
+		{
+			name:            "sweep is capped in size",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/sweep",
+			body:            `{"model":"flux/schnell","prompt":"a red fox","axes":{"seed":[1,2,3,4,5,6,7],"num_inference_steps":[1,2,3,4,5,6]}}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{"more than 36 cells"},
+		},
+		{
+			name:               "sweep is capped by budget",
+			method:             http.MethodPost,
+			url:                "/api/custom/generate/sweep",
+			body:               `{"model":"flux/schnell","prompt":"a red fox","parameters":{"num_images":2},"axes":{"seed":[1,2,3]},"max_cost":0.01}`,
+			headers:            withSession,
+			expectedStatus:     http.StatusBadRequest,
+			expectedContent:    []string{"Estimated cost $0.0180 exceeds the budget of $0.0100"},
+			notExpectedContent: []string{"sweep_id"},
+		},
+		{
+			name:            "sweep fails when every cell fails",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/sweep",
+			body:            `{"model":"flux/schnell","prompt":"a red fox","axes":{"seed":[1,2]}}`,
+			before:          failModel("flux/schnell"),
+			headers:         withSession,
+			expectedStatus:  http.StatusBadGateway,
+			expectedContent: []string{`"code":"external_error"`},
+		},
+	})
+}
@@ -0,0 +1,173 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"generatio-pb/internal/fal"
+	"generatio-pb/internal/folderacl"
+	"generatio-pb/internal/orgs"
+
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// targetFolderID is a folder of the seeded user's personal library
+const targetFolderID = "targetfolder001"
+
+// withTargetFolder seeds targetFolderID, trashed when deleted is set
+func withTargetFolder(deleted bool) func(t testing.TB, env *testEnv) {
+	return func(t testing.TB, env *testEnv) {
+		seedFolder(t, env, targetFolderID, "")
+		if deleted {
+			folder, err := env.app.FindRecordById("folders", targetFolderID)
+			require.NoError(t, err)
+			folder.Set("deleted_at", types.NowDateTime())
+			require.NoError(t, env.app.Save(folder))
+		}
+	}
+}
+
+// savedFolderIDs returns the folder_id of every saved image
+func savedFolderIDs(t testing.TB, env *testEnv) []string {
+	images, err := env.app.FindAllRecords("images")
+	require.NoError(t, err)
+	folderIDs := make([]string, 0, len(images))
+	for _, image := range images {
+		folderIDs = append(folderIDs, image.GetString("folder_id"))
+	}
+	return folderIDs
+}
+
+// assertNothingSaved checks that a rejected request saved no images
+func assertNothingSaved(t testing.TB, env *testEnv, res *http.Response) {
+	assert.Empty(t, savedFolderIDs(t, env))
+}
+
+func TestTargetFolderRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "images are saved into the user's folder",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a red fox","collection_id":"` + targetFolderID + `"}`,
+			setup:           withTargetFolder(false),
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"images":[`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, []string{targetFolderID}, savedFolderIDs(t, env))
+			},
+		},
+		{
+			name:            "missing folders are rejected with a specific code",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a red fox","collection_id":"missingfolder01"}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"invalid_folder"`, `"folder_id":"missingfolder01"`, `"reason":"not_found"`},
+			after:           assertNothingSaved,
+		},
+		{
+			name:            "trashed folders are rejected",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a red fox","collection_id":"` + targetFolderID + `"}`,
+			setup:           withTargetFolder(true),
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"invalid_folder"`, `"reason":"not_found"`},
+			after:           assertNothingSaved,
+		},
+		{
+			name:            "folders of other users are rejected",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a red fox","collection_id":"` + testFolderID + `"}`,
+			setup:           withFolderRole(""),
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"invalid_folder"`, `"reason":"not_found"`},
+			after:           assertNothingSaved,
+		},
+		{
+			name:            "folders shared read-only are rejected",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"flux/schnell","prompt":"a red fox","collection_id":"` + testFolderID + `"}`,
+			setup:           withFolderRole(folderacl.RoleViewer),
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"invalid_folder"`, `"reason":"read_only"`},
+			after:           assertNothingSaved,
+		},
+		{
+			name:   "organization folders are rejected for the personal library",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"model":"flux/schnell","prompt":"a red fox","collection_id":"` + targetFolderID + `"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withOrgRole(orgs.RoleMember)(t, env)
+				withTargetFolder(false)(t, env)
+				folder, err := env.app.FindRecordById("folders", targetFolderID)
+				require.NoError(t, err)
+				folder.Set("org_id", testOrgID)
+				require.NoError(t, env.app.Save(folder))
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"invalid_folder"`, `"reason":"other_library"`},
+			after:           assertNothingSaved,
+		},
+		{
+			name:   "folders trashed during generation are not filed into",
+			method: http.MethodPost,
+			url:    "/api/custom/generate/image",
+			body:   `{"model":"flux/schnell","prompt":"a red fox","collection_id":"` + targetFolderID + `"}`,
+			setup: func(t testing.TB, env *testEnv) {
+				withTargetFolder(false)(t, env)
+				env.falClient.SetGenerateImageFunc(func(ctx context.Context, token string, req fal.GenerationRequest) (*fal.GenerationResponse, error) {
+					folder, err := env.app.FindRecordById("folders", targetFolderID)
+					if err != nil {
+						return nil, err
+					}
+					folder.Set("deleted_at", types.NowDateTime())
+					if err := env.app.Save(folder); err != nil {
+						return nil, err
+					}
+					return deliveredResult(req.Parameters, "https://a.example/1.jpg"), nil
+				})
+			},
+			headers:         withSession,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"url":"https://a.example/1.jpg"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, []string{""}, savedFolderIDs(t, env))
+			},
+		},
+		{
+			name:            "comparisons check their folder the same way",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/compare",
+			body:            `{"prompt":"a red fox","collection_id":"missingfolder01","variants":[{"model":"flux/schnell"},{"model":"hidream/hidream-i1-fast"}]}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"invalid_folder"`, `"reason":"not_found"`},
+			after:           assertNothingSaved,
+		},
+		{
+			name:            "imports check their folder the same way",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/import",
+			body:            `{"folder_id":"` + testFolderID + `","images":[{"url":"https://example.com/a.png"}]}`,
+			setup:           withFolderRole(folderacl.RoleViewer),
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"invalid_folder"`, `"reason":"read_only"`},
+			after:           assertNothingSaved,
+		},
+	})
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/require"
+)
+
+// backdatedCreated is the created time of backdated records
+const backdatedCreated = "2024-01-02 03:04:05.000Z"
+
+// withBackdatedRecords seeds a folder and an image in it created at
+// backdatedCreated
+func withBackdatedRecords(t testing.TB, env *testEnv) {
+	created, err := types.ParseDateTime(backdatedCreated)
+	require.NoError(t, err)
+
+	folders, err := env.app.FindCollectionByNameOrId("folders")
+	require.NoError(t, err)
+	folder := core.NewRecord(folders)
+	folder.Id = "oldfolder000001"
+	folder.Set("name", "Old folder")
+	folder.Set("user_id", env.user.Id)
+	// Autodate fields keep manually set values only through SetRaw
+	folder.SetRaw("created", created)
+	require.NoError(t, env.app.Save(folder))
+
+	env.createImage(t, map[string]any{"id": "oldimage0000001", "folder_id": folder.Id, "created": created})
+}
+
+func TestRecordTimestampRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "collections report when they were created",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections",
+			setup:           withBackdatedRecords,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"id":"oldfolder000001"`, `"created":"2024-01-02T03:04:05Z"`},
+		},
+		{
+			name:            "collection images report when they were created",
+			method:          http.MethodGet,
+			url:             "/api/custom/collections/oldfolder000001/images",
+			setup:           withBackdatedRecords,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"id":"oldimage0000001"`, `"created":"2024-01-02T03:04:05Z"`, `"updated":"`},
+		},
+		{
+			name:               "new collections report their saved time",
+			method:             http.MethodPost,
+			url:                "/api/custom/collections/create",
+			body:               `{"name":"Fresh"}`,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"name":"Fresh"`, `"created":"`, `"updated":"`},
+			notExpectedContent: []string{`"created":"0001-01-01T00:00:00Z"`},
+		},
+	})
+}
@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/trash"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seedTrash puts one image in the trash 45 days ago, one yesterday, and
+// leaves one image in the library
+func seedTrash(t testing.TB, env *testEnv) {
+	longAgo, err := types.ParseDateTime(time.Now().AddDate(0, 0, -45))
+	require.NoError(t, err)
+	yesterday, err := types.ParseDateTime(time.Now().AddDate(0, 0, -1))
+	require.NoError(t, err)
+
+	env.createImage(t, map[string]any{"id": "oldtrashimage01", "title": "old trash", "deleted_at": longAgo})
+	env.createImage(t, map[string]any{"id": "newtrashimage01", "title": "new trash", "deleted_at": yesterday})
+	env.createImage(t, map[string]any{"id": "keptimage000001", "title": "kept"})
+}
+
+// imageExists reports whether an image record is still stored
+func imageExists(env *testEnv, id string) bool {
+	_, err := env.app.FindRecordById("images", id)
+	return err == nil
+}
+
+func TestTrashService(t *testing.T) {
+	t.Run("RunPurgesImagesPastTheWindow", func(t *testing.T) {
+		env := newTestEnv(t)
+		defer env.app.Cleanup()
+		seedTrash(t, env)
+
+		report, err := trash.NewService(env.app, 30, 0).Run()
+		require.NoError(t, err)
+		assert.Equal(t, trash.Report{Purged: 1}, *report)
+		assert.False(t, imageExists(env, "oldtrashimage01"))
+		assert.True(t, imageExists(env, "newtrashimage01"))
+		assert.True(t, imageExists(env, "keptimage000001"))
+	})
+
+	t.Run("ZeroDaysKeepsTheTrash", func(t *testing.T) {
+		env := newTestEnv(t)
+		defer env.app.Cleanup()
+		seedTrash(t, env)
+
+		service := trash.NewService(env.app, 0, 0)
+		report, err := service.Run()
+		require.NoError(t, err)
+		assert.Zero(t, report.Purged)
+
+		record, err := env.app.FindRecordById("images", "oldtrashimage01")
+		require.NoError(t, err)
+		assert.Nil(t, service.PurgeAt(record))
+	})
+
+	t.Run("EmptyOnlyTouchesTheUsersTrash", func(t *testing.T) {
+		env := newTestEnv(t)
+		defer env.app.Cleanup()
+		seedTrash(t, env)
+
+		report, err := trash.NewService(env.app, 30, 0).Empty(env.user.Id)
+		require.NoError(t, err)
+		assert.Equal(t, 2, report.Purged)
+		assert.True(t, imageExists(env, "keptimage000001"))
+	})
+
+	t.Run("RestoreLeavesDeletedFolders", func(t *testing.T) {
+		env := newTestEnv(t)
+		defer env.app.Cleanup()
+
+		folders, err := env.app.FindCollectionByNameOrId("folders")
+		require.NoError(t, err)
+		folder := core.NewRecord(folders)
+		folder.Set("name", "Old moodboard")
+		folder.Set("user_id", env.user.Id)
+		folder.Set("deleted_at", types.NowDateTime())
+		require.NoError(t, env.app.Save(folder))
+
+		image := env.createImage(t, map[string]any{"folder_id": folder.Id, "deleted_at": types.NowDateTime()})
+		require.NoError(t, trash.NewService(env.app, 30, 0).Restore(image))
+
+		restored, err := env.app.FindRecordById("images", image.Id)
+		require.NoError(t, err)
+		assert.True(t, restored.GetDateTime("deleted_at").IsZero())
+		assert.Empty(t, restored.GetString("folder_id"))
+	})
+}
+
+func TestTrashRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "deleting an image moves it to the trash",
+			method:          http.MethodDelete,
+			url:             "/api/custom/images/keptimage000001",
+			setup:           seedTrash,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"id":"keptimage000001"`, `"deleted_at"`, `"purge_at"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				record, err := env.app.FindRecordById("images", "keptimage000001")
+				require.NoError(t, err)
+				assert.False(t, record.GetDateTime("deleted_at").IsZero())
+			},
+		},
+		{
+			name:            "images already in the trash cannot be deleted again",
+			method:          http.MethodDelete,
+			url:             "/api/custom/images/newtrashimage01",
+			setup:           seedTrash,
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"Image not found"},
+		},
+		{
+			name:   "other users' images cannot be deleted",
+			method: http.MethodDelete,
+			url:    "/api/custom/images/othersimage0001",
+			setup: func(t testing.TB, env *testEnv) {
+				env.createImage(t, map[string]any{"id": "othersimage0001", "user_id": "someoneelse0001"})
+			},
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"Image not found"},
+		},
+		{
+			name:               "the trash lists deleted images with their purge date",
+			method:             http.MethodGet,
+			url:                "/api/custom/images/trash",
+			setup:              seedTrash,
+			headers:            authOnly,
+			expectedStatus:     http.StatusOK,
+			expectedContent:    []string{`"retention_days":30`, `"id":"oldtrashimage01"`, `"id":"newtrashimage01"`, `"purge_at"`},
+			notExpectedContent: []string{"keptimage000001"},
+		},
+		{
+			name:            "restoring takes an image out of the trash",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/newtrashimage01/restore",
+			setup:           seedTrash,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"restored":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				record, err := env.app.FindRecordById("images", "newtrashimage01")
+				require.NoError(t, err)
+				assert.True(t, record.GetDateTime("deleted_at").IsZero())
+			},
+		},
+		{
+			name:            "only trashed images can be restored",
+			method:          http.MethodPost,
+			url:             "/api/custom/images/keptimage000001/restore",
+			setup:           seedTrash,
+			headers:         authOnly,
+			expectedStatus:  http.StatusNotFound,
+			expectedContent: []string{"Image not in trash"},
+		},
+		{
+			name:            "emptying the trash purges it now",
+			method:          http.MethodDelete,
+			url:             "/api/custom/images/trash",
+			setup:           seedTrash,
+			headers:         authOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"purged":2`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.False(t, imageExists(env, "newtrashimage01"))
+				assert.True(t, imageExists(env, "keptimage000001"))
+			},
+		},
+		{
+			name:            "trash purges require a superuser",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/trash/purge",
+			headers:         authOnly,
+			expectedStatus:  http.StatusForbidden,
+			expectedContent: []string{"Superuser access required"},
+		},
+		{
+			name:            "manual purges remove expired trash",
+			method:          http.MethodPost,
+			url:             "/api/custom/admin/trash/purge",
+			setup:           seedTrash,
+			headers:         superuserOnly,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"purged":1`, `"failed":0`},
+		},
+	})
+}
@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	localmodels "generatio-pb/internal/models"
+	"generatio-pb/internal/validation"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidationStruct(t *testing.T) {
+	t.Run("ValidStructsPass", func(t *testing.T) {
+		assert.NoError(t, validation.Struct(&localmodels.GenerateImageRequest{Model: "fal-ai/flux/schnell", Prompt: "a castle"}))
+		assert.NoError(t, validation.Struct(&localmodels.CustomLoginRequest{Email: "user@example.com", Password: "secret"}))
+	})
+
+	t.Run("EveryFailingFieldIsNamed", func(t *testing.T) {
+		err := validation.Struct(&localmodels.GenerateImageRequest{Prompt: strings.Repeat("a", 1001)})
+		require.Error(t, err)
+
+		errs, ok := err.(validation.Errors)
+		require.True(t, ok)
+		assert.Equal(t, validation.Errors{
+			"model":  "is required",
+			"prompt": "must have at most 1000 characters",
+		}, errs)
+		assert.Equal(t, "model is required; prompt must have at most 1000 characters", err.Error())
+	})
+
+	t.Run("BlankStringsAreMissing", func(t *testing.T) {
+		err := validation.Struct(&localmodels.CreateSessionRequest{Password: "   "})
+		assert.Equal(t, validation.Errors{"password": "is required"}, err)
+	})
+
+	t.Run("CollectionsAndEmails", func(t *testing.T) {
+		err := validation.Struct(&localmodels.AddImagesToCollectionRequest{ImageIDs: []string{}})
+		assert.Equal(t, "must have at least 1 item", err.(validation.Errors)["image_ids"])
+
+		err = validation.Struct(&localmodels.CustomLoginRequest{Email: "Someone <user@example.com>", Password: "secret"})
+		assert.Equal(t, validation.Errors{"email": "must be a valid email address"}, err)
+	})
+}
+
+func TestValidationRoutes(t *testing.T) {
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "long prompts are reported per field",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{"model":"fal-ai/flux/schnell","prompt":"` + strings.Repeat("a", 1001) + `"}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"code":"validation_error"`, `"details":{"prompt":"must have at most 1000 characters"}`},
+		},
+		{
+			name:            "every missing field is reported",
+			method:          http.MethodPost,
+			url:             "/api/custom/generate/image",
+			body:            `{}`,
+			headers:         withSession,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"model":"is required"`, `"prompt":"is required"`},
+		},
+		{
+			name:            "missing passwords are reported",
+			method:          http.MethodPost,
+			url:             "/api/custom/auth/create-session",
+			body:            `{}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"details":{"password":"is required"}`},
+		},
+		{
+			name:            "collection names are limited",
+			method:          http.MethodPost,
+			url:             "/api/custom/collections/create",
+			body:            `{"name":"` + strings.Repeat("n", 101) + `"}`,
+			headers:         authOnly,
+			expectedStatus:  http.StatusBadRequest,
+			expectedContent: []string{`"details":{"name":"must have at most 100 characters"}`},
+		},
+	})
+}
@@ -0,0 +1,168 @@
+package tests
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"generatio-pb/internal/auth"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// verifyPassword caches the keys a token verify with the test password
+// would have derived
+func verifyPassword(t testing.TB, env *testEnv) {
+	for _, field := range []string{"fal_token", "fal_token_testing"} {
+		stored := env.user.GetString(field)
+		if stored == "" {
+			continue
+		}
+		salt := strings.Split(stored, ".")[1]
+		key, err := env.encService.DeriveKey(salt, testPassword)
+		require.NoError(t, err)
+		env.handler.Verifications().Put(env.user.Id, salt, testPassword, key)
+	}
+}
+
+func TestVerificationCache(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	cache := auth.NewVerificationCache(time.Minute)
+	cache.Put("user1", "salt1", testPassword, key)
+	cache.Put("user2", "salt2", testPassword, key)
+
+	cached, ok := cache.Get("user1", "salt1", testPassword)
+	require.True(t, ok)
+	assert.Equal(t, key, cached)
+	cached[0] = 'x'
+	cached, _ = cache.Get("user1", "salt1", testPassword)
+	assert.Equal(t, key, cached, "callers get a copy")
+
+	_, ok = cache.Get("user1", "salt1", "wrongpassword")
+	assert.False(t, ok)
+	_, ok = cache.Get("user1", "salt2", testPassword)
+	assert.False(t, ok)
+	_, ok = cache.Get("user2", "salt1", testPassword)
+	assert.False(t, ok)
+
+	cache.Invalidate("user1")
+	_, ok = cache.Get("user1", "salt1", testPassword)
+	assert.False(t, ok)
+	_, ok = cache.Get("user2", "salt2", testPassword)
+	assert.True(t, ok, "other users keep their entries")
+
+	expiring := auth.NewVerificationCache(20 * time.Millisecond)
+	expiring.Put("user1", "salt1", testPassword, key)
+	time.Sleep(40 * time.Millisecond)
+	_, ok = expiring.Get("user1", "salt1", testPassword)
+	assert.False(t, ok)
+	assert.Zero(t, expiring.Len(), "expired entries are dropped")
+
+	disabled := auth.NewVerificationCache(0)
+	disabled.Put("user1", "salt1", testPassword, key)
+	_, ok = disabled.Get("user1", "salt1", testPassword)
+	assert.False(t, ok)
+}
+
+func TestVerificationCacheRoutes(t *testing.T) {
+	var derivations uint64
+	countDerivations := func(t testing.TB, env *testEnv) {
+		derivations = env.encService.Derivations()
+	}
+
+	runScenarios(t, []handlerScenario{
+		{
+			name:            "token verify caches the derived key",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/verify",
+			body:            `{"password":"` + testPassword + `"}`,
+			headers:         authOnly,
+			setup:           withStoredToken,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"can_decrypt":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, 1, env.handler.Verifications().Len())
+			},
+		},
+		{
+			name:            "failed verifications are not cached",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/verify",
+			body:            `{"password":"wrongpassword"}`,
+			headers:         authOnly,
+			setup:           withStoredToken,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"can_decrypt":false`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Zero(t, env.handler.Verifications().Len())
+			},
+		},
+		{
+			name:    "create session after a token verify derives nothing",
+			method:  http.MethodPost,
+			url:     "/api/custom/auth/create-session",
+			body:    `{"password":"` + testPassword + `"}`,
+			headers: authOnly,
+			setup:   withStoredTestingToken,
+			before: func(t testing.TB, env *testEnv) {
+				verifyPassword(t, env)
+				countDerivations(t, env)
+			},
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"environments":["production","testing"]`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, derivations, env.encService.Derivations())
+				session, err := env.sessionStore.GetUserSession(env.user.Id)
+				require.NoError(t, err)
+				assert.Equal(t, testFALToken, session.FALToken)
+			},
+		},
+		{
+			name:    "a cached verification does not admit another password",
+			method:  http.MethodPost,
+			url:     "/api/custom/auth/create-session",
+			body:    `{"password":"wrongpassword"}`,
+			headers: authOnly,
+			setup:   withStoredToken,
+			before: func(t testing.TB, env *testEnv) {
+				verifyPassword(t, env)
+				countDerivations(t, env)
+			},
+			expectedStatus:  http.StatusUnauthorized,
+			expectedContent: []string{`"message":"Invalid password"`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Equal(t, derivations+1, env.encService.Derivations())
+			},
+		},
+		{
+			name:            "removing the testing token invalidates cached verifications",
+			method:          http.MethodDelete,
+			url:             "/api/custom/tokens/testing",
+			headers:         authOnly,
+			setup:           withStoredTestingToken,
+			before:          verifyPassword,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Zero(t, env.handler.Verifications().Len())
+			},
+		},
+		{
+			name:            "replacing the token invalidates cached verifications",
+			method:          http.MethodPost,
+			url:             "/api/custom/tokens/setup",
+			body:            `{"fal_token":"fal_replacement_key_9876","password":"` + testPassword + `"}`,
+			headers:         authOnly,
+			setup:           withStoredToken,
+			before:          verifyPassword,
+			expectedStatus:  http.StatusOK,
+			expectedContent: []string{`"success":true`},
+			after: func(t testing.TB, env *testEnv, res *http.Response) {
+				assert.Zero(t, env.handler.Verifications().Len())
+			},
+		},
+	})
+}